@@ -0,0 +1,108 @@
+package canvas
+
+import (
+	"fmt"
+	"image"
+)
+
+// AlphaMask is an alpha only image, such as a brush tip, a glyph or an
+// icon, that can be filled with any fill style with FillWithMask. Like
+// images, masks keep smaller copies of themselves so that they look
+// smooth when they are drawn scaled down
+type AlphaMask struct {
+	mips []*image.Alpha
+}
+
+// LoadAlphaMask creates a mask from a copy of the image
+func LoadAlphaMask(mask *image.Alpha) *AlphaMask {
+	w, h := mask.Rect.Dx(), mask.Rect.Dy()
+	img := image.NewAlpha(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		copy(img.Pix[y*img.Stride:y*img.Stride+w], mask.Pix[mask.PixOffset(mask.Rect.Min.X, mask.Rect.Min.Y+y):])
+	}
+	am := &AlphaMask{mips: []*image.Alpha{img}}
+	for w > 1 || h > 1 {
+		img = halveAlpha(img)
+		w, h = img.Rect.Dx(), img.Rect.Dy()
+		am.mips = append(am.mips, img)
+	}
+	return am
+}
+
+// halveAlpha returns the mask at half the size, rounded up, with each
+// pixel the average of the pixels it covers
+func halveAlpha(src *image.Alpha) *image.Alpha {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	w, h := (sw+1)/2, (sh+1)/2
+	dst := image.NewAlpha(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sum, n := 0, 0
+			for sy := y * 2; sy < y*2+2 && sy < sh; sy++ {
+				for sx := x * 2; sx < x*2+2 && sx < sw; sx++ {
+					sum += int(src.Pix[sy*src.Stride+sx])
+					n++
+				}
+			}
+			dst.Pix[y*dst.Stride+x] = uint8((sum + n/2) / n)
+		}
+	}
+	return dst
+}
+
+// Width returns the width of the mask
+func (am *AlphaMask) Width() int { return am.mips[0].Rect.Dx() }
+
+// Height returns the height of the mask
+func (am *AlphaMask) Height() int { return am.mips[0].Rect.Dy() }
+
+// Size returns the width and height of the mask
+func (am *AlphaMask) Size() (int, int) { return am.Width(), am.Height() }
+
+// level returns the smallest copy of the mask that is at least as
+// large as the area it is drawn to
+func (am *AlphaMask) level(w, h float64) *image.Alpha {
+	mask := am.mips[0]
+	for _, m := range am.mips[1:] {
+		if float64(m.Rect.Dx()) < w || float64(m.Rect.Dy()) < h {
+			break
+		}
+		mask = m
+	}
+	return mask
+}
+
+// FillWithMask fills the area covered by the mask with the style, which
+// can be anything that SetFillStyle accepts, without changing the fill
+// style of the canvas. The mask is an *AlphaMask or an *image.Alpha and
+// is stretched to the rectangle at x/y with the size w/h
+func (cv *Canvas) FillWithMask(style interface{}, mask interface{}, x, y, w, h float64) {
+	quad := [4]BackendVec{
+		cv.tf(BackendVec{x, y}),
+		cv.tf(BackendVec{x, y + h}),
+		cv.tf(BackendVec{x + w, y + h}),
+		cv.tf(BackendVec{x + w, y}),
+	}
+
+	var img *image.Alpha
+	switch m := mask.(type) {
+	case *AlphaMask:
+		img = m.level(quad[0].Sub(quad[3]).Len(), quad[0].Sub(quad[1]).Len())
+	case *image.Alpha:
+		img = m
+	default:
+		cv.reportError(fmt.Errorf("FillWithMask with a mask of type %T: %w", mask, ErrUnsupportedSource))
+		return
+	}
+	if img.Rect.Empty() {
+		return
+	}
+
+	cv.drawShadow(quad[:], img, false)
+
+	ds := cv.parseStyle(style)
+	stl := cv.backendFillStyle(&ds, 1)
+	cv.fillImageMask(&stl, img, quad)
+
+	cv.drawInsetShadow(quad[:], img)
+}
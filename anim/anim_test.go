@@ -0,0 +1,176 @@
+package anim_test
+
+import (
+	"image/color"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/opentoys/canvas"
+	"github.com/opentoys/canvas/anim"
+)
+
+func TestEasing(t *testing.T) {
+	for name, e := range map[string]anim.Easing{
+		"Linear":         anim.Linear,
+		"EaseInQuad":     anim.EaseInQuad,
+		"EaseOutQuad":    anim.EaseOutQuad,
+		"EaseInOutQuad":  anim.EaseInOutQuad,
+		"EaseInCubic":    anim.EaseInCubic,
+		"EaseOutCubic":   anim.EaseOutCubic,
+		"EaseInOutCubic": anim.EaseInOutCubic,
+		"EaseInSine":     anim.EaseInSine,
+		"EaseOutSine":    anim.EaseOutSine,
+		"EaseInOutSine":  anim.EaseInOutSine,
+		"EaseOutBack":    anim.EaseOutBack,
+		"EaseOutElastic": anim.EaseOutElastic,
+		"EaseOutBounce":  anim.EaseOutBounce,
+		"CubicBezier":    anim.CubicBezier(0.25, 0.1, 0.25, 1),
+	} {
+		if v := e(0); math.Abs(v) > 1e-9 {
+			t.Fatalf("%s(0) = %v", name, v)
+		}
+		if v := e(1); math.Abs(v-1) > 1e-9 {
+			t.Fatalf("%s(1) = %v", name, v)
+		}
+	}
+
+	// the CSS ease-in-out curve is symmetric
+	ease := anim.CubicBezier(0.42, 0, 0.58, 1)
+	if v := ease(0.5); math.Abs(v-0.5) > 1e-6 {
+		t.Fatalf("Expected 0.5 in the middle, got %v", v)
+	}
+	if v := ease(0.25) + ease(0.75); math.Abs(v-1) > 1e-6 {
+		t.Fatalf("Expected a symmetric curve, got %v", v)
+	}
+	if anim.EaseOutBack(0.7) <= 1 {
+		t.Fatal("Expected EaseOutBack to overshoot")
+	}
+}
+
+func TestTween(t *testing.T) {
+	tw := anim.NewTween(10, 20, 100*time.Millisecond, nil)
+	tw.Delay = 50 * time.Millisecond
+	tw.Update(25 * time.Millisecond)
+	if tw.Value() != 10 {
+		t.Fatalf("Expected the start value during the delay, got %v", tw.Value())
+	}
+	tw.Update(75 * time.Millisecond)
+	if tw.Value() != 15 || tw.Done() {
+		t.Fatalf("Expected 15 halfway, got %v", tw.Value())
+	}
+	tw.Update(time.Second)
+	if tw.Value() != 20 || !tw.Done() {
+		t.Fatalf("Expected the end value, got %v", tw.Value())
+	}
+	tw.Reset()
+	if tw.Value() != 10 || tw.Done() {
+		t.Fatal("Expected Reset to go back to the start")
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	var a, b []float64
+	var tl anim.Timeline
+	tl.Add(0, 100*time.Millisecond, nil, func(p float64) { a = append(a, p) }).
+		Then(100*time.Millisecond, anim.EaseInQuad, func(p float64) { b = append(b, p) })
+	if tl.Duration() != 200*time.Millisecond {
+		t.Fatalf("Expected 200ms, got %v", tl.Duration())
+	}
+
+	tl.Update(50 * time.Millisecond)
+	// skipping past the end of a still calls it with 1
+	tl.Update(100 * time.Millisecond)
+	tl.Update(100 * time.Millisecond)
+	if len(a) != 2 || a[0] != 0.5 || a[1] != 1 {
+		t.Fatalf("Unexpected progress of a: %v", a)
+	}
+	if len(b) != 2 || b[0] != 0.25 || b[1] != 1 || !tl.Done() {
+		t.Fatalf("Unexpected progress of b: %v", b)
+	}
+	tl.Update(time.Second)
+	if len(a) != 2 || len(b) != 2 {
+		t.Fatal("Expected finished animations not to be called again")
+	}
+
+	a, b = nil, nil
+	tl.Loop = true
+	tl.Seek(0)
+	tl.Update(250 * time.Millisecond)
+	if len(b) != 1 || b[0] != 1 || a[len(a)-1] != 0.5 || tl.Done() {
+		t.Fatalf("Expected the loop to finish b and restart a, got %v and %v", a, b)
+	}
+}
+
+func TestSpring(t *testing.T) {
+	s := anim.NewSpring(0, 200, 2*math.Sqrt(200))
+	s.Target = 100
+	var max float64
+	for i := 0; i < 120; i++ {
+		s.Update(time.Second / 60)
+		max = math.Max(max, s.Value)
+	}
+	if !s.Settled(0.01) {
+		t.Fatalf("Expected the spring to settle, got %v at %v", s.Value, s.Velocity)
+	}
+	if max > 100.001 {
+		t.Fatalf("Expected a critically damped spring not to overshoot, got %v", max)
+	}
+
+	// an underdamped spring overshoots, even with a single long frame
+	s = anim.NewSpring(0, 200, 5)
+	s.Target = 100
+	s.Update(300 * time.Millisecond)
+	if s.Value <= 100 {
+		t.Fatalf("Expected an overshoot, got %v", s.Value)
+	}
+}
+
+func TestLerp(t *testing.T) {
+	if c := anim.LerpColor(color.RGBA{0, 0, 0, 255}, color.RGBA{255, 100, 0, 255}, 0.5); c != (color.RGBA{128, 50, 0, 255}) {
+		t.Fatalf("Unexpected color %v", c)
+	}
+	if c := anim.LerpColor(color.RGBA{0, 0, 0, 255}, color.RGBA{255, 0, 0, 255}, 1.2); c.R != 255 {
+		t.Fatalf("Expected the color to be clamped, got %v", c)
+	}
+
+	// halfway through a quarter turn the shape keeps its size
+	tf := anim.LerpTransform(anim.IdentityTransform, anim.Transform{ScaleX: 1, ScaleY: 1, Rotation: math.Pi / 2}, 0.5)
+	if l := (canvas.BackendVec{1, 0}).MulMat(tf.Mat()).Len(); math.Abs(l-1) > 1e-9 {
+		t.Fatalf("Expected the length to stay 1, got %v", l)
+	}
+
+	line := []canvas.BackendVec{{0, 0}, {10, 0}}
+	corner := []canvas.BackendVec{{0, 10}, {10, 10}, {10, 20}}
+	pts := anim.Resample(corner, 5)
+	want := []canvas.BackendVec{{0, 10}, {5, 10}, {10, 10}, {10, 15}, {10, 20}}
+	for i := range want {
+		if pts[i].Sub(want[i]).Len() > 1e-9 {
+			t.Fatalf("Expected %v, got %v", want, pts)
+		}
+	}
+	mid := anim.Morph(line, corner, 0.5)
+	if len(mid) != 3 || mid[0] != (canvas.BackendVec{0, 5}) || mid[2] != (canvas.BackendVec{10, 10}) {
+		t.Fatalf("Unexpected morph %v", mid)
+	}
+
+	cv := canvas.New(canvas.NewBackend(10, 10))
+	pa, pb := cv.NewPath2D(), cv.NewPath2D()
+	pa.Rect(0, 0, 2, 2)
+	pb.Rect(4, 4, 4, 4)
+	morphed := anim.MorphPath(cv, pa, pb, 0.5).Polylines()
+	if len(morphed) != 1 || morphed[0][0] != (canvas.BackendVec{2, 2}) {
+		t.Fatalf("Unexpected morphed path %v", morphed)
+	}
+}
+
+func TestClock(t *testing.T) {
+	var c anim.Clock
+	if dt := c.Tick(); dt != 0 {
+		t.Fatalf("Expected 0 on the first tick, got %v", dt)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if dt := c.Tick(); dt < 5*time.Millisecond {
+		t.Fatalf("Expected at least 5ms, got %v", dt)
+	}
+}
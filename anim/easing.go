@@ -0,0 +1,108 @@
+// Package anim contains tweens, timelines and springs that compute
+// values for animations frame by frame, and functions to interpolate
+// the values of the canvas, such as colors, transformations and paths.
+//
+// All animations are advanced with Update and the time since the last
+// frame, which Clock measures, so they work with any frame loop, for
+// example one run by canvas.FramePacer
+package anim
+
+import (
+	"math"
+)
+
+// Easing maps the linear progress of an animation between 0 and 1 to the
+// eased progress. The result is 0 at 0 and 1 at 1, but can be outside of
+// that range in between, for example for EaseOutBack
+type Easing func(t float64) float64
+
+// Easing functions
+var (
+	Linear Easing = func(t float64) float64 { return t }
+
+	EaseInQuad    Easing = func(t float64) float64 { return t * t }
+	EaseOutQuad   Easing = func(t float64) float64 { return t * (2 - t) }
+	EaseInOutQuad Easing = func(t float64) float64 {
+		if t < 0.5 {
+			return 2 * t * t
+		}
+		return -1 + (4-2*t)*t
+	}
+
+	EaseInCubic    Easing = func(t float64) float64 { return t * t * t }
+	EaseOutCubic   Easing = func(t float64) float64 { t--; return t*t*t + 1 }
+	EaseInOutCubic Easing = func(t float64) float64 {
+		if t < 0.5 {
+			return 4 * t * t * t
+		}
+		t = 2*t - 2
+		return t*t*t/2 + 1
+	}
+
+	EaseInSine    Easing = func(t float64) float64 { return 1 - math.Cos(t*math.Pi/2) }
+	EaseOutSine   Easing = func(t float64) float64 { return math.Sin(t * math.Pi / 2) }
+	EaseInOutSine Easing = func(t float64) float64 { return (1 - math.Cos(t*math.Pi)) / 2 }
+
+	// EaseOutBack overshoots the target and then settles on it
+	EaseOutBack Easing = func(t float64) float64 {
+		const c1 = 1.70158
+		const c3 = c1 + 1
+		t--
+		return 1 + c3*t*t*t + c1*t*t
+	}
+
+	// EaseOutElastic swings around the target like a spring
+	EaseOutElastic Easing = func(t float64) float64 {
+		if t <= 0 || t >= 1 {
+			return math.Max(0, math.Min(1, t))
+		}
+		return math.Pow(2, -10*t)*math.Sin((t*10-0.75)*2*math.Pi/3) + 1
+	}
+
+	// EaseOutBounce bounces off the target like a dropped ball
+	EaseOutBounce Easing = func(t float64) float64 {
+		const n, d = 7.5625, 2.75
+		switch {
+		case t < 1/d:
+			return n * t * t
+		case t < 2/d:
+			t -= 1.5 / d
+			return n*t*t + 0.75
+		case t < 2.5/d:
+			t -= 2.25 / d
+			return n*t*t + 0.9375
+		}
+		t -= 2.625 / d
+		return n*t*t + 0.984375
+	}
+)
+
+// CubicBezier returns the easing of a cubic Bézier curve from 0/0 to 1/1
+// with the control points x1/y1 and x2/y2, like the CSS cubic-bezier
+// timing function. x1 and x2 must be between 0 and 1
+func CubicBezier(x1, y1, x2, y2 float64) Easing {
+	bezier := func(a, b, t float64) float64 {
+		return 3*a*(1-t)*(1-t)*t + 3*b*(1-t)*t*t + t*t*t
+	}
+	return func(x float64) float64 {
+		if x <= 0 || x >= 1 {
+			return x
+		}
+		// the curve is monotonic in x, so bisection always finds t
+		lo, hi := 0.0, 1.0
+		t := x
+		for i := 0; i < 40; i++ {
+			bx := bezier(x1, x2, t)
+			if math.Abs(bx-x) < 1e-9 {
+				break
+			}
+			if bx < x {
+				lo = t
+			} else {
+				hi = t
+			}
+			t = (lo + hi) / 2
+		}
+		return bezier(y1, y2, t)
+	}
+}
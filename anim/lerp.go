@@ -0,0 +1,134 @@
+package anim
+
+import (
+	"image/color"
+	"math"
+
+	"github.com/opentoys/canvas"
+)
+
+// Lerp interpolates between a and b, returning a at 0 and b at 1
+func Lerp(a, b, t float64) float64 {
+	return a + (b-a)*t
+}
+
+// LerpColor interpolates between two colors. Eased progress outside of
+// 0 to 1 is clamped to the valid color range
+func LerpColor(a, b color.RGBA, t float64) color.RGBA {
+	ch := func(x, y uint8) uint8 {
+		return uint8(math.Round(math.Max(0, math.Min(255, Lerp(float64(x), float64(y), t)))))
+	}
+	return color.RGBA{R: ch(a.R, b.R), G: ch(a.G, b.G), B: ch(a.B, b.B), A: ch(a.A, b.A)}
+}
+
+// Transform is a transformation split into parts that can be
+// interpolated, unlike the elements of a matrix, which skew and shrink
+// shapes that rotate in between
+type Transform struct {
+	X, Y           float64
+	ScaleX, ScaleY float64
+	// Rotation is the rotation in radians
+	Rotation float64
+}
+
+// IdentityTransform is a transform that changes nothing
+var IdentityTransform = Transform{ScaleX: 1, ScaleY: 1}
+
+// LerpTransform interpolates between two transforms part by part
+func LerpTransform(a, b Transform, t float64) Transform {
+	return Transform{
+		X:        Lerp(a.X, b.X, t),
+		Y:        Lerp(a.Y, b.Y, t),
+		ScaleX:   Lerp(a.ScaleX, b.ScaleX, t),
+		ScaleY:   Lerp(a.ScaleY, b.ScaleY, t),
+		Rotation: Lerp(a.Rotation, b.Rotation, t),
+	}
+}
+
+// Mat returns the matrix that scales, then rotates, then translates
+func (tf Transform) Mat() canvas.BackendMat {
+	return canvas.BackendMatScale(canvas.BackendVec{tf.ScaleX, tf.ScaleY}).
+		Mul(canvas.BackendMatRotate(tf.Rotation)).
+		Mul(canvas.BackendMatTranslate(canvas.BackendVec{tf.X, tf.Y}))
+}
+
+// Apply multiplies the current transformation of the canvas with the
+// transform
+func (tf Transform) Apply(cv *canvas.Canvas) {
+	m := tf.Mat()
+	cv.Transform(m[0], m[1], m[2], m[3], m[4], m[5])
+}
+
+// Resample returns n points that are evenly spaced along the polyline
+func Resample(pts []canvas.BackendVec, n int) []canvas.BackendVec {
+	if len(pts) == 0 || n <= 0 {
+		return nil
+	}
+	result := make([]canvas.BackendVec, 0, n)
+	if len(pts) == 1 || n == 1 {
+		for len(result) < n {
+			result = append(result, pts[0])
+		}
+		return result
+	}
+
+	var total float64
+	for i := 1; i < len(pts); i++ {
+		total += pts[i].Sub(pts[i-1]).Len()
+	}
+	seg, segStart := 1, 0.0
+	for i := 0; i < n; i++ {
+		d := total * float64(i) / float64(n-1)
+		for seg < len(pts)-1 && segStart+pts[seg].Sub(pts[seg-1]).Len() < d {
+			segStart += pts[seg].Sub(pts[seg-1]).Len()
+			seg++
+		}
+		a, b := pts[seg-1], pts[seg]
+		l := b.Sub(a).Len()
+		t := 0.0
+		if l > 0 {
+			t = math.Min(1, (d-segStart)/l)
+		}
+		result = append(result, a.Add(b.Sub(a).Mulf(t)))
+	}
+	return result
+}
+
+// Morph interpolates between two polylines, for example the ones
+// returned by Path2D.Polylines. Polylines with different numbers of
+// points are resampled to the larger number first
+func Morph(a, b []canvas.BackendVec, t float64) []canvas.BackendVec {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	if len(a) != n {
+		a = Resample(a, n)
+	}
+	if len(b) != n {
+		b = Resample(b, n)
+	}
+	result := make([]canvas.BackendVec, n)
+	for i := range result {
+		result[i] = canvas.BackendVec{Lerp(a[i][0], b[i][0], t), Lerp(a[i][1], b[i][1], t)}
+	}
+	return result
+}
+
+// MorphPath returns a path that interpolates between the subpaths of a
+// and b, which need to have the same number of subpaths. Curves are
+// morphed as the lines that they are drawn with
+func MorphPath(cv *canvas.Canvas, a, b *canvas.Path2D, t float64) *canvas.Path2D {
+	pa, pb := a.Polylines(), b.Polylines()
+	path := cv.NewPath2D()
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		for j, pt := range Morph(pa[i], pb[i], t) {
+			if j == 0 {
+				path.MoveTo(pt[0], pt[1])
+			} else {
+				path.LineTo(pt[0], pt[1])
+			}
+		}
+	}
+	return path
+}
@@ -0,0 +1,61 @@
+package anim
+
+import (
+	"math"
+	"time"
+)
+
+// springStep is the longest time step of the simulation, which keeps
+// stiff springs stable at low frame rates
+const springStep = time.Second / 240
+
+// Spring moves a value towards a target like a damped spring, which
+// gives natural motion that can change its target at any time without
+// jumps in speed
+type Spring struct {
+	// Stiffness is the force towards the target per unit of distance
+	Stiffness float64
+	// Damping is the force against the velocity. A damping of
+	// 2*sqrt(Stiffness*Mass) reaches the target fastest without
+	// overshooting
+	Damping float64
+	// Mass is the mass of the value. Zero is treated as 1
+	Mass float64
+
+	Value    float64
+	Velocity float64
+	Target   float64
+}
+
+// NewSpring creates a spring at value that moves towards the same
+// target, with the given stiffness and damping
+func NewSpring(value, stiffness, damping float64) *Spring {
+	return &Spring{Stiffness: stiffness, Damping: damping, Mass: 1, Value: value, Target: value}
+}
+
+// Update advances the simulation by dt
+func (s *Spring) Update(dt time.Duration) {
+	mass := s.Mass
+	if mass == 0 {
+		mass = 1
+	}
+	for dt > 0 {
+		step := dt
+		if step > springStep {
+			step = springStep
+		}
+		dt -= step
+		h := step.Seconds()
+		force := -s.Stiffness*(s.Value-s.Target) - s.Damping*s.Velocity
+		// semi-implicit Euler, which keeps the energy of an undamped
+		// spring from growing
+		s.Velocity += force / mass * h
+		s.Value += s.Velocity * h
+	}
+}
+
+// Settled returns true if the value is within epsilon of the target and
+// hardly moves anymore
+func (s *Spring) Settled(epsilon float64) bool {
+	return math.Abs(s.Value-s.Target) < epsilon && math.Abs(s.Velocity) < epsilon
+}
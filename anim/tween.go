@@ -0,0 +1,180 @@
+package anim
+
+import (
+	"sort"
+	"time"
+)
+
+// Clock measures the time between frames
+type Clock struct {
+	last time.Time
+}
+
+// Tick returns the time since the last call, or zero on the first call.
+// Call it once per frame and pass the result to Update
+func (c *Clock) Tick() time.Duration {
+	now := time.Now()
+	if c.last.IsZero() {
+		c.last = now
+		return 0
+	}
+	dt := now.Sub(c.last)
+	c.last = now
+	return dt
+}
+
+// Tween animates a value from one number to another over a fixed time
+type Tween struct {
+	From, To float64
+	Duration time.Duration
+	// Delay is the time before the tween starts
+	Delay time.Duration
+	// Easing is the easing function. Nil is linear
+	Easing Easing
+
+	elapsed time.Duration
+}
+
+// NewTween creates a tween from from to to
+func NewTween(from, to float64, duration time.Duration, easing Easing) *Tween {
+	return &Tween{From: from, To: to, Duration: duration, Easing: easing}
+}
+
+// Update advances the tween by dt
+func (tw *Tween) Update(dt time.Duration) {
+	tw.elapsed += dt
+}
+
+// Seek moves the tween to the given time since its start, including the
+// delay
+func (tw *Tween) Seek(t time.Duration) {
+	tw.elapsed = t
+}
+
+// Reset moves the tween back to its start
+func (tw *Tween) Reset() {
+	tw.elapsed = 0
+}
+
+// Progress returns the eased progress of the tween, which is 0 before it
+// starts and 1 after it ends
+func (tw *Tween) Progress() float64 {
+	t := tw.elapsed - tw.Delay
+	if t <= 0 {
+		return 0
+	}
+	if t >= tw.Duration {
+		return 1
+	}
+	p := float64(t) / float64(tw.Duration)
+	if tw.Easing != nil {
+		p = tw.Easing(p)
+	}
+	return p
+}
+
+// Value returns the current value of the tween
+func (tw *Tween) Value() float64 {
+	return Lerp(tw.From, tw.To, tw.Progress())
+}
+
+// Done returns true once the tween has ended
+func (tw *Tween) Done() bool {
+	return tw.elapsed >= tw.Delay+tw.Duration
+}
+
+type timelineEntry struct {
+	start    time.Duration
+	duration time.Duration
+	easing   Easing
+	fn       func(p float64)
+	// done is set once fn was called with the final progress
+	done bool
+}
+
+// Timeline runs animations at fixed times, one after another or
+// overlapping. Each animation calls its function with the eased progress
+// between 0 and 1 on every Update while it runs, and once more with 1
+// when it ends, even if a frame skipped past the end
+type Timeline struct {
+	// Loop restarts the timeline when it ends
+	Loop bool
+
+	entries []timelineEntry
+	elapsed time.Duration
+}
+
+// Add adds an animation that starts at the given time. It returns the
+// timeline to allow chaining
+func (tl *Timeline) Add(at, duration time.Duration, easing Easing, fn func(p float64)) *Timeline {
+	tl.entries = append(tl.entries, timelineEntry{start: at, duration: duration, easing: easing, fn: fn})
+	sort.SliceStable(tl.entries, func(i, j int) bool { return tl.entries[i].start < tl.entries[j].start })
+	return tl
+}
+
+// Then adds an animation that starts when all animations added so far
+// have ended
+func (tl *Timeline) Then(duration time.Duration, easing Easing, fn func(p float64)) *Timeline {
+	return tl.Add(tl.Duration(), duration, easing, fn)
+}
+
+// Duration returns the time at which the last animation ends
+func (tl *Timeline) Duration() time.Duration {
+	var end time.Duration
+	for _, e := range tl.entries {
+		if e.start+e.duration > end {
+			end = e.start + e.duration
+		}
+	}
+	return end
+}
+
+// Done returns true once all animations have ended. A looping timeline
+// is never done
+func (tl *Timeline) Done() bool {
+	return !tl.Loop && tl.elapsed >= tl.Duration()
+}
+
+// Update advances the timeline by dt and calls the functions of the
+// running animations
+func (tl *Timeline) Update(dt time.Duration) {
+	tl.Seek(tl.elapsed + dt)
+}
+
+// Seek moves the timeline to the given time and calls the functions of
+// the animations that run at that time
+func (tl *Timeline) Seek(t time.Duration) {
+	if d := tl.Duration(); tl.Loop && d > 0 && t >= d {
+		// finish the current pass before starting the next one
+		tl.run(d)
+		t %= d
+		for i := range tl.entries {
+			tl.entries[i].done = false
+		}
+	} else if t < tl.elapsed {
+		for i := range tl.entries {
+			tl.entries[i].done = false
+		}
+	}
+	tl.elapsed = t
+	tl.run(t)
+}
+
+func (tl *Timeline) run(t time.Duration) {
+	for i := range tl.entries {
+		e := &tl.entries[i]
+		if t < e.start || e.done {
+			continue
+		}
+		p := 1.0
+		if t < e.start+e.duration {
+			p = float64(t-e.start) / float64(e.duration)
+			if e.easing != nil {
+				p = e.easing(p)
+			}
+		} else {
+			e.done = true
+		}
+		e.fn(p)
+	}
+}
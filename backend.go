@@ -22,15 +22,18 @@ type Backend interface {
 	Fill(style *BackendFillStyle, pts []BackendVec, tf BackendMat, canOverlap bool)
 	DrawImage(dimg BackendImage, sx, sy, sw, sh float64, pts [4]BackendVec, alpha float64)
 	FillImageMask(style *BackendFillStyle, mask *image.Alpha, pts [4]BackendVec) // pts must have four points
+	FillTrianglesVertexColor(pts []BackendVec, colors []color.RGBA)              // one color per point, three points per triangle
 
 	ClearClip()
 	Clip(pts []BackendVec)
 
-	GetImageData(x, y, w, h int) *image.RGBA
+	GetImageData(x, y, w, h int) *image.RGBA // straight alpha, see UnpremultiplyRGBA
 	PutImageData(img *image.RGBA, x, y int)
 
 	CanUseAsImage(b Backend) bool
 	AsImage() BackendImage // can return nil if not supported
+
+	Capabilities() BackendCapabilities
 }
 
 // FillStyle is the color and other details on how to fill
@@ -46,15 +49,40 @@ type BackendFillStyle struct {
 		RadTo   float64
 	}
 	ImagePattern BackendImagePattern
+	// Dither is the dithering of gradients. Backends that don't
+	// support it draw gradients without dithering
+	Dither BackendDither
 }
 
+type BackendDither uint8
+
+// Gradient dithering constants
+const (
+	BackendNoDither BackendDither = iota
+	// BackendDitherOrdered adds the thresholds of an 8x8 Bayer matrix
+	BackendDitherOrdered
+	// BackendDitherNoise adds a random threshold for every pixel
+	BackendDitherNoise
+)
+
 type BackendGradient []BackendGradientStop
 
 func (g BackendGradient) ColorAt(pos float64) color.RGBA {
+	c := g.colorAt(pos)
+	return color.RGBA{
+		R: uint8(math.Round(c[0])),
+		G: uint8(math.Round(c[1])),
+		B: uint8(math.Round(c[2])),
+		A: uint8(math.Round(c[3])),
+	}
+}
+
+// colorAt returns the color at pos before it is rounded to 8 bits
+func (g BackendGradient) colorAt(pos float64) [4]float64 {
 	if len(g) == 0 {
-		return color.RGBA{}
+		return [4]float64{}
 	} else if len(g) == 1 {
-		return g[0].Color
+		return rgbaFloats(g[0].Color)
 	}
 	beforeIdx, afterIdx := -1, -1
 	for i, stop := range g {
@@ -65,9 +93,9 @@ func (g BackendGradient) ColorAt(pos float64) color.RGBA {
 		beforeIdx = i
 	}
 	if beforeIdx == -1 {
-		return g[0].Color
+		return rgbaFloats(g[0].Color)
 	} else if afterIdx == -1 {
-		return g[len(g)-1].Color
+		return rgbaFloats(g[len(g)-1].Color)
 	}
 	before, after := g[beforeIdx], g[afterIdx]
 	p := (pos - before.Pos) / (after.Pos - before.Pos)
@@ -76,12 +104,11 @@ func (g BackendGradient) ColorAt(pos float64) color.RGBA {
 	c[1] = (float64(after.Color.G)-float64(before.Color.G))*p + float64(before.Color.G)
 	c[2] = (float64(after.Color.B)-float64(before.Color.B))*p + float64(before.Color.B)
 	c[3] = (float64(after.Color.A)-float64(before.Color.A))*p + float64(before.Color.A)
-	return color.RGBA{
-		R: uint8(math.Round(c[0])),
-		G: uint8(math.Round(c[1])),
-		B: uint8(math.Round(c[2])),
-		A: uint8(math.Round(c[3])),
-	}
+	return c
+}
+
+func rgbaFloats(c color.RGBA) [4]float64 {
+	return [4]float64{float64(c.R), float64(c.G), float64(c.B), float64(c.A)}
 }
 
 type BackendGradientStop struct {
@@ -111,6 +138,9 @@ type BackendImagePatternData struct {
 	Image     BackendImage
 	Transform [9]float64
 	Repeat    BackendImagePatternRepeat
+	// Area is the x, y, width and height in image pixels of the area that
+	// BackendRepeatRound and BackendRepeatSpace fit the tiles into
+	Area [4]float64
 }
 
 type BackendImagePatternRepeat uint8
@@ -121,6 +151,12 @@ const (
 	BackendRepeatX
 	BackendRepeatY
 	BackendNoRepeat
+	// BackendRepeatRound scales the tiles so that a whole number of them
+	// fits into the area
+	BackendRepeatRound
+	// BackendRepeatSpace repeats as many whole tiles as fit into the area
+	// and distributes the remaining space between them
+	BackendRepeatSpace
 )
 
 type BackendImagePattern interface {
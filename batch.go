@@ -0,0 +1,99 @@
+package canvas
+
+// fillBatch collects consecutive fills with the same style so that they
+// can be sent to the backend with a single Fill call
+type fillBatch struct {
+	depth      int
+	style      BackendFillStyle
+	pts        []BackendVec
+	bounds     Bounds
+	canOverlap bool
+}
+
+// BeginBatch starts merging consecutive fills and strokes that use the
+// same style into a single backend call. This reduces the overhead per
+// call, especially on GPU backends. Any other drawing call, clipping,
+// and reading image data flush the batch first, so the result is the
+// same as without batching. Fills with translucent styles are only
+// merged if they don't overlap. Calls can be nested, batching ends
+// with the outermost EndBatch.
+//
+// When drawing with a batch, pending fills are only visible in the
+// backend after calling Flush or EndBatch
+func (cv *Canvas) BeginBatch() {
+	cv.batch.depth++
+}
+
+// EndBatch flushes the pending fills and ends the batch started with
+// the matching BeginBatch
+func (cv *Canvas) EndBatch() {
+	if cv.batch.depth == 0 {
+		return
+	}
+	cv.batch.depth--
+	if cv.batch.depth == 0 {
+		cv.Flush()
+	}
+}
+
+// Flush sends all pending batched fills to the backend
+func (cv *Canvas) Flush() {
+	if len(cv.batch.pts) == 0 {
+		return
+	}
+	cv.backendFill(&cv.batch.style, cv.batch.pts, BackendMatIdentity, cv.batch.canOverlap)
+	cv.batch.pts = cv.batch.pts[:0]
+}
+
+// fill is used instead of calling Fill on the backend directly, so that
+// the fill can be added to the current batch
+func (cv *Canvas) fill(style *BackendFillStyle, pts []BackendVec, tf BackendMat, canOverlap bool) {
+	if cv.culled(transformedBounds(pts, tf).Grow(style.Blur * 3)) {
+		return
+	}
+	if cv.batch.depth == 0 || style.Blur > 0 {
+		cv.Flush()
+		// the batch is empty after flushing, and copying the style and
+		// points into it keeps the values of the caller from escaping to
+		// the heap through the backend interface
+		cv.batch.style = *style
+		cv.batch.pts = append(cv.batch.pts[:0], pts...)
+		cv.backendFill(&cv.batch.style, cv.batch.pts, tf, canOverlap)
+		cv.batch.pts = cv.batch.pts[:0]
+		return
+	}
+
+	start := len(cv.batch.pts)
+	if len(pts) == 4 {
+		// four points are a quad rather than a triangle list
+		for _, i := range [...]int{0, 1, 2, 0, 2, 3} {
+			cv.batch.pts = append(cv.batch.pts, pts[i].MulMat(tf))
+		}
+	} else {
+		for _, pt := range pts {
+			cv.batch.pts = append(cv.batch.pts, pt.MulMat(tf))
+		}
+	}
+	bounds := BoundsOf(cv.batch.pts[start:])
+
+	if start > 0 && (*style != cv.batch.style || (!style.opaque() && bounds.Intersects(cv.batch.bounds))) {
+		added := cv.batch.pts[start:]
+		cv.backendFill(&cv.batch.style, cv.batch.pts[:start], BackendMatIdentity, cv.batch.canOverlap)
+		cv.batch.pts = append(cv.batch.pts[:0], added...)
+		start = 0
+	}
+	if start == 0 {
+		cv.batch.style = *style
+		cv.batch.bounds = bounds
+		cv.batch.canOverlap = canOverlap
+		return
+	}
+	cv.batch.bounds = cv.batch.bounds.Union(bounds)
+	cv.batch.canOverlap = cv.batch.canOverlap || canOverlap
+}
+
+// opaque returns true if the style only produces opaque pixels, so that
+// overlapping fills can be merged without changing the result
+func (s *BackendFillStyle) opaque() bool {
+	return s.Color.A == 255 && s.LinearGradient == nil && s.RadialGradient == nil && s.ImagePattern == nil
+}
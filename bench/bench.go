@@ -0,0 +1,228 @@
+// Package bench is a headless benchmark suite with standardized scenes,
+// such as many small fills, large blurs, walls of text and image scaling.
+// The runner measures the time and allocations per frame of every scene
+// on every registered backend, so that backends can be compared and
+// regressions between releases become visible. The results can be saved
+// as JSON and compared with a later run
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/opentoys/canvas"
+)
+
+// Options control a run of the suite
+type Options struct {
+	// Backends are the names of registered backends to run the scenes
+	// on. Nil runs them on all registered backends
+	Backends []string
+	// Scenes are the scenes to run. Nil runs the standard Scenes
+	Scenes []Scene
+	// Duration is the minimum time that every scene is run for. Zero is
+	// one second
+	Duration time.Duration
+	// MinFrames is the minimum number of frames of every scene
+	MinFrames int
+	// MSAA and Params are passed to the backends
+	MSAA   int
+	Params map[string]interface{}
+	// Font is the font of the text scenes, in any form accepted by
+	// Canvas.LoadFont. Text scenes are skipped without it
+	Font interface{}
+}
+
+// Result is the measurement of one scene on one backend
+type Result struct {
+	Backend string `json:"backend"`
+	Scene   string `json:"scene"`
+	Frames  int    `json:"frames"`
+	// PerFrame is the average time of a frame, including the flush
+	PerFrame time.Duration `json:"perFrame"`
+	// AllocsPerFrame and BytesPerFrame are the average heap allocations
+	// of a frame
+	AllocsPerFrame float64 `json:"allocsPerFrame"`
+	BytesPerFrame  float64 `json:"bytesPerFrame"`
+	// Skipped is the reason why the scene was not run, if it wasn't
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// MsPerFrame returns the time per frame in milliseconds
+func (r Result) MsPerFrame() float64 {
+	return float64(r.PerFrame) / float64(time.Millisecond)
+}
+
+// Run runs the scenes on the backends. An error is returned if a backend
+// can't be created or the setup of a scene fails
+func Run(opts Options) ([]Result, error) {
+	names := opts.Backends
+	if names == nil {
+		names = canvas.Backends()
+	}
+	scenes := opts.Scenes
+	if scenes == nil {
+		scenes = Scenes
+	}
+	var results []Result
+	for _, name := range names {
+		for _, sc := range scenes {
+			r, err := runScene(name, sc, &opts)
+			if err != nil {
+				return results, fmt.Errorf("Scene %s on backend %s: %w", sc.Name, name, err)
+			}
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+func runScene(name string, sc Scene, opts *Options) (Result, error) {
+	r := Result{Backend: name, Scene: sc.Name}
+	cv, err := canvas.NewWithBackend(name, canvas.BackendOptions{
+		Width:  sc.Width,
+		Height: sc.Height,
+		MSAA:   opts.MSAA,
+		Params: opts.Params,
+	})
+	if err != nil {
+		return r, err
+	}
+	frame, err := sc.Setup(cv, opts)
+	if err == errSkip {
+		r.Skipped = "missing font"
+		return r, nil
+	} else if err != nil {
+		return r, err
+	}
+
+	// the first frame fills the caches of the canvas and the backend
+	frame()
+	cv.Flush()
+
+	duration := opts.Duration
+	if duration <= 0 {
+		duration = time.Second
+	}
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	var elapsed time.Duration
+	for r.Frames < opts.MinFrames || elapsed < duration {
+		frame()
+		cv.Flush()
+		r.Frames++
+		elapsed = time.Since(start)
+	}
+	runtime.ReadMemStats(&after)
+
+	r.PerFrame = elapsed / time.Duration(r.Frames)
+	r.AllocsPerFrame = float64(after.Mallocs-before.Mallocs) / float64(r.Frames)
+	r.BytesPerFrame = float64(after.TotalAlloc-before.TotalAlloc) / float64(r.Frames)
+	return r, nil
+}
+
+// WriteReport writes the results as a table with a row per scene and a
+// column per backend
+func WriteReport(w io.Writer, results []Result) error {
+	var backends, scenes []string
+	cells := make(map[[2]string]Result)
+	for _, r := range results {
+		key := [2]string{r.Scene, r.Backend}
+		if _, ok := cells[key]; ok {
+			continue
+		}
+		cells[key] = r
+		backends = appendUnique(backends, r.Backend)
+		scenes = appendUnique(scenes, r.Scene)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "scene\t")
+	for _, b := range backends {
+		fmt.Fprintf(tw, "%s ms/frame\t%s allocs/frame\t", b, b)
+	}
+	fmt.Fprintln(tw)
+	for _, s := range scenes {
+		fmt.Fprintf(tw, "%s\t", s)
+		for _, b := range backends {
+			r, ok := cells[[2]string{s, b}]
+			switch {
+			case !ok:
+				fmt.Fprint(tw, "-\t-\t")
+			case r.Skipped != "":
+				fmt.Fprint(tw, "skipped\t-\t")
+			default:
+				fmt.Fprintf(tw, "%.3f\t%.1f\t", r.MsPerFrame(), r.AllocsPerFrame)
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// WriteJSON saves the results, for example as the baseline of a release
+func WriteJSON(w io.Writer, results []Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(results)
+}
+
+// ReadJSON loads results saved with WriteJSON
+func ReadJSON(r io.Reader) ([]Result, error) {
+	var results []Result
+	err := json.NewDecoder(r).Decode(&results)
+	return results, err
+}
+
+// Regression is a scene that got slower or allocates more than in the
+// baseline
+type Regression struct {
+	Backend, Scene string
+	// Old and New are the results of the baseline and the current run
+	Old, New Result
+	// Slowdown is the ratio of the times per frame
+	Slowdown float64
+	// MoreAllocs is set if the number of allocations per frame grew
+	MoreAllocs bool
+}
+
+// Compare returns the scenes that are more than threshold times slower
+// than in the baseline, for example 1.1 for ten percent, or that allocate
+// more per frame, sorted by the slowdown
+func Compare(baseline, current []Result, threshold float64) []Regression {
+	old := make(map[[2]string]Result)
+	for _, r := range baseline {
+		old[[2]string{r.Backend, r.Scene}] = r
+	}
+	var regs []Regression
+	for _, r := range current {
+		o, ok := old[[2]string{r.Backend, r.Scene}]
+		if !ok || o.Skipped != "" || r.Skipped != "" || o.PerFrame <= 0 {
+			continue
+		}
+		slowdown := float64(r.PerFrame) / float64(o.PerFrame)
+		// fractions of an allocation are noise from the runtime
+		moreAllocs := r.AllocsPerFrame >= o.AllocsPerFrame+1
+		if slowdown > threshold || moreAllocs {
+			regs = append(regs, Regression{Backend: r.Backend, Scene: r.Scene, Old: o, New: r, Slowdown: slowdown, MoreAllocs: moreAllocs})
+		}
+	}
+	sort.Slice(regs, func(i, j int) bool { return regs[i].Slowdown > regs[j].Slowdown })
+	return regs
+}
@@ -0,0 +1,119 @@
+package bench_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentoys/canvas"
+	"github.com/opentoys/canvas/bench"
+)
+
+// textDisabled is set when testing with the canvas_notext build tag
+var textDisabled = false
+
+func TestRun(t *testing.T) {
+	opts := bench.Options{
+		Backends:  []string{"software"},
+		Duration:  time.Millisecond,
+		MinFrames: 2,
+		Font:      "../testdata/Roboto-Light.ttf",
+	}
+	if textDisabled {
+		// without text support the text scene is skipped
+		opts.Font = nil
+	}
+	results, err := bench.Run(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(bench.Scenes) {
+		t.Fatalf("Expected a result per scene, got %d", len(results))
+	}
+	for _, r := range results {
+		if r.Skipped != "" {
+			continue
+		}
+		if r.Frames < 2 || r.PerFrame <= 0 {
+			t.Errorf("%s: expected at least 2 measured frames, got %d in %v", r.Scene, r.Frames, r.PerFrame)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := bench.WriteReport(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "software ms/frame") || !strings.Contains(buf.String(), "LargeBlur") {
+		t.Fatalf("Unexpected report:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := bench.WriteJSON(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := bench.ReadJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != len(results) || loaded[0] != results[0] {
+		t.Fatal("Expected the results to survive JSON")
+	}
+
+	_, err = bench.Run(bench.Options{Backends: []string{"nonexistent"}})
+	if err == nil {
+		t.Fatal("Expected an error for an unknown backend")
+	}
+}
+
+func TestSkip(t *testing.T) {
+	results, err := bench.Run(bench.Options{
+		Backends: []string{"software"},
+		Scenes:   []bench.Scene{bench.Scenes[3]},
+		Duration: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Scene != "TextWall" || results[0].Skipped == "" {
+		t.Fatalf("Expected the text scene to be skipped without a font, got %+v", results[0])
+	}
+}
+
+func TestCompare(t *testing.T) {
+	baseline := []bench.Result{
+		{Backend: "software", Scene: "A", PerFrame: 10 * time.Millisecond},
+		{Backend: "software", Scene: "B", PerFrame: 10 * time.Millisecond},
+		{Backend: "software", Scene: "C", PerFrame: 10 * time.Millisecond, AllocsPerFrame: 1},
+	}
+	current := []bench.Result{
+		{Backend: "software", Scene: "A", PerFrame: 10500 * time.Microsecond},
+		{Backend: "software", Scene: "B", PerFrame: 15 * time.Millisecond},
+		{Backend: "software", Scene: "C", PerFrame: 10 * time.Millisecond, AllocsPerFrame: 5},
+		{Backend: "software", Scene: "D", PerFrame: 50 * time.Millisecond},
+	}
+	regs := bench.Compare(baseline, current, 1.1)
+	if len(regs) != 2 || regs[0].Scene != "B" || regs[1].Scene != "C" || !regs[1].MoreAllocs {
+		t.Fatalf("Expected regressions of B and C, got %+v", regs)
+	}
+}
+
+func BenchmarkScenes(b *testing.B) {
+	for _, sc := range bench.Scenes {
+		sc := sc
+		b.Run(sc.Name, func(b *testing.B) {
+			cv := canvas.New(canvas.NewBackend(sc.Width, sc.Height))
+			frame, err := sc.Setup(cv, &bench.Options{Font: "../testdata/Roboto-Light.ttf"})
+			if err != nil {
+				b.Fatal(err)
+			}
+			frame()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				frame()
+				cv.Flush()
+			}
+		})
+	}
+}
@@ -0,0 +1,8 @@
+//go:build canvas_notext
+// +build canvas_notext
+
+package bench_test
+
+func init() {
+	textDisabled = true
+}
@@ -0,0 +1,172 @@
+package bench
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"strings"
+
+	"github.com/opentoys/canvas"
+)
+
+// Scene is a standardized drawing workload
+type Scene struct {
+	Name          string
+	Width, Height int
+	// Setup loads the resources of the scene and returns the function
+	// that draws one frame
+	Setup func(cv *canvas.Canvas, opts *Options) (frame func(), err error)
+}
+
+// errSkip is returned by the setup of scenes that can't run with the
+// options, for example text without a font
+var errSkip = errors.New("skipped")
+
+// Scenes are the standard scenes, run by default
+var Scenes = []Scene{
+	{Name: "ManyFills", Width: 800, Height: 600, Setup: manyFills},
+	{Name: "Strokes", Width: 800, Height: 600, Setup: strokes},
+	{Name: "LargeBlur", Width: 800, Height: 600, Setup: largeBlur},
+	{Name: "TextWall", Width: 800, Height: 600, Setup: textWall},
+	{Name: "ImageScaling", Width: 800, Height: 600, Setup: imageScaling},
+	{Name: "Gradients", Width: 800, Height: 600, Setup: gradients},
+}
+
+// manyFills fills thousands of small rectangles and circles in
+// translucent colors, like charts and particle systems
+func manyFills(cv *canvas.Canvas, opts *Options) (func(), error) {
+	type shape struct {
+		x, y, s float64
+		// boxed once, so that the frames measure the canvas only
+		c interface{}
+	}
+	rnd := rand.New(rand.NewSource(1))
+	shapes := make([]shape, 5000)
+	for i := range shapes {
+		shapes[i] = shape{
+			x: rnd.Float64() * 800,
+			y: rnd.Float64() * 600,
+			s: 2 + rnd.Float64()*10,
+			c: color.RGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), 200},
+		}
+	}
+	return func() {
+		cv.ClearRect(0, 0, 800, 600)
+		for i, s := range shapes {
+			cv.SetFillStyle(s.c)
+			if i%2 == 0 {
+				cv.FillRect(s.x, s.y, s.s, s.s)
+				continue
+			}
+			cv.BeginPath()
+			cv.Arc(s.x, s.y, s.s/2, 0, math.Pi*2, false)
+			cv.Fill()
+		}
+	}, nil
+}
+
+// strokes draws wide curved lines with joins and caps
+func strokes(cv *canvas.Canvas, opts *Options) (func(), error) {
+	rnd := rand.New(rand.NewSource(2))
+	pts := make([][8]float64, 300)
+	for i := range pts {
+		for j := range pts[i] {
+			pts[i][j] = rnd.Float64() * 600
+		}
+	}
+	return func() {
+		cv.ClearRect(0, 0, 800, 600)
+		cv.SetStrokeStyle("#3366CCB0")
+		cv.SetLineWidth(4)
+		cv.SetLineJoin(canvas.Round)
+		cv.SetLineCap(canvas.Round)
+		for _, p := range pts {
+			cv.BeginPath()
+			cv.MoveTo(p[0], p[1])
+			cv.BezierCurveTo(p[2], p[3], p[4], p[5], p[6], p[7])
+			cv.Stroke()
+		}
+	}, nil
+}
+
+// largeBlur fills large shapes with blurred shadows, like the cards and
+// dialogs of user interfaces
+func largeBlur(cv *canvas.Canvas, opts *Options) (func(), error) {
+	return func() {
+		cv.ClearRect(0, 0, 800, 600)
+		cv.Save()
+		cv.SetShadowColor("#000000A0")
+		cv.SetShadowBlur(24)
+		cv.SetShadowOffset(0, 8)
+		cv.SetFillStyle("#FFF")
+		cv.FillRect(100, 80, 600, 440)
+		cv.BeginPath()
+		cv.Arc(400, 300, 150, 0, math.Pi*2, false)
+		cv.Fill()
+		cv.Restore()
+	}, nil
+}
+
+// textWall fills the canvas with lines of small text
+func textWall(cv *canvas.Canvas, opts *Options) (func(), error) {
+	if opts.Font == nil {
+		return nil, errSkip
+	}
+	font, err := cv.LoadFont(opts.Font)
+	if err != nil {
+		return nil, err
+	}
+	line := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 3)
+	return func() {
+		cv.ClearRect(0, 0, 800, 600)
+		cv.SetFont(font, 12)
+		cv.SetFillStyle("#222")
+		for y := 14.0; y < 600; y += 14 {
+			cv.FillText(line, 4, y)
+		}
+	}, nil
+}
+
+// imageScaling draws a large image scaled down and a small one scaled
+// up, like photo viewers and zoomed pixel art
+func imageScaling(cv *canvas.Canvas, opts *Options) (func(), error) {
+	rgba := image.NewRGBA(image.Rect(0, 0, 1024, 1024))
+	for y := 0; y < 1024; y++ {
+		for x := 0; x < 1024; x++ {
+			rgba.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), uint8(x ^ y), 255})
+		}
+	}
+	large, err := cv.LoadImage(rgba)
+	if err != nil {
+		return nil, err
+	}
+	small, err := cv.LoadImage(rgba.SubImage(image.Rect(0, 0, 32, 32)))
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		cv.ClearRect(0, 0, 800, 600)
+		cv.DrawImage(large, 0, 0, 300, 300)
+		cv.DrawImage(large, 300, 0, 150, 150)
+		cv.DrawImage(small, 400, 200, 400, 400)
+	}, nil
+}
+
+// gradients fills the canvas with linear and radial gradients
+func gradients(cv *canvas.Canvas, opts *Options) (func(), error) {
+	lg := cv.CreateLinearGradient(0, 0, 800, 600)
+	lg.AddColorStop(0, "#F00")
+	lg.AddColorStop(0.5, "#0F08")
+	lg.AddColorStop(1, "#00F")
+	rg := cv.CreateRadialGradient(400, 300, 10, 400, 300, 300)
+	rg.AddColorStop(0, "#FFF")
+	rg.AddColorStop(1, "#0000")
+	return func() {
+		cv.SetFillStyle(lg)
+		cv.FillRect(0, 0, 800, 600)
+		cv.SetFillStyle(rg)
+		cv.FillRect(0, 0, 800, 600)
+	}, nil
+}
@@ -0,0 +1,59 @@
+package canvas
+
+import (
+	"image"
+	"image/draw"
+)
+
+// SetBuffers sets the number of images that the backend draws into in
+// turn with Present, two for double and three for triple buffering. One
+// turns buffering off, which is the default. The current content is
+// kept
+func (b *SoftwareBackend) SetBuffers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	buffers := make([]*image.RGBA, n)
+	buffers[0] = b.Image
+	for i := 1; i < n; i++ {
+		buffers[i] = image.NewRGBA(b.Image.Rect)
+	}
+	b.buffers, b.buffer = buffers, 0
+}
+
+// Buffers returns the number of buffers set with SetBuffers
+func (b *SoftwareBackend) Buffers() int {
+	if len(b.buffers) == 0 {
+		return 1
+	}
+	return len(b.buffers)
+}
+
+// Present returns the image of the finished frame and continues drawing
+// into the next buffer, which starts with a copy of the frame, so that
+// goroutines that encode or upload the frame never see a half drawn one.
+// The returned image is not changed until Present was called as many
+// more times as there are other buffers, in which time it has to be
+// done with. Without buffering Present returns Image, which keeps
+// changing. Image is the next buffer after the call, and buffers of a
+// different size after SetSize are replaced
+func (b *SoftwareBackend) Present() *image.RGBA {
+	frame := b.Image
+	if len(b.buffers) < 2 {
+		return frame
+	}
+	b.buffers[b.buffer] = frame
+	b.buffer = (b.buffer + 1) % len(b.buffers)
+	next := b.buffers[b.buffer]
+	if next.Rect != frame.Rect {
+		next = image.NewRGBA(frame.Rect)
+		b.buffers[b.buffer] = next
+	}
+	draw.Draw(next, next.Rect, frame, frame.Rect.Min, draw.Src)
+	b.Image = next
+	// snapshots keep sharing the same pixels, now in the next buffer
+	for _, s := range b.snapshots {
+		s.src = next
+	}
+	return frame
+}
@@ -0,0 +1,71 @@
+package canvas
+
+type cacheGroup struct {
+	cv      *Canvas
+	backend *SoftwareBackend
+	img     *Image
+	valid   bool
+}
+
+// CacheGroup draws a group of drawing calls that rarely changes, such as
+// a static map layer or UI chrome. The first time a group with the given
+// id is drawn, fn is called to draw it into an offscreen image of the
+// canvas size, and the image is drawn onto the canvas. Later calls with
+// the same id only draw the image, until the group is invalidated with
+// InvalidateGroup or the canvas size changes.
+//
+// The canvas passed to fn is a separate canvas with its own draw state,
+// images and fonts, and it is kept for the next time the group is drawn.
+// The cached image is drawn with the current transformation, global
+// alpha, shadow and clipping region
+func (cv *Canvas) CacheGroup(id string, fn func(cv *Canvas)) {
+	if cv.cacheGroups == nil {
+		cv.cacheGroups = make(map[string]*cacheGroup)
+	}
+	w, h := cv.Size()
+	group, ok := cv.cacheGroups[id]
+	if !ok {
+		group = &cacheGroup{}
+		cv.cacheGroups[id] = group
+	}
+	if group.backend == nil || group.backend.Image.Rect.Dx() != w || group.backend.Image.Rect.Dy() != h {
+		group.backend = NewBackend(w, h)
+		group.cv = New(group.backend)
+		group.valid = false
+	}
+	if group.valid {
+		cv.stats.cur.GroupHits++
+	} else {
+		cv.stats.cur.GroupMisses++
+		// the canvas of the group keeps its images between redraws
+		group.cv.reset()
+		fn(group.cv)
+		group.cv.Flush()
+		if group.img == nil || group.img.deleted || group.img.src != group.backend.Image {
+			group.img.Delete()
+			group.img = cv.getImage(group.backend.Image)
+		} else {
+			group.img.Replace(group.backend.Image)
+		}
+		group.valid = true
+	}
+	if group.img != nil {
+		cv.DrawImage(group.img, 0, 0)
+	}
+}
+
+// InvalidateGroup makes the next CacheGroup call with the given id draw
+// the group again
+func (cv *Canvas) InvalidateGroup(id string) {
+	if group, ok := cv.cacheGroups[id]; ok {
+		group.valid = false
+	}
+}
+
+// DeleteGroup removes the cached image of the group with the given id
+func (cv *Canvas) DeleteGroup(id string) {
+	if group, ok := cv.cacheGroups[id]; ok {
+		group.img.Delete()
+		delete(cv.cacheGroups, id)
+	}
+}
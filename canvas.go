@@ -1,5 +1,11 @@
 // Package canvas provides an API that tries to closely mirror that
 // of the HTML5 canvas API, using OpenGL to do the rendering.
+//
+// A Canvas and its backend must only be used by one goroutine at a
+// time. Different canvases can be used concurrently, and fonts, images
+// and gradients must only be shared between canvases of the same
+// backend. To draw on one canvas from several goroutines, use
+// NewConcurrent.
 package canvas
 
 import (
@@ -7,10 +13,6 @@ import (
 	"image/color"
 	"math"
 	"time"
-
-	"github.com/golang/freetype/truetype"
-	"golang.org/x/image/font"
-	"golang.org/x/image/math/fixed"
 )
 
 //go:generate go run make_shaders.go
@@ -19,35 +21,64 @@ import (
 // Canvas represents an area on the viewport on which to draw
 // using a set of functions very similar to the HTML5 canvas
 type Canvas struct {
-	b Backend
+	b    Backend
+	caps BackendCapabilities
 
 	path Path2D
+	// polygons holds the shapes of FillPolygon and StrokePolyline
+	polygons Path2D
 
 	state      drawState
 	stateStack []drawState
 
 	images        map[interface{}]*Image
-	fonts         map[interface{}]*Font
-	fontCtxs      map[fontKey]*frCache
-	fontPathCache map[*Font]*fontPathCache
-	fontTriCache  map[*Font]*fontTriCache
+	imageCache    imageCache
+	imagePatterns map[interface{}]*ImagePattern
+	text          textCache
+	// sharedImages are the backend images of loaded images by their
+	// pixels
+	sharedImages map[imageContentKey]*imageShare
 
 	shadowBuf []BackendVec
+	spriteBuf []BackendSprite
+	// instanceBuf holds the transformations of DrawInstances
+	instanceBuf []BackendMat
+	// markers are the images of DrawMarkers
+	markers map[markerKey]*image.RGBA
+
+	hitRegions  hitRegions
+	cacheGroups map[string]*cacheGroup
+
+	batch fillBatch
+	stats frameStats
+
+	quality Quality
+	view    viewTransform
+	unit    Unit
+	dpi     float64
+
+	err           error
+	errHandler    func(err error)
+	errHandlerSet bool
+
+	context contextLoss
+	frame   frameState
+	// renderBounds is the rectangle of SetRenderBounds
+	renderBounds image.Rectangle
 }
 
 type drawState struct {
 	transform     BackendMat
 	fill          drawStyle
 	stroke        drawStyle
-	font          *Font
-	fontSize      fixed.Int26_6
-	fontMetrics   font.Metrics
+	text          textState
 	textAlign     textAlign
 	textBaseline  textBaseline
 	lineAlpha     float64
 	lineWidth     float64
 	lineJoin      lineJoin
 	lineCap       lineCap
+	strokeAlign   strokeAlign
 	miterLimitSqr float64
 	globalAlpha   float64
 
@@ -61,6 +92,8 @@ type drawState struct {
 	shadowOffsetX float64
 	shadowOffsetY float64
 	shadowBlur    float64
+	shadowSpread  float64
+	shadowInset   bool
 
 	/*
 		The current transformation matrix.
@@ -82,6 +115,7 @@ type drawStyle struct {
 
 type lineJoin uint8
 type lineCap uint8
+type strokeAlign uint8
 
 // Line join and end constants for SetLineJoin and SetLineCap
 const (
@@ -103,6 +137,12 @@ const (
 	End
 )
 
+// Stroke alignment constants for SetStrokeAlignment, along with Center
+const (
+	Inner = End + 1 + iota
+	Outer
+)
+
 type textBaseline uint8
 
 // Text baseline constants for SetTextBaseline
@@ -142,20 +182,84 @@ func New(backend Backend) *Canvas {
 		b:             backend,
 		stateStack:    make([]drawState, 0, 20),
 		images:        make(map[interface{}]*Image),
-		fonts:         make(map[interface{}]*Font),
-		fontCtxs:      make(map[fontKey]*frCache),
-		fontPathCache: make(map[*Font]*fontPathCache),
-		fontTriCache:  make(map[*Font]*fontTriCache),
+		imagePatterns: make(map[interface{}]*ImagePattern),
+		text:          newTextCache(),
+	}
+	cv.caps = backend.Capabilities()
+	cv.resetState()
+	cv.path.cv = cv
+	if cl, ok := backend.(ContextLossBackend); ok {
+		cl.NotifyContextLoss(cv.ContextLost, func() { cv.ContextRestored() })
 	}
+	return cv
+}
+
+func (cv *Canvas) resetState() {
+	cv.state = drawState{}
 	cv.state.lineWidth = 1
 	cv.state.lineAlpha = 1
+	cv.state.strokeAlign = Center
 	cv.state.miterLimitSqr = 100
 	cv.state.globalAlpha = 1
 	cv.state.fill.color = color.RGBA{A: 255}
 	cv.state.stroke.color = color.RGBA{A: 255}
-	cv.state.transform = BackendMatIdentity
-	cv.path.cv = cv
-	return cv
+	cv.state.transform = cv.unitTransform()
+}
+
+// Reset clears the canvas to transparent black and resets the draw state,
+// the state stack, the clipping region, the render bounds, the view
+// transform, the units, the quality, the current path, the hit regions
+// and the error to the state of a newly created canvas. Fills that are
+// pending in a batch are discarded. Loaded images, the patterns of image
+// fill styles and cache groups are deleted, so that canvases that are
+// reused, for example from a Pool, don't grow. Images returned by
+// LoadImage before can't be used anymore. Loaded fonts are kept
+func (cv *Canvas) Reset() {
+	cv.reset()
+	cv.releaseCaches()
+}
+
+// reset is Reset without deleting the caches
+func (cv *Canvas) reset() {
+	cv.batch = fillBatch{}
+	cv.err = nil
+	cv.view = viewTransform{}
+	cv.unit, cv.dpi = Pixels, 0
+	if cv.quality != QualityDefault {
+		cv.SetQuality(QualityDefault)
+	}
+	cv.renderBounds = image.Rectangle{}
+	if sb, ok := cv.b.(ScissorBackend); ok {
+		sb.SetScissor(image.Rectangle{})
+	}
+	cv.resetState()
+	cv.stateStack = cv.stateStack[:0]
+	cv.BeginPath()
+	cv.ClearHitRegions()
+	cv.clearClip()
+
+	w, h := cv.b.Size()
+	fw, fh := float64(w), float64(h)
+	cv.countQuad([4]BackendVec{{0, 0}, {0, fh}, {fw, fh}, {fw, 0}})
+	cv.b.Clear([4]BackendVec{{0, 0}, {0, fh}, {fw, fh}, {fw, 0}})
+}
+
+// releaseCaches deletes the cached images, the patterns of image fill
+// styles, the cache groups and the marker images
+func (cv *Canvas) releaseCaches() {
+	for _, ip := range cv.imagePatterns {
+		if ip.ip != nil {
+			ip.ip.Delete()
+		}
+	}
+	cv.imagePatterns = make(map[interface{}]*ImagePattern)
+	for id := range cv.cacheGroups {
+		cv.DeleteGroup(id)
+	}
+	cv.markers = nil
+	for _, img := range cv.images {
+		img.Delete()
+	}
 }
 
 // Width returns the internal width of the canvas
@@ -174,6 +278,9 @@ func (cv *Canvas) Height() int {
 func (cv *Canvas) Size() (int, int) { return cv.b.Size() }
 
 func (cv *Canvas) tf(v BackendVec) BackendVec {
+	if cv.view.active {
+		return v.MulMat(cv.state.transform).Sub(cv.view.origin).MulMat(cv.view.mat)
+	}
 	return v.MulMat(cv.state.transform)
 }
 
@@ -204,8 +311,6 @@ func (cv *Canvas) SetStrokeStyle(value ...interface{}) {
 	cv.state.stroke = cv.parseStyle(value...)
 }
 
-var imagePatterns = make(map[interface{}]*ImagePattern)
-
 func (cv *Canvas) parseStyle(value ...interface{}) drawStyle {
 	var style drawStyle
 	if len(value) == 1 {
@@ -229,10 +334,10 @@ func (cv *Canvas) parseStyle(value ...interface{}) drawStyle {
 	if len(value) == 1 {
 		switch v := value[0].(type) {
 		case *Image, image.Image, string:
-			if _, ok := imagePatterns[v]; !ok {
-				imagePatterns[v] = cv.CreatePattern(v, Repeat)
+			if _, ok := cv.imagePatterns[v]; !ok {
+				cv.imagePatterns[v] = cv.CreatePattern(v, Repeat)
 			}
-			style.imagePattern = imagePatterns[v]
+			style.imagePattern = cv.imagePatterns[v]
 		}
 	}
 	return style
@@ -250,6 +355,7 @@ func (cv *Canvas) backendFillStyle(s *drawStyle, alpha float64) BackendFillStyle
 		stl.Gradient.Y0 = from[1]
 		stl.Gradient.X1 = to[0]
 		stl.Gradient.Y1 = to[1]
+		stl.Dither = BackendDither(lg.dither)
 	} else if rg := s.radialGradient; rg != nil {
 		rg.load()
 		from := cv.tf(rg.from)
@@ -261,11 +367,20 @@ func (cv *Canvas) backendFillStyle(s *drawStyle, alpha float64) BackendFillStyle
 		stl.Gradient.RadFrom = rg.radFrom
 		stl.Gradient.RadTo = rg.radTo
 		stl.RadialGradient = rg.grad
+		stl.Dither = BackendDither(rg.dither)
 	} else if ip := s.imagePattern; ip != nil {
+		if ip.live != nil {
+			// batched fills may still use the previous content
+			cv.Flush()
+			ip.refresh()
+		}
+		ip.restore()
 		if ip.ip == nil {
 			stl.Color = color.RGBA{}
 		} else {
-			ip.ip.Replace(ip.data(cv.state.transform))
+			// batched fills may still use the previous pattern data
+			cv.Flush()
+			ip.ip.Replace(ip.data(cv.transform()))
 			stl.ImagePattern = ip.ip
 		}
 	} else {
@@ -277,33 +392,23 @@ func (cv *Canvas) backendFillStyle(s *drawStyle, alpha float64) BackendFillStyle
 
 // SetLineWidth sets the line width for any line drawing calls
 func (cv *Canvas) SetLineWidth(width float64) {
+	// lines thinner than a pixel are drawn a pixel wide and transparent
+	pixel := 1.0
+	if cv.unit != Pixels {
+		pixel = cv.FromPixels(1)
+	}
 	if width < 0 {
-		cv.state.lineWidth = 1
+		cv.state.lineWidth = pixel
 		cv.state.lineAlpha = 0
-	} else if width < 1 {
-		cv.state.lineWidth = 1
-		cv.state.lineAlpha = width
+	} else if width < pixel {
+		cv.state.lineWidth = pixel
+		cv.state.lineAlpha = width / pixel
 	} else {
 		cv.state.lineWidth = width
 		cv.state.lineAlpha = 1
 	}
 }
 
-// SetFont sets the font and font size. The font parameter can be a font loaded
-// with the LoadFont function, a filename for a font to load (which will be
-// cached), or nil, in which case the first loaded font will be used
-func (cv *Canvas) SetFont(src interface{}, size float64) {
-	cv.state.fontSize = fixed.Int26_6(math.Round(size * 64))
-	if src == nil {
-		cv.state.font = defaultFont
-	} else {
-		cv.state.font = cv.getFont(src)
-	}
-
-	fontFace := truetype.NewFace(cv.state.font.font, &truetype.Options{Size: size})
-	cv.state.fontMetrics = fontFace.Metrics()
-}
-
 // SetTextAlign sets the text align for any text drawing calls.
 // The value can be Left, Center, Right, Start, or End
 func (cv *Canvas) SetTextAlign(align textAlign) {
@@ -329,8 +434,22 @@ func (cv *Canvas) SetLineCap(cap lineCap) {
 	cv.state.lineCap = cap
 }
 
-// SetLineDash sets the line dash style
+// SetStrokeAlignment sets where the line is drawn for rendering a path
+// with Stroke. The value can be Center (default) to straddle the path,
+// Inner to stay inside of it, or Outer to stay outside of it. Inner
+// and Outer apply to paths where all subpaths are closed, other paths
+// are stroked centered
+func (cv *Canvas) SetStrokeAlignment(align strokeAlign) {
+	cv.state.strokeAlign = align
+}
+
+// SetLineDash sets the line dash style. Like in the HTML5 canvas, dashes
+// with negative or non-finite values are ignored. Dashes that are all
+// zero are ignored as well, since they never advance along the line
 func (cv *Canvas) SetLineDash(dash []float64) {
+	if !validLineDash(dash) {
+		return
+	}
 	l := len(dash)
 	if l%2 == 0 {
 		d2 := make([]float64, l)
@@ -346,6 +465,18 @@ func (cv *Canvas) SetLineDash(dash []float64) {
 	cv.state.lineDashOffset = 0
 }
 
+// validLineDash returns whether the dash can be used for stroking
+func validLineDash(dash []float64) bool {
+	var sum float64
+	for _, d := range dash {
+		if d < 0 || math.IsNaN(d) || math.IsInf(d, 0) {
+			return false
+		}
+		sum += d
+	}
+	return len(dash) == 0 || (sum > 0 && !math.IsInf(sum, 0))
+}
+
 // SetLineDashOffset sets the line dash offset
 func (cv *Canvas) SetLineDashOffset(offset float64) {
 	cv.state.lineDashOffset = offset
@@ -380,7 +511,8 @@ func (cv *Canvas) Restore() {
 	if l <= 0 {
 		return
 	}
-	cv.b.ClearClip()
+	cv.Flush()
+	cv.clearClip()
 	for _, st := range cv.stateStack {
 		if len(st.clip.p) > 0 {
 			cv.clip(&st.clip, BackendMatIdentity)
@@ -410,9 +542,13 @@ func (cv *Canvas) Transform(a, b, c, d, e, f float64) {
 	cv.state.transform = BackendMat{a, b, c, d, e, f}.Mul(cv.state.transform)
 }
 
-// SetTransform replaces the current transformation with the given matrix
+// SetTransform replaces the current transformation with the given matrix,
+// applied on top of the scaling of the units set with SetUnits
 func (cv *Canvas) SetTransform(a, b, c, d, e, f float64) {
 	cv.state.transform = BackendMat{a, b, c, d, e, f}
+	if cv.unit != Pixels {
+		cv.state.transform = cv.state.transform.Mul(cv.unitTransform())
+	}
 }
 
 // SetShadowColor sets the color of the shadow. If it is fully transparent (default)
@@ -445,6 +581,20 @@ func (cv *Canvas) SetShadowBlur(r float64) {
 	cv.state.shadowBlur = r
 }
 
+// SetShadowSpread sets the distance by which the shape is grown
+// before the shadow is cast (negative values shrink it), like the
+// spread radius of the CSS box-shadow property
+func (cv *Canvas) SetShadowSpread(spread float64) {
+	cv.state.shadowSpread = spread
+}
+
+// SetShadowInset sets whether the shadow is cast inside the shape
+// instead of behind it, like the inset keyword of the CSS box-shadow
+// property
+func (cv *Canvas) SetShadowInset(inset bool) {
+	cv.state.shadowInset = inset
+}
+
 // IsPointInPath returns true if the point is in the current
 // path according to the given rule
 func (cv *Canvas) IsPointInPath(x, y float64, rule pathRule) bool {
@@ -459,7 +609,7 @@ func (cv *Canvas) IsPointInStroke(x, y float64) bool {
 	}
 
 	var triBuf [500]BackendVec
-	tris := cv.strokeTris(&cv.path, cv.state.transform, cv.state.transform.Invert(), true, triBuf[:0])
+	tris := cv.strokeTris(&cv.path, cv.transform(), cv.transform().Invert(), true, triBuf[:0])
 
 	pt := BackendVec{x, y}
 
@@ -479,61 +629,29 @@ func (cv *Canvas) reduceCache(keepSize, rec int) {
 		return
 	}
 
-	var total int
+	total, oldestText, hasText := cv.text.usage()
 	oldest := time.Now()
-	var oldestFontKey fontKey
-	var oldestFontKey2 *Font
-	var oldestFontKey3 *Font
 	var oldestImageKey interface{}
 	for src, img := range cv.images {
-		w, h := img.img.Size()
-		total += w * h * 4
+		if img.evicted {
+			continue
+		}
+		total += img.memSize()
 		if img.lastUsed.Before(oldest) {
 			oldest = img.lastUsed
 			oldestImageKey = src
 		}
 	}
-	for key, frctx := range cv.fontCtxs {
-		total += frctx.ctx.cacheSize()
-		if frctx.lastUsed.Before(oldest) {
-			oldest = frctx.lastUsed
-			oldestFontKey = key
-			oldestImageKey = nil
-		}
-	}
-	for fnt, cache := range cv.fontPathCache {
-		total += cache.size()
-		if cache.lastUsed.Before(oldest) {
-			oldest = cache.lastUsed
-			oldestFontKey2 = fnt
-			oldestFontKey = fontKey{}
-			oldestImageKey = nil
-		}
-	}
-	for fnt, cache := range cv.fontTriCache {
-		total += cache.size()
-		if cache.lastUsed.Before(oldest) {
-			oldest = cache.lastUsed
-			oldestFontKey3 = fnt
-			oldestFontKey2 = nil
-			oldestFontKey = fontKey{}
-			oldestImageKey = nil
-		}
-	}
 	if total <= keepSize {
 		return
 	}
 
-	if oldestImageKey != nil {
-		cv.images[oldestImageKey].Delete()
-		delete(cv.images, oldestImageKey)
-	} else if oldestFontKey2 != nil {
-		delete(cv.fontPathCache, oldestFontKey2)
-	} else if oldestFontKey3 != nil {
-		delete(cv.fontTriCache, oldestFontKey3)
+	if oldestImageKey != nil && (!hasText || oldest.Before(oldestText)) {
+		cv.dropImage(oldestImageKey, cv.images[oldestImageKey])
+	} else if hasText {
+		cv.text.dropOldest()
 	} else {
-		cv.fontCtxs[oldestFontKey].ctx = nil
-		delete(cv.fontCtxs, oldestFontKey)
+		return
 	}
 
 	cv.reduceCache(keepSize, rec+1)
@@ -1,21 +1,43 @@
 package canvas_test
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"image"
-	_ "image/jpeg"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/jpeg"
 	"image/png"
+	"io/ioutil"
 	"math"
 	"os"
+	"reflect"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/opentoys/canvas"
 )
 
 var usesw = false
 
+// raceEnabled is set when testing with the race detector
+var raceEnabled = false
+
+// textDisabled, imageFormatsDisabled and colorNamesDisabled are set when
+// testing with the build tags that leave out these parts
+var (
+	textDisabled         = false
+	imageFormatsDisabled = false
+	colorNamesDisabled   = false
+)
+
 func run(t *testing.T, fn func(cv *canvas.Canvas)) {
 	var img *image.RGBA
 	backend := canvas.NewBackend(100, 100)
@@ -293,6 +315,23 @@ func TestLineDashOffset(t *testing.T) {
 	})
 }
 
+func TestLineDashInvalid(t *testing.T) {
+	cv := canvas.New(canvas.NewBackend(100, 100))
+	cv.SetLineDash([]float64{4, 6})
+	for _, dash := range [][]float64{{0, 0}, {0}, {-1, 5}, {math.NaN(), 2}, {math.Inf(1), 2}, {math.MaxFloat64, math.MaxFloat64}} {
+		cv.SetLineDash(dash)
+		if ld := cv.GetLineDash(); len(ld) != 2 || ld[0] != 4 || ld[1] != 6 {
+			t.Fatalf("Expected %v to be ignored, got %v", dash, ld)
+		}
+	}
+	cv.SetLineDash([]float64{0, 0})
+	cv.StrokeRect(10, 10, 50, 50)
+	cv.SetLineDash(nil)
+	if ld := cv.GetLineDash(); len(ld) != 0 {
+		t.Fatalf("Expected no dash, got %v", ld)
+	}
+}
+
 func TestCurves(t *testing.T) {
 	run(t, func(cv *canvas.Canvas) {
 		cv.SetStrokeStyle("#00F")
@@ -635,3 +674,4076 @@ func TestReadme(t *testing.T) {
 		cv.Stroke()
 	})
 }
+
+func TestMesh(t *testing.T) {
+	run(t, func(cv *canvas.Canvas) {
+		cv.DrawMesh([]canvas.BackendVec{
+			{10, 10}, {90, 10}, {10, 90},
+			{90, 10}, {90, 90}, {10, 90},
+		}, "#F00", "#0F0", "#00F", "#0F0", "#FFF", "#00F")
+	})
+}
+
+func TestNewBackendChecked(t *testing.T) {
+	if _, err := canvas.NewBackendChecked(100, 100); err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+	for _, size := range [][2]int{{-1, 10}, {10, -1}, {100000, 10}, {16000, 16000}} {
+		if _, err := canvas.NewBackendChecked(size[0], size[1]); err == nil {
+			t.Fatalf("Expected an error for size %dx%d", size[0], size[1])
+		}
+	}
+
+	// the MSAA, blur and Present buffers count too, not only the image
+	old := canvas.SizeLimits
+	defer func() { canvas.SizeLimits = old }()
+	canvas.SizeLimits.MaxBytes = 100 * 100 * 8
+	if _, err := canvas.NewBackendChecked(100, 100); err == nil {
+		t.Fatal("Expected an error when only the image fits into the limit")
+	}
+	canvas.SizeLimits = old
+
+	backend := canvas.NewBackend(10, 10)
+	if err := backend.SetSizeChecked(1<<20, 1<<20); err == nil {
+		t.Fatal("Expected an error for a huge size")
+	}
+	if w, h := backend.Size(); w != 10 || h != 10 {
+		t.Fatalf("Backend size changed to %dx%d after a failed resize", w, h)
+	}
+}
+
+func TestBackendForBuffer(t *testing.T) {
+	draw := func(b canvas.Backend) {
+		cv := canvas.New(b)
+		cv.SetFillStyle("#0F08")
+		cv.FillRect(2, 2, 20, 10)
+		cv.SetShadowColor("#000")
+		cv.SetShadowBlur(3)
+		cv.BeginPath()
+		cv.Arc(20, 15, 8, 0, math.Pi*2, false)
+		cv.Clip()
+		cv.SetFillStyle("#F00")
+		cv.FillRect(10, 5, 30, 20)
+		cv.Flush()
+	}
+	const w, h, stride = 40, 30, 40*4 + 12
+	expected := canvas.NewBackend(w, h)
+	draw(expected)
+
+	pix := make([]byte, stride*h)
+	for i := range pix {
+		pix[i] = 0xAB
+	}
+	for y := 0; y < h; y++ {
+		for i := 0; i < w*4; i++ {
+			pix[y*stride+i] = 0
+		}
+	}
+	b, err := canvas.NewBackendForBuffer(pix, w, h, stride)
+	if err != nil {
+		t.Fatal(err)
+	}
+	draw(b)
+	for y := 0; y < h; y++ {
+		if !bytes.Equal(pix[y*stride:y*stride+w*4], expected.Image.Pix[y*w*4:(y+1)*w*4]) {
+			t.Fatalf("Row %d differs from drawing into a new image", y)
+		}
+		for _, v := range pix[y*stride+w*4 : (y+1)*stride] {
+			if v != 0xAB {
+				t.Fatalf("The padding of row %d was written to", y)
+			}
+		}
+	}
+
+	outer := image.NewRGBA(image.Rect(-5, -5, 60, 60))
+	b, err = canvas.NewBackendForImage(outer.SubImage(image.Rect(10, 10, 10+w, 10+h)).(*image.RGBA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	draw(b)
+	if !bytes.Equal(b.Image.Pix[:w*4], expected.Image.Pix[:w*4]) || outer.RGBAAt(25, 20) != expected.Image.RGBAAt(15, 10) {
+		t.Fatal("Drawing into a sub-image differs from drawing into a new image")
+	}
+
+	if _, err := canvas.NewBackendForBuffer(make([]byte, 100), 10, 10, 40); err == nil {
+		t.Fatal("Expected an error for a short buffer")
+	}
+	if _, err := canvas.NewBackendForBuffer(make([]byte, 400), 10, 10, 20); err == nil {
+		t.Fatal("Expected an error for a short stride")
+	}
+}
+
+func TestShadowSpread(t *testing.T) {
+	run(t, func(cv *canvas.Canvas) {
+		cv.SetFillStyle("#800")
+		cv.SetShadowColor("#00F")
+		cv.SetShadowOffset(4, 4)
+		cv.SetShadowSpread(4)
+		cv.FillRect(10, 10, 30, 30)
+		cv.SetShadowBlur(3)
+		cv.FillRect(60, 10, 30, 30)
+		cv.SetShadowInset(true)
+		cv.SetShadowColor("#FFF")
+		cv.FillRect(10, 60, 30, 30)
+		cv.SetShadowSpread(0)
+		cv.BeginPath()
+		cv.Arc(75, 75, 15, 0, math.Pi*2, false)
+		cv.Fill()
+	})
+}
+
+func TestPool(t *testing.T) {
+	pool := canvas.NewPool(10, 10)
+	cv, backend := pool.Get()
+	if w, h := backend.Size(); w != 10 || h != 10 {
+		t.Fatalf("Expected a 10x10 canvas, got %dx%d", w, h)
+	}
+	cv.SetFillStyle("#F00")
+	cv.Translate(2, 2)
+	cv.FillRect(0, 0, 10, 10)
+	pool.Put(cv)
+
+	cv, backend = pool.Get()
+	if c := backend.Image.RGBAAt(5, 5); c.A != 0 {
+		t.Fatalf("Expected a cleared canvas, got %v", c)
+	}
+	cv.SetFillStyle("#0F0")
+	cv.FillRect(0, 0, 1, 1)
+	if c := backend.Image.RGBAAt(0, 0); c.G != 255 {
+		t.Fatalf("Expected the transform to be reset, got %v at 0,0", c)
+	}
+}
+
+func TestPoolReset(t *testing.T) {
+	pool := canvas.NewPool(50, 50)
+	cv, backend := pool.Get()
+	cv.SetFillStyle("#F00")
+	cv.FillRect(0, 0, 50, 50)
+	cv.SetRenderBounds(image.Rect(0, 0, 10, 10))
+	cv.SetViewTransform(0, 0, 2, 0, 0, 0)
+	cv.SetUnits(canvas.Millimeters, 96)
+	cv.SetQuality(canvas.QualityHigh)
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	cv.DrawImage(img, 0, 0)
+	cv.SetFillStyle(image.NewRGBA(image.Rect(0, 0, 2, 2)))
+	cv.FillRect(0, 0, 4, 4)
+	groupDraws := 0
+	cv.CacheGroup("group", func(cv *canvas.Canvas) { groupDraws++ })
+	pool.Put(cv)
+
+	cv, backend = pool.Get()
+	if c := backend.Image.RGBAAt(30, 30); c.A != 0 {
+		t.Errorf("Expected the canvas to be cleared outside of the old render bounds, got %v", c)
+	}
+	if r := cv.RenderBounds(); !r.Empty() {
+		t.Errorf("Expected no render bounds, got %v", r)
+	}
+	if x, y := cv.WorldToDevice(5, 5); x != 5 || y != 5 {
+		t.Errorf("Expected no view transform, got 5/5 at %g/%g", x, y)
+	}
+	if unit, dpi := cv.Units(); unit != canvas.Pixels || dpi != canvas.DefaultDPI {
+		t.Errorf("Expected pixels at the default resolution, got %v at %g dpi", unit, dpi)
+	}
+	if q := cv.Quality(); q != canvas.QualityDefault {
+		t.Errorf("Expected the default quality, got %v", q)
+	}
+	if backend.MSAA != 0 {
+		t.Errorf("Expected MSAA to be off, got %d", backend.MSAA)
+	}
+	if n := cv.ImageCacheStats().Images; n != 0 {
+		t.Errorf("Expected the cached images to be released, got %d", n)
+	}
+	cv.CacheGroup("group", func(cv *canvas.Canvas) { groupDraws++ })
+	if groupDraws != 2 {
+		t.Errorf("Expected the cache group to be drawn again, got %d draws", groupDraws)
+	}
+	cv.SetFillStyle("#0F0")
+	cv.FillRect(0, 0, 20, 20)
+	if c := backend.Image.RGBAAt(15, 15); c != (color.RGBA{0, 255, 0, 255}) {
+		t.Errorf("Expected the fill at 15/15, got %v", c)
+	}
+}
+
+func TestShadowImageText(t *testing.T) {
+	if textDisabled {
+		t.Skip("Text is left out with the canvas_notext tag")
+	}
+	run(t, func(cv *canvas.Canvas) {
+		img := image.NewRGBA(image.Rect(0, 0, 30, 30))
+		for y := 0; y < 30; y++ {
+			for x := 0; x < 30; x++ {
+				if (x-15)*(x-15)+(y-15)*(y-15) < 15*15 {
+					img.Pix[img.PixOffset(x, y)+1] = 255
+					img.Pix[img.PixOffset(x, y)+3] = 255
+				}
+			}
+		}
+		cv.SetShadowColor("#00F")
+		cv.SetShadowOffset(5, 5)
+		cv.DrawImage(img, 10, 10)
+		cv.SetShadowBlur(2)
+		cv.DrawImage(img, 55, 10)
+		cv.SetFont("testdata/Roboto-Light.ttf", 20)
+		cv.SetFillStyle("#F00")
+		cv.FillText("Shadow", 5, 70)
+		cv.SetShadowBlur(0)
+		cv.FillText("Text", 5, 92)
+	})
+}
+
+func TestResize(t *testing.T) {
+	backend := canvas.NewBackend(10, 10)
+	cv := canvas.New(backend)
+	cv.SetFillStyle("#F00")
+	cv.FillRect(0, 0, 2, 2)
+	cv.Rect(0, 0, 5, 5)
+	cv.Clip()
+
+	err := cv.Resize(20, 20, canvas.ResizeCenter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := backend.Image.RGBAAt(5, 5); c.R != 255 {
+		t.Fatalf("Expected the content to be centered, got %v at 5,5", c)
+	}
+
+	cv.SetFillStyle("#0F0")
+	cv.FillRect(0, 0, 10, 10)
+	if c := backend.Image.RGBAAt(9, 9); c.G != 255 {
+		t.Fatalf("Expected the transformation to be remapped, got %v at 9,9", c)
+	}
+	if c := backend.Image.RGBAAt(11, 11); c.A != 0 {
+		t.Fatalf("Expected the clip region to be remapped, got %v at 11,11", c)
+	}
+
+	cv.Resize(40, 40, canvas.ResizeStretch)
+	if c := backend.Image.RGBAAt(15, 15); c.G != 255 {
+		t.Fatalf("Expected the content to be scaled, got %v at 15,15", c)
+	}
+
+	// the color of transparent pixels must not show at the edges
+	backend = canvas.NewBackend(2, 1)
+	cv = canvas.New(backend)
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(0, 0, color.RGBA{0, 0, 255, 255})
+	img.SetRGBA(1, 0, color.RGBA{255, 0, 0, 0})
+	cv.PutImageData(img, 0, 0)
+	cv.Resize(8, 1, canvas.ResizeStretch)
+	for x := 0; x < 8; x++ {
+		if c := backend.Image.RGBAAt(x, 0); c.A != 0 && (c.R != 0 || c.B != 255) {
+			t.Fatalf("Expected pure blue or transparent pixels, got %v at %d", c, x)
+		}
+	}
+}
+
+func TestNoiseDeterministic(t *testing.T) {
+	noise := canvas.Noise{Seed: 42, BaseFrequency: 0.05, Octaves: 3}
+	img1 := noise.Image(64, 64)
+	img2 := noise.Image(64, 64)
+	var sum uint32
+	for i, v := range img1.Pix {
+		if img2.Pix[i] != v {
+			t.Fatalf("Noise output differs at byte %d", i)
+		}
+		sum = sum*31 + uint32(v)
+	}
+	// the checksum must be the same on every platform
+	if sum != 3217770484 {
+		t.Fatalf("Unexpected noise checksum %d", sum)
+	}
+
+	noise.Seed = 43
+	img3 := noise.Image(64, 64)
+	if string(img3.Pix) == string(img1.Pix) {
+		t.Fatal("Expected a different seed to produce different noise")
+	}
+}
+
+func TestHitRegions(t *testing.T) {
+	cv := canvas.New(canvas.NewBackend(200, 200))
+	for i := 0; i < 12; i++ {
+		a := float64(i) * math.Pi / 6
+		cv.BeginPath()
+		cv.MoveTo(100, 100)
+		cv.Arc(100, 100, 80, a, a+math.Pi/6, false)
+		cv.ClosePath()
+		cv.AddHitRegion(fmt.Sprintf("segment%d", i), nil)
+	}
+	path := cv.NewPath2D()
+	path.Rect(0, 0, 20, 20)
+	cv.Translate(90, 90)
+	cv.AddHitRegion("center", path)
+
+	if ids := cv.HitTest(150, 110); len(ids) != 1 || ids[0] != "segment0" {
+		t.Fatalf("Expected segment0, got %v", ids)
+	}
+	if ids := cv.HitTest(108, 102); len(ids) != 2 || ids[0] != "center" || ids[1] != "segment0" {
+		t.Fatalf("Expected center and segment0, got %v", ids)
+	}
+	if ids := cv.HitTest(5, 5); len(ids) != 0 {
+		t.Fatalf("Expected no hits, got %v", ids)
+	}
+	cv.RemoveHitRegion("center")
+	if ids := cv.HitTest(108, 102); len(ids) != 1 || ids[0] != "segment0" {
+		t.Fatalf("Expected segment0 after removal, got %v", ids)
+	}
+}
+
+func TestReplayCulled(t *testing.T) {
+	target := canvas.NewBackend(100, 100)
+	rec := canvas.NewRecordingBackend(target)
+	cv := canvas.New(rec)
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			cv.SetFillStyle(x*25, y*25, 255)
+			cv.FillRect(float64(x*10), float64(y*10), 10, 10)
+		}
+	}
+	list := rec.DisplayList()
+	if len(list.Commands) != 100 {
+		t.Fatalf("Expected 100 commands, got %d", len(list.Commands))
+	}
+	if ids := list.Index().Query(canvas.Bounds{MinX: 12, MinY: 12, MaxX: 18, MaxY: 18}); len(ids) != 1 || ids[0] != 11 {
+		t.Fatalf("Expected command 11, got %v", ids)
+	}
+
+	list.ReplayCulled(target, canvas.Bounds{MinX: 0, MinY: 0, MaxX: 45, MaxY: 45})
+	if c := target.Image.RGBAAt(15, 15); c != (color.RGBA{R: 25, G: 25, B: 255, A: 255}) {
+		t.Fatalf("Expected the visible command to be replayed, got %v", c)
+	}
+	if c := target.Image.RGBAAt(75, 75); c.A != 0 {
+		t.Fatalf("Expected the command outside of the viewport to be culled, got %v", c)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	backend := canvas.NewBackend(100, 100)
+	cc := canvas.NewConcurrent(backend)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cc.Submit(func(cv *canvas.Canvas) {
+				cv.SetFillStyle("#F00")
+				cv.FillRect(float64(i*10), 0, 10, 50)
+			})
+			cc.Do(func(cv *canvas.Canvas) {
+				cv.SetFillStyle("#00F")
+				cv.FillRect(float64(i*10), 50, 10, 50)
+			})
+		}(i)
+	}
+	wg.Wait()
+	if n := cc.Pending(); n != 10 {
+		t.Fatalf("Expected 10 pending functions, got %d", n)
+	}
+	cc.Flush()
+	if n := cc.Pending(); n != 0 {
+		t.Fatalf("Expected no pending functions after Flush, got %d", n)
+	}
+
+	for x := 5; x < 100; x += 10 {
+		if c := backend.Image.RGBAAt(x, 25); c != (color.RGBA{R: 255, A: 255}) {
+			t.Fatalf("Expected red at %d,25, got %v", x, c)
+		}
+		if c := backend.Image.RGBAAt(x, 75); c != (color.RGBA{B: 255, A: 255}) {
+			t.Fatalf("Expected blue at %d,75, got %v", x, c)
+		}
+	}
+}
+
+func TestSeparateCanvasesConcurrently(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cv := canvas.New(canvas.NewBackend(100, 100))
+			cv.SetFont("testdata/Roboto-Light.ttf", 24)
+			cv.SetFillStyle("#F00")
+			cv.FillText("ABC", 0, 30)
+			cv.SetFont(nil, 24)
+			cv.FillText("DEF", 0, 60)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestBatch(t *testing.T) {
+	draw := func(cv *canvas.Canvas) {
+		cv.SetFillStyle("#F00")
+		for i := 0; i < 10; i++ {
+			cv.FillRect(float64(i*10), 0, 15, 15)
+		}
+		cv.SetFillStyle("#0F08")
+		for i := 0; i < 10; i++ {
+			cv.FillRect(float64(i*10), 30, 15, 15)
+		}
+		cv.SetStrokeStyle("#00F")
+		cv.StrokeRect(10, 60, 80, 30)
+		cv.FillRect(20, 70, 10, 10)
+	}
+
+	rec := canvas.NewRecordingBackend(canvas.NewBackend(100, 100))
+	cv := canvas.New(rec)
+	cv.BeginBatch()
+	draw(cv)
+	cv.EndBatch()
+	if n := len(rec.DisplayList().Commands); n != 13 {
+		t.Fatalf("Expected 13 backend calls, got %d", n)
+	}
+
+	batched := canvas.NewBackend(100, 100)
+	cv = canvas.New(batched)
+	cv.BeginBatch()
+	draw(cv)
+	cv.EndBatch()
+
+	unbatched := canvas.NewBackend(100, 100)
+	draw(canvas.New(unbatched))
+
+	for i := range batched.Image.Pix {
+		if batched.Image.Pix[i] != unbatched.Image.Pix[i] {
+			t.Fatalf("Batched result differs at byte %d: %d != %d", i, batched.Image.Pix[i], unbatched.Image.Pix[i])
+		}
+	}
+}
+
+func TestTileCache(t *testing.T) {
+	draw := func(cv *canvas.Canvas) {
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				cv.SetFillStyle(x*32, y*32, 128)
+				cv.BeginPath()
+				cv.Arc(float64(x*50+25), float64(y*50+25), 20, 0, math.Pi*2, false)
+				cv.Fill()
+			}
+		}
+	}
+
+	rec := canvas.NewRecordingBackend(canvas.NewBackend(400, 400))
+	draw(canvas.New(rec))
+	tc := canvas.NewTileCache(rec.DisplayList(), 64)
+
+	backend := canvas.NewBackend(100, 100)
+	cv := canvas.New(backend)
+	tc.Draw(cv, 0, 0, 1)
+	if n := tc.Rendered(); n != 4 {
+		t.Fatalf("Expected 4 rendered tiles, got %d", n)
+	}
+	tc.Draw(cv, 10, 0, 1)
+	if n := tc.Rendered(); n != 4 {
+		t.Fatalf("Expected cached tiles to be reused, got %d rendered tiles", n)
+	}
+	cv.ClearRect(0, 0, 100, 100)
+	tc.Draw(cv, 40, 20, 1)
+	if n := tc.Rendered(); n != 6 {
+		t.Fatalf("Expected 2 newly exposed tiles, got %d rendered tiles", n-4)
+	}
+
+	direct := canvas.NewBackend(100, 100)
+	dcv := canvas.New(direct)
+	dcv.Translate(-40, -20)
+	draw(dcv)
+	for i := range direct.Image.Pix {
+		if d := int(direct.Image.Pix[i]) - int(backend.Image.Pix[i]); d < -1 || d > 1 {
+			t.Fatalf("Tiled result differs at byte %d: %d != %d", i, backend.Image.Pix[i], direct.Image.Pix[i])
+		}
+	}
+
+	tc.Draw(cv, 0, 0, 2)
+	if n := tc.Rendered(); n != 10 {
+		t.Fatalf("Expected all tiles to be rendered again after zooming, got %d", n-6)
+	}
+}
+
+func TestCacheGroup(t *testing.T) {
+	calls := 0
+	group := func(cv *canvas.Canvas) {
+		calls++
+		cv.SetFillStyle("#F00")
+		cv.FillRect(10, 10, 30, 30)
+		cv.SetStrokeStyle("#00F")
+		cv.StrokeRect(50, 50, 40, 40)
+	}
+
+	backend := canvas.NewBackend(100, 100)
+	cv := canvas.New(backend)
+	for i := 0; i < 3; i++ {
+		cv.ClearRect(0, 0, 100, 100)
+		cv.CacheGroup("layer", group)
+	}
+	if calls != 1 {
+		t.Fatalf("Expected the group to be drawn once, got %d", calls)
+	}
+
+	direct := canvas.NewBackend(100, 100)
+	group(canvas.New(direct))
+	for i := range direct.Image.Pix {
+		if direct.Image.Pix[i] != backend.Image.Pix[i] {
+			t.Fatalf("Cached group differs at byte %d: %d != %d", i, backend.Image.Pix[i], direct.Image.Pix[i])
+		}
+	}
+
+	calls = 0
+	cv.InvalidateGroup("layer")
+	cv.CacheGroup("layer", group)
+	if calls != 1 {
+		t.Fatalf("Expected the group to be drawn again after invalidating, got %d calls", calls)
+	}
+}
+
+type noCapsBackend struct {
+	*canvas.SoftwareBackend
+}
+
+func (b noCapsBackend) Capabilities() canvas.BackendCapabilities {
+	return canvas.BackendCapabilities{}
+}
+
+func (b noCapsBackend) FillImageMask(style *canvas.BackendFillStyle, mask *image.Alpha, pts [4]canvas.BackendVec) {
+	panic("FillImageMask called on a backend without support")
+}
+
+func (b noCapsBackend) FillTrianglesVertexColor(pts []canvas.BackendVec, colors []color.RGBA) {
+	panic("FillTrianglesVertexColor called on a backend without support")
+}
+
+func TestCapabilitiesFallback(t *testing.T) {
+	backend := canvas.NewBackend(100, 100)
+	cv := canvas.New(noCapsBackend{backend})
+	if caps := cv.Capabilities(); caps.ImageMask || caps.VertexColors {
+		t.Fatalf("Expected no capabilities, got %+v", caps)
+	}
+
+	cv.DrawMesh([]canvas.BackendVec{{0, 0}, {50, 0}, {0, 50}}, "#F00", "#F00", "#00F")
+
+	cv.SetShadowColor("#000")
+	cv.SetShadowBlur(4)
+	cv.SetShadowOffset(10, 10)
+	cv.SetFillStyle("#0F0")
+	cv.FillRect(50, 50, 30, 30)
+
+	if c := backend.Image.RGBAAt(10, 10); c != (color.RGBA{R: 170, B: 85, A: 255}) {
+		t.Fatalf("Expected the average mesh color, got %v", c)
+	}
+	if c := backend.Image.RGBAAt(85, 85); c.A == 0 || c.G != 0 {
+		t.Fatalf("Expected the shadow to be drawn, got %v", c)
+	}
+
+	// the fallback draws partly covered pixels in the same color
+	mask := image.NewAlpha(image.Rect(0, 0, 10, 10))
+	for i := range mask.Pix {
+		mask.Pix[i] = 128
+	}
+	fillMask := func(b canvas.Backend) {
+		cv := canvas.New(b)
+		cv.SetFillStyle("#FF7F7F")
+		cv.FillMask(mask, 0, 0)
+	}
+	native, fallback := canvas.NewBackend(10, 10), canvas.NewBackend(10, 10)
+	fillMask(native)
+	fillMask(noCapsBackend{fallback})
+	want, got := native.Image.RGBAAt(5, 5), fallback.Image.RGBAAt(5, 5)
+	for i, d := range []int{int(got.R) - int(want.R), int(got.G) - int(want.G), int(got.B) - int(want.B), int(got.A) - int(want.A)} {
+		if d < -2 || d > 2 {
+			t.Fatalf("Expected the fallback mask to match %v, got %v (channel %d)", want, got, i)
+		}
+	}
+}
+
+func TestDiffDisplayLists(t *testing.T) {
+	frame := func(x float64) *canvas.DisplayList {
+		rec := canvas.NewRecordingBackend(canvas.NewBackend(200, 200))
+		cv := canvas.New(rec)
+		cv.SetFillStyle("#F00")
+		cv.FillRect(0, 0, 50, 50)
+		cv.SetFillStyle("#00F")
+		cv.FillRect(x, 100, 20, 20)
+		cv.SetFillStyle("#0F0")
+		cv.FillRect(150, 150, 40, 40)
+		return rec.DisplayList()
+	}
+
+	diff := canvas.DiffDisplayLists(frame(100), frame(100))
+	if len(diff.Added) != 0 || len(diff.Removed) != 0 || len(diff.Damage) != 0 {
+		t.Fatalf("Expected no difference between equal frames, got %+v", diff)
+	}
+
+	diff = canvas.DiffDisplayLists(frame(100), frame(110))
+	if len(diff.Added) != 1 || len(diff.Removed) != 1 {
+		t.Fatalf("Expected one added and one removed command, got %+v", diff)
+	}
+	if len(diff.Damage) != 1 || diff.Damage[0] != image.Rect(100, 100, 130, 120) {
+		t.Fatalf("Expected the old and new position to be merged into one damaged region, got %v", diff.Damage)
+	}
+
+	diff = canvas.DiffDisplayLists(frame(0), frame(100))
+	if len(diff.Damage) != 2 {
+		t.Fatalf("Expected two separate damaged regions, got %v", diff.Damage)
+	}
+}
+
+func TestWriteEXR(t *testing.T) {
+	backend := canvas.NewBackend(4, 3)
+	cv := canvas.New(backend)
+	cv.SetFillStyle("#FFF")
+	cv.FillRect(0, 0, 4, 3)
+
+	for _, pixelType := range []canvas.EXRPixelType{canvas.EXRHalf, canvas.EXRFloat} {
+		var buf bytes.Buffer
+		if err := backend.WriteEXR(&buf, pixelType); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		if !bytes.HasPrefix(data, []byte{0x76, 0x2f, 0x31, 0x01, 2, 0, 0, 0}) {
+			t.Fatal("Missing EXR magic number and version")
+		}
+
+		// the offset table points to the first scan line, which
+		// starts with the y coordinate and the size of the data
+		headerEnd := bytes.Index(data, []byte("screenWindowWidth\x00float\x00")) + 33
+		first := int(binary.LittleEndian.Uint64(data[headerEnd:]))
+		channelSize := int(pixelType) * 2
+		if size := int(binary.LittleEndian.Uint32(data[first+4:])); size != 4*4*channelSize {
+			t.Fatalf("Expected a scan line size of %d, got %d", 4*4*channelSize, size)
+		}
+		if len(data) != first+3*(8+4*4*channelSize) {
+			t.Fatalf("Unexpected EXR file size %d", len(data))
+		}
+
+		// white with full alpha is 1.0 in every channel
+		var one []byte
+		if pixelType == canvas.EXRHalf {
+			one = []byte{0x00, 0x3c}
+		} else {
+			one = []byte{0x00, 0x00, 0x80, 0x3f}
+		}
+		if px := data[first+8 : first+8+channelSize]; !bytes.Equal(px, one) {
+			t.Fatalf("Expected 1.0 in the first channel, got %v", px)
+		}
+	}
+}
+
+func TestNewWithBackend(t *testing.T) {
+	cv, err := canvas.NewWithBackend("software", canvas.BackendOptions{Width: 30, Height: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := cv.Size(); w != 30 || h != 20 {
+		t.Fatalf("Expected a 30x20 canvas, got %dx%d", w, h)
+	}
+
+	canvas.RegisterBackend("test-recording", func(opts canvas.BackendOptions) (canvas.Backend, error) {
+		return canvas.NewRecordingBackend(canvas.NewBackend(opts.Width, opts.Height)), nil
+	})
+	names := canvas.Backends()
+	if len(names) != 2 || names[0] != "software" || names[1] != "test-recording" {
+		t.Fatalf("Unexpected registered backends %v", names)
+	}
+	if _, err := canvas.NewWithBackend("test-recording", canvas.BackendOptions{Width: 10, Height: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := canvas.NewWithBackend("missing", canvas.BackendOptions{}); err == nil {
+		t.Fatal("Expected an error for an unknown backend")
+	}
+	if _, err := canvas.NewWithBackend("software", canvas.BackendOptions{Width: -1, Height: 10}); err == nil {
+		t.Fatal("Expected an error for an invalid size")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	cv := canvas.New(canvas.NewBackend(100, 100))
+	var reported []error
+	cv.SetErrorHandler(func(err error) { reported = append(reported, err) })
+
+	cv.DrawImage("testdata/missing.png", 0, 0)
+	if !textDisabled {
+		cv.SetFont("testdata/missing.ttf", 12)
+	}
+	img, err := cv.LoadImage(image.NewRGBA(image.Rect(0, 0, 10, 10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cv.DrawImage(img, 1, 2, 3)
+
+	other := canvas.New(canvas.NewBackend(10, 10))
+	if _, err := other.LoadImage(img); !errors.Is(err, canvas.ErrImageOtherCanvas) {
+		t.Fatalf("Expected ErrImageOtherCanvas, got %v", err)
+	}
+	img.Delete()
+	if err := img.Replace(image.NewRGBA(image.Rect(0, 0, 10, 10))); !errors.Is(err, canvas.ErrImageDeleted) {
+		t.Fatalf("Expected ErrImageDeleted, got %v", err)
+	}
+
+	want := 4
+	if textDisabled {
+		want = 2
+	} else if !errors.Is(reported[1], canvas.ErrNoFont) && !errors.Is(reported[2], canvas.ErrNoFont) {
+		t.Fatalf("Expected ErrNoFont to be reported, got %v", reported)
+	}
+	if len(reported) != want {
+		t.Fatalf("Expected %d reported errors, got %v", want, reported)
+	}
+	if !errors.Is(reported[want-1], canvas.ErrInvalidArguments) {
+		t.Fatalf("Expected ErrInvalidArguments, got %v", reported[want-1])
+	}
+	if cv.Err() != reported[0] {
+		t.Fatalf("Expected Err to return the first error, got %v", cv.Err())
+	}
+	cv.ClearErr()
+	if cv.Err() != nil {
+		t.Fatal("Expected no error after ClearErr")
+	}
+}
+
+func TestChannels(t *testing.T) {
+	backend := canvas.NewBackend(100, 100)
+	cv := canvas.New(backend)
+	cv.SetFillStyle("#F008")
+	cv.FillRect(10, 10, 30, 30)
+
+	alpha := cv.ExtractChannel(canvas.ChannelAlpha)
+	drawn := backend.Image.RGBAAt(20, 20).A
+	if a := alpha.AlphaAt(20, 20).A; a == 0 || a != drawn {
+		t.Fatalf("Expected alpha %#x, got %#x", drawn, a)
+	}
+	if a := alpha.AlphaAt(50, 50).A; a != 0 {
+		t.Fatalf("Expected alpha 0 outside of the rect, got %#x", a)
+	}
+
+	cv.ClearChannel(canvas.ChannelRed)
+	if c := backend.Image.RGBAAt(20, 20); c.R != 0 || c.A != drawn {
+		t.Fatalf("Expected only the red channel to be cleared, got %v", c)
+	}
+
+	cv.SetFillStyle("#00F")
+	cv.FillMask(alpha, 50, 50)
+	if c := backend.Image.RGBAAt(70, 70); c.B == 0 || c.R != 0 || c.A == 0 {
+		t.Fatalf("Expected the mask to be filled, got %v", c)
+	}
+	if c := backend.Image.RGBAAt(55, 55); c.A != 0 {
+		t.Fatalf("Expected nothing outside of the mask, got %v", c)
+	}
+
+	var reported []error
+	cv.SetErrorHandler(func(err error) { reported = append(reported, err) })
+	before := append([]byte(nil), backend.Image.Pix...)
+	cv.FillMask(nil, 0, 0)
+	if mask := cv.ExtractChannel(canvas.Channel(9)); mask != nil {
+		t.Fatal("Expected no mask for an unknown channel")
+	}
+	cv.ClearChannel(canvas.Channel(9))
+	if len(reported) != 2 || !errors.Is(reported[0], canvas.ErrInvalidChannel) || !errors.Is(reported[1], canvas.ErrInvalidChannel) {
+		t.Fatalf("Expected two ErrInvalidChannel errors, got %v", reported)
+	}
+	if !bytes.Equal(before, backend.Image.Pix) {
+		t.Fatal("Expected a nil mask and unknown channels to change nothing")
+	}
+}
+
+func TestCoverageAndClipMask(t *testing.T) {
+	backend := canvas.NewBackend(100, 100)
+	cv := canvas.New(backend)
+
+	drawn := image.NewAlpha(image.Rect(0, 0, 100, 100))
+	fills := 0
+	backend.SetCoverageCallback(func(coverage *image.Alpha) {
+		fills++
+		for i, a := range coverage.Pix {
+			if a > drawn.Pix[i] {
+				drawn.Pix[i] = a
+			}
+		}
+	})
+
+	cv.BeginPath()
+	cv.Rect(0, 0, 50, 100)
+	cv.Clip()
+	clip := backend.ClipMask()
+	if clip.AlphaAt(25, 50).A != 255 || clip.AlphaAt(75, 50).A != 0 {
+		t.Fatal("Expected the clip mask to contain the left half")
+	}
+
+	cv.FillRect(10, 10, 20, 20)
+	cv.FillRect(40, 40, 20, 20)
+	backend.SetCoverageCallback(nil)
+	cv.FillRect(80, 80, 10, 10)
+
+	if fills != 2 {
+		t.Fatalf("Expected 2 coverage callbacks, got %d", fills)
+	}
+	if drawn.AlphaAt(20, 20).A != 255 || drawn.AlphaAt(45, 45).A != 255 {
+		t.Fatal("Expected the filled pixels to be covered")
+	}
+	if drawn.AlphaAt(55, 55).A != 0 || drawn.AlphaAt(85, 85).A != 0 || drawn.AlphaAt(35, 35).A != 0 {
+		t.Fatal("Expected clipped and unfilled pixels not to be covered")
+	}
+}
+
+func TestStrokeAppend(t *testing.T) {
+	pts := [][2]float64{{10, 10}, {50, 20}, {80, 60}, {40, 90}, {20, 60}, {60, 50}}
+	setup := func(cv *canvas.Canvas) {
+		cv.SetStrokeStyle("#000")
+		cv.SetLineWidth(6)
+		cv.SetLineCap(canvas.Round)
+		cv.SetLineJoin(canvas.Round)
+		cv.BeginPath()
+		cv.MoveTo(pts[0][0], pts[0][1])
+	}
+
+	full := canvas.NewBackend(100, 100)
+	cv := canvas.New(full)
+	setup(cv)
+	for _, pt := range pts[1:] {
+		cv.LineTo(pt[0], pt[1])
+	}
+	cv.Stroke()
+
+	appended := canvas.NewBackend(100, 100)
+	rec := canvas.NewRecordingBackend(appended)
+	cv = canvas.New(rec)
+	setup(cv)
+	for _, pt := range pts[1:] {
+		cv.LineTo(pt[0], pt[1])
+		cv.StrokeAppend()
+		list := rec.DisplayList()
+		if n := len(list.Commands); n != 1 {
+			t.Fatalf("Expected 1 backend call per append, got %d", n)
+		}
+		list.Replay(appended)
+	}
+	cv.StrokeAppend()
+	if n := len(rec.DisplayList().Commands); n != 0 {
+		t.Fatalf("Expected no backend call without new segments, got %d", n)
+	}
+
+	var diff int
+	for i := 3; i < len(full.Image.Pix); i += 4 {
+		a, b := int(full.Image.Pix[i]), int(appended.Image.Pix[i])
+		if a > b+80 || b > a+80 {
+			diff++
+		}
+	}
+	if diff > 10 {
+		t.Fatalf("Appended stroke differs from the full stroke in %d pixels", diff)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	draw := func(backend *canvas.SoftwareBackend) {
+		cv := canvas.New(backend)
+		cv.SetFillStyle("#F00")
+		cv.Translate(50, 50)
+		for i := 0; i < 2; i++ {
+			cv.BeginPath()
+			for j := 0; j < 300; j++ {
+				a := float64(j) / 300 * math.Pi * 2
+				cv.LineTo(math.Cos(a)*15, math.Sin(a)*15)
+			}
+			cv.Fill()
+			cv.SetLineWidth(3)
+			cv.Stroke()
+		}
+	}
+
+	plain := canvas.NewBackend(100, 100)
+	plain.MSAA = 1
+	draw(plain)
+
+	reserved := canvas.NewBackend(100, 100)
+	reserved.MSAA = 1
+	reserved.Reserve(5000, 5000)
+	draw(reserved)
+
+	for i := range plain.Image.Pix {
+		if plain.Image.Pix[i] != reserved.Image.Pix[i] {
+			t.Fatalf("Result with reserved buffers differs at byte %d: %d != %d", i, reserved.Image.Pix[i], plain.Image.Pix[i])
+		}
+	}
+}
+
+func TestSelection(t *testing.T) {
+	sel := canvas.NewRectSelection(10.5, 10.5, 60, 40)
+	if !sel.Contains(30, 30) || sel.Contains(5, 5) {
+		t.Fatal("Rect selection contains the wrong points")
+	}
+	if n := len(sel.Damage()); n != 4 {
+		t.Fatalf("Expected a damaged area for each edge, got %d", n)
+	}
+
+	background := func(cv *canvas.Canvas) {
+		cv.SetFillStyle("#888")
+		cv.FillRect(0, 0, 100, 100)
+	}
+	frame := func(sel *canvas.Selection) *canvas.SoftwareBackend {
+		backend := canvas.NewBackend(100, 100)
+		cv := canvas.New(backend)
+		background(cv)
+		sel.Draw(cv)
+		return backend
+	}
+
+	first := frame(sel)
+	sel.Advance(time.Second / 8)
+	full := frame(sel)
+	if bytes.Equal(first.Image.Pix, full.Image.Pix) {
+		t.Fatal("Advancing the selection did not move the ants")
+	}
+
+	cv := canvas.New(first)
+	sel.Redraw(cv, func(area canvas.Bounds) { background(cv) })
+	for i := range full.Image.Pix {
+		if full.Image.Pix[i] != first.Image.Pix[i] {
+			t.Fatalf("Partial redraw differs from full redraw at byte %d", i)
+		}
+	}
+
+	lasso := canvas.NewLassoSelection()
+	for _, pt := range [][2]float64{{10, 10}, {90, 20}, {50, 90}} {
+		lasso.Add(pt[0], pt[1])
+	}
+	if lasso.Contains(50, 40) {
+		t.Fatal("Open lasso selection must not contain points")
+	}
+	lasso.Close()
+	if !lasso.Contains(50, 40) {
+		t.Fatal("Closed lasso selection does not contain its center")
+	}
+}
+
+// allocScenes are common scenes that must not allocate once the canvas
+// has drawn them for the first time
+func allocScenes(cv *canvas.Canvas) map[string]func() {
+	img, err := cv.LoadImage(image.NewRGBA(image.Rect(0, 0, 16, 16)))
+	if err != nil {
+		panic(err)
+	}
+	opaque := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := range opaque.Pix {
+		opaque.Pix[i] = uint8(i * 7)
+		if i%4 == 3 {
+			opaque.Pix[i] = 255
+		}
+	}
+	opaqueImg, err := cv.LoadImage(opaque)
+	if err != nil {
+		panic(err)
+	}
+	paletted := image.NewPaletted(image.Rect(0, 0, 16, 16), palette.Plan9)
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(i * 13)
+	}
+	for i := range nrgba.Pix {
+		nrgba.Pix[i] = uint8(i * 7)
+	}
+	palettedImg, err := cv.LoadImage(paletted)
+	if err != nil {
+		panic(err)
+	}
+	nrgbaImg, err := cv.LoadImage(nrgba)
+	if err != nil {
+		panic(err)
+	}
+	grad := cv.CreateLinearGradient(0, 0, 64, 0)
+	grad.AddColorStop(0, "#F00")
+	grad.AddColorStop(1, "#00F")
+	return map[string]func(){
+		"SolidFill": func() {
+			cv.SetFillStyle("#F00")
+			cv.FillRect(5, 5, 30, 30)
+			cv.BeginPath()
+			cv.MoveTo(1, 1)
+			cv.LineTo(40, 5)
+			cv.LineTo(20, 40)
+			cv.Fill()
+			cv.SetStrokeStyle(0.1, 0.2, 0.3, 1.0)
+			cv.Stroke()
+		},
+		"TranslucentFill": func() {
+			cv.SetFillStyle("#0000FF80")
+			cv.FillRect(0, 0, 64, 64)
+		},
+		"DrawImage": func() {
+			cv.DrawImage(img, 10, 10)
+			cv.DrawImage(img, 10, 10, 40, 30)
+		},
+		"OpaqueRect": func() {
+			cv.SetFillStyle("#204060")
+			cv.FillRect(0, 0, 64, 64)
+		},
+		"OpaqueImage": func() {
+			cv.DrawImage(opaqueImg, 0, 0, 64, 64)
+		},
+		"PalettedImage": func() {
+			cv.DrawImage(palettedImg, 0, 0, 64, 64)
+		},
+		"NRGBAImage": func() {
+			cv.DrawImage(nrgbaImg, 0, 0, 64, 64)
+		},
+		"ClearRect": func() {
+			cv.ClearRect(0, 0, 64, 64)
+		},
+		"GradientFill": func() {
+			cv.SetFillStyle(grad)
+			cv.FillRect(0, 0, 64, 64)
+		},
+	}
+}
+
+func TestZeroAllocFrame(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops buffers randomly with the race detector")
+	}
+	for _, msaa := range []int{0, 2} {
+		backend := canvas.NewBackend(64, 64)
+		backend.MSAA = msaa
+		cv := canvas.New(backend)
+		for name, scene := range allocScenes(cv) {
+			scene()
+			if allocs := testing.AllocsPerRun(10, scene); allocs > 0 {
+				t.Errorf("%s with MSAA %d: expected no allocations per frame, got %g", name, msaa, allocs)
+			}
+		}
+	}
+}
+
+func benchmarkScene(b *testing.B, name string) {
+	cv := canvas.New(canvas.NewBackend(64, 64))
+	scene := allocScenes(cv)[name]
+	scene()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		scene()
+	}
+}
+
+func BenchmarkSolidFill(b *testing.B)       { benchmarkScene(b, "SolidFill") }
+func BenchmarkTranslucentFill(b *testing.B) { benchmarkScene(b, "TranslucentFill") }
+func BenchmarkDrawImage(b *testing.B)       { benchmarkScene(b, "DrawImage") }
+func BenchmarkGradientFill(b *testing.B)    { benchmarkScene(b, "GradientFill") }
+func BenchmarkClearRect(b *testing.B)       { benchmarkScene(b, "ClearRect") }
+func BenchmarkOpaqueRect(b *testing.B)      { benchmarkScene(b, "OpaqueRect") }
+func BenchmarkOpaqueImage(b *testing.B)     { benchmarkScene(b, "OpaqueImage") }
+func BenchmarkPalettedImage(b *testing.B)   { benchmarkScene(b, "PalettedImage") }
+func BenchmarkNRGBAImage(b *testing.B)      { benchmarkScene(b, "NRGBAImage") }
+
+// premulBackend is a fake GPU backend that keeps its pixels with
+// premultiplied alpha. It rasterizes with a software backend and copies
+// the result into its own premultiplied texture after every drawing
+// call. GetImageData only reads the texture
+type premulBackend struct {
+	canvas.Backend
+	sw  *canvas.SoftwareBackend
+	tex *image.RGBA
+	// unpremultiply is false to simulate a backend that forgets to
+	// convert the pixels it reads back
+	unpremultiply bool
+}
+
+func newPremulBackend(w, h int, unpremultiply bool) *premulBackend {
+	sw := canvas.NewBackend(w, h)
+	sw.MSAA = 2
+	return &premulBackend{
+		Backend:       sw,
+		sw:            sw,
+		tex:           image.NewRGBA(image.Rect(0, 0, w, h)),
+		unpremultiply: unpremultiply,
+	}
+}
+
+func (b *premulBackend) sync() {
+	copy(b.tex.Pix, b.sw.Image.Pix)
+	canvas.PremultiplyRGBA(b.tex)
+}
+
+func (b *premulBackend) Clear(pts [4]canvas.BackendVec) {
+	b.Backend.Clear(pts)
+	b.sync()
+}
+
+func (b *premulBackend) Fill(style *canvas.BackendFillStyle, pts []canvas.BackendVec, tf canvas.BackendMat, canOverlap bool) {
+	b.Backend.Fill(style, pts, tf, canOverlap)
+	b.sync()
+}
+
+func (b *premulBackend) DrawImage(dimg canvas.BackendImage, sx, sy, sw, sh float64, pts [4]canvas.BackendVec, alpha float64) {
+	b.Backend.DrawImage(dimg, sx, sy, sw, sh, pts, alpha)
+	b.sync()
+}
+
+func (b *premulBackend) FillTrianglesVertexColor(pts []canvas.BackendVec, colors []color.RGBA) {
+	b.Backend.FillTrianglesVertexColor(pts, colors)
+	b.sync()
+}
+
+func (b *premulBackend) FillImageMask(style *canvas.BackendFillStyle, mask *image.Alpha, pts [4]canvas.BackendVec) {
+	b.Backend.FillImageMask(style, mask, pts)
+	b.sync()
+}
+
+func (b *premulBackend) PutImageData(img *image.RGBA, x, y int) {
+	b.Backend.PutImageData(img, x, y)
+	b.sync()
+}
+
+func (b *premulBackend) GetImageData(x, y, w, h int) *image.RGBA {
+	rect := image.Rect(x, y, x+w, y+h).Intersect(b.tex.Rect)
+	data := image.NewRGBA(rect)
+	draw.Draw(data, rect, b.tex, rect.Min, draw.Src)
+	if b.unpremultiply {
+		canvas.UnpremultiplyRGBA(data)
+	}
+	return data
+}
+
+func TestPremultipliedReadback(t *testing.T) {
+	render := func(b canvas.Backend) *image.RGBA {
+		cv := canvas.New(b)
+		cv.SetFillStyle("#FF800080")
+		cv.FillRect(0, 0, 10, 10)
+		cv.SetFillStyle("#F80")
+		cv.BeginPath()
+		cv.Arc(20, 20, 15, 0, math.Pi*2, false)
+		cv.Fill()
+		return cv.GetImageData(0, 0, 40, 40)
+	}
+	// check returns an error if the semi-transparent pixels of got do
+	// not have the straight alpha colors of want
+	check := func(got, want *image.RGBA) error {
+		fringe := false
+		for i := 0; i < len(want.Pix); i += 4 {
+			a := int(want.Pix[i+3])
+			if a == 0 || a == 255 {
+				continue
+			}
+			fringe = true
+			maxDiff := 255/a + 1
+			for c := 0; c < 3; c++ {
+				d := int(got.Pix[i+c]) - int(want.Pix[i+c])
+				if d < -maxDiff || d > maxDiff {
+					return fmt.Errorf("Channel %d of pixel %d with alpha %d is %d, expected %d", c, i/4, a, got.Pix[i+c], want.Pix[i+c])
+				}
+			}
+		}
+		if !fringe {
+			return errors.New("Expected semi-transparent edge pixels")
+		}
+		return nil
+	}
+
+	straight := canvas.NewBackend(40, 40)
+	straight.MSAA = 2
+	want := render(straight)
+
+	premul := newPremulBackend(40, 40, true)
+	if err := check(render(premul), want); err != nil {
+		t.Fatal(err)
+	}
+	async := <-canvas.New(premul).GetImageDataAsync(0, 0, 40, 40)
+	if err := check(async, want); err != nil {
+		t.Fatal(err)
+	}
+
+	buggy := newPremulBackend(40, 40, false)
+	if check(render(buggy), want) == nil {
+		t.Fatal("Expected a backend that returns premultiplied pixels to fail the check")
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, canvas.ImageDataNRGBA(want)); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nrgba := decoded.(*image.NRGBA)
+	if !bytes.Equal(nrgba.Pix, want.Pix) {
+		t.Fatal("Exported PNG does not contain the straight alpha colors")
+	}
+}
+
+func TestQuality(t *testing.T) {
+	draw := func(q canvas.Quality) (*canvas.SoftwareBackend, *image.RGBA) {
+		backend := canvas.NewBackend(40, 40)
+		cv := canvas.New(backend)
+		cv.SetQuality(q)
+		if cv.Quality() != q {
+			t.Fatalf("Quality is %d, expected %d", cv.Quality(), q)
+		}
+		cv.SetFillStyle("#F80")
+		cv.BeginPath()
+		cv.Arc(20, 20, 15, 0, math.Pi*2, false)
+		cv.Fill()
+		return backend, cv.GetImageData(0, 0, 40, 40)
+	}
+	partial := func(img *image.RGBA) int {
+		count := 0
+		for i := 3; i < len(img.Pix); i += 4 {
+			if img.Pix[i] != 0 && img.Pix[i] != 255 {
+				count++
+			}
+		}
+		return count
+	}
+
+	low, lowImg := draw(canvas.QualityLow)
+	if low.MSAA != 0 || low.BilinearFilter {
+		t.Fatalf("Unexpected low quality settings: MSAA %d, bilinear %v", low.MSAA, low.BilinearFilter)
+	}
+	if n := partial(lowImg); n != 0 {
+		t.Fatalf("Expected no anti-aliased pixels with low quality, got %d", n)
+	}
+
+	high, highImg := draw(canvas.QualityHigh)
+	if high.MSAA == 0 || !high.BilinearFilter {
+		t.Fatalf("Unexpected high quality settings: MSAA %d, bilinear %v", high.MSAA, high.BilinearFilter)
+	}
+	if n := partial(highImg); n == 0 {
+		t.Fatal("Expected anti-aliased pixels with high quality")
+	}
+
+	_, defaultImg := draw(canvas.QualityDefault)
+	_, lowAgainImg := draw(canvas.QualityLow)
+	if !bytes.Equal(lowImg.Pix, lowAgainImg.Pix) {
+		t.Fatal("Drawing with the same quality gave different results")
+	}
+	if n := partial(defaultImg); n != 0 {
+		t.Fatalf("Expected the default quality to draw without anti-aliasing, got %d pixels", n)
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Pix = []byte{0, 0, 0, 255, 255, 255, 255, 255}
+	backend := canvas.NewBackend(20, 1)
+	cv := canvas.New(backend)
+	cv.SetQuality(canvas.QualityHigh)
+	cv.DrawImage(src, 0, 0, 20, 1)
+	if c := backend.Image.RGBAAt(10, 0); c.R == 0 || c.R == 255 {
+		t.Fatalf("Expected a bilinear filtered gray in the middle, got %v", c)
+	}
+}
+
+func TestFillRectFastPath(t *testing.T) {
+	draw := func(backend canvas.Backend) *image.RGBA {
+		cv := canvas.New(backend)
+		cv.SetFillStyle("#204060")
+		cv.FillRect(0, 0, 50, 50)
+		cv.BeginPath()
+		cv.Arc(25, 25, 20, 0, math.Pi*2, false)
+		cv.Clip()
+		cv.SetFillStyle("#F80")
+		cv.FillRect(5, 5, 30, 20)
+		cv.SetFillStyle("#00FF0080")
+		cv.FillRect(35, 40, -20, -25)
+		cv.SetGlobalAlpha(0.5)
+		cv.FillRect(-10, 30, 100, 5)
+		cv.ClearRect(20, 20, 10, 10)
+		cv.Translate(0.5, 0)
+		cv.FillRect(10, 10, 5, 5)
+		return cv.GetImageData(0, 0, 50, 50)
+	}
+
+	fast := draw(canvas.NewBackend(50, 50))
+	// hide the fast path methods of the software backend
+	slow := draw(struct{ canvas.Backend }{canvas.NewBackend(50, 50)})
+	if !bytes.Equal(fast.Pix, slow.Pix) {
+		t.Fatal("Fast path gave a different result than the regular fill")
+	}
+}
+
+func TestMSAASharedEdges(t *testing.T) {
+	backend := canvas.NewBackend(64, 64)
+	backend.MSAA = 3
+	cv := canvas.New(backend)
+	cv.SetFillStyle("#F80")
+	// a rotated square made of several triangles that share edges
+	cv.BeginPath()
+	cv.MoveTo(32, 2.3)
+	cv.LineTo(61.7, 32)
+	cv.LineTo(32, 61.7)
+	cv.LineTo(2.3, 32)
+	cv.LineTo(20.1, 20.7)
+	cv.ClosePath()
+	cv.Fill()
+
+	img := cv.GetImageData(0, 0, 64, 64)
+	for y := 25; y < 40; y++ {
+		for x := 25; x < 40; x++ {
+			if c := img.RGBAAt(x, y); c != (color.RGBA{R: 255, G: 136, A: 255}) {
+				t.Fatalf("Interior pixel %d,%d is %v", x, y, c)
+			}
+		}
+	}
+	var partial bool
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] != 0 && img.Pix[i] != 255 {
+			partial = true
+		}
+	}
+	if !partial {
+		t.Fatal("Expected anti-aliased edges")
+	}
+}
+
+func BenchmarkMSAAFill(b *testing.B) {
+	backend := canvas.NewBackend(512, 512)
+	backend.MSAA = 3
+	cv := canvas.New(backend)
+	cv.SetFillStyle("#F80")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cv.BeginPath()
+		cv.Arc(256, 256, 250, 0, math.Pi*2, false)
+		cv.Fill()
+	}
+}
+
+func TestClipBounds(t *testing.T) {
+	for _, msaa := range []int{0, 2} {
+		backend := canvas.NewBackend(40, 40)
+		backend.MSAA = msaa
+		cv := canvas.New(backend)
+		cv.Save()
+		cv.BeginPath()
+		cv.Rect(10, 5, 20, 15)
+		cv.Clip()
+		cv.SetFillStyle("#F00")
+		cv.FillRect(0, 0, 40, 40)
+		cv.BeginPath()
+		cv.Arc(20, 20, 30, 0, math.Pi*2, false)
+		cv.SetFillStyle("#00F")
+		cv.Fill()
+
+		img := cv.GetImageData(0, 0, 40, 40)
+		for y := 0; y < 40; y++ {
+			for x := 0; x < 40; x++ {
+				inside := x >= 10 && x < 30 && y >= 5 && y < 20
+				if a := img.RGBAAt(x, y).A; (a == 255) != inside {
+					t.Fatalf("MSAA %d: pixel %d,%d has alpha %d, inside is %v", msaa, x, y, a, inside)
+				}
+			}
+		}
+
+		// clipping regions that don't overlap leave nothing to draw
+		cv.BeginPath()
+		cv.Rect(0, 30, 5, 5)
+		cv.Clip()
+		cv.SetFillStyle("#0F0")
+		cv.FillRect(0, 0, 40, 40)
+		cv.ClearRect(0, 0, 40, 40)
+		if !bytes.Equal(img.Pix, cv.GetImageData(0, 0, 40, 40).Pix) {
+			t.Fatalf("MSAA %d: drawing outside of an empty clipping region changed the image", msaa)
+		}
+
+		cv.Restore()
+		cv.ClearRect(0, 0, 40, 40)
+		cv.SetFillStyle("#0F0")
+		cv.BeginPath()
+		cv.Arc(20, 20, 10, 0, math.Pi*2, false)
+		cv.Clip()
+		cv.FillRect(0, 0, 40, 40)
+		img = cv.GetImageData(0, 0, 40, 40)
+		if img.RGBAAt(20, 20).G != 255 || img.RGBAAt(2, 2).A != 0 || img.RGBAAt(35, 20).A != 0 {
+			t.Fatalf("MSAA %d: unexpected result with a circular clipping region", msaa)
+		}
+	}
+}
+
+func TestStencilClear(t *testing.T) {
+	backend := canvas.NewBackend(100, 100)
+	cv := canvas.New(backend)
+	var covered int
+	backend.SetCoverageCallback(func(coverage *image.Alpha) {
+		covered = 0
+		for _, a := range coverage.Pix {
+			if a != 0 {
+				covered++
+			}
+		}
+	})
+
+	cv.SetFillStyle("#F00")
+	cv.BeginPath()
+	cv.Arc(50, 50, 45, 0, math.Pi*2, false)
+	cv.Fill()
+	if covered < 6000 {
+		t.Fatalf("Expected the large fill to cover most of the canvas, got %d pixels", covered)
+	}
+
+	cv.SetFillStyle("#00F")
+	cv.BeginPath()
+	cv.MoveTo(80, 80)
+	cv.LineTo(90, 80)
+	cv.LineTo(90, 90)
+	cv.LineTo(80, 90)
+	cv.Fill()
+	if covered != 100 {
+		t.Fatalf("Expected only the small fill to be covered, got %d pixels", covered)
+	}
+}
+
+func BenchmarkSmallFillLargeCanvas(b *testing.B) {
+	cv := canvas.New(canvas.NewBackend(1920, 1080))
+	cv.SetFillStyle("#0000FF80")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cv.BeginPath()
+		cv.MoveTo(10, 10)
+		cv.LineTo(30, 12)
+		cv.LineTo(20, 30)
+		cv.Fill()
+	}
+}
+
+func benchmarkShadowBlur(b *testing.B, blur float64) {
+	cv := canvas.New(canvas.NewBackend(100, 100))
+	cv.SetFillStyle("#F00")
+	cv.SetShadowColor("#000")
+	cv.SetShadowBlur(blur)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cv.FillRect(10, 10, 20, 20)
+	}
+}
+
+func BenchmarkShadowBlur10(b *testing.B)  { benchmarkShadowBlur(b, 10) }
+func BenchmarkShadowBlur200(b *testing.B) { benchmarkShadowBlur(b, 200) }
+func BenchmarkShadowBlur800(b *testing.B) { benchmarkShadowBlur(b, 800) }
+
+func BenchmarkShadowBlurImage(b *testing.B) {
+	cv := canvas.New(canvas.NewBackend(100, 100))
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 1000))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	cv.SetShadowColor("#000")
+	cv.SetShadowBlur(200)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cv.DrawImage(img, 10, 10, 20, 20)
+	}
+}
+
+func TestShadowBlurLarge(t *testing.T) {
+	draw := func(x, offsetX float64) *image.RGBA {
+		backend := canvas.NewBackend(100, 100)
+		cv := canvas.New(backend)
+		cv.SetFillStyle("#FFF")
+		cv.FillRect(0, 0, 100, 100)
+		// the shape is outside of the canvas, only its shadow is visible
+		cv.SetFillStyle("#F00")
+		cv.SetShadowColor("#000")
+		cv.SetShadowBlur(40)
+		cv.SetShadowOffsetX(offsetX)
+		cv.FillRect(x, 30, 40, 40)
+		return backend.Image
+	}
+
+	img := draw(-260, 300)
+	center := img.RGBAAt(60, 50)
+	if center.R > 240 {
+		t.Fatalf("Expected the shadow at 60/50, got %v", center)
+	}
+	// the blur is smooth and centered on the shadow
+	for _, d := range []int{10, 20, 30} {
+		l, r := img.RGBAAt(60-d, 50), img.RGBAAt(60+d, 50)
+		if l.R <= center.R || r.R <= center.R {
+			t.Errorf("Expected the shadow to fade %d pixels from the center, got %v and %v", d, l, r)
+		}
+		if diff := int(l.R) - int(r.R); diff < -4 || diff > 4 {
+			t.Errorf("Expected a symmetric shadow %d pixels from the center, got %v and %v", d, l, r)
+		}
+	}
+
+	// the part of the shape that is rendered into the mask doesn't
+	// change the visible shadow
+	img2 := draw(-160, 200)
+	for x := 0; x < 100; x++ {
+		a, b := img.RGBAAt(x, 50), img2.RGBAAt(x, 50)
+		if diff := int(a.R) - int(b.R); diff < -4 || diff > 4 {
+			t.Fatalf("Expected the same shadow at %d/50, got %v and %v", x, a, b)
+		}
+	}
+}
+
+func TestDrawImageTinted(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Pix = []byte{255, 255, 255, 255, 100, 200, 50, 255}
+	tint := color.RGBA{R: 255, G: 128, B: 0, A: 255}
+	draw := func(backend canvas.Backend) *image.RGBA {
+		cv := canvas.New(backend)
+		cv.DrawImageTinted(src, tint, 0, 0, 20, 10)
+		return cv.GetImageData(0, 0, 20, 10)
+	}
+
+	img := draw(canvas.NewBackend(20, 10))
+	if c := img.RGBAAt(5, 5); c != (color.RGBA{R: 255, G: 128, B: 0, A: 255}) {
+		t.Fatalf("Expected the white pixel to take the tint color, got %v", c)
+	}
+	if c := img.RGBAAt(15, 5); c != (color.RGBA{R: 100, G: 100, B: 0, A: 255}) {
+		t.Fatalf("Expected the second pixel to be multiplied with the tint, got %v", c)
+	}
+
+	// backends without tint support draw a tinted copy
+	fallback := draw(struct{ canvas.Backend }{canvas.NewBackend(20, 10)})
+	if !bytes.Equal(img.Pix, fallback.Pix) {
+		t.Fatal("The fallback gave a different result")
+	}
+
+	rec := canvas.NewRecordingBackend(canvas.NewBackend(20, 10))
+	draw(rec)
+	target := canvas.NewBackend(20, 10)
+	rec.DisplayList().Replay(target)
+	if !bytes.Equal(img.Pix, target.Image.Pix) {
+		t.Fatal("Replaying the recording gave a different result")
+	}
+}
+
+func TestPatternMipmap(t *testing.T) {
+	// a one pixel checkerboard averages to gray when scaled down
+	checker := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if (x+y)%2 == 0 {
+				checker.SetRGBA(x, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+			} else {
+				checker.SetRGBA(x, y, color.RGBA{A: 255})
+			}
+		}
+	}
+
+	for _, quality := range []canvas.Quality{canvas.QualityDefault, canvas.QualityHigh} {
+		cv := canvas.New(canvas.NewBackend(32, 32))
+		cv.SetQuality(quality)
+		pattern := cv.CreatePattern(checker, canvas.Repeat)
+		pattern.SetTransform([6]float64{0.25, 0, 0, 0.25, 0, 0})
+		cv.SetFillStyle(pattern)
+		cv.FillRect(0, 0, 32, 32)
+
+		img := cv.GetImageData(0, 0, 32, 32)
+		for i := 0; i < len(img.Pix); i += 4 {
+			if v := img.Pix[i]; v < 64 || v > 192 {
+				t.Fatalf("Quality %d: expected the scaled down pattern to be gray, got %d at pixel %d", quality, v, i/4)
+			}
+		}
+	}
+}
+
+func TestScatter(t *testing.T) {
+	cv := canvas.New(canvas.NewBackend(100, 100))
+	area := cv.NewPath2D()
+	area.Rect(10, 10, 80, 40)
+	s := canvas.Scatter{Seed: 7, Density: 0.02, Jitter: 0.8, Rotation: math.Pi, MinScale: 0.5, MaxScale: 1.5}
+
+	placements := s.Placements(area)
+	if n := len(placements); n < 50 || n > 80 {
+		t.Fatalf("Expected about 64 placements, got %d", n)
+	}
+	for _, pl := range placements {
+		if pl.X < 10 || pl.X > 90 || pl.Y < 10 || pl.Y > 50 {
+			t.Fatalf("Placement %v is outside of the shape", pl)
+		}
+		if pl.Scale < 0.5 || pl.Scale > 1.5 || math.Abs(pl.Rotation) > math.Pi {
+			t.Fatalf("Placement %v is outside of the random ranges", pl)
+		}
+	}
+	if again := s.Placements(area); !reflect.DeepEqual(placements, again) {
+		t.Fatal("The same seed gave different placements")
+	}
+
+	// growing the shape keeps the existing placements
+	larger := cv.NewPath2D()
+	larger.Rect(0, 0, 100, 100)
+	kept := 0
+	for _, pl := range s.Placements(larger) {
+		for _, pl2 := range placements {
+			if pl == pl2 {
+				kept++
+			}
+		}
+	}
+	if kept != len(placements) {
+		t.Fatalf("Expected all %d placements to be kept, got %d", len(placements), kept)
+	}
+
+	dot := cv.NewPath2D()
+	dot.Arc(0, 0, 2, 0, math.Pi*2, false)
+	cv.SetFillStyle("#F00")
+	cv.BeginPath()
+	cv.Rect(0, 0, 100, 30)
+	cv.Clip()
+	cv.ScatterPath(area, s, dot)
+	img := cv.GetImageData(0, 0, 100, 100)
+	if img.RGBAAt(50, 45).A != 0 {
+		t.Fatal("Expected the scattered shapes to honor the clipping region")
+	}
+	drawn := 0
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] != 0 {
+			drawn++
+		}
+	}
+	if drawn == 0 {
+		t.Fatal("Expected scattered shapes to be drawn")
+	}
+}
+
+func TestDamageTracker(t *testing.T) {
+	backend := canvas.NewBackend(100, 100)
+	dt := canvas.NewDamageTracker(backend)
+	cv := canvas.New(dt)
+
+	cv.SetFillStyle("#FFF")
+	cv.FillRect(10, 10, 20, 20)
+	cv.FillRect(20, 20, 20, 20)
+	cv.FillRect(60, 60, 10, 10)
+	cv.Flush()
+	dt.EndFrame()
+
+	if n := dt.Overdraw(15, 15); n != 1 {
+		t.Fatalf("Expected 1 draw at 15/15, got %d", n)
+	}
+	if n := dt.Overdraw(25, 25); n != 2 {
+		t.Fatalf("Expected 2 draws at 25/25, got %d", n)
+	}
+	if n := dt.Overdraw(50, 50); n != 0 {
+		t.Fatalf("Expected no draws at 50/50, got %d", n)
+	}
+	damage := dt.Damage()
+	if len(damage) != 2 {
+		t.Fatalf("Expected 2 damage regions, got %v", damage)
+	}
+
+	dt.DrawOverlay(cv)
+	dt.EndFrame()
+	if len(dt.Damage()) != 0 {
+		t.Fatal("The overlay was counted as damage")
+	}
+	if c := backend.Image.RGBAAt(50, 50); c.A != 0 {
+		t.Fatalf("Expected the undrawn pixel to stay transparent, got %v", c)
+	}
+	once, twice := backend.Image.RGBAAt(15, 15), backend.Image.RGBAAt(25, 25)
+	if once == twice || once.B == 255 {
+		t.Fatalf("Expected different tints for overdraw, got %v and %v", once, twice)
+	}
+}
+
+func TestMipmapGammaCorrect(t *testing.T) {
+	checker := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if (x+y)%2 == 0 {
+				checker.SetRGBA(x, y, color.RGBA{255, 255, 255, 255})
+			} else {
+				checker.SetRGBA(x, y, color.RGBA{0, 0, 0, 255})
+			}
+		}
+	}
+	for _, q := range []canvas.MipmapQuality{canvas.MipmapBox, canvas.MipmapTriangle, canvas.MipmapLanczos} {
+		backend := canvas.NewBackend(32, 32)
+		cv := canvas.New(backend)
+		img, err := cv.LoadImage(checker, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cv.DrawImage(img, 0, 0, 32, 32)
+		cv.Flush()
+		// half of the light in linear space is 188 in sRGB, averaging
+		// the sRGB values would give 127
+		if c := backend.Image.RGBAAt(16, 16); c.R < 180 || c.R > 196 || c.A != 255 {
+			t.Fatalf("Quality %d: expected a gamma correct gray, got %v", q, c)
+		}
+	}
+
+	// odd sizes must not drop the last column
+	odd := image.NewRGBA(image.Rect(0, 0, 3, 3))
+	for y := 0; y < 3; y++ {
+		odd.SetRGBA(2, y, color.RGBA{255, 0, 0, 255})
+	}
+	backend := canvas.NewBackend(2, 2)
+	cv := canvas.New(backend)
+	cv.DrawImage(odd, 0, 0, 2, 2)
+	cv.Flush()
+	if c := backend.Image.RGBAAt(1, 0); c.R == 0 {
+		t.Fatalf("Expected the last column to be part of the mipmap, got %v", c)
+	}
+
+	// the colors are straight alpha, so transparent pixels must not
+	// darken the colors next to them
+	edge := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		edge.SetRGBA(0, y, color.RGBA{255, 0, 0, 128})
+	}
+	backend = canvas.NewBackend(2, 2)
+	cv = canvas.New(backend)
+	cv.DrawImage(edge, 0, 0, 2, 2)
+	cv.Flush()
+	ref := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	ref.SetRGBA(0, 0, color.RGBA{255, 0, 0, 64})
+	refBackend := canvas.NewBackend(1, 1)
+	refCv := canvas.New(refBackend)
+	refCv.DrawImage(ref, 0, 0)
+	refCv.Flush()
+	if c, want := backend.Image.RGBAAt(0, 0), refBackend.Image.RGBAAt(0, 0); c != want {
+		t.Fatalf("Expected %v, got %v", want, c)
+	}
+}
+
+func TestPixelHelpers(t *testing.T) {
+	for _, inPlace := range []bool{true, false} {
+		backend := canvas.NewBackend(20, 20)
+		var cv *canvas.Canvas
+		if inPlace {
+			cv = canvas.New(backend)
+		} else {
+			// hide the pixel buffer to test the fallback
+			cv = canvas.New(struct{ canvas.Backend }{backend})
+		}
+		cv.SetFillStyle("#F00")
+		cv.FillRect(0, 0, 10, 20)
+
+		cv.MapPixels(image.Rect(5, 5, 15, 15), func(x, y int, c color.RGBA) color.RGBA {
+			return color.RGBA{R: 255 - c.R, G: uint8(x), B: uint8(y), A: 255}
+		})
+		if c := backend.Image.RGBAAt(6, 7); c != (color.RGBA{0, 6, 7, 255}) {
+			t.Fatalf("In place %v: unexpected mapped pixel %v", inPlace, c)
+		}
+		if c := backend.Image.RGBAAt(12, 14); c != (color.RGBA{255, 12, 14, 255}) {
+			t.Fatalf("In place %v: unexpected mapped pixel %v", inPlace, c)
+		}
+
+		hist := cv.Histogram(image.Rect(-5, -5, 100, 100))
+		if hist.A[0] != 150 || hist.A[255] != 250 {
+			t.Fatalf("Unexpected alpha histogram %d/%d", hist.A[0], hist.A[255])
+		}
+
+		r, g, b, a := cv.SplitChannels(image.Rect(0, 0, 20, 20))
+		cv.MergeChannels(b, g, r, nil, 0, 0)
+		if c := backend.Image.RGBAAt(2, 2); c != (color.RGBA{0, 0, 255, 255}) {
+			t.Fatalf("Expected the channels to be swapped, got %v", c)
+		}
+		if a.AlphaAt(15, 2).A != 0 || a.AlphaAt(2, 2).A != 255 {
+			t.Fatal("Unexpected alpha channel")
+		}
+	}
+}
+
+func TestViewTransform(t *testing.T) {
+	backend := canvas.NewBackend(100, 100)
+	cv := canvas.New(backend)
+	cv.SetViewTransform(1e12, -1e12, 2, 0, 50, 50)
+
+	if x, y := cv.WorldToDevice(1e12, -1e12); x != 50 || y != 50 {
+		t.Fatalf("Expected the origin at 50/50, got %v/%v", x, y)
+	}
+	if x, y := cv.DeviceToWorld(60, 50); x != 1e12+5 || y != -1e12 {
+		t.Fatalf("Expected 60/50 to be at %v/%v, got %v/%v", 1e12+5, -1e12, x, y)
+	}
+
+	cv.SetFillStyle("#F00")
+	cv.FillRect(1e12-5, -1e12-5, 5, 5)
+	cv.Save()
+	cv.Translate(1e12, -1e12)
+	cv.SetFillStyle("#00F")
+	cv.FillRect(0.25, 0.25, 4.5, 4.5)
+	cv.Restore()
+	cv.Flush()
+
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	for _, c := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{40, 40, red}, {49, 49, red}, {39, 45, color.RGBA{}},
+		{51, 51, blue}, {58, 58, blue}, {59, 59, color.RGBA{}},
+	} {
+		if got := backend.Image.RGBAAt(c.x, c.y); got != c.want {
+			t.Fatalf("Expected %v at %d/%d, got %v", c.want, c.x, c.y, got)
+		}
+	}
+
+	cv.ClearViewTransform()
+	if x, y := cv.WorldToDevice(3, 4); x != 3 || y != 4 {
+		t.Fatalf("Expected no view, got %v/%v", x, y)
+	}
+}
+
+func TestPerspectiveTransform(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if y >= 5 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	// a card that is tilted away from the viewer at the top
+	src := [4]canvas.BackendVec{{0, 0}, {0, 100}, {100, 100}, {100, 0}}
+	dst := [4]canvas.BackendVec{{30, 0}, {0, 100}, {100, 100}, {70, 0}}
+	m, ok := canvas.PerspectiveFromQuads(src, dst)
+	if !ok {
+		t.Fatal("Expected a valid perspective matrix")
+	}
+
+	for _, msaa := range []int{0, 2} {
+		backend := canvas.NewBackend(100, 100)
+		backend.MSAA = msaa
+		cv := canvas.New(backend)
+		cv.SetPerspectiveTransform(m)
+		cv.DrawImage(img, 0, 0, 100, 100)
+		cv.Flush()
+
+		// the far half of the card is foreshortened, so the middle of
+		// the image is above the middle of the canvas
+		for _, c := range []struct {
+			x, y int
+			want color.RGBA
+		}{
+			{50, 5, color.RGBA{255, 0, 0, 255}},
+			{50, 38, color.RGBA{0, 0, 255, 255}},
+			{50, 95, color.RGBA{0, 0, 255, 255}},
+			{5, 5, color.RGBA{}},
+		} {
+			if got := backend.Image.RGBAAt(c.x, c.y); got != c.want {
+				t.Fatalf("MSAA %d: expected %v at %d/%d, got %v", msaa, c.want, c.x, c.y, got)
+			}
+		}
+
+		cv.ClearRect(0, 0, 100, 100)
+		cv.SetFillStyle("#0F0")
+		cv.FillRect(0, 0, 100, 100)
+		cv.ClearPerspectiveTransform()
+		cv.Flush()
+		if c := backend.Image.RGBAAt(10, 5); c.A != 0 {
+			t.Fatalf("MSAA %d: expected the corner outside of the card to be empty, got %v", msaa, c)
+		}
+		if c := backend.Image.RGBAAt(50, 5); c.G != 255 {
+			t.Fatalf("MSAA %d: expected the card to be filled, got %v", msaa, c)
+		}
+	}
+}
+
+func TestScreenshot(t *testing.T) {
+	backend := canvas.NewBackend(60, 40)
+	cv := canvas.New(backend)
+	cv.SetFillStyle("#00F")
+	cv.FillRect(0, 0, 60, 40)
+
+	cursor := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(cursor, cursor.Rect, image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+
+	var buf bytes.Buffer
+	err := cv.Screenshot(&buf, image.Rect(10, 10, 50, 30), canvas.ScreenshotOptions{
+		Cursor:  cursor,
+		CursorX: 22, CursorY: 22,
+		HotspotX: 2, HotspotY: 2,
+		Overlay: func(layer *canvas.Canvas) {
+			layer.SetFillStyle("#0F0")
+			layer.FillRect(40, 10, 10, 5)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 40 || b.Dy() != 20 {
+		t.Fatalf("Expected a 40x20 image, got %v", b)
+	}
+	for _, c := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 0, color.RGBA{0, 0, 255, 255}},
+		{10, 10, color.RGBA{255, 0, 0, 255}},
+		{35, 2, color.RGBA{0, 255, 0, 255}},
+	} {
+		if got := color.RGBAModel.Convert(img.At(c.x, c.y)).(color.RGBA); got != c.want {
+			t.Fatalf("Expected %v at %d/%d, got %v", c.want, c.x, c.y, got)
+		}
+	}
+	if c := backend.Image.RGBAAt(20, 20); c != (color.RGBA{0, 0, 255, 255}) {
+		t.Fatalf("Expected the canvas to be unchanged, got %v", c)
+	}
+
+	buf.Reset()
+	err = cv.Screenshot(&buf, image.Rect(0, 0, 60, 40), canvas.ScreenshotOptions{Format: canvas.ScreenshotJPEG, Quality: 90})
+	if imageFormatsDisabled {
+		if err == nil {
+			t.Fatal("Expected an error for JPEG with the canvas_noimageformats tag")
+		}
+	} else if err != nil {
+		t.Fatal(err)
+	} else if _, err := jpeg.Decode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := cv.Screenshot(&buf, image.Rect(100, 100, 120, 120), canvas.ScreenshotOptions{}); err == nil {
+		t.Fatal("Expected an error for a region outside of the canvas")
+	}
+}
+
+func TestExport(t *testing.T) {
+	// pixel scaling
+	cv := canvas.New(canvas.NewBackend(40, 20))
+	cv.SetFillStyle("#F00")
+	cv.FillRect(0, 0, 40, 20)
+	cv.SetFillStyle("#00F")
+	cv.FillRect(20, 0, 20, 20)
+	var buf bytes.Buffer
+	if err := cv.Export(&buf, image.Rect(10, 0, 30, 20), 2, canvas.ScreenshotPNG); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 40 || b.Dy() != 40 {
+		t.Fatalf("Expected a 40x40 image, got %v", b)
+	}
+	if c := color.RGBAModel.Convert(img.At(5, 20)).(color.RGBA); c != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("Expected red on the left, got %v", c)
+	}
+	if c := color.RGBAModel.Convert(img.At(35, 20)).(color.RGBA); c != (color.RGBA{0, 0, 255, 255}) {
+		t.Errorf("Expected blue on the right, got %v", c)
+	}
+
+	// a recorded canvas is rendered again, so a circle edge stays as
+	// sharp as when drawn at the larger size
+	rec := canvas.NewRecordingBackend(canvas.NewBackend(20, 20))
+	rcv := canvas.New(rec)
+	rcv.SetFillStyle("#000")
+	rcv.BeginPath()
+	rcv.Arc(10, 10, 8, 0, math.Pi*2, false)
+	rcv.Fill()
+	exported, err := rcv.ExportImage(image.Rect(0, 0, 20, 20), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := canvas.New(canvas.NewBackend(80, 80))
+	ref.SetFillStyle("#000")
+	ref.BeginPath()
+	ref.Arc(40, 40, 32, 0, math.Pi*2, false)
+	ref.Fill()
+	want := canvas.ImageDataNRGBA(ref.GetImageData(0, 0, 80, 80))
+	if exported.Rect != want.Rect {
+		t.Fatalf("Expected %v, got %v", want.Rect, exported.Rect)
+	}
+	for i := range want.Pix {
+		if d := int(exported.Pix[i]) - int(want.Pix[i]); d < -2 || d > 2 {
+			t.Fatalf("Expected the re-rendered export to match a render at 4x, byte %d is %d instead of %d", i, exported.Pix[i], want.Pix[i])
+		}
+	}
+
+	if _, err := cv.ExportImage(image.Rect(50, 50, 60, 60), 1); err == nil {
+		t.Error("Expected an error for a region outside of the canvas")
+	}
+	if _, err := cv.ExportImage(image.Rect(0, 0, 10, 10), 0); err == nil {
+		t.Error("Expected an error for a zero scale")
+	}
+}
+
+func TestUnits(t *testing.T) {
+	backend := canvas.NewBackend(300, 300)
+	cv := canvas.New(backend)
+	cv.SetUnits(canvas.Millimeters, 254)
+	if px := cv.ToPixels(25.4); math.Abs(px-254) > 1e-9 {
+		t.Fatalf("Expected 25.4mm to be 254 pixels at 254 DPI, got %v", px)
+	}
+	// 10mm at 254 DPI are 100 pixels
+	cv.SetFillStyle("#F00")
+	cv.FillRect(10, 10, 5, 5)
+	if c := backend.Image.RGBAAt(120, 120); c != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("Expected red inside of the rectangle, got %v", c)
+	}
+	if c := backend.Image.RGBAAt(95, 120); c.A != 0 {
+		t.Errorf("Expected nothing left of the rectangle, got %v", c)
+	}
+
+	// SetTransform is relative to the units
+	cv.SetTransform(1, 0, 0, 1, 20, 0)
+	cv.SetFillStyle("#00F")
+	cv.FillRect(0, 0, 1, 1)
+	if c := backend.Image.RGBAAt(205, 5); c != (color.RGBA{0, 0, 255, 255}) {
+		t.Errorf("Expected blue at 20mm, got %v", c)
+	}
+
+	cv.SetUnits(canvas.Pixels, 0)
+	if unit, dpi := cv.Units(); unit != canvas.Pixels || dpi != canvas.DefaultDPI {
+		t.Errorf("Expected pixels at the default resolution, got %v at %v", unit, dpi)
+	}
+	if px := cv.ToPixels(7); px != 7 {
+		t.Errorf("Expected pixels to stay unscaled, got %v", px)
+	}
+}
+
+func TestPage(t *testing.T) {
+	page := canvas.Page{
+		Unit: canvas.Millimeters, DPI: 254,
+		Width: 20, Height: 10,
+		MarginTop: 1, MarginRight: 2, MarginBottom: 1, MarginLeft: 2,
+		Bleed: 1, Slug: 4,
+	}
+	if w, h := page.PixelSize(); w != 300 || h != 200 {
+		t.Fatalf("Expected a 300x200 canvas, got %dx%d", w, h)
+	}
+	if b := page.ContentBox(); b != (canvas.Bounds{MinX: 2, MinY: 1, MaxX: 18, MaxY: 9}) {
+		t.Errorf("Unexpected content box %v", b)
+	}
+	if b := page.BleedBox(); b != (canvas.Bounds{MinX: -1, MinY: -1, MaxX: 21, MaxY: 11}) {
+		t.Errorf("Unexpected bleed box %v", b)
+	}
+
+	backend := page.NewBackend()
+	cv := canvas.New(backend)
+	page.Setup(cv)
+	bleed := page.BleedBox()
+	cv.SetFillStyle("#0F0")
+	cv.FillRect(bleed.MinX, bleed.MinY, bleed.MaxX-bleed.MinX, bleed.MaxY-bleed.MinY)
+	page.DrawCropMarks(cv)
+
+	// the trimmed page starts at 5mm, the bleed at 4mm
+	if c := backend.Image.RGBAAt(45, 100); c != (color.RGBA{0, 255, 0, 255}) {
+		t.Errorf("Expected the bleed to be filled, got %v", c)
+	}
+	if c := backend.Image.RGBAAt(35, 100); c.A != 0 {
+		t.Errorf("Expected the slug to be empty, got %v", c)
+	}
+	// the horizontal crop mark of the top left corner runs along y=5mm
+	// from 0 to 4mm
+	if c := backend.Image.RGBAAt(20, 50); c.A == 0 {
+		t.Errorf("Expected a crop mark, got %v", c)
+	}
+	if c := backend.Image.RGBAAt(20, 46); c.A != 0 {
+		t.Errorf("Expected the crop mark to be thin, got %v", c)
+	}
+}
+
+type testFrames struct {
+	frames  []image.Image
+	current int
+}
+
+func (f *testFrames) Frame() (image.Image, uint64) {
+	return f.frames[f.current], uint64(f.current)
+}
+
+func TestLivePattern(t *testing.T) {
+	src := canvas.New(canvas.NewBackend(4, 4))
+	src.SetFillStyle("#F00")
+	src.FillRect(0, 0, 4, 4)
+
+	backend := canvas.NewBackend(8, 8)
+	cv := canvas.New(backend)
+	cv.SetFillStyle(cv.CreatePattern(src, canvas.Repeat))
+	cv.FillRect(0, 0, 8, 8)
+	if c := backend.Image.RGBAAt(6, 6); c != (color.RGBA{255, 0, 0, 255}) {
+		t.Fatalf("Expected the red source canvas, got %v", c)
+	}
+
+	// the pattern follows the source canvas
+	src.SetFillStyle("#00F")
+	src.FillRect(0, 0, 4, 4)
+	cv.FillRect(0, 0, 8, 8)
+	if c := backend.Image.RGBAAt(6, 6); c != (color.RGBA{0, 0, 255, 255}) {
+		t.Fatalf("Expected the pattern to follow the source canvas, got %v", c)
+	}
+
+	// frame sources
+	green := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	draw.Draw(green, green.Rect, image.NewUniform(color.RGBA{0, 255, 0, 255}), image.Point{}, draw.Src)
+	white := image.NewRGBA(image.Rect(0, 0, 3, 3))
+	draw.Draw(white, white.Rect, image.NewUniform(color.RGBA{255, 255, 255, 255}), image.Point{}, draw.Src)
+	frames := &testFrames{frames: []image.Image{green, white}}
+	cv.SetFillStyle(cv.CreatePattern(frames, canvas.Repeat))
+	cv.FillRect(0, 0, 8, 8)
+	if c := backend.Image.RGBAAt(5, 5); c != (color.RGBA{0, 255, 0, 255}) {
+		t.Fatalf("Expected the first frame, got %v", c)
+	}
+	frames.current = 1
+	cv.FillRect(0, 0, 8, 8)
+	if c := backend.Image.RGBAAt(5, 5); c != (color.RGBA{255, 255, 255, 255}) {
+		t.Fatalf("Expected the second frame, got %v", c)
+	}
+
+	// backends are copied on every use
+	other := canvas.NewBackend(2, 2)
+	draw.Draw(other.Image, other.Image.Rect, image.NewUniform(color.RGBA{0, 0, 0, 255}), image.Point{}, draw.Src)
+	cv.SetFillStyle(cv.CreatePattern(other, canvas.Repeat))
+	cv.FillRect(0, 0, 8, 8)
+	if c := backend.Image.RGBAAt(3, 3); c != (color.RGBA{0, 0, 0, 255}) {
+		t.Fatalf("Expected the backend content, got %v", c)
+	}
+}
+
+func TestPatternRoundSpace(t *testing.T) {
+	// left half red, right half blue
+	tile := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(tile, image.Rect(0, 0, 2, 4), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	draw.Draw(tile, image.Rect(2, 0, 4, 4), image.NewUniform(color.RGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+
+	// three tiles of 10/3 pixels fit into the 10 pixels of the canvas
+	backend := canvas.NewBackend(10, 10)
+	cv := canvas.New(backend)
+	cv.SetFillStyle(cv.CreatePattern(tile, canvas.RepeatRound))
+	cv.FillRect(0, 0, 10, 10)
+	for x, want := range map[int]color.RGBA{0: red, 2: blue, 4: red, 6: blue, 7: red, 9: blue} {
+		if c := backend.Image.RGBAAt(x, 1); c != want {
+			t.Errorf("Expected %v at %d with round, got %v", want, x, c)
+		}
+	}
+
+	// two tiles with a gap of 2 pixels fit into the area
+	backend = canvas.NewBackend(10, 10)
+	cv = canvas.New(backend)
+	ip := cv.CreatePattern(tile, canvas.RepeatSpace)
+	ip.SetRepeatArea(0, 0, 10, 4)
+	cv.SetFillStyle(ip)
+	cv.FillRect(0, 0, 10, 10)
+	for x, want := range map[int]color.RGBA{0: red, 3: blue, 4: {}, 5: {}, 6: red, 9: blue} {
+		if c := backend.Image.RGBAAt(x, 1); c != want {
+			t.Errorf("Expected %v at %d with space, got %v", want, x, c)
+		}
+	}
+	// only one tile fits vertically, so it is not repeated
+	if c := backend.Image.RGBAAt(0, 6); c.A != 0 {
+		t.Errorf("Expected no repetition below a single tile, got %v", c)
+	}
+}
+
+func TestParseGradient(t *testing.T) {
+	backend := canvas.NewBackend(100, 20)
+	cv := canvas.New(backend)
+
+	g, err := canvas.ParseGradient("linear-gradient(to right, #f00, #00f 50px, rgba(0,255,0,.5))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cv.SetFillStyle(g.FillStyle(cv, 0, 0, 100, 20))
+	cv.FillRect(0, 0, 100, 20)
+	for _, c := range []struct {
+		x    int
+		want color.RGBA
+	}{
+		{0, color.RGBA{255, 0, 0, 255}},
+		{50, color.RGBA{0, 0, 255, 255}},
+	} {
+		got := backend.Image.RGBAAt(c.x, 10)
+		diff := 0
+		for i, v := range [4]uint8{got.R, got.G, got.B, got.A} {
+			d := int(v) - int([4]uint8{c.want.R, c.want.G, c.want.B, c.want.A}[i])
+			if d < 0 {
+				d = -d
+			}
+			if d > diff {
+				diff = d
+			}
+		}
+		if diff > 8 {
+			t.Errorf("Expected %v at %d, got %v", c.want, c.x, got)
+		}
+	}
+	if got := backend.Image.RGBAAt(99, 10); got.G <= got.R || got.G <= got.B || got.A > 200 {
+		t.Errorf("Expected half transparent green at the end, got %v", got)
+	}
+
+	// 0deg points up, so the first color is at the bottom
+	g, err = canvas.ParseGradient("linear-gradient(0deg, #fff, #000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cv.SetFillStyle(g.FillStyle(cv, 0, 0, 100, 20))
+	cv.FillRect(0, 0, 100, 20)
+	if top, bottom := backend.Image.RGBAAt(50, 0), backend.Image.RGBAAt(50, 19); top.R > 30 || bottom.R < 225 {
+		t.Errorf("Expected black at the top and white at the bottom, got %v and %v", top, bottom)
+	}
+
+	g, err = canvas.ParseGradient("radial-gradient(circle closest-side at 20px 50%, #ff0, transparent)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cv.ClearRect(0, 0, 100, 20)
+	cv.SetFillStyle(g.FillStyle(cv, 0, 0, 100, 20))
+	cv.FillRect(0, 0, 100, 20)
+	if c := backend.Image.RGBAAt(20, 10); c.R < 240 || c.G < 240 || c.A < 240 {
+		t.Errorf("Expected yellow at the center, got %v", c)
+	}
+	if c := backend.Image.RGBAAt(40, 10); c.A != 0 {
+		t.Errorf("Expected transparent outside of the closest side, got %v", c)
+	}
+
+	for _, s := range []string{
+		"conic-gradient(red, blue)",
+		"linear-gradient(red)",
+		"linear-gradient(to middle, red, blue)",
+		"linear-gradient(red, notacolor)",
+		"linear-gradient(red 10em, blue)",
+	} {
+		if _, err := canvas.ParseGradient(s); err == nil {
+			t.Errorf("Expected an error for %s", s)
+		}
+	}
+}
+
+func TestParseGradientColorNames(t *testing.T) {
+	g, err := canvas.ParseGradient("linear-gradient(Red, blue)")
+	if colorNamesDisabled {
+		if err == nil {
+			t.Fatal("Expected an error for color names with the canvas_nocolornames tag")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := canvas.NewBackend(10, 100)
+	cv := canvas.New(backend)
+	cv.SetFillStyle(g.FillStyle(cv, 0, 0, 10, 100))
+	cv.FillRect(0, 0, 10, 100)
+	if top, bottom := backend.Image.RGBAAt(5, 0), backend.Image.RGBAAt(5, 99); top.R < 240 || top.B > 10 || bottom.B < 240 || bottom.R > 10 {
+		t.Fatalf("Expected red at the top and blue at the bottom, got %v and %v", top, bottom)
+	}
+}
+func TestFramePacer(t *testing.T) {
+	cv := canvas.New(canvas.NewBackend(20, 20))
+	cv.SetQuality(canvas.QualityHigh)
+
+	fp := canvas.NewFramePacer(200)
+	if fp.Interval() != 5*time.Millisecond {
+		t.Fatalf("Expected an interval of 5ms, got %v", fp.Interval())
+	}
+	for i := 0; i < 3; i++ {
+		fp.Frame(cv, func(cv *canvas.Canvas) {
+			cv.FillRect(0, 0, 10, 10)
+			time.Sleep(8 * time.Millisecond)
+		})
+	}
+	if q := cv.Quality(); q != canvas.QualityMedium {
+		t.Fatalf("Expected the quality to be lowered to medium, got %v", q)
+	}
+	stats := fp.Stats()
+	if stats.Frames != 3 {
+		t.Fatalf("Expected 3 frames, got %d", stats.Frames)
+	}
+	if stats.Average < 8*time.Millisecond || stats.Max < stats.Average || stats.Last < 8*time.Millisecond {
+		t.Fatalf("Unexpected frame times %+v", stats)
+	}
+
+	cc := canvas.NewConcurrent(canvas.NewBackend(20, 20))
+	cc.Do(func(cv *canvas.Canvas) { cv.SetQuality(canvas.QualityMedium) })
+	for i := 0; i < 3; i++ {
+		cc.Submit(func(cv *canvas.Canvas) { time.Sleep(8 * time.Millisecond) })
+		fp.FrameConcurrent(cc)
+	}
+	cc.Do(func(cv *canvas.Canvas) {
+		if q := cv.Quality(); q != canvas.QualityLow {
+			t.Fatalf("Expected the quality to be lowered to low, got %v", q)
+		}
+	})
+
+	fp.ResetStats()
+	if fp.Stats() != (canvas.FrameStats{}) {
+		t.Fatal("Expected empty stats after ResetStats")
+	}
+}
+
+func TestRenderStats(t *testing.T) {
+	cv := canvas.New(canvas.NewBackend(100, 100))
+	cv.SetFillStyle("#F00")
+	cv.FillRect(0, 0, 10, 10)
+	cv.FillRect(20, 0, 10, 10)
+	cv.Scale(2, 2)
+	cv.FillRect(0, 20, 10, 10)
+	if st := cv.Stats(); st != (canvas.RenderStats{}) {
+		t.Fatalf("Expected no stats before the first frame, got %+v", st)
+	}
+	cv.EndFrame()
+	st := cv.Stats()
+	if st.Triangles != 6 || st.Pixels != 600 || st.DrawCalls < 1 || st.DrawCalls > 3 {
+		t.Fatalf("Expected 6 triangles and 600 pixels, got %+v", st)
+	}
+
+	cv.SetTransform(1, 0, 0, 1, 0, 0)
+	cv.SetShadowColor("#000")
+	cv.SetShadowBlur(4)
+	cv.FillRect(40, 40, 20, 20)
+	cv.SetShadowColor("#0000")
+	img, err := cv.LoadImage(image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cv.DrawImage(img, 0, 0, 8, 8)
+	group := func(cv *canvas.Canvas) { cv.FillRect(0, 0, 5, 5) }
+	cv.CacheGroup("g", group)
+	cv.CacheGroup("g", group)
+	cv.EndFrame()
+	st = cv.Stats()
+	if st.BlurPixels < 20*20 || st.GroupHits != 1 || st.GroupMisses != 1 {
+		t.Fatalf("Expected blur and cache group counters, got %+v", st)
+	}
+	if st.ImageCacheHits+st.ImageDecodes == 0 {
+		t.Fatalf("Expected image cache counters, got %+v", st)
+	}
+
+	cv.EndFrame()
+	if st := cv.Stats(); st != (canvas.RenderStats{}) {
+		t.Fatalf("Expected no stats for an empty frame, got %+v", st)
+	}
+}
+
+func TestDrawImageTransformed(t *testing.T) {
+	sprite := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	for i := range sprite.Pix {
+		sprite.Pix[i] = 255
+	}
+	sprite.SetRGBA(0, 0, color.RGBA{255, 0, 0, 255})
+
+	draw := func(fn func(cv *canvas.Canvas)) *image.RGBA {
+		backend := canvas.NewBackend(40, 40)
+		cv := canvas.New(backend)
+		cv.Translate(5, 0)
+		fn(cv)
+		cv.Flush()
+		return backend.Image
+	}
+
+	want := draw(func(cv *canvas.Canvas) {
+		cv.Save()
+		cv.Translate(20, 10)
+		cv.Rotate(math.Pi / 2)
+		cv.Scale(2, 2)
+		cv.SetGlobalAlpha(0.5)
+		cv.DrawImage(sprite, 0, 0, 8, 4, 0, 0, 8, 4)
+		cv.Restore()
+	})
+	got := draw(func(cv *canvas.Canvas) {
+		mat := canvas.BackendMatScale(canvas.BackendVec{2, 2}).
+			Mul(canvas.BackendMatRotate(math.Pi / 2)).
+			Mul(canvas.BackendMatTranslate(canvas.BackendVec{20, 10}))
+		cv.DrawImageTransformed(sprite, 0, 0, 8, 4, mat, 0.5)
+	})
+	if !bytes.Equal(want.Pix, got.Pix) {
+		t.Fatal("Expected DrawImageTransformed to match DrawImage with the same transformation")
+	}
+	if c := got.RGBAAt(24, 10); c.A == 0 {
+		t.Fatal("Expected the sprite to be drawn")
+	}
+
+	after := draw(func(cv *canvas.Canvas) {
+		cv.DrawImageTransformed(sprite, 0, 0, 8, 4, canvas.BackendMatScale(canvas.BackendVec{0, 0}), 1)
+		cv.FillRect(0, 0, 1, 1)
+	})
+	if c := after.RGBAAt(5, 0); c.A != 255 {
+		t.Fatal("Expected the transformation to be unchanged")
+	}
+}
+
+func TestDrawSprites(t *testing.T) {
+	sheet := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	draw.Draw(sheet, image.Rect(0, 0, 4, 4), image.NewUniform(color.RGBA{255, 255, 255, 255}), image.Point{}, draw.Src)
+	draw.Draw(sheet, image.Rect(4, 0, 8, 4), image.NewUniform(color.RGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+
+	sprites := []canvas.SpriteInstance{
+		{SX: 0, SY: 0, SW: 4, SH: 4, Transform: canvas.BackendMatTranslate(canvas.BackendVec{2, 2}), Alpha: 1, Tint: color.RGBA{255, 0, 0, 255}},
+		{SX: 4, SY: 0, SW: 4, SH: 4, Transform: canvas.BackendMatScale(canvas.BackendVec{2, 2}).Mul(canvas.BackendMatTranslate(canvas.BackendVec{10, 2})), Alpha: 0.5},
+		{Transform: canvas.BackendMatTranslate(canvas.BackendVec{2, 12}), Alpha: 1},
+	}
+
+	render := func(b canvas.Backend, fn func(cv *canvas.Canvas)) {
+		cv := canvas.New(b)
+		cv.Translate(1, 1)
+		fn(cv)
+		cv.Flush()
+	}
+
+	want := canvas.NewBackend(30, 30)
+	render(want, func(cv *canvas.Canvas) {
+		cv.DrawImageTinted(sheet, color.RGBA{255, 0, 0, 255}, 0, 0, 4, 4, 2, 2, 4, 4)
+		cv.DrawImageTransformed(sheet, 4, 0, 4, 4, sprites[1].Transform, 0.5)
+		cv.DrawImage(sheet, 2, 12)
+	})
+	got := canvas.NewBackend(30, 30)
+	render(got, func(cv *canvas.Canvas) { cv.DrawSprites(sheet, sprites) })
+	if !bytes.Equal(want.Image.Pix, got.Image.Pix) {
+		t.Fatal("Expected DrawSprites to match drawing the sprites one by one")
+	}
+	if c := got.Image.RGBAAt(4, 4); c != (color.RGBA{255, 0, 0, 255}) {
+		t.Fatalf("Expected a red tinted sprite, got %v", c)
+	}
+	if c := got.Image.RGBAAt(14, 6); c.B == 0 || c.A == 0 || c.A == 255 {
+		t.Fatalf("Expected a half transparent blue sprite, got %v", c)
+	}
+
+	sprites[0].Tint = color.RGBA{}
+	want = canvas.NewBackend(30, 30)
+	render(want, func(cv *canvas.Canvas) { cv.DrawSprites(sheet, sprites) })
+	fallback := canvas.NewBackend(30, 30)
+	render(struct{ canvas.Backend }{fallback}, func(cv *canvas.Canvas) { cv.DrawSprites(sheet, sprites) })
+	if !bytes.Equal(want.Image.Pix, fallback.Image.Pix) {
+		t.Fatal("Expected the fallback to match the software backend")
+	}
+}
+
+func TestTileMap(t *testing.T) {
+	tileset := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	draw.Draw(tileset, image.Rect(0, 0, 4, 4), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	draw.Draw(tileset, image.Rect(4, 0, 8, 4), image.NewUniform(color.RGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+
+	tm := canvas.NewTileMap(tileset, 4, 4, 100, 100)
+	for row := 0; row < 100; row++ {
+		for col := 0; col < 100; col++ {
+			tm.Set(col, row, 0)
+		}
+	}
+	tm.Set(1, 0, 1)
+	tm.Set(2, 0, -1)
+	if tm.Tile(1, 0) != 1 || tm.Tile(2, 0) != -1 || tm.Tile(100, 0) != -1 {
+		t.Fatal("Unexpected tiles")
+	}
+
+	backend := canvas.NewBackend(40, 40)
+	cv := canvas.New(backend)
+	tm.Draw(cv, 0, 0)
+	cv.Flush()
+	for _, c := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{1, 1, color.RGBA{255, 0, 0, 255}},
+		{5, 1, color.RGBA{0, 0, 255, 255}},
+		{9, 1, color.RGBA{}},
+		{39, 39, color.RGBA{255, 0, 0, 255}},
+	} {
+		if got := backend.Image.RGBAAt(c.x, c.y); got != c.want {
+			t.Fatalf("Expected %v at %d/%d, got %v", c.want, c.x, c.y, got)
+		}
+	}
+
+	// scaled with bilinear filtering, the red tiles must neither show
+	// gaps nor blend in the blue tile next to them in the tileset
+	backend = canvas.NewBackend(40, 40)
+	cv = canvas.New(backend)
+	cv.SetQuality(canvas.QualityMedium)
+	cv.Translate(-0.3, -0.3)
+	cv.Scale(2.7, 2.7)
+	tm.Draw(cv, 0, 4)
+	cv.Flush()
+	for y := 22; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			if c := backend.Image.RGBAAt(x, y); c != (color.RGBA{255, 0, 0, 255}) {
+				t.Fatalf("Expected red at %d/%d, got %v", x, y, c)
+			}
+		}
+	}
+}
+
+func TestDrawImageColorMatrix(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Pix = []byte{255, 0, 0, 255, 100, 200, 50, 255}
+
+	gray := canvas.ColorMatrixSaturation(0)
+	if c := gray.Apply(color.RGBA{255, 0, 0, 255}); c.R != c.G || c.G != c.B || c.R != 54 {
+		t.Fatalf("Expected red to turn dark gray, got %v", c)
+	}
+	if c := canvas.ColorMatrixIdentity.Apply(color.RGBA{1, 2, 3, 4}); c != (color.RGBA{1, 2, 3, 4}) {
+		t.Fatalf("Expected the identity to leave the color unchanged, got %v", c)
+	}
+	faded := gray.Then(canvas.ColorMatrixMultiply(color.RGBA{255, 255, 255, 128}))
+	if c := faded.Apply(color.RGBA{255, 0, 0, 255}); c != (color.RGBA{54, 54, 54, 128}) {
+		t.Fatalf("Expected a faded gray, got %v", c)
+	}
+
+	draw := func(backend canvas.Backend) *image.RGBA {
+		cv := canvas.New(backend)
+		cv.DrawImageColorMatrix(src, faded, 0, 0, 20, 10)
+		return cv.GetImageData(0, 0, 20, 10)
+	}
+	img := draw(canvas.NewBackend(20, 10))
+	if c := img.RGBAAt(5, 5); c.R != c.G || c.G != c.B || c.A == 0 || c.A == 255 {
+		t.Fatalf("Expected a half transparent gray pixel, got %v", c)
+	}
+
+	fallback := draw(struct{ canvas.Backend }{canvas.NewBackend(20, 10)})
+	if !bytes.Equal(img.Pix, fallback.Pix) {
+		t.Fatal("The fallback gave a different result")
+	}
+
+	rec := canvas.NewRecordingBackend(canvas.NewBackend(20, 10))
+	draw(rec)
+	target := canvas.NewBackend(20, 10)
+	rec.DisplayList().Replay(target)
+	if !bytes.Equal(img.Pix, target.Image.Pix) {
+		t.Fatal("Replaying the recording gave a different result")
+	}
+}
+
+func TestFillWithMask(t *testing.T) {
+	checker := image.NewAlpha(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if (x+y)%2 == 0 {
+				checker.SetAlpha(x, y, color.Alpha{A: 255})
+			}
+		}
+	}
+	am := canvas.LoadAlphaMask(checker)
+	if w, h := am.Size(); w != 8 || h != 8 {
+		t.Fatalf("Expected an 8x8 mask, got %dx%d", w, h)
+	}
+
+	backend := canvas.NewBackend(20, 10)
+	cv := canvas.New(backend)
+	cv.FillWithMask("#f00", am, 0, 0, 8, 8)
+	cv.FillWithMask("#00f", checker, 10, 0, 8, 8)
+	// scaled down, the smaller copy of the mask averages the pixels
+	cv.FillWithMask("#0f0", am, 0, 8, 2, 2)
+	cv.FillRect(19, 9, 1, 1)
+	cv.Flush()
+
+	for _, c := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 0, color.RGBA{255, 0, 0, 255}},
+		{1, 0, color.RGBA{}},
+		{10, 0, color.RGBA{0, 0, 255, 255}},
+		{11, 0, color.RGBA{}},
+		{19, 9, color.RGBA{0, 0, 0, 255}},
+	} {
+		if got := backend.Image.RGBAAt(c.x, c.y); got != c.want {
+			t.Fatalf("Expected %v at %d/%d, got %v", c.want, c.x, c.y, got)
+		}
+	}
+	if c := backend.Image.RGBAAt(0, 8); c.G == 0 || c.A == 0 || c.A == 255 {
+		t.Fatalf("Expected a half covered pixel, got %v", c)
+	}
+}
+
+func TestFloodFill(t *testing.T) {
+	draw := func(antialias bool) (*image.RGBA, int) {
+		backend := canvas.NewBackend(20, 10)
+		cv := canvas.New(backend)
+		cv.SetFillStyle("#fff")
+		cv.FillRect(0, 0, 20, 10)
+		cv.SetFillStyle("#000")
+		cv.FillRect(10, 0, 1, 10)
+		cv.Flush()
+		backend.Image.SetRGBA(10, 5, color.RGBA{128, 128, 128, 255})
+		backend.Image.SetRGBA(3, 3, color.RGBA{250, 250, 250, 255})
+		n := cv.FloodFill(2, 2, color.RGBA{255, 0, 0, 255}, 8, antialias)
+		return backend.Image, n
+	}
+
+	img, n := draw(false)
+	if n != 100 {
+		t.Fatalf("Expected 100 filled pixels, got %d", n)
+	}
+	for _, c := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 0, color.RGBA{255, 0, 0, 255}},
+		{3, 3, color.RGBA{255, 0, 0, 255}},
+		{9, 9, color.RGBA{255, 0, 0, 255}},
+		{10, 0, color.RGBA{0, 0, 0, 255}},
+		{10, 5, color.RGBA{128, 128, 128, 255}},
+		{15, 5, color.RGBA{255, 255, 255, 255}},
+	} {
+		if got := img.RGBAAt(c.x, c.y); got != c.want {
+			t.Fatalf("Expected %v at %d/%d, got %v", c.want, c.x, c.y, got)
+		}
+	}
+
+	img, _ = draw(true)
+	if c := img.RGBAAt(10, 0); c != (color.RGBA{0, 0, 0, 255}) {
+		t.Fatalf("Expected the black edge to stay black, got %v", c)
+	}
+	if c := img.RGBAAt(10, 5); c.R <= c.G || c.G < 32 || c.G > 96 {
+		t.Fatalf("Expected the gray edge to be blended with red, got %v", c)
+	}
+	if c := img.RGBAAt(15, 5); c != (color.RGBA{255, 255, 255, 255}) {
+		t.Fatalf("Expected the other side to be unchanged, got %v", c)
+	}
+}
+
+func TestHitRegionAt(t *testing.T) {
+	backend := canvas.NewBackend(60, 60)
+	cv := canvas.New(backend)
+	cv.SetQuality(canvas.QualityLow)
+	cv.Translate(0.3, 0.7)
+	cv.BeginPath()
+	cv.MoveTo(5, 3)
+	cv.LineTo(55, 20)
+	cv.LineTo(17, 51.5)
+	cv.ClosePath()
+	cv.Fill()
+	cv.AddHitRegion("triangle", nil)
+	cv.Flush()
+
+	// the region covers exactly the pixels that the fill covers
+	for y := 0; y < 60; y++ {
+		for x := 0; x < 60; x++ {
+			filled := backend.Image.RGBAAt(x, y).A != 0
+			hit := cv.HitRegionAt(float64(x)+0.9, float64(y)+0.1) == "triangle"
+			if filled != hit {
+				t.Fatalf("Pixel %d/%d is filled %v but hit %v", x, y, filled, hit)
+			}
+		}
+	}
+
+	path := cv.NewPath2D()
+	path.Rect(10, 10, 10, 10)
+	cv.AddHitRegion("square", path)
+	if id := cv.HitRegionAt(15, 15); id != "square" {
+		t.Fatalf("Expected the topmost region, got %q", id)
+	}
+	cv.RemoveHitRegion("square")
+	if id := cv.HitRegionAt(15, 15); id != "triangle" {
+		t.Fatalf("Expected the triangle after removal, got %q", id)
+	}
+	if id := cv.HitRegionAt(58, 58); id != "" {
+		t.Fatalf("Expected no region, got %q", id)
+	}
+}
+
+func TestPickingBackend(t *testing.T) {
+	pb := canvas.NewPickingBackend(canvas.NewBackend(40, 40))
+	cv := canvas.New(pb)
+
+	cv.SetObjectID(1)
+	cv.SetFillStyle("#f00")
+	cv.FillRect(0, 0, 20, 20)
+
+	// a nearly transparent shape still owns its pixels, its shadow doesn't
+	cv.SetObjectID(2)
+	cv.SetFillStyle(0, 0, 255, 0.05)
+	cv.SetShadowColor("#000")
+	cv.SetShadowOffset(12, 0)
+	cv.FillRect(10, 10, 10, 10)
+	cv.SetShadowColor(color.RGBA{})
+
+	sprite := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	sprite.Pix = []byte{0, 255, 0, 255, 0, 0, 0, 0}
+	cv.SetObjectID(3)
+	cv.DrawImage(sprite, 0, 30, 20, 10)
+
+	cv.SetObjectID(4)
+	cv.BeginPath()
+	cv.Rect(30, 0, 10, 40)
+	cv.Clip()
+	cv.FillRect(0, 0, 40, 5)
+
+	for _, c := range []struct {
+		x, y int
+		want uint32
+	}{
+		{5, 5, 1},
+		{15, 15, 2},
+		{25, 15, 0},
+		{5, 35, 3},
+		{15, 35, 0},
+		{5, 2, 1},
+		{35, 2, 4},
+		{35, 20, 0},
+	} {
+		if id := cv.ObjectIDAt(c.x, c.y); id != c.want {
+			t.Fatalf("Expected ID %d at %d/%d, got %d", c.want, c.x, c.y, id)
+		}
+	}
+
+	if id := canvas.New(canvas.NewBackend(10, 10)).ObjectIDAt(5, 5); id != 0 {
+		t.Fatalf("Expected 0 without a picking buffer, got %d", id)
+	}
+}
+
+func TestRedrawScheduler(t *testing.T) {
+	backend := canvas.NewBackend(100, 100)
+	cv := canvas.New(backend)
+	fill := "#f00"
+	var calls []image.Rectangle
+	rs := canvas.NewRedrawScheduler(cv, func(cv *canvas.Canvas, region image.Rectangle) {
+		calls = append(calls, region)
+		cv.SetFillStyle(fill)
+		cv.FillRect(0, 0, 100, 100)
+	})
+
+	if regions := rs.Frame(); len(regions) != 1 || regions[0] != image.Rect(0, 0, 100, 100) {
+		t.Fatalf("Expected the first frame to redraw everything, got %v", regions)
+	}
+	if rs.Pending() || rs.Frame() != nil {
+		t.Fatal("Expected nothing to redraw")
+	}
+
+	rs.Invalidate(image.Rect(5, 5, 10, 10))
+	rs.Invalidate(image.Rect(8, 8, 12, 12))
+	rs.Invalidate(image.Rect(90, 90, 120, 120))
+	select {
+	case <-rs.Changed():
+	default:
+		t.Fatal("Expected a change notification")
+	}
+	want := []image.Rectangle{image.Rect(5, 5, 12, 12), image.Rect(90, 90, 100, 100)}
+	if regions := rs.Regions(); !reflect.DeepEqual(regions, want) {
+		t.Fatalf("Expected %v, got %v", want, regions)
+	}
+
+	fill = "#00f"
+	calls = nil
+	if regions := rs.Frame(); !reflect.DeepEqual(regions, want) || !reflect.DeepEqual(calls, want) {
+		t.Fatalf("Expected %v to be redrawn, got %v and %v", want, regions, calls)
+	}
+	for _, c := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{6, 6, color.RGBA{0, 0, 255, 255}},
+		{11, 11, color.RGBA{0, 0, 255, 255}},
+		{95, 95, color.RGBA{0, 0, 255, 255}},
+		{50, 50, color.RGBA{255, 0, 0, 255}},
+		{12, 12, color.RGBA{255, 0, 0, 255}},
+	} {
+		if got := backend.Image.RGBAAt(c.x, c.y); got != c.want {
+			t.Fatalf("Expected %v at %d/%d, got %v", c.want, c.x, c.y, got)
+		}
+	}
+
+	// many small regions close to each other are redrawn together
+	for i := 0; i < 30; i++ {
+		rs.Invalidate(image.Rect(i*3, 50, i*3+1, 51))
+	}
+	if regions := rs.Regions(); len(regions) != 1 || regions[0] != image.Rect(0, 50, 88, 51) {
+		t.Fatalf("Expected one merged region, got %v", regions)
+	}
+}
+
+func TestSubImage(t *testing.T) {
+	sheet := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	draw.Draw(sheet, image.Rect(0, 0, 4, 4), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	draw.Draw(sheet, image.Rect(4, 0, 8, 4), image.NewUniform(color.RGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+
+	render := func(b canvas.Backend, fn func(cv *canvas.Canvas)) {
+		cv := canvas.New(b)
+		fn(cv)
+		cv.Flush()
+	}
+
+	want := canvas.NewBackend(20, 20)
+	render(want, func(cv *canvas.Canvas) {
+		cv.DrawImage(sheet, 4, 0, 4, 4, 2, 2, 8, 8)
+		cv.DrawImage(sheet, 5, 1, 2, 2, 12, 12, 4, 4)
+		cv.DrawImageTinted(sheet, color.RGBA{0, 255, 0, 255}, 4, 0, 4, 4, 2, 12, 4, 4)
+	})
+	for i := 0; i < 3; i++ {
+		got := canvas.NewBackend(20, 20)
+		var b canvas.Backend = got
+		switch i {
+		case 1:
+			b = struct{ canvas.Backend }{got}
+		case 2:
+			b = plainImageBackend{got}
+		}
+		render(b, func(cv *canvas.Canvas) {
+			img, err := cv.LoadImage(sheet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			blue := img.SubImage(image.Rect(4, 0, 8, 4))
+			if w, h := blue.Size(); w != 4 || h != 4 {
+				t.Fatalf("Expected a 4x4 sub-image, got %dx%d", w, h)
+			}
+			cv.DrawImage(blue, 2, 2, 8, 8)
+			cv.DrawImage(blue.SubImage(image.Rect(1, 1, 3, 3)), 12, 12, 4, 4)
+			cv.DrawImageTinted(blue, color.RGBA{0, 255, 0, 255}, 2, 12)
+		})
+		if !bytes.Equal(want.Image.Pix, got.Image.Pix) {
+			t.Fatal("Expected the sub-image to draw the same as source coordinates")
+		}
+	}
+
+	bimg, _ := want.LoadImage(sheet)
+	sub := bimg.(canvas.SubImageBackendImage).SubImage(image.Rect(2, 2, 10, 10))
+	if r := sub.(*canvas.BackendSubImage).Rect(); r != image.Rect(2, 2, 8, 4) {
+		t.Fatalf("Expected the sub-image to be clipped, got %v", r)
+	}
+	if !errors.Is(sub.Replace(sheet), canvas.ErrSubImageReplace) {
+		t.Fatal("Expected an error replacing a sub-image")
+	}
+}
+
+// plainImageBackend returns images that don't implement
+// SubImageBackendImage, so that the canvas has to use BackendSubImage
+type plainImageBackend struct {
+	canvas.Backend
+}
+
+type plainImage struct {
+	canvas.BackendImage
+}
+
+func (b plainImageBackend) LoadImage(img image.Image) (canvas.BackendImage, error) {
+	bimg, err := b.Backend.LoadImage(img)
+	if err != nil {
+		return nil, err
+	}
+	return plainImage{bimg}, nil
+}
+
+func (b plainImageBackend) DrawImage(dimg canvas.BackendImage, sx, sy, sw, sh float64, pts [4]canvas.BackendVec, alpha float64) {
+	b.Backend.DrawImage(dimg.(plainImage).BackendImage, sx, sy, sw, sh, pts, alpha)
+}
+
+func TestDrawNinePatch(t *testing.T) {
+	// a 6x6 image with a 2 pixel red border around a blue center
+	img := image.NewRGBA(image.Rect(0, 0, 6, 6))
+	draw.Draw(img, img.Rect, image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(2, 2, 4, 4), image.NewUniform(color.RGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+
+	b := canvas.NewBackend(30, 20)
+	cv := canvas.New(b)
+	cv.SetQuality(canvas.QualityLow)
+	cv.DrawNinePatch(img, 2, 2, 2, 2, 0, 0, 30, 20)
+	cv.Flush()
+	got := b.Image
+	for _, tc := range []struct {
+		x, y int
+		c    color.RGBA
+	}{
+		{0, 0, color.RGBA{255, 0, 0, 255}},
+		{1, 10, color.RGBA{255, 0, 0, 255}},
+		{15, 18, color.RGBA{255, 0, 0, 255}},
+		{28, 19, color.RGBA{255, 0, 0, 255}},
+		{2, 2, color.RGBA{0, 0, 255, 255}},
+		{15, 10, color.RGBA{0, 0, 255, 255}},
+		{27, 17, color.RGBA{0, 0, 255, 255}},
+	} {
+		if c := got.RGBAAt(tc.x, tc.y); c != tc.c {
+			t.Fatalf("Expected %v at %d,%d, got %v", tc.c, tc.x, tc.y, c)
+		}
+	}
+}
+
+func TestLoadImageBytes(t *testing.T) {
+	data := rotatedJPEG(t)
+	b := canvas.NewBackend(32, 16)
+	cv := canvas.New(b)
+	img, err := cv.LoadImageBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := img.Size(); w != 32 || h != 16 {
+		t.Fatalf("Expected the image to be rotated to 32x16, got %dx%d", w, h)
+	}
+	cv.DrawImage(img, 0, 0)
+	cv.Flush()
+	if c := b.Image.RGBAAt(4, 8); c.R < 200 || c.B > 50 {
+		t.Fatalf("Expected red on the left, got %v", c)
+	}
+	if c := b.Image.RGBAAt(28, 8); c.B < 200 || c.R > 50 {
+		t.Fatalf("Expected blue on the right, got %v", c)
+	}
+}
+
+// hugePNG returns a PNG file with a header that claims the size, but
+// only the pixels of a 1x1 image
+func hugePNG(w, h int) []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)))
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:], uint32(w))
+	binary.BigEndian.PutUint32(data[20:], uint32(h))
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestLoadImageTooLarge(t *testing.T) {
+	cv := canvas.New(canvas.NewBackend(10, 10))
+	old := canvas.SizeLimits
+	defer func() { canvas.SizeLimits = old }()
+	canvas.SizeLimits.MaxBytes = 1 << 20
+	for _, size := range [][2]int{{100000, 1}, {1000, 1000}} {
+		_, err := cv.LoadImageBytes(hugePNG(size[0], size[1]))
+		if err == nil || !strings.Contains(err.Error(), "exceeds") {
+			t.Errorf("Expected a size error for %dx%d, got %v", size[0], size[1], err)
+		}
+	}
+}
+
+// rotatedJPEG returns a 32x16 JPEG image with a red left and a blue right
+// half, stored rotated by 90° counterclockwise with an EXIF orientation
+// of 6
+func rotatedJPEG(t *testing.T) []byte {
+	stored := image.NewRGBA(image.Rect(0, 0, 16, 32))
+	draw.Draw(stored, image.Rect(0, 0, 16, 16), image.NewUniform(color.RGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+	draw.Draw(stored, image.Rect(0, 16, 16, 32), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, stored, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	exif := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00")
+	app1 := append([]byte{0xFF, 0xE1, 0, byte(len(exif) + 2)}, exif...)
+	return append(append([]byte{0xFF, 0xD8}, app1...), buf.Bytes()[2:]...)
+}
+
+func TestImageBudget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "canvas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	colors := []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}}
+	var files []string
+	for i, c := range colors {
+		img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+		draw.Draw(img, img.Rect, image.NewUniform(c), image.Point{}, draw.Src)
+		var buf bytes.Buffer
+		png.Encode(&buf, img)
+		name := fmt.Sprintf("%s/%d.png", dir, i)
+		if err := ioutil.WriteFile(name, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, name)
+	}
+
+	b := canvas.NewBackend(30, 10)
+	cv := canvas.New(b)
+	cv.SetImageBudget(2 * 10 * 10 * 4)
+	var images []*canvas.Image
+	for _, name := range files {
+		img, err := cv.LoadImageLazy(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w, h := img.Size(); w != 10 || h != 10 {
+			t.Fatalf("Expected the size of a lazy image, got %dx%d", w, h)
+		}
+		images = append(images, img)
+	}
+	if s := cv.ImageCacheStats(); s.Images != 3 || s.Decoded != 0 || s.Decodes != 0 {
+		t.Fatalf("Expected no image to be decoded before drawing, got %+v", s)
+	}
+
+	for i, img := range images {
+		cv.DrawImage(img, float64(i*10), 0)
+	}
+	s := cv.ImageCacheStats()
+	if s.Decoded != 2 || s.Decodes != 3 || s.Evictions != 1 || s.Bytes != 800 {
+		t.Fatalf("Expected the first image to be evicted, got %+v", s)
+	}
+
+	// the evicted image is decoded again and evicts the next one
+	cv.ClearRect(0, 0, 30, 10)
+	for i, img := range images {
+		cv.DrawImage(img, float64(i*10), 0)
+	}
+	cv.Flush()
+	for i, c := range colors {
+		if got := b.Image.RGBAAt(i*10+5, 5); got != c {
+			t.Fatalf("Expected %v for image %d, got %v", c, i, got)
+		}
+	}
+	if s := cv.ImageCacheStats(); s.Decodes != 6 || s.Decoded != 2 {
+		t.Fatalf("Expected every image to be decoded again, got %+v", s)
+	}
+
+	cv.DrawImage(files[2], 0, 0)
+	if s := cv.ImageCacheStats(); s.Decodes != 6 || s.Hits == 0 {
+		t.Fatalf("Expected the cached image to be used by its file name, got %+v", s)
+	}
+}
+
+func TestLoadImageYUV(t *testing.T) {
+	// a 4x2 4:2:0 frame with a red left and a white right half, with
+	// padded rows
+	format := canvas.YUVFormat{Width: 4, Height: 2, Subsampling: image.YCbCrSubsampleRatio420, YStride: 6, CStride: 3}
+	y := []byte{81, 81, 235, 235, 0, 0, 81, 81, 235, 235, 0, 0}
+	u := []byte{90, 128, 0}
+	v := []byte{240, 128, 0}
+
+	for _, fallback := range []bool{false, true} {
+		b := canvas.NewBackend(4, 2)
+		var backend canvas.Backend = b
+		if fallback {
+			backend = struct{ canvas.Backend }{b}
+		}
+		cv := canvas.New(backend)
+		cv.SetQuality(canvas.QualityLow)
+		img, err := cv.LoadImageYUV(y, u, v, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cv.DrawImage(img, 0, 0)
+		cv.Flush()
+		if c := b.Image.RGBAAt(0, 1); c.R < 250 || c.G > 5 || c.B > 5 || c.A != 255 {
+			t.Fatalf("Expected red, got %v", c)
+		}
+		if c := b.Image.RGBAAt(3, 0); c != (color.RGBA{255, 255, 255, 255}) {
+			t.Fatalf("Expected white, got %v", c)
+		}
+
+		// the next frame is black
+		for i := range y {
+			y[i] = 16
+		}
+		if err := img.ReplaceYUV(y, []byte{128, 128, 0}, []byte{128, 128, 0}, format); err != nil {
+			t.Fatal(err)
+		}
+		cv.DrawImage(img, 0, 0)
+		cv.Flush()
+		if c := b.Image.RGBAAt(2, 1); c != (color.RGBA{0, 0, 0, 255}) {
+			t.Fatalf("Expected black, got %v", c)
+		}
+		y = []byte{81, 81, 235, 235, 0, 0, 81, 81, 235, 235, 0, 0}
+	}
+
+	cv := canvas.New(canvas.NewBackend(4, 2))
+	if _, err := cv.LoadImageYUV(y[:8], u, v, format); err == nil {
+		t.Fatal("Expected an error for a short plane")
+	}
+}
+
+func TestImageTypeFastPaths(t *testing.T) {
+	rect := image.Rect(0, 0, 8, 8)
+	paletted := image.NewPaletted(rect, palette.WebSafe)
+	paletted.Palette = append(paletted.Palette, color.NRGBA{255, 128, 0, 100})
+	nrgba := image.NewNRGBA(rect)
+	gray := image.NewGray(rect)
+	alpha := image.NewAlpha(rect)
+	for i := range nrgba.Pix {
+		nrgba.Pix[i] = uint8(i * 37)
+	}
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(i * 3)
+		gray.Pix[i] = uint8(i * 5)
+		alpha.Pix[i] = uint8(i * 11)
+	}
+
+	render := func(img image.Image) []byte {
+		b := canvas.NewBackend(24, 24)
+		cv := canvas.New(b)
+		cv.SetQuality(canvas.QualityLow)
+		cv.DrawImage(img, 0, 0)
+		cv.DrawImage(img, 8, 0, 16, 16)
+		cv.DrawImage(img, 0, 16, 4, 4)
+		cv.Flush()
+		return b.Image.Pix
+	}
+	for _, img := range []image.Image{paletted, nrgba, gray, alpha} {
+		// the anonymous struct hides the type, so that At is used
+		if !bytes.Equal(render(img), render(struct{ image.Image }{img})) {
+			t.Fatalf("Expected the fast path for %T to match At", img)
+		}
+	}
+}
+
+func TestGetImageDataInto(t *testing.T) {
+	b := canvas.NewBackend(10, 10)
+	cv := canvas.New(b)
+	cv.SetFillStyle("#F00")
+	cv.FillRect(0, 0, 10, 10)
+
+	data := cv.GetImageData(2, 2, 4, 4)
+	cv.SetFillStyle("#00F")
+	cv.FillRect(0, 0, 10, 10)
+	if c := data.RGBAAt(3, 3); c != (color.RGBA{255, 0, 0, 255}) {
+		t.Fatalf("Expected GetImageData to return a copy, got %v", c)
+	}
+
+	// a 4x3 read at 8/-1 with padded rows only partially overlaps the canvas
+	const stride = 20
+	for _, fallback := range []bool{false, true} {
+		var backend canvas.Backend = b
+		if fallback {
+			backend = struct{ canvas.Backend }{b}
+		}
+		cv := canvas.New(backend)
+		buf := make([]byte, stride*3)
+		for i := range buf {
+			buf[i] = 7
+		}
+		if err := cv.GetImageDataInto(buf, 8, -1, 4, 3, stride); err != nil {
+			t.Fatal(err)
+		}
+		for row := 0; row < 3; row++ {
+			for col := 0; col < 4; col++ {
+				p := buf[row*stride+col*4:][:4]
+				want := []byte{0, 0, 0, 0}
+				if row > 0 && col < 2 {
+					want = []byte{0, 0, 255, 255}
+				}
+				if !bytes.Equal(p, want) {
+					t.Fatalf("Expected %v at %d,%d, got %v", want, col, row, p)
+				}
+			}
+			if buf[row*stride+16] != 7 {
+				t.Fatal("Expected the padding to be left alone")
+			}
+		}
+	}
+
+	buf := make([]byte, 10*10*4)
+	if allocs := testing.AllocsPerRun(10, func() { cv.GetImageDataInto(buf, 0, 0, 10, 10, 40) }); allocs != 0 {
+		t.Fatalf("Expected no allocations, got %v", allocs)
+	}
+	if err := cv.GetImageDataInto(buf[:100], 0, 0, 10, 10, 40); err == nil {
+		t.Fatal("Expected an error for a small buffer")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	b := canvas.NewBackend(200, 100)
+	cv := canvas.New(b)
+	cv.SetFillStyle("#F00")
+	cv.FillRect(0, 0, 200, 100)
+	want := cv.GetImageData(0, 0, 200, 100)
+
+	snap := b.Snapshot()
+	cv.SetFillStyle("#00F")
+	cv.FillRect(10, 10, 20, 20)
+	cv.ClearRect(150, 50, 10, 10)
+	cv.PutImageData(image.NewRGBA(image.Rect(0, 0, 5, 5)), 100, 90)
+	cv.MapPixels(image.Rect(70, 0, 75, 5), func(x, y int, c color.RGBA) color.RGBA { return color.RGBA{} })
+	if c := b.Image.RGBAAt(15, 15); c != (color.RGBA{0, 0, 255, 255}) {
+		t.Fatalf("Expected the backend to change, got %v", c)
+	}
+	if !bytes.Equal(snap.Image().Pix, want.Pix) {
+		t.Fatal("Expected the snapshot to keep the content at the time it was taken")
+	}
+	if c := snap.RGBAAt(155, 55); c != (color.RGBA{255, 0, 0, 255}) {
+		t.Fatalf("Expected red in the snapshot, got %v", c)
+	}
+
+	// drawing the snapshot back restores the content
+	cv.DrawImage(snap, 0, 0)
+	cv.Flush()
+	if !bytes.Equal(b.Image.Pix, want.Pix) {
+		t.Fatal("Expected drawing the snapshot to restore the content")
+	}
+	snap.Release()
+
+	// a released snapshot no longer costs copies
+	snap = b.Snapshot()
+	snap.Release()
+	if allocs := testing.AllocsPerRun(10, func() { b.ClearPixelRect(image.Rect(0, 0, 200, 100)) }); allocs != 0 {
+		t.Fatalf("Expected no copies after Release, got %v allocations", allocs)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	for _, fallback := range []bool{false, true} {
+		b := canvas.NewBackend(200, 100)
+		var backend canvas.Backend = b
+		if fallback {
+			backend = struct{ canvas.Backend }{b}
+		}
+		cv := canvas.New(backend)
+		cv.SetFillStyle("#FFF")
+		cv.FillRect(0, 0, 200, 100)
+		white := cv.GetImageData(0, 0, 200, 100)
+
+		h := canvas.NewHistory(cv, 0)
+		h.Group(func(cv *canvas.Canvas) {
+			cv.SetFillStyle("#F00")
+			cv.FillRect(10, 10, 20, 20)
+		})
+		red := cv.GetImageData(0, 0, 200, 100)
+		h.Begin()
+		cv.SetFillStyle("#00F")
+		cv.FillRect(150, 50, 30, 30)
+		h.End()
+		h.Group(func(cv *canvas.Canvas) {})
+		if !h.CanUndo() || h.CanRedo() {
+			t.Fatal("Expected changes to undo")
+		}
+		// only the tiles with changes are kept
+		if n := h.Bytes(); n != 2*(64*64*4)+2*(64*64*4+64*36*4) {
+			t.Fatalf("Expected the changed tiles before and after, got %d bytes", n)
+		}
+
+		h.Undo()
+		if got := cv.GetImageData(0, 0, 200, 100); !bytes.Equal(got.Pix, red.Pix) {
+			t.Fatal("Expected the blue rectangle to be undone")
+		}
+		h.Undo()
+		if got := cv.GetImageData(0, 0, 200, 100); !bytes.Equal(got.Pix, white.Pix) {
+			t.Fatal("Expected the red rectangle to be undone")
+		}
+		if h.Undo() {
+			t.Fatal("Expected nothing more to undo")
+		}
+		h.Redo()
+		if got := cv.GetImageData(0, 0, 200, 100); !bytes.Equal(got.Pix, red.Pix) {
+			t.Fatal("Expected the red rectangle to be redone")
+		}
+
+		// a new group drops the redo steps, and the cap drops the oldest
+		h.MaxBytes = 3 * 64 * 64 * 4
+		h.Group(func(cv *canvas.Canvas) {
+			cv.SetFillStyle("#0F0")
+			cv.FillRect(100, 0, 10, 10)
+		})
+		if h.CanRedo() {
+			t.Fatal("Expected no redo after a new group")
+		}
+		h.Undo()
+		if h.Undo() {
+			t.Fatal("Expected the oldest group to be dropped by the memory cap")
+		}
+	}
+}
+
+func TestStateBlob(t *testing.T) {
+	setup := func(cv *canvas.Canvas) {
+		cv.SetFillStyle("#FFF")
+		cv.FillRect(0, 0, 100, 100)
+		cv.Save()
+		cv.BeginPath()
+		cv.Arc(50, 50, 40, 0, math.Pi*2, false)
+		cv.Clip()
+		cv.Translate(10, 5)
+		grad := cv.CreateLinearGradient(0, 0, 100, 0)
+		grad.AddColorStop(0, "#F00")
+		grad.AddColorStop(1, "#00F8")
+		cv.SetStrokeStyle(grad)
+		cv.Save()
+		cv.Rotate(0.3)
+		cv.SetLineWidth(6)
+		cv.SetLineDash([]float64{8, 4})
+		cv.SetShadowColor("#0008")
+		cv.SetShadowOffset(3, 2)
+		pattern := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		pattern.Pix[3], pattern.Pix[4*4+1], pattern.Pix[4*4+3] = 255, 200, 128
+		cv.SetFillStyle(cv.CreatePattern(pattern, canvas.Repeat))
+	}
+	draw := func(cv *canvas.Canvas) {
+		cv.FillRect(10, 10, 40, 30)
+		cv.StrokeRect(20, 20, 50, 40)
+		cv.Restore()
+		cv.StrokeRect(5, 60, 60, 20)
+		cv.Restore()
+		cv.FillRect(0, 90, 100, 10)
+	}
+
+	cv := canvas.New(canvas.NewBackend(100, 100))
+	setup(cv)
+	blob, err := cv.SaveStateBlob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	draw(cv)
+	want := cv.GetImageData(0, 0, 100, 100)
+
+	cv2 := canvas.New(canvas.NewBackend(100, 100))
+	if err := cv2.LoadStateBlob(blob); err != nil {
+		t.Fatal(err)
+	}
+	draw(cv2)
+	if got := cv2.GetImageData(0, 0, 100, 100); !bytes.Equal(got.Pix, want.Pix) {
+		t.Fatal("Expected the restored canvas to draw the same as the original")
+	}
+
+	if err := canvas.New(canvas.NewBackend(50, 50)).LoadStateBlob(blob); err == nil {
+		t.Fatal("Expected an error for a different size")
+	}
+	for _, n := range []int{0, 10, len(blob) / 2, len(blob) - 1} {
+		if err := cv2.LoadStateBlob(blob[:n]); err == nil {
+			t.Fatalf("Expected an error for a blob cut to %d bytes", n)
+		}
+	}
+
+	// the line dash of [8, 4] is followed by the dash position
+	var dash []byte
+	for _, v := range []uint64{2, math.Float64bits(8), math.Float64bits(4)} {
+		dash = append(dash, make([]byte, 8)...)
+		binary.LittleEndian.PutUint64(dash[len(dash)-8:], v)
+	}
+	dash = append(dash[:4], dash[8:]...)
+	pos := bytes.Index(blob, dash)
+	if pos < 0 {
+		t.Fatal("Expected the line dash in the blob")
+	}
+	for name, change := range map[string]func(b []byte){
+		"dash position": func(b []byte) { binary.LittleEndian.PutUint32(b[pos+len(dash):], 7) },
+		"zero dash":     func(b []byte) { copy(b[pos+4:], make([]byte, 16)) },
+		"negative dash": func(b []byte) { binary.LittleEndian.PutUint64(b[pos+4:], math.Float64bits(-8)) },
+		"quality":       func(b []byte) { b[13+100*100*4] = 200 },
+	} {
+		broken := append([]byte(nil), blob...)
+		change(broken)
+		if err := cv2.LoadStateBlob(broken); err == nil {
+			t.Errorf("Expected an error for an invalid %s", name)
+		}
+	}
+	cv2.StrokeRect(10, 10, 20, 20)
+}
+
+func TestGradientDither(t *testing.T) {
+	// 16 levels over 256 pixels, so every level is a band of 16 pixels
+	draw := func(set func(lg *canvas.LinearGradient), seed int64) *canvas.SoftwareBackend {
+		backend := canvas.NewBackend(300, 8)
+		backend.DitherSeed = seed
+		cv := canvas.New(backend)
+		lg := cv.CreateLinearGradient(0, 0, 256, 0)
+		lg.AddColorStop(0, "#000")
+		lg.AddColorStop(1, "#101010")
+		set(lg)
+		cv.SetFillStyle(lg)
+		cv.FillRect(0, 0, 300, 8)
+		return backend
+	}
+	// mean returns the average red value of the 8 pixel wide block at x
+	mean := func(b *canvas.SoftwareBackend, x int) float64 {
+		var sum int
+		for y := 0; y < 8; y++ {
+			for i := x; i < x+8; i++ {
+				sum += int(b.Image.RGBAAt(i, y).R)
+			}
+		}
+		return float64(sum) / 64
+	}
+
+	plain := draw(func(lg *canvas.LinearGradient) {}, 0)
+	for x := 16; x < 256; x += 16 {
+		want := plain.Image.RGBAAt(x, 0).R
+		for i := x - 6; i < x+6; i++ {
+			if c := plain.Image.RGBAAt(i, 3).R; c != want {
+				t.Fatalf("Expected a band of %d at %d without dithering, got %d", want, i, c)
+			}
+		}
+	}
+
+	for name, tol := range map[string]float64{"ordered": 0.1, "noise": 0.3} {
+		b := draw(func(lg *canvas.LinearGradient) {
+			if name == "ordered" {
+				lg.SetDither(canvas.DitherOrdered)
+			} else {
+				lg.SetDither(canvas.DitherNoise)
+			}
+		}, 0)
+		for x := 8; x < 248; x += 8 {
+			exact := 16 * (float64(x) + 4) / 256
+			if m := mean(b, x); math.Abs(m-exact) > tol {
+				t.Errorf("Expected an average of %.2f at %d with %s dithering, got %.2f", exact, x, name, m)
+			}
+		}
+		for x := 260; x < 300; x++ {
+			if c := b.Image.RGBAAt(x, 5); c != (color.RGBA{16, 16, 16, 255}) {
+				t.Fatalf("Expected the last stop color at %d with %s dithering, got %v", x, name, c)
+			}
+		}
+	}
+
+	// the noise depends only on the seed
+	noise := func(lg *canvas.LinearGradient) { lg.SetDither(canvas.DitherNoise) }
+	if a, b := draw(noise, 1), draw(noise, 1); !bytes.Equal(a.Image.Pix, b.Image.Pix) {
+		t.Fatal("Expected the same noise with the same seed")
+	}
+	if a, b := draw(noise, 1), draw(noise, 2); bytes.Equal(a.Image.Pix, b.Image.Pix) {
+		t.Fatal("Expected different noise with a different seed")
+	}
+}
+
+func TestHairlines(t *testing.T) {
+	backend := canvas.NewBackend(20, 12)
+	backend.Hairlines = true
+	cv := canvas.New(backend)
+	cv.SetStrokeStyle("#000")
+	cv.BeginPath()
+	cv.MoveTo(0, 2.5)
+	cv.LineTo(10, 2.5)
+	cv.Stroke()
+	for x := 0; x < 12; x++ {
+		want := uint8(0)
+		if x < 10 {
+			want = 255
+		}
+		if a := backend.Image.RGBAAt(x, 2).A; a != want {
+			t.Errorf("Expected alpha %d at %d,2, got %d", want, x, a)
+		}
+		if a := backend.Image.RGBAAt(x, 1).A + backend.Image.RGBAAt(x, 3).A; a != 0 {
+			t.Errorf("Expected nothing above and below %d,2, got alpha %d", x, a)
+		}
+	}
+
+	// the coverage of every column of a diagonal line adds up to a pixel
+	backend = canvas.NewBackend(20, 12)
+	backend.Hairlines = true
+	cv = canvas.New(backend)
+	cv.SetFillStyle("#FFF")
+	cv.FillRect(0, 0, 20, 12)
+	cv.SetStrokeStyle("#000")
+	cv.BeginPath()
+	cv.MoveTo(0, 1)
+	cv.LineTo(20, 11)
+	cv.Stroke()
+	for x := 1; x < 19; x++ {
+		var sum int
+		for y := 0; y < 12; y++ {
+			sum += 255 - int(backend.Image.RGBAAt(x, y).R)
+		}
+		if sum < 250 || sum > 260 {
+			t.Errorf("Expected a coverage of about 255 in column %d, got %d", x, sum)
+		}
+	}
+
+	// wider lines are filled as usual
+	backend = canvas.NewBackend(20, 12)
+	backend.Hairlines = true
+	cv = canvas.New(backend)
+	cv.SetStrokeStyle("#000")
+	cv.SetLineWidth(3)
+	cv.BeginPath()
+	cv.MoveTo(0, 5.5)
+	cv.LineTo(10, 5.5)
+	cv.Stroke()
+	for y := 4; y <= 6; y++ {
+		if a := backend.Image.RGBAAt(5, y).A; a != 255 {
+			t.Errorf("Expected a filled wide line at 5,%d, got alpha %d", y, a)
+		}
+	}
+}
+
+func TestPolygons(t *testing.T) {
+	backend := canvas.NewBackend(40, 20)
+	cv := canvas.New(backend)
+	cv.SetFillStyle("#F00")
+	cv.BeginPath()
+	cv.Rect(30, 10, 10, 10)
+
+	// the second square starts where the first one ends
+	cv.FillPolygons([][]canvas.BackendVec{
+		{{0, 0}, {10, 0}, {10, 10}, {0, 10}},
+		{{0, 10}, {10, 10}, {10, 20}, {0, 20}},
+	})
+	cv.FillPolygon([]canvas.BackendVec{{20, 0}, {30, 0}, {20, 10}})
+	red := color.RGBA{255, 0, 0, 255}
+	for _, pt := range []image.Point{{5, 5}, {5, 15}, {22, 2}} {
+		if c := backend.Image.RGBAAt(pt.X, pt.Y); c != red {
+			t.Errorf("Expected %v at %v, got %v", red, pt, c)
+		}
+	}
+	if c := backend.Image.RGBAAt(28, 8); c.A != 0 {
+		t.Errorf("Expected nothing outside of the triangle, got %v", c)
+	}
+
+	// the current path is kept
+	cv.SetFillStyle("#00F")
+	cv.Fill()
+	if c := backend.Image.RGBAAt(35, 15); c != (color.RGBA{0, 0, 255, 255}) {
+		t.Errorf("Expected the current path to be filled, got %v", c)
+	}
+
+	cv.SetStrokeStyle("#0F0")
+	cv.SetLineWidth(2)
+	cv.StrokePolyline([]canvas.BackendVec{{12, 2}, {18, 2}, {18, 8}})
+	cv.StrokePolylines([][]canvas.BackendVec{{{12, 12}, {18, 12}}, {{12, 16}, {18, 16}}})
+	green := color.RGBA{0, 255, 0, 255}
+	for _, pt := range []image.Point{{15, 1}, {15, 2}, {17, 5}, {15, 11}, {15, 15}} {
+		if c := backend.Image.RGBAAt(pt.X, pt.Y); c != green {
+			t.Errorf("Expected %v at %v, got %v", green, pt, c)
+		}
+	}
+	if c := backend.Image.RGBAAt(15, 5); c.A != 0 {
+		t.Errorf("Expected the polyline not to be closed, got %v at 15,5", c)
+	}
+}
+
+func TestDrawMarkers(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	check := func(backend *canvas.SoftwareBackend) {
+		t.Helper()
+		for _, pt := range []image.Point{{3, 3}, {6, 6}, {13, 3}, {16, 6}} {
+			if c := backend.Image.RGBAAt(pt.X, pt.Y); c != red {
+				t.Errorf("Expected %v at %v, got %v", red, pt, c)
+			}
+		}
+		for _, pt := range []image.Point{{2, 5}, {7, 5}, {10, 5}, {5, 8}} {
+			if c := backend.Image.RGBAAt(pt.X, pt.Y); c.A != 0 {
+				t.Errorf("Expected nothing at %v, got %v", pt, c)
+			}
+		}
+	}
+
+	backend := canvas.NewBackend(20, 10)
+	cv := canvas.New(backend)
+	cv.DrawMarkers(canvas.MarkerSquare, []canvas.BackendVec{{5, 5}, {15, 5}}, 4, canvas.MarkerStyle{Fill: "#F00"})
+	check(backend)
+
+	// the positions and the size are transformed
+	backend = canvas.NewBackend(20, 10)
+	cv = canvas.New(backend)
+	cv.Scale(2, 2)
+	cv.DrawMarkers(canvas.MarkerSquare, []canvas.BackendVec{{2.5, 2.5}, {7.5, 2.5}}, 2, canvas.MarkerStyle{Fill: "#F00"})
+	check(backend)
+
+	backend = canvas.NewBackend(20, 10)
+	cv = canvas.New(backend)
+	cv.DrawMarkers(canvas.MarkerPlus, []canvas.BackendVec{{5, 5}}, 6, canvas.MarkerStyle{Fill: "#F00", LineWidth: 2})
+	for _, pt := range []image.Point{{3, 4}, {5, 2}, {5, 7}} {
+		if c := backend.Image.RGBAAt(pt.X, pt.Y); c != red {
+			t.Errorf("Expected %v at %v of the plus, got %v", red, pt, c)
+		}
+	}
+	if c := backend.Image.RGBAAt(3, 2); c.A != 0 {
+		t.Errorf("Expected nothing between the lines of the plus, got %v", c)
+	}
+}
+
+func TestDrawInstances(t *testing.T) {
+	backend := canvas.NewBackend(30, 30)
+	cv := canvas.New(backend)
+	path := cv.NewPath2D()
+	path.Rect(0, 0, 4, 4)
+	cv.Translate(1, 1)
+	cv.DrawInstances(path, []canvas.BackendMat{
+		canvas.BackendMatTranslate(canvas.BackendVec{1, 1}),
+		canvas.BackendMatTranslate(canvas.BackendVec{9, 1}),
+		canvas.BackendMatScale(canvas.BackendVec{2, 2}).Mul(canvas.BackendMatTranslate(canvas.BackendVec{1, 9})),
+	}, "#F00")
+	red := color.RGBA{255, 0, 0, 255}
+	for _, pt := range []image.Point{{2, 2}, {5, 5}, {10, 2}, {13, 5}, {2, 10}, {9, 17}} {
+		if c := backend.Image.RGBAAt(pt.X, pt.Y); c != red {
+			t.Errorf("Expected %v at %v, got %v", red, pt, c)
+		}
+	}
+	for _, pt := range []image.Point{{1, 1}, {6, 6}, {8, 3}, {10, 18}} {
+		if c := backend.Image.RGBAAt(pt.X, pt.Y); c.A != 0 {
+			t.Errorf("Expected nothing at %v, got %v", pt, c)
+		}
+	}
+	cv.EndFrame()
+	if s := cv.Stats(); s.DrawCalls != 1 || s.Triangles != 6 {
+		t.Errorf("Expected one draw call with 6 triangles, got %+v", s)
+	}
+
+	one := []canvas.BackendMat{canvas.BackendMatIdentity}
+	cv.DrawInstances(nil, one, "#F00")
+	cv.DrawInstances(cv.NewPath2D(), one, "#F00")
+	cv.EndFrame()
+	if s := cv.Stats(); s.DrawCalls != 0 || s.Triangles != 0 {
+		t.Errorf("Expected nothing drawn for nil and empty paths, got %+v", s)
+	}
+}
+
+func TestImageSharing(t *testing.T) {
+	backend := canvas.NewBackend(4, 1)
+	cv := canvas.New(backend)
+	newSrc := func(c color.NRGBA) *image.NRGBA {
+		img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+		img.SetNRGBA(0, 0, c)
+		return img
+	}
+	red := color.NRGBA{255, 0, 0, 255}
+	src1, src2, src3 := newSrc(red), newSrc(red), newSrc(color.NRGBA{0, 0, 255, 255})
+	img1, err := cv.LoadImage(src1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img2, _ := cv.LoadImage(src2)
+	cv.LoadImage(src3)
+	if s := cv.ImageCacheStats(); s.Shared != 1 {
+		t.Fatalf("Expected one shared image, got %d", s.Shared)
+	}
+
+	// replacing the pixels of one image does not change the other
+	src2.SetNRGBA(0, 0, color.NRGBA{0, 255, 0, 255})
+	if err := img2.Replace(src2); err != nil {
+		t.Fatal(err)
+	}
+	cv.DrawImage(img1, 0, 0)
+	cv.DrawImage(img2, 1, 0)
+
+	// deleting an image keeps the pixels for the other one
+	img3, _ := cv.LoadImage(newSrc(red))
+	img1.Delete()
+	cv.DrawImage(img3, 2, 0)
+
+	for x, want := range []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {255, 0, 0, 255}} {
+		if c := backend.Image.RGBAAt(x, 0); c != want {
+			t.Errorf("Expected %v at %d, got %v", want, x, c)
+		}
+	}
+}
+
+func TestResourceTracker(t *testing.T) {
+	backend := canvas.NewBackend(10, 10)
+	rt := canvas.NewResourceTracker(backend)
+	rt.Stacks = true
+	cv := canvas.New(rt)
+
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for i := range src.Pix {
+		src.Pix[i] = 255
+	}
+	img, err := cv.LoadImage(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lg := cv.CreateLinearGradient(0, 0, 10, 0)
+	lg.AddColorStop(0, "#F00")
+	lg.AddColorStop(1, "#00F")
+	cv.SetFillStyle(lg)
+	cv.FillRect(0, 0, 10, 5)
+	cv.DrawImage(img, 0, 5)
+
+	res := rt.Resources()
+	if len(res) != 2 {
+		t.Fatalf("Expected 2 resources, got %+v", res)
+	}
+	if res[0].Kind != canvas.ResourceImage || res[0].Width != 4 || res[0].Height != 2 || res[0].Bytes != 32 {
+		t.Errorf("Expected a 4x2 image of 32 bytes, got %+v", res[0])
+	}
+	if res[1].Kind != canvas.ResourceLinearGradient {
+		t.Errorf("Expected a linear gradient, got %v", res[1].Kind)
+	}
+	if !strings.Contains(res[0].Stack, "TestResourceTracker") {
+		t.Errorf("Expected the stack to contain the test, got %q", res[0].Stack)
+	}
+	if c := backend.Image.RGBAAt(1, 6); c != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("Expected the image to be drawn, got %v", c)
+	}
+
+	img.Delete()
+	res = rt.Resources()
+	if len(res) != 1 || res[0].Kind != canvas.ResourceLinearGradient {
+		t.Errorf("Expected only the gradient after deleting the image, got %+v", res)
+	}
+	var sb strings.Builder
+	if err := rt.Report(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), "linearGradient: 1,") || !strings.Contains(sb.String(), "image: 0,") {
+		t.Errorf("Unexpected report:\n%s", sb.String())
+	}
+}
+
+type lossyBackend struct {
+	*canvas.ResourceTracker
+	lost, restored func()
+}
+
+func (lb *lossyBackend) NotifyContextLoss(lost, restored func()) {
+	lb.lost, lb.restored = lost, restored
+}
+
+func TestContextLoss(t *testing.T) {
+	backend := canvas.NewBackend(10, 10)
+	rt := canvas.NewResourceTracker(backend)
+	lb := &lossyBackend{ResourceTracker: rt}
+	cv := canvas.New(lb)
+	if lb.lost == nil || lb.restored == nil {
+		t.Fatal("Expected the canvas to register with the backend")
+	}
+	var events []string
+	cv.SetContextLossHandlers(func() { events = append(events, "lost") }, func() { events = append(events, "restored") })
+
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	draw.Draw(src, src.Rect, image.NewUniform(color.RGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+	green := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	draw.Draw(green, green.Rect, image.NewUniform(color.RGBA{0, 255, 0, 255}), image.Point{}, draw.Src)
+	file, err := ioutil.TempFile("", "canvas*.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	png.Encode(file, green)
+	file.Close()
+	cv.SetKeepImageData(false)
+	decoded, err := cv.LoadImage(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cv.SetKeepImageData(true)
+	img, _ := cv.LoadImage(src)
+	lg := cv.CreateLinearGradient(0, 0, 10, 0)
+	lg.AddColorStop(0, "#F00")
+	lg.AddColorStop(1, "#F00")
+	repaint := func() {
+		cv.SetFillStyle(lg)
+		cv.FillRect(0, 0, 10, 4)
+		cv.DrawImage(img, 0, 4)
+		cv.DrawImage(decoded, 4, 4)
+	}
+	repaint()
+	count := func() map[canvas.ResourceKind]int {
+		n := make(map[canvas.ResourceKind]int)
+		for _, res := range rt.Resources() {
+			n[res.Kind]++
+		}
+		return n
+	}
+	if n := count(); n[canvas.ResourceImage] != 2 || n[canvas.ResourceLinearGradient] != 1 {
+		t.Fatalf("Unexpected resources before the loss: %v", n)
+	}
+
+	lb.lost()
+	if !cv.ContextIsLost() {
+		t.Error("Expected the context to be lost")
+	}
+	draw.Draw(backend.Image, backend.Image.Rect, image.Transparent, image.Point{}, draw.Src)
+	lb.restored()
+	if cv.ContextIsLost() {
+		t.Error("Expected the context to be restored")
+	}
+	// only the image with kept pixels is uploaded right away
+	if n := count(); n[canvas.ResourceImage] != 3 || n[canvas.ResourceLinearGradient] != 1 {
+		t.Errorf("Unexpected resources after restoring: %v", n)
+	}
+	repaint()
+	if n := count(); n[canvas.ResourceImage] != 4 || n[canvas.ResourceLinearGradient] != 2 {
+		t.Errorf("Unexpected resources after drawing: %v", n)
+	}
+	if strings.Join(events, ",") != "lost,restored" {
+		t.Errorf("Unexpected events %v", events)
+	}
+	red, green2, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 255, 0, 255}, color.RGBA{0, 0, 255, 255}
+	for _, tc := range []struct {
+		x, y int
+		c    color.RGBA
+	}{{5, 1, red}, {1, 5, blue}, {5, 5, green2}} {
+		if c := backend.Image.RGBAAt(tc.x, tc.y); c != tc.c {
+			t.Errorf("Expected %v at %d,%d, got %v", tc.c, tc.x, tc.y, c)
+		}
+	}
+}
+
+type frameBackend struct {
+	*canvas.SoftwareBackend
+	events *[]string
+}
+
+func (fb frameBackend) BeginFrame() { *fb.events = append(*fb.events, "backend begin") }
+func (fb frameBackend) EndFrame()   { *fb.events = append(*fb.events, "backend end") }
+
+func TestFrameLifecycle(t *testing.T) {
+	var events []string
+	backend := canvas.NewBackend(10, 10)
+	dt := canvas.NewDamageTracker(frameBackend{SoftwareBackend: backend, events: &events})
+	cv := canvas.New(dt)
+	cv.SetFrameHandlers(func() {
+		events = append(events, "begin")
+	}, func(stats canvas.RenderStats) {
+		events = append(events, fmt.Sprintf("present %d", stats.DrawCalls))
+	})
+
+	cv.BeginFrame()
+	cv.BeginFrame()
+	cv.FillRect(1, 1, 3, 3)
+	cv.EndFrame()
+	// frames don't need BeginFrame
+	cv.FillRect(5, 5, 2, 2)
+	cv.FillRect(6, 6, 2, 2)
+	cv.EndFrame()
+
+	want := "backend begin,begin,backend end,present 1,backend end,present 2"
+	if got := strings.Join(events, ","); got != want {
+		t.Errorf("Expected events %s, got %s", want, got)
+	}
+	// the damage tracker finished the frame with the canvas
+	if damage := dt.Damage(); len(damage) != 1 || damage[0] != image.Rect(5, 5, 8, 8) {
+		t.Errorf("Expected the damage of the last frame, got %v", damage)
+	}
+}
+
+func TestPresentBuffers(t *testing.T) {
+	backend := canvas.NewBackend(4, 4)
+	backend.SetBuffers(2)
+	cv := canvas.New(backend)
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+
+	cv.SetFillStyle(red)
+	cv.FillRect(0, 0, 4, 4)
+	frame1 := backend.Present()
+	cv.SetFillStyle(blue)
+	cv.FillRect(0, 0, 2, 2)
+	if c := frame1.RGBAAt(0, 0); c != red {
+		t.Errorf("Expected the presented frame to stay %v, got %v", red, c)
+	}
+	// the next buffer continues with the content of the frame
+	if c := backend.Image.RGBAAt(3, 3); c != red {
+		t.Errorf("Expected %v in the next buffer, got %v", red, c)
+	}
+	frame2 := backend.Present()
+	if frame2 == frame1 || frame2.RGBAAt(0, 0) != blue {
+		t.Error("Expected the second frame in the other buffer")
+	}
+	if backend.Image != frame1 {
+		t.Error("Expected the first buffer to be reused")
+	}
+	if c := frame1.RGBAAt(0, 0); c != blue {
+		t.Errorf("Expected the reused buffer to start with the last frame, got %v", c)
+	}
+
+	backend.SetBuffers(1)
+	if backend.Present() != backend.Image {
+		t.Error("Expected Present without buffering to return the image")
+	}
+}
+
+type plainBackend struct{ canvas.Backend }
+
+func TestGetImageDataAsync(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend func(b *canvas.SoftwareBackend) canvas.Backend
+	}{
+		{"software", func(b *canvas.SoftwareBackend) canvas.Backend { return b }},
+		{"fallback", func(b *canvas.SoftwareBackend) canvas.Backend { return plainBackend{b} }},
+		{"wrapped", func(b *canvas.SoftwareBackend) canvas.Backend { return canvas.NewDamageTracker(b) }},
+	} {
+		backend := canvas.NewBackend(4, 4)
+		cv := canvas.New(tc.backend(backend))
+		cv.SetFillStyle("#F00")
+		cv.FillRect(0, 0, 2, 2)
+		ch := cv.GetImageDataAsync(0, 0, 4, 4)
+		// the image shows the content at the time of the call
+		cv.FillRect(0, 0, 4, 4)
+		select {
+		case img := <-ch:
+			if c := img.RGBAAt(1, 1); c != (color.RGBA{255, 0, 0, 255}) {
+				t.Errorf("%s: Expected red at 1,1, got %v", tc.name, c)
+			}
+			if c := img.RGBAAt(3, 3); c.A != 0 {
+				t.Errorf("%s: Expected nothing at 3,3, got %v", tc.name, c)
+			}
+		default:
+			t.Errorf("%s: Expected the image to be available right away", tc.name)
+		}
+		if _, ok := <-ch; ok {
+			t.Errorf("%s: Expected the channel to be closed", tc.name)
+		}
+	}
+}
+
+func TestRenderBounds(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(img, img.Rect, image.NewUniform(color.RGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+	for _, tc := range []struct {
+		name    string
+		backend func(b *canvas.SoftwareBackend) canvas.Backend
+	}{
+		{"scissor", func(b *canvas.SoftwareBackend) canvas.Backend { return b }},
+		{"clip", func(b *canvas.SoftwareBackend) canvas.Backend { return plainBackend{b} }},
+	} {
+		backend := canvas.NewBackend(20, 20)
+		cv := canvas.New(tc.backend(backend))
+		cv.SetRenderBounds(image.Rect(5, 5, 15, 15))
+		cv.Save()
+		cv.BeginPath()
+		cv.Rect(0, 0, 10, 20)
+		cv.Clip()
+		cv.SetFillStyle("#F00")
+		cv.FillRect(0, 0, 20, 20)
+		cv.Restore()
+		if c := backend.Image.RGBAAt(7, 7); c != (color.RGBA{255, 0, 0, 255}) {
+			t.Errorf("%s: Expected the clipped fill inside of the bounds, got %v", tc.name, c)
+		}
+		if c := backend.Image.RGBAAt(12, 7); c.A != 0 {
+			t.Errorf("%s: Expected the clipping region to still apply, got %v", tc.name, c)
+		}
+		cv.SetShadowColor("#0F0")
+		cv.SetShadowBlur(4)
+		cv.SetStrokeStyle("#F00")
+		cv.StrokeRect(1, 1, 18, 18)
+		cv.SetShadowColor("#0000")
+		cv.DrawImage(img, 16, 16)
+		cv.PutImageData(image.NewRGBA(image.Rect(0, 0, 0, 0)), 0, 0)
+
+		for y := 0; y < 20; y++ {
+			for x := 0; x < 20; x++ {
+				inside := image.Pt(x, y).In(image.Rect(5, 5, 15, 15))
+				c := backend.Image.RGBAAt(x, y)
+				if !inside && c.A != 0 {
+					t.Fatalf("%s: Expected nothing outside of the bounds at %d,%d, got %v", tc.name, x, y, c)
+				}
+			}
+		}
+
+		cv.SetRenderBounds(image.Rectangle{})
+		cv.FillRect(0, 0, 2, 2)
+		if c := backend.Image.RGBAAt(1, 1); c.A == 0 {
+			t.Errorf("%s: Expected drawing everywhere without render bounds", tc.name)
+		}
+	}
+}
+
+func TestViewport(t *testing.T) {
+	backend := canvas.NewBackend(100, 50)
+	cv := canvas.New(backend)
+	vp := canvas.NewViewport()
+	vp.CenterX, vp.CenterY = 1000, 2000
+	vp.Apply(cv)
+
+	if x, y := vp.ScreenToWorld(50, 25); x != 1000 || y != 2000 {
+		t.Errorf("Expected the center at 1000/2000, got %g/%g", x, y)
+	}
+	vp.ZoomAt(2, 0, 0)
+	vp.Apply(cv)
+	// the corner stays at the same world point
+	if x, y := vp.ScreenToWorld(0, 0); math.Abs(x-950) > 1e-9 || math.Abs(y-1975) > 1e-9 {
+		t.Errorf("Expected the corner at 950/1975, got %g/%g", x, y)
+	}
+	b := vp.VisibleBounds()
+	if b != (canvas.Bounds{MinX: 950, MinY: 1975, MaxX: 1000, MaxY: 2000}) {
+		t.Errorf("Unexpected visible bounds %+v", b)
+	}
+	if x, y := vp.WorldToScreen(990, 1990); x != 80 || y != 30 {
+		t.Errorf("Expected 990/1990 at 80/30, got %g/%g", x, y)
+	}
+	// the canvas draws with the viewport
+	cv.SetFillStyle("#F00")
+	cv.FillRect(990, 1990, 2, 2)
+	if c := backend.Image.RGBAAt(81, 31); c.A != 255 {
+		t.Errorf("Expected the world rectangle at 81/31, got %v", c)
+	}
+
+	vp.MaxZoom = 4
+	vp.Wheel(-5000, 10, 10)
+	if vp.Zoom != 4 {
+		t.Errorf("Expected the zoom to be limited to 4, got %g", vp.Zoom)
+	}
+
+	before := vp.CenterX
+	vp.Pan(8, 0)
+	if vp.CenterX != before-2 {
+		t.Errorf("Expected panning by 2 world units, got center %g from %g", vp.CenterX, before)
+	}
+
+	vp.BeginDrag(0, 0)
+	time.Sleep(5 * time.Millisecond)
+	vp.Drag(20, 0)
+	vp.EndDrag()
+	center := vp.CenterX
+	if !vp.Update(10*time.Millisecond) || vp.CenterX >= center {
+		t.Errorf("Expected the view to keep moving after the drag, got center %g from %g", vp.CenterX, center)
+	}
+	for i := 0; i < 1000 && vp.Update(100*time.Millisecond); i++ {
+	}
+	if vp.Update(100 * time.Millisecond) {
+		t.Error("Expected the inertia to stop")
+	}
+}
+
+func TestCulling(t *testing.T) {
+	backend := canvas.NewBackend(100, 100)
+	cv := canvas.New(backend)
+	cv.SetFillStyle("#F00")
+
+	cv.FillRect(-50, 10, 20, 20)
+	cv.BeginPath()
+	cv.Arc(300, 300, 10, 0, math.Pi*2, false)
+	cv.Fill()
+	cv.SetStrokeStyle("#F00")
+	cv.StrokeRect(200, 10, 10, 10)
+	cv.FillRect(95, 95, 20, 20)
+	cv.EndFrame()
+	if st := cv.Stats(); st.Culled != 3 || st.DrawCalls != 1 {
+		t.Errorf("Expected 3 culled fills and 1 drawn, got %+v", st)
+	}
+
+	// shadows of shapes outside of the canvas can be visible
+	cv.SetShadowColor("#00F")
+	cv.SetShadowOffsetX(60)
+	cv.BeginPath()
+	cv.Rect(-50, 40, 20, 20)
+	cv.Fill()
+	cv.SetShadowColor("#0000")
+	if c := backend.Image.RGBAAt(20, 50); c != (color.RGBA{0, 0, 255, 255}) {
+		t.Errorf("Expected the shadow at 20/50, got %v", c)
+	}
+
+	cv.Save()
+	cv.BeginPath()
+	cv.Rect(0, 0, 10, 10)
+	cv.Clip()
+	cv.FillRect(50, 50, 10, 10)
+	cv.Restore()
+	cv.SetRenderBounds(image.Rect(0, 0, 10, 10))
+	cv.FillRect(50, 50, 10, 10)
+	cv.SetRenderBounds(image.Rectangle{})
+	cv.FillRect(50, 50, 10, 10)
+	cv.EndFrame()
+	// the shape with the visible shadow is culled as well
+	if st := cv.Stats(); st.Culled != 3 {
+		t.Errorf("Expected the fills outside of the clip and render bounds to be culled, got %+v", st)
+	}
+	if c := backend.Image.RGBAAt(55, 55); c != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("Expected the visible fill at 55/55, got %v", c)
+	}
+}
+
+func TestStrokeAlignment(t *testing.T) {
+	backend := canvas.NewBackend(100, 100)
+	cv := canvas.New(backend)
+	white := color.RGBA{255, 255, 255, 255}
+	black := color.RGBA{0, 0, 0, 255}
+
+	for _, tc := range []struct {
+		name string
+		set  func()
+		in   [3]bool
+	}{
+		{"center", func() { cv.SetStrokeAlignment(canvas.Center) }, [3]bool{false, true, false}},
+		{"inner", func() { cv.SetStrokeAlignment(canvas.Inner) }, [3]bool{false, true, true}},
+		{"outer", func() { cv.SetStrokeAlignment(canvas.Outer) }, [3]bool{true, false, false}},
+	} {
+		cv.SetFillStyle("#000")
+		cv.FillRect(0, 0, 100, 100)
+		cv.SetStrokeStyle("#FFF")
+		cv.SetLineWidth(10)
+		tc.set()
+		cv.StrokeRect(20, 20, 60, 60)
+		for i, x := range [3]int{12, 22, 28} {
+			expected := black
+			if tc.in[i] {
+				expected = white
+			}
+			if c := backend.Image.RGBAAt(x, 50); c != expected {
+				t.Errorf("Expected %v at %d/50 with %s alignment, got %v", expected, x, tc.name, c)
+			}
+		}
+	}
+
+	// paths are cut with the transformation applied
+	cv.SetFillStyle("#000")
+	cv.FillRect(0, 0, 100, 100)
+	var p canvas.Path2D
+	p.Arc(20, 20, 10, 0, math.Pi*2, false)
+	p.ClosePath()
+	cv.Save()
+	cv.Scale(2, 2)
+	cv.SetStrokeAlignment(canvas.Inner)
+	cv.SetLineWidth(4)
+	cv.StrokePath(&p)
+	cv.Restore()
+	if c := backend.Image.RGBAAt(57, 40); c != white {
+		t.Errorf("Expected the inner stroke at 57/40, got %v", c)
+	}
+	if c := backend.Image.RGBAAt(62, 40); c != black {
+		t.Errorf("Expected no stroke outside of the circle at 62/40, got %v", c)
+	}
+
+	// open paths are stroked centered
+	cv.SetStrokeAlignment(canvas.Outer)
+	cv.BeginPath()
+	cv.MoveTo(10, 90)
+	cv.LineTo(90, 90)
+	cv.Stroke()
+	if c := backend.Image.RGBAAt(50, 88); c != white {
+		t.Errorf("Expected the open path stroked centered at 50/88, got %v", c)
+	}
+}
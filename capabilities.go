@@ -0,0 +1,92 @@
+package canvas
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// BackendCapabilities lists the optional features of a backend. For
+// features that are missing, the canvas falls back to a slower
+// implementation that only uses the required backend functions
+type BackendCapabilities struct {
+	// Blur means that Fill supports BackendFillStyle.Blur efficiently.
+	// Otherwise shadows are blurred on the CPU before they are drawn
+	Blur bool
+	// ImageMask means that FillImageMask is supported. Otherwise the
+	// mask is converted to an image and drawn with DrawImage, which
+	// only supports solid colors
+	ImageMask bool
+	// VertexColors means that FillTrianglesVertexColor is supported.
+	// Otherwise each triangle is filled with the average of its colors
+	VertexColors bool
+	// AsImage means that the backend can be used as an image by other
+	// backends, see CanUseAsImage and AsImage
+	AsImage bool
+}
+
+// Capabilities returns the optional features of the canvas backend
+func (cv *Canvas) Capabilities() BackendCapabilities { return cv.caps }
+
+// fillImageMask calls FillImageMask on the backend, or falls back to
+// drawing an image if it is not supported
+func (cv *Canvas) fillImageMask(style *BackendFillStyle, mask *image.Alpha, pts [4]BackendVec) {
+	cv.Flush()
+	cv.countQuad(pts)
+	if cv.caps.ImageMask {
+		cv.b.FillImageMask(style, mask, pts)
+		return
+	}
+
+	// the image has straight alpha like all images passed to LoadImage,
+	// so the color stays the same and the mask becomes the alpha
+	img := image.NewRGBA(image.Rect(0, 0, mask.Rect.Dx(), mask.Rect.Dy()))
+	col := style.Color
+	for y := 0; y < img.Rect.Dy(); y++ {
+		for x := 0; x < img.Rect.Dx(); x++ {
+			a := mask.AlphaAt(mask.Rect.Min.X+x, mask.Rect.Min.Y+y).A
+			img.SetRGBA(x, y, color.RGBA{R: col.R, G: col.G, B: col.B, A: a})
+		}
+	}
+	bimg, err := cv.b.LoadImage(img)
+	if err != nil {
+		cv.reportError(fmt.Errorf("Error loading image mask: %w", err))
+		return
+	}
+	w, h := float64(img.Rect.Dx()), float64(img.Rect.Dy())
+	cv.b.DrawImage(bimg, 0, 0, w, h, pts, float64(col.A)/255)
+	bimg.Delete()
+}
+
+// fillTrianglesVertexColor calls FillTrianglesVertexColor on the backend,
+// or falls back to filling each triangle with its average color if it is
+// not supported
+func (cv *Canvas) fillTrianglesVertexColor(pts []BackendVec, colors []color.RGBA) {
+	cv.Flush()
+	cv.countTriangles(pts, BackendMatIdentity)
+	if cv.caps.VertexColors {
+		cv.b.FillTrianglesVertexColor(pts, colors)
+		return
+	}
+
+	for i := 0; i+2 < len(pts); i += 3 {
+		var r, g, b, a float64
+		for _, c := range colors[i : i+3] {
+			r += float64(c.R) * float64(c.A)
+			g += float64(c.G) * float64(c.A)
+			b += float64(c.B) * float64(c.A)
+			a += float64(c.A)
+		}
+		var style BackendFillStyle
+		if a > 0 {
+			style.Color = color.RGBA{
+				R: uint8(math.Round(r / a)),
+				G: uint8(math.Round(g / a)),
+				B: uint8(math.Round(b / a)),
+				A: uint8(math.Round(a / 3)),
+			}
+		}
+		cv.b.Fill(&style, pts[i:i+3], BackendMatIdentity, false)
+	}
+}
@@ -0,0 +1,92 @@
+package canvas
+
+import (
+	"fmt"
+	"image"
+)
+
+// Channel selects one color channel of the canvas
+type Channel uint8
+
+// Channel constants for ExtractChannel and ClearChannel
+const (
+	ChannelRed Channel = iota
+	ChannelGreen
+	ChannelBlue
+	ChannelAlpha
+)
+
+// ExtractChannel returns one channel of the canvas content as an alpha
+// image, for example to use the alpha channel of what was drawn as a
+// mask for FillMask. For an unknown channel it reports
+// ErrInvalidChannel and returns nil
+func (cv *Canvas) ExtractChannel(ch Channel) *image.Alpha {
+	if !cv.validChannel("ExtractChannel", ch) {
+		return nil
+	}
+	w, h := cv.Size()
+	mask := image.NewAlpha(image.Rect(0, 0, w, h))
+	cv.editPixels(mask.Rect, false, func(img *image.RGBA) {
+		for y := 0; y < img.Rect.Dy(); y++ {
+			src := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+			dst := mask.Pix[mask.PixOffset(0, y):]
+			for x := 0; x < img.Rect.Dx(); x++ {
+				dst[x] = src[x*4+int(ch)]
+			}
+		}
+	})
+	return mask
+}
+
+// ClearChannel sets one channel of the whole canvas to zero. Clearing the
+// alpha channel makes the canvas fully transparent while keeping the
+// colors. Like PutImageData, this ignores the clipping region. Unknown
+// channels are reported as ErrInvalidChannel
+func (cv *Canvas) ClearChannel(ch Channel) {
+	if !cv.validChannel("ClearChannel", ch) {
+		return
+	}
+	w, h := cv.Size()
+	cv.editPixels(image.Rect(0, 0, w, h), true, func(img *image.RGBA) {
+		for y := 0; y < img.Rect.Dy(); y++ {
+			dst := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+			for x := 0; x < img.Rect.Dx(); x++ {
+				dst[x*4+int(ch)] = 0
+			}
+		}
+	})
+}
+
+func (cv *Canvas) validChannel(fn string, ch Channel) bool {
+	if ch > ChannelAlpha {
+		cv.reportError(fmt.Errorf("%s with channel %d: %w", fn, ch, ErrInvalidChannel))
+		return false
+	}
+	return true
+}
+
+// FillMask fills the area covered by the mask with the current fill
+// style, with the top left corner of the mask at x/y. A nil mask fills
+// nothing
+func (cv *Canvas) FillMask(mask *image.Alpha, x, y float64) {
+	if mask == nil {
+		return
+	}
+	w, h := float64(mask.Rect.Dx()), float64(mask.Rect.Dy())
+	if w == 0 || h == 0 {
+		return
+	}
+	quad := [4]BackendVec{
+		cv.tf(BackendVec{x, y}),
+		cv.tf(BackendVec{x, y + h}),
+		cv.tf(BackendVec{x + w, y + h}),
+		cv.tf(BackendVec{x + w, y}),
+	}
+
+	cv.drawShadow(quad[:], mask, false)
+
+	stl := cv.backendFillStyle(&cv.state.fill, 1)
+	cv.fillImageMask(&stl, mask, quad)
+
+	cv.drawInsetShadow(quad[:], mask)
+}
@@ -0,0 +1,182 @@
+// Command canvas renders a JSON scene or a canvas script to a PNG or JPEG
+// image, for CI pipelines and quick checks without writing Go.
+//
+// Usage:
+//
+//	canvas [flags] input
+//
+// The input is a file or - for the standard input. Files ending in .json
+// are scenes as described in the scene package, everything else is a
+// script. Fonts and images of scenes are read relative to the directory
+// of the input, or the current directory for the standard input.
+//
+// Scripts have one command per line, the name of a method or property of
+// CanvasRenderingContext2D followed by its arguments as JSON values, as in
+// the protocol of the remote package. Empty lines and lines starting with
+// # are ignored:
+//
+//	# a red square with a blue outline
+//	fillStyle "#f00"
+//	fillRect 10 10 80 80
+//	strokeStyle "#00f"
+//	lineWidth 4
+//	strokeRect 10 10 80 80
+//
+// Flags:
+//
+//	-o file      the output file, the format is chosen by the extension
+//	-format f    png or jpeg, overrides the extension
+//	-quality q   the JPEG quality between 1 and 100
+//	-type t      scene or script, overrides the extension of the input
+//	-width w     the width of the canvas of scripts, 300 by default
+//	-height h    the height of the canvas of scripts, 150 by default
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opentoys/canvas"
+	"github.com/opentoys/canvas/remote"
+	"github.com/opentoys/canvas/scene"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin); err != nil {
+		fmt.Fprintln(os.Stderr, "canvas:", err)
+		os.Exit(1)
+	}
+}
+
+func run(argv []string, stdin io.Reader) error {
+	fs := flag.NewFlagSet("canvas", flag.ContinueOnError)
+	out := fs.String("o", "", "output `file`")
+	format := fs.String("format", "", "output format, png or jpeg")
+	quality := fs.Int("quality", 0, "JPEG quality between 1 and 100")
+	typ := fs.String("type", "", "input type, scene or script")
+	width := fs.Int("width", 300, "canvas width of scripts")
+	height := fs.Int("height", 150, "canvas height of scripts")
+	if err := fs.Parse(argv); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *out == "" {
+		return errors.New("usage: canvas [flags] -o output input")
+	}
+	input := fs.Arg(0)
+
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*out)), ".")
+	}
+	opts := canvas.ScreenshotOptions{Quality: *quality}
+	switch *format {
+	case "png":
+		opts.Format = canvas.ScreenshotPNG
+	case "jpeg", "jpg":
+		opts.Format = canvas.ScreenshotJPEG
+	default:
+		return fmt.Errorf("Unsupported output format %q", *format)
+	}
+	if *typ == "" {
+		*typ = "script"
+		if strings.ToLower(filepath.Ext(input)) == ".json" {
+			*typ = "scene"
+		}
+	}
+
+	var data []byte
+	var err error
+	if input == "-" {
+		data, err = ioutil.ReadAll(stdin)
+	} else {
+		data, err = ioutil.ReadFile(input)
+	}
+	if err != nil {
+		return err
+	}
+
+	var cv *canvas.Canvas
+	switch *typ {
+	case "scene":
+		s, err := scene.Parse(data)
+		if err != nil {
+			return err
+		}
+		b, err := canvas.NewBackendChecked(s.Width, s.Height)
+		if err != nil {
+			return err
+		}
+		cv = canvas.New(b)
+		r := scene.Renderer{BaseDir: "."}
+		if input != "-" {
+			r.BaseDir = filepath.Dir(input)
+		}
+		if err := r.Draw(cv, s); err != nil {
+			return err
+		}
+	case "script":
+		if *width <= 0 || *height <= 0 {
+			return fmt.Errorf("Invalid canvas size %dx%d", *width, *height)
+		}
+		b, err := canvas.NewBackendChecked(*width, *height)
+		if err != nil {
+			return err
+		}
+		cv = canvas.New(b)
+		if err := runScript(remote.NewInterpreter(cv), strings.NewReader(string(data))); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unknown input type %q", *typ)
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	w, h := cv.Size()
+	if err := cv.Screenshot(f, image.Rect(0, 0, w, h), opts); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runScript runs the commands of a script, one per line
+func runScript(in *remote.Interpreter, r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 64<<20)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		name, rest := text, ""
+		if i := strings.IndexAny(text, " \t"); i >= 0 {
+			name, rest = text[:i], text[i:]
+		}
+		var values []json.RawMessage
+		dec := json.NewDecoder(strings.NewReader(rest))
+		for {
+			var v json.RawMessage
+			if err := dec.Decode(&v); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("Line %d: %v", line, err)
+			}
+			values = append(values, v)
+		}
+		if err := in.Run(name, values...); err != nil {
+			return fmt.Errorf("Line %d: %v", line, err)
+		}
+	}
+	return sc.Err()
+}
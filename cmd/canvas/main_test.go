@@ -0,0 +1,102 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func decode(t *testing.T, fileName string) image.Image {
+	f, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// tempDir creates a temporary directory and returns it with a function
+// that removes it
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "canvas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestScript(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	out := filepath.Join(dir, "out.png")
+	script := `
+# red square
+fillStyle "#f00"
+fillRect 10 10 20 20
+setLineDash [2, 2]
+`
+	err := run([]string{"-width", "40", "-height", "30", "-o", out, "-"}, strings.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := decode(t, out)
+	if b := img.Bounds(); b.Dx() != 40 || b.Dy() != 30 {
+		t.Fatalf("Expected a 40x30 image, got %v", b)
+	}
+	if c := color.NRGBAModel.Convert(img.At(20, 20)); c != (color.NRGBA{255, 0, 0, 255}) {
+		t.Fatalf("Expected red inside the square, got %v", c)
+	}
+
+	err = run([]string{"-o", out, "-"}, strings.NewReader("fillRect 0 0\n"))
+	if err == nil || !strings.Contains(err.Error(), "Line 1") {
+		t.Fatalf("Expected an error for line 1, got %v", err)
+	}
+	err = run([]string{"-o", out, "-"}, strings.NewReader("beginPath\nmoveTo 1 {\n"))
+	if err == nil || !strings.Contains(err.Error(), "Line 2") {
+		t.Fatalf("Expected an error for line 2, got %v", err)
+	}
+}
+
+// imageFormatsDisabled is set when testing with the canvas_noimageformats
+// build tag
+var imageFormatsDisabled = false
+
+func TestScene(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	in := filepath.Join(dir, "card.json")
+	out := filepath.Join(dir, "card.jpg")
+	if imageFormatsDisabled {
+		// only PNG is available with the canvas_noimageformats tag
+		out = filepath.Join(dir, "card.png")
+	}
+	err := ioutil.WriteFile(in, []byte(`{"width": 64, "height": 32, "background": "#00f",
+		"items": [{"type": "circle", "x": 16, "y": 16, "radius": 10, "fill": "#fff"}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run([]string{"-o", out, in}, nil); err != nil {
+		t.Fatal(err)
+	}
+	img := decode(t, out)
+	if b := img.Bounds(); b.Dx() != 64 || b.Dy() != 32 {
+		t.Fatalf("Expected a 64x32 image, got %v", b)
+	}
+	if r, _, _, _ := img.At(16, 16).RGBA(); r < 0xF000 {
+		t.Fatalf("Expected white inside the circle, got %v", img.At(16, 16))
+	}
+
+	if err := run([]string{"-o", filepath.Join(dir, "out.gif"), in}, nil); err == nil {
+		t.Fatal("Expected an error for an unsupported format")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build canvas_noimageformats
+// +build canvas_noimageformats
+
+package main
+
+func init() {
+	imageFormatsDisabled = true
+}
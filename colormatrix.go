@@ -0,0 +1,116 @@
+package canvas
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// ColorMatrix is a 4x5 matrix that transforms colors, row by row. Each
+// row computes one of red, green, blue and alpha from the four channels
+// of the straight alpha color, each between 0 and 1, plus the offset in
+// the fifth column, like the SVG feColorMatrix filter
+type ColorMatrix [20]float64
+
+// ColorMatrixIdentity leaves colors unchanged
+var ColorMatrixIdentity = ColorMatrix{
+	1, 0, 0, 0, 0,
+	0, 1, 0, 0, 0,
+	0, 0, 1, 0, 0,
+	0, 0, 0, 1, 0,
+}
+
+// ColorMatrixSaturation returns a matrix that changes the saturation,
+// where 0 turns colors gray, 1 leaves them unchanged and larger values
+// make them more saturated
+func ColorMatrixSaturation(s float64) ColorMatrix {
+	// luminance weights of the SVG saturate filter
+	const lr, lg, lb = 0.2126, 0.7152, 0.0722
+	return ColorMatrix{
+		lr + (1-lr)*s, lg - lg*s, lb - lb*s, 0, 0,
+		lr - lr*s, lg + (1-lg)*s, lb - lb*s, 0, 0,
+		lr - lr*s, lg - lg*s, lb + (1-lb)*s, 0, 0,
+		0, 0, 0, 1, 0,
+	}
+}
+
+// ColorMatrixMultiply returns a matrix that multiplies each channel with
+// the one of the color, so that a color with a lower alpha fades the
+// image
+func ColorMatrixMultiply(c color.RGBA) ColorMatrix {
+	return ColorMatrix{
+		float64(c.R) / 255, 0, 0, 0, 0,
+		0, float64(c.G) / 255, 0, 0, 0,
+		0, 0, float64(c.B) / 255, 0, 0,
+		0, 0, 0, float64(c.A) / 255, 0,
+	}
+}
+
+// Then returns the matrix that applies m first and then n
+func (m ColorMatrix) Then(n ColorMatrix) ColorMatrix {
+	var r ColorMatrix
+	for row := 0; row < 4; row++ {
+		for col := 0; col < 5; col++ {
+			v := 0.0
+			for k := 0; k < 4; k++ {
+				v += n[row*5+k] * m[k*5+col]
+			}
+			if col == 4 {
+				v += n[row*5+4]
+			}
+			r[row*5+col] = v
+		}
+	}
+	return r
+}
+
+// Apply returns the transformed color. The color is straight alpha,
+// like the colors of GetImageData
+func (m *ColorMatrix) Apply(c color.RGBA) color.RGBA {
+	r, g, b, a := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255, float64(c.A)/255
+	ch := func(row int) uint8 {
+		v := m[row]*r + m[row+1]*g + m[row+2]*b + m[row+3]*a + m[row+4]
+		return uint8(math.Round(math.Max(0, math.Min(1, v)) * 255))
+	}
+	return color.RGBA{R: ch(0), G: ch(5), B: ch(10), A: ch(15)}
+}
+
+// ColorMatrixImageBackend is implemented by backends that can transform
+// the pixels of an image with a color matrix while drawing it. For other
+// backends a transformed copy of the image is drawn instead
+type ColorMatrixImageBackend interface {
+	DrawImageColorMatrix(dimg BackendImage, sx, sy, sw, sh float64, pts [4]BackendVec, alpha float64, m *ColorMatrix)
+}
+
+func (cv *Canvas) drawColorMatrix(img *Image, sx, sy, sw, sh float64, pts [4]BackendVec, alpha float64, m *ColorMatrix) {
+	if cb, ok := cv.b.(ColorMatrixImageBackend); ok {
+		bimg, bsx, bsy := subImageSource(img.img, sx, sy)
+		cb.DrawImageColorMatrix(bimg, bsx, bsy, sw, sh, pts, alpha, m)
+		return
+	}
+	if img.data == nil {
+		cv.reportError(fmt.Errorf("Color matrix on a canvas image on a backend without color matrix support: %w", ErrUnsupportedSource))
+		return
+	}
+	bounds := img.data.Bounds()
+	result := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			result.SetRGBA(x, y, m.Apply(rgbaAt(img.data, bounds.Min.X+x, bounds.Min.Y+y)))
+		}
+	}
+	changed, err := cv.b.LoadImage(result)
+	if err != nil {
+		cv.reportError(fmt.Errorf("Error loading color matrix image: %w", err))
+		return
+	}
+	defer changed.Delete()
+	cv.b.DrawImage(changed, sx, sy, sw, sh, pts, alpha)
+}
+
+// DrawImageColorMatrix draws the image like DrawImage, with the color of
+// every sampled pixel transformed by the color matrix
+func (b *SoftwareBackend) DrawImageColorMatrix(dimg BackendImage, sx, sy, sw, sh float64, pts [4]BackendVec, alpha float64, m *ColorMatrix) {
+	b.drawImage(dimg, sx, sy, sw, sh, pts, alpha, imageColor{matrix: m})
+}
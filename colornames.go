@@ -0,0 +1,17 @@
+//go:build !canvas_nocolornames
+// +build !canvas_nocolornames
+
+package canvas
+
+import (
+	"image/color"
+
+	"golang.org/x/image/colornames"
+)
+
+// cssColorName returns the color with the lower case CSS name. The names
+// are left out with the canvas_nocolornames build tag
+func cssColorName(name string) (color.RGBA, bool) {
+	c, ok := colornames.Map[name]
+	return c, ok
+}
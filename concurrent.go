@@ -0,0 +1,73 @@
+package canvas
+
+import (
+	"sync"
+)
+
+// ConcurrentCanvas wraps a canvas so that it can be drawn on from
+// multiple goroutines. There are two ways to use it:
+//
+// Do runs a function with exclusive access to the canvas and returns
+// when it is done. The draw state (styles, transformation, path) is
+// shared, so everything that depends on it should happen within a
+// single call to Do.
+//
+// Submit queues a function without blocking, and Flush runs all queued
+// functions in the order they were submitted. This is meant for render
+// loops, where other goroutines produce draw calls and a single render
+// goroutine calls Flush once per frame, for example because the backend
+// requires all calls to come from the same thread.
+//
+// The canvas passed to the functions must not be retained or used
+// after they return
+type ConcurrentCanvas struct {
+	mu sync.Mutex
+	cv *Canvas
+
+	queueMu sync.Mutex
+	queue   []func(cv *Canvas)
+}
+
+// NewConcurrent creates a new canvas for the given backend that is safe
+// for concurrent use
+func NewConcurrent(backend Backend) *ConcurrentCanvas {
+	return &ConcurrentCanvas{cv: New(backend)}
+}
+
+// Do calls fn with exclusive access to the canvas
+func (cc *ConcurrentCanvas) Do(fn func(cv *Canvas)) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	fn(cc.cv)
+}
+
+// Submit queues fn to be called on the next Flush. It can be called
+// from any goroutine and does not block on drawing
+func (cc *ConcurrentCanvas) Submit(fn func(cv *Canvas)) {
+	cc.queueMu.Lock()
+	cc.queue = append(cc.queue, fn)
+	cc.queueMu.Unlock()
+}
+
+// Pending returns the number of functions waiting for the next Flush
+func (cc *ConcurrentCanvas) Pending() int {
+	cc.queueMu.Lock()
+	defer cc.queueMu.Unlock()
+	return len(cc.queue)
+}
+
+// Flush calls all queued functions in the order they were submitted,
+// with exclusive access to the canvas. Functions submitted while
+// flushing are left for the next Flush
+func (cc *ConcurrentCanvas) Flush() {
+	cc.queueMu.Lock()
+	queue := cc.queue
+	cc.queue = nil
+	cc.queueMu.Unlock()
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	for _, fn := range queue {
+		fn(cc.cv)
+	}
+}
@@ -0,0 +1,249 @@
+// Package conformance compares the output of the canvas package with
+// reference renders of the same operations made by web browsers, to show
+// where the package diverges from the HTML5 canvas behaviour.
+//
+// Every case draws with the canvas API and contains the equivalent
+// JavaScript code. WriteHarness creates an HTML page that renders all
+// cases in a browser and offers the results for download, which are then
+// stored in a reference directory per browser (for example
+// testdata/chrome/<name>.png). Run renders the cases with the software
+// backend, compares them with the references and computes a score.
+//
+// The browser references are not part of the repository yet, since they
+// have to be rendered and reviewed in real browsers. Until they are added,
+// they have to be generated locally with the harness, and Run returns
+// ErrNoReferences when pointed at a directory without any of them
+package conformance
+
+import (
+	"math"
+
+	"github.com/opentoys/canvas"
+	"github.com/opentoys/canvas/testutil"
+)
+
+// Case is a single conformance test case
+type Case struct {
+	Name          string
+	Width, Height int
+	// Options define the tolerance for this case. Anti-aliasing
+	// differs between all implementations, so the default allows
+	// small perceptual differences on a few percent of the pixels
+	Options *testutil.Options
+	// Draw draws the case with the canvas package
+	Draw func(cv *canvas.Canvas)
+	// JS draws the same case in a browser, with ctx being the
+	// CanvasRenderingContext2D
+	JS string
+}
+
+// DefaultOptions are used for cases without their own options
+var DefaultOptions = testutil.Options{
+	Perceptual:    true,
+	Threshold:     0.1,
+	MaxDiffPixels: 300,
+}
+
+// Cases is the corpus of conformance cases
+var Cases = []Case{
+	{
+		Name: "fill-rect", Width: 100, Height: 100,
+		Draw: func(cv *canvas.Canvas) {
+			cv.SetFillStyle("#F00")
+			cv.FillRect(10, 10, 50, 30)
+			cv.SetFillStyle("rgba(0, 0, 255, 0.5)")
+			cv.FillRect(30, 20, 50, 50)
+		},
+		JS: `ctx.fillStyle = "#F00";
+ctx.fillRect(10, 10, 50, 30);
+ctx.fillStyle = "rgba(0, 0, 255, 0.5)";
+ctx.fillRect(30, 20, 50, 50);`,
+	},
+	{
+		Name: "arc", Width: 100, Height: 100,
+		Draw: func(cv *canvas.Canvas) {
+			cv.SetFillStyle("#0A0")
+			cv.BeginPath()
+			cv.Arc(50, 50, 35, 0, math.Pi*1.5, false)
+			cv.LineTo(50, 50)
+			cv.Fill()
+		},
+		JS: `ctx.fillStyle = "#0A0";
+ctx.beginPath();
+ctx.arc(50, 50, 35, 0, Math.PI * 1.5, false);
+ctx.lineTo(50, 50);
+ctx.fill();`,
+	},
+	{
+		Name: "line-join", Width: 100, Height: 100,
+		Draw: func(cv *canvas.Canvas) {
+			cv.SetStrokeStyle("#000")
+			cv.SetLineWidth(10)
+			joins := []func(){
+				func() { cv.SetLineJoin(canvas.Miter) },
+				func() { cv.SetLineJoin(canvas.Bevel) },
+				func() { cv.SetLineJoin(canvas.Round) },
+			}
+			for i, setJoin := range joins {
+				y := float64(20 + i*30)
+				setJoin()
+				cv.BeginPath()
+				cv.MoveTo(10, y+15)
+				cv.LineTo(50, y)
+				cv.LineTo(90, y+15)
+				cv.Stroke()
+			}
+		},
+		JS: `ctx.strokeStyle = "#000";
+ctx.lineWidth = 10;
+["miter", "bevel", "round"].forEach((join, i) => {
+  const y = 20 + i * 30;
+  ctx.lineJoin = join;
+  ctx.beginPath();
+  ctx.moveTo(10, y + 15);
+  ctx.lineTo(50, y);
+  ctx.lineTo(90, y + 15);
+  ctx.stroke();
+});`,
+	},
+	{
+		Name: "line-cap", Width: 100, Height: 100,
+		Draw: func(cv *canvas.Canvas) {
+			cv.SetStrokeStyle("#00F")
+			cv.SetLineWidth(12)
+			caps := []func(){
+				func() { cv.SetLineCap(canvas.Butt) },
+				func() { cv.SetLineCap(canvas.Square) },
+				func() { cv.SetLineCap(canvas.Round) },
+			}
+			for i, setCap := range caps {
+				y := float64(20 + i*30)
+				setCap()
+				cv.BeginPath()
+				cv.MoveTo(20, y)
+				cv.LineTo(80, y)
+				cv.Stroke()
+			}
+		},
+		JS: `ctx.strokeStyle = "#00F";
+ctx.lineWidth = 12;
+["butt", "square", "round"].forEach((cap, i) => {
+  const y = 20 + i * 30;
+  ctx.lineCap = cap;
+  ctx.beginPath();
+  ctx.moveTo(20, y);
+  ctx.lineTo(80, y);
+  ctx.stroke();
+});`,
+	},
+	{
+		Name: "line-dash", Width: 100, Height: 100,
+		Draw: func(cv *canvas.Canvas) {
+			cv.SetStrokeStyle("#000")
+			cv.SetLineWidth(4)
+			cv.SetLineDash([]float64{10, 5})
+			cv.StrokeRect(15, 15, 70, 70)
+		},
+		JS: `ctx.strokeStyle = "#000";
+ctx.lineWidth = 4;
+ctx.setLineDash([10, 5]);
+ctx.strokeRect(15, 15, 70, 70);`,
+	},
+	{
+		Name: "bezier", Width: 100, Height: 100,
+		Draw: func(cv *canvas.Canvas) {
+			cv.SetStrokeStyle("#800")
+			cv.SetLineWidth(3)
+			cv.BeginPath()
+			cv.MoveTo(10, 90)
+			cv.BezierCurveTo(10, 10, 90, 10, 90, 90)
+			cv.QuadraticCurveTo(50, 50, 10, 90)
+			cv.Stroke()
+		},
+		JS: `ctx.strokeStyle = "#800";
+ctx.lineWidth = 3;
+ctx.beginPath();
+ctx.moveTo(10, 90);
+ctx.bezierCurveTo(10, 10, 90, 10, 90, 90);
+ctx.quadraticCurveTo(50, 50, 10, 90);
+ctx.stroke();`,
+	},
+	{
+		Name: "transform", Width: 100, Height: 100,
+		Draw: func(cv *canvas.Canvas) {
+			cv.Translate(50, 50)
+			cv.Rotate(math.Pi / 6)
+			cv.Scale(1.5, 0.75)
+			cv.SetFillStyle("#F80")
+			cv.FillRect(-20, -20, 40, 40)
+		},
+		JS: `ctx.translate(50, 50);
+ctx.rotate(Math.PI / 6);
+ctx.scale(1.5, 0.75);
+ctx.fillStyle = "#F80";
+ctx.fillRect(-20, -20, 40, 40);`,
+	},
+	{
+		Name: "global-alpha", Width: 100, Height: 100,
+		Draw: func(cv *canvas.Canvas) {
+			cv.SetGlobalAlpha(0.5)
+			cv.SetFillStyle("#F00")
+			cv.FillRect(10, 10, 60, 60)
+			cv.SetFillStyle("#00F")
+			cv.FillRect(30, 30, 60, 60)
+		},
+		JS: `ctx.globalAlpha = 0.5;
+ctx.fillStyle = "#F00";
+ctx.fillRect(10, 10, 60, 60);
+ctx.fillStyle = "#00F";
+ctx.fillRect(30, 30, 60, 60);`,
+	},
+	{
+		Name: "linear-gradient", Width: 100, Height: 100,
+		Draw: func(cv *canvas.Canvas) {
+			grad := cv.CreateLinearGradient(10, 0, 90, 0)
+			grad.AddColorStop(0, "#F00")
+			grad.AddColorStop(0.5, "#0F0")
+			grad.AddColorStop(1, "#00F")
+			cv.SetFillStyle(grad)
+			cv.FillRect(10, 10, 80, 80)
+		},
+		JS: `const grad = ctx.createLinearGradient(10, 0, 90, 0);
+grad.addColorStop(0, "#F00");
+grad.addColorStop(0.5, "#0F0");
+grad.addColorStop(1, "#00F");
+ctx.fillStyle = grad;
+ctx.fillRect(10, 10, 80, 80);`,
+	},
+	{
+		Name: "clip", Width: 100, Height: 100,
+		Draw: func(cv *canvas.Canvas) {
+			cv.BeginPath()
+			cv.Arc(50, 50, 40, 0, math.Pi*2, false)
+			cv.Clip()
+			cv.SetFillStyle("#088")
+			cv.FillRect(0, 0, 60, 60)
+		},
+		JS: `ctx.beginPath();
+ctx.arc(50, 50, 40, 0, Math.PI * 2, false);
+ctx.clip();
+ctx.fillStyle = "#088";
+ctx.fillRect(0, 0, 60, 60);`,
+	},
+	{
+		Name: "shadow", Width: 100, Height: 100,
+		Draw: func(cv *canvas.Canvas) {
+			cv.SetShadowColor("rgba(0, 0, 0, 0.5)")
+			cv.SetShadowOffset(8, 8)
+			cv.SetShadowBlur(6)
+			cv.SetFillStyle("#F0F")
+			cv.FillRect(20, 20, 50, 50)
+		},
+		JS: `ctx.shadowColor = "rgba(0, 0, 0, 0.5)";
+ctx.shadowOffsetX = 8;
+ctx.shadowOffsetY = 8;
+ctx.shadowBlur = 6;
+ctx.fillStyle = "#F0F";
+ctx.fillRect(20, 20, 50, 50);`,
+	},
+}
@@ -0,0 +1,84 @@
+package conformance_test
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opentoys/canvas/conformance"
+	"github.com/opentoys/canvas/testutil"
+)
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conformance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cases := conformance.Cases[:3]
+	for i, c := range cases[:2] {
+		img := testutil.Render(c.Width, c.Height, c.Draw)
+		if i == 1 {
+			for y := 0; y < 40; y++ {
+				for x := 0; x < 40; x++ {
+					img.SetRGBA(x, y, color.RGBA{G: 255, A: 255})
+				}
+			}
+		}
+		f, err := os.Create(filepath.Join(dir, c.Name+".png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = png.Encode(f, img)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	summary, err := conformance.Run(dir, cases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Passed != 1 || summary.Failed != 1 || summary.Skipped != 1 {
+		t.Fatalf("got %d passed, %d failed, %d skipped", summary.Passed, summary.Failed, summary.Skipped)
+	}
+	if summary.Results[1].Status != conformance.Fail || summary.Results[2].Status != conformance.Skip {
+		t.Fatalf("unexpected results %v, %v", summary.Results[1].Status, summary.Results[2].Status)
+	}
+	if score := summary.Score(); score != 0.5 {
+		t.Fatalf("expected score 0.5, got %g", score)
+	}
+
+	var report testutil.Report
+	summary.Report(&report)
+	if report.Len() != 1 {
+		t.Fatalf("expected 1 report entry, got %d", report.Len())
+	}
+
+	if _, err := conformance.Run(filepath.Join(dir, "missing"), cases); !errors.Is(err, conformance.ErrNoReferences) {
+		t.Fatalf("expected ErrNoReferences for a missing directory, got %v", err)
+	}
+}
+
+func TestWriteHarness(t *testing.T) {
+	var buf bytes.Buffer
+	if err := conformance.WriteHarness(&buf, conformance.Cases); err != nil {
+		t.Fatal(err)
+	}
+	html := buf.String()
+	for _, c := range conformance.Cases {
+		if !strings.Contains(html, `id="`+c.Name+`"`) {
+			t.Errorf("harness is missing case %s", c.Name)
+		}
+	}
+	if !strings.Contains(html, "ctx.fillRect(10, 10, 50, 30);") {
+		t.Error("harness does not contain the JavaScript of the cases")
+	}
+}
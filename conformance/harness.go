@@ -0,0 +1,59 @@
+package conformance
+
+import (
+	"html/template"
+	"io"
+)
+
+var harnessTemplate = template.Must(template.New("harness").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Canvas conformance references</title>
+<style>
+body { font-family: sans-serif; }
+figure { display: inline-block; margin: 8px; }
+canvas { border: 1px solid #ccc; }
+</style>
+</head>
+<body>
+<p>Save the images into the reference directory of this browser, or use
+<button id="download">Download all</button></p>
+{{range .}}<figure>
+<canvas id="{{.Name}}" width="{{.Width}}" height="{{.Height}}"></canvas>
+<figcaption>{{.Name}}</figcaption>
+</figure>
+<script>
+(function() {
+const ctx = document.getElementById({{.Name}}).getContext("2d");
+{{.JS}}
+})();
+</script>
+{{end}}<script>
+document.getElementById("download").onclick = function() {
+  for (const cv of document.querySelectorAll("canvas")) {
+    const a = document.createElement("a");
+    a.href = cv.toDataURL("image/png");
+    a.download = cv.id + ".png";
+    a.click();
+  }
+};
+</script>
+</body>
+</html>
+`))
+
+// WriteHarness writes an HTML page that renders the cases in a browser
+// and lets the user download the results as reference images
+func WriteHarness(w io.Writer, cases []Case) error {
+	type harnessCase struct {
+		Name          string
+		Width, Height int
+		JS            template.JS
+	}
+	list := make([]harnessCase, len(cases))
+	for i, c := range cases {
+		list[i] = harnessCase{Name: c.Name, Width: c.Width, Height: c.Height, JS: template.JS(c.JS)}
+	}
+	return harnessTemplate.Execute(w, list)
+}
@@ -0,0 +1,141 @@
+package conformance
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"github.com/opentoys/canvas/testutil"
+)
+
+// ErrNoReferences is returned by Run if none of the cases has a reference,
+// so that a missing or wrong reference directory can't pass unnoticed
+var ErrNoReferences = errors.New("No reference images found")
+
+// Status is the outcome of a single case
+type Status uint8
+
+// Status constants for CaseResult
+const (
+	// Pass means the output matches the reference
+	Pass Status = iota
+	// Fail means the output differs from the reference
+	Fail
+	// Skip means there is no reference for the case
+	Skip
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "pass"
+	case Fail:
+		return "fail"
+	case Skip:
+		return "skip"
+	}
+	return fmt.Sprintf("Status(%d)", uint8(s))
+}
+
+// CaseResult is the result of running a single case
+type CaseResult struct {
+	Name       string
+	Status     Status
+	DiffPixels int
+	// Got is the output of the canvas package and Want the reference.
+	// Want is nil if the case was skipped
+	Got, Want *image.RGBA
+	Diff      *image.RGBA
+}
+
+// Summary is the result of running the conformance cases against the
+// references of one browser
+type Summary struct {
+	Results []CaseResult
+	Passed  int
+	Failed  int
+	Skipped int
+}
+
+// Score returns the compatibility score, the fraction of the cases with a
+// reference that pass. If no case has a reference, the score is 0
+func (s *Summary) Score() float64 {
+	if s.Passed+s.Failed == 0 {
+		return 0
+	}
+	return float64(s.Passed) / float64(s.Passed+s.Failed)
+}
+
+// Run renders the cases and compares them with the references in refDir,
+// which are named <case name>.png. Cases without a reference are skipped,
+// but if all of them are, ErrNoReferences is returned
+func Run(refDir string, cases []Case) (*Summary, error) {
+	var summary Summary
+	for _, c := range cases {
+		result := CaseResult{Name: c.Name, Got: testutil.Render(c.Width, c.Height, c.Draw)}
+		want, err := loadReference(filepath.Join(refDir, c.Name+".png"))
+		if os.IsNotExist(err) {
+			result.Status = Skip
+			summary.Skipped++
+			summary.Results = append(summary.Results, result)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		opts := DefaultOptions
+		if c.Options != nil {
+			opts = *c.Options
+		}
+		cmp, err := testutil.Compare(result.Got, want, opts)
+		if err != nil {
+			return nil, fmt.Errorf("Case %s: %v", c.Name, err)
+		}
+		result.Want = want
+		result.Diff = cmp.Diff
+		result.DiffPixels = cmp.DiffPixels
+		if cmp.Match {
+			result.Status = Pass
+			summary.Passed++
+		} else {
+			result.Status = Fail
+			summary.Failed++
+		}
+		summary.Results = append(summary.Results, result)
+	}
+	if len(cases) > 0 && summary.Skipped == len(cases) {
+		return nil, fmt.Errorf("%w in %s", ErrNoReferences, refDir)
+	}
+	return &summary, nil
+}
+
+// Report adds the failed cases to the report
+func (s *Summary) Report(r *testutil.Report) {
+	for _, result := range s.Results {
+		if result.Status == Fail {
+			r.Add(result.Name, result.Want, result.Got, testutil.Result{DiffPixels: result.DiffPixels, Diff: result.Diff})
+		}
+	}
+}
+
+func loadReference(path string) (*image.RGBA, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode reference %s: %v", path, err)
+	}
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba, nil
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Rect, img, img.Bounds().Min, draw.Src)
+	return rgba, nil
+}
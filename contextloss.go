@@ -0,0 +1,137 @@
+package canvas
+
+import "fmt"
+
+// ContextLossBackend is implemented by backends that can lose their
+// images, gradients and patterns, for example GPU backends when the GL
+// or Vulkan context is lost after a driver reset or when a browser takes
+// away the WebGL context. New registers the canvas with the backend,
+// which calls lost when the resources become invalid and restored when
+// it can load them again. Until restored is called the backend ignores
+// drawing calls and the deletion of lost resources
+type ContextLossBackend interface {
+	NotifyContextLoss(lost, restored func())
+}
+
+// contextLoss is the context loss state of a canvas
+type contextLoss struct {
+	// gen is increased whenever resources are lost, gradients and
+	// patterns of an older generation are loaded again
+	gen      int
+	lost     bool
+	dropData bool
+
+	onLost, onRestored func()
+}
+
+// SetContextLossHandlers sets the functions that are called after the
+// backend lost its context and after the canvas restored its resources
+// in a new one. Images that the canvas does not cache, those loaded from
+// byte slices with LoadImage and those of LoadImageYUV, have to be
+// loaded again in the restored function. Either function may be nil
+func (cv *Canvas) SetContextLossHandlers(lost, restored func()) {
+	cv.context.onLost, cv.context.onRestored = lost, restored
+}
+
+// SetKeepImageData sets whether the canvas keeps the decoded pixels of
+// images loaded from files and byte slices, which is the default. They
+// are needed to upload the images again right when a lost context is
+// restored. Without them the images are decoded again when they are
+// used, which saves memory but stalls the first frame after a context
+// loss. Shadows of such images and tinting them on backends without
+// tint support also need the pixels and are not drawn without them.
+// The setting applies to images loaded afterwards
+func (cv *Canvas) SetKeepImageData(keep bool) {
+	cv.context.dropData = !keep
+}
+
+// ContextLost tells the canvas that the backend lost its resources.
+// Backends that implement ContextLossBackend call it themselves. Fills
+// that are pending in a batch are discarded
+func (cv *Canvas) ContextLost() {
+	cv.dropResources()
+	cv.context.lost = true
+	if cv.context.onLost != nil {
+		cv.context.onLost()
+	}
+}
+
+// ContextRestored tells the canvas that the backend can load resources
+// again. The images that the canvas kept the pixels of are uploaded
+// right away, other images are decoded again when they are used, and
+// gradients and patterns are loaded again when they are used. Backends
+// that implement ContextLossBackend call it themselves. The first error
+// of uploading an image is returned and reported
+func (cv *Canvas) ContextRestored() error {
+	// resources loaded while the context was lost are lost as well
+	cv.dropResources()
+	cv.context.lost = false
+	var firstErr error
+	for _, img := range cv.images {
+		if img.data == nil || img.deleted {
+			continue
+		}
+		bimg, shared, err := cv.loadSharedImage(img.data, img.mipmap)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+				cv.reportError(fmt.Errorf("Error restoring image: %w", err))
+			}
+			continue
+		}
+		img.img, img.shared, img.evicted = bimg, shared, false
+	}
+	if cv.context.onRestored != nil {
+		cv.context.onRestored()
+	}
+	return firstErr
+}
+
+// ContextIsLost returns whether the backend lost its context and it was
+// not restored yet
+func (cv *Canvas) ContextIsLost() bool {
+	return cv.context.lost
+}
+
+// dropResources forgets the backend resources without deleting them, as
+// they are not valid anymore. Cached images keep their size and are
+// loaded again like evicted images
+func (cv *Canvas) dropResources() {
+	cv.batch = fillBatch{}
+	cv.context.gen++
+	cv.sharedImages = nil
+	for _, img := range cv.images {
+		if img.img == nil {
+			continue
+		}
+		img.w, img.h = img.img.Size()
+		img.img = nil
+		img.shared = nil
+		img.evicted = true
+	}
+}
+
+// keepData drops the decoded pixels of the image if they are not kept
+// and can be decoded again from its source
+func (cv *Canvas) keepData(img *Image) {
+	if !cv.context.dropData || !img.evictable() {
+		return
+	}
+	img.data = nil
+	if img.shared != nil {
+		// images without pixels are not shared with later ones
+		img.shared.data = nil
+	}
+}
+
+// restore loads the pattern again if it was lost with the context
+func (ip *ImagePattern) restore() {
+	if ip.gen == ip.cv.context.gen {
+		return
+	}
+	ip.gen = ip.cv.context.gen
+	if ip.ip == nil || ip.img == nil {
+		return
+	}
+	ip.ip = ip.cv.b.LoadImagePattern(ip.data(ip.cv.transform()))
+}
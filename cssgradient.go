@@ -0,0 +1,396 @@
+package canvas
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// CSSGradient is a gradient parsed from a CSS gradient function with
+// ParseGradient. Like in CSS, where the gradient starts and ends depends
+// on the box that it fills, so it is turned into a canvas gradient for a
+// box with FillStyle
+type CSSGradient struct {
+	radial bool
+	// angle is the direction of a linear gradient in radians, clockwise
+	// from the top. If corner is set, the direction points to that
+	// corner of the box instead
+	angle  float64
+	corner [2]float64
+	// size is the size keyword of a radial gradient, or the radius in
+	// pixels if it is empty
+	size   string
+	radius float64
+	at     [2]cssLength
+	stops  []cssStop
+}
+
+// cssLength is a length in pixels or a percentage
+type cssLength struct {
+	v       float64
+	percent bool
+}
+
+func (l cssLength) resolve(total float64) float64 {
+	if l.percent {
+		return l.v / 100 * total
+	}
+	return l.v
+}
+
+// cssStop is a color stop, the position is NaN if it was not given
+type cssStop struct {
+	color color.RGBA
+	pos   cssLength
+}
+
+// ParseGradient parses a CSS linear-gradient or radial-gradient, for
+// example from a theme in a configuration file:
+//
+//	linear-gradient(45deg, red, rgba(0,0,255,.5) 60%, white)
+//	linear-gradient(to bottom right, #fff, #000)
+//	radial-gradient(circle closest-side at 30% 40%, yellow, transparent)
+//
+// Colors can be given like for SetFillStyle, as CSS color names or as
+// transparent. The color names are left out with the canvas_nocolornames
+// build tag. Stop positions are percentages or pixels. Radial
+// gradients are always circles, ellipses are drawn as circles with the
+// same size keyword
+func ParseGradient(s string) (*CSSGradient, error) {
+	s = strings.TrimSpace(s)
+	open := strings.IndexByte(s, '(')
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		return nil, fmt.Errorf("Invalid gradient %q", s)
+	}
+	g := &CSSGradient{angle: math.Pi, at: [2]cssLength{{50, true}, {50, true}}, size: "farthest-corner"}
+	switch strings.ToLower(strings.TrimSpace(s[:open])) {
+	case "linear-gradient":
+	case "radial-gradient":
+		g.radial = true
+	default:
+		return nil, fmt.Errorf("Unsupported gradient function %q", s[:open])
+	}
+
+	args := splitCSS(s[open+1:len(s)-1], ',')
+	if len(args) == 0 {
+		return nil, fmt.Errorf("Gradient without color stops %q", s)
+	}
+	var err error
+	if g.radial {
+		err = g.parseRadialShape(args[0])
+	} else {
+		err = g.parseLinearDirection(args[0])
+	}
+	if err == nil {
+		args = args[1:]
+	} else if err != errNotShape {
+		return nil, err
+	}
+
+	for _, arg := range args {
+		parts := splitCSS(arg, ' ')
+		if len(parts) == 0 || len(parts) > 3 {
+			return nil, fmt.Errorf("Invalid color stop %q", arg)
+		}
+		c, ok := parseCSSColor(parts[0])
+		if !ok {
+			return nil, fmt.Errorf("Invalid color %q", parts[0])
+		}
+		// a stop with two positions is two stops of the same color
+		stop := cssStop{color: c, pos: cssLength{v: math.NaN()}}
+		if len(parts) == 1 {
+			g.stops = append(g.stops, stop)
+		}
+		for _, p := range parts[1:] {
+			if stop.pos, ok = parseCSSLength(p); !ok {
+				return nil, fmt.Errorf("Invalid color stop position %q", p)
+			}
+			g.stops = append(g.stops, stop)
+		}
+	}
+	if len(g.stops) < 2 {
+		return nil, fmt.Errorf("Gradient needs at least two color stops %q", s)
+	}
+	return g, nil
+}
+
+// errNotShape is returned when the first argument is a color stop
+var errNotShape = errors.New("Not a gradient shape")
+
+func (g *CSSGradient) parseLinearDirection(arg string) error {
+	words := strings.Fields(strings.ToLower(arg))
+	if len(words) > 0 && words[0] == "to" {
+		for _, w := range words[1:] {
+			switch w {
+			case "left":
+				g.corner[0] = -1
+			case "right":
+				g.corner[0] = 1
+			case "top":
+				g.corner[1] = -1
+			case "bottom":
+				g.corner[1] = 1
+			default:
+				return fmt.Errorf("Invalid gradient direction %q", arg)
+			}
+		}
+		if len(words) < 2 || len(words) > 3 || (len(words) == 3 && (g.corner[0] == 0 || g.corner[1] == 0)) {
+			return fmt.Errorf("Invalid gradient direction %q", arg)
+		}
+		if g.corner[0] == 0 || g.corner[1] == 0 {
+			// sides are plain angles
+			g.angle = math.Atan2(g.corner[0], -g.corner[1])
+			g.corner = [2]float64{}
+		}
+		return nil
+	}
+	if len(words) != 1 {
+		return errNotShape
+	}
+	units := []struct {
+		suffix string
+		scale  float64
+	}{{"deg", math.Pi / 180}, {"grad", math.Pi / 200}, {"rad", 1}, {"turn", 2 * math.Pi}}
+	for _, u := range units {
+		if strings.HasSuffix(words[0], u.suffix) {
+			v, err := strconv.ParseFloat(strings.TrimSuffix(words[0], u.suffix), 64)
+			if err != nil {
+				return fmt.Errorf("Invalid gradient angle %q", arg)
+			}
+			g.angle = v * u.scale
+			return nil
+		}
+	}
+	return errNotShape
+}
+
+func (g *CSSGradient) parseRadialShape(arg string) error {
+	words := strings.Fields(strings.ToLower(arg))
+	shape := false
+	for i := 0; i < len(words); i++ {
+		switch w := words[i]; w {
+		case "circle", "ellipse":
+		case "closest-side", "closest-corner", "farthest-side", "farthest-corner":
+			g.size = w
+		case "at":
+			pos := words[i+1:]
+			if len(pos) == 0 || len(pos) > 2 {
+				return fmt.Errorf("Invalid gradient position %q", arg)
+			}
+			if len(pos) == 1 {
+				pos = append(pos, "center")
+			}
+			// keywords for the vertical axis can come first
+			if pos[0] == "top" || pos[0] == "bottom" || pos[1] == "left" || pos[1] == "right" {
+				pos[0], pos[1] = pos[1], pos[0]
+			}
+			for axis, p := range pos {
+				l, ok := parseCSSPosition(p, axis)
+				if !ok {
+					return fmt.Errorf("Invalid gradient position %q", arg)
+				}
+				g.at[axis] = l
+			}
+			return nil
+		default:
+			l, ok := parseCSSLength(w)
+			if !ok || l.percent {
+				if shape {
+					return fmt.Errorf("Invalid gradient shape %q", arg)
+				}
+				return errNotShape
+			}
+			g.size, g.radius = "", l.v
+		}
+		shape = true
+	}
+	return nil
+}
+
+func parseCSSPosition(s string, axis int) (cssLength, bool) {
+	switch s {
+	case "center":
+		return cssLength{50, true}, true
+	case "left", "top":
+		if (s == "left") != (axis == 0) {
+			return cssLength{}, false
+		}
+		return cssLength{0, true}, true
+	case "right", "bottom":
+		if (s == "right") != (axis == 0) {
+			return cssLength{}, false
+		}
+		return cssLength{100, true}, true
+	}
+	return parseCSSLength(s)
+}
+
+func parseCSSLength(s string) (cssLength, bool) {
+	var l cssLength
+	switch {
+	case strings.HasSuffix(s, "%"):
+		s, l.percent = s[:len(s)-1], true
+	case strings.HasSuffix(s, "px"):
+		s = s[:len(s)-2]
+	case s != "0":
+		return l, false
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return l, false
+	}
+	l.v = v
+	return l, true
+}
+
+// parseCSSColor parses a color like parseColor, plus the CSS color names
+func parseCSSColor(s string) (color.RGBA, bool) {
+	name := strings.ToLower(s)
+	if name == "transparent" {
+		return color.RGBA{}, true
+	}
+	if c, ok := cssColorName(name); ok {
+		return c, true
+	}
+	if strings.HasPrefix(name, "#") || strings.HasPrefix(name, "rgb") {
+		return parseColor(s)
+	}
+	return color.RGBA{}, false
+}
+
+// splitCSS splits the string at the separator outside of parentheses and
+// drops empty parts, so that spaces within colors don't split stops
+func splitCSS(s string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	add := func(p string) {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				add(s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	add(s[start:])
+	return parts
+}
+
+// FillStyle creates the gradient for the box at x/y with the size w/h in
+// the current coordinates of the canvas. The result is a *LinearGradient
+// or *RadialGradient that can be passed to SetFillStyle and
+// SetStrokeStyle
+func (g *CSSGradient) FillStyle(cv *Canvas, x, y, w, h float64) interface{} {
+	if g.radial {
+		cx, cy := x+g.at[0].resolve(w), y+g.at[1].resolve(h)
+		r := g.radialSize(cx-x, x+w-cx, cy-y, y+h-cy)
+		rg := cv.CreateRadialGradient(cx, cy, 0, cx, cy, r)
+		for _, s := range g.resolveStops(r) {
+			rg.AddColorStop(s.Pos, s.Color)
+		}
+		return rg
+	}
+
+	var dir BackendVec
+	if g.corner != [2]float64{} {
+		// the line through the other two corners is perpendicular to
+		// the gradient, like in CSS
+		dir = BackendVec{g.corner[0] * h, g.corner[1] * w}.Norm()
+	} else {
+		s, c := math.Sincos(g.angle)
+		dir = BackendVec{s, -c}
+	}
+	length := math.Abs(w*dir[0]) + math.Abs(h*dir[1])
+	center := BackendVec{x + w/2, y + h/2}
+	from := center.Sub(dir.Mulf(length / 2))
+	to := center.Add(dir.Mulf(length / 2))
+	lg := cv.CreateLinearGradient(from[0], from[1], to[0], to[1])
+	for _, s := range g.resolveStops(length) {
+		lg.AddColorStop(s.Pos, s.Color)
+	}
+	return lg
+}
+
+// radialSize returns the radius from the distances of the center to the
+// sides of the box
+func (g *CSSGradient) radialSize(left, right, top, bottom float64) float64 {
+	dx := [2]float64{math.Abs(left), math.Abs(right)}
+	dy := [2]float64{math.Abs(top), math.Abs(bottom)}
+	switch g.size {
+	case "closest-side":
+		return math.Min(math.Min(dx[0], dx[1]), math.Min(dy[0], dy[1]))
+	case "farthest-side":
+		return math.Max(math.Max(dx[0], dx[1]), math.Max(dy[0], dy[1]))
+	case "closest-corner":
+		return math.Hypot(math.Min(dx[0], dx[1]), math.Min(dy[0], dy[1]))
+	case "farthest-corner":
+		return math.Hypot(math.Max(dx[0], dx[1]), math.Max(dy[0], dy[1]))
+	}
+	return g.radius
+}
+
+// resolveStops returns the stops with positions between 0 and 1 for a
+// gradient of the given length, filling in missing positions like CSS
+func (g *CSSGradient) resolveStops(length float64) BackendGradient {
+	stops := make(BackendGradient, len(g.stops))
+	for i, s := range g.stops {
+		stops[i].Color = s.color
+		stops[i].Pos = math.NaN()
+		if !math.IsNaN(s.pos.v) {
+			if s.pos.percent {
+				stops[i].Pos = s.pos.v / 100
+			} else if length > 0 {
+				stops[i].Pos = s.pos.v / length
+			} else {
+				stops[i].Pos = 0
+			}
+		}
+	}
+	last := len(stops) - 1
+	if math.IsNaN(stops[0].Pos) {
+		stops[0].Pos = 0
+	}
+	if math.IsNaN(stops[last].Pos) {
+		stops[last].Pos = 1
+	}
+	// positions can't go back
+	prev := stops[0].Pos
+	for i := 1; i <= last; i++ {
+		if math.IsNaN(stops[i].Pos) {
+			continue
+		}
+		prev = math.Max(prev, stops[i].Pos)
+		stops[i].Pos = prev
+	}
+	// missing positions are spread evenly between the known ones
+	for i := 1; i < last; i++ {
+		if !math.IsNaN(stops[i].Pos) {
+			continue
+		}
+		j := i
+		for math.IsNaN(stops[j].Pos) {
+			j++
+		}
+		from, to := stops[i-1].Pos, stops[j].Pos
+		for k := i; k < j; k++ {
+			stops[k].Pos = from + (to-from)*float64(k-i+1)/float64(j-i+1)
+		}
+		i = j
+	}
+	for i := range stops {
+		stops[i].Pos = math.Max(0, math.Min(1, stops[i].Pos))
+	}
+	return stops
+}
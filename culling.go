@@ -0,0 +1,95 @@
+package canvas
+
+import (
+	"image"
+	"math"
+)
+
+// ClipBoundsBackend is implemented by backends that know the bounding
+// rectangle of their clipping region, so that the canvas can skip
+// shapes outside of it before they are triangulated
+type ClipBoundsBackend interface {
+	ClipBounds() image.Rectangle
+}
+
+// ClipBounds returns the bounding rectangle of the clipping region
+func (b *SoftwareBackend) ClipBounds() image.Rectangle {
+	return b.clipRect
+}
+
+// visibleBounds returns the part of the canvas that can be drawn to
+func (cv *Canvas) visibleBounds() Bounds {
+	w, h := cv.b.Size()
+	r := image.Rect(0, 0, w, h)
+	if !cv.renderBounds.Empty() {
+		r = r.Intersect(cv.renderBounds)
+	}
+	if cb, ok := cv.b.(ClipBoundsBackend); ok {
+		r = r.Intersect(cb.ClipBounds())
+	}
+	if r.Empty() {
+		return EmptyBounds
+	}
+	return Bounds{MinX: float64(r.Min.X), MinY: float64(r.Min.Y), MaxX: float64(r.Max.X), MaxY: float64(r.Max.Y)}
+}
+
+// culled returns whether a fill with the bounds in canvas coordinates
+// is outside of the visible part of the canvas, and counts it. The
+// bounds are grown by a pixel for the anti-aliasing. Bounds of points
+// that are not a number are never culled, the backend skips only the
+// triangles with such points
+func (cv *Canvas) culled(b Bounds) bool {
+	if math.IsNaN(b.MinX + b.MinY + b.MaxX + b.MaxY) {
+		return false
+	}
+	if b.Grow(1).Intersects(cv.visibleBounds()) {
+		return false
+	}
+	cv.stats.cur.Culled++
+	return true
+}
+
+// culledWithShadow is like culled, but keeps shapes whose shadow is
+// visible
+func (cv *Canvas) culledWithShadow(b Bounds) bool {
+	st := &cv.state
+	if st.shadowColor.A != 0 && !st.shadowInset {
+		margin := math.Abs(st.shadowSpread) + st.shadowBlur*3 + 1
+		shadow := Bounds{
+			MinX: b.MinX + st.shadowOffsetX, MinY: b.MinY + st.shadowOffsetY,
+			MaxX: b.MaxX + st.shadowOffsetX, MaxY: b.MaxY + st.shadowOffsetY,
+		}
+		b = b.Union(shadow.Grow(margin))
+	}
+	return cv.culled(b)
+}
+
+// transformedBounds returns the bounds of the points transformed with
+// the matrix
+func transformedBounds(pts []BackendVec, tf BackendMat) Bounds {
+	if tf == BackendMatIdentity {
+		return BoundsOf(pts)
+	}
+	b := EmptyBounds
+	for _, pt := range pts {
+		pt = pt.MulMat(tf)
+		b.MinX, b.MaxX = math.Min(b.MinX, pt[0]), math.Max(b.MaxX, pt[0])
+		b.MinY, b.MaxY = math.Min(b.MinY, pt[1]), math.Max(b.MaxY, pt[1])
+	}
+	return b
+}
+
+// pathBounds returns the bounds of the points of the path transformed
+// with the matrix. Arcs and curves are already flattened to points
+func pathBounds(path []pathPoint, tf BackendMat) Bounds {
+	b := EmptyBounds
+	for _, p := range path {
+		b.MinX, b.MaxX = math.Min(b.MinX, p.pos[0]), math.Max(b.MaxX, p.pos[0])
+		b.MinY, b.MaxY = math.Min(b.MinY, p.pos[1]), math.Max(b.MaxY, p.pos[1])
+	}
+	if tf == BackendMatIdentity || b.Empty() {
+		return b
+	}
+	corners := [4]BackendVec{{b.MinX, b.MinY}, {b.MinX, b.MaxY}, {b.MaxX, b.MaxY}, {b.MaxX, b.MinY}}
+	return transformedBounds(corners[:], tf)
+}
@@ -0,0 +1,213 @@
+package canvas
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// DamageTracker is a debugging backend that passes all calls to a target
+// backend and keeps track of which pixels each frame draws to, and how
+// often. It helps to find out how much of the screen a redraw strategy
+// actually touches. The regions are based on the bounds of the drawing
+// calls, so pixels that are cut off by the clipping region or that are
+// inside of the bounds of a shape but not covered by it are included
+type DamageTracker struct {
+	target Backend
+
+	w, h   int
+	counts []uint16
+	bounds []Bounds
+
+	last       []uint16
+	lastBounds []Bounds
+}
+
+// DamageOverlayColors are the colors that DrawOverlay tints pixels with
+// that were drawn to once, twice, three times, and four or more times
+var DamageOverlayColors = [4]color.RGBA{
+	{G: 255, A: 64},
+	{R: 255, G: 255, A: 96},
+	{R: 255, G: 128, A: 128},
+	{R: 255, A: 160},
+}
+
+// NewDamageTracker creates a new damage tracker for the given target
+func NewDamageTracker(target Backend) *DamageTracker {
+	return &DamageTracker{target: target}
+}
+
+// Target returns the backend that the calls are passed to
+func (dt *DamageTracker) Target() Backend { return dt.target }
+
+// BeginFrame passes the beginning of a frame to the target
+func (dt *DamageTracker) BeginFrame() { beginFrame(dt.target) }
+
+// EndFrame finishes the current frame. Damage, Overdraw and DrawOverlay
+// show the frame that was finished last. EndFrame of the canvas calls it
+func (dt *DamageTracker) EndFrame() {
+	endFrame(dt.target)
+	dt.last, dt.counts = dt.counts, dt.last
+	dt.lastBounds, dt.bounds = dt.bounds, dt.lastBounds[:0]
+	if len(dt.counts) != len(dt.last) {
+		dt.counts = make([]uint16, len(dt.last))
+	}
+	for i := range dt.counts {
+		dt.counts[i] = 0
+	}
+}
+
+// Damage returns the regions of the last frame that were drawn to, with
+// overlapping and touching regions merged
+func (dt *DamageTracker) Damage() []image.Rectangle {
+	return mergeDamage(dt.lastBounds)
+}
+
+// Overdraw returns how often the pixel at x/y was drawn to in the last
+// frame
+func (dt *DamageTracker) Overdraw(x, y int) int {
+	if x < 0 || y < 0 || x >= dt.w || y >= dt.h || len(dt.last) != dt.w*dt.h {
+		return 0
+	}
+	return int(dt.last[y*dt.w+x])
+}
+
+// DrawOverlay tints all pixels of the target that were drawn to in the
+// last frame with the DamageOverlayColors. The tint is drawn with the
+// current clipping region and is not counted as damage
+func (dt *DamageTracker) DrawOverlay(cv *Canvas) {
+	cv.Flush()
+	if len(dt.last) != dt.w*dt.h || dt.w == 0 || dt.h == 0 {
+		return
+	}
+	var masks [len(DamageOverlayColors)]*image.Alpha
+	for i, c := range dt.last {
+		if c == 0 {
+			continue
+		}
+		level := int(c) - 1
+		if level >= len(masks) {
+			level = len(masks) - 1
+		}
+		if masks[level] == nil {
+			masks[level] = image.NewAlpha(image.Rect(0, 0, dt.w, dt.h))
+		}
+		masks[level].Pix[i] = 255
+	}
+	fw, fh := float64(dt.w), float64(dt.h)
+	pts := [4]BackendVec{{0, 0}, {0, fh}, {fw, fh}, {fw, 0}}
+	for i, mask := range masks {
+		if mask != nil {
+			style := BackendFillStyle{Color: DamageOverlayColors[i]}
+			dt.target.FillImageMask(&style, mask, pts)
+		}
+	}
+}
+
+func (dt *DamageTracker) damage(b Bounds) {
+	w, h := dt.target.Size()
+	if w != dt.w || h != dt.h {
+		dt.w, dt.h = w, h
+		dt.counts = make([]uint16, w*h)
+		dt.last = nil
+	}
+	dt.bounds = append(dt.bounds, b)
+
+	x0 := int(math.Max(math.Floor(b.MinX), 0))
+	y0 := int(math.Max(math.Floor(b.MinY), 0))
+	x1 := int(math.Min(math.Ceil(b.MaxX), float64(w)))
+	y1 := int(math.Min(math.Ceil(b.MaxY), float64(h)))
+	for y := y0; y < y1; y++ {
+		row := dt.counts[y*w : y*w+w]
+		for x := x0; x < x1; x++ {
+			if row[x] < math.MaxUint16 {
+				row[x]++
+			}
+		}
+	}
+}
+
+func (dt *DamageTracker) Size() (int, int) { return dt.target.Size() }
+
+func (dt *DamageTracker) LoadImage(img image.Image) (BackendImage, error) {
+	return dt.target.LoadImage(img)
+}
+
+func (dt *DamageTracker) LoadImageMipmap(img image.Image, quality MipmapQuality) (BackendImage, error) {
+	return loadImageMipmap(dt.target, img, quality)
+}
+
+func (dt *DamageTracker) LoadImageYUV(y, u, v []byte, format YUVFormat) (BackendImage, error) {
+	return loadImageYUV(dt.target, y, u, v, format)
+}
+
+func (dt *DamageTracker) LoadImagePattern(data BackendImagePatternData) BackendImagePattern {
+	return dt.target.LoadImagePattern(data)
+}
+
+func (dt *DamageTracker) LoadLinearGradient(data BackendGradient) BackendLinearGradient {
+	return dt.target.LoadLinearGradient(data)
+}
+
+func (dt *DamageTracker) LoadRadialGradient(data BackendGradient) BackendRadialGradient {
+	return dt.target.LoadRadialGradient(data)
+}
+
+func (dt *DamageTracker) Clear(pts [4]BackendVec) {
+	dt.damage(BoundsOf(pts[:]))
+	dt.target.Clear(pts)
+}
+
+func (dt *DamageTracker) Fill(style *BackendFillStyle, pts []BackendVec, tf BackendMat, canOverlap bool) {
+	b := EmptyBounds
+	for _, pt := range pts {
+		pt = pt.MulMat(tf)
+		b = b.Union(Bounds{MinX: pt[0], MinY: pt[1], MaxX: pt[0], MaxY: pt[1]})
+	}
+	dt.damage(b.Grow(style.Blur * 3))
+	dt.target.Fill(style, pts, tf, canOverlap)
+}
+
+func (dt *DamageTracker) DrawImage(dimg BackendImage, sx, sy, sw, sh float64, pts [4]BackendVec, alpha float64) {
+	dt.damage(BoundsOf(pts[:]))
+	dt.target.DrawImage(dimg, sx, sy, sw, sh, pts, alpha)
+}
+
+func (dt *DamageTracker) FillImageMask(style *BackendFillStyle, mask *image.Alpha, pts [4]BackendVec) {
+	dt.damage(BoundsOf(pts[:]))
+	dt.target.FillImageMask(style, mask, pts)
+}
+
+func (dt *DamageTracker) FillTrianglesVertexColor(pts []BackendVec, colors []color.RGBA) {
+	dt.damage(BoundsOf(pts))
+	dt.target.FillTrianglesVertexColor(pts, colors)
+}
+
+func (dt *DamageTracker) ClearClip()            { dt.target.ClearClip() }
+func (dt *DamageTracker) Clip(pts []BackendVec) { dt.target.Clip(pts) }
+
+func (dt *DamageTracker) GetImageData(x, y, w, h int) *image.RGBA {
+	return dt.target.GetImageData(x, y, w, h)
+}
+
+func (dt *DamageTracker) GetImageDataInto(dst []byte, x, y, w, h, stride int) {
+	getImageDataInto(dt.target, dst, x, y, w, h, stride)
+}
+
+func (dt *DamageTracker) GetImageDataAsync(x, y, w, h int) <-chan *image.RGBA {
+	return getImageDataAsync(dt.target, x, y, w, h)
+}
+
+func (dt *DamageTracker) PutImageData(img *image.RGBA, x, y int) {
+	r := img.Rect
+	dt.damage(Bounds{
+		MinX: float64(x), MinY: float64(y),
+		MaxX: float64(x + r.Dx()), MaxY: float64(y + r.Dy()),
+	})
+	dt.target.PutImageData(img, x, y)
+}
+
+func (dt *DamageTracker) CanUseAsImage(b Backend) bool { return dt.target.CanUseAsImage(b) }
+func (dt *DamageTracker) AsImage() BackendImage        { return dt.target.AsImage() }
+
+func (dt *DamageTracker) Capabilities() BackendCapabilities { return dt.target.Capabilities() }
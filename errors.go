@@ -0,0 +1,64 @@
+package canvas
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Errors reported by the canvas. They can be checked with errors.Is on
+// the errors returned by functions and passed to the error handler
+var (
+	// ErrUnsupportedSource means that an image or font source is
+	// not of a supported type
+	ErrUnsupportedSource = errors.New("Unsupported source type")
+	// ErrImageDeleted means that an image was used after Delete
+	ErrImageDeleted = errors.New("Image was deleted")
+	// ErrImageOtherCanvas means that an image loaded with a different
+	// canvas was used
+	ErrImageOtherCanvas = errors.New("Image was loaded with a different canvas")
+	// ErrInvalidPath means that the points of a shape can not be drawn
+	ErrInvalidPath = errors.New("Invalid path")
+	// ErrInvalidArguments means that a function was called with an
+	// invalid number of arguments
+	ErrInvalidArguments = errors.New("Invalid number of arguments")
+	// ErrNoFont means that text was drawn or a font was set without a
+	// font being loaded
+	ErrNoFont = errors.New("No font loaded")
+	// ErrSubImageReplace means that Replace was called on a sub-image,
+	// which shares its pixels with the parent image
+	ErrSubImageReplace = errors.New("Sub-images can not be replaced")
+	// ErrInvalidTrace means that a trace written by a TracingBackend
+	// could not be replayed
+	ErrInvalidTrace = errors.New("Invalid trace")
+	// ErrInvalidChannel means that a Channel other than the Channel
+	// constants was used
+	ErrInvalidChannel = errors.New("Invalid channel")
+)
+
+// Err returns the first non-fatal error that occurred while drawing since
+// the canvas was created or ClearErr was called. Drawing functions don't
+// return errors, instead the call that caused the error draws nothing
+func (cv *Canvas) Err() error { return cv.err }
+
+// ClearErr resets the error returned by Err
+func (cv *Canvas) ClearErr() { cv.err = nil }
+
+// SetErrorHandler sets a function that is called with every non-fatal
+// error as it occurs. By default errors are written to stderr. A nil
+// handler ignores the errors, they are still available with Err
+func (cv *Canvas) SetErrorHandler(fn func(err error)) {
+	cv.errHandler = fn
+	cv.errHandlerSet = true
+}
+
+func (cv *Canvas) reportError(err error) {
+	if cv.err == nil {
+		cv.err = err
+	}
+	if !cv.errHandlerSet {
+		fmt.Fprintln(os.Stderr, err)
+	} else if cv.errHandler != nil {
+		cv.errHandler(err)
+	}
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
+	"io/ioutil"
 	"math"
-	"os"
 
 	"github.com/opentoys/canvas"
 )
@@ -30,7 +30,7 @@ func main() {
 	cv.Arc(w*0.5, h*0.5, math.Min(w, h)*0.4, 0, math.Pi*2, false)
 	cv.Stroke()
 
-	os.WriteFile("asdasfd.png", backend.Bytes(), 0o777)
+	ioutil.WriteFile("asdasfd.png", backend.Bytes(), 0o777)
 	// f, err := os.OpenFile("result.png", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0777)
 	// if err != nil {
 	// 	panic(err)
@@ -0,0 +1,165 @@
+package canvas
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// EXRPixelType is the channel format used by WriteEXR
+type EXRPixelType uint8
+
+// EXR pixel type constants for WriteEXR
+const (
+	// EXRHalf stores 16 bit floating point channels
+	EXRHalf EXRPixelType = 1
+	// EXRFloat stores 32 bit floating point channels
+	EXRFloat EXRPixelType = 2
+)
+
+// WriteEXR writes the image of the backend as an uncompressed OpenEXR
+// file with RGBA channels of the given pixel type. As is the convention
+// for EXR files, the colors are converted from sRGB to linear values and
+// premultiplied with alpha
+func (b *SoftwareBackend) WriteEXR(w io.Writer, pixelType EXRPixelType) error {
+	if pixelType != EXRHalf && pixelType != EXRFloat {
+		return errors.New("Unsupported EXR pixel type")
+	}
+	width, height := b.Image.Rect.Dx(), b.Image.Rect.Dy()
+	if width == 0 || height == 0 {
+		return errors.New("Can not write an empty image as EXR")
+	}
+
+	le := binary.LittleEndian
+	var buf [8]byte
+	var hdr bytes.Buffer
+	writeInt32 := func(w io.Writer, v int) {
+		le.PutUint32(buf[:4], uint32(int32(v)))
+		w.Write(buf[:4])
+	}
+	writeFloat32 := func(w io.Writer, v float32) {
+		le.PutUint32(buf[:4], math.Float32bits(v))
+		w.Write(buf[:4])
+	}
+	attribute := func(name, typ string, size int) {
+		hdr.WriteString(name)
+		hdr.WriteByte(0)
+		hdr.WriteString(typ)
+		hdr.WriteByte(0)
+		writeInt32(&hdr, size)
+	}
+
+	// magic number and version 2, single part scan line file
+	hdr.Write([]byte{0x76, 0x2f, 0x31, 0x01, 2, 0, 0, 0})
+
+	channels := "ABGR"
+	attribute("channels", "chlist", len(channels)*18+1)
+	for _, c := range channels {
+		hdr.WriteByte(byte(c))
+		hdr.WriteByte(0)
+		writeInt32(&hdr, int(pixelType))
+		hdr.Write([]byte{0, 0, 0, 0})
+		writeInt32(&hdr, 1)
+		writeInt32(&hdr, 1)
+	}
+	hdr.WriteByte(0)
+	attribute("compression", "compression", 1)
+	hdr.WriteByte(0)
+	for _, name := range []string{"dataWindow", "displayWindow"} {
+		attribute(name, "box2i", 16)
+		writeInt32(&hdr, 0)
+		writeInt32(&hdr, 0)
+		writeInt32(&hdr, width-1)
+		writeInt32(&hdr, height-1)
+	}
+	attribute("lineOrder", "lineOrder", 1)
+	hdr.WriteByte(0)
+	attribute("pixelAspectRatio", "float", 4)
+	writeFloat32(&hdr, 1)
+	attribute("screenWindowCenter", "v2f", 8)
+	writeFloat32(&hdr, 0)
+	writeFloat32(&hdr, 0)
+	attribute("screenWindowWidth", "float", 4)
+	writeFloat32(&hdr, 1)
+	hdr.WriteByte(0)
+
+	bw := bufio.NewWriter(w)
+	bw.Write(hdr.Bytes())
+
+	// offset table with one scan line per block
+	channelSize := 2
+	if pixelType == EXRFloat {
+		channelSize = 4
+	}
+	lineSize := width * len(channels) * channelSize
+	offset := hdr.Len() + 8*height
+	for y := 0; y < height; y++ {
+		le.PutUint64(buf[:], uint64(offset+y*(8+lineSize)))
+		bw.Write(buf[:])
+	}
+
+	var toLinear [256]float32
+	for i := range toLinear {
+		toLinear[i] = float32(srgbToLinear(float64(i) / 255))
+	}
+	line := make([]float32, width*len(channels))
+	for y := 0; y < height; y++ {
+		writeInt32(bw, y)
+		writeInt32(bw, lineSize)
+		for x := 0; x < width; x++ {
+			col := b.Image.RGBAAt(b.Image.Rect.Min.X+x, b.Image.Rect.Min.Y+y)
+			a := float32(col.A) / 255
+			line[x] = a
+			line[width+x] = toLinear[col.B] * a
+			line[2*width+x] = toLinear[col.G] * a
+			line[3*width+x] = toLinear[col.R] * a
+		}
+		for _, v := range line {
+			if pixelType == EXRFloat {
+				writeFloat32(bw, v)
+			} else {
+				le.PutUint16(buf[:2], float32ToHalf(v))
+				bw.Write(buf[:2])
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// float32ToHalf converts a float to a 16 bit float, rounding to the
+// nearest value. Only values in the range [0,1] are expected, so values
+// that are too large for a half are not handled
+func float32ToHalf(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int(bits>>23&0xff) - 127 + 15
+	mant := bits & 0x7fffff
+	if exp <= 0 {
+		if exp < -10 {
+			return sign
+		}
+		// subnormal half
+		mant |= 0x800000
+		shift := uint(14 - exp)
+		half := mant >> shift
+		if mant>>(shift-1)&1 != 0 {
+			half++
+		}
+		return sign | uint16(half)
+	}
+	half := uint32(exp)<<10 | mant>>13
+	if mant&0x1000 != 0 {
+		half++
+	}
+	return sign | uint16(half)
+}
@@ -0,0 +1,125 @@
+package canvas
+
+import (
+	"image"
+	"image/color"
+)
+
+// FloodFill fills the area of connected pixels around x/y that have
+// about the same color as the pixel at x/y, like the paint bucket of
+// painting applications. A pixel belongs to the area if none of its
+// channels differ by more than tolerance from the starting pixel. With
+// antialias, the pixels around the area are blended with the fill color
+// by how similar they are to the starting pixel, which keeps the
+// anti-aliased edges of shapes smooth. It returns the number of filled
+// pixels. Like PutImageData, this ignores the transformation and the
+// clipping region
+func (cv *Canvas) FloodFill(x, y int, fill color.RGBA, tolerance uint8, antialias bool) int {
+	filled := 0
+	cv.editPixels(image.Rect(0, 0, cv.Width(), cv.Height()), true, func(img *image.RGBA) {
+		if !(image.Point{x, y}.In(img.Rect)) {
+			return
+		}
+		w, h := img.Rect.Dx(), img.Rect.Dy()
+		x, y := x-img.Rect.Min.X, y-img.Rect.Min.Y
+		at := func(x, y int) []uint8 {
+			i := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+			return img.Pix[i : i+4 : i+4]
+		}
+		seed := rgbaFromPix(at(x, y))
+		done := make([]bool, w*h)
+		inside := func(x, y int) bool {
+			return !done[y*w+x] && colorDistance(rgbaFromPix(at(x, y)), seed) <= int(tolerance)
+		}
+
+		// scanline fill: fill the whole run of a seed, then add one seed
+		// for each run of matching pixels above and below it
+		stack := []image.Point{{x, y}}
+		for len(stack) > 0 {
+			p := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if !inside(p.X, p.Y) {
+				continue
+			}
+			x0, x1 := p.X, p.X
+			for x0 > 0 && inside(x0-1, p.Y) {
+				x0--
+			}
+			for x1 < w-1 && inside(x1+1, p.Y) {
+				x1++
+			}
+			for px := x0; px <= x1; px++ {
+				done[p.Y*w+px] = true
+				c := at(px, p.Y)
+				c[0], c[1], c[2], c[3] = fill.R, fill.G, fill.B, fill.A
+			}
+			filled += x1 - x0 + 1
+			for _, ny := range [2]int{p.Y - 1, p.Y + 1} {
+				if ny < 0 || ny >= h {
+					continue
+				}
+				run := false
+				for px := x0; px <= x1; px++ {
+					if inside(px, ny) {
+						if !run {
+							stack = append(stack, image.Point{px, ny})
+						}
+						run = true
+					} else {
+						run = false
+					}
+				}
+			}
+		}
+
+		if !antialias || tolerance == 255 {
+			return
+		}
+		// blend the pixels next to the area, each once
+		edge := make([]bool, w*h)
+		for py := 0; py < h; py++ {
+			for px := 0; px < w; px++ {
+				if !done[py*w+px] {
+					continue
+				}
+				for _, n := range [4]image.Point{{px - 1, py}, {px + 1, py}, {px, py - 1}, {px, py + 1}} {
+					if n.X < 0 || n.Y < 0 || n.X >= w || n.Y >= h || done[n.Y*w+n.X] || edge[n.Y*w+n.X] {
+						continue
+					}
+					edge[n.Y*w+n.X] = true
+					p := at(n.X, n.Y)
+					c := rgbaFromPix(p)
+					d := colorDistance(c, seed) - int(tolerance)
+					weight := 1 - float64(d)/float64(255-int(tolerance))
+					if weight <= 0 {
+						continue
+					}
+					src := fill
+					src.A = uint8(float64(fill.A)*weight + 0.5)
+					c = mix(src, c)
+					p[0], p[1], p[2], p[3] = c.R, c.G, c.B, c.A
+				}
+			}
+		}
+	})
+	return filled
+}
+
+func rgbaFromPix(p []uint8) color.RGBA {
+	return color.RGBA{R: p[0], G: p[1], B: p[2], A: p[3]}
+}
+
+// colorDistance returns the largest difference between the channels of
+// the two colors
+func colorDistance(a, b color.RGBA) int {
+	d := 0
+	for _, v := range [4]int{int(a.R) - int(b.R), int(a.G) - int(b.G), int(a.B) - int(b.B), int(a.A) - int(b.A)} {
+		if v < 0 {
+			v = -v
+		}
+		if v > d {
+			d = v
+		}
+	}
+	return d
+}
@@ -0,0 +1,66 @@
+package canvas
+
+// FrameBackend is implemented by backends that do work per frame, like
+// swapping buffers, submitting command buffers or computing the regions
+// that changed. The BeginFrame and EndFrame functions of the canvas call
+// them. BeginFrame is optional for the application, so backends have to
+// handle EndFrame without a BeginFrame before it
+type FrameBackend interface {
+	BeginFrame()
+	EndFrame()
+}
+
+// frameState is the frame lifecycle state of a canvas
+type frameState struct {
+	begun bool
+
+	onBegin   func()
+	onPresent func(stats RenderStats)
+}
+
+// SetFrameHandlers sets the functions that are called when a frame
+// begins and after it ended and the backend finished it, for example to
+// present the software backend image on the screen. The present function
+// gets the counters of the frame. Either function may be nil
+func (cv *Canvas) SetFrameHandlers(begin func(), present func(stats RenderStats)) {
+	cv.frame.onBegin, cv.frame.onPresent = begin, present
+}
+
+// BeginFrame begins a frame, which ends with EndFrame. Calling it is
+// optional, without it each frame begins when the previous one ended.
+// It does nothing if the frame already began
+func (cv *Canvas) BeginFrame() {
+	if cv.frame.begun {
+		return
+	}
+	cv.frame.begun = true
+	beginFrame(cv.b)
+	if cv.frame.onBegin != nil {
+		cv.frame.onBegin()
+	}
+}
+
+func beginFrame(b Backend) {
+	if fb, ok := b.(FrameBackend); ok {
+		fb.BeginFrame()
+	}
+}
+
+func endFrame(b Backend) {
+	if fb, ok := b.(FrameBackend); ok {
+		fb.EndFrame()
+	}
+}
+
+// BeginFrame does nothing, the software backend draws right away
+func (b *SoftwareBackend) BeginFrame() {}
+
+// EndFrame releases the buffers of the backend that the frame did not
+// use, so that memory needed for a few frames is not kept forever
+func (b *SoftwareBackend) EndFrame() {
+	if !b.msaaUsed {
+		b.msaaHeads = nil
+	}
+	b.msaaUsed = false
+	b.blurSwap = nil
+}
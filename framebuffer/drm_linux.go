@@ -0,0 +1,387 @@
+//go:build linux
+// +build linux
+
+package framebuffer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// drmIOWR returns the request number of a read/write DRM ioctl
+func drmIOWR(nr, size uintptr) uintptr {
+	return 3<<30 | size<<16 | 'd'<<8 | nr
+}
+
+var (
+	drmModeGetResources = drmIOWR(0xA0, unsafe.Sizeof(drmModeCardRes{}))
+	drmModeGetCrtc      = drmIOWR(0xA1, unsafe.Sizeof(drmModeCrtc{}))
+	drmModeSetCrtc      = drmIOWR(0xA2, unsafe.Sizeof(drmModeCrtc{}))
+	drmModeGetEncoder   = drmIOWR(0xA6, unsafe.Sizeof(drmModeGetEncoderArg{}))
+	drmModeGetConnector = drmIOWR(0xA7, unsafe.Sizeof(drmModeGetConnectorArg{}))
+	drmModeAddFB        = drmIOWR(0xAE, unsafe.Sizeof(drmModeFBCmd{}))
+	drmModeRmFB         = drmIOWR(0xAF, unsafe.Sizeof(uint32(0)))
+	drmModePageFlip     = drmIOWR(0xB0, unsafe.Sizeof(drmModeCrtcPageFlip{}))
+	drmModeCreateDumb   = drmIOWR(0xB2, unsafe.Sizeof(drmModeCreateDumbArg{}))
+	drmModeMapDumb      = drmIOWR(0xB3, unsafe.Sizeof(drmModeMapDumbArg{}))
+	drmModeDestroyDumb  = drmIOWR(0xB4, unsafe.Sizeof(uint32(0)))
+)
+
+const (
+	drmModeConnected      = 1
+	drmModeTypePreferred  = 1 << 3
+	drmModePageFlipEvent  = 1
+	drmEventFlipComplete  = 2
+	drmEventHeaderSize    = 8
+	drmEventVBlankMinSize = 32
+)
+
+type drmModeCardRes struct {
+	FBIDPtr, CrtcIDPtr, ConnectorIDPtr, EncoderIDPtr     uint64
+	CountFBs, CountCrtcs, CountConnectors, CountEncoders uint32
+	MinWidth, MaxWidth, MinHeight, MaxHeight             uint32
+}
+
+type drmModeModeInfo struct {
+	Clock                                         uint32
+	HDisplay, HSyncStart, HSyncEnd, HTotal, HSkew uint16
+	VDisplay, VSyncStart, VSyncEnd, VTotal, VScan uint16
+	VRefresh, Flags, Type                         uint32
+	Name                                          [32]byte
+}
+
+type drmModeGetConnectorArg struct {
+	EncodersPtr, ModesPtr, PropsPtr, PropValuesPtr uint64
+	CountModes, CountProps, CountEncoders          uint32
+	EncoderID, ConnectorID                         uint32
+	ConnectorType, ConnectorTypeID                 uint32
+	Connection, MMWidth, MMHeight, Subpixel        uint32
+	Pad                                            uint32
+}
+
+type drmModeGetEncoderArg struct {
+	EncoderID, EncoderType, CrtcID uint32
+	PossibleCrtcs, PossibleClones  uint32
+}
+
+type drmModeCrtc struct {
+	SetConnectorsPtr uint64
+	CountConnectors  uint32
+	CrtcID, FBID     uint32
+	X, Y             uint32
+	GammaSize        uint32
+	ModeValid        uint32
+	Mode             drmModeModeInfo
+}
+
+type drmModeCreateDumbArg struct {
+	Height, Width, BPP, Flags uint32
+	Handle, Pitch             uint32
+	Size                      uint64
+}
+
+type drmModeMapDumbArg struct {
+	Handle, Pad uint32
+	Offset      uint64
+}
+
+type drmModeFBCmd struct {
+	FBID, Width, Height, Pitch uint32
+	BPP, Depth, Handle         uint32
+}
+
+type drmModeCrtcPageFlip struct {
+	CrtcID, FBID, Flags, Reserved uint32
+	UserData                      uint64
+}
+
+// dumbBuffer is a mapped dumb buffer with a framebuffer id
+type dumbBuffer struct {
+	handle, fb uint32
+	pitch      int
+	mem        []byte
+}
+
+// DRM is a display driven through a DRM/KMS device such as
+// /dev/dri/card0, with two dumb buffers that are flipped on vsync
+type DRM struct {
+	f         *os.File
+	w, h      int
+	connector uint32
+	crtc      uint32
+	bufs      [2]dumbBuffer
+	front     int
+	// saved is the CRTC state before the device was opened
+	saved drmModeCrtc
+}
+
+func sliceAddr(p unsafe.Pointer) uint64 { return uint64(uintptr(p)) }
+
+// OpenDRM opens a DRM device such as /dev/dri/card0 and shows a black
+// screen on the first connected display in its preferred mode. Setting
+// the mode requires that no other program, such as a display server,
+// controls the device
+func OpenDRM(path string) (*DRM, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	d := &DRM{f: f}
+	if err := d.init(); err != nil {
+		d.release()
+		return nil, fmt.Errorf("Opening %s: %w", path, err)
+	}
+	return d, nil
+}
+
+func (d *DRM) ioctl(req uintptr, arg unsafe.Pointer) error {
+	return ioctl(d.f.Fd(), req, arg)
+}
+
+func (d *DRM) init() error {
+	var res drmModeCardRes
+	if err := d.ioctl(drmModeGetResources, unsafe.Pointer(&res)); err != nil {
+		return err
+	}
+	crtcs := make([]uint32, res.CountCrtcs+1)
+	connectors := make([]uint32, res.CountConnectors+1)
+	res = drmModeCardRes{
+		CrtcIDPtr:       sliceAddr(unsafe.Pointer(&crtcs[0])),
+		ConnectorIDPtr:  sliceAddr(unsafe.Pointer(&connectors[0])),
+		CountCrtcs:      uint32(len(crtcs) - 1),
+		CountConnectors: uint32(len(connectors) - 1),
+	}
+	err := d.ioctl(drmModeGetResources, unsafe.Pointer(&res))
+	runtime.KeepAlive(crtcs)
+	runtime.KeepAlive(connectors)
+	if err != nil {
+		return err
+	}
+	if int(res.CountCrtcs) >= len(crtcs) || int(res.CountConnectors) >= len(connectors) {
+		return syscall.EAGAIN
+	}
+	crtcs, connectors = crtcs[:res.CountCrtcs], connectors[:res.CountConnectors]
+
+	var mode drmModeModeInfo
+	found := false
+	for _, id := range connectors {
+		conn, modes, encoders, err := d.getConnector(id)
+		if err != nil || conn.Connection != drmModeConnected || len(modes) == 0 {
+			continue
+		}
+		mode = modes[0]
+		for _, m := range modes {
+			if m.Type&drmModeTypePreferred != 0 {
+				mode = m
+				break
+			}
+		}
+		if crtc, ok := d.findCrtc(&conn, encoders, crtcs); ok {
+			d.connector, d.crtc = id, crtc
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("No connected display: %w", ErrUnsupported)
+	}
+	d.w, d.h = int(mode.HDisplay), int(mode.VDisplay)
+
+	d.saved.CrtcID = d.crtc
+	if err := d.ioctl(drmModeGetCrtc, unsafe.Pointer(&d.saved)); err != nil {
+		return err
+	}
+	for i := range d.bufs {
+		if err := d.createBuffer(&d.bufs[i]); err != nil {
+			return err
+		}
+	}
+	return d.setCrtc(d.bufs[0].fb, &mode)
+}
+
+// getConnector returns the connector with its modes and encoders
+func (d *DRM) getConnector(id uint32) (drmModeGetConnectorArg, []drmModeModeInfo, []uint32, error) {
+	conn := drmModeGetConnectorArg{ConnectorID: id}
+	if err := d.ioctl(drmModeGetConnector, unsafe.Pointer(&conn)); err != nil {
+		return conn, nil, nil, err
+	}
+	modes := make([]drmModeModeInfo, conn.CountModes+1)
+	encoders := make([]uint32, conn.CountEncoders+1)
+	conn = drmModeGetConnectorArg{
+		ConnectorID:   id,
+		ModesPtr:      sliceAddr(unsafe.Pointer(&modes[0])),
+		EncodersPtr:   sliceAddr(unsafe.Pointer(&encoders[0])),
+		CountModes:    uint32(len(modes) - 1),
+		CountEncoders: uint32(len(encoders) - 1),
+	}
+	err := d.ioctl(drmModeGetConnector, unsafe.Pointer(&conn))
+	runtime.KeepAlive(modes)
+	runtime.KeepAlive(encoders)
+	if err != nil {
+		return conn, nil, nil, err
+	}
+	// the counts can grow between the calls if a display was plugged
+	// in, then nothing was copied
+	if int(conn.CountModes) >= len(modes) || int(conn.CountEncoders) >= len(encoders) {
+		return conn, nil, nil, syscall.EAGAIN
+	}
+	return conn, modes[:conn.CountModes], encoders[:conn.CountEncoders], nil
+}
+
+// findCrtc returns the CRTC of the current encoder of the connector, or
+// the first CRTC that one of its encoders can drive
+func (d *DRM) findCrtc(conn *drmModeGetConnectorArg, encoders, crtcs []uint32) (uint32, bool) {
+	if conn.EncoderID != 0 {
+		enc := drmModeGetEncoderArg{EncoderID: conn.EncoderID}
+		if d.ioctl(drmModeGetEncoder, unsafe.Pointer(&enc)) == nil && enc.CrtcID != 0 {
+			return enc.CrtcID, true
+		}
+	}
+	for _, id := range encoders {
+		enc := drmModeGetEncoderArg{EncoderID: id}
+		if d.ioctl(drmModeGetEncoder, unsafe.Pointer(&enc)) != nil {
+			continue
+		}
+		for i, crtc := range crtcs {
+			if i < 32 && enc.PossibleCrtcs&(1<<uint(i)) != 0 {
+				return crtc, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func (d *DRM) createBuffer(buf *dumbBuffer) error {
+	create := drmModeCreateDumbArg{Width: uint32(d.w), Height: uint32(d.h), BPP: 32}
+	if err := d.ioctl(drmModeCreateDumb, unsafe.Pointer(&create)); err != nil {
+		return err
+	}
+	buf.handle, buf.pitch = create.Handle, int(create.Pitch)
+	cmd := drmModeFBCmd{Width: uint32(d.w), Height: uint32(d.h), Pitch: create.Pitch, BPP: 32, Depth: 24, Handle: create.Handle}
+	if err := d.ioctl(drmModeAddFB, unsafe.Pointer(&cmd)); err != nil {
+		return err
+	}
+	buf.fb = cmd.FBID
+	mapArg := drmModeMapDumbArg{Handle: create.Handle}
+	if err := d.ioctl(drmModeMapDumb, unsafe.Pointer(&mapArg)); err != nil {
+		return err
+	}
+	mem, err := syscall.Mmap(int(d.f.Fd()), int64(mapArg.Offset), int(create.Size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	buf.mem = mem
+	for i := range mem {
+		mem[i] = 0
+	}
+	return nil
+}
+
+func (d *DRM) setCrtc(fb uint32, mode *drmModeModeInfo) error {
+	conn := d.connector
+	crtc := drmModeCrtc{
+		SetConnectorsPtr: sliceAddr(unsafe.Pointer(&conn)),
+		CountConnectors:  1,
+		CrtcID:           d.crtc,
+		FBID:             fb,
+		ModeValid:        1,
+		Mode:             *mode,
+	}
+	err := d.ioctl(drmModeSetCrtc, unsafe.Pointer(&crtc))
+	runtime.KeepAlive(&conn)
+	return err
+}
+
+// Size returns the resolution of the display mode
+func (d *DRM) Size() (int, int) { return d.w, d.h }
+
+// Present copies the image into the back buffer and flips it to the
+// front on the next vertical blank. It returns once the flip is done
+func (d *DRM) Present(img *image.RGBA) error {
+	if d.f == nil {
+		return os.ErrClosed
+	}
+	back := 1 - d.front
+	buf := &d.bufs[back]
+	xrgb8888.convert(buf.mem, buf.pitch, d.w, d.h, img)
+
+	flip := drmModeCrtcPageFlip{CrtcID: d.crtc, FBID: buf.fb, Flags: drmModePageFlipEvent}
+	if err := d.ioctl(drmModePageFlip, unsafe.Pointer(&flip)); err != nil {
+		return fmt.Errorf("Page flip: %w", err)
+	}
+	if err := d.waitFlip(); err != nil {
+		return err
+	}
+	d.front = back
+	return nil
+}
+
+// waitFlip reads events from the device until the page flip completed
+func (d *DRM) waitFlip() error {
+	var buf [1024]byte
+	for {
+		n, err := syscall.Read(int(d.f.Fd()), buf[:])
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Waiting for the page flip: %w", err)
+		}
+		for i := 0; i+drmEventHeaderSize <= n; {
+			typ := binary.LittleEndian.Uint32(buf[i:])
+			length := int(binary.LittleEndian.Uint32(buf[i+4:]))
+			if typ == drmEventFlipComplete && length >= drmEventVBlankMinSize {
+				return nil
+			}
+			if length < drmEventHeaderSize {
+				break
+			}
+			i += length
+		}
+	}
+}
+
+// Close restores the previous mode of the display and frees the buffers
+func (d *DRM) Close() error {
+	if d.f == nil {
+		return os.ErrClosed
+	}
+	var err error
+	if d.saved.ModeValid != 0 {
+		conn := d.connector
+		d.saved.SetConnectorsPtr = sliceAddr(unsafe.Pointer(&conn))
+		d.saved.CountConnectors = 1
+		err = d.ioctl(drmModeSetCrtc, unsafe.Pointer(&d.saved))
+		runtime.KeepAlive(&conn)
+	}
+	if rerr := d.release(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// release frees the buffers and closes the device
+func (d *DRM) release() error {
+	for i := range d.bufs {
+		buf := &d.bufs[i]
+		if buf.mem != nil {
+			syscall.Munmap(buf.mem)
+			buf.mem = nil
+		}
+		if buf.fb != 0 {
+			d.ioctl(drmModeRmFB, unsafe.Pointer(&buf.fb))
+			buf.fb = 0
+		}
+		if buf.handle != 0 {
+			d.ioctl(drmModeDestroyDumb, unsafe.Pointer(&buf.handle))
+			buf.handle = 0
+		}
+	}
+	err := d.f.Close()
+	d.f = nil
+	return err
+}
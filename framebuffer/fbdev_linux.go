@@ -0,0 +1,164 @@
+//go:build linux
+// +build linux
+
+package framebuffer
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	fbioGetVScreenInfo = 0x4600
+	fbioGetFScreenInfo = 0x4602
+	fbioWaitForVSync   = 0x40044620
+)
+
+type fbBitfield struct {
+	Offset, Length, MSBRight uint32
+}
+
+// fbVarScreenInfo is struct fb_var_screeninfo
+type fbVarScreenInfo struct {
+	XRes, YRes                 uint32
+	XResVirtual, YResVirtual   uint32
+	XOffset, YOffset           uint32
+	BitsPerPixel, Grayscale    uint32
+	Red, Green, Blue, Transp   fbBitfield
+	NonStd, Activate           uint32
+	Height, Width              uint32
+	AccelFlags, PixClock       uint32
+	LeftMargin, RightMargin    uint32
+	UpperMargin, LowerMargin   uint32
+	HSyncLen, VSyncLen         uint32
+	Sync, VMode, Rotate, Color uint32
+	Reserved                   [4]uint32
+}
+
+// fbFixScreenInfo is struct fb_fix_screeninfo
+type fbFixScreenInfo struct {
+	ID                             [16]byte
+	SmemStart                      uintptr
+	SmemLen, Type, TypeAux, Visual uint32
+	XPanStep, YPanStep, YWrapStep  uint16
+	LineLength                     uint32
+	MMIOStart                      uintptr
+	MMIOLen, Accel                 uint32
+	Capabilities                   uint16
+	Reserved                       [2]uint16
+}
+
+// FB is a framebuffer device such as /dev/fb0
+type FB struct {
+	f      *os.File
+	mem    []byte
+	w, h   int
+	stride int
+	// start is the offset of the visible part in the memory
+	start  int
+	format format
+	// vsync is cleared if the driver doesn't support waiting for vsync
+	vsync bool
+	saved []byte
+}
+
+func ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// OpenFB opens a framebuffer device such as /dev/fb0. The content of the
+// framebuffer is restored by Close
+func OpenFB(path string) (*FB, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	var vinfo fbVarScreenInfo
+	var finfo fbFixScreenInfo
+	if err := ioctl(f.Fd(), fbioGetVScreenInfo, unsafe.Pointer(&vinfo)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Reading the screen info of %s: %w", path, err)
+	}
+	if err := ioctl(f.Fd(), fbioGetFScreenInfo, unsafe.Pointer(&finfo)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Reading the screen info of %s: %w", path, err)
+	}
+	fb := &FB{
+		f:      f,
+		w:      int(vinfo.XRes),
+		h:      int(vinfo.YRes),
+		stride: int(finfo.LineLength),
+		vsync:  true,
+		format: format{
+			bpp:   int(vinfo.BitsPerPixel),
+			red:   field{uint(vinfo.Red.Offset), uint(vinfo.Red.Length)},
+			green: field{uint(vinfo.Green.Offset), uint(vinfo.Green.Length)},
+			blue:  field{uint(vinfo.Blue.Offset), uint(vinfo.Blue.Length)},
+			alpha: field{uint(vinfo.Transp.Offset), uint(vinfo.Transp.Length)},
+		},
+	}
+	if !fb.format.supported() || vinfo.Grayscale != 0 {
+		f.Close()
+		return nil, fmt.Errorf("%s has %d bits per pixel: %w", path, vinfo.BitsPerPixel, ErrUnsupported)
+	}
+	// the visible part starts at the offset within the virtual resolution
+	start := int(vinfo.YOffset)*fb.stride + int(vinfo.XOffset)*fb.format.bpp/8
+	size := start + fb.stride*fb.h
+	if size > int(finfo.SmemLen) {
+		f.Close()
+		return nil, fmt.Errorf("%s reports %d bytes of memory for %dx%d pixels: %w", path, finfo.SmemLen, fb.w, fb.h, ErrUnsupported)
+	}
+	mem, err := syscall.Mmap(int(f.Fd()), 0, int(finfo.SmemLen), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Mapping %s: %w", path, err)
+	}
+	fb.mem, fb.start = mem, start
+	fb.saved = append([]byte(nil), fb.visible()...)
+	return fb, nil
+}
+
+// Size returns the visible resolution
+func (fb *FB) Size() (int, int) { return fb.w, fb.h }
+
+// visible returns the memory of the visible part of the framebuffer
+func (fb *FB) visible() []byte {
+	return fb.mem[fb.start : fb.start+fb.stride*fb.h]
+}
+
+// Present waits for the vertical blank and copies the image into the
+// framebuffer. Drivers without FBIO_WAITFORVSYNC present immediately
+func (fb *FB) Present(img *image.RGBA) error {
+	if fb.mem == nil {
+		return os.ErrClosed
+	}
+	if fb.vsync {
+		var crtc uint32
+		if err := ioctl(fb.f.Fd(), fbioWaitForVSync, unsafe.Pointer(&crtc)); err != nil {
+			fb.vsync = false
+		}
+	}
+	fb.format.convert(fb.visible(), fb.stride, fb.w, fb.h, img)
+	return nil
+}
+
+// Close restores the content that the framebuffer had when it was opened
+func (fb *FB) Close() error {
+	if fb.mem == nil {
+		return os.ErrClosed
+	}
+	copy(fb.visible(), fb.saved)
+	err := syscall.Munmap(fb.mem)
+	fb.mem = nil
+	if cerr := fb.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,142 @@
+// Package framebuffer presents the pixels of a software backend on the
+// Linux framebuffer device (/dev/fb0) or on a DRM/KMS dumb buffer
+// (/dev/dri/card0), so that kiosk and embedded devices can run canvas
+// applications without X, Wayland or a GPU stack. Frames are presented
+// with vsync where the device supports it.
+//
+// The canvas pixels have straight alpha. Most framebuffers have no alpha
+// channel, and the alpha is dropped, so partially transparent pixels show
+// their full color. Applications should fill the canvas with an opaque
+// background first
+package framebuffer
+
+import (
+	"errors"
+	"image"
+	"strings"
+
+	"github.com/opentoys/canvas"
+)
+
+// ErrUnsupported is returned on systems without framebuffer support and
+// for devices with a pixel format that can't be written
+var ErrUnsupported = errors.New("Framebuffer output is not supported")
+
+// Output is a display that frames are presented on
+type Output interface {
+	// Size returns the size of the display in pixels
+	Size() (w, h int)
+	// Present shows the image, waiting for the vertical blank if the
+	// device supports it. Pixels outside of the display are ignored
+	Present(img *image.RGBA) error
+	// Close restores the previous content of the display if possible
+	// and releases the device
+	Close() error
+}
+
+// Open opens a DRM device for paths below /dev/dri and a framebuffer
+// device otherwise
+func Open(path string) (Output, error) {
+	if strings.HasPrefix(path, "/dev/dri/") {
+		d, err := OpenDRM(path)
+		if err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+	fb, err := OpenFB(path)
+	if err != nil {
+		return nil, err
+	}
+	return fb, nil
+}
+
+// NewBackend creates a software backend with the size of the output
+func NewBackend(out Output) *canvas.SoftwareBackend {
+	w, h := out.Size()
+	return canvas.NewBackend(w, h)
+}
+
+// field is the position of a color channel in a pixel
+type field struct {
+	offset, length uint
+}
+
+func (f field) put(v uint8) uint32 {
+	if f.length == 0 {
+		return 0
+	}
+	if f.length < 8 {
+		return uint32(v>>(8-f.length)) << f.offset
+	}
+	return uint32(v) << (f.offset + f.length - 8)
+}
+
+// format is a little-endian packed pixel format
+type format struct {
+	bpp                     int
+	red, green, blue, alpha field
+}
+
+// xrgb8888 is the format of the dumb buffers
+var xrgb8888 = format{bpp: 32, red: field{16, 8}, green: field{8, 8}, blue: field{0, 8}}
+
+func (f format) supported() bool {
+	return f.bpp == 16 || f.bpp == 24 || f.bpp == 32
+}
+
+// bytes returns the byte positions of the channels if the format has 32
+// bits with 8 bits per byte aligned channel, for the fast path
+func (f format) bytes() (r, g, b, a int, ok bool) {
+	if f.bpp != 32 {
+		return 0, 0, 0, 0, false
+	}
+	pos := func(fd field) int {
+		if fd.length != 8 || fd.offset%8 != 0 {
+			return -2
+		}
+		return int(fd.offset / 8)
+	}
+	r, g, b = pos(f.red), pos(f.green), pos(f.blue)
+	a = -1
+	if f.alpha.length != 0 {
+		a = pos(f.alpha)
+	}
+	ok = r >= 0 && g >= 0 && b >= 0 && a >= -1
+	return
+}
+
+// convert writes the part of src that fits into the w*h pixels of dst
+func (f format) convert(dst []byte, stride, w, h int, src *image.RGBA) {
+	sr := src.Rect
+	if sr.Dx() < w {
+		w = sr.Dx()
+	}
+	if sr.Dy() < h {
+		h = sr.Dy()
+	}
+	bpp := f.bpp / 8
+	rb, gb, bb, ab, fast := f.bytes()
+	for y := 0; y < h; y++ {
+		s := src.Pix[src.PixOffset(sr.Min.X, sr.Min.Y+y):]
+		d := dst[y*stride:]
+		if fast {
+			for x := 0; x < w; x++ {
+				p := d[x*4 : x*4+4 : x*4+4]
+				p[0], p[1], p[2], p[3] = 0, 0, 0, 0
+				p[rb], p[gb], p[bb] = s[x*4], s[x*4+1], s[x*4+2]
+				if ab >= 0 {
+					p[ab] = s[x*4+3]
+				}
+			}
+			continue
+		}
+		for x := 0; x < w; x++ {
+			v := f.red.put(s[x*4]) | f.green.put(s[x*4+1]) | f.blue.put(s[x*4+2]) | f.alpha.put(s[x*4+3])
+			p := d[x*bpp : x*bpp+bpp]
+			for i := range p {
+				p[i] = uint8(v >> (8 * uint(i)))
+			}
+		}
+	}
+}
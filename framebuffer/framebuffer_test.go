@@ -0,0 +1,56 @@
+package framebuffer
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	src.SetRGBA(0, 0, color.RGBA{0xFF, 0x80, 0x10, 0xFF})
+	src.SetRGBA(2, 1, color.RGBA{0x08, 0x04, 0xF8, 0x80})
+
+	// XRGB8888 with a row stride of 16 bytes and a display of 2x2 pixels
+	dst := bytes.Repeat([]byte{0xAA}, 32)
+	xrgb8888.convert(dst, 16, 2, 2, src)
+	if !bytes.Equal(dst[:8], []byte{0x10, 0x80, 0xFF, 0, 0, 0, 0, 0}) {
+		t.Fatalf("Unexpected XRGB8888 pixels %v", dst[:8])
+	}
+	if dst[8] != 0xAA || dst[31] != 0xAA {
+		t.Fatal("Expected the padding and the pixels outside of the display to stay unchanged")
+	}
+
+	// RGB565
+	rgb565 := format{bpp: 16, red: field{11, 5}, green: field{5, 6}, blue: field{0, 5}}
+	dst = make([]byte, 12)
+	rgb565.convert(dst, 6, 3, 2, src)
+	if v := uint16(dst[0]) | uint16(dst[1])<<8; v != 0xFC02 {
+		t.Fatalf("Expected the RGB565 pixel 0xFC02, got %#x", v)
+	}
+	if v := uint16(dst[10]) | uint16(dst[11])<<8; v != 0x083F {
+		t.Fatalf("Expected the RGB565 pixel 0x083F, got %#x", v)
+	}
+
+	// ABGR with alpha, 32 bits
+	abgr := format{bpp: 32, red: field{0, 8}, green: field{8, 8}, blue: field{16, 8}, alpha: field{24, 8}}
+	dst = make([]byte, 24)
+	abgr.convert(dst, 12, 3, 2, src)
+	if !bytes.Equal(dst[20:24], []byte{0x08, 0x04, 0xF8, 0x80}) {
+		t.Fatalf("Unexpected ABGR pixel %v", dst[20:24])
+	}
+
+	if (format{bpp: 8}).supported() {
+		t.Fatal("Expected 8 bits per pixel to be unsupported")
+	}
+}
+
+func TestOpenMissing(t *testing.T) {
+	for _, path := range []string{"/nonexistent/fb0", "/dev/dri/nonexistent"} {
+		out, err := Open(path)
+		if err == nil || out != nil {
+			t.Fatalf("Expected an error for %s, got %v", path, out)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+// +build !linux
+
+package framebuffer
+
+import "image"
+
+// FB is a framebuffer device, only supported on Linux
+type FB struct{}
+
+// OpenFB returns ErrUnsupported on systems other than Linux
+func OpenFB(path string) (*FB, error) { return nil, ErrUnsupported }
+
+func (fb *FB) Size() (int, int)              { return 0, 0 }
+func (fb *FB) Present(img *image.RGBA) error { return ErrUnsupported }
+func (fb *FB) Close() error                  { return ErrUnsupported }
+
+// DRM is a DRM/KMS display, only supported on Linux
+type DRM struct{}
+
+// OpenDRM returns ErrUnsupported on systems other than Linux
+func OpenDRM(path string) (*DRM, error) { return nil, ErrUnsupported }
+
+func (d *DRM) Size() (int, int)              { return 0, 0 }
+func (d *DRM) Present(img *image.RGBA) error { return ErrUnsupported }
+func (d *DRM) Close() error                  { return ErrUnsupported }
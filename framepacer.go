@@ -0,0 +1,201 @@
+package canvas
+
+import (
+	"time"
+)
+
+// FramePacer runs a render loop at a fixed frame rate, like a vsync
+// ticker, and keeps statistics of how long the frames take. If
+// AdaptQuality is set, it lowers the quality preset of the canvas when
+// frames take longer than the frame interval and raises it again up to
+// the quality the application set once frames are fast enough, so that
+// software rendered applications stay responsive on weak hardware
+type FramePacer struct {
+	// AdaptQuality enables changing the quality preset of the canvas.
+	// NewFramePacer sets it
+	AdaptQuality bool
+
+	interval time.Duration
+	next     time.Time
+
+	times   [frameWindow]time.Duration
+	stats   FrameStats
+	slow    int
+	fast    int
+	ceiling Quality
+	set     Quality
+	started bool
+}
+
+// FrameStats are the frame time statistics of a FramePacer. The
+// average and maximum are taken over the last frames
+type FrameStats struct {
+	// Frames is the number of frames that were drawn
+	Frames int
+	// Dropped is the number of frame deadlines that were missed
+	// because a frame took too long
+	Dropped int
+	Last    time.Duration
+	Average time.Duration
+	Max     time.Duration
+}
+
+const (
+	// frameWindow is the number of frames the statistics are taken over
+	frameWindow = 60
+	// slowFrames is the number of consecutive frames over budget after
+	// which the quality is lowered
+	slowFrames = 3
+	// fastFrames is the number of consecutive frames that take less
+	// than half of the budget after which the quality is raised
+	fastFrames = 60
+)
+
+// NewFramePacer creates a frame pacer that runs at targetFPS frames per
+// second
+func NewFramePacer(targetFPS float64) *FramePacer {
+	if targetFPS <= 0 {
+		targetFPS = 60
+	}
+	return &FramePacer{
+		AdaptQuality: true,
+		interval:     time.Duration(float64(time.Second) / targetFPS),
+	}
+}
+
+// Interval returns the time budget of a frame
+func (fp *FramePacer) Interval() time.Duration {
+	return fp.interval
+}
+
+// Wait sleeps until the next frame is due. If the deadline was missed by
+// more than a frame, the missed frames are counted as dropped and the
+// pacer continues from now instead of trying to catch up
+func (fp *FramePacer) Wait() {
+	now := time.Now()
+	if fp.next.IsZero() {
+		fp.next = now
+	}
+	if d := fp.next.Sub(now); d > 0 {
+		time.Sleep(d)
+	} else if -d >= fp.interval {
+		fp.stats.Dropped += int(-d / fp.interval)
+		fp.next = now
+	}
+	fp.next = fp.next.Add(fp.interval)
+}
+
+// Frame waits for the next frame, calls draw and flushes the canvas. The
+// time taken by draw and the flush is the frame time
+func (fp *FramePacer) Frame(cv *Canvas, draw func(cv *Canvas)) {
+	fp.Wait()
+	start := time.Now()
+	draw(cv)
+	cv.Flush()
+	fp.endFrame(cv, time.Since(start))
+}
+
+// FrameConcurrent waits for the next frame and flushes the functions
+// that were submitted to the concurrent canvas since the last frame
+func (fp *FramePacer) FrameConcurrent(cc *ConcurrentCanvas) {
+	fp.Wait()
+	start := time.Now()
+	cc.Flush()
+	cc.Do(func(cv *Canvas) {
+		cv.Flush()
+		fp.endFrame(cv, time.Since(start))
+	})
+}
+
+// Stats returns the frame time statistics
+func (fp *FramePacer) Stats() FrameStats {
+	return fp.stats
+}
+
+// ResetStats clears the frame time statistics
+func (fp *FramePacer) ResetStats() {
+	fp.stats = FrameStats{}
+	fp.times = [frameWindow]time.Duration{}
+}
+
+func (fp *FramePacer) endFrame(cv *Canvas, d time.Duration) {
+	cv.EndFrame()
+	fp.times[fp.stats.Frames%frameWindow] = d
+	fp.stats.Frames++
+	fp.stats.Last = d
+
+	n := fp.stats.Frames
+	if n > frameWindow {
+		n = frameWindow
+	}
+	var sum, max time.Duration
+	for _, t := range fp.times[:n] {
+		sum += t
+		if t > max {
+			max = t
+		}
+	}
+	fp.stats.Average = sum / time.Duration(n)
+	fp.stats.Max = max
+
+	if fp.AdaptQuality {
+		fp.adapt(cv, d)
+	}
+}
+
+// adapt moves the quality of the canvas one step down after a few slow
+// frames and one step up after many fast ones. Quality changes made by
+// the application become the new upper limit
+func (fp *FramePacer) adapt(cv *Canvas, d time.Duration) {
+	if q := cv.Quality(); !fp.started || q != fp.set {
+		fp.ceiling, fp.set, fp.started = q, q, true
+		fp.slow, fp.fast = 0, 0
+	}
+
+	switch {
+	case d > fp.interval:
+		fp.slow++
+		fp.fast = 0
+	case d < fp.interval/2:
+		fp.fast++
+		fp.slow = 0
+	default:
+		fp.slow, fp.fast = 0, 0
+	}
+
+	q := fp.set
+	if fp.slow >= slowFrames {
+		q = lowerQuality(q)
+	} else if fp.fast >= fastFrames && q != fp.ceiling {
+		q = raiseQuality(q, fp.ceiling)
+	} else {
+		return
+	}
+	fp.slow, fp.fast = 0, 0
+	if q != fp.set {
+		cv.SetQuality(q)
+		fp.set = q
+	}
+}
+
+func lowerQuality(q Quality) Quality {
+	switch q {
+	case QualityHigh:
+		return QualityMedium
+	case QualityMedium, QualityDefault:
+		return QualityLow
+	}
+	return q
+}
+
+func raiseQuality(q, ceiling Quality) Quality {
+	switch {
+	case q == QualityLow && ceiling == QualityDefault:
+		return QualityDefault
+	case q == QualityLow:
+		return QualityMedium
+	case q == QualityMedium && ceiling == QualityHigh:
+		return QualityHigh
+	}
+	return q
+}
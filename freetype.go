@@ -1,3 +1,6 @@
+//go:build !canvas_notext
+// +build !canvas_notext
+
 package canvas
 
 // Copyright 2010 The Freetype-Go Authors. All rights reserved.
@@ -0,0 +1,263 @@
+// Package geometry contains the intersection tests and predicates that
+// the canvas uses to fill and stroke paths, so that applications such as
+// diagram editors can make decisions that agree with what is drawn.
+//
+// Points are [2]float64 arrays like canvas.BackendVec, so the flattened
+// paths returned by Path2D.Polylines can be converted without copying
+// each point
+package geometry
+
+import (
+	"math"
+)
+
+// Point is a point or vector in 2D
+type Point [2]float64
+
+func (p Point) sub(p2 Point) Point { return Point{p[0] - p2[0], p[1] - p2[1]} }
+
+// Orient returns 1 if c is to the left of the line from a to b (counter
+// clockwise in a y-up coordinate system), -1 if it is to the right and 0
+// if the three points are collinear. The result is exact, close cases
+// are decided with exact arithmetic, as long as the products of the
+// coordinates neither overflow nor underflow
+func Orient(a, b, c Point) int {
+	l := (b[0] - a[0]) * (c[1] - a[1])
+	r := (b[1] - a[1]) * (c[0] - a[0])
+	det := l - r
+	// error bound of the floating point calculation, see Shewchuk,
+	// Adaptive Precision Floating-Point Arithmetic and Fast Robust
+	// Geometric Predicates
+	const eps = 1.0 / (1 << 53)
+	bound := (3 + 16*eps) * eps * (math.Abs(l) + math.Abs(r))
+	if det > bound {
+		return 1
+	} else if det < -bound {
+		return -1
+	}
+	return orientExact(a, b, c)
+}
+
+// orientExact calculates the determinant of Orient as the sum of its six
+// products, each split into the rounded product and its rounding error,
+// so that the sum is exact. The terms of the sum are kept as an
+// expansion of non-overlapping floating point numbers ordered by
+// magnitude, as described by Shewchuk, so its sign is the sign of the
+// largest one
+func orientExact(a, b, c Point) int {
+	products := [6][2]float64{
+		{b[0], c[1]}, {-b[0], a[1]}, {-a[0], c[1]},
+		{-b[1], c[0]}, {b[1], a[0]}, {a[1], c[0]},
+	}
+	var buf [12]float64
+	e := buf[:0]
+	for _, p := range products {
+		x, y := twoProduct(p[0], p[1])
+		e = growExpansion(e, y)
+		e = growExpansion(e, x)
+	}
+	for i := len(e) - 1; i >= 0; i-- {
+		if e[i] > 0 {
+			return 1
+		} else if e[i] < 0 {
+			return -1
+		}
+	}
+	return 0
+}
+
+// twoSum returns the rounded sum and its rounding error
+func twoSum(a, b float64) (x, y float64) {
+	x = a + b
+	bv := x - a
+	av := x - bv
+	return x, (a - av) + (b - bv)
+}
+
+// split splits a number into two halves of 26 bits, so that products of
+// the halves are exact
+func split(a float64) (hi, lo float64) {
+	const splitter = 1<<27 + 1
+	c := float64(splitter * a)
+	hi = c - (c - a)
+	return hi, a - hi
+}
+
+// twoProduct returns the rounded product and its rounding error. The
+// conversions keep the compiler from fusing the multiplications and
+// subtractions, which would round differently
+func twoProduct(a, b float64) (x, y float64) {
+	x = a * b
+	ahi, alo := split(a)
+	bhi, blo := split(b)
+	err := x - float64(ahi*bhi) - float64(alo*bhi) - float64(ahi*blo)
+	return x, float64(alo*blo) - err
+}
+
+// growExpansion adds b to the expansion, dropping zero terms. The
+// expansion is updated in place and may grow by one term
+func growExpansion(e []float64, b float64) []float64 {
+	q := b
+	n := 0
+	for _, v := range e {
+		var h float64
+		q, h = twoSum(q, v)
+		if h != 0 {
+			e[n] = h
+			n++
+		}
+	}
+	return append(e[:n], q)
+}
+
+// LineIntersection returns the intersection of the infinite lines through
+// a0/a1 and b0/b1, together with the positions ta and tb of the point on
+// the two lines, where 0 is at a0/b0 and 1 at a1/b1. If the lines are
+// parallel or one of them has no length, ta and tb are +Inf. This is
+// the calculation the canvas uses for line joins and self intersections
+func LineIntersection(a0, a1, b0, b1 Point) (p Point, ta, tb float64) {
+	va := a1.sub(a0)
+	vb := b1.sub(b0)
+
+	if (va[0] == 0 && vb[0] == 0) || (va[1] == 0 && vb[1] == 0) || (va[0] == 0 && va[1] == 0) || (vb[0] == 0 && vb[1] == 0) {
+		return Point{}, math.Inf(1), math.Inf(1)
+	}
+	d := va[1]*vb[0] - va[0]*vb[1]
+	if d == 0 {
+		return Point{}, math.Inf(1), math.Inf(1)
+	}
+	ta = (vb[1]*(a0[0]-b0[0]) - a0[1]*vb[0] + b0[1]*vb[0]) / d
+	if vb[0] == 0 {
+		tb = (a0[1] + ta*va[1] - b0[1]) / vb[1]
+	} else {
+		tb = (a0[0] + ta*va[0] - b0[0]) / vb[0]
+	}
+
+	return Point{a0[0] + va[0]*ta, a0[1] + va[1]*ta}, ta, tb
+}
+
+// SegmentsIntersect returns true if the segments a0/a1 and b0/b1 cross or
+// touch, including collinear segments that overlap
+func SegmentsIntersect(a0, a1, b0, b1 Point) bool {
+	o1 := Orient(a0, a1, b0)
+	o2 := Orient(a0, a1, b1)
+	o3 := Orient(b0, b1, a0)
+	o4 := Orient(b0, b1, a1)
+
+	if o1 != o2 && o3 != o4 {
+		return true
+	}
+	return (o1 == 0 && onSegment(a0, b0, a1)) ||
+		(o2 == 0 && onSegment(a0, b1, a1)) ||
+		(o3 == 0 && onSegment(b0, a0, b1)) ||
+		(o4 == 0 && onSegment(b0, a1, b1))
+}
+
+// onSegment returns true if q is on the segment p/r, for collinear points
+func onSegment(p, q, r Point) bool {
+	return q[0] <= math.Max(p[0], r[0]) && q[0] >= math.Min(p[0], r[0]) &&
+		q[1] <= math.Max(p[1], r[1]) && q[1] >= math.Min(p[1], r[1])
+}
+
+// SegmentIntersection returns the point where the segments a0/a1 and
+// b0/b1 cross or touch. Collinear segments have no single intersection
+// point and return false
+func SegmentIntersection(a0, a1, b0, b1 Point) (Point, bool) {
+	if !SegmentsIntersect(a0, a1, b0, b1) {
+		return Point{}, false
+	}
+	p, ta, _ := LineIntersection(a0, a1, b0, b1)
+	if math.IsInf(ta, 1) {
+		return Point{}, false
+	}
+	return p, true
+}
+
+// SegmentArcIntersections returns the points where the segment a0/a1
+// crosses or touches the arc around center, with the angles and
+// direction of the Arc function of the canvas
+func SegmentArcIntersections(a0, a1, center Point, radius, startAngle, endAngle float64, anticlockwise bool) []Point {
+	d := a1.sub(a0)
+	f := a0.sub(center)
+	a := d[0]*d[0] + d[1]*d[1]
+	if a == 0 || radius <= 0 {
+		return nil
+	}
+	b := 2 * (f[0]*d[0] + f[1]*d[1])
+	c := f[0]*f[0] + f[1]*f[1] - radius*radius
+	disc := b*b - 4*a*c
+	if disc < 0 {
+		return nil
+	}
+	sq := math.Sqrt(disc)
+	ts := [2]float64{(-b - sq) / (2 * a), (-b + sq) / (2 * a)}
+	n := 2
+	if disc == 0 {
+		n = 1
+	}
+
+	var points []Point
+	for _, t := range ts[:n] {
+		if t < 0 || t > 1 {
+			continue
+		}
+		p := Point{a0[0] + d[0]*t, a0[1] + d[1]*t}
+		angle := math.Atan2(p[1]-center[1], p[0]-center[0])
+		if angleOnArc(angle, startAngle, endAngle, anticlockwise) {
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+// angleOnArc returns true if the angle is within the arc, which follows
+// the rules of the canvas Arc function for angles beyond a full circle
+func angleOnArc(angle, startAngle, endAngle float64, anticlockwise bool) bool {
+	if anticlockwise {
+		startAngle, endAngle = endAngle, startAngle
+	}
+	sweep := endAngle - startAngle
+	if sweep >= math.Pi*2 || sweep <= -math.Pi*2 {
+		return true
+	}
+	sweep = math.Mod(sweep, math.Pi*2)
+	if sweep < 0 {
+		sweep += math.Pi * 2
+	}
+	rel := math.Mod(angle-startAngle, math.Pi*2)
+	if rel < 0 {
+		rel += math.Pi * 2
+	}
+	return rel <= sweep
+}
+
+// Intersection is a point where two polylines cross or touch
+type Intersection struct {
+	Point Point
+	// SegmentA and SegmentB are the indices of the segments of the two
+	// polylines, where segment i goes from point i to point i+1
+	SegmentA, SegmentB int
+}
+
+// PolylineIntersections returns the points where the polylines a and b
+// cross or touch. Closed shapes must repeat their first point at the end
+func PolylineIntersections(a, b []Point) []Intersection {
+	if len(a) < 2 || len(b) < 2 {
+		return nil
+	}
+	var result []Intersection
+	for i := 0; i+1 < len(a); i++ {
+		a0, a1 := a[i], a[i+1]
+		for j := 0; j+1 < len(b); j++ {
+			b0, b1 := b[j], b[j+1]
+			if math.Max(a0[0], a1[0]) < math.Min(b0[0], b1[0]) || math.Min(a0[0], a1[0]) > math.Max(b0[0], b1[0]) ||
+				math.Max(a0[1], a1[1]) < math.Min(b0[1], b1[1]) || math.Min(a0[1], a1[1]) > math.Max(b0[1], b1[1]) {
+				continue
+			}
+			if p, ok := SegmentIntersection(a0, a1, b0, b1); ok {
+				result = append(result, Intersection{Point: p, SegmentA: i, SegmentB: j})
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,148 @@
+package geometry_test
+
+import (
+	"math"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/opentoys/canvas"
+	"github.com/opentoys/canvas/geometry"
+)
+
+func TestOrient(t *testing.T) {
+	a, b := geometry.Point{0, 0}, geometry.Point{10, 10}
+	if o := geometry.Orient(a, b, geometry.Point{0, 10}); o != 1 {
+		t.Fatalf("Expected left, got %d", o)
+	}
+	if o := geometry.Orient(a, b, geometry.Point{10, 0}); o != -1 {
+		t.Fatalf("Expected right, got %d", o)
+	}
+	// points that are only a few ulps away from the line are close
+	// enough for rounding errors to decide the sign
+	a, b = geometry.Point{0.5, 0.5}, geometry.Point{12, 12}
+	c := geometry.Point{24, 24}
+	for i := 0; i < 64; i++ {
+		c[0] = math.Nextafter(c[0], 25)
+		if o := geometry.Orient(a, b, c); o != -1 {
+			t.Fatalf("Expected %v to be right of the line, got %d", c, o)
+		}
+	}
+	if o := geometry.Orient(a, b, geometry.Point{24, 24}); o != 0 {
+		t.Fatalf("Expected collinear, got %d", o)
+	}
+}
+
+func TestOrientExact(t *testing.T) {
+	// points on a line that are moved by a few ulps, compared with the
+	// determinant calculated with rational numbers
+	rat := func(v float64) *big.Rat { return new(big.Rat).SetFloat64(v) }
+	want := func(a, b, c geometry.Point) int {
+		bx, by := new(big.Rat).Sub(rat(b[0]), rat(a[0])), new(big.Rat).Sub(rat(b[1]), rat(a[1]))
+		cx, cy := new(big.Rat).Sub(rat(c[0]), rat(a[0])), new(big.Rat).Sub(rat(c[1]), rat(a[1]))
+		return new(big.Rat).Mul(bx, cy).Cmp(new(big.Rat).Mul(by, cx))
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		scale := math.Pow(10, float64(rnd.Intn(12)-4))
+		a := geometry.Point{rnd.Float64() * scale, rnd.Float64() * scale}
+		d := geometry.Point{rnd.Float64() - 0.5, rnd.Float64() - 0.5}
+		b := geometry.Point{a[0] + d[0]*scale, a[1] + d[1]*scale}
+		f := rnd.Float64() * 3
+		c := geometry.Point{a[0] + d[0]*scale*f, a[1] + d[1]*scale*f}
+		for j := rnd.Intn(4); j > 0; j-- {
+			c[j%2] = math.Nextafter(c[j%2], math.Inf(rnd.Intn(2)*2-1))
+		}
+		if o, w := geometry.Orient(a, b, c), want(a, b, c); o != w {
+			t.Fatalf("Expected %d for %v %v %v, got %d", w, a, b, c, o)
+		}
+	}
+}
+
+func TestSegments(t *testing.T) {
+	cases := []struct {
+		a0, a1, b0, b1 geometry.Point
+		hit            bool
+		point          geometry.Point
+	}{
+		{geometry.Point{0, 0}, geometry.Point{10, 10}, geometry.Point{0, 10}, geometry.Point{10, 0}, true, geometry.Point{5, 5}},
+		{geometry.Point{0, 0}, geometry.Point{10, 0}, geometry.Point{10, 0}, geometry.Point{10, 10}, true, geometry.Point{10, 0}},
+		{geometry.Point{0, 0}, geometry.Point{10, 0}, geometry.Point{11, -5}, geometry.Point{11, 5}, false, geometry.Point{}},
+		{geometry.Point{0, 0}, geometry.Point{10, 0}, geometry.Point{0, 1}, geometry.Point{10, 1}, false, geometry.Point{}},
+	}
+	for i, c := range cases {
+		if hit := geometry.SegmentsIntersect(c.a0, c.a1, c.b0, c.b1); hit != c.hit {
+			t.Fatalf("Case %d: expected %v, got %v", i, c.hit, hit)
+		}
+		p, ok := geometry.SegmentIntersection(c.a0, c.a1, c.b0, c.b1)
+		if ok != c.hit || p != c.point {
+			t.Fatalf("Case %d: expected %v, got %v %v", i, c.point, p, ok)
+		}
+	}
+
+	// collinear overlapping segments intersect, but not in a single point
+	a0, a1 := geometry.Point{0, 0}, geometry.Point{10, 0}
+	b0, b1 := geometry.Point{5, 0}, geometry.Point{15, 0}
+	if !geometry.SegmentsIntersect(a0, a1, b0, b1) {
+		t.Fatal("Expected overlapping segments to intersect")
+	}
+	if _, ok := geometry.SegmentIntersection(a0, a1, b0, b1); ok {
+		t.Fatal("Expected no single intersection point")
+	}
+}
+
+func TestSegmentArc(t *testing.T) {
+	center := geometry.Point{0, 0}
+	a0, a1 := geometry.Point{-20, 0}, geometry.Point{20, 0}
+
+	full := geometry.SegmentArcIntersections(a0, a1, center, 10, 0, math.Pi*2, false)
+	if len(full) != 2 {
+		t.Fatalf("Expected 2 intersections with the circle, got %v", full)
+	}
+
+	// the lower half, since y points down on the canvas
+	lower := geometry.SegmentArcIntersections(a0, a1, center, 10, 0, math.Pi*0.5, false)
+	if len(lower) != 1 || math.Abs(lower[0][0]-10) > 1e-9 {
+		t.Fatalf("Expected one intersection at 10/0, got %v", lower)
+	}
+	other := geometry.SegmentArcIntersections(a0, a1, center, 10, 0, math.Pi*0.5, true)
+	if len(other) != 2 {
+		t.Fatalf("Expected both intersections on the anticlockwise arc, got %v", other)
+	}
+
+	if miss := geometry.SegmentArcIntersections(geometry.Point{-20, 20}, geometry.Point{20, 20}, center, 10, 0, math.Pi*2, false); len(miss) != 0 {
+		t.Fatalf("Expected no intersections, got %v", miss)
+	}
+}
+
+func TestPathIntersections(t *testing.T) {
+	cv := canvas.New(canvas.NewBackend(10, 10))
+	rect := cv.NewPath2D()
+	rect.Rect(0, 0, 20, 20)
+	circle := cv.NewPath2D()
+	circle.Arc(20, 10, 5, 0, math.Pi*2, false)
+
+	rl, cl := rect.Polylines(), circle.Polylines()
+	if len(rl) != 1 || len(cl) != 1 {
+		t.Fatalf("Expected one subpath each, got %d and %d", len(rl), len(cl))
+	}
+	toPoints := func(line []canvas.BackendVec) []geometry.Point {
+		pts := make([]geometry.Point, len(line))
+		for i, v := range line {
+			pts[i] = geometry.Point(v)
+		}
+		return pts
+	}
+	hits := geometry.PolylineIntersections(toPoints(rl[0]), toPoints(cl[0]))
+	if len(hits) != 2 {
+		t.Fatalf("Expected 2 intersections, got %v", hits)
+	}
+	for _, h := range hits {
+		if math.Abs(h.Point[0]-20) > 1e-9 || math.Abs(math.Abs(h.Point[1]-10)-5) > 0.01 {
+			t.Fatalf("Unexpected intersection %v", h)
+		}
+		if h.SegmentA != 1 {
+			t.Fatalf("Expected the right edge of the rectangle, got segment %d", h.SegmentA)
+		}
+	}
+}
@@ -15,8 +15,10 @@ type LinearGradient struct {
 	created  bool
 	loaded   bool
 	opaque   bool
+	dither   gradientDither
 	grad     BackendLinearGradient
 	data     BackendGradient
+	gen      int
 }
 
 // RadialGradient is a gradient with any number of
@@ -31,10 +33,25 @@ type RadialGradient struct {
 	created  bool
 	loaded   bool
 	opaque   bool
+	dither   gradientDither
 	grad     BackendRadialGradient
 	data     BackendGradient
+	gen      int
 }
 
+type gradientDither uint8
+
+// Gradient dithering constants for SetDither
+const (
+	NoDither = gradientDither(BackendNoDither)
+	// DitherOrdered uses a regular pattern, which is less noticeable
+	// in flat areas and compresses better
+	DitherOrdered = gradientDither(BackendDitherOrdered)
+	// DitherNoise uses a random pattern without visible structure. The
+	// software backend takes the pattern from SoftwareBackend.DitherSeed
+	DitherNoise = gradientDither(BackendDitherNoise)
+)
+
 // CreateLinearGradient creates a new linear gradient with
 // the coordinates from where to where the gradient
 // will apply on the canvas
@@ -42,6 +59,7 @@ func (cv *Canvas) CreateLinearGradient(x0, y0, x1, y1 float64) *LinearGradient {
 	lg := &LinearGradient{
 		cv:     cv,
 		opaque: true,
+		gen:    cv.context.gen,
 		from:   BackendVec{x0, y0},
 		to:     BackendVec{x1, y1},
 		data:   make(BackendGradient, 0, 20),
@@ -60,6 +78,7 @@ func (cv *Canvas) CreateRadialGradient(x0, y0, r0, x1, y1, r1 float64) *RadialGr
 	rg := &RadialGradient{
 		cv:      cv,
 		opaque:  true,
+		gen:     cv.context.gen,
 		from:    BackendVec{x0, y0},
 		to:      BackendVec{x1, y1},
 		radFrom: r0,
@@ -73,6 +92,11 @@ func (cv *Canvas) CreateRadialGradient(x0, y0, r0, x1, y1, r1 float64) *RadialGr
 }
 
 func (lg *LinearGradient) load() {
+	if lg.gen != lg.cv.context.gen {
+		// the gradient was lost with the context
+		lg.created, lg.loaded = false, false
+		lg.gen = lg.cv.context.gen
+	}
 	if lg.loaded || len(lg.data) < 1 {
 		return
 	}
@@ -87,6 +111,11 @@ func (lg *LinearGradient) load() {
 }
 
 func (rg *RadialGradient) load() {
+	if rg.gen != rg.cv.context.gen {
+		// the gradient was lost with the context
+		rg.created, rg.loaded = false, false
+		rg.gen = rg.cv.context.gen
+	}
 	if rg.loaded || len(rg.data) < 1 {
 		return
 	}
@@ -124,6 +153,20 @@ func (rg *RadialGradient) AddColorStop(pos float64, stopColor ...interface{}) {
 	rg.loaded = false
 }
 
+// SetDither sets how the colors of the gradient are rounded to 8 bits
+// per channel. Dithering breaks up the bands that gradients over large
+// areas show otherwise. Only the software backend dithers
+func (lg *LinearGradient) SetDither(dither gradientDither) {
+	lg.dither = dither
+}
+
+// SetDither sets how the colors of the gradient are rounded to 8 bits
+// per channel. Dithering breaks up the bands that gradients over large
+// areas show otherwise. Only the software backend dithers
+func (rg *RadialGradient) SetDither(dither gradientDither) {
+	rg.dither = dither
+}
+
 func addColorStop(stops BackendGradient, pos float64, stopColor ...interface{}) (BackendGradient, color.RGBA) {
 	c, _ := parseColor(stopColor...)
 	insert := len(stops)
@@ -0,0 +1,170 @@
+package canvas
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// maxHairlineWidth is the widest line that is drawn as a hairline
+const maxHairlineWidth = 1.5
+
+// HairlineBackend is implemented by backends that can draw thin lines
+// faster than by filling the quads of their segments. The lines are
+// pairs of points in pixels. The function returns false if it can't
+// handle the call, in which case the lines are filled instead
+type HairlineBackend interface {
+	StrokeHairlines(lines []BackendVec, width float64, col color.RGBA) bool
+}
+
+// strokeHairlines strokes the path with the backend fast path if the
+// line is thin, the transformation is the identity and the stroke style
+// is a plain color without shadows
+func (cv *Canvas) strokeHairlines(path *Path2D, tf BackendMat) bool {
+	hb, ok := cv.b.(HairlineBackend)
+	if !ok || tf != BackendMatIdentity || cv.state.lineWidth > maxHairlineWidth || cv.state.shadowColor.A != 0 {
+		return false
+	}
+	s := &cv.state.stroke
+	if s.linearGradient != nil || s.radialGradient != nil || s.imagePattern != nil {
+		return false
+	}
+
+	scratch := getVecScratch(0)
+	lines := scratch.buf
+	defer func() { scratch.release(lines) }()
+	var p0 BackendVec
+	for _, p := range cv.applyLineDash(path.p) {
+		if p.flags&pathMove == 0 {
+			lines = append(lines, p0, p.pos)
+		}
+		p0 = p.pos
+	}
+	if len(lines) == 0 {
+		return true
+	}
+
+	cv.Flush()
+	stl := cv.backendFillStyle(s, 1)
+	if !hb.StrokeHairlines(lines, cv.state.lineWidth, stl.Color) {
+		return false
+	}
+	cv.countHairlines(lines, cv.state.lineWidth)
+	return true
+}
+
+// countHairlines counts a draw call with the lines
+func (cv *Canvas) countHairlines(lines []BackendVec, width float64) {
+	cv.stats.changes++
+	st := &cv.stats.cur
+	st.DrawCalls++
+	var length float64
+	for i := 0; i+1 < len(lines); i += 2 {
+		length += lines[i+1].Sub(lines[i]).Len()
+	}
+	st.Pixels += int(length * width)
+}
+
+// StrokeHairlines draws the lines with Wu's algorithm if Hairlines is
+// set. The pixels where lines meet or cross are blended once for every
+// line. It returns false if Hairlines is not set, or if MSAA or a
+// coverage callback is active
+func (b *SoftwareBackend) StrokeHairlines(lines []BackendVec, width float64, col color.RGBA) bool {
+	if !b.Hairlines || b.MSAA > 0 || b.coverageFn != nil {
+		return false
+	}
+	if col.A == 0 {
+		return true
+	}
+	b.touchPts(lines)
+	for i := 0; i+1 < len(lines); i += 2 {
+		b.wuLine(lines[i], lines[i+1], width, col)
+	}
+	return true
+}
+
+// wuLine draws an anti-aliased line with Wu's algorithm. The coverage
+// of each column of a one pixel line adds up to one, and wider lines
+// are drawn with more coverage
+func (b *SoftwareBackend) wuLine(p0, p1 BackendVec, width float64, col color.RGBA) {
+	x0, y0, x1, y1 := p0[0]-0.5, p0[1]-0.5, p1[0]-0.5, p1[1]-0.5
+	if math.IsNaN(x0+y0+x1+y1) || math.IsInf(x0+y0+x1+y1, 0) {
+		return
+	}
+	steep := math.Abs(y1-y0) > math.Abs(x1-x0)
+	if steep {
+		x0, y0, x1, y1 = y0, x0, y1, x1
+	}
+	if x0 > x1 {
+		x0, y0, x1, y1 = x1, y1, x0, y0
+	}
+	plot := func(x, y, cov float64) {
+		if steep {
+			x, y = y, x
+		}
+		// checked before the conversion, which is undefined for huge
+		// values
+		if x < 0 || y < 0 || x >= float64(b.w) || y >= float64(b.h) {
+			return
+		}
+		b.blendHairline(int(x), int(y), cov*width, col)
+	}
+
+	gradient := 1.0
+	if dx := x1 - x0; dx != 0 {
+		gradient = (y1 - y0) / dx
+	}
+
+	// the end points only cover the part of their column on the line
+	xend := math.Round(x0)
+	yend := y0 + gradient*(xend-x0)
+	xgap := 1 - fract(x0+0.5)
+	xstart := xend
+	plot(xend, math.Floor(yend), (1-fract(yend))*xgap)
+	plot(xend, math.Floor(yend)+1, fract(yend)*xgap)
+	ystart := yend
+
+	xend = math.Round(x1)
+	yend = y1 + gradient*(xend-x1)
+	xgap = fract(x1 + 0.5)
+	plot(xend, math.Floor(yend), (1-fract(yend))*xgap)
+	plot(xend, math.Floor(yend)+1, fract(yend)*xgap)
+
+	// only the columns inside of the image are walked, so that lines far
+	// outside of it don't take long
+	size := b.w
+	if steep {
+		size = b.h
+	}
+	from := math.Max(xstart+1, -1)
+	to := math.Min(xend, float64(size)+1)
+	intery := ystart + gradient*(from-xstart)
+	for x := from; x < to; x++ {
+		y := math.Floor(intery)
+		plot(x, y, 1-fract(intery))
+		plot(x, y+1, fract(intery))
+		intery += gradient
+	}
+}
+
+// blendHairline blends the color with the coverage over the pixel if it
+// is inside of the clipping region
+func (b *SoftwareBackend) blendHairline(x, y int, cov float64, col color.RGBA) {
+	if cov <= 0 || !image.Pt(x, y).In(b.clipRect) {
+		return
+	}
+	if !b.clipIsRect && b.clip.Pix[b.clip.PixOffset(x, y)] == 0 {
+		return
+	}
+	if cov < 1 {
+		col.A = uint8(math.Round(float64(col.A) * cov))
+	}
+	p := b.Image.Pix[b.Image.PixOffset(x, y):]
+	p = p[:4:4]
+	c := mix(col, color.RGBA{R: p[0], G: p[1], B: p[2], A: p[3]})
+	p[0], p[1], p[2], p[3] = c.R, c.G, c.B, c.A
+}
+
+func fract(v float64) float64 {
+	return v - math.Floor(v)
+}
@@ -0,0 +1,215 @@
+package canvas
+
+import (
+	"bytes"
+	"image"
+)
+
+// History records the changes that groups of drawing calls make to a
+// canvas, so that they can be undone and redone, as needed by editors.
+// Only the regions that changed are kept, as a copy before and after
+// the group. On the software backend the regions are found with a
+// copy-on-write snapshot, on other backends the whole canvas is read
+// back before and after every group
+type History struct {
+	// MaxBytes limits the memory used by the recorded changes. The
+	// oldest groups are dropped when it is exceeded. Zero means no limit
+	MaxBytes int
+
+	cv         *Canvas
+	undo, redo []historyGroup
+	bytes      int
+
+	active bool
+	w, h   int
+	snap   *Snapshot
+	before *image.RGBA
+}
+
+// historyGroup is the list of regions that a group changed
+type historyGroup []historyRegion
+
+type historyRegion struct {
+	before, after *image.RGBA
+}
+
+func (g historyGroup) size() int {
+	n := 0
+	for _, r := range g {
+		n += len(r.before.Pix) + len(r.after.Pix)
+	}
+	return n
+}
+
+// NewHistory creates a history for the canvas that keeps at most
+// maxBytes of changes, or any amount if it is zero
+func NewHistory(cv *Canvas, maxBytes int) *History {
+	return &History{cv: cv, MaxBytes: maxBytes}
+}
+
+// Begin starts a group of drawing calls that is undone as a whole, for
+// example all drawing of a brush stroke from pressing to releasing the
+// mouse button. Calling Begin while a group is active ends it first
+func (h *History) Begin() {
+	if h.active {
+		h.End()
+	}
+	h.cv.Flush()
+	h.active = true
+	h.w, h.h = h.cv.Size()
+	if sb, ok := h.cv.backend().(*SoftwareBackend); ok {
+		h.snap = sb.Snapshot()
+	} else {
+		h.before = h.cv.GetImageData(0, 0, h.w, h.h)
+	}
+}
+
+// End finishes the group started with Begin and records the regions that
+// it changed. Groups that change nothing are not recorded
+func (h *History) End() {
+	if !h.active {
+		return
+	}
+	h.cv.Flush()
+	h.active = false
+	snap, before := h.snap, h.before
+	h.snap, h.before = nil, nil
+	if snap != nil {
+		defer snap.Release()
+	}
+	if w, hh := h.cv.Size(); w != h.w || hh != h.h {
+		// changes of the size can't be undone
+		h.Clear()
+		return
+	}
+
+	var group historyGroup
+	for ty := 0; ty*snapshotTile < h.h; ty++ {
+		for tx := 0; tx*snapshotTile < h.w; tx++ {
+			r := image.Rect(tx*snapshotTile, ty*snapshotTile, (tx+1)*snapshotTile, (ty+1)*snapshotTile).Intersect(image.Rect(0, 0, h.w, h.h))
+			var old *image.RGBA
+			if snap != nil {
+				tile := snap.tiles[ty*snap.cols+tx]
+				if tile == nil {
+					continue
+				}
+				old = &image.RGBA{Pix: tile, Stride: r.Dx() * 4, Rect: r}
+			} else {
+				old = before.SubImage(r).(*image.RGBA)
+			}
+			cur := h.cv.GetImageData(r.Min.X, r.Min.Y, r.Dx(), r.Dy())
+			if rgbaEqual(old, cur) {
+				continue
+			}
+			if snap == nil {
+				old = copyRGBA(old)
+			}
+			group = append(group, historyRegion{before: old, after: cur})
+		}
+	}
+	if len(group) == 0 {
+		return
+	}
+	h.redo = h.redo[:0]
+	h.undo = append(h.undo, group)
+	h.recount()
+	h.trim()
+}
+
+// Group records the drawing calls of fn as one group
+func (h *History) Group(fn func(cv *Canvas)) {
+	h.Begin()
+	fn(h.cv)
+	h.End()
+}
+
+// Undo reverts the last recorded group. It returns false if there is
+// nothing to undo
+func (h *History) Undo() bool {
+	h.End()
+	if len(h.undo) == 0 {
+		return false
+	}
+	group := h.undo[len(h.undo)-1]
+	h.undo = h.undo[:len(h.undo)-1]
+	for _, r := range group {
+		h.cv.PutImageData(r.before, r.before.Rect.Min.X, r.before.Rect.Min.Y)
+	}
+	h.redo = append(h.redo, group)
+	return true
+}
+
+// Redo applies the last undone group again. It returns false if there
+// is nothing to redo
+func (h *History) Redo() bool {
+	h.End()
+	if len(h.redo) == 0 {
+		return false
+	}
+	group := h.redo[len(h.redo)-1]
+	h.redo = h.redo[:len(h.redo)-1]
+	for _, r := range group {
+		h.cv.PutImageData(r.after, r.after.Rect.Min.X, r.after.Rect.Min.Y)
+	}
+	h.undo = append(h.undo, group)
+	return true
+}
+
+// CanUndo returns whether there is a group to undo
+func (h *History) CanUndo() bool { return len(h.undo) > 0 }
+
+// CanRedo returns whether there is a group to redo
+func (h *History) CanRedo() bool { return len(h.redo) > 0 }
+
+// Bytes returns the memory used by the recorded changes
+func (h *History) Bytes() int { return h.bytes }
+
+// Clear drops all recorded changes
+func (h *History) Clear() {
+	h.undo, h.redo = nil, nil
+	h.bytes = 0
+}
+
+func (h *History) recount() {
+	h.bytes = 0
+	for _, g := range h.undo {
+		h.bytes += g.size()
+	}
+	for _, g := range h.redo {
+		h.bytes += g.size()
+	}
+}
+
+// trim drops the oldest undo groups until the changes fit into MaxBytes.
+// The last group is kept even if it is larger
+func (h *History) trim() {
+	for h.MaxBytes > 0 && h.bytes > h.MaxBytes && len(h.undo) > 1 {
+		h.bytes -= h.undo[0].size()
+		h.undo[0] = nil
+		h.undo = h.undo[1:]
+	}
+}
+
+// rgbaEqual returns whether the two images of the same size have the
+// same pixels
+func rgbaEqual(a, b *image.RGBA) bool {
+	w := a.Rect.Dx() * 4
+	for y := 0; y < a.Rect.Dy(); y++ {
+		ra := a.Pix[y*a.Stride : y*a.Stride+w]
+		rb := b.Pix[y*b.Stride : y*b.Stride+w]
+		if !bytes.Equal(ra, rb) {
+			return false
+		}
+	}
+	return true
+}
+
+// copyRGBA returns a tightly packed copy of the image
+func copyRGBA(img *image.RGBA) *image.RGBA {
+	c := image.NewRGBA(img.Rect)
+	w := img.Rect.Dx() * 4
+	for y := 0; y < img.Rect.Dy(); y++ {
+		copy(c.Pix[y*c.Stride:y*c.Stride+w], img.Pix[y*img.Stride:])
+	}
+	return c
+}
@@ -0,0 +1,198 @@
+package canvas
+
+import (
+	"math"
+	"sort"
+)
+
+// hitGridSize is the cell size in pixels of the grid that is used to
+// find the hit regions that may contain a point
+const hitGridSize = 64
+
+// maxHitCells is the maximum number of grid cells a region is added to.
+// Larger regions are always checked instead
+const maxHitCells = 1024
+
+type hitRegion struct {
+	id       string
+	seq      int
+	path     Path2D
+	min, max BackendVec
+	cells    [][2]int
+}
+
+type hitRegions struct {
+	seq     int
+	regions []*hitRegion
+	large   []*hitRegion
+	grid    map[[2]int][]*hitRegion
+}
+
+// AddHitRegion registers the given path as a hit region with the given
+// id, so that HitTest can find it. The path is transformed with the
+// current transformation. If path is nil, the current path is used. A
+// region that was previously added with the same id is replaced
+func (cv *Canvas) AddHitRegion(id string, path *Path2D) {
+	cv.RemoveHitRegion(id)
+
+	region := &hitRegion{id: id}
+	if path == nil {
+		region.path.p = make([]pathPoint, len(cv.path.p))
+		copy(region.path.p, cv.path.p)
+	} else {
+		region.path.p = make([]pathPoint, len(path.p))
+		for i, pt := range path.p {
+			pt.pos = cv.tf(pt.pos)
+			pt.next = cv.tf(pt.next)
+			region.path.p[i] = pt
+		}
+	}
+	if len(region.path.p) < 3 {
+		return
+	}
+
+	cv.hitRegions.seq++
+	region.seq = cv.hitRegions.seq
+	cv.hitRegions.add(region)
+}
+
+func (hr *hitRegions) add(region *hitRegion) {
+	region.min = BackendVec{math.Inf(1), math.Inf(1)}
+	region.max = BackendVec{math.Inf(-1), math.Inf(-1)}
+	for _, pt := range region.path.p {
+		region.min = BackendVec{math.Min(region.min[0], pt.pos[0]), math.Min(region.min[1], pt.pos[1])}
+		region.max = BackendVec{math.Max(region.max[0], pt.pos[0]), math.Max(region.max[1], pt.pos[1])}
+	}
+	hr.regions = append(hr.regions, region)
+
+	cw := math.Floor(region.max[0]/hitGridSize) - math.Floor(region.min[0]/hitGridSize) + 1
+	ch := math.Floor(region.max[1]/hitGridSize) - math.Floor(region.min[1]/hitGridSize) + 1
+	if !(cw*ch <= maxHitCells) {
+		hr.large = append(hr.large, region)
+		return
+	}
+	x0, y0 := hitCell(region.min)
+	x1, y1 := hitCell(region.max)
+	if hr.grid == nil {
+		hr.grid = make(map[[2]int][]*hitRegion)
+	}
+	region.cells = region.cells[:0]
+	for y := y0; y <= y1; y++ {
+		for x := x0; x <= x1; x++ {
+			cell := [2]int{x, y}
+			hr.grid[cell] = append(hr.grid[cell], region)
+			region.cells = append(region.cells, cell)
+		}
+	}
+}
+
+// RemoveHitRegion removes the hit region with the given id
+func (cv *Canvas) RemoveHitRegion(id string) {
+	hr := &cv.hitRegions
+	for i, region := range hr.regions {
+		if region.id != id {
+			continue
+		}
+		for _, cell := range region.cells {
+			list := removeHitRegion(hr.grid[cell], region)
+			if len(list) == 0 {
+				delete(hr.grid, cell)
+			} else {
+				hr.grid[cell] = list
+			}
+		}
+		hr.large = removeHitRegion(hr.large, region)
+		hr.regions = append(hr.regions[:i], hr.regions[i+1:]...)
+		return
+	}
+}
+
+func removeHitRegion(list []*hitRegion, region *hitRegion) []*hitRegion {
+	for i, r := range list {
+		if r == region {
+			return append(list[:i], list[i+1:]...)
+		}
+	}
+	return list
+}
+
+// ClearHitRegions removes all hit regions
+func (cv *Canvas) ClearHitRegions() {
+	cv.hitRegions = hitRegions{}
+}
+
+// HitTest returns the ids of all hit regions that contain the given point
+// in canvas pixel coordinates. The most recently added region comes first
+func (cv *Canvas) HitTest(x, y float64) []string {
+	cx, cy := hitCell(BackendVec{x, y})
+	var hits []*hitRegion
+	check := func(list []*hitRegion) {
+		for _, region := range list {
+			if x < region.min[0] || y < region.min[1] || x > region.max[0] || y > region.max[1] {
+				continue
+			}
+			if region.path.IsPointInPath(x, y, NonZero) {
+				hits = append(hits, region)
+			}
+		}
+	}
+	check(cv.hitRegions.grid[[2]int{cx, cy}])
+	check(cv.hitRegions.large)
+	if len(hits) == 0 {
+		return nil
+	}
+
+	sort.Slice(hits, func(i, j int) bool { return hits[i].seq > hits[j].seq })
+	ids := make([]string, len(hits))
+	for i, region := range hits {
+		ids[i] = region.id
+	}
+	return ids
+}
+
+// HitRegionAt returns the id of the topmost hit region at the pixel that
+// contains the given point in canvas pixel coordinates, or an empty
+// string if there is none. Regions are tested at the center of the
+// pixel, like shapes are filled without anti-aliasing, so that the
+// result matches the pixels that a region covers when it is drawn
+func (cv *Canvas) HitRegionAt(x, y float64) string {
+	px, py := math.Floor(x)+0.5, math.Floor(y)+0.5
+	cx, cy := hitCell(BackendVec{px, py})
+	var top *hitRegion
+	check := func(list []*hitRegion) {
+		for _, region := range list {
+			if top != nil && region.seq < top.seq {
+				continue
+			}
+			if px < region.min[0] || py < region.min[1] || px > region.max[0] || py > region.max[1] {
+				continue
+			}
+			if region.path.IsPointInPath(px, py, NonZero) {
+				top = region
+			}
+		}
+	}
+	check(cv.hitRegions.grid[[2]int{cx, cy}])
+	check(cv.hitRegions.large)
+	if top == nil {
+		return ""
+	}
+	return top.id
+}
+
+// remap transforms all hit regions with the given matrix
+func (hr *hitRegions) remap(m BackendMat) {
+	if m == BackendMatIdentity || len(hr.regions) == 0 {
+		return
+	}
+	regions := hr.regions
+	*hr = hitRegions{seq: hr.seq}
+	for _, region := range regions {
+		region.path.remap(m)
+		hr.add(region)
+	}
+}
+
+func hitCell(v BackendVec) (int, int) {
+	return int(math.Floor(v[0] / hitGridSize)), int(math.Floor(v[1] / hitGridSize))
+}
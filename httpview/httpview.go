@@ -0,0 +1,269 @@
+// Package httpview streams a canvas to web browsers, to watch long
+// running renders during development. The render loop passes each frame
+// to Update, and the Handler sends the latest frame to every connected
+// browser at a limited frame rate, either as an MJPEG stream that an img
+// element can show directly, or as PNG images over server-sent events.
+//
+// Requests that accept HTML get a small viewer page. Otherwise the format
+// query parameter selects the stream, mjpeg (the default) or sse:
+//
+//	http.Handle("/view", h)
+//	// <img src="/view?format=mjpeg">
+package httpview
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opentoys/canvas"
+)
+
+// boundary separates the frames of the MJPEG stream
+const boundary = "canvasframe"
+
+// Handler is an http.Handler that streams the frames passed to Update
+type Handler struct {
+	// FPS limits the frames per second sent to each browser. Zero is 10
+	FPS float64
+	// Quality is the JPEG quality of the MJPEG stream. Zero is 80
+	Quality int
+	// Background is drawn below the frames of the MJPEG stream, since JPEG
+	// has no alpha channel. Nil is opaque black. The PNG images of the
+	// server-sent events keep the alpha channel
+	Background color.Color
+
+	mu      sync.Mutex
+	changed *sync.Cond
+	frame   *image.NRGBA
+	version uint64
+	jpeg    []byte
+	jpegVer uint64
+	png     []byte
+	pngVer  uint64
+}
+
+// New creates a handler without a frame. Browsers wait for the first
+// call to Update
+func New() *Handler {
+	h := &Handler{}
+	h.changed = sync.NewCond(&h.mu)
+	return h
+}
+
+// Update sets the content of the canvas as the current frame. It must be
+// called from the goroutine that draws on the canvas
+func (h *Handler) Update(cv *canvas.Canvas) {
+	w, h2 := cv.Size()
+	h.UpdateImage(canvas.ImageDataNRGBA(cv.GetImageData(0, 0, w, h2)))
+}
+
+// UpdateImage sets the image as the current frame. The image must not be
+// changed afterwards
+func (h *Handler) UpdateImage(img *image.NRGBA) {
+	h.mu.Lock()
+	h.frame = img
+	h.version++
+	h.mu.Unlock()
+	h.changed.Broadcast()
+}
+
+// next waits until there is a frame newer than the given version, or the
+// request is cancelled, and returns it in the format
+func (h *Handler) next(r *http.Request, version uint64, encode func(*image.NRGBA, uint64) ([]byte, error)) ([]byte, uint64, error) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			// with the lock, so the wakeup can't happen between the
+			// check of the context and the wait
+			h.mu.Lock()
+			h.changed.Broadcast()
+			h.mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	h.mu.Lock()
+	for h.version == version {
+		if r.Context().Err() != nil {
+			h.mu.Unlock()
+			return nil, 0, r.Context().Err()
+		}
+		h.changed.Wait()
+	}
+	// frames are not changed after UpdateImage, so they can be encoded
+	// without holding the lock
+	frame, version := h.frame, h.version
+	h.mu.Unlock()
+	data, err := encode(frame, version)
+	return data, version, err
+}
+
+// encodeJPEG returns the frame as JPEG, encoding it only once per frame
+func (h *Handler) encodeJPEG(frame *image.NRGBA, version uint64) ([]byte, error) {
+	return h.cached(&h.jpeg, &h.jpegVer, version, func(buf *bytes.Buffer) error {
+		quality := h.Quality
+		if quality <= 0 {
+			quality = 80
+		}
+		bg := h.Background
+		if bg == nil {
+			bg = color.Black
+		}
+		img := image.NewRGBA(frame.Rect)
+		draw.Draw(img, img.Rect, image.NewUniform(bg), image.Point{}, draw.Src)
+		draw.Draw(img, img.Rect, frame, frame.Rect.Min, draw.Over)
+		return jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+	})
+}
+
+// encodePNG is like encodeJPEG for PNG
+func (h *Handler) encodePNG(frame *image.NRGBA, version uint64) ([]byte, error) {
+	return h.cached(&h.png, &h.pngVer, version, func(buf *bytes.Buffer) error {
+		return png.Encode(buf, frame)
+	})
+}
+
+// cached returns the data if it belongs to the version, and otherwise
+// encodes it without holding the lock and stores it unless a newer
+// version was stored in the meantime
+func (h *Handler) cached(data *[]byte, dataVer *uint64, version uint64, encode func(*bytes.Buffer) error) ([]byte, error) {
+	h.mu.Lock()
+	if *dataVer == version {
+		defer h.mu.Unlock()
+		return *data, nil
+	}
+	h.mu.Unlock()
+
+	var buf bytes.Buffer
+	if err := encode(&buf); err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	if *dataVer < version {
+		*data, *dataVer = buf.Bytes(), version
+	}
+	h.mu.Unlock()
+	return buf.Bytes(), nil
+}
+
+func (h *Handler) interval() time.Duration {
+	fps := h.FPS
+	if fps <= 0 {
+		fps = 10
+	}
+	return time.Duration(float64(time.Second) / fps)
+}
+
+// ServeHTTP serves the viewer page or streams the frames until the
+// browser disconnects
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		format = "html"
+	}
+	switch format {
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, viewerPage)
+	case "", "mjpeg":
+		h.serveMJPEG(w, r)
+	case "sse":
+		h.serveSSE(w, r)
+	default:
+		http.Error(w, fmt.Sprintf("Unknown format %q", format), http.StatusBadRequest)
+	}
+}
+
+func (h *Handler) serveMJPEG(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+boundary)
+	w.Header().Set("Cache-Control", "no-cache")
+	// send the headers before waiting for the first frame
+	flusher.Flush()
+	h.stream(r, h.encodeJPEG, func(data []byte) error {
+		_, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", boundary, len(data))
+		if err == nil {
+			_, err = w.Write(data)
+		}
+		if err == nil {
+			_, err = w.Write([]byte("\r\n"))
+		}
+		flusher.Flush()
+		return err
+	})
+}
+
+func (h *Handler) serveSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// send the headers before waiting for the first frame
+	flusher.Flush()
+	h.stream(r, h.encodePNG, func(data []byte) error {
+		_, err := fmt.Fprintf(w, "event: frame\ndata: data:image/png;base64,%s\n\n", base64.StdEncoding.EncodeToString(data))
+		flusher.Flush()
+		return err
+	})
+}
+
+// stream sends new frames, at most at the frame rate, until the request
+// is cancelled or writing fails. Frames that are replaced before they
+// could be sent are skipped
+func (h *Handler) stream(r *http.Request, encode func(*image.NRGBA, uint64) ([]byte, error), send func([]byte) error) {
+	interval := h.interval()
+	var version uint64
+	var last time.Time
+	for {
+		if wait := interval - time.Since(last); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		data, v, err := h.next(r, version, encode)
+		if err != nil {
+			return
+		}
+		version, last = v, time.Now()
+		if err := send(data); err != nil {
+			return
+		}
+	}
+}
+
+const viewerPage = `<!DOCTYPE html>
+<html><head><title>canvas</title>
+<style>body{margin:0;background:#333;display:flex;justify-content:center;align-items:center;min-height:100vh}</style>
+</head><body>
+<img id="frame" alt="">
+<script>
+var img = document.getElementById("frame");
+if (window.EventSource) {
+	new EventSource("?format=sse").addEventListener("frame", function(e) { img.src = e.data; });
+} else {
+	img.src = "?format=mjpeg";
+}
+</script>
+</body></html>
+`
@@ -0,0 +1,180 @@
+package httpview_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentoys/canvas"
+	"github.com/opentoys/canvas/httpview"
+)
+
+func get(t *testing.T, url, accept string) (*http.Response, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	req, _ := http.NewRequest("GET", url, nil)
+	req = req.WithContext(ctx)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		t.Fatalf("Request failed: %v", err)
+	}
+	return resp, cancel
+}
+
+func TestMJPEG(t *testing.T) {
+	cv := canvas.New(canvas.NewBackend(20, 10))
+	cv.SetFillStyle("#F00")
+	cv.FillRect(0, 0, 20, 10)
+	h := httpview.New()
+	h.FPS = 100
+	h.Update(cv)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, cancel := get(t, srv.URL, "")
+	defer cancel()
+	defer resp.Body.Close()
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/x-mixed-replace" {
+		t.Fatalf("Expected a multipart stream, got %q", resp.Header.Get("Content-Type"))
+	}
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+
+	part, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("Failed to read the first frame: %v", err)
+	}
+	img, err := jpeg.Decode(part)
+	if err != nil {
+		t.Fatalf("Failed to decode the first frame: %v", err)
+	}
+	if r, g, _, _ := img.At(10, 5).RGBA(); r < 0xF000 || g > 0x1000 {
+		t.Errorf("Expected red, got %v", img.At(10, 5))
+	}
+
+	// the next frame is only sent after an update
+	cv.SetFillStyle("#00F")
+	cv.FillRect(0, 0, 20, 10)
+	h.Update(cv)
+	part, err = mr.NextPart()
+	if err != nil {
+		t.Fatalf("Failed to read the second frame: %v", err)
+	}
+	img, err = jpeg.Decode(part)
+	if err != nil {
+		t.Fatalf("Failed to decode the second frame: %v", err)
+	}
+	if r, _, b, _ := img.At(10, 5).RGBA(); b < 0xF000 || r > 0x1000 {
+		t.Errorf("Expected blue, got %v", img.At(10, 5))
+	}
+}
+
+func TestMJPEGBackground(t *testing.T) {
+	h := httpview.New()
+	h.FPS = 100
+	h.Background = color.White
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	for x := 10; x < 20; x++ {
+		for y := 0; y < 10; y++ {
+			img.SetNRGBA(x, y, color.NRGBA{0, 0, 255, 128})
+		}
+	}
+	h.UpdateImage(img)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, cancel := get(t, srv.URL, "")
+	defer cancel()
+	defer resp.Body.Close()
+	_, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	part, err := multipart.NewReader(resp.Body, params["boundary"]).NextPart()
+	if err != nil {
+		t.Fatalf("Failed to read the frame: %v", err)
+	}
+	frame, err := jpeg.Decode(part)
+	if err != nil {
+		t.Fatalf("Failed to decode the frame: %v", err)
+	}
+	if r, g, b, _ := frame.At(4, 5).RGBA(); r < 0xF000 || g < 0xF000 || b < 0xF000 {
+		t.Errorf("Expected the white background, got %v", frame.At(4, 5))
+	}
+	if r, _, b, _ := frame.At(15, 5).RGBA(); b < 0xF000 || r < 0x7000 || r > 0x9000 {
+		t.Errorf("Expected blue blended over white, got %v", frame.At(15, 5))
+	}
+}
+
+func TestSSE(t *testing.T) {
+	cv := canvas.New(canvas.NewBackend(4, 4))
+	cv.SetFillStyle("#0F0")
+	cv.FillRect(0, 0, 4, 4)
+	h := httpview.New()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, cancel := get(t, srv.URL+"?format=sse", "")
+	defer cancel()
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q", ct)
+	}
+	h.Update(cv)
+
+	r := bufio.NewReader(resp.Body)
+	var data string
+	for data == "" {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read the event: %v", err)
+		}
+		if strings.HasPrefix(line, "data: data:image/png;base64,") {
+			data = strings.TrimSpace(strings.TrimPrefix(line, "data: data:image/png;base64,"))
+		}
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		t.Fatalf("Invalid base64: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Failed to decode the frame: %v", err)
+	}
+	if _, g, _, a := img.At(1, 1).RGBA(); g != 0xFFFF || a != 0xFFFF {
+		t.Errorf("Expected green, got %v", img.At(1, 1))
+	}
+}
+
+func TestViewerPage(t *testing.T) {
+	srv := httptest.NewServer(httpview.New())
+	defer srv.Close()
+
+	resp, cancel := get(t, srv.URL, "text/html,*/*")
+	defer cancel()
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "EventSource") {
+		t.Errorf("Expected the viewer page, got %q", body)
+	}
+
+	resp, cancel = get(t, srv.URL+"?format=gif", "")
+	defer cancel()
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown format, got %d", resp.StatusCode)
+	}
+}
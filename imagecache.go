@@ -0,0 +1,172 @@
+package canvas
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io/ioutil"
+)
+
+// ImageCacheStats are the metrics of the image cache of a canvas
+type ImageCacheStats struct {
+	// Images is the number of cached images, decoded or not
+	Images int
+	// Decoded is the number of images that are currently decoded
+	Decoded int
+	// Bytes is the approximate memory used by the decoded images
+	Bytes int
+	// Budget is the memory budget set with SetImageBudget
+	Budget int
+
+	// Hits is the number of times a decoded image was used
+	Hits int
+	// Decodes is the number of times an image was decoded, including
+	// decoding it again after it was evicted
+	Decodes int
+	// Evictions is the number of times a decoded image was released
+	Evictions int
+	// Shared is the number of times an image was loaded with the same
+	// pixels as a loaded image and uses its memory
+	Shared int
+}
+
+// imageCache is the memory budget and the metrics of the cached images
+type imageCache struct {
+	budget int
+	stats  ImageCacheStats
+}
+
+// SetImageBudget limits the memory used by decoded images of the canvas
+// to approximately the given number of bytes. When a decoded image
+// exceeds it, the least recently used images are evicted. Images loaded
+// from files or bytes stay valid when they are evicted and are decoded
+// again the next time they are drawn, other images are deleted. A budget
+// of 0 only applies the global Performance.CacheSize
+func (cv *Canvas) SetImageBudget(bytes int) {
+	cv.imageCache.budget = bytes
+	if bytes > 0 {
+		cv.trimImages(bytes, nil)
+	}
+}
+
+// ImageCacheStats returns the metrics of the image cache
+func (cv *Canvas) ImageCacheStats() ImageCacheStats {
+	stats := cv.imageCache.stats
+	stats.Budget = cv.imageCache.budget
+	stats.Images = len(cv.images)
+	for _, img := range cv.images {
+		if !img.evicted {
+			stats.Decoded++
+			stats.Bytes += img.memSize()
+		}
+	}
+	return stats
+}
+
+// LoadImageLazy returns an image that is only decoded when it is first
+// drawn, from a file name or the contents of an image file. Only the
+// header is read, so the size of the image is available right away. The
+// image is kept in the cache of the canvas and can be evicted and
+// decoded again under memory pressure, see SetImageBudget
+func (cv *Canvas) LoadImageLazy(src interface{}, quality ...MipmapQuality) (*Image, error) {
+	mipmap := MipmapBox
+	if len(quality) > 0 {
+		mipmap = quality[0]
+	}
+	var data []byte
+	switch v := src.(type) {
+	case string:
+		if img, ok := cv.images[v]; ok {
+			return img, nil
+		}
+		var err error
+		data, err = ioutil.ReadFile(v)
+		if err != nil {
+			return nil, err
+		}
+	case []byte:
+		data = v
+	default:
+		return nil, fmt.Errorf("Lazy loading %T: %w", src, ErrUnsupportedSource)
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	w, h := cfg.Width, cfg.Height
+	if format == "jpeg" && jpegOrientation(data) >= 5 {
+		w, h = h, w
+	}
+	img := &Image{cv: cv, src: src, mipmap: mipmap, evicted: true, w: w, h: h}
+	if _, ok := src.([]byte); ok {
+		// byte slices can't be map keys, the image is its own key
+		cv.images[img] = img
+	} else {
+		cv.images[src] = img
+	}
+	return img, nil
+}
+
+// memSize returns the approximate memory used by the decoded image
+func (img *Image) memSize() int {
+	w, h := img.Size()
+	return w * h * 4
+}
+
+// evictable returns whether the image can be released and decoded again
+// from its source
+func (img *Image) evictable() bool {
+	switch img.src.(type) {
+	case string, []byte:
+		return true
+	}
+	return false
+}
+
+// evict releases the decoded pixels of the image, which are decoded
+// again from the source the next time the image is used
+func (img *Image) evict() {
+	img.w, img.h = img.Size()
+	img.releaseImage()
+	img.img = nil
+	img.data = nil
+	img.alphaMask = nil
+	img.evicted = true
+	img.cv.imageCache.stats.Evictions++
+}
+
+// trimImages evicts or deletes the least recently used decoded images
+// other than keep until they use at most budget bytes
+func (cv *Canvas) trimImages(budget int, keep *Image) {
+	for {
+		total := 0
+		var oldest *Image
+		var oldestKey interface{}
+		for key, img := range cv.images {
+			if img.evicted {
+				continue
+			}
+			total += img.memSize()
+			if img != keep && (oldest == nil || img.lastUsed.Before(oldest.lastUsed)) {
+				oldest, oldestKey = img, key
+			}
+		}
+		if total <= budget || oldest == nil {
+			return
+		}
+		cv.dropImage(oldestKey, oldest)
+	}
+}
+
+// dropImage evicts the image if it can be decoded again, otherwise it is
+// deleted and removed from the cache
+func (cv *Canvas) dropImage(key interface{}, img *Image) {
+	if img.evictable() {
+		img.evict()
+		return
+	}
+	img.Delete()
+	delete(cv.images, key)
+	cv.imageCache.stats.Evictions++
+}
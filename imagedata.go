@@ -0,0 +1,114 @@
+package canvas
+
+import (
+	"errors"
+	"image"
+)
+
+// ImageDataIntoBackend is implemented by backends that can read back
+// the canvas content into a buffer of the caller. For other backends
+// GetImageDataInto copies the result of GetImageData
+type ImageDataIntoBackend interface {
+	GetImageDataInto(dst []byte, x, y, w, h, stride int)
+}
+
+// GetImageDataInto reads the w by h pixels at x/y into dst, with stride
+// bytes per row, without allocating a new image for every read-back.
+// The colors are straight alpha RGBA like those of GetImageData, and
+// pixels outside of the canvas are transparent. An error is returned
+// if the buffer is too small
+func (cv *Canvas) GetImageDataInto(dst []byte, x, y, w, h, stride int) error {
+	if w <= 0 || h <= 0 {
+		return nil
+	}
+	if stride < w*4 {
+		return errors.New("Stride smaller than a row of the image data")
+	}
+	if len(dst) < stride*(h-1)+w*4 {
+		return errors.New("Buffer too small for the image data")
+	}
+	cv.Flush()
+	getImageDataInto(cv.b, dst, x, y, w, h, stride)
+	return nil
+}
+
+func getImageDataInto(b Backend, dst []byte, x, y, w, h, stride int) {
+	if ib, ok := b.(ImageDataIntoBackend); ok {
+		ib.GetImageDataInto(dst, x, y, w, h, stride)
+		return
+	}
+	copyImageData(dst, x, y, w, h, stride, b.GetImageData(x, y, w, h))
+}
+
+// copyImageData copies the part of src that overlaps the rectangle at
+// x/y into dst and clears the rest
+func copyImageData(dst []byte, x, y, w, h, stride int, src *image.RGBA) {
+	rect := image.Rect(x, y, x+w, y+h)
+	inside := rect.Intersect(src.Rect)
+	for row := 0; row < h; row++ {
+		line := dst[row*stride : row*stride+w*4]
+		py := y + row
+		if py < inside.Min.Y || py >= inside.Max.Y {
+			clearBytes(line)
+			continue
+		}
+		left := (inside.Min.X - x) * 4
+		right := (inside.Max.X - x) * 4
+		clearBytes(line[:left])
+		copy(line[left:right], src.Pix[src.PixOffset(inside.Min.X, py):])
+		clearBytes(line[right:])
+	}
+}
+
+func clearBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// GetImageDataInto copies the pixels directly from the image of the
+// backend
+func (b *SoftwareBackend) GetImageDataInto(dst []byte, x, y, w, h, stride int) {
+	copyImageData(dst, x, y, w, h, stride, b.Image)
+}
+
+// AsyncImageDataBackend is implemented by backends that can read back
+// the canvas content without waiting for the drawing to finish, like
+// GPU backends that copy into a staging buffer and map it a few frames
+// later. The channel receives the image once it is available and is
+// then closed
+type AsyncImageDataBackend interface {
+	GetImageDataAsync(x, y, w, h int) <-chan *image.RGBA
+}
+
+// GetImageDataAsync is like GetImageData, but returns a channel that
+// receives the image when it is read back, so that screenshots don't
+// stall the rendering of GPU backends. Drawing may continue before the
+// image is received, the image shows the content at the time of the
+// call. Backends that read back right away, like the software backend,
+// send the image before GetImageDataAsync returns
+func (cv *Canvas) GetImageDataAsync(x, y, w, h int) <-chan *image.RGBA {
+	cv.Flush()
+	return getImageDataAsync(cv.b, x, y, w, h)
+}
+
+func getImageDataAsync(b Backend, x, y, w, h int) <-chan *image.RGBA {
+	if ab, ok := b.(AsyncImageDataBackend); ok {
+		return ab.GetImageDataAsync(x, y, w, h)
+	}
+	return imageDataChan(b.GetImageData(x, y, w, h))
+}
+
+// imageDataChan returns a closed channel with the image in its buffer
+func imageDataChan(img *image.RGBA) <-chan *image.RGBA {
+	ch := make(chan *image.RGBA, 1)
+	ch <- img
+	close(ch)
+	return ch
+}
+
+// GetImageDataAsync reads the pixels right away, the channel already
+// holds the image when it is returned
+func (b *SoftwareBackend) GetImageDataAsync(x, y, w, h int) <-chan *image.RGBA {
+	return imageDataChan(b.GetImageData(x, y, w, h))
+}
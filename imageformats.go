@@ -0,0 +1,30 @@
+//go:build !canvas_noimageformats
+// +build !canvas_noimageformats
+
+package canvas
+
+import (
+	"image"
+	_ "image/gif" // register the decoders for the image loading functions
+	"image/jpeg"
+	"io"
+
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// encodeJPEG writes the image as a JPEG, quality zero is the default of
+// image/jpeg
+func encodeJPEG(w io.Writer, img image.Image, quality int) error {
+	var jo *jpeg.Options
+	if quality > 0 {
+		jo = &jpeg.Options{Quality: quality}
+	}
+	return jpeg.Encode(w, img, jo)
+}
+
+// scaleExport scales the image to the size of dst with a Catmull-Rom
+// filter
+func scaleExport(dst, src *image.NRGBA) {
+	xdraw.CatmullRom.Scale(dst, dst.Rect, src, src.Rect, xdraw.Src, nil)
+}
@@ -0,0 +1,45 @@
+//go:build !canvas_nohttp
+// +build !canvas_nohttp
+
+package canvas
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// maxImageDownload is the maximum size in bytes of an image file that
+// LoadImageHTTP downloads
+const maxImageDownload = 32 << 20
+
+// imageHTTPClient is used by LoadImageHTTP if no client is given
+var imageHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// LoadImageHTTP downloads the image at the given URL with the client, or
+// a client with a timeout of 30 seconds if it is nil, and loads it like
+// LoadImageBytes. Files larger than 32 MiB are rejected. It is left out
+// with the canvas_nohttp build tag
+func (cv *Canvas) LoadImageHTTP(url string, client *http.Client, quality ...MipmapQuality) (*Image, error) {
+	if client == nil {
+		client = imageHTTPClient
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error downloading image %s: %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxImageDownload+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImageDownload {
+		return nil, fmt.Errorf("Error downloading image %s: larger than the maximum of %d bytes", url, maxImageDownload)
+	}
+	return cv.LoadImageBytes(data, quality...)
+}
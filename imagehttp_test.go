@@ -0,0 +1,43 @@
+//go:build !canvas_nohttp
+// +build !canvas_nohttp
+
+package canvas_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/opentoys/canvas"
+)
+
+func TestLoadImageHTTP(t *testing.T) {
+	data := rotatedJPEG(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image.jpg":
+			w.Write(data)
+		case "/large.jpg":
+			w.Write(make([]byte, 33<<20))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cv := canvas.New(canvas.NewBackend(32, 16))
+	img, err := cv.LoadImageHTTP(srv.URL+"/image.jpg", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := img.Size(); w != 32 || h != 16 {
+		t.Fatalf("Expected a rotated 32x16 image, got %dx%d", w, h)
+	}
+	if _, err = cv.LoadImageHTTP(srv.URL+"/missing.jpg", srv.Client()); err == nil {
+		t.Fatal("Expected an error for a missing image")
+	}
+	if _, err = cv.LoadImageHTTP(srv.URL+"/large.jpg", nil); err == nil || !strings.Contains(err.Error(), "maximum") {
+		t.Fatalf("Expected an error for a too large image, got %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package canvas
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+)
+
+// LoadImageFile loads and caches the image file with the given path like
+// LoadImage. PNG, JPEG, GIF and WebP files can be loaded without
+// importing the decoders, and JPEG files are rotated according to their
+// EXIF orientation. With the canvas_noimageformats build tag only PNG
+// and the formats that the application registers are supported
+func (cv *Canvas) LoadImageFile(path string, quality ...MipmapQuality) (*Image, error) {
+	return cv.LoadImage(path, quality...)
+}
+
+// LoadImageBytes loads the image from the contents of an image file like
+// LoadImageFile. The image is not cached
+func (cv *Canvas) LoadImageBytes(data []byte, quality ...MipmapQuality) (*Image, error) {
+	return cv.LoadImage(data, quality...)
+}
+
+// decodeImage decodes the contents of an image file and applies the EXIF
+// orientation of JPEG files. The size in the header is checked against
+// SizeLimits first, so that small files can't allocate huge images
+func decodeImage(data []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkImageSize(cfg.Width, cfg.Height); err != nil {
+		return nil, err
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if format == "jpeg" {
+		img = orientImage(img, jpegOrientation(data))
+	}
+	return img, nil
+}
+
+// checkImageSize returns an error if a decoded image of the size would
+// exceed SizeLimits
+func checkImageSize(w, h int) error {
+	if max := SizeLimits.MaxDimension; max > 0 && (w > max || h > max) {
+		return fmt.Errorf("Image size %dx%d exceeds the maximum dimension of %d", w, h, max)
+	}
+	if max := SizeLimits.MaxBytes; max > 0 && w > 0 && h > max/4/w {
+		return fmt.Errorf("Image size %dx%d exceeds the memory limit of %d bytes", w, h, max)
+	}
+	return nil
+}
+
+// jpegOrientation returns the orientation tag of the EXIF data of a JPEG
+// file, or 1 if there is none
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		size := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if marker == 0xDA || size < 2 || pos+2+size > len(data) {
+			break
+		}
+		seg := data[pos+4 : pos+2+size]
+		if marker == 0xE1 && len(seg) > 6 && string(seg[:6]) == "Exif\x00\x00" {
+			return exifOrientation(seg[6:])
+		}
+		pos += 2 + size
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag from the first IFD of the
+// TIFF structure of EXIF data
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var bo binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(bo.Uint32(tiff[4:]))
+	if ifd < 0 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(bo.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if bo.Uint16(tiff[entry:]) == 0x0112 {
+			o := int(bo.Uint16(tiff[entry+8:]))
+			if o < 1 || o > 8 {
+				return 1
+			}
+			return o
+		}
+	}
+	return 1
+}
+
+// orientImage returns the image flipped and rotated so that it appears
+// upright for the given EXIF orientation
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	bounds := img.Bounds()
+	src, ok := img.(*image.RGBA)
+	if !ok || src.Rect.Min != (image.Point{}) {
+		src = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(src, src.Rect, img, bounds.Min, draw.Src)
+	}
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
@@ -1,12 +1,12 @@
 package canvas
 
 import (
-	"bytes"
-	"errors"
 	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
 	"io/ioutil"
-	"os"
+	"math"
 	"strings"
 	"time"
 )
@@ -19,29 +19,69 @@ type Image struct {
 	img      BackendImage
 	deleted  bool
 	lastUsed time.Time
+	mipmap   MipmapQuality
+
+	data      image.Image
+	alphaMask *image.Alpha
+
+	// evicted images have released their pixels and are decoded again
+	// from src when they are used, w and h keep their size
+	evicted bool
+	w, h    int
+
+	// shared counts the images that use the backend image, nil if the
+	// image is the only one
+	shared *imageShare
 }
 
 // LoadImage loads an image. The src parameter can be either an image from the
 // standard image package, a byte slice that will be loaded, or a file name
-// string. If you want the canvas package to load the image, make sure you
-// import the required format packages
-func (cv *Canvas) LoadImage(src interface{}) (*Image, error) {
+// string. PNG, JPEG, GIF and WebP files are supported, for other formats
+// import the required format packages. The optional mipmap quality selects
+// the filter for the scaled down versions of the image, MipmapBox is the
+// default. Loading a cached image with a different quality reloads it
+func (cv *Canvas) LoadImage(src interface{}, quality ...MipmapQuality) (*Image, error) {
+	mipmap := MipmapBox
+	if len(quality) > 0 {
+		mipmap = quality[0]
+	}
 	var reload *Image
 	if img, ok := src.(*Image); ok {
 		if img.cv != cv {
-			panic("image loaded with different canvas")
+			return nil, ErrImageOtherCanvas
 		}
 		if img.deleted {
 			reload = img
 			src = img.src
+		} else if img.evicted {
+			if len(quality) == 0 {
+				mipmap = img.mipmap
+			}
+			reload = img
+			src = img.src
+		} else if len(quality) > 0 && img.mipmap != mipmap {
+			img.releaseImage()
+			reload = img
+			src = img.src
 		} else {
 			img.lastUsed = time.Now()
+			cv.imageCache.stats.Hits++
 			return img, nil
 		}
 	} else if _, ok := src.([]byte); !ok {
 		if img, ok := cv.images[src]; ok {
-			img.lastUsed = time.Now()
-			return img, nil
+			if img.evicted {
+				if len(quality) == 0 {
+					mipmap = img.mipmap
+				}
+			} else if len(quality) == 0 || img.mipmap == mipmap {
+				img.lastUsed = time.Now()
+				cv.imageCache.stats.Hits++
+				return img, nil
+			} else {
+				img.releaseImage()
+			}
+			reload = img
 		}
 	}
 	cv.reduceCache(Performance.CacheSize, 0)
@@ -54,13 +94,13 @@ func (cv *Canvas) LoadImage(src interface{}) (*Image, error) {
 		if err != nil {
 			return nil, err
 		}
-		srcImg, _, err = image.Decode(bytes.NewReader(data))
+		srcImg, err = decodeImage(data)
 		if err != nil {
 			return nil, err
 		}
 	case []byte:
 		var err error
-		srcImg, _, err = image.Decode(bytes.NewReader(v))
+		srcImg, err = decodeImage(v)
 		if err != nil {
 			return nil, err
 		}
@@ -68,26 +108,30 @@ func (cv *Canvas) LoadImage(src interface{}) (*Image, error) {
 		w, h := cv.b.Size()
 		src = cv.GetImageData(0, 0, w, h)
 	default:
-		return nil, errors.New("Unsupported source type")
+		return nil, ErrUnsupportedSource
 	}
-	backendImg, err := cv.b.LoadImage(srcImg)
+	backendImg, shared, err := cv.loadSharedImage(srcImg, mipmap)
 	if err != nil {
 		return nil, err
 	}
-	cvimg := &Image{cv: cv, img: backendImg, lastUsed: time.Now(), src: src}
+	cv.imageCache.stats.Decodes++
+	cvimg := &Image{cv: cv, img: backendImg, shared: shared, lastUsed: time.Now(), mipmap: mipmap, src: src, data: srcImg}
 	if reload != nil {
 		*reload = *cvimg
-		return reload, nil
-	}
-	if _, ok := src.([]byte); !ok {
+		cvimg = reload
+	} else if _, ok := src.([]byte); !ok {
 		cv.images[src] = cvimg
 	}
+	cv.keepData(cvimg)
+	if cv.imageCache.budget > 0 {
+		cv.trimImages(cv.imageCache.budget, cvimg)
+	}
 	return cvimg, nil
 }
 
 func (cv *Canvas) getImage(src interface{}) *Image {
 	if cv2, ok := src.(*Canvas); ok {
-		if !cv.b.CanUseAsImage(cv2.b) {
+		if !cv2.caps.AsImage || !cv.b.CanUseAsImage(cv2.b) {
 			w, h := cv2.Size()
 			return cv.getImage(cv2.GetImageData(0, 0, w, h))
 		}
@@ -101,31 +145,41 @@ func (cv *Canvas) getImage(src interface{}) *Image {
 
 	img, err := cv.LoadImage(src)
 	if err != nil {
-		cv.images[src] = nil
 		switch v := src.(type) {
 		case image.Image:
-			fmt.Fprintf(os.Stderr, "Error loading image: %v\n", err)
+			cv.reportError(fmt.Errorf("Error loading image: %w", err))
 		case string:
 			if strings.Contains(strings.ToLower(err.Error()), "format") {
-				fmt.Fprintf(os.Stderr, "Error loading image %s: %v\nIt may be necessary to import the appropriate decoder, e.g.\nimport _ \"image/jpeg\"\n", v, err)
+				cv.reportError(fmt.Errorf("Error loading image %s: %w\nIt may be necessary to import the appropriate decoder, e.g.\nimport _ \"image/jpeg\"", v, err))
 			} else {
-				fmt.Fprintf(os.Stderr, "Error loading image %s: %v\n", v, err)
+				cv.reportError(fmt.Errorf("Error loading image %s: %w", v, err))
 			}
 		default:
-			fmt.Fprintf(os.Stderr, "Failed to load image: %v\n", err)
+			cv.reportError(fmt.Errorf("Failed to load image: %w", err))
 		}
 	}
 	return img
 }
 
 // Width returns the width of the image
-func (img *Image) Width() int { return img.img.Width() }
+func (img *Image) Width() int {
+	w, _ := img.Size()
+	return w
+}
 
 // Height returns the height of the image
-func (img *Image) Height() int { return img.img.Height() }
+func (img *Image) Height() int {
+	_, h := img.Size()
+	return h
+}
 
 // Size returns the width and height of the image
-func (img *Image) Size() (int, int) { return img.img.Size() }
+func (img *Image) Size() (int, int) {
+	if img.img == nil {
+		return img.w, img.h
+	}
+	return img.img.Size()
+}
 
 // Delete deletes the image from memory
 func (img *Image) Delete() {
@@ -133,26 +187,85 @@ func (img *Image) Delete() {
 		return
 	}
 	img.deleted = true
-	img.img.Delete()
-	delete(img.cv.images, img.src)
+	if img.img != nil {
+		img.releaseImage()
+	}
+	delete(img.cv.images, img.cacheKey())
+}
+
+// cacheKey returns the key of the image in the image cache of the canvas
+func (img *Image) cacheKey() interface{} {
+	if _, ok := img.src.([]byte); ok {
+		return img
+	}
+	return img.src
 }
 
 // Replace replaces the image with the new one
 func (img *Image) Replace(src interface{}) error {
+	if img.deleted {
+		return ErrImageDeleted
+	}
+	if img.evicted {
+		if _, err := img.cv.LoadImage(img); err != nil {
+			return err
+		}
+	}
+	img.alphaMask = nil
 	if img.src == src {
 		if origImg, ok := img.src.(image.Image); ok {
-			img.img.Replace(origImg)
+			if img.ownImage() {
+				img.img.Replace(origImg)
+			} else {
+				// the pixels were shared with other images
+				bimg, err := loadImageMipmap(img.cv.b, origImg, img.mipmap)
+				if err != nil {
+					return err
+				}
+				img.img = bimg
+			}
+			img.data = origImg
 			return nil
 		}
 	}
-	newImg, err := img.cv.LoadImage(src)
+	newImg, err := img.cv.LoadImage(src, img.mipmap)
 	if err != nil {
 		return err
 	}
-	img.img = newImg.img
+	if newImg == img {
+		return nil
+	}
+	img.releaseImage()
+	img.img, img.shared = newImg.img, newImg.shared
+	if img.shared != nil {
+		img.shared.refs++
+	}
+	img.data = newImg.data
 	return nil
 }
 
+// shadowMask returns the alpha channel of the given part of the image
+// to be used as the silhouette of the image for shadows. If no shadow
+// is drawn or the image data is not available, nil is returned
+func (img *Image) shadowMask(sx, sy, sw, sh float64) *image.Alpha {
+	if img.data == nil || img.cv.state.shadowColor.A == 0 {
+		return nil
+	}
+	if img.alphaMask == nil {
+		bounds := img.data.Bounds()
+		img.alphaMask = image.NewAlpha(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(img.alphaMask, img.alphaMask.Rect, img.data, bounds.Min, draw.Src)
+	}
+	rect := image.Rect(
+		int(math.Floor(sx)), int(math.Floor(sy)),
+		int(math.Ceil(sx+sw)), int(math.Ceil(sy+sh)))
+	mask := img.alphaMask.SubImage(rect).(*image.Alpha)
+	if mask.Rect != rect {
+		return nil
+	}
+	return mask
+}
+
 // DrawImage draws the given image to the given coordinates. The image
 // parameter can be an Image loaded by LoadImage, a file name string that will
 // be loaded and cached, or a name string that corresponds to a previously
@@ -167,6 +280,32 @@ func (img *Image) Replace(src interface{}) error {
 // Where dx/dy/dw/dh are the destination coordinates and sx/sy/sw/sh are the
 // source coordinates
 func (cv *Canvas) DrawImage(image interface{}, coords ...float64) {
+	cv.drawImage(image, imageColor{}, coords)
+}
+
+// DrawImageTinted draws the image like DrawImage, but multiplies the
+// color of every pixel with the tint color, which is commonly used to
+// colorize sprites. The coordinates are the same as for DrawImage
+func (cv *Canvas) DrawImageTinted(image interface{}, tint color.RGBA, coords ...float64) {
+	cv.drawImage(image, imageColor{tint: tint, tinted: true}, coords)
+}
+
+// DrawImageColorMatrix draws the image like DrawImage, with the color of
+// every pixel transformed by the color matrix, for example to recolor,
+// desaturate or fade sprites without keeping modified copies of them.
+// The coordinates are the same as for DrawImage
+func (cv *Canvas) DrawImageColorMatrix(image interface{}, m ColorMatrix, coords ...float64) {
+	cv.drawImage(image, imageColor{matrix: &m}, coords)
+}
+
+// imageColor is the color change of an image draw
+type imageColor struct {
+	tint   color.RGBA
+	tinted bool
+	matrix *ColorMatrix
+}
+
+func (cv *Canvas) drawImage(image interface{}, ic imageColor, coords []float64) {
 	img := cv.getImage(image)
 	if img == nil {
 		return
@@ -185,6 +324,9 @@ func (cv *Canvas) DrawImage(image interface{}, coords ...float64) {
 		sw, sh = coords[2], coords[3]
 		dx, dy = coords[4], coords[5]
 		dw, dh = coords[6], coords[7]
+	} else {
+		cv.reportError(fmt.Errorf("DrawImage with %d coordinates: %w", len(coords), ErrInvalidArguments))
+		return
 	}
 
 	var data [4]BackendVec
@@ -193,18 +335,113 @@ func (cv *Canvas) DrawImage(image interface{}, coords ...float64) {
 	data[2] = cv.tf(BackendVec{dx + dw, dy + dh})
 	data[3] = cv.tf(BackendVec{dx + dw, dy})
 
-	cv.drawShadow(data[:], nil, false)
+	cv.drawImageQuad(img, sx, sy, sw, sh, data, cv.state.globalAlpha, ic)
+}
+
+// DrawImageTransformed draws the sw by sh pixel region of the image at
+// sx/sy with its top left corner at 0/0, transformed first by mat and
+// then by the current transformation, with alpha multiplied with the
+// global alpha. Unlike calling Translate, Rotate and Scale around
+// DrawImage, this does not change the state of the canvas, which makes
+// it cheap to draw many sprites with their own transformation
+func (cv *Canvas) DrawImageTransformed(image interface{}, sx, sy, sw, sh float64, mat BackendMat, alpha float64) {
+	img := cv.getImage(image)
+	if img == nil {
+		return
+	}
+
+	var data [4]BackendVec
+	data[0] = cv.tf(BackendVec{0, 0}.MulMat(mat))
+	data[1] = cv.tf(BackendVec{0, sh}.MulMat(mat))
+	data[2] = cv.tf(BackendVec{sw, sh}.MulMat(mat))
+	data[3] = cv.tf(BackendVec{sw, 0}.MulMat(mat))
+
+	cv.drawImageQuad(img, sx, sy, sw, sh, data, cv.state.globalAlpha*alpha, imageColor{})
+}
+
+func (cv *Canvas) drawImageQuad(img *Image, sx, sy, sw, sh float64, data [4]BackendVec, alpha float64, ic imageColor) {
+	mask := img.shadowMask(sx, sy, sw, sh)
+
+	cv.drawShadow(data[:], mask, false)
+
+	cv.Flush()
+	r := cv.traceRegion("canvas.DrawImage")
+	cv.countQuad(data)
+	if ic.matrix != nil {
+		cv.drawColorMatrix(img, sx, sy, sw, sh, data, alpha, ic.matrix)
+	} else if ic.tinted {
+		cv.drawTinted(img, sx, sy, sw, sh, data, alpha, ic.tint)
+	} else {
+		bimg, bsx, bsy := subImageSource(img.img, sx, sy)
+		cv.b.DrawImage(bimg, bsx, bsy, sw, sh, data, alpha)
+	}
+	r.End()
+
+	cv.drawInsetShadow(data[:], mask)
+}
+
+// TintedImageBackend is implemented by backends that can multiply the
+// pixels of an image with a tint color while drawing it. For other
+// backends a tinted copy of the image is drawn instead
+type TintedImageBackend interface {
+	DrawImageTinted(dimg BackendImage, sx, sy, sw, sh float64, pts [4]BackendVec, alpha float64, tint color.RGBA)
+}
+
+func (cv *Canvas) drawTinted(img *Image, sx, sy, sw, sh float64, pts [4]BackendVec, alpha float64, tint color.RGBA) {
+	if tb, ok := cv.b.(TintedImageBackend); ok {
+		bimg, bsx, bsy := subImageSource(img.img, sx, sy)
+		tb.DrawImageTinted(bimg, bsx, bsy, sw, sh, pts, alpha, tint)
+		return
+	}
+	if img.data == nil {
+		cv.reportError(fmt.Errorf("Tinting a canvas image on a backend without tint support: %w", ErrUnsupportedSource))
+		return
+	}
+	tinted, err := cv.b.LoadImage(tintImage(img.data, tint))
+	if err != nil {
+		cv.reportError(fmt.Errorf("Error loading tinted image: %w", err))
+		return
+	}
+	defer tinted.Delete()
+	cv.b.DrawImage(tinted, sx, sy, sw, sh, pts, alpha)
+}
+
+// tintImage returns a copy of the image with every pixel multiplied
+// by the tint color
+func tintImage(src image.Image, tint color.RGBA) *image.RGBA {
+	bounds := src.Bounds()
+	result := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			result.SetRGBA(x, y, tintColor(rgbaAt(src, bounds.Min.X+x, bounds.Min.Y+y), tint))
+		}
+	}
+	return result
+}
 
-	cv.b.DrawImage(img.img, sx, sy, sw, sh, data, cv.state.globalAlpha)
+// tintColor multiplies each channel of the color with the tint, which
+// leaves the color unchanged for a white tint
+func tintColor(c, tint color.RGBA) color.RGBA {
+	return color.RGBA{
+		R: uint8((uint32(c.R)*uint32(tint.R) + 127) / 255),
+		G: uint8((uint32(c.G)*uint32(tint.G) + 127) / 255),
+		B: uint8((uint32(c.B)*uint32(tint.B) + 127) / 255),
+		A: uint8((uint32(c.A)*uint32(tint.A) + 127) / 255),
+	}
 }
 
-// GetImageData returns an RGBA image of the current image
+// GetImageData returns an RGBA image of the given part of the canvas.
+// The image is a copy that doesn't change when the canvas is drawn to,
+// use GetImageDataInto to reuse a buffer instead
 func (cv *Canvas) GetImageData(x, y, w, h int) *image.RGBA {
+	cv.Flush()
 	return cv.b.GetImageData(x, y, w, h)
 }
 
 // PutImageData puts the given image at the given x/y coordinates
 func (cv *Canvas) PutImageData(img *image.RGBA, x, y int) {
+	cv.Flush()
+	cv.stats.changes++
 	cv.b.PutImageData(img, x, y)
 }
 
@@ -216,6 +453,16 @@ type ImagePattern struct {
 	tf  BackendMat
 	rep imagePatternRepeat
 	ip  BackendImagePattern
+	// area is the area of RepeatRound and RepeatSpace, the canvas if
+	// the size is zero
+	area [4]float64
+
+	// live patterns copy their source into buf when it changes
+	live patternSource
+	buf  *image.RGBA
+
+	// gen is the context generation that ip was loaded in
+	gen int
 }
 
 type imagePatternRepeat uint8
@@ -226,9 +473,16 @@ const (
 	RepeatX                     = imagePatternRepeat(BackendRepeatX)
 	RepeatY                     = imagePatternRepeat(BackendRepeatY)
 	NoRepeat                    = imagePatternRepeat(BackendNoRepeat)
+	// RepeatRound and RepeatSpace work like the round and space values
+	// of the CSS background-repeat property, see SetRepeatArea
+	RepeatRound = imagePatternRepeat(BackendRepeatRound)
+	RepeatSpace = imagePatternRepeat(BackendRepeatSpace)
 )
 
 func (ip *ImagePattern) data(tf BackendMat) BackendImagePatternData {
+	if ip.img.evicted {
+		ip.cv.LoadImage(ip.img)
+	}
 	m := tf.Invert().Mul(ip.tf.Invert())
 	return BackendImagePatternData{
 		Image: ip.img.img,
@@ -238,7 +492,28 @@ func (ip *ImagePattern) data(tf BackendMat) BackendImagePatternData {
 			0, 0, 1,
 		},
 		Repeat: BackendImagePatternRepeat(ip.rep),
+		Area:   ip.repeatArea(),
+	}
+}
+
+// repeatArea returns the area of RepeatRound and RepeatSpace
+func (ip *ImagePattern) repeatArea() [4]float64 {
+	if ip.area[2] > 0 && ip.area[3] > 0 {
+		return ip.area
 	}
+	w, h := ip.cv.Size()
+	return [4]float64{0, 0, float64(w), float64(h)}
+}
+
+// SetRepeatArea sets the area that RepeatRound and RepeatSpace fit the
+// tiles into, in the coordinates of the pattern before its
+// transformation. RepeatRound scales the tiles so that a whole number
+// of them fits into the area, RepeatSpace repeats as many whole tiles as
+// fit and distributes the remaining space between them. Outside of the
+// area the tiles continue the same way. The default is the size of the
+// canvas
+func (ip *ImagePattern) SetRepeatArea(x, y, w, h float64) {
+	ip.area = [4]float64{x, y, w, h}
 }
 
 // SetTransform changes the transformation of the image pattern
@@ -249,16 +524,30 @@ func (ip *ImagePattern) SetTransform(tf [6]float64) {
 }
 
 // CreatePattern creates a new image pattern with the specified
-// image and repetition
+// image and repetition. Like in HTML the source can also be another
+// canvas, in which case the pattern shows what is drawn on it, or a
+// FrameSource that the pattern shows the current frame of. A Backend
+// can be used as well, but since backends don't track changes, it is
+// copied every time the pattern is used
 func (cv *Canvas) CreatePattern(src interface{}, repeat imagePatternRepeat) *ImagePattern {
+	if ip := cv.createLivePattern(src, repeat); ip != nil {
+		return ip
+	}
 	ip := &ImagePattern{
 		cv:  cv,
 		img: cv.getImage(src),
 		rep: repeat,
 		tf:  BackendMat{1, 0, 0, 1, 0, 0},
+		gen: cv.context.gen,
+	}
+	if ip.img != nil && ip.img.data != nil {
+		// sub-images share their texture, so patterns repeat a copy
+		if _, ok := ip.img.img.(*BackendSubImage); ok {
+			ip.img = cv.getImage(ip.img.data)
+		}
 	}
 	if ip.img != nil {
-		ip.ip = cv.b.LoadImagePattern(ip.data(cv.state.transform))
+		ip.ip = cv.b.LoadImagePattern(ip.data(cv.transform()))
 	}
 	return ip
 }
@@ -0,0 +1,145 @@
+package canvas
+
+import (
+	"bytes"
+	"hash/fnv"
+	"image"
+	"image/draw"
+)
+
+// imageShare is a backend image that is used by all images that were
+// loaded with the same pixels, so that assets that are loaded again and
+// again only take memory once
+type imageShare struct {
+	img  BackendImage
+	key  imageContentKey
+	data image.Image
+	refs int
+}
+
+type imageContentKey struct {
+	hash   uint64
+	w, h   int
+	format uint8
+	mipmap MipmapQuality
+}
+
+// pixel formats of imageContentKey, images of other types are converted
+// to NRGBA
+const (
+	pixelsNRGBA uint8 = iota
+	pixelsRGBA
+	pixelsGray
+	pixelsAlpha
+)
+
+// loadSharedImage returns the backend image of an image that was loaded
+// before with the same pixels and mipmap filter, or loads it
+func (cv *Canvas) loadSharedImage(src image.Image, mipmap MipmapQuality) (BackendImage, *imageShare, error) {
+	key := imageKey(src, mipmap)
+	if s, ok := cv.sharedImages[key]; ok && s.data != nil && samePixels(s.data, src) {
+		s.refs++
+		cv.imageCache.stats.Shared++
+		return s.img, s, nil
+	}
+	bimg, err := loadImageMipmap(cv.b, src, mipmap)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cv.sharedImages == nil {
+		cv.sharedImages = make(map[imageContentKey]*imageShare)
+	}
+	s := &imageShare{img: bimg, key: key, data: src, refs: 1}
+	if _, ok := cv.sharedImages[key]; !ok {
+		cv.sharedImages[key] = s
+	}
+	return bimg, s, nil
+}
+
+// releaseImage releases the backend image of the image and deletes it
+// if no other image uses it
+func (img *Image) releaseImage() {
+	s := img.shared
+	img.shared = nil
+	if s == nil {
+		img.img.Delete()
+		return
+	}
+	s.refs--
+	if s.refs > 0 {
+		return
+	}
+	if img.cv.sharedImages[s.key] == s {
+		delete(img.cv.sharedImages, s.key)
+	}
+	s.img.Delete()
+}
+
+// ownImage prepares the backend image of the image for changing its
+// pixels. It returns false if other images use the same backend image,
+// in which case the image has released it and needs a new one
+func (img *Image) ownImage() bool {
+	s := img.shared
+	if s == nil {
+		return true
+	}
+	if s.refs > 1 {
+		img.releaseImage()
+		return false
+	}
+	img.shared = nil
+	if img.cv.sharedImages[s.key] == s {
+		delete(img.cv.sharedImages, s.key)
+	}
+	return true
+}
+
+// imageKey returns the key of the pixels of the image
+func imageKey(img image.Image, mipmap MipmapQuality) imageContentKey {
+	pix, stride, rowLen, format := pixelRows(img)
+	b := img.Bounds()
+	h := fnv.New64a()
+	for y := 0; y < b.Dy(); y++ {
+		h.Write(pix[y*stride : y*stride+rowLen])
+	}
+	return imageContentKey{hash: h.Sum64(), w: b.Dx(), h: b.Dy(), format: format, mipmap: mipmap}
+}
+
+// samePixels returns whether both images have the same pixels in the
+// same format
+func samePixels(a, b image.Image) bool {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return false
+	}
+	pa, sa, na, fa := pixelRows(a)
+	pb, sb, nb, fb := pixelRows(b)
+	if fa != fb || na != nb {
+		return false
+	}
+	for y := 0; y < a.Bounds().Dy(); y++ {
+		if !bytes.Equal(pa[y*sa:y*sa+na], pb[y*sb:y*sb+nb]) {
+			return false
+		}
+	}
+	return true
+}
+
+// pixelRows returns the pixels of the image starting at its top left
+// corner, the distance between the rows and the length of a row in
+// bytes, and the pixel format
+func pixelRows(img image.Image) ([]byte, int, int, uint8) {
+	b := img.Bounds()
+	switch v := img.(type) {
+	case *image.NRGBA:
+		return v.Pix[v.PixOffset(b.Min.X, b.Min.Y):], v.Stride, b.Dx() * 4, pixelsNRGBA
+	case *image.RGBA:
+		return v.Pix[v.PixOffset(b.Min.X, b.Min.Y):], v.Stride, b.Dx() * 4, pixelsRGBA
+	case *image.Gray:
+		return v.Pix[v.PixOffset(b.Min.X, b.Min.Y):], v.Stride, b.Dx(), pixelsGray
+	case *image.Alpha:
+		return v.Pix[v.PixOffset(b.Min.X, b.Min.Y):], v.Stride, b.Dx(), pixelsAlpha
+	}
+	conv := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(conv, conv.Rect, img, b.Min, draw.Src)
+	return conv.Pix, conv.Stride, b.Dx() * 4, pixelsNRGBA
+}
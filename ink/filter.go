@@ -0,0 +1,63 @@
+package ink
+
+import (
+	"math"
+	"time"
+)
+
+// OneEuroFilter is a one euro filter, a low pass filter for noisy input
+// that adapts its cutoff frequency to the speed of the signal. Slow
+// movements are smoothed strongly to remove jitter, fast movements only
+// slightly to keep the lag low
+type OneEuroFilter struct {
+	// MinCutoff is the cutoff frequency in Hz at zero speed. Lower
+	// values remove more jitter
+	MinCutoff float64
+	// Beta is the increase of the cutoff frequency with the speed.
+	// Higher values reduce the lag of fast movements
+	Beta float64
+	// DCutoff is the cutoff frequency in Hz for the speed estimation
+	DCutoff float64
+
+	started bool
+	last    time.Duration
+	x, dx   float64
+}
+
+// NewOneEuroFilter returns a one euro filter with the given parameters
+func NewOneEuroFilter(minCutoff, beta float64) *OneEuroFilter {
+	return &OneEuroFilter{MinCutoff: minCutoff, Beta: beta, DCutoff: 1}
+}
+
+// Reset resets the filter so that the next value is passed through
+// unchanged
+func (f *OneEuroFilter) Reset() {
+	f.started = false
+}
+
+// Filter filters the value at the given time and returns the smoothed
+// value. The times must be increasing
+func (f *OneEuroFilter) Filter(x float64, t time.Duration) float64 {
+	if !f.started {
+		f.started = true
+		f.last = t
+		f.x, f.dx = x, 0
+		return x
+	}
+	dt := (t - f.last).Seconds()
+	if dt <= 0 {
+		return f.x
+	}
+	f.last = t
+
+	dx := (x - f.x) / dt
+	f.dx += smoothingFactor(f.DCutoff, dt) * (dx - f.dx)
+	cutoff := f.MinCutoff + f.Beta*math.Abs(f.dx)
+	f.x += smoothingFactor(cutoff, dt) * (x - f.x)
+	return f.x
+}
+
+func smoothingFactor(cutoff, dt float64) float64 {
+	tau := 1 / (2 * math.Pi * cutoff)
+	return 1 / (1 + tau/dt)
+}
@@ -0,0 +1,107 @@
+package ink_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/opentoys/canvas"
+	"github.com/opentoys/canvas/ink"
+	"github.com/opentoys/canvas/testutil"
+)
+
+func wave(n int) []ink.Sample {
+	samples := make([]ink.Sample, n)
+	for i := range samples {
+		x := 10 + float64(i)*4
+		samples[i] = ink.Sample{
+			X:        x,
+			Y:        50 + math.Sin(x/15)*20,
+			Pressure: float64(i) / float64(n-1),
+			Time:     time.Duration(i) * 8 * time.Millisecond,
+		}
+	}
+	return samples
+}
+
+func TestIncremental(t *testing.T) {
+	samples := wave(40)
+
+	full := testutil.Render(180, 100, func(cv *canvas.Canvas) {
+		stroke := ink.NewStroke(ink.DefaultOptions)
+		stroke.Add(samples...)
+		stroke.Finish()
+		stroke.Draw(cv)
+	})
+	incremental := testutil.Render(180, 100, func(cv *canvas.Canvas) {
+		stroke := ink.NewStroke(ink.DefaultOptions)
+		for _, sample := range samples {
+			stroke.Add(sample)
+			stroke.DrawIncremental(cv)
+		}
+		stroke.Finish()
+		stroke.DrawIncremental(cv)
+	})
+
+	result, err := testutil.Compare(incremental, full, testutil.Options{Tolerance: 80, MaxDiffPixels: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Match {
+		t.Fatalf("incremental drawing differs in %d pixels", result.DiffPixels)
+	}
+
+	var covered int
+	for i := 3; i < len(full.Pix); i += 4 {
+		if full.Pix[i] > 0 {
+			covered++
+		}
+	}
+	if covered < 300 {
+		t.Fatalf("stroke covers only %d pixels", covered)
+	}
+}
+
+func TestPressureWidth(t *testing.T) {
+	opts := ink.DefaultOptions
+	opts.Thinning = 0
+	opts.MinCutoff = 0
+	img := testutil.Render(100, 40, func(cv *canvas.Canvas) {
+		stroke := ink.NewStroke(opts)
+		for i := 0; i <= 8; i++ {
+			stroke.Add(ink.Sample{X: 10 + float64(i)*10, Y: 20, Pressure: float64(i) / 8, Time: time.Duration(i) * 10 * time.Millisecond})
+		}
+		stroke.Finish()
+		stroke.Draw(cv)
+	})
+	height := func(x int) int {
+		var h int
+		for y := 0; y < 40; y++ {
+			if img.RGBAAt(x, y).A > 128 {
+				h++
+			}
+		}
+		return h
+	}
+	if thin, thick := height(15), height(85); thin >= thick {
+		t.Fatalf("expected the stroke to get wider with pressure, got %d and %d", thin, thick)
+	}
+}
+
+func TestOneEuroFilter(t *testing.T) {
+	f := ink.NewOneEuroFilter(1, 0)
+	var maxDev float64
+	for i := 0; i < 100; i++ {
+		noise := 1.0
+		if i%2 == 0 {
+			noise = -1
+		}
+		v := f.Filter(100+noise, time.Duration(i)*10*time.Millisecond)
+		if i > 10 {
+			maxDev = math.Max(maxDev, math.Abs(v-100))
+		}
+	}
+	if maxDev > 0.5 {
+		t.Fatalf("filter did not remove the jitter, deviation %g", maxDev)
+	}
+}
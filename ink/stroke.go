@@ -0,0 +1,268 @@
+// Package ink turns pen, touch and mouse input into smooth strokes of
+// variable width, as used by note taking and whiteboard applications.
+//
+// The input samples are smoothed with a one euro filter, interpolated
+// with Catmull-Rom splines and the width of the stroke follows the
+// pressure and speed of the pen. Strokes can be drawn incrementally while
+// the input arrives, so that each frame only draws the new part
+package ink
+
+import (
+	"math"
+	"time"
+
+	"github.com/opentoys/canvas"
+)
+
+// Sample is a single input sample
+type Sample struct {
+	X, Y float64
+	// Pressure is the pen pressure between 0 and 1. Devices without
+	// pressure information should use 0.5
+	Pressure float64
+	// Time is the time of the sample, relative to any fixed point
+	Time time.Duration
+}
+
+// Options control the shape of a stroke
+type Options struct {
+	// Width is the width of the stroke at full pressure
+	Width float64
+	// MinWidth is the width of the stroke at zero pressure
+	MinWidth float64
+	// Thinning is how much faster pen movements make the stroke
+	// thinner, between 0 (not at all) and 1
+	Thinning float64
+	// MaxSpeed is the speed in pixels per second at which the
+	// thinning has its full effect
+	MaxSpeed float64
+	// MinCutoff and Beta are the parameters of the one euro filter
+	// that smooths the input positions. A MinCutoff of 0 disables
+	// the filter
+	MinCutoff, Beta float64
+}
+
+// DefaultOptions are suitable options for handwriting
+var DefaultOptions = Options{
+	Width:     6,
+	MinWidth:  1,
+	Thinning:  0.5,
+	MaxSpeed:  3000,
+	MinCutoff: 2,
+	Beta:      0.02,
+}
+
+type point struct {
+	x, y, r float64
+}
+
+// Stroke is a single stroke that is built from input samples
+type Stroke struct {
+	opts     Options
+	fx, fy   OneEuroFilter
+	pts      []point
+	last     Sample
+	finished bool
+	drawn    int
+}
+
+// NewStroke creates an empty stroke with the given options
+func NewStroke(opts Options) *Stroke {
+	return &Stroke{
+		opts: opts,
+		fx:   OneEuroFilter{MinCutoff: opts.MinCutoff, Beta: opts.Beta, DCutoff: 1},
+		fy:   OneEuroFilter{MinCutoff: opts.MinCutoff, Beta: opts.Beta, DCutoff: 1},
+	}
+}
+
+// Add adds input samples to the stroke. Samples must be added in the
+// order of their time
+func (s *Stroke) Add(samples ...Sample) {
+	for _, sample := range samples {
+		s.add(sample)
+	}
+}
+
+func (s *Stroke) add(sample Sample) {
+	if s.finished {
+		return
+	}
+	x, y := sample.X, sample.Y
+	if s.opts.MinCutoff > 0 {
+		x = s.fx.Filter(x, sample.Time)
+		y = s.fy.Filter(y, sample.Time)
+	}
+
+	pressure := math.Max(0, math.Min(1, sample.Pressure))
+	r := (s.opts.MinWidth + (s.opts.Width-s.opts.MinWidth)*pressure) * 0.5
+	if len(s.pts) == 0 {
+		s.pts = append(s.pts, point{x: x, y: y, r: r})
+		s.last = sample
+		return
+	}
+
+	prev := s.pts[len(s.pts)-1]
+	dist := math.Hypot(x-prev.x, y-prev.y)
+	if dist < 0.5 {
+		return
+	}
+	if dt := (sample.Time - s.last.Time).Seconds(); dt > 0 && s.opts.MaxSpeed > 0 {
+		speed := math.Min(1, dist/dt/s.opts.MaxSpeed)
+		r *= 1 - s.opts.Thinning*speed
+	}
+	// avoid sudden jumps in the width
+	r = prev.r + (r-prev.r)*0.5
+	s.pts = append(s.pts, point{x: x, y: y, r: r})
+	s.last = sample
+}
+
+// Finish marks the end of the stroke, so that the last segment can be
+// drawn. Samples that are added afterwards are ignored
+func (s *Stroke) Finish() {
+	s.finished = true
+}
+
+// Finished returns true if Finish was called
+func (s *Stroke) Finished() bool { return s.finished }
+
+// Len returns the number of points of the stroke after filtering
+func (s *Stroke) Len() int { return len(s.pts) }
+
+// Bounds returns the area that the stroke covers
+func (s *Stroke) Bounds() canvas.Bounds {
+	b := canvas.EmptyBounds
+	for _, pt := range s.pts {
+		b = b.Union(canvas.Bounds{MinX: pt.x - pt.r, MinY: pt.y - pt.r, MaxX: pt.x + pt.r, MaxY: pt.y + pt.r})
+	}
+	return b
+}
+
+// segments returns the number of segments that can be drawn. The
+// curve of a segment depends on the point after it, so the last segment
+// can only be drawn once the next point is known or the stroke is
+// finished
+func (s *Stroke) segments() int {
+	if len(s.pts) < 2 {
+		return 0
+	}
+	if s.finished {
+		return len(s.pts) - 1
+	}
+	return len(s.pts) - 2
+}
+
+// Draw draws the whole stroke with the current fill style of the canvas
+func (s *Stroke) Draw(cv *canvas.Canvas) {
+	s.draw(cv, 0, s.segments())
+	s.drawn = s.segments()
+}
+
+// DrawIncremental draws the part of the stroke that was not drawn yet
+// by Draw or DrawIncremental. Calling it every frame while samples are
+// added draws the stroke without filling all of it again.
+//
+// The parts are drawn with separate fills that overlap where they meet,
+// so a translucent fill style is blended twice at the joints. Translucent
+// strokes should be drawn with an opaque style onto a separate layer that
+// is then drawn with the desired alpha
+func (s *Stroke) DrawIncremental(cv *canvas.Canvas) {
+	segments := s.segments()
+	if s.drawn == 0 && segments == 0 && s.finished && len(s.pts) == 1 {
+		s.draw(cv, 0, 0)
+		s.drawn = 1
+		return
+	}
+	if segments <= s.drawn {
+		return
+	}
+	s.draw(cv, s.drawn, segments)
+	s.drawn = segments
+}
+
+func (s *Stroke) draw(cv *canvas.Canvas, from, to int) {
+	if len(s.pts) == 0 {
+		return
+	}
+	path := cv.NewPath2D()
+	if len(s.pts) == 1 {
+		pt := s.pts[0]
+		circle(path, pt.x, pt.y, pt.r)
+		cv.FillPath(path)
+		return
+	}
+	if from >= to {
+		return
+	}
+
+	n := len(s.pts)
+	for i := from; i < to; i++ {
+		p0 := s.pts[maxInt(i-1, 0)]
+		p1 := s.pts[i]
+		p2 := s.pts[i+1]
+		p3 := s.pts[minInt(i+2, n-1)]
+
+		steps := int(math.Ceil(math.Hypot(p2.x-p1.x, p2.y-p1.y) / 2))
+		steps = maxInt(1, minInt(steps, 16))
+		prev := p1
+		for j := 1; j <= steps; j++ {
+			t := float64(j) / float64(steps)
+			next := point{
+				x: catmullRom(p0.x, p1.x, p2.x, p3.x, t),
+				y: catmullRom(p0.y, p1.y, p2.y, p3.y, t),
+				r: p1.r + (p2.r-p1.r)*t,
+			}
+			capsule(path, prev, next)
+			prev = next
+		}
+	}
+	cv.FillPath(path)
+}
+
+func catmullRom(p0, p1, p2, p3, t float64) float64 {
+	t2 := t * t
+	t3 := t2 * t
+	return 0.5 * (2*p1 + (p2-p0)*t + (2*p0-5*p1+4*p2-p3)*t2 + (3*p1-p0-3*p2+p3)*t3)
+}
+
+// capsule adds the convex shape that covers the circles around a and b
+// as a sub path
+func capsule(path *canvas.Path2D, a, b point) {
+	dx, dy := b.x-a.x, b.y-a.y
+	l := math.Hypot(dx, dy)
+	if l <= math.Abs(a.r-b.r) {
+		if a.r > b.r {
+			circle(path, a.x, a.y, a.r)
+		} else {
+			circle(path, b.x, b.y, b.r)
+		}
+		return
+	}
+	nx, ny := -dy/l, dx/l
+	angle := math.Atan2(dy, dx)
+	path.MoveTo(a.x+nx*a.r, a.y+ny*a.r)
+	path.LineTo(b.x+nx*b.r, b.y+ny*b.r)
+	path.Arc(b.x, b.y, b.r, angle+math.Pi/2, angle-math.Pi/2, true)
+	path.LineTo(a.x-nx*a.r, a.y-ny*a.r)
+	path.Arc(a.x, a.y, a.r, angle-math.Pi/2, angle-math.Pi*1.5, true)
+	path.ClosePath()
+}
+
+func circle(path *canvas.Path2D, x, y, r float64) {
+	path.MoveTo(x+r, y)
+	path.Arc(x, y, r, 0, math.Pi*2, false)
+	path.ClosePath()
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
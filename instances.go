@@ -0,0 +1,82 @@
+package canvas
+
+// InstanceBackend is implemented by backends that can fill the same
+// triangles at many transformations in one call, for example with
+// instanced drawing. For other backends each instance is filled with
+// Fill
+type InstanceBackend interface {
+	FillInstances(style *BackendFillStyle, tris []BackendVec, transforms []BackendMat)
+}
+
+// DrawInstances fills the path at each of the transformations, which
+// map the path to the current coordinates like the matrix of
+// DrawImageTransformed. The path is only triangulated once, which is
+// much faster than filling it for every instance. The style is given
+// like for SetFillStyle, without it the current fill style is used.
+// Gradients and patterns are not transformed with the instances, and
+// shadows are not drawn. A nil or empty path draws nothing
+func (cv *Canvas) DrawInstances(path *Path2D, transforms []BackendMat, style ...interface{}) {
+	if path == nil || len(path.p) < 3 || len(transforms) == 0 {
+		return
+	}
+
+	var tris []BackendVec
+	if path.standalone && path.fillCache != nil {
+		tris = path.fillCache
+	} else {
+		if path.standalone {
+			tris = make([]BackendVec, 0, 500)
+		} else {
+			scratch := getVecScratch(0)
+			tris = scratch.buf
+			defer func() { scratch.release(tris) }()
+		}
+		runSubPaths(path.p, true, func(sp []pathPoint) bool {
+			tris = appendSubPathTriangles(tris, BackendMatIdentity, sp)
+			return false
+		})
+		if path.standalone {
+			path.fillCache = tris
+		}
+	}
+	if len(tris) == 0 {
+		return
+	}
+
+	s := &cv.state.fill
+	if len(style) > 0 {
+		ds := cv.parseStyle(style...)
+		s = &ds
+	}
+	stl := cv.backendFillStyle(s, 1)
+
+	tf := cv.transform()
+	cv.instanceBuf = cv.instanceBuf[:0]
+	for _, m := range transforms {
+		cv.instanceBuf = append(cv.instanceBuf, m.Mul(tf))
+	}
+	mats := cv.instanceBuf
+
+	ib, ok := cv.b.(InstanceBackend)
+	if !ok {
+		for _, m := range mats {
+			cv.fill(&stl, tris, m, false)
+		}
+		return
+	}
+	cv.Flush()
+	r := cv.traceRegion("canvas.DrawInstances")
+	defer r.End()
+	for _, m := range mats {
+		cv.countTriangles(tris, m)
+	}
+	cv.stats.cur.DrawCalls -= len(mats) - 1
+	ib.FillInstances(&stl, tris, mats)
+}
+
+// FillInstances fills the triangles at each of the transformations
+func (b *SoftwareBackend) FillInstances(style *BackendFillStyle, tris []BackendVec, transforms []BackendMat) {
+	for _, tf := range transforms {
+		b.Fill(style, tris, tf, false)
+	}
+}
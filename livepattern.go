@@ -0,0 +1,128 @@
+package canvas
+
+import (
+	"image"
+	"image/draw"
+)
+
+// FrameSource is a source of images that change over time, such as a
+// video decoder or a camera. An image pattern created from a frame
+// source shows the current frame whenever it is used
+type FrameSource interface {
+	// Frame returns the current frame, or nil if there is none yet, and
+	// a number that changes whenever the frame changes, so that frames
+	// are only uploaded once
+	Frame() (image.Image, uint64)
+}
+
+// patternSource is the source of a live image pattern
+type patternSource interface {
+	// update copies the current content into the buffer if it changed,
+	// allocating a new buffer if the size changed
+	update(buf *image.RGBA) (*image.RGBA, bool)
+}
+
+// canvasSource copies a canvas whenever something was drawn on it
+type canvasSource struct {
+	cv      *Canvas
+	changes uint64
+	valid   bool
+}
+
+func (s *canvasSource) update(buf *image.RGBA) (*image.RGBA, bool) {
+	s.cv.Flush()
+	if s.valid && s.cv.stats.changes == s.changes {
+		return buf, false
+	}
+	w, h := s.cv.Size()
+	buf = patternBuffer(buf, w, h)
+	s.cv.GetImageDataInto(buf.Pix, 0, 0, w, h, buf.Stride)
+	s.changes, s.valid = s.cv.stats.changes, true
+	return buf, true
+}
+
+// backendSource copies a backend every time the pattern is used, since
+// backends don't track their changes
+type backendSource struct {
+	b Backend
+}
+
+func (s backendSource) update(buf *image.RGBA) (*image.RGBA, bool) {
+	w, h := s.b.Size()
+	buf = patternBuffer(buf, w, h)
+	getImageDataInto(s.b, buf.Pix, 0, 0, w, h, buf.Stride)
+	return buf, true
+}
+
+// frameSource copies the frames of a FrameSource when they change
+type frameSource struct {
+	src     FrameSource
+	version uint64
+	valid   bool
+}
+
+func (s *frameSource) update(buf *image.RGBA) (*image.RGBA, bool) {
+	img, version := s.src.Frame()
+	if img == nil || (s.valid && version == s.version) {
+		return buf, false
+	}
+	b := img.Bounds()
+	buf = patternBuffer(buf, b.Dx(), b.Dy())
+	draw.Draw(buf, buf.Rect, img, b.Min, draw.Src)
+	s.version, s.valid = version, true
+	return buf, true
+}
+
+// patternBuffer returns the buffer if it has the size, or a new one
+func patternBuffer(buf *image.RGBA, w, h int) *image.RGBA {
+	if buf != nil && buf.Rect.Dx() == w && buf.Rect.Dy() == h {
+		return buf
+	}
+	return image.NewRGBA(image.Rect(0, 0, w, h))
+}
+
+// createLivePattern returns an image pattern that follows the source, or
+// nil if the source is not a live source. Canvases that the backend can
+// draw directly are left to CreatePattern, they need no copying
+func (cv *Canvas) createLivePattern(src interface{}, repeat imagePatternRepeat) *ImagePattern {
+	var live patternSource
+	switch v := src.(type) {
+	case *Canvas:
+		if v.caps.AsImage && cv.b.CanUseAsImage(v.b) && v.b.AsImage() != nil {
+			return nil
+		}
+		live = &canvasSource{cv: v}
+	case FrameSource:
+		live = &frameSource{src: v}
+	case Backend:
+		live = backendSource{b: v}
+	default:
+		return nil
+	}
+	ip := &ImagePattern{cv: cv, rep: repeat, tf: BackendMatIdentity, live: live, gen: cv.context.gen}
+	ip.refresh()
+	return ip
+}
+
+// refresh updates the image of a live pattern from its source
+func (ip *ImagePattern) refresh() {
+	buf, changed := ip.live.update(ip.buf)
+	if !changed {
+		return
+	}
+	if buf == ip.buf && ip.img != nil {
+		ip.img.Replace(buf)
+		return
+	}
+	if ip.img != nil {
+		ip.img.Delete()
+	}
+	ip.buf = buf
+	ip.img = ip.cv.getImage(buf)
+	if ip.img == nil {
+		return
+	}
+	if ip.ip == nil {
+		ip.ip = ip.cv.b.LoadImagePattern(ip.data(ip.cv.transform()))
+	}
+}
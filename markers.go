@@ -0,0 +1,177 @@
+package canvas
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// MarkerType is the shape of the markers drawn with DrawMarkers
+type MarkerType uint8
+
+// Marker shapes for DrawMarkers
+const (
+	MarkerCircle MarkerType = iota
+	MarkerSquare
+	MarkerDiamond
+	MarkerTriangle
+	// MarkerCross and MarkerPlus are made of lines, which are drawn
+	// with the stroke color, or the fill color if there is none
+	MarkerCross
+	MarkerPlus
+)
+
+// MarkerStyle is the look of the markers drawn with DrawMarkers
+type MarkerStyle struct {
+	// Fill and Stroke are colors in any of the forms that SetFillStyle
+	// accepts. Nil draws no fill or outline
+	Fill, Stroke interface{}
+	// LineWidth is the width of the outline in the current
+	// coordinates. Zero is 1
+	LineWidth float64
+}
+
+type markerKey struct {
+	symbol          MarkerType
+	size, lineWidth float64
+	fill, stroke    color.RGBA
+}
+
+const (
+	// maxMarkerImages is the number of marker images that are kept
+	maxMarkerImages = 32
+	// maxMarkerSize is the size of the largest marker in pixels
+	maxMarkerSize = 1024
+)
+
+// DrawMarkers draws a marker centered at each of the positions, with
+// size being its width in the current coordinates. The marker is drawn
+// into an image once at the scale of the current transformation and
+// that image is then drawn at the positions rounded to whole pixels,
+// which is much faster than filling a path for every point of a scatter
+// plot. The positions are transformed, but the markers are not rotated
+// or skewed by the transformation. Shadows are not drawn, and markers
+// larger than 1024 pixels are not drawn at all
+func (cv *Canvas) DrawMarkers(symbol MarkerType, positions []BackendVec, size float64, style MarkerStyle) {
+	if len(positions) == 0 || !(size > 0) {
+		return
+	}
+	tf := cv.transform()
+	scale := math.Sqrt(math.Abs(tf[0]*tf[3] - tf[1]*tf[2]))
+	lw := style.LineWidth
+	if lw <= 0 {
+		lw = 1
+	}
+	key := markerKey{symbol: symbol, size: size * scale, lineWidth: lw * scale}
+	if style.Fill != nil {
+		key.fill, _ = parseColor(style.Fill)
+	}
+	if style.Stroke != nil {
+		key.stroke, _ = parseColor(style.Stroke)
+	}
+	if key.fill.A == 0 && key.stroke.A == 0 {
+		return
+	}
+	if key.size > maxMarkerSize || key.lineWidth > maxMarkerSize {
+		return
+	}
+
+	raster := cv.markerImage(key)
+	img := cv.getImage(raster)
+	if img == nil {
+		return
+	}
+	w, h := float64(raster.Rect.Dx()), float64(raster.Rect.Dy())
+	cv.spriteBuf = cv.spriteBuf[:0]
+	for _, pos := range positions {
+		c := cv.tf(pos)
+		x, y := math.Round(c[0]-w/2), math.Round(c[1]-h/2)
+		cv.spriteBuf = append(cv.spriteBuf, BackendSprite{
+			SW: w, SH: h,
+			Pts:   [4]BackendVec{{x, y}, {x, y + h}, {x + w, y + h}, {x + w, y}},
+			Alpha: cv.state.globalAlpha,
+		})
+	}
+	cv.drawSpriteBuf(img)
+}
+
+// markerImage returns the image of the marker, drawing it if it is not
+// cached yet
+func (cv *Canvas) markerImage(key markerKey) *image.RGBA {
+	if raster, ok := cv.markers[key]; ok {
+		return raster
+	}
+	if len(cv.markers) >= maxMarkerImages {
+		for k, raster := range cv.markers {
+			if img, ok := cv.images[raster]; ok {
+				img.Delete()
+			}
+			delete(cv.markers, k)
+		}
+	}
+	if cv.markers == nil {
+		cv.markers = make(map[markerKey]*image.RGBA)
+	}
+
+	// room for the outline and the anti-aliasing around it, with the
+	// parity of the size so that markers of whole pixels are aligned
+	// to the pixels
+	n := int(math.Ceil(key.size+key.lineWidth)) + 2
+	if (n-int(math.Round(key.size)))%2 != 0 {
+		n++
+	}
+	backend := NewBackend(n, n)
+	mc := New(backend)
+	c, r := float64(n)/2, key.size/2
+	mc.BeginPath()
+	lines := false
+	switch key.symbol {
+	case MarkerSquare:
+		mc.Rect(c-r, c-r, key.size, key.size)
+	case MarkerDiamond:
+		mc.MoveTo(c, c-r)
+		mc.LineTo(c+r, c)
+		mc.LineTo(c, c+r)
+		mc.LineTo(c-r, c)
+		mc.ClosePath()
+	case MarkerTriangle:
+		// the center of the triangle is the center of the marker
+		for i := 0; i < 3; i++ {
+			s, co := math.Sincos(float64(i) * math.Pi * 2 / 3)
+			mc.LineTo(c+s*r, c-co*r)
+		}
+		mc.ClosePath()
+	case MarkerCross:
+		d := r * math.Sqrt2 / 2
+		mc.MoveTo(c-d, c-d)
+		mc.LineTo(c+d, c+d)
+		mc.MoveTo(c+d, c-d)
+		mc.LineTo(c-d, c+d)
+		lines = true
+	case MarkerPlus:
+		mc.MoveTo(c-r, c)
+		mc.LineTo(c+r, c)
+		mc.MoveTo(c, c-r)
+		mc.LineTo(c, c+r)
+		lines = true
+	default:
+		mc.Arc(c, c, r, 0, math.Pi*2, false)
+		mc.ClosePath()
+	}
+	stroke := key.stroke
+	if lines {
+		if stroke.A == 0 {
+			stroke = key.fill
+		}
+	} else if key.fill.A > 0 {
+		mc.SetFillStyle(key.fill)
+		mc.Fill()
+	}
+	if stroke.A > 0 {
+		mc.SetStrokeStyle(stroke)
+		mc.SetLineWidth(key.lineWidth)
+		mc.Stroke()
+	}
+	cv.markers[key] = backend.Image
+	return backend.Image
+}
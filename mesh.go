@@ -0,0 +1,42 @@
+package canvas
+
+import (
+	"image/color"
+	"math"
+)
+
+// DrawMesh fills a list of triangles where each corner has its own color.
+// Every three points in pts form one triangle, and colors must contain one
+// color per point. The colors are interpolated across each triangle, which
+// allows for gradients that can not be expressed with linear or radial
+// gradients. The colors can be given in any of the formats accepted by
+// SetFillStyle
+func (cv *Canvas) DrawMesh(pts []BackendVec, colors ...interface{}) {
+	count := len(pts) - len(pts)%3
+	if len(colors) < count {
+		count = len(colors) - len(colors)%3
+	}
+	if count == 0 {
+		return
+	}
+
+	var triBuf [500]BackendVec
+	var colBuf [500]color.RGBA
+	tris := triBuf[:0]
+	cols := colBuf[:0]
+	for i := 0; i < count; i++ {
+		c, ok := parseColor(colors[i])
+		if !ok {
+			c = color.RGBA{A: 255}
+		}
+		c.A = uint8(math.Round(float64(c.A) * cv.state.globalAlpha))
+		tris = append(tris, cv.tf(pts[i]))
+		cols = append(cols, c)
+	}
+
+	cv.drawShadow(tris, nil, false)
+
+	cv.fillTrianglesVertexColor(tris, cols)
+
+	cv.drawInsetShadow(tris, nil)
+}
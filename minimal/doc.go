@@ -0,0 +1,21 @@
+// Package minimal is the entry point for binary size sensitive builds,
+// such as WebAssembly or embedded targets. It only provides its API when
+// built with the canvas_notext build tag:
+//
+//	go build -tags canvas_notext
+//
+// With the tag, the canvas package leaves out all font loading and text
+// rendering code, including the freetype dependency. Without the tag,
+// this package is empty, so code using it fails to compile instead of
+// silently linking the text code. Further optional subsystems are left
+// out with their own build tags:
+//
+//	canvas_nohttp          loading images from URLs (net/http)
+//	canvas_notrace         TracingBackend and runtime tracing
+//	canvas_noimageformats  GIF, JPEG and WebP images
+//	canvas_nocolornames    CSS color names in gradient strings
+//
+// The smallest build uses all of them:
+//
+//	go build -tags canvas_notext,canvas_nohttp,canvas_notrace,canvas_noimageformats,canvas_nocolornames
+package minimal
@@ -0,0 +1,23 @@
+//go:build canvas_notext
+// +build canvas_notext
+
+package minimal
+
+import (
+	"github.com/opentoys/canvas"
+)
+
+// the canvas package only defines NoText if text support is left out
+const _ = canvas.NoText
+
+// Canvas is the canvas type of the canvas package
+type Canvas = canvas.Canvas
+
+// SoftwareBackend is the software backend of the canvas package
+type SoftwareBackend = canvas.SoftwareBackend
+
+// New creates a canvas with a software backend of the given size
+func New(w, h int) (*Canvas, *SoftwareBackend) {
+	backend := canvas.NewBackend(w, h)
+	return canvas.New(backend), backend
+}
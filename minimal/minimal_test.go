@@ -0,0 +1,48 @@
+//go:build canvas_notext
+// +build canvas_notext
+
+package minimal_test
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+const minimalTags = "canvas_notext,canvas_nohttp,canvas_notrace,canvas_noimageformats,canvas_nocolornames"
+
+func TestMinimalImports(t *testing.T) {
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip(err)
+	}
+	out, err := exec.Command(gobin, "list", "-deps", "-tags", minimalTags, "github.com/opentoys/canvas/minimal").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := make(map[string]bool)
+	for _, pkg := range strings.Fields(string(out)) {
+		deps[pkg] = true
+	}
+	if !deps["github.com/opentoys/canvas"] {
+		t.Fatalf("Expected the canvas package in the dependencies:\n%s", out)
+	}
+	for _, pkg := range []string{
+		"encoding/json",
+		"runtime/trace",
+		"image/gif",
+		"image/jpeg",
+		"math/big",
+		"net/http",
+		"golang.org/x/image/webp",
+		"golang.org/x/image/colornames",
+		"golang.org/x/image/draw",
+		"github.com/golang/freetype",
+	} {
+		for dep := range deps {
+			if dep == pkg || strings.HasPrefix(dep, pkg+"/") {
+				t.Errorf("The minimal build imports %s", dep)
+			}
+		}
+	}
+}
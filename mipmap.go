@@ -0,0 +1,242 @@
+package canvas
+
+import (
+	"image"
+	"math"
+)
+
+// MipmapQuality selects the filter that creates the smaller versions of
+// an image that are used when it is drawn scaled down. All filters
+// average in linear light, so that scaled down images don't get darker
+type MipmapQuality uint8
+
+// Mipmap filters for LoadImage
+const (
+	// MipmapBox averages the pixels that each pixel of the smaller
+	// version covers
+	MipmapBox MipmapQuality = iota
+	// MipmapTriangle uses a tent filter, which is a bit smoother
+	// than the box filter
+	MipmapTriangle
+	// MipmapLanczos uses a two lobe Lanczos filter, which keeps more
+	// detail but can cause slight ringing at hard edges
+	MipmapLanczos
+)
+
+// MipmapBackend is implemented by backends that can create the mipmaps
+// of an image with a given filter
+type MipmapBackend interface {
+	LoadImageMipmap(img image.Image, quality MipmapQuality) (BackendImage, error)
+}
+
+// loadImageMipmap loads the image with the mipmap filter if the backend
+// supports it, and with its default filter otherwise
+func loadImageMipmap(b Backend, img image.Image, quality MipmapQuality) (BackendImage, error) {
+	if mb, ok := b.(MipmapBackend); ok {
+		return mb.LoadImageMipmap(img, quality)
+	}
+	return b.LoadImage(img)
+}
+
+var srgbLinearTable = func() (table [256]float32) {
+	for i := range table {
+		table[i] = float32(srgbToLinear(float64(i) / 255))
+	}
+	return
+}()
+
+const linearSteps = 4096
+
+var linearToSRGB = func() (table [linearSteps + 1]uint8) {
+	for i := range table {
+		v := float64(i) / linearSteps
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		table[i] = uint8(math.Round(v * 255))
+	}
+	return
+}()
+
+// linearImage holds colors in linear light, four values per pixel, so
+// that the mipmap levels don't lose precision. The colors are
+// multiplied with the alpha value, so that transparent pixels don't
+// bleed into their neighbors
+type linearImage struct {
+	w, h int
+	pix  []float32
+}
+
+func newLinearImage(img image.Image) *linearImage {
+	bounds := img.Bounds()
+	li := &linearImage{w: bounds.Dx(), h: bounds.Dy()}
+	li.pix = make([]float32, li.w*li.h*4)
+	i := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := rgbaAt(img, x, y)
+			if c.A != 0 {
+				a := float32(c.A) / 255
+				li.pix[i] = srgbLinearTable[c.R] * a
+				li.pix[i+1] = srgbLinearTable[c.G] * a
+				li.pix[i+2] = srgbLinearTable[c.B] * a
+				li.pix[i+3] = a
+			}
+			i += 4
+		}
+	}
+	return li
+}
+
+// rgba converts the image back to sRGB with straight alpha, like all
+// images of the canvas
+func (li *linearImage) rgba() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, li.w, li.h))
+	for i := 0; i < len(li.pix); i += 4 {
+		a := li.pix[i+3]
+		if a <= 0 {
+			continue
+		}
+		if a > 1 {
+			a = 1
+		}
+		p := img.Pix[i : i+4 : i+4]
+		for j := 0; j < 3; j++ {
+			v := li.pix[i+j] / a
+			if v <= 0 {
+				continue
+			}
+			if v > 1 {
+				v = 1
+			}
+			p[j] = linearToSRGB[int(v*linearSteps+0.5)]
+		}
+		p[3] = uint8(a*255 + 0.5)
+	}
+	return img
+}
+
+type mipTap struct {
+	i int
+	w float32
+}
+
+// mipTaps returns the source pixels and their weights for every pixel
+// when scaling a row or column from srcN to dstN pixels
+func mipTaps(srcN, dstN int, quality MipmapQuality) [][]mipTap {
+	scale := float64(srcN) / float64(dstN)
+	taps := make([][]mipTap, dstN)
+	for d := range taps {
+		x0, x1 := float64(d)*scale, float64(d+1)*scale
+		center := (x0 + x1) / 2
+		radius := scale / 2
+		switch quality {
+		case MipmapTriangle:
+			radius = scale
+		case MipmapLanczos:
+			radius = scale * 2
+		}
+		var sum float64
+		for i := int(math.Floor(center - radius)); i < int(math.Ceil(center+radius)); i++ {
+			var w float64
+			switch quality {
+			case MipmapTriangle:
+				w = math.Max(1-math.Abs(float64(i)+0.5-center)/scale, 0)
+			case MipmapLanczos:
+				w = lanczos2((float64(i) + 0.5 - center) / scale)
+			default:
+				w = math.Min(float64(i+1), x1) - math.Max(float64(i), x0)
+			}
+			if w == 0 {
+				continue
+			}
+			// pixels outside of the image repeat the edge
+			idx := i
+			if idx < 0 {
+				idx = 0
+			} else if idx >= srcN {
+				idx = srcN - 1
+			}
+			sum += w
+			taps[d] = append(taps[d], mipTap{i: idx, w: float32(w)})
+		}
+		for j := range taps[d] {
+			taps[d][j].w /= float32(sum)
+		}
+	}
+	return taps
+}
+
+func lanczos2(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	if x <= -2 || x >= 2 {
+		return 0
+	}
+	px := math.Pi * x
+	return 2 * math.Sin(px) * math.Sin(px/2) / (px * px)
+}
+
+// halve returns the next mipmap level. Odd sizes are rounded up, so
+// that the last row and column are not lost
+func (li *linearImage) halve(quality MipmapQuality) *linearImage {
+	w, h := (li.w+1)/2, (li.h+1)/2
+
+	tmp := make([]float32, w*li.h*4)
+	xtaps := mipTaps(li.w, w, quality)
+	for y := 0; y < li.h; y++ {
+		src := li.pix[y*li.w*4:]
+		dst := tmp[y*w*4:]
+		for x, taps := range xtaps {
+			var r, g, b, a float32
+			for _, t := range taps {
+				p := src[t.i*4 : t.i*4+4 : t.i*4+4]
+				r += p[0] * t.w
+				g += p[1] * t.w
+				b += p[2] * t.w
+				a += p[3] * t.w
+			}
+			dst[x*4], dst[x*4+1], dst[x*4+2], dst[x*4+3] = r, g, b, a
+		}
+	}
+
+	out := &linearImage{w: w, h: h, pix: make([]float32, w*h*4)}
+	ytaps := mipTaps(li.h, h, quality)
+	for y, taps := range ytaps {
+		dst := out.pix[y*w*4 : (y+1)*w*4]
+		for _, t := range taps {
+			src := tmp[t.i*w*4 : (t.i+1)*w*4]
+			for i, v := range src {
+				dst[i] += v * t.w
+			}
+		}
+		if quality == MipmapLanczos {
+			// negative lobes can overshoot
+			for i := 0; i < len(dst); i += 4 {
+				a := clampUnit(dst[i+3])
+				dst[i+3] = a
+				for j := i; j < i+3; j++ {
+					if dst[j] < 0 {
+						dst[j] = 0
+					} else if dst[j] > a {
+						dst[j] = a
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+func clampUnit(v float32) float32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
@@ -0,0 +1,8 @@
+//go:build canvas_nocolornames
+// +build canvas_nocolornames
+
+package canvas
+
+import "image/color"
+
+func cssColorName(name string) (color.RGBA, bool) { return color.RGBA{}, false }
@@ -0,0 +1,8 @@
+//go:build canvas_nocolornames
+// +build canvas_nocolornames
+
+package canvas_test
+
+func init() {
+	colorNamesDisabled = true
+}
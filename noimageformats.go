@@ -0,0 +1,25 @@
+//go:build canvas_noimageformats
+// +build canvas_noimageformats
+
+package canvas
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+)
+
+func encodeJPEG(w io.Writer, img image.Image, quality int) error {
+	return errors.New("JPEG is not supported with the canvas_noimageformats build tag")
+}
+
+// scaleExport scales the image to the size of dst bilinearly, with
+// premultiplied alpha
+func scaleExport(dst, src *image.NRGBA) {
+	rgba := image.NewRGBA(src.Rect)
+	draw.Draw(rgba, rgba.Rect, src, src.Rect.Min, draw.Src)
+	scaled := image.NewRGBA(dst.Rect)
+	scaleBilinear(scaled, scaled.Rect, rgba)
+	draw.Draw(dst, dst.Rect, scaled, scaled.Rect.Min, draw.Src)
+}
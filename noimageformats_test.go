@@ -0,0 +1,8 @@
+//go:build canvas_noimageformats
+// +build canvas_noimageformats
+
+package canvas_test
+
+func init() {
+	imageFormatsDisabled = true
+}
@@ -0,0 +1,123 @@
+package canvas
+
+import (
+	"image"
+	"math"
+)
+
+// Noise describes a procedural noise texture similar to the feTurbulence
+// SVG filter. The output only depends on the parameters, so the same
+// seed always produces exactly the same pixels, across runs and across
+// platforms. Only integer hashing and basic float operations are used,
+// with explicit conversions so that no fused multiply-add instructions
+// can change the rounding
+type Noise struct {
+	// Seed selects the random pattern
+	Seed int64
+	// BaseFrequency is the frequency of the first octave in
+	// cycles per pixel
+	BaseFrequency float64
+	// Octaves is the number of noise layers that are added up,
+	// each one with double the frequency and half the amplitude
+	// of the previous one. It defaults to 1
+	Octaves int
+	// Turbulence selects the sum of absolute noise values
+	// instead of fractal noise
+	Turbulence bool
+}
+
+// noiseRand is a small random number generator (splitmix64) that produces
+// the same sequence on every platform for a given seed, unlike the global
+// generator of math/rand
+type noiseRand struct {
+	state uint64
+}
+
+func (r *noiseRand) next() uint64 {
+	r.state += 0x9e3779b97f4a7c15
+	z := r.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// float returns a number in the range [0,1)
+func (r *noiseRand) float() float64 {
+	return float64(r.next()>>11) / (1 << 53)
+}
+
+// noiseHash returns a pseudo random value in the range [-1,1] for
+// the lattice point x/y of the given channel
+func noiseHash(seed int64, channel, x, y int) float64 {
+	r := noiseRand{state: uint64(seed) ^ uint64(channel)*0xd6e8feb86659fd93}
+	r.state ^= uint64(int64(x))*0x9e3779b97f4a7c15 ^ uint64(int64(y))*0xc2b2ae3d27d4eb4f
+	return r.float()*2 - 1
+}
+
+func noiseSmooth(t float64) float64 {
+	return float64(t*t) * float64(3-float64(2*t))
+}
+
+// valueNoise returns smoothly interpolated noise in the range [-1,1]
+func valueNoise(seed int64, channel int, x, y float64) float64 {
+	fx, fy := math.Floor(x), math.Floor(y)
+	ix, iy := int(fx), int(fy)
+	tx, ty := noiseSmooth(x-fx), noiseSmooth(y-fy)
+
+	v00 := noiseHash(seed, channel, ix, iy)
+	v10 := noiseHash(seed, channel, ix+1, iy)
+	v01 := noiseHash(seed, channel, ix, iy+1)
+	v11 := noiseHash(seed, channel, ix+1, iy+1)
+
+	top := v00 + float64((v10-v00)*tx)
+	bottom := v01 + float64((v11-v01)*tx)
+	return top + float64((bottom-top)*ty)
+}
+
+// At returns the noise value of the given channel (0-3 for RGBA) at
+// the given pixel position in the range [0,1]
+func (n Noise) At(channel int, x, y float64) float64 {
+	octaves := n.Octaves
+	if octaves < 1 {
+		octaves = 1
+	}
+
+	var sum float64
+	freq, amp := n.BaseFrequency, 1.0
+	for i := 0; i < octaves; i++ {
+		v := valueNoise(n.Seed+int64(i), channel, float64(x*freq), float64(y*freq))
+		if n.Turbulence {
+			v = math.Abs(v)
+		}
+		sum += float64(v * amp)
+		freq *= 2
+		amp *= 0.5
+	}
+
+	if n.Turbulence {
+		return math.Min(1, sum)
+	}
+	return math.Max(0, math.Min(1, float64(sum+1)*0.5))
+}
+
+// Image renders the noise into a new RGBA image of the given size
+func (n Noise) Image(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			off := img.PixOffset(x, y)
+			fx, fy := float64(x)+0.5, float64(y)+0.5
+			for c := 0; c < 4; c++ {
+				img.Pix[off+c] = uint8(math.Round(n.At(c, fx, fy) * 255))
+			}
+		}
+	}
+	return img
+}
+
+// CreateNoisePattern creates an image pattern from a noise texture of
+// the given size. Since the texture is deterministic, renders that use
+// it can be cached and compared in tests
+func (cv *Canvas) CreateNoisePattern(noise Noise, w, h int, repeat imagePatternRepeat) *ImagePattern {
+	return cv.CreatePattern(noise.Image(w, h), repeat)
+}
@@ -0,0 +1,52 @@
+//go:build canvas_notext
+// +build canvas_notext
+
+package canvas
+
+import (
+	"errors"
+	"time"
+)
+
+// NoText is only defined when the package is built with the canvas_notext
+// build tag, which leaves out all font loading and text rendering code.
+// The text functions still exist, but do nothing
+const NoText = true
+
+// Font is a loaded font that can be passed to the
+// SetFont method
+type Font struct{}
+
+type textCache struct{}
+
+func newTextCache() textCache { return textCache{} }
+
+type textState struct{}
+
+func (tc *textCache) usage() (total int, oldest time.Time, ok bool) { return }
+
+func (tc *textCache) dropOldest() {}
+
+// LoadFont always returns an error, since text support is disabled
+func (cv *Canvas) LoadFont(src interface{}) (*Font, error) {
+	return nil, errors.New("Text support is disabled")
+}
+
+// SetFont does nothing, since text support is disabled
+func (cv *Canvas) SetFont(src interface{}, size float64) {}
+
+// FillText does nothing, since text support is disabled
+func (cv *Canvas) FillText(str string, x, y float64) {}
+
+// StrokeText does nothing, since text support is disabled
+func (cv *Canvas) StrokeText(str string, x, y float64) {}
+
+// TextMetrics is the result of a MeasureText call
+type TextMetrics struct {
+	Width                    float64
+	ActualBoundingBoxAscent  float64
+	ActualBoundingBoxDescent float64
+}
+
+// MeasureText always returns empty metrics, since text support is disabled
+func (cv *Canvas) MeasureText(str string) TextMetrics { return TextMetrics{} }
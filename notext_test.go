@@ -0,0 +1,8 @@
+//go:build canvas_notext
+// +build canvas_notext
+
+package canvas_test
+
+func init() {
+	textDisabled = true
+}
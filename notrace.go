@@ -0,0 +1,16 @@
+//go:build canvas_notrace
+// +build canvas_notrace
+
+package canvas
+
+// frameTrace does nothing without runtime/trace support
+type frameTrace struct{}
+
+func (ft *frameTrace) next() {}
+
+// noTraceRegion stands in for a trace region
+type noTraceRegion struct{}
+
+func (noTraceRegion) End() {}
+
+func (cv *Canvas) traceRegion(name string) noTraceRegion { return noTraceRegion{} }
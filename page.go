@@ -0,0 +1,100 @@
+package canvas
+
+import "math"
+
+// Page describes a printed page for raster export at print resolution.
+// All lengths are in Unit. The canvas for a page holds the trimmed page,
+// the bleed around it that artwork should extend into, and the slug
+// around that where crop marks are drawn
+type Page struct {
+	Unit Unit
+	// DPI is the resolution of the canvas. Zero is DefaultDPI
+	DPI float64
+	// Width and Height are the trimmed size of the page
+	Width, Height float64
+	// Margins are the distances of the content area from the trimmed
+	// edges
+	MarginTop, MarginRight, MarginBottom, MarginLeft float64
+	// Bleed is the width of the area outside of the trimmed page that
+	// is printed and cut off
+	Bleed float64
+	// Slug is the width of the area outside of the bleed for the crop
+	// marks. Without it no crop marks are drawn
+	Slug float64
+}
+
+// offset is the distance of the trimmed page from the canvas edges
+func (p Page) offset() float64 {
+	return p.Bleed + p.Slug
+}
+
+// PixelSize returns the size of the canvas for the page in pixels,
+// including the bleed and the slug
+func (p Page) PixelSize() (int, int) {
+	s := p.Unit.PixelsPerUnit(p.DPI)
+	o := 2 * p.offset()
+	return int(math.Ceil((p.Width+o)*s - 1e-9)), int(math.Ceil((p.Height+o)*s - 1e-9))
+}
+
+// NewBackend creates a software backend with the size of the page
+func (p Page) NewBackend() *SoftwareBackend {
+	w, h := p.PixelSize()
+	return NewBackend(w, h)
+}
+
+// Setup sets the units of the canvas to those of the page and sets the
+// transformation so that 0,0 is the top left corner of the trimmed page
+func (p Page) Setup(cv *Canvas) {
+	cv.SetUnits(p.Unit, p.DPI)
+	cv.Translate(p.offset(), p.offset())
+}
+
+// TrimBox returns the trimmed page in the coordinates set by Setup
+func (p Page) TrimBox() Bounds {
+	return Bounds{MaxX: p.Width, MaxY: p.Height}
+}
+
+// BleedBox returns the trimmed page with the bleed
+func (p Page) BleedBox() Bounds {
+	return p.TrimBox().Grow(p.Bleed)
+}
+
+// ContentBox returns the area inside of the margins
+func (p Page) ContentBox() Bounds {
+	return Bounds{
+		MinX: p.MarginLeft, MinY: p.MarginTop,
+		MaxX: p.Width - p.MarginRight, MaxY: p.Height - p.MarginBottom,
+	}
+}
+
+// DrawCropMarks draws the crop marks at the corners of the trimmed page
+// into the slug, as lines of a quarter point in the current stroke
+// style. It replaces the current path. The canvas must be set up with
+// Setup
+func (p Page) DrawCropMarks(cv *Canvas) {
+	if p.Slug <= 0 {
+		return
+	}
+	cv.Save()
+	cv.SetLineWidth(0.25 * Points.PixelsPerUnit(p.DPI) / p.Unit.PixelsPerUnit(p.DPI))
+	cv.SetLineCap(Butt)
+	cv.BeginPath()
+	start, end := p.Bleed, p.offset()
+	for _, x := range [2]float64{0, p.Width} {
+		for _, y := range [2]float64{0, p.Height} {
+			dx, dy := -1.0, -1.0
+			if x > 0 {
+				dx = 1
+			}
+			if y > 0 {
+				dy = 1
+			}
+			cv.MoveTo(x+dx*start, y)
+			cv.LineTo(x+dx*end, y)
+			cv.MoveTo(x, y+dy*start)
+			cv.LineTo(x, y+dy*end)
+		}
+	}
+	cv.Stroke()
+	cv.Restore()
+}
@@ -0,0 +1,219 @@
+// Package painting stamps brush tips along paths, which is the core of
+// the brush engines of painting applications.
+//
+// A stroke is built as a mask first: each dab of the brush adds its tip
+// with the flow of the brush, and the opacity of the brush limits the
+// whole stroke, so that overlapping dabs of the same stroke don't build
+// up beyond it. The mask is then blended onto the canvas in one step
+package painting
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+
+	"github.com/opentoys/canvas"
+)
+
+// BlendMode is how a stroke is combined with the canvas
+type BlendMode uint8
+
+// Blend modes
+const (
+	// BlendNormal paints the color over the canvas
+	BlendNormal BlendMode = iota
+	// BlendErase reduces the alpha of the canvas, ignoring the color
+	BlendErase
+	// BlendMultiply multiplies the color of the canvas with the color
+	// of the brush, which darkens it
+	BlendMultiply
+)
+
+// Brush describes the dabs that are stamped along a path
+type Brush struct {
+	// Size is the diameter of a dab
+	Size float64
+	// Tip is the shape of a dab, scaled so that its larger side is
+	// Size. If it is nil, a round tip is used
+	Tip *image.Alpha
+	// Hardness is the part of the radius of the round tip that is
+	// fully opaque, between 0 and 1. The rest fades out smoothly
+	Hardness float64
+	// Spacing is the distance between dabs relative to Size. Zero uses
+	// a quarter of the size
+	Spacing float64
+	// Scatter is the maximum random distance of dabs from the path,
+	// relative to Size
+	Scatter float64
+	// Seed is the seed of the random scatter, so that strokes can be
+	// drawn again identically
+	Seed int64
+	// Opacity is the maximum alpha of the whole stroke, between 0 and 1
+	Opacity float64
+	// Flow is the alpha of each dab, between 0 and 1
+	Flow float64
+	// Color is the color of the stroke
+	Color color.RGBA
+	// Blend is how the stroke is combined with the canvas
+	Blend BlendMode
+}
+
+// DefaultBrush is a soft round black brush
+var DefaultBrush = Brush{
+	Size:     10,
+	Hardness: 0.5,
+	Spacing:  0.25,
+	Opacity:  1,
+	Flow:     1,
+	Color:    color.RGBA{A: 255},
+}
+
+// Dabs returns the centers of the dabs that StrokeBrush stamps along the
+// path. Each subpath starts with a dab
+func Dabs(path *canvas.Path2D, b *Brush) []canvas.BackendVec {
+	spacing := b.Spacing
+	if spacing <= 0 {
+		spacing = 0.25
+	}
+	step := math.Max(spacing*b.Size, 0.5)
+	rnd := rand.New(rand.NewSource(b.Seed))
+
+	var dabs []canvas.BackendVec
+	add := func(p canvas.BackendVec) {
+		if b.Scatter > 0 {
+			// uniform in a disk with the scatter radius
+			r := math.Sqrt(rnd.Float64()) * b.Scatter * b.Size
+			a := rnd.Float64() * 2 * math.Pi
+			p = canvas.BackendVec{p[0] + math.Cos(a)*r, p[1] + math.Sin(a)*r}
+		}
+		dabs = append(dabs, p)
+	}
+
+	for _, line := range path.Polylines() {
+		if len(line) == 0 {
+			continue
+		}
+		add(line[0])
+		next := step
+		for i := 1; i < len(line); i++ {
+			d := line[i].Sub(line[i-1])
+			l := d.Len()
+			for next <= l {
+				add(line[i-1].Add(d.Mulf(next / l)))
+				next += step
+			}
+			next -= l
+		}
+	}
+	return dabs
+}
+
+// StrokeBrush stamps the brush along the path and blends the stroke onto
+// the canvas, using the current transformation. The stroke is built at
+// one pixel per unit of the current coordinates
+func StrokeBrush(cv *canvas.Canvas, path *canvas.Path2D, b *Brush) {
+	dabs := Dabs(path, b)
+	if len(dabs) == 0 || b.Size <= 0 {
+		return
+	}
+
+	r := b.Size / 2
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, d := range dabs {
+		minX, minY = math.Min(minX, d[0]), math.Min(minY, d[1])
+		maxX, maxY = math.Max(maxX, d[0]), math.Max(maxY, d[1])
+	}
+	x0, y0 := int(math.Floor(minX-r)), int(math.Floor(minY-r))
+	x1, y1 := int(math.Ceil(maxX+r)), int(math.Ceil(maxY+r))
+	w, h := x1-x0, y1-y0
+
+	acc := make([]float64, w*h)
+	for _, d := range dabs {
+		stamp(acc, w, h, d[0]-float64(x0), d[1]-float64(y0), b)
+	}
+
+	mask := image.NewAlpha(image.Rect(0, 0, w, h))
+	for i, a := range acc {
+		mask.Pix[i] = uint8(math.Round(math.Min(a, 1) * b.Opacity * 255))
+	}
+
+	fx, fy, fw, fh := float64(x0), float64(y0), float64(w), float64(h)
+	switch b.Blend {
+	case BlendNormal:
+		cv.FillWithMask(b.Color, mask, fx, fy, fw, fh)
+	case BlendErase:
+		cv.MapPixelsMask(mask, fx, fy, fw, fh, func(c color.RGBA, cov uint8) color.RGBA {
+			c.A = uint8((int(c.A)*(255-int(cov)) + 127) / 255)
+			return c
+		})
+	case BlendMultiply:
+		bc := b.Color
+		cv.MapPixelsMask(mask, fx, fy, fw, fh, func(c color.RGBA, cov uint8) color.RGBA {
+			mul := func(v, m uint8) uint8 {
+				// mix between 1 and the brush color by the coverage
+				f := 255*255 - int(cov)*(255-int(m))
+				return uint8((int(v)*f + 255*255/2) / (255 * 255))
+			}
+			c.R, c.G, c.B = mul(c.R, bc.R), mul(c.G, bc.G), mul(c.B, bc.B)
+			return c
+		})
+	}
+}
+
+// stamp adds one dab centered at cx/cy to the accumulated alpha
+func stamp(acc []float64, w, h int, cx, cy float64, b *Brush) {
+	r := b.Size / 2
+	sx0, sy0 := maxInt(int(math.Floor(cx-r)), 0), maxInt(int(math.Floor(cy-r)), 0)
+	sx1, sy1 := minInt(int(math.Ceil(cx+r)), w), minInt(int(math.Ceil(cy+r)), h)
+	for y := sy0; y < sy1; y++ {
+		for x := sx0; x < sx1; x++ {
+			a := tipAt(b, float64(x)+0.5-cx, float64(y)+0.5-cy) * b.Flow
+			if a <= 0 {
+				continue
+			}
+			i := y*w + x
+			acc[i] += a * (1 - acc[i])
+		}
+	}
+}
+
+// tipAt returns the alpha of the tip at the offset from its center
+func tipAt(b *Brush, dx, dy float64) float64 {
+	r := b.Size / 2
+	if b.Tip == nil {
+		d := math.Sqrt(dx*dx+dy*dy) / r
+		if d >= 1 {
+			return 0
+		}
+		if d <= b.Hardness {
+			return 1
+		}
+		t := (d - b.Hardness) / (1 - b.Hardness)
+		return 1 - t*t*(3-2*t)
+	}
+
+	tw, th := b.Tip.Rect.Dx(), b.Tip.Rect.Dy()
+	scale := b.Size / float64(maxInt(tw, th))
+	tx := int(math.Floor(dx/scale + float64(tw)/2))
+	ty := int(math.Floor(dy/scale + float64(th)/2))
+	if tx < 0 || ty < 0 || tx >= tw || ty >= th {
+		return 0
+	}
+	return float64(b.Tip.AlphaAt(b.Tip.Rect.Min.X+tx, b.Tip.Rect.Min.Y+ty).A) / 255
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,111 @@
+package painting_test
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/opentoys/canvas"
+	"github.com/opentoys/canvas/painting"
+	"github.com/opentoys/canvas/testutil"
+)
+
+func line(cv *canvas.Canvas, x0, y0, x1, y1 float64) *canvas.Path2D {
+	p := cv.NewPath2D()
+	p.MoveTo(x0, y0)
+	p.LineTo(x1, y1)
+	return p
+}
+
+func TestDabs(t *testing.T) {
+	cv := canvas.New(canvas.NewBackend(1, 1))
+	brush := painting.DefaultBrush
+	brush.Spacing = 0.5
+	dabs := painting.Dabs(line(cv, 0, 0, 100, 0), &brush)
+	if len(dabs) != 21 {
+		t.Fatalf("Expected 21 dabs, got %d", len(dabs))
+	}
+	for i, d := range dabs {
+		if d.Sub(canvas.BackendVec{float64(i) * 5, 0}).Len() > 1e-9 {
+			t.Fatalf("Unexpected dab %d at %v", i, d)
+		}
+	}
+
+	brush.Scatter = 1
+	brush.Seed = 7
+	scattered := painting.Dabs(line(cv, 0, 0, 100, 0), &brush)
+	if !reflect.DeepEqual(scattered, painting.Dabs(line(cv, 0, 0, 100, 0), &brush)) {
+		t.Fatal("Expected the same seed to scatter the dabs the same way")
+	}
+	if reflect.DeepEqual(scattered, dabs) {
+		t.Fatal("Expected the dabs to be scattered")
+	}
+	for i, d := range scattered {
+		if d.Sub(dabs[i]).Len() > 10 {
+			t.Fatalf("Dab %d scattered too far to %v", i, d)
+		}
+	}
+}
+
+func TestStrokeBrush(t *testing.T) {
+	brush := painting.DefaultBrush
+	brush.Color = color.RGBA{255, 0, 0, 255}
+	brush.Hardness = 1
+	img := testutil.Render(60, 20, func(cv *canvas.Canvas) {
+		painting.StrokeBrush(cv, line(cv, 10, 10, 50, 10), &brush)
+	})
+	if c := img.RGBAAt(30, 10); c != brush.Color {
+		t.Fatalf("Expected the stroke color on the path, got %v", c)
+	}
+	if c := img.RGBAAt(30, 17); c.A != 0 {
+		t.Fatalf("Expected nothing outside of the brush, got %v", c)
+	}
+
+	// overlapping dabs don't build up beyond the opacity
+	brush.Opacity = 0.5
+	img = testutil.Render(60, 20, func(cv *canvas.Canvas) {
+		cv.SetFillStyle("#fff")
+		cv.FillRect(0, 0, 60, 20)
+		painting.StrokeBrush(cv, line(cv, 10, 10, 50, 10), &brush)
+	})
+	if c := img.RGBAAt(30, 10); c.R != 255 || c.G < 126 || c.G > 129 {
+		t.Fatalf("Expected a half opaque stroke, got %v", c)
+	}
+
+	brush.Opacity = 1
+	brush.Blend = painting.BlendErase
+	img = testutil.Render(60, 20, func(cv *canvas.Canvas) {
+		cv.SetFillStyle("#00f")
+		cv.FillRect(0, 0, 60, 20)
+		cv.Translate(10, 0)
+		painting.StrokeBrush(cv, line(cv, 0, 10, 40, 10), &brush)
+	})
+	if c := img.RGBAAt(30, 10); c.A != 0 {
+		t.Fatalf("Expected the stroke to erase, got %v", c)
+	}
+	if c := img.RGBAAt(2, 10); c != (color.RGBA{0, 0, 255, 255}) {
+		t.Fatalf("Expected the canvas to be unchanged outside of the stroke, got %v", c)
+	}
+}
+
+func TestTip(t *testing.T) {
+	// a tip that only covers its left half
+	tip := image.NewAlpha(image.Rect(0, 0, 2, 2))
+	tip.Pix = []byte{255, 0, 255, 0}
+	brush := painting.DefaultBrush
+	brush.Tip = tip
+	brush.Color = color.RGBA{0, 255, 0, 255}
+	img := testutil.Render(20, 20, func(cv *canvas.Canvas) {
+		p := cv.NewPath2D()
+		p.MoveTo(10, 10)
+		p.LineTo(10.5, 10)
+		painting.StrokeBrush(cv, p, &brush)
+	})
+	if c := img.RGBAAt(7, 10); c != brush.Color {
+		t.Fatalf("Expected the left half to be painted, got %v", c)
+	}
+	if c := img.RGBAAt(12, 10); c.A != 0 {
+		t.Fatalf("Expected the right half to be empty, got %v", c)
+	}
+}
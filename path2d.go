@@ -16,6 +16,10 @@ type Path2D struct {
 	fillCache  []BackendVec
 
 	noSelfIntersection bool
+
+	// appended is the number of points that were stroked
+	// by StrokeAppend
+	appended int
 }
 
 type pathPoint struct {
@@ -33,6 +37,7 @@ const (
 	pathIsConvex
 	pathIsClockwise
 	pathSelfIntersects
+	pathNoCap
 )
 
 // NewPath2D creates a new Path2D and returns it
@@ -52,6 +57,11 @@ func (p *Path2D) MoveTo(x, y float64) {
 	if len(p.p) > 0 && isSamePoint(p.p[len(p.p)-1].pos, BackendVec{x, y}, 0.1) {
 		return
 	}
+	p.moveTo(x, y)
+}
+
+// moveTo starts a new sub path even if the path already ends at x/y
+func (p *Path2D) moveTo(x, y float64) {
 	p.clearCache()
 	p.p = append(p.p, pathPoint{pos: BackendVec{x, y}, flags: pathMove | pathIsConvex})
 	p.cwSum = 0
@@ -173,7 +183,11 @@ func (p *Path2D) arc(x, y, radius, startAngle, endAngle float64, anticlockwise b
 		}
 	}
 
-	const step = math.Pi * 2 / 90
+	scale := 1.0
+	if !ident {
+		scale = math.Sqrt(math.Abs(m[0]*m[3] - m[1]*m[2]))
+	}
+	step := arcStep(radius*scale, p.curveTolerance())
 	if !anticlockwise {
 		for a := startAngle; a < endAngle; a += step {
 			s, c := math.Sincos(a)
@@ -258,7 +272,7 @@ func (p *Path2D) QuadraticCurveTo(x1, y1, x2, y2 float64) {
 	v0 := p1.Sub(p0)
 	v1 := p2.Sub(p1)
 
-	const step = 0.01
+	step := curveStep(v1.Sub(v0).Len(), 2, p.curveTolerance())
 
 	for r := 0.0; r < 1; r += step {
 		i0 := v0.Mulf(r).Add(p0)
@@ -282,7 +296,8 @@ func (p *Path2D) BezierCurveTo(x1, y1, x2, y2, x3, y3 float64) {
 	v1 := p2.Sub(p1)
 	v2 := p3.Sub(p2)
 
-	const step = 0.01
+	dd := math.Max(v1.Sub(v0).Len(), v2.Sub(v1).Len())
+	step := curveStep(dd, 6, p.curveTolerance())
 
 	for r := 0.0; r < 1; r += step {
 		i0 := v0.Mulf(r).Add(p0)
@@ -339,7 +354,7 @@ func (p *Path2D) Ellipse(x, y, radiusX, radiusY, rotation, startAngle, endAngle
 		}
 	}
 
-	const step = math.Pi * 2 / 90
+	step := arcStep(math.Max(math.Abs(radiusX), math.Abs(radiusY)), p.curveTolerance())
 	if !anticlockwise {
 		for a := startAngle; a < endAngle; a += step {
 			s, c := math.Sincos(a)
@@ -421,13 +436,38 @@ func runSubPath(path []pathPoint, close bool, fn func(subPath []pathPoint) bool)
 		return fn(path)
 	}
 
-	var buf [64]pathPoint
+	scratch := getPathScratch()
 	path2 := Path2D{
-		p:    append(buf[:0], path...),
+		p:    append(scratch.buf, path...),
 		move: path[0].pos,
 	}
 	path2.lineTo(path[0].pos[0], path[0].pos[1], true)
-	return fn(path2.p)
+	stop := fn(path2.p)
+	scratch.release(path2.p)
+	return stop
+}
+
+// Polylines returns the subpaths of the path as the lines that the canvas
+// fills and strokes, with curves and arcs already flattened. Closed
+// subpaths end with their first point. The points can be used with the
+// geometry package to get the same intersections as the renderer
+func (p *Path2D) Polylines() [][]BackendVec {
+	var lines [][]BackendVec
+	start := 0
+	for i := 1; i <= len(p.p); i++ {
+		if i < len(p.p) && p.p[i].flags&pathMove == 0 {
+			continue
+		}
+		if i-start >= 2 {
+			line := make([]BackendVec, i-start)
+			for j := range line {
+				line[j] = p.p[start+j].pos
+			}
+			lines = append(lines, line)
+		}
+		start = i
+	}
+	return lines
 }
 
 type pathRule uint8
@@ -477,7 +517,7 @@ func (p *Path2D) IsPointInStroke(x, y float64) bool {
 	}
 
 	var triBuf [500]BackendVec
-	tris := p.cv.strokeTris(p, p.cv.state.transform, BackendMat{}, false, triBuf[:0])
+	tris := p.cv.strokeTris(p, p.cv.transform(), BackendMat{}, false, triBuf[:0])
 
 	pt := BackendVec{x, y}
 
@@ -2,6 +2,8 @@ package canvas
 
 import (
 	"math"
+
+	"github.com/opentoys/canvas/geometry"
 )
 
 // BeginPath clears the current path and starts a new one
@@ -10,6 +12,7 @@ func (cv *Canvas) BeginPath() {
 		cv.path.p = make([]pathPoint, 0, 100)
 	}
 	cv.path.p = cv.path.p[:0]
+	cv.path.appended = 0
 }
 
 func isSamePoint(a, b BackendVec, maxDist float64) bool {
@@ -33,9 +36,9 @@ func (cv *Canvas) LineTo(x, y float64) {
 // means that the line is added anticlockwise
 func (cv *Canvas) Arc(x, y, radius, startAngle, endAngle float64, anticlockwise bool) {
 	ax, ay := math.Sincos(startAngle)
-	startAngle2 := BackendVec{ay, ax}.MulMat2(cv.state.transform.Mat2()).Atan2()
+	startAngle2 := BackendVec{ay, ax}.MulMat2(cv.transform().Mat2()).Atan2()
 	endAngle2 := startAngle2 + (endAngle - startAngle)
-	cv.path.arc(x, y, radius, startAngle2, endAngle2, anticlockwise, cv.state.transform, false)
+	cv.path.arc(x, y, radius, startAngle2, endAngle2, anticlockwise, cv.transform(), false)
 }
 
 // ArcTo adds to the current path by drawing a line toward x1/y1 and a circle
@@ -43,7 +46,7 @@ func (cv *Canvas) Arc(x, y, radius, startAngle, endAngle float64, anticlockwise
 // lines from the end of the path to x1/y1, and from x1/y1 to x2/y2. The line
 // will only go to where the circle segment would touch the latter line
 func (cv *Canvas) ArcTo(x1, y1, x2, y2, radius float64) {
-	cv.path.arcTo(x1, y1, x2, y2, radius, cv.state.transform, false)
+	cv.path.arcTo(x1, y1, x2, y2, radius, cv.transform(), false)
 }
 
 // QuadraticCurveTo adds a quadratic curve to the path. It uses the current end
@@ -71,7 +74,7 @@ func (cv *Canvas) BezierCurveTo(x1, y1, x2, y2, x3, y3 float64) {
 func (cv *Canvas) Ellipse(x, y, radiusX, radiusY, rotation, startAngle, endAngle float64, anticlockwise bool) {
 	tf := cv.tf(BackendVec{x, y})
 	ax, ay := math.Sincos(startAngle)
-	startAngle2 := BackendVec{ay, ax}.MulMat2(cv.state.transform.Mat2()).Atan2()
+	startAngle2 := BackendVec{ay, ax}.MulMat2(cv.transform().Mat2()).Atan2()
 	endAngle2 := startAngle2 + (endAngle - startAngle)
 	cv.path.Ellipse(tf[0], tf[1], radiusX, radiusY, rotation, startAngle2, endAngle2, anticlockwise)
 }
@@ -84,7 +87,7 @@ func (cv *Canvas) ClosePath() {
 
 // Stroke uses the current StrokeStyle to draw the current path
 func (cv *Canvas) Stroke() {
-	cv.strokePath(&cv.path, cv.state.transform, cv.state.transform.Invert(), true)
+	cv.strokePath(&cv.path, cv.transform(), cv.transform().Invert(), true)
 }
 
 // StrokePath uses the current StrokeStyle to draw the given path
@@ -94,21 +97,68 @@ func (cv *Canvas) StrokePath(path *Path2D) {
 		p: make([]pathPoint, len(path.p)),
 	}
 	copy(path2.p, path.p)
-	cv.strokePath(&path2, cv.state.transform, BackendMat{}, false)
+	cv.strokePath(&path2, cv.transform(), BackendMat{}, false)
+}
+
+// StrokeAppend uses the current StrokeStyle to draw the part of the
+// current path that was added since the last call to StrokeAppend or
+// BeginPath. To get the line join right, the last segment that was
+// already drawn is drawn again together with the new segments.
+//
+// This is meant for paths that grow while the user draws them, so that
+// each frame only draws the new part instead of the whole path. Since the
+// overlapping segment is drawn twice, a translucent stroke style is
+// blended twice there, and the line dash pattern starts again with every
+// call. Round line caps and joins give the best results
+func (cv *Canvas) StrokeAppend() {
+	p := cv.path.p
+	if cv.path.appended > len(p) {
+		cv.path.appended = 0
+	}
+	if len(p) < 2 || cv.path.appended >= len(p) {
+		cv.path.appended = len(p)
+		return
+	}
+
+	from := cv.path.appended - 2
+	if from < 0 {
+		from = 0
+	}
+	noCap := from > 0 && p[from+1].flags&pathMove == 0
+	cv.path.appended = len(p)
+
+	var pbuf [50]pathPoint
+	sub := Path2D{p: append(pbuf[:0], p[from:]...)}
+	sub.p[0].flags |= pathMove
+	if noCap {
+		// the start of the overlapping segment is in the middle of
+		// the line and was drawn before
+		sub.p[0].flags |= pathNoCap
+	}
+	cv.strokePath(&sub, cv.transform(), cv.transform().Invert(), true)
 }
 
 func (cv *Canvas) strokePath(path *Path2D, tf BackendMat, inv BackendMat, doInv bool) {
 	if len(path.p) == 0 {
 		return
 	}
+	if cv.strokeAligned(path, tf, inv, doInv) {
+		return
+	}
+	if cv.strokeHairlines(path, tf) {
+		return
+	}
 
-	var triBuf [500]BackendVec
-	tris := cv.strokeTris(path, tf, inv, doInv, triBuf[:0])
+	scratch := getVecScratch(0)
+	tris := cv.strokeTris(path, tf, inv, doInv, scratch.buf)
+	defer scratch.release(tris)
 
 	cv.drawShadow(tris, nil, true)
 
 	stl := cv.backendFillStyle(&cv.state.stroke, 1)
-	cv.b.Fill(&stl, tris, BackendMatIdentity, true)
+	cv.fill(&stl, tris, BackendMatIdentity, true)
+
+	cv.drawInsetShadow(tris, nil)
 }
 
 func (cv *Canvas) strokeTris(path *Path2D, tf BackendMat, inv BackendMat, doInv bool, target []BackendVec) []BackendVec {
@@ -135,12 +185,13 @@ func (cv *Canvas) strokeTris(path *Path2D, tf BackendMat, inv BackendMat, doInv
 
 	dashedPath := cv.applyLineDash(path.p)
 
-	start := true
+	start, startCap := true, true
 	var p0 BackendVec
 	for _, p := range dashedPath {
 		if p.flags&pathMove != 0 {
 			p0 = p.pos
 			start = true
+			startCap = p.flags&pathNoCap == 0
 			continue
 		}
 		p1 := p.pos
@@ -154,7 +205,7 @@ func (cv *Canvas) strokeTris(path *Path2D, tf BackendMat, inv BackendMat, doInv
 		lp2 := p0.Sub(v1)
 		lp3 := p1.Sub(v1)
 
-		if start {
+		if start && startCap {
 			switch cv.state.lineCap {
 			case Butt:
 				// no need to do anything
@@ -333,25 +384,8 @@ func (cv *Canvas) addCircleTris(center BackendVec, radius float64, tf BackendMat
 }
 
 func lineIntersection(a0, a1, b0, b1 BackendVec) (BackendVec, float64, float64) {
-	va := a1.Sub(a0)
-	vb := b1.Sub(b0)
-
-	if (va[0] == 0 && vb[0] == 0) || (va[1] == 0 && vb[1] == 0) || (va[0] == 0 && va[1] == 0) || (vb[0] == 0 && vb[1] == 0) {
-		return BackendVec{}, float64(math.Inf(1)), float64(math.Inf(1))
-	}
-	d := va[1]*vb[0] - va[0]*vb[1]
-	if d == 0 {
-		return BackendVec{}, float64(math.Inf(1)), float64(math.Inf(1))
-	}
-	p := (vb[1]*(a0[0]-b0[0]) - a0[1]*vb[0] + b0[1]*vb[0]) / d
-	var q float64
-	if vb[0] == 0 {
-		q = (a0[1] + p*va[1] - b0[1]) / vb[1]
-	} else {
-		q = (a0[0] + p*va[0] - b0[0]) / vb[0]
-	}
-
-	return a0.Add(va.Mulf(p)), p, q
+	p, ta, tb := geometry.LineIntersection(geometry.Point(a0), geometry.Point(a1), geometry.Point(b0), geometry.Point(b1))
+	return BackendVec(p), ta, tb
 }
 
 func linePointDistSqr(a, b, p BackendVec) float64 {
@@ -370,24 +404,25 @@ func (cv *Canvas) Fill() {
 
 // FillPath fills the given path with the current FillStyle
 func (cv *Canvas) FillPath(path *Path2D) {
-	cv.fillPath(path, cv.state.transform)
+	cv.fillPath(path, cv.transform())
 }
 
 // FillPath fills the given path with the current FillStyle
 func (cv *Canvas) fillPath(path *Path2D, tf BackendMat) {
-	if len(path.p) < 3 {
+	if len(path.p) < 3 || cv.culledWithShadow(pathBounds(path.p, tf)) {
 		return
 	}
 
 	var tris []BackendVec
-	var triBuf [500]BackendVec
 	if path.standalone && path.fillCache != nil {
 		tris = path.fillCache
 	} else {
 		if path.standalone {
 			tris = make([]BackendVec, 0, 500)
 		} else {
-			tris = triBuf[:0]
+			scratch := getVecScratch(0)
+			tris = scratch.buf
+			defer func() { scratch.release(tris) }()
 		}
 		runSubPaths(path.p, true, func(sp []pathPoint) bool {
 			tris = appendSubPathTriangles(tris, BackendMatIdentity, sp)
@@ -402,10 +437,13 @@ func (cv *Canvas) fillPath(path *Path2D, tf BackendMat) {
 		return
 	}
 
-	cv.drawShadow(tris, nil, false)
+	shadowTris := cv.shadowPts(tris, tf)
+	cv.drawShadow(shadowTris, nil, false)
 
 	stl := cv.backendFillStyle(&cv.state.fill, 1)
-	cv.b.Fill(&stl, tris, tf, false)
+	cv.fill(&stl, tris, tf, false)
+
+	cv.drawInsetShadow(shadowTris, nil)
 }
 
 func appendSubPathTriangles(tris []BackendVec, mat BackendMat, path []pathPoint) []BackendVec {
@@ -453,6 +491,7 @@ func (cv *Canvas) clip(path *Path2D, tf BackendMat) {
 		for i := range quad {
 			quad[i] = path.p[i].pos
 		}
+		cv.Flush()
 		cv.b.Clip(quad)
 		return
 	}
@@ -469,6 +508,7 @@ func (cv *Canvas) clip(path *Path2D, tf BackendMat) {
 	cv.state.clip.p = make([]pathPoint, len(path.p))
 	copy(cv.state.clip.p, path.p)
 
+	cv.Flush()
 	cv.b.Clip(tris)
 }
 
@@ -499,7 +539,7 @@ func (cv *Canvas) StrokeRect(x, y, w, h float64) {
 	p[3] = pathPoint{pos: v3, next: v0, flags: pathAttach}
 	p[4] = pathPoint{pos: v0, next: v1, flags: pathAttach}
 	path := Path2D{p: p[:]}
-	cv.strokePath(&path, cv.state.transform, BackendMat{}, false)
+	cv.strokePath(&path, cv.transform(), BackendMat{}, false)
 }
 
 // FillRect fills a rectangle with the active fill style
@@ -511,10 +551,19 @@ func (cv *Canvas) FillRect(x, y, w, h float64) {
 
 	data := [4]BackendVec{{p0[0], p0[1]}, {p1[0], p1[1]}, {p2[0], p2[1]}, {p3[0], p3[1]}}
 
+	if cv.culledWithShadow(BoundsOf(data[:])) {
+		return
+	}
+	if cv.fillPixelRect(data) {
+		return
+	}
+
 	cv.drawShadow(data[:], nil, false)
 
 	stl := cv.backendFillStyle(&cv.state.fill, 1)
-	cv.b.Fill(&stl, data[:], BackendMatIdentity, false)
+	cv.fill(&stl, data[:], BackendMatIdentity, false)
+
+	cv.drawInsetShadow(data[:], nil)
 }
 
 // ClearRect sets the color of the rectangle to transparent black
@@ -525,5 +574,10 @@ func (cv *Canvas) ClearRect(x, y, w, h float64) {
 	p3 := cv.tf(BackendVec{x + w, y})
 	data := [4]BackendVec{{p0[0], p0[1]}, {p1[0], p1[1]}, {p2[0], p2[1]}, {p3[0], p3[1]}}
 
+	cv.Flush()
+	cv.countQuad(data)
+	if cv.clearPixelRect(data) {
+		return
+	}
 	cv.b.Clear(data)
 }
@@ -0,0 +1,233 @@
+package canvas
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// SetPerspectiveTransform projects everything that is drawn after the call
+// with the 3x3 matrix, which maps x/y to
+//
+//	x' = (m[0]*x + m[1]*y + m[2]) / (m[6]*x + m[7]*y + m[8])
+//	y' = (m[3]*x + m[4]*y + m[5]) / (m[6]*x + m[7]*y + m[8])
+//
+// The projection is applied after the transformation and the view, to the
+// corners of the shapes and images, so lines stay straight and images are
+// mapped with correct perspective. Gradients, patterns, shadows and line
+// widths are not foreshortened, and the clipping region and hit regions
+// stay in the coordinates before the projection. The projection must not
+// move any point behind the viewer, where the divisor is zero or negative.
+// PerspectiveFromQuads creates a matrix from the corners of two quads
+func (cv *Canvas) SetPerspectiveTransform(m [9]float64) {
+	cv.Flush()
+	cv.b = &perspectiveBackend{target: cv.backend(), m: m}
+}
+
+// ClearPerspectiveTransform removes the projection set with
+// SetPerspectiveTransform
+func (cv *Canvas) ClearPerspectiveTransform() {
+	cv.Flush()
+	cv.b = cv.backend()
+}
+
+// backend returns the backend of the canvas without the projection
+func (cv *Canvas) backend() Backend {
+	if pb, ok := cv.b.(*perspectiveBackend); ok {
+		return pb.target
+	}
+	return cv.b
+}
+
+// PerspectiveFromQuads returns the projective matrix that maps the four
+// corners of src to the four corners of dst, for use with
+// SetPerspectiveTransform. It returns false if three of the corners of
+// either quad are on a line
+func PerspectiveFromQuads(src, dst [4]BackendVec) ([9]float64, bool) {
+	s, ok := squareToQuad(src)
+	if !ok {
+		return [9]float64{}, false
+	}
+	sinv, ok := invert3(s)
+	if !ok {
+		return [9]float64{}, false
+	}
+	d, ok := squareToQuad(dst)
+	if !ok {
+		return [9]float64{}, false
+	}
+	return mul3(d, sinv), true
+}
+
+// squareToQuad returns the projective mapping from the unit square to the
+// quad, where 0/0 maps to quad[0], 0/1 to quad[1], 1/1 to quad[2] and 1/0
+// to quad[3], like the texture coordinates of a quad. See Heckbert,
+// Fundamentals of Texture Mapping and Image Warping
+func squareToQuad(quad [4]BackendVec) ([9]float64, bool) {
+	x0, y0 := quad[0][0], quad[0][1]
+	x1, y1 := quad[3][0], quad[3][1]
+	x2, y2 := quad[2][0], quad[2][1]
+	x3, y3 := quad[1][0], quad[1][1]
+
+	dx3, dy3 := x0-x1+x2-x3, y0-y1+y2-y3
+	if dx3 == 0 && dy3 == 0 {
+		m := [9]float64{x1 - x0, x3 - x0, x0, y1 - y0, y3 - y0, y0, 0, 0, 1}
+		return m, m[0]*m[4]-m[1]*m[3] != 0
+	}
+	dx1, dy1 := x1-x2, y1-y2
+	dx2, dy2 := x3-x2, y3-y2
+	den := dx1*dy2 - dx2*dy1
+	if den == 0 {
+		return [9]float64{}, false
+	}
+	g := (dx3*dy2 - dx2*dy3) / den
+	h := (dx1*dy3 - dx3*dy1) / den
+	return [9]float64{
+		x1 - x0 + g*x1, x3 - x0 + h*x3, x0,
+		y1 - y0 + g*y1, y3 - y0 + h*y3, y0,
+		g, h, 1,
+	}, true
+}
+
+// isParallelogram returns true if the quad is a parallelogram, as all
+// quads are that are transformed with an affine matrix
+func isParallelogram(quad [4]BackendVec) bool {
+	dx := quad[0][0] + quad[2][0] - quad[1][0] - quad[3][0]
+	dy := quad[0][1] + quad[2][1] - quad[1][1] - quad[3][1]
+	return math.Abs(dx) < 1e-6 && math.Abs(dy) < 1e-6
+}
+
+func mul3(a, b [9]float64) [9]float64 {
+	var m [9]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			m[i*3+j] = a[i*3]*b[j] + a[i*3+1]*b[3+j] + a[i*3+2]*b[6+j]
+		}
+	}
+	return m
+}
+
+func invert3(m [9]float64) ([9]float64, bool) {
+	c0 := m[4]*m[8] - m[5]*m[7]
+	c1 := m[5]*m[6] - m[3]*m[8]
+	c2 := m[3]*m[7] - m[4]*m[6]
+	det := m[0]*c0 + m[1]*c1 + m[2]*c2
+	if det == 0 {
+		return [9]float64{}, false
+	}
+	id := 1 / det
+	return [9]float64{
+		c0 * id, (m[2]*m[7] - m[1]*m[8]) * id, (m[1]*m[5] - m[2]*m[4]) * id,
+		c1 * id, (m[0]*m[8] - m[2]*m[6]) * id, (m[2]*m[3] - m[0]*m[5]) * id,
+		c2 * id, (m[1]*m[6] - m[0]*m[7]) * id, (m[0]*m[4] - m[1]*m[3]) * id,
+	}, true
+}
+
+// perspectiveBackend projects all points that are passed to the target
+// backend. Backends map the images of quads that are no longer
+// parallelograms with the projective mapping
+type perspectiveBackend struct {
+	target Backend
+	m      [9]float64
+	buf    []BackendVec
+}
+
+func (pb *perspectiveBackend) project(v BackendVec) BackendVec {
+	m := &pb.m
+	w := m[6]*v[0] + m[7]*v[1] + m[8]
+	if w < 1e-9 {
+		w = 1e-9
+	}
+	return BackendVec{
+		(m[0]*v[0] + m[1]*v[1] + m[2]) / w,
+		(m[3]*v[0] + m[4]*v[1] + m[5]) / w,
+	}
+}
+
+func (pb *perspectiveBackend) projectAll(pts []BackendVec, tf BackendMat) []BackendVec {
+	pb.buf = pb.buf[:0]
+	for _, pt := range pts {
+		pb.buf = append(pb.buf, pb.project(pt.MulMat(tf)))
+	}
+	return pb.buf
+}
+
+func (pb *perspectiveBackend) projectQuad(pts [4]BackendVec) [4]BackendVec {
+	for i, pt := range pts {
+		pts[i] = pb.project(pt)
+	}
+	return pts
+}
+
+func (pb *perspectiveBackend) Size() (int, int) { return pb.target.Size() }
+
+func (pb *perspectiveBackend) LoadImage(img image.Image) (BackendImage, error) {
+	return pb.target.LoadImage(img)
+}
+
+func (pb *perspectiveBackend) LoadImageMipmap(img image.Image, quality MipmapQuality) (BackendImage, error) {
+	return loadImageMipmap(pb.target, img, quality)
+}
+
+func (pb *perspectiveBackend) LoadImageYUV(y, u, v []byte, format YUVFormat) (BackendImage, error) {
+	return loadImageYUV(pb.target, y, u, v, format)
+}
+
+func (pb *perspectiveBackend) LoadImagePattern(data BackendImagePatternData) BackendImagePattern {
+	return pb.target.LoadImagePattern(data)
+}
+
+func (pb *perspectiveBackend) LoadLinearGradient(data BackendGradient) BackendLinearGradient {
+	return pb.target.LoadLinearGradient(data)
+}
+
+func (pb *perspectiveBackend) LoadRadialGradient(data BackendGradient) BackendRadialGradient {
+	return pb.target.LoadRadialGradient(data)
+}
+
+func (pb *perspectiveBackend) Clear(pts [4]BackendVec) {
+	pb.target.Clear(pb.projectQuad(pts))
+}
+
+func (pb *perspectiveBackend) Fill(style *BackendFillStyle, pts []BackendVec, tf BackendMat, canOverlap bool) {
+	pb.target.Fill(style, pb.projectAll(pts, tf), BackendMatIdentity, canOverlap)
+}
+
+func (pb *perspectiveBackend) DrawImage(dimg BackendImage, sx, sy, sw, sh float64, pts [4]BackendVec, alpha float64) {
+	pb.target.DrawImage(dimg, sx, sy, sw, sh, pb.projectQuad(pts), alpha)
+}
+
+func (pb *perspectiveBackend) FillImageMask(style *BackendFillStyle, mask *image.Alpha, pts [4]BackendVec) {
+	pb.target.FillImageMask(style, mask, pb.projectQuad(pts))
+}
+
+func (pb *perspectiveBackend) FillTrianglesVertexColor(pts []BackendVec, colors []color.RGBA) {
+	pb.target.FillTrianglesVertexColor(pb.projectAll(pts, BackendMatIdentity), colors)
+}
+
+func (pb *perspectiveBackend) ClearClip() { pb.target.ClearClip() }
+
+func (pb *perspectiveBackend) Clip(pts []BackendVec) {
+	pb.target.Clip(pb.projectAll(pts, BackendMatIdentity))
+}
+
+func (pb *perspectiveBackend) GetImageData(x, y, w, h int) *image.RGBA {
+	return pb.target.GetImageData(x, y, w, h)
+}
+
+func (pb *perspectiveBackend) GetImageDataInto(dst []byte, x, y, w, h, stride int) {
+	getImageDataInto(pb.target, dst, x, y, w, h, stride)
+}
+
+func (pb *perspectiveBackend) GetImageDataAsync(x, y, w, h int) <-chan *image.RGBA {
+	return getImageDataAsync(pb.target, x, y, w, h)
+}
+
+func (pb *perspectiveBackend) PutImageData(img *image.RGBA, x, y int) {
+	pb.target.PutImageData(img, x, y)
+}
+
+func (pb *perspectiveBackend) CanUseAsImage(b Backend) bool { return pb.target.CanUseAsImage(b) }
+func (pb *perspectiveBackend) AsImage() BackendImage        { return pb.target.AsImage() }
+
+func (pb *perspectiveBackend) Capabilities() BackendCapabilities { return pb.target.Capabilities() }
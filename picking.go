@@ -0,0 +1,291 @@
+package canvas
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// ObjectIDBackend is implemented by backends that keep an object ID for
+// every pixel, see PickingBackend
+type ObjectIDBackend interface {
+	// SetObjectID sets the ID that the following drawing calls write
+	SetObjectID(id uint32)
+	// ReadID returns the ID of the last drawing call that covered the
+	// pixel at x/y, or 0
+	ReadID(x, y int) uint32
+}
+
+// PickingBackend passes all calls to a target backend and also writes
+// the current object ID to a buffer of the same size for every pixel a
+// call covers. Unlike hit testing the colors, this finds the topmost
+// object regardless of how translucent it is, as GPU picking does.
+// Pixels of images and masks are covered if they are not fully
+// transparent, shadows are not covered, and the clipping region is
+// applied. Clear and drawing with ID 0 reset the pixels to 0
+type PickingBackend struct {
+	target Backend
+	id     uint32
+	// paused stops IDs from being written while shadows are drawn
+	paused bool
+
+	w, h     int
+	ids      []uint32
+	coverage *SoftwareBackend
+	white    []color.RGBA
+}
+
+// NewPickingBackend creates a new picking backend for the given target
+func NewPickingBackend(target Backend) *PickingBackend {
+	return &PickingBackend{target: target}
+}
+
+// Target returns the backend that the calls are passed to
+func (pb *PickingBackend) Target() Backend { return pb.target }
+
+// SetObjectID sets the ID that the following drawing calls write
+func (pb *PickingBackend) SetObjectID(id uint32) { pb.id = id }
+
+// ReadID returns the ID of the last drawing call that covered the pixel
+// at x/y, or 0
+func (pb *PickingBackend) ReadID(x, y int) uint32 {
+	if x < 0 || y < 0 || x >= pb.w || y >= pb.h || len(pb.ids) != pb.w*pb.h {
+		return 0
+	}
+	return pb.ids[y*pb.w+x]
+}
+
+// ClearIDs resets the IDs of all pixels to 0
+func (pb *PickingBackend) ClearIDs() {
+	for i := range pb.ids {
+		pb.ids[i] = 0
+	}
+}
+
+// ensure resizes the buffers to the size of the target
+func (pb *PickingBackend) ensure() {
+	w, h := pb.target.Size()
+	if w != pb.w || h != pb.h || pb.coverage == nil {
+		pb.w, pb.h = w, h
+		pb.ids = make([]uint32, w*h)
+		pb.coverage = NewBackend(w, h)
+	}
+}
+
+// begin prepares the coverage backend for a call and returns the pixel
+// rectangle that it can draw to
+func (pb *PickingBackend) begin(b Bounds) image.Rectangle {
+	pb.ensure()
+	if b.MinX > b.MaxX || b.MinY > b.MaxY {
+		return image.Rectangle{}
+	}
+	w, h := pb.w, pb.h
+	r := image.Rect(
+		int(math.Floor(b.MinX)), int(math.Floor(b.MinY)),
+		int(math.Ceil(b.MaxX)), int(math.Ceil(b.MaxY)),
+	).Intersect(image.Rect(0, 0, w, h))
+	img := pb.coverage.Image
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(r.Min.X, y):img.PixOffset(r.Max.X, y)]
+		for i := range row {
+			row[i] = 0
+		}
+	}
+	return r
+}
+
+// end writes the current ID to all pixels inside of r that the coverage
+// backend drew to
+func (pb *PickingBackend) end(r image.Rectangle) {
+	img := pb.coverage.Image
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(r.Min.X, y):]
+		ids := pb.ids[y*pb.w+r.Min.X : y*pb.w+r.Max.X]
+		for i := range ids {
+			if row[i*4+3] != 0 {
+				ids[i] = pb.id
+			}
+		}
+	}
+}
+
+func (pb *PickingBackend) coverQuad(pts [4]BackendVec) {
+	if pb.paused {
+		return
+	}
+	r := pb.begin(BoundsOf(pts[:]))
+	style := BackendFillStyle{Color: color.RGBA{255, 255, 255, 255}}
+	pb.coverage.Fill(&style, pts[:], BackendMatIdentity, false)
+	pb.end(r)
+}
+
+func (pb *PickingBackend) Size() (int, int) { return pb.target.Size() }
+
+func (pb *PickingBackend) LoadImage(img image.Image) (BackendImage, error) {
+	return pb.target.LoadImage(img)
+}
+
+func (pb *PickingBackend) LoadImageMipmap(img image.Image, quality MipmapQuality) (BackendImage, error) {
+	return loadImageMipmap(pb.target, img, quality)
+}
+
+func (pb *PickingBackend) LoadImageYUV(y, u, v []byte, format YUVFormat) (BackendImage, error) {
+	return loadImageYUV(pb.target, y, u, v, format)
+}
+
+func (pb *PickingBackend) LoadImagePattern(data BackendImagePatternData) BackendImagePattern {
+	return pb.target.LoadImagePattern(data)
+}
+
+func (pb *PickingBackend) LoadLinearGradient(data BackendGradient) BackendLinearGradient {
+	return pb.target.LoadLinearGradient(data)
+}
+
+func (pb *PickingBackend) LoadRadialGradient(data BackendGradient) BackendRadialGradient {
+	return pb.target.LoadRadialGradient(data)
+}
+
+func (pb *PickingBackend) Clear(pts [4]BackendVec) {
+	id := pb.id
+	pb.id = 0
+	pb.coverQuad(pts)
+	pb.id = id
+	pb.target.Clear(pts)
+}
+
+func (pb *PickingBackend) Fill(style *BackendFillStyle, pts []BackendVec, tf BackendMat, canOverlap bool) {
+	if style.Blur == 0 && !pb.paused {
+		b := EmptyBounds
+		for _, pt := range pts {
+			pt = pt.MulMat(tf)
+			b = b.Union(Bounds{MinX: pt[0], MinY: pt[1], MaxX: pt[0], MaxY: pt[1]})
+		}
+		r := pb.begin(b)
+		cover := BackendFillStyle{Color: color.RGBA{255, 255, 255, 255}}
+		pb.coverage.Fill(&cover, pts, tf, canOverlap)
+		pb.end(r)
+	}
+	pb.target.Fill(style, pts, tf, canOverlap)
+}
+
+func (pb *PickingBackend) DrawImage(dimg BackendImage, sx, sy, sw, sh float64, pts [4]BackendVec, alpha float64) {
+	if simg, ok := dimg.(*SoftwareImage); ok && !pb.paused {
+		r := pb.begin(BoundsOf(pts[:]))
+		pb.coverage.DrawImage(simg, sx, sy, sw, sh, pts, 1)
+		pb.end(r)
+	} else if !ok {
+		pb.coverQuad(pts)
+	}
+	pb.target.DrawImage(dimg, sx, sy, sw, sh, pts, alpha)
+}
+
+func (pb *PickingBackend) FillImageMask(style *BackendFillStyle, mask *image.Alpha, pts [4]BackendVec) {
+	if style.Blur == 0 && !pb.paused {
+		r := pb.begin(BoundsOf(pts[:]))
+		cover := BackendFillStyle{Color: color.RGBA{255, 255, 255, 255}}
+		pb.coverage.FillImageMask(&cover, mask, pts)
+		pb.end(r)
+	}
+	pb.target.FillImageMask(style, mask, pts)
+}
+
+func (pb *PickingBackend) FillTrianglesVertexColor(pts []BackendVec, colors []color.RGBA) {
+	if !pb.paused {
+		r := pb.begin(BoundsOf(pts))
+		pb.white = pb.white[:0]
+		for range colors {
+			pb.white = append(pb.white, color.RGBA{255, 255, 255, 255})
+		}
+		pb.coverage.FillTrianglesVertexColor(pts, pb.white)
+		pb.end(r)
+	}
+	pb.target.FillTrianglesVertexColor(pts, colors)
+}
+
+func (pb *PickingBackend) ClearClip() {
+	if pb.coverage != nil {
+		pb.coverage.ClearClip()
+	}
+	pb.target.ClearClip()
+}
+
+func (pb *PickingBackend) Clip(pts []BackendVec) {
+	pb.ensure()
+	pb.coverage.Clip(pts)
+	pb.target.Clip(pts)
+}
+
+func (pb *PickingBackend) GetImageData(x, y, w, h int) *image.RGBA {
+	return pb.target.GetImageData(x, y, w, h)
+}
+
+func (pb *PickingBackend) GetImageDataInto(dst []byte, x, y, w, h, stride int) {
+	getImageDataInto(pb.target, dst, x, y, w, h, stride)
+}
+
+func (pb *PickingBackend) GetImageDataAsync(x, y, w, h int) <-chan *image.RGBA {
+	return getImageDataAsync(pb.target, x, y, w, h)
+}
+
+func (pb *PickingBackend) BeginFrame() { beginFrame(pb.target) }
+func (pb *PickingBackend) EndFrame()   { endFrame(pb.target) }
+
+func (pb *PickingBackend) PutImageData(img *image.RGBA, x, y int) {
+	pb.ensure()
+	// like the pixels, the IDs are replaced regardless of the clipping
+	r := image.Rect(x, y, x+img.Rect.Dx(), y+img.Rect.Dy()).Intersect(image.Rect(0, 0, pb.w, pb.h))
+	for py := r.Min.Y; py < r.Max.Y; py++ {
+		ids := pb.ids[py*pb.w+r.Min.X : py*pb.w+r.Max.X]
+		for i := range ids {
+			ids[i] = pb.id
+		}
+	}
+	pb.target.PutImageData(img, x, y)
+}
+
+func (pb *PickingBackend) CanUseAsImage(b Backend) bool { return pb.target.CanUseAsImage(b) }
+func (pb *PickingBackend) AsImage() BackendImage        { return pb.target.AsImage() }
+
+func (pb *PickingBackend) Capabilities() BackendCapabilities { return pb.target.Capabilities() }
+
+// SetObjectID sets the object ID that the following drawing calls write
+// to the picking buffer of the backend, see PickingBackend. ID 0 marks
+// pixels that don't belong to an object. Backends without a picking
+// buffer ignore the ID
+func (cv *Canvas) SetObjectID(id uint32) {
+	if ob, ok := cv.backend().(ObjectIDBackend); ok {
+		cv.Flush()
+		ob.SetObjectID(id)
+	}
+}
+
+// pausePicking stops a picking backend from writing IDs for the shadows
+// that are drawn until resumePicking is called. Queued fills
+// are flushed first so that they still get their ID
+func (cv *Canvas) pausePicking() *PickingBackend {
+	pb, ok := cv.backend().(*PickingBackend)
+	if !ok {
+		return nil
+	}
+	cv.Flush()
+	pb.paused = true
+	return pb
+}
+
+// resumePicking lets the backend returned by pausePicking write IDs again
+func (cv *Canvas) resumePicking(pb *PickingBackend) {
+	if pb != nil {
+		cv.Flush()
+		pb.paused = false
+	}
+}
+
+// ObjectIDAt returns the ID of the topmost object at the pixel x/y, or 0
+// if there is none or the backend has no picking buffer
+func (cv *Canvas) ObjectIDAt(x, y int) uint32 {
+	if ob, ok := cv.backend().(ObjectIDBackend); ok {
+		cv.Flush()
+		return ob.ReadID(x, y)
+	}
+	return 0
+}
@@ -0,0 +1,191 @@
+package canvas
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// PixelBufferBackend is implemented by backends that keep the canvas
+// content in memory. The pixel helpers of the canvas work on the buffer
+// in place instead of copying it with GetImageData and PutImageData.
+// Like GetImageData, the buffer holds straight alpha colors
+type PixelBufferBackend interface {
+	PixelBuffer() *image.RGBA
+}
+
+// PixelBuffer returns the image that the backend draws to. Writing to
+// it directly bypasses the copying of snapshots
+func (b *SoftwareBackend) PixelBuffer() *image.RGBA { return b.Image }
+
+// editPixels calls fn with the part of the canvas content inside of
+// rect. The image passed to fn uses canvas coordinates. If the backend
+// has no pixel buffer, fn gets a copy, which is only written back if
+// write is set. Like PutImageData, this ignores the clipping region
+func (cv *Canvas) editPixels(rect image.Rectangle, write bool, fn func(img *image.RGBA)) {
+	cv.Flush()
+	w, h := cv.b.Size()
+	rect = rect.Canon().Intersect(image.Rect(0, 0, w, h))
+	if rect.Empty() {
+		return
+	}
+	if write {
+		cv.stats.changes++
+	}
+	if pb, ok := cv.backend().(PixelBufferBackend); ok {
+		if sb, ok := pb.(*SoftwareBackend); ok && write {
+			sb.touch(rect)
+		}
+		buf := pb.PixelBuffer()
+		fn(buf.SubImage(rect.Add(buf.Rect.Min)).(*image.RGBA))
+		return
+	}
+
+	data := cv.b.GetImageData(rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
+	img := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	for y := 0; y < img.Rect.Dy(); y++ {
+		copy(img.Pix[y*img.Stride:(y+1)*img.Stride], data.Pix[data.PixOffset(data.Rect.Min.X, data.Rect.Min.Y+y):])
+	}
+	view := *img
+	view.Rect = rect
+	fn(&view)
+	if write {
+		cv.b.PutImageData(img, rect.Min.X, rect.Min.Y)
+	}
+}
+
+// MapPixels replaces every pixel inside of rect with the result of fn,
+// which gets the position and the current color of the pixel. The
+// colors are straight alpha, like with GetImageData. Like PutImageData,
+// this ignores the clipping region
+func (cv *Canvas) MapPixels(rect image.Rectangle, fn func(x, y int, c color.RGBA) color.RGBA) {
+	cv.editPixels(rect, true, func(img *image.RGBA) {
+		for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+			row := img.Pix[img.PixOffset(img.Rect.Min.X, y):]
+			for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+				p := row[(x-img.Rect.Min.X)*4 : (x-img.Rect.Min.X)*4+4 : (x-img.Rect.Min.X)*4+4]
+				c := fn(x, y, color.RGBA{R: p[0], G: p[1], B: p[2], A: p[3]})
+				p[0], p[1], p[2], p[3] = c.R, c.G, c.B, c.A
+			}
+		}
+	})
+}
+
+// MapPixelsMask calls fn for every pixel that the mask covers when it is
+// stretched to the rectangle at x/y with the size w/h, like with
+// FillWithMask, and replaces the pixel with the result. fn gets the
+// current straight alpha color and the coverage of the mask, which
+// allows blending masks in ways that fill styles can't, such as
+// erasing. Like PutImageData, this ignores the clipping region
+func (cv *Canvas) MapPixelsMask(mask *image.Alpha, x, y, w, h float64, fn func(c color.RGBA, coverage uint8) color.RGBA) {
+	mw, mh := mask.Rect.Dx(), mask.Rect.Dy()
+	if mw == 0 || mh == 0 || w == 0 || h == 0 {
+		return
+	}
+	quad := [4]BackendVec{
+		cv.tf(BackendVec{x, y}),
+		cv.tf(BackendVec{x, y + h}),
+		cv.tf(BackendVec{x + w, y + h}),
+		cv.tf(BackendVec{x + w, y}),
+	}
+	b := BoundsOf(quad[:])
+	rect := image.Rect(int(math.Floor(b.MinX)), int(math.Floor(b.MinY)), int(math.Ceil(b.MaxX)), int(math.Ceil(b.MaxY)))
+	inv := cv.transform().Invert()
+
+	cv.editPixels(rect, true, func(img *image.RGBA) {
+		for py := img.Rect.Min.Y; py < img.Rect.Max.Y; py++ {
+			row := img.Pix[img.PixOffset(img.Rect.Min.X, py):]
+			for px := img.Rect.Min.X; px < img.Rect.Max.X; px++ {
+				p := BackendVec{float64(px) + 0.5, float64(py) + 0.5}.MulMat(inv)
+				mx := int(math.Floor((p[0] - x) / w * float64(mw)))
+				my := int(math.Floor((p[1] - y) / h * float64(mh)))
+				if mx < 0 || my < 0 || mx >= mw || my >= mh {
+					continue
+				}
+				cov := mask.Pix[mask.PixOffset(mask.Rect.Min.X+mx, mask.Rect.Min.Y+my)]
+				if cov == 0 {
+					continue
+				}
+				i := (px - img.Rect.Min.X) * 4
+				c := fn(color.RGBA{R: row[i], G: row[i+1], B: row[i+2], A: row[i+3]}, cov)
+				row[i], row[i+1], row[i+2], row[i+3] = c.R, c.G, c.B, c.A
+			}
+		}
+	})
+}
+
+// Histogram counts how many pixels have each value, per channel
+type Histogram struct {
+	R, G, B, A [256]int
+}
+
+// Histogram returns the histogram of the pixels inside of rect
+func (cv *Canvas) Histogram(rect image.Rectangle) *Histogram {
+	hist := &Histogram{}
+	cv.editPixels(rect, false, func(img *image.RGBA) {
+		n := img.Rect.Dx() * 4
+		for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+			row := img.Pix[img.PixOffset(img.Rect.Min.X, y):][:n]
+			for i := 0; i < n; i += 4 {
+				hist.R[row[i]]++
+				hist.G[row[i+1]]++
+				hist.B[row[i+2]]++
+				hist.A[row[i+3]]++
+			}
+		}
+	})
+	return hist
+}
+
+// SplitChannels returns the four channels of the pixels inside of rect
+// as separate images, which start at 0/0
+func (cv *Canvas) SplitChannels(rect image.Rectangle) (r, g, b, a *image.Alpha) {
+	var channels [4]*image.Alpha
+	cv.editPixels(rect, false, func(img *image.RGBA) {
+		w, h := img.Rect.Dx(), img.Rect.Dy()
+		for i := range channels {
+			channels[i] = image.NewAlpha(image.Rect(0, 0, w, h))
+		}
+		for y := 0; y < h; y++ {
+			row := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+			for x := 0; x < w; x++ {
+				for i, ch := range channels {
+					ch.Pix[y*ch.Stride+x] = row[x*4+i]
+				}
+			}
+		}
+	})
+	return channels[0], channels[1], channels[2], channels[3]
+}
+
+// MergeChannels writes the channel images into the canvas with their
+// top left corner at x/y. A nil image leaves that channel unchanged.
+// Like PutImageData, this ignores the clipping region
+func (cv *Canvas) MergeChannels(r, g, b, a *image.Alpha, x, y int) {
+	channels := [4]*image.Alpha{r, g, b, a}
+	var rect image.Rectangle
+	for _, ch := range channels {
+		if ch != nil {
+			rect = rect.Union(image.Rect(0, 0, ch.Rect.Dx(), ch.Rect.Dy()))
+		}
+	}
+	rect = rect.Add(image.Pt(x, y))
+	cv.editPixels(rect, true, func(img *image.RGBA) {
+		for py := img.Rect.Min.Y; py < img.Rect.Max.Y; py++ {
+			row := img.Pix[img.PixOffset(img.Rect.Min.X, py):]
+			for i, ch := range channels {
+				if ch == nil {
+					continue
+				}
+				cy := py - y
+				if cy >= ch.Rect.Dy() {
+					continue
+				}
+				src := ch.Pix[ch.PixOffset(ch.Rect.Min.X, ch.Rect.Min.Y+cy):]
+				for px := img.Rect.Min.X; px < img.Rect.Max.X && px-x < ch.Rect.Dx(); px++ {
+					row[(px-img.Rect.Min.X)*4+i] = src[px-x]
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package canvas
+
+// FillPolygon fills the polygon through the points with the current
+// fill style. It is the same as filling a closed path of MoveTo and
+// LineTo calls, but the current path is not changed
+func (cv *Canvas) FillPolygon(pts []BackendVec) {
+	cv.fillPath(cv.polygonPath(true, pts), cv.transform())
+}
+
+// FillPolygons fills all of the polygons with a single draw call. They
+// are filled as one path, so where they overlap the fill style is only
+// blended once
+func (cv *Canvas) FillPolygons(polygons [][]BackendVec) {
+	cv.fillPath(cv.polygonPath(true, polygons...), cv.transform())
+}
+
+// StrokePolyline draws the lines through the points with the current
+// stroke style. It is the same as stroking a path of MoveTo and LineTo
+// calls, but the current path is not changed
+func (cv *Canvas) StrokePolyline(pts []BackendVec) {
+	cv.strokePath(cv.polygonPath(false, pts), cv.transform(), BackendMat{}, false)
+}
+
+// StrokePolylines draws all of the polylines with a single draw call
+func (cv *Canvas) StrokePolylines(polylines [][]BackendVec) {
+	cv.strokePath(cv.polygonPath(false, polylines...), cv.transform(), BackendMat{}, false)
+}
+
+// polygonPath returns a path with a sub path for each shape, closed if
+// closed is set. Every shape starts a new sub path, even if it starts
+// where the previous one ended
+func (cv *Canvas) polygonPath(closed bool, shapes ...[]BackendVec) *Path2D {
+	p := &cv.polygons
+	p.cv = cv
+	p.p = p.p[:0]
+	for _, shape := range shapes {
+		if len(shape) == 0 {
+			continue
+		}
+		p.moveTo(shape[0][0], shape[0][1])
+		for _, pt := range shape[1:] {
+			p.LineTo(pt[0], pt[1])
+		}
+		if closed {
+			p.ClosePath()
+		}
+	}
+	return p
+}
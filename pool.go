@@ -0,0 +1,56 @@
+package canvas
+
+import (
+	"sync"
+)
+
+// Pool hands out canvases with software backends of a fixed size, so
+// that services rendering an image per request don't have to allocate
+// a new backend every time. Canvases are reset when they are returned.
+// The pool grows as needed and unused canvases are released by the
+// garbage collector, so its size follows the load. A Pool is safe for
+// concurrent use, but the canvases it hands out are not
+type Pool struct {
+	w, h int
+	pool sync.Pool
+}
+
+type pooledCanvas struct {
+	cv      *Canvas
+	backend *SoftwareBackend
+}
+
+// NewPool creates a new pool that hands out canvases of the given size
+func NewPool(w, h int) *Pool {
+	p := &Pool{w: w, h: h}
+	p.pool.New = func() interface{} {
+		backend := NewBackend(w, h)
+		return &pooledCanvas{cv: New(backend), backend: backend}
+	}
+	return p
+}
+
+// Size returns the size of the canvases in the pool
+func (p *Pool) Size() (int, int) { return p.w, p.h }
+
+// Get returns a cleared canvas and its backend from the pool, or
+// creates a new one if none is available
+func (p *Pool) Get() (*Canvas, *SoftwareBackend) {
+	pc := p.pool.Get().(*pooledCanvas)
+	return pc.cv, pc.backend
+}
+
+// Put resets the canvas and returns it to the pool. Canvases that
+// don't have a software backend of the pool size are discarded. The
+// canvas must not be used after calling Put
+func (p *Pool) Put(cv *Canvas) {
+	backend, ok := cv.b.(*SoftwareBackend)
+	if !ok {
+		return
+	}
+	if w, h := backend.Size(); w != p.w || h != p.h {
+		return
+	}
+	cv.Reset()
+	p.pool.Put(&pooledCanvas{cv: cv, backend: backend})
+}
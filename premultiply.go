@@ -0,0 +1,79 @@
+package canvas
+
+import (
+	"image"
+)
+
+// The canvas works with straight (not premultiplied) alpha, like the
+// HTML5 canvas: GetImageData returns, and PutImageData and LoadImage with
+// an *image.RGBA expect, color values that are not multiplied with the
+// alpha value, even though they are stored in an *image.RGBA.
+//
+// Backends that render with premultiplied alpha, which is usual for GPU
+// backends, have to convert the pixels they read back with
+// UnpremultiplyRGBA before returning them from GetImageData. Otherwise
+// the color of semi-transparent pixels is too dark, which shows as dark
+// fringes around anti-aliased edges
+
+// UnpremultiplyRGBA converts the pixels of the image from premultiplied
+// to straight alpha in place. Color values greater than the alpha value
+// are clamped
+func UnpremultiplyRGBA(img *image.RGBA) {
+	forEachPixel(img, func(px []uint8) {
+		a := int(px[3])
+		if a == 0 {
+			px[0], px[1], px[2] = 0, 0, 0
+			return
+		}
+		if a == 255 {
+			return
+		}
+		for i := 0; i < 3; i++ {
+			v := (int(px[i])*255 + a/2) / a
+			if v > 255 {
+				v = 255
+			}
+			px[i] = uint8(v)
+		}
+	})
+}
+
+// PremultiplyRGBA converts the pixels of the image from straight to
+// premultiplied alpha in place. Premultiplying backends can use it for
+// the images passed to PutImageData
+func PremultiplyRGBA(img *image.RGBA) {
+	forEachPixel(img, func(px []uint8) {
+		a := int(px[3])
+		if a == 255 {
+			return
+		}
+		for i := 0; i < 3; i++ {
+			px[i] = uint8((int(px[i])*a + 127) / 255)
+		}
+	})
+}
+
+// ImageDataNRGBA returns the image data from GetImageData as an
+// *image.NRGBA, which is the type that matches the straight alpha values.
+// Encoders such as image/png treat the values of an *image.RGBA as
+// premultiplied, so the result of GetImageData should be converted with
+// this function before exporting it
+func ImageDataNRGBA(img *image.RGBA) *image.NRGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		off := img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y)
+		copy(nrgba.Pix[y*nrgba.Stride:y*nrgba.Stride+w*4], img.Pix[off:off+w*4])
+	}
+	return nrgba
+}
+
+func forEachPixel(img *image.RGBA, fn func(px []uint8)) {
+	w := img.Rect.Dx()
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		off := img.PixOffset(img.Rect.Min.X, y)
+		for x := 0; x < w; x++ {
+			fn(img.Pix[off+x*4 : off+x*4+4])
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package canvas
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Quality is a preset that sets the anti-aliasing, curve tolerance,
+// image sampling and blur quality together
+type Quality uint8
+
+// Quality presets for SetQuality
+const (
+	// QualityDefault keeps the settings that a new canvas starts with
+	QualityDefault Quality = iota
+	// QualityLow draws without anti-aliasing, with coarse curves,
+	// nearest neighbor image sampling and a single pass blur
+	QualityLow
+	// QualityMedium uses a low level of anti-aliasing, bilinear image
+	// sampling and a two pass blur
+	QualityMedium
+	// QualityHigh uses a high level of anti-aliasing, fine curves,
+	// bilinear image sampling and a three pass blur
+	QualityHigh
+)
+
+type qualitySettings struct {
+	// curveTolerance is the maximum distance in pixels between
+	// a curve and the lines it is drawn with. Zero uses the
+	// fixed number of steps
+	curveTolerance float64
+	// blurPasses is the number of box blur passes used for
+	// shadows. Zero uses three passes
+	blurPasses int
+	// msaa is the anti-aliasing level of backends that support it
+	msaa int
+	// bilinear enables bilinear filtering when drawing images
+	bilinear bool
+}
+
+var qualityPresets = [...]qualitySettings{
+	QualityDefault: {},
+	QualityLow:     {curveTolerance: 0.5, blurPasses: 1, msaa: 0, bilinear: false},
+	QualityMedium:  {curveTolerance: 0.25, blurPasses: 2, msaa: 1, bilinear: true},
+	QualityHigh:    {curveTolerance: 0.1, blurPasses: 3, msaa: 3, bilinear: true},
+}
+
+// qualityBackend is implemented by backends that can change
+// their rendering quality
+type qualityBackend interface {
+	SetQuality(q Quality)
+}
+
+// SetQuality changes the rendering quality of the canvas and of the
+// backend if it supports it. Only paths added after the call use the
+// new curve tolerance
+func (cv *Canvas) SetQuality(q Quality) {
+	if int(q) >= len(qualityPresets) {
+		q = QualityDefault
+	}
+	cv.quality = q
+	if qb, ok := cv.backend().(qualityBackend); ok {
+		cv.Flush()
+		qb.SetQuality(q)
+	}
+}
+
+// Quality returns the quality preset set with SetQuality
+func (cv *Canvas) Quality() Quality {
+	return cv.quality
+}
+
+// SetQuality sets the MSAA level, image filter and blur passes of
+// the backend to the values of the preset. QualityDefault turns MSAA
+// off and restores the other settings to their defaults
+func (b *SoftwareBackend) SetQuality(q Quality) {
+	if int(q) >= len(qualityPresets) {
+		q = QualityDefault
+	}
+	s := qualityPresets[q]
+	b.MSAA = s.msaa
+	b.BilinearFilter = s.bilinear
+	b.BlurPasses = s.blurPasses
+}
+
+func (p *Path2D) curveTolerance() float64 {
+	if p.cv == nil {
+		return 0
+	}
+	return qualityPresets[p.cv.quality].curveTolerance
+}
+
+// arcStep returns the angle between the points of an arc with the
+// given radius in pixels
+func arcStep(radius, tolerance float64) float64 {
+	const defaultStep = math.Pi * 2 / 90
+	if tolerance <= 0 {
+		return defaultStep
+	}
+	radius = math.Abs(radius)
+	if radius <= tolerance {
+		return math.Pi / 4
+	}
+	step := 2 * math.Acos(1-tolerance/radius)
+	return math.Max(math.Min(step, math.Pi/4), math.Pi*2/1440)
+}
+
+// curveStep returns the parameter step for a curve with the given
+// length of the second difference of its control points. The
+// degree scales the estimate for cubic curves
+func curveStep(dd, degree, tolerance float64) float64 {
+	if tolerance <= 0 {
+		return 0.01
+	}
+	n := math.Ceil(math.Sqrt(degree * dd / (8 * tolerance)))
+	n = math.Max(math.Min(n, 1000), 1)
+	return 1 / n
+}
+
+// boxBlur blurs the image with the given number of box blur passes
+// per direction. More passes are closer to a gaussian blur
+func boxBlur(img *image.RGBA, size float64, passes int) *image.RGBA {
+	if passes <= 0 || passes >= 3 {
+		return box3(img, size)
+	}
+	size *= 1 - 1/(size+1)
+	// keep the variance of the three pass blur
+	r := int(math.Round(size * math.Sqrt(3/float64(passes))))
+	for i := 0; i < passes; i++ {
+		img = box3x(img, r)
+	}
+	for i := 0; i < passes; i++ {
+		img = box3y(img, r)
+	}
+	return img
+}
+
+// bilinearAt samples the image at x/y with bilinear filtering
+func bilinearAt(img image.Image, x, y float64) color.RGBA {
+	bounds := img.Bounds()
+	x -= 0.5
+	y -= 0.5
+	xf, yf := math.Floor(x), math.Floor(y)
+	rx, ry := x-xf, y-yf
+	x0, y0 := int(xf), int(yf)
+	x1, y1 := x0+1, y0+1
+	clamp := func(v, min, max int) int {
+		if v < min {
+			return min
+		}
+		if v >= max {
+			return max - 1
+		}
+		return v
+	}
+	x0, x1 = clamp(x0, bounds.Min.X, bounds.Max.X), clamp(x1, bounds.Min.X, bounds.Max.X)
+	y0, y1 = clamp(y0, bounds.Min.Y, bounds.Max.Y), clamp(y1, bounds.Min.Y, bounds.Max.Y)
+
+	return bilinearMix(rgbaAt(img, x0, y0), rgbaAt(img, x1, y0), rgbaAt(img, x0, y1), rgbaAt(img, x1, y1), rx, ry)
+}
+
+// bilinearMix interpolates between the four colors around a point, where
+// rx/ry is the position of the point between them. The colors are
+// weighted by alpha so that transparent pixels don't darken the edges
+func bilinearMix(c00, c10, c01, c11 color.RGBA, rx, ry float64) color.RGBA {
+	var r, g, b, a float64
+	add := func(c color.RGBA, w float64) {
+		wa := w * float64(c.A)
+		r += float64(c.R) * wa
+		g += float64(c.G) * wa
+		b += float64(c.B) * wa
+		a += wa
+	}
+	add(c00, (1-rx)*(1-ry))
+	add(c10, rx*(1-ry))
+	add(c01, (1-rx)*ry)
+	add(c11, rx*ry)
+	if a <= 0 {
+		return color.RGBA{}
+	}
+	return color.RGBA{
+		R: uint8(math.Round(r / a)),
+		G: uint8(math.Round(g / a)),
+		B: uint8(math.Round(b / a)),
+		A: uint8(math.Round(a)),
+	}
+}
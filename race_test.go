@@ -0,0 +1,8 @@
+//go:build race
+// +build race
+
+package canvas_test
+
+func init() {
+	raceEnabled = true
+}
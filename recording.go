@@ -0,0 +1,394 @@
+package canvas
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// DisplayCommandKind is the type of a recorded backend call
+type DisplayCommandKind uint8
+
+// Display command kinds, one for each drawing call of the Backend interface
+const (
+	DisplayClear DisplayCommandKind = iota
+	DisplayFill
+	DisplayDrawImage
+	DisplayFillImageMask
+	DisplayFillVertexColor
+	DisplayClip
+	DisplayClearClip
+	DisplayPutImageData
+)
+
+// DisplayCommand is a single recorded backend call. Only the fields that
+// belong to the kind of the command are set. All points are in canvas
+// pixel coordinates. Masks and image data are copied when recording, but
+// images, gradients and image patterns in the style are referenced
+type DisplayCommand struct {
+	Kind   DisplayCommandKind
+	Bounds Bounds
+
+	Style      BackendFillStyle
+	Pts        []BackendVec
+	CanOverlap bool
+	// Colors are the vertex colors of DisplayFillVertexColor, or
+	// the tint color of a tinted DisplayDrawImage
+	Colors []color.RGBA
+
+	Image          BackendImage
+	SX, SY, SW, SH float64
+	Alpha          float64
+	// Matrix is the color matrix of a DisplayDrawImage, if it has one
+	Matrix *ColorMatrix
+
+	Mask *image.Alpha
+	Data *image.RGBA
+}
+
+// drawing returns true if the command draws pixels, as opposed to
+// changing the clipping state
+func (c *DisplayCommand) drawing() bool {
+	return c.Kind != DisplayClip && c.Kind != DisplayClearClip
+}
+
+// DisplayList is a list of recorded backend calls that can be replayed
+// on any backend that the images, gradients and patterns were loaded with
+type DisplayList struct {
+	Commands []DisplayCommand
+
+	index   *SpatialIndex
+	indexed int
+}
+
+// Bounds returns the area that is drawn to by the commands
+func (dl *DisplayList) Bounds() Bounds {
+	b := EmptyBounds
+	for i := range dl.Commands {
+		if dl.Commands[i].drawing() {
+			b = b.Union(dl.Commands[i].Bounds)
+		}
+	}
+	return b
+}
+
+// Index returns a spatial index over the bounds of the drawing commands.
+// It is built on the first call and rebuilt when commands were added
+func (dl *DisplayList) Index() *SpatialIndex {
+	if dl.index == nil || dl.indexed != len(dl.Commands) {
+		bounds := make([]Bounds, len(dl.Commands))
+		for i := range dl.Commands {
+			if dl.Commands[i].drawing() {
+				bounds[i] = dl.Commands[i].Bounds
+			} else {
+				bounds[i] = EmptyBounds
+			}
+		}
+		dl.index = NewSpatialIndex(bounds)
+		dl.indexed = len(dl.Commands)
+	}
+	return dl.index
+}
+
+// Replay executes all commands on the given backend
+func (dl *DisplayList) Replay(b Backend) {
+	for i := range dl.Commands {
+		dl.Commands[i].run(b, BackendMatIdentity)
+	}
+}
+
+// ReplayCulled executes the commands on the given backend, but skips all
+// drawing commands that are entirely outside of the viewport or the
+// current clipping region. Clipping commands are always executed
+func (dl *DisplayList) ReplayCulled(b Backend, viewport Bounds) {
+	dl.replay(b, BackendMatIdentity, viewport)
+}
+
+// replay is ReplayCulled with all commands transformed by the given
+// matrix. The viewport is in the untransformed coordinates
+func (dl *DisplayList) replay(b Backend, m BackendMat, viewport Bounds) {
+	visible := dl.Index().Query(viewport)
+	clip := InfiniteBounds
+	next := 0
+	for i := range dl.Commands {
+		cmd := &dl.Commands[i]
+		if !cmd.drawing() {
+			if cmd.Kind == DisplayClearClip {
+				clip = InfiniteBounds
+			} else {
+				clip = clip.Intersect(cmd.Bounds)
+			}
+			cmd.run(b, m)
+			continue
+		}
+		for next < len(visible) && visible[next] < i {
+			next++
+		}
+		if next >= len(visible) || visible[next] != i {
+			continue
+		}
+		if !cmd.Bounds.Intersects(clip) {
+			continue
+		}
+		cmd.run(b, m)
+	}
+}
+
+// run executes the command with all coordinates transformed by the given
+// matrix. Image patterns and image data are not scaled
+func (c *DisplayCommand) run(b Backend, m BackendMat) {
+	pts, style := c.Pts, c.Style
+	if m != BackendMatIdentity {
+		pts = make([]BackendVec, len(c.Pts))
+		for i, pt := range c.Pts {
+			pts[i] = pt.MulMat(m)
+		}
+		style = transformStyle(style, m)
+	}
+	var quad [4]BackendVec
+	copy(quad[:], pts)
+
+	switch c.Kind {
+	case DisplayClear:
+		b.Clear(quad)
+	case DisplayFill:
+		b.Fill(&style, pts, BackendMatIdentity, c.CanOverlap)
+	case DisplayDrawImage:
+		if cb, ok := b.(ColorMatrixImageBackend); ok && c.Matrix != nil {
+			cb.DrawImageColorMatrix(c.Image, c.SX, c.SY, c.SW, c.SH, quad, c.Alpha, c.Matrix)
+		} else if tb, ok := b.(TintedImageBackend); ok && len(c.Colors) == 1 {
+			tb.DrawImageTinted(c.Image, c.SX, c.SY, c.SW, c.SH, quad, c.Alpha, c.Colors[0])
+		} else {
+			b.DrawImage(c.Image, c.SX, c.SY, c.SW, c.SH, quad, c.Alpha)
+		}
+	case DisplayFillImageMask:
+		b.FillImageMask(&style, c.Mask, quad)
+	case DisplayFillVertexColor:
+		b.FillTrianglesVertexColor(pts, c.Colors)
+	case DisplayClip:
+		b.Clip(pts)
+	case DisplayClearClip:
+		b.ClearClip()
+	case DisplayPutImageData:
+		pos := BackendVec{c.Bounds.MinX, c.Bounds.MinY}.MulMat(m)
+		b.PutImageData(c.Data, int(math.Round(pos[0])), int(math.Round(pos[1])))
+	}
+}
+
+// transformStyle returns the style with the gradient coordinates and the
+// blur transformed by the given matrix
+func transformStyle(style BackendFillStyle, m BackendMat) BackendFillStyle {
+	scale := math.Sqrt(math.Abs(m[0]*m[3] - m[1]*m[2]))
+	from := BackendVec{style.Gradient.X0, style.Gradient.Y0}.MulMat(m)
+	to := BackendVec{style.Gradient.X1, style.Gradient.Y1}.MulMat(m)
+	style.Gradient.X0, style.Gradient.Y0 = from[0], from[1]
+	style.Gradient.X1, style.Gradient.Y1 = to[0], to[1]
+	style.Gradient.RadFrom *= scale
+	style.Gradient.RadTo *= scale
+	style.Blur *= scale
+	return style
+}
+
+// RecordingBackend is a backend that records the drawing calls into a
+// display list instead of executing them. Images, gradients and image
+// patterns are loaded with the target backend, so the display list can
+// be replayed on it later. The size is also taken from the target
+type RecordingBackend struct {
+	target Backend
+	list   DisplayList
+}
+
+// NewRecordingBackend creates a new recording backend for the given target
+func NewRecordingBackend(target Backend) *RecordingBackend {
+	return &RecordingBackend{target: target}
+}
+
+// Target returns the backend that the recording is meant for
+func (rb *RecordingBackend) Target() Backend { return rb.target }
+
+// DisplayList returns the commands that were recorded so far and
+// starts a new recording
+func (rb *RecordingBackend) DisplayList() *DisplayList {
+	list := rb.list
+	rb.list = DisplayList{}
+	return &list
+}
+
+func (rb *RecordingBackend) record(cmd DisplayCommand) {
+	rb.list.Commands = append(rb.list.Commands, cmd)
+}
+
+func copyPts(pts []BackendVec) []BackendVec {
+	result := make([]BackendVec, len(pts))
+	copy(result, pts)
+	return result
+}
+
+func (rb *RecordingBackend) Size() (int, int) { return rb.target.Size() }
+
+func (rb *RecordingBackend) LoadImage(img image.Image) (BackendImage, error) {
+	return rb.target.LoadImage(img)
+}
+
+func (rb *RecordingBackend) LoadImageMipmap(img image.Image, quality MipmapQuality) (BackendImage, error) {
+	return loadImageMipmap(rb.target, img, quality)
+}
+
+func (rb *RecordingBackend) LoadImageYUV(y, u, v []byte, format YUVFormat) (BackendImage, error) {
+	return loadImageYUV(rb.target, y, u, v, format)
+}
+
+func (rb *RecordingBackend) LoadImagePattern(data BackendImagePatternData) BackendImagePattern {
+	return rb.target.LoadImagePattern(data)
+}
+
+func (rb *RecordingBackend) LoadLinearGradient(data BackendGradient) BackendLinearGradient {
+	return rb.target.LoadLinearGradient(data)
+}
+
+func (rb *RecordingBackend) LoadRadialGradient(data BackendGradient) BackendRadialGradient {
+	return rb.target.LoadRadialGradient(data)
+}
+
+func (rb *RecordingBackend) Clear(pts [4]BackendVec) {
+	rb.record(DisplayCommand{Kind: DisplayClear, Pts: copyPts(pts[:]), Bounds: BoundsOf(pts[:])})
+}
+
+func (rb *RecordingBackend) Fill(style *BackendFillStyle, pts []BackendVec, tf BackendMat, canOverlap bool) {
+	tfPts := make([]BackendVec, len(pts))
+	for i, pt := range pts {
+		tfPts[i] = pt.MulMat(tf)
+	}
+	rb.record(DisplayCommand{
+		Kind:       DisplayFill,
+		Style:      *style,
+		Pts:        tfPts,
+		CanOverlap: canOverlap,
+		Bounds:     BoundsOf(tfPts).Grow(style.Blur * 3),
+	})
+}
+
+func (rb *RecordingBackend) DrawImage(dimg BackendImage, sx, sy, sw, sh float64, pts [4]BackendVec, alpha float64) {
+	rb.record(DisplayCommand{
+		Kind:   DisplayDrawImage,
+		Image:  dimg,
+		SX:     sx,
+		SY:     sy,
+		SW:     sw,
+		SH:     sh,
+		Pts:    copyPts(pts[:]),
+		Alpha:  alpha,
+		Bounds: BoundsOf(pts[:]),
+	})
+}
+
+// DrawImageTinted records a tinted image draw. The tint is stored as
+// the only color of the command. Replaying it on a backend without tint
+// support draws the image untinted
+func (rb *RecordingBackend) DrawImageTinted(dimg BackendImage, sx, sy, sw, sh float64, pts [4]BackendVec, alpha float64, tint color.RGBA) {
+	rb.record(DisplayCommand{
+		Kind:   DisplayDrawImage,
+		Image:  dimg,
+		SX:     sx,
+		SY:     sy,
+		SW:     sw,
+		SH:     sh,
+		Pts:    copyPts(pts[:]),
+		Alpha:  alpha,
+		Colors: []color.RGBA{tint},
+		Bounds: BoundsOf(pts[:]),
+	})
+}
+
+// DrawImageColorMatrix records an image draw with a color matrix.
+// Replaying it on a backend without color matrix support draws the
+// image unchanged
+func (rb *RecordingBackend) DrawImageColorMatrix(dimg BackendImage, sx, sy, sw, sh float64, pts [4]BackendVec, alpha float64, m *ColorMatrix) {
+	mc := *m
+	rb.record(DisplayCommand{
+		Kind:   DisplayDrawImage,
+		Image:  dimg,
+		SX:     sx,
+		SY:     sy,
+		SW:     sw,
+		SH:     sh,
+		Pts:    copyPts(pts[:]),
+		Alpha:  alpha,
+		Matrix: &mc,
+		Bounds: BoundsOf(pts[:]),
+	})
+}
+
+func (rb *RecordingBackend) FillImageMask(style *BackendFillStyle, mask *image.Alpha, pts [4]BackendVec) {
+	maskCopy := image.NewAlpha(mask.Rect)
+	draw.Draw(maskCopy, mask.Rect, mask, mask.Rect.Min, draw.Src)
+	rb.record(DisplayCommand{
+		Kind:   DisplayFillImageMask,
+		Style:  *style,
+		Mask:   maskCopy,
+		Pts:    copyPts(pts[:]),
+		Bounds: BoundsOf(pts[:]),
+	})
+}
+
+func (rb *RecordingBackend) FillTrianglesVertexColor(pts []BackendVec, colors []color.RGBA) {
+	cols := make([]color.RGBA, len(colors))
+	copy(cols, colors)
+	rb.record(DisplayCommand{
+		Kind:   DisplayFillVertexColor,
+		Pts:    copyPts(pts),
+		Colors: cols,
+		Bounds: BoundsOf(pts),
+	})
+}
+
+func (rb *RecordingBackend) ClearClip() {
+	rb.record(DisplayCommand{Kind: DisplayClearClip, Bounds: InfiniteBounds})
+}
+
+func (rb *RecordingBackend) Clip(pts []BackendVec) {
+	rb.record(DisplayCommand{Kind: DisplayClip, Pts: copyPts(pts), Bounds: BoundsOf(pts)})
+}
+
+// GetImageData returns the image data of the target backend, which does
+// not contain any of the recorded commands that were not replayed yet
+func (rb *RecordingBackend) GetImageData(x, y, w, h int) *image.RGBA {
+	return rb.target.GetImageData(x, y, w, h)
+}
+
+func (rb *RecordingBackend) GetImageDataInto(dst []byte, x, y, w, h, stride int) {
+	getImageDataInto(rb.target, dst, x, y, w, h, stride)
+}
+
+func (rb *RecordingBackend) GetImageDataAsync(x, y, w, h int) <-chan *image.RGBA {
+	return getImageDataAsync(rb.target, x, y, w, h)
+}
+
+func (rb *RecordingBackend) BeginFrame() { beginFrame(rb.target) }
+func (rb *RecordingBackend) EndFrame()   { endFrame(rb.target) }
+
+func (rb *RecordingBackend) PutImageData(img *image.RGBA, x, y int) {
+	imgCopy := image.NewRGBA(image.Rect(0, 0, img.Rect.Dx(), img.Rect.Dy()))
+	draw.Draw(imgCopy, imgCopy.Rect, img, img.Rect.Min, draw.Src)
+	rb.record(DisplayCommand{
+		Kind: DisplayPutImageData,
+		Data: imgCopy,
+		Bounds: Bounds{
+			MinX: float64(x), MinY: float64(y),
+			MaxX: float64(x + img.Rect.Dx()), MaxY: float64(y + img.Rect.Dy()),
+		},
+	})
+}
+
+func (rb *RecordingBackend) CanUseAsImage(b Backend) bool { return false }
+
+// Capabilities returns the capabilities of the target backend, except
+// that a recording can not be used as an image
+func (rb *RecordingBackend) Capabilities() BackendCapabilities {
+	caps := rb.target.Capabilities()
+	caps.AsImage = false
+	return caps
+}
+
+func (rb *RecordingBackend) AsImage() BackendImage { return nil }
@@ -0,0 +1,130 @@
+package canvas
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// RectFillBackend is implemented by backends that can fill and clear
+// pixel aligned rectangles faster than with Fill and Clear. Both
+// functions return false if they can't handle the call, in which case
+// the regular functions are used instead
+type RectFillBackend interface {
+	FillPixelRect(rect image.Rectangle, col color.RGBA) bool
+	ClearPixelRect(rect image.Rectangle) bool
+}
+
+// pixelRect returns the pixel rectangle of a quad as created by
+// FillRect and ClearRect if it is axis aligned and all of its
+// corners are on pixel boundaries
+func pixelRect(pts [4]BackendVec) (image.Rectangle, bool) {
+	if pts[0][0] != pts[1][0] || pts[2][0] != pts[3][0] ||
+		pts[0][1] != pts[3][1] || pts[1][1] != pts[2][1] {
+		return image.Rectangle{}, false
+	}
+	for _, v := range [...]float64{pts[0][0], pts[0][1], pts[2][0], pts[2][1]} {
+		if v != math.Trunc(v) || math.Abs(v) > 1<<30 {
+			return image.Rectangle{}, false
+		}
+	}
+	return image.Rect(int(pts[0][0]), int(pts[0][1]), int(pts[2][0]), int(pts[2][1])), true
+}
+
+// fillPixelRect fills the quad with the backend fast path if the fill
+// style is a plain color without shadows and the quad is pixel aligned
+func (cv *Canvas) fillPixelRect(pts [4]BackendVec) bool {
+	rb, ok := cv.b.(RectFillBackend)
+	if !ok || cv.state.shadowColor.A != 0 {
+		return false
+	}
+	s := &cv.state.fill
+	if s.linearGradient != nil || s.radialGradient != nil || s.imagePattern != nil {
+		return false
+	}
+	rect, ok := pixelRect(pts)
+	if !ok {
+		return false
+	}
+	cv.Flush()
+	stl := cv.backendFillStyle(s, 1)
+	if !rb.FillPixelRect(rect, stl.Color) {
+		return false
+	}
+	cv.countQuad(pts)
+	return true
+}
+
+// clearPixelRect clears the quad with the backend fast path if it
+// is pixel aligned
+func (cv *Canvas) clearPixelRect(pts [4]BackendVec) bool {
+	rb, ok := cv.b.(RectFillBackend)
+	if !ok {
+		return false
+	}
+	rect, ok := pixelRect(pts)
+	if !ok {
+		return false
+	}
+	return rb.ClearPixelRect(rect)
+}
+
+// FillPixelRect fills the rectangle with the color, blended over the
+// existing pixels inside of the clipping region. It returns false if
+// MSAA or a coverage callback is active, since both need the regular
+// fill path
+func (b *SoftwareBackend) FillPixelRect(rect image.Rectangle, col color.RGBA) bool {
+	if b.MSAA > 0 || b.coverageFn != nil {
+		return false
+	}
+	rect = rect.Canon().Intersect(b.clipRect)
+	if col.A == 0 || rect.Empty() {
+		return true
+	}
+	b.touch(rect)
+	n := rect.Dx()
+	if col.A == 255 && b.clipIsRect {
+		// fill the first row and copy it to the others
+		first := b.Image.Pix[b.Image.PixOffset(rect.Min.X, rect.Min.Y):][:n*4]
+		fillPixels(first, col)
+		for y := rect.Min.Y + 1; y < rect.Max.Y; y++ {
+			copy(b.Image.Pix[b.Image.PixOffset(rect.Min.X, y):], first)
+		}
+		return true
+	}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		clip := b.clip.Pix[b.clip.PixOffset(rect.Min.X, y):]
+		pix := b.Image.Pix[b.Image.PixOffset(rect.Min.X, y):]
+		for i := 0; i < n; i++ {
+			if !b.clipIsRect && clip[i] == 0 {
+				continue
+			}
+			p := pix[i*4 : i*4+4 : i*4+4]
+			c := col
+			if c.A < 255 {
+				c = mix(c, color.RGBA{R: p[0], G: p[1], B: p[2], A: p[3]})
+			}
+			p[0], p[1], p[2], p[3] = c.R, c.G, c.B, c.A
+		}
+	}
+	return true
+}
+
+// ClearPixelRect sets the pixels of the rectangle that are inside of
+// the clipping region to transparent black
+func (b *SoftwareBackend) ClearPixelRect(rect image.Rectangle) bool {
+	rect = rect.Canon().Intersect(b.clipRect)
+	b.touch(rect)
+	n := rect.Dx()
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		clip := b.clip.Pix[b.clip.PixOffset(rect.Min.X, y):]
+		pix := b.Image.Pix[b.Image.PixOffset(rect.Min.X, y):]
+		for i := 0; i < n; i++ {
+			if b.clipIsRect || clip[i] != 0 {
+				p := pix[i*4 : i*4+4 : i*4+4]
+				p[0], p[1], p[2], p[3] = 0, 0, 0, 0
+			}
+		}
+	}
+	return true
+}
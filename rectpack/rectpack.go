@@ -0,0 +1,253 @@
+// Package rectpack places rectangles in a fixed size area without
+// overlaps, as needed for texture atlases, sprite sheets and collages.
+//
+// Skyline packing is fast and works best for rectangles of similar
+// height, such as glyphs. Guillotine packing keeps track of all free
+// space and wastes less space with very different sizes
+package rectpack
+
+import (
+	"image"
+	"sort"
+)
+
+// Packer places rectangles in an area
+type Packer interface {
+	// Pack returns the position of a new w by h rectangle, or false if
+	// there is no more room for it
+	Pack(w, h int) (image.Rectangle, bool)
+	// Reset removes all rectangles
+	Reset()
+}
+
+// Skyline is a packer that keeps the top edge of the packed rectangles
+// as a list of horizontal segments and places each rectangle as low as
+// possible on it
+type Skyline struct {
+	// Padding is the space that is kept free around each rectangle
+	Padding int
+
+	w, h int
+	line []skylineSegment
+	area int
+}
+
+type skylineSegment struct {
+	x, y, w int
+}
+
+// NewSkyline creates a skyline packer for an area of w by h pixels
+func NewSkyline(w, h int) *Skyline {
+	s := &Skyline{w: w, h: h}
+	s.Reset()
+	return s
+}
+
+// Reset removes all rectangles
+func (s *Skyline) Reset() {
+	s.line = append(s.line[:0], skylineSegment{w: s.w})
+	s.area = 0
+}
+
+// Occupancy returns the part of the area that is covered by rectangles,
+// between 0 and 1
+func (s *Skyline) Occupancy() float64 {
+	if s.w <= 0 || s.h <= 0 {
+		return 0
+	}
+	return float64(s.area) / float64(s.w*s.h)
+}
+
+// Pack returns the position of a new w by h rectangle, or false if there
+// is no more room for it
+func (s *Skyline) Pack(w, h int) (image.Rectangle, bool) {
+	if w <= 0 || h <= 0 {
+		return image.Rectangle{}, false
+	}
+	pw, ph := w+s.Padding*2, h+s.Padding*2
+
+	best, bestY, bestW := -1, 0, 0
+	for i := range s.line {
+		y, ok := s.fit(i, pw, ph)
+		if !ok {
+			continue
+		}
+		// prefer the lowest position, then the narrowest segment to
+		// keep wide gaps for wide rectangles
+		if best < 0 || y < bestY || (y == bestY && s.line[i].w < bestW) {
+			best, bestY, bestW = i, y, s.line[i].w
+		}
+	}
+	if best < 0 {
+		return image.Rectangle{}, false
+	}
+
+	x := s.line[best].x
+	s.insert(best, skylineSegment{x: x, y: bestY + ph, w: pw})
+	s.area += w * h
+	return image.Rect(x+s.Padding, bestY+s.Padding, x+s.Padding+w, bestY+s.Padding+h), true
+}
+
+// fit returns the height at which a w by h rectangle can be placed
+// starting at segment i
+func (s *Skyline) fit(i, w, h int) (int, bool) {
+	x := s.line[i].x
+	if x+w > s.w {
+		return 0, false
+	}
+	y := 0
+	for left := w; left > 0; i++ {
+		seg := s.line[i]
+		if seg.y > y {
+			y = seg.y
+		}
+		if y+h > s.h {
+			return 0, false
+		}
+		left -= seg.w
+	}
+	return y, true
+}
+
+// insert adds the segment at index i and shrinks or removes the
+// segments that it covers
+func (s *Skyline) insert(i int, seg skylineSegment) {
+	s.line = append(s.line, skylineSegment{})
+	copy(s.line[i+1:], s.line[i:])
+	s.line[i] = seg
+
+	end := seg.x + seg.w
+	for j := i + 1; j < len(s.line); {
+		next := &s.line[j]
+		if next.x >= end {
+			break
+		}
+		if next.x+next.w <= end {
+			s.line = append(s.line[:j], s.line[j+1:]...)
+			continue
+		}
+		next.w -= end - next.x
+		next.x = end
+		break
+	}
+
+	// merge neighbors of the same height
+	for j := 0; j+1 < len(s.line); {
+		if s.line[j].y == s.line[j+1].y {
+			s.line[j].w += s.line[j+1].w
+			s.line = append(s.line[:j+1], s.line[j+2:]...)
+			continue
+		}
+		j++
+	}
+}
+
+// Guillotine is a packer that keeps a list of free rectangles. Each
+// rectangle is placed in the free rectangle that fits it best, and the
+// rest of that free rectangle is split in two
+type Guillotine struct {
+	// Padding is the space that is kept free around each rectangle
+	Padding int
+
+	w, h int
+	free []image.Rectangle
+	area int
+}
+
+// NewGuillotine creates a guillotine packer for an area of w by h pixels
+func NewGuillotine(w, h int) *Guillotine {
+	g := &Guillotine{w: w, h: h}
+	g.Reset()
+	return g
+}
+
+// Reset removes all rectangles
+func (g *Guillotine) Reset() {
+	g.free = append(g.free[:0], image.Rect(0, 0, g.w, g.h))
+	g.area = 0
+}
+
+// Occupancy returns the part of the area that is covered by rectangles,
+// between 0 and 1
+func (g *Guillotine) Occupancy() float64 {
+	if g.w <= 0 || g.h <= 0 {
+		return 0
+	}
+	return float64(g.area) / float64(g.w*g.h)
+}
+
+// Pack returns the position of a new w by h rectangle, or false if there
+// is no more room for it
+func (g *Guillotine) Pack(w, h int) (image.Rectangle, bool) {
+	if w <= 0 || h <= 0 {
+		return image.Rectangle{}, false
+	}
+	pw, ph := w+g.Padding*2, h+g.Padding*2
+
+	best, bestWaste := -1, 0
+	for i, f := range g.free {
+		if f.Dx() < pw || f.Dy() < ph {
+			continue
+		}
+		waste := f.Dx()*f.Dy() - pw*ph
+		if best < 0 || waste < bestWaste {
+			best, bestWaste = i, waste
+		}
+	}
+	if best < 0 {
+		return image.Rectangle{}, false
+	}
+
+	f := g.free[best]
+	g.free = append(g.free[:best], g.free[best+1:]...)
+	// split along the shorter leftover axis, which keeps the larger
+	// free rectangle as big as possible
+	rw, rh := f.Dx()-pw, f.Dy()-ph
+	var right, bottom image.Rectangle
+	if rw < rh {
+		right = image.Rect(f.Min.X+pw, f.Min.Y, f.Max.X, f.Min.Y+ph)
+		bottom = image.Rect(f.Min.X, f.Min.Y+ph, f.Max.X, f.Max.Y)
+	} else {
+		right = image.Rect(f.Min.X+pw, f.Min.Y, f.Max.X, f.Max.Y)
+		bottom = image.Rect(f.Min.X, f.Min.Y+ph, f.Min.X+pw, f.Max.Y)
+	}
+	for _, r := range [2]image.Rectangle{right, bottom} {
+		if !r.Empty() {
+			g.free = append(g.free, r)
+		}
+	}
+
+	g.area += w * h
+	x, y := f.Min.X+g.Padding, f.Min.Y+g.Padding
+	return image.Rect(x, y, x+w, y+h), true
+}
+
+// PackAll packs all sizes, largest first, which gives a much tighter
+// packing than packing them in any order. The positions are returned
+// in the order of the sizes. If not all of them fit, the ones that fit
+// are still placed and false is returned, and the others are empty
+func PackAll(p Packer, sizes []image.Point) ([]image.Rectangle, bool) {
+	order := make([]int, len(sizes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		sa, sb := sizes[order[a]], sizes[order[b]]
+		if sa.Y != sb.Y {
+			return sa.Y > sb.Y
+		}
+		return sa.X > sb.X
+	})
+
+	rects := make([]image.Rectangle, len(sizes))
+	all := true
+	for _, i := range order {
+		r, ok := p.Pack(sizes[i].X, sizes[i].Y)
+		if !ok {
+			all = false
+			continue
+		}
+		rects[i] = r
+	}
+	return rects, all
+}
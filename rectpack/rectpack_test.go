@@ -0,0 +1,85 @@
+package rectpack_test
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+
+	"github.com/opentoys/canvas/rectpack"
+)
+
+func checkPacking(t *testing.T, name string, bounds image.Rectangle, rects []image.Rectangle, padding int) {
+	for i, r := range rects {
+		if r.Empty() {
+			continue
+		}
+		if !r.Inset(-padding).In(bounds) {
+			t.Fatalf("%s: rectangle %v with padding is outside of %v", name, r, bounds)
+		}
+		for _, r2 := range rects[i+1:] {
+			if r.Inset(-padding).Overlaps(r2) {
+				t.Fatalf("%s: rectangles %v and %v are too close", name, r, r2)
+			}
+		}
+	}
+}
+
+func TestPackers(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	sizes := make([]image.Point, 300)
+	for i := range sizes {
+		sizes[i] = image.Pt(4+rnd.Intn(28), 4+rnd.Intn(28))
+	}
+
+	for _, padding := range []int{0, 1} {
+		skyline := rectpack.NewSkyline(512, 512)
+		skyline.Padding = padding
+		guillotine := rectpack.NewGuillotine(512, 512)
+		guillotine.Padding = padding
+		packers := []struct {
+			name string
+			p    rectpack.Packer
+		}{
+			{"skyline", skyline},
+			{"guillotine", guillotine},
+		}
+
+		for _, pk := range packers {
+			rects, ok := rectpack.PackAll(pk.p, sizes)
+			if !ok {
+				t.Fatalf("%s: expected all rectangles to fit", pk.name)
+			}
+			for i, r := range rects {
+				if r.Size() != sizes[i] {
+					t.Fatalf("%s: rectangle %d has size %v, expected %v", pk.name, i, r.Size(), sizes[i])
+				}
+			}
+			checkPacking(t, pk.name, image.Rect(0, 0, 512, 512), rects, padding)
+		}
+	}
+}
+
+func TestPackFull(t *testing.T) {
+	s := rectpack.NewSkyline(64, 64)
+	for i := 0; i < 16; i++ {
+		if _, ok := s.Pack(16, 16); !ok {
+			t.Fatalf("Expected square %d to fit", i)
+		}
+	}
+	if occ := s.Occupancy(); occ != 1 {
+		t.Fatalf("Expected a full area, got %v", occ)
+	}
+	if _, ok := s.Pack(1, 1); ok {
+		t.Fatal("Expected no room left")
+	}
+	s.Reset()
+	if r, ok := s.Pack(64, 64); !ok || r != image.Rect(0, 0, 64, 64) {
+		t.Fatalf("Expected the whole area after Reset, got %v", r)
+	}
+
+	g := rectpack.NewGuillotine(64, 64)
+	rects, ok := rectpack.PackAll(g, []image.Point{{40, 40}, {30, 30}, {20, 20}})
+	if ok || rects[1] != (image.Rectangle{}) || rects[0].Empty() || rects[2].Empty() {
+		t.Fatalf("Expected only the rectangle that doesn't fit to be empty, got %v", rects)
+	}
+}
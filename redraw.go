@@ -0,0 +1,162 @@
+package canvas
+
+import (
+	"image"
+	"sync"
+)
+
+// redrawOverhead is the cost of redrawing a region in pixels. Two regions
+// are redrawn as one if their union is not larger than the two of them
+// by more than this
+const redrawOverhead = 64 * 64
+
+// maxRedrawRegions is the maximum number of regions of a frame. If there
+// are more, they are redrawn as one region that covers all of them
+const maxRedrawRegions = 16
+
+// RedrawScheduler redraws only the parts of a canvas that changed, for
+// applications that draw in response to events instead of in a loop.
+// Invalidate marks regions as changed, and Frame redraws all of them at
+// once by calling the draw function with the clipping region set to
+// each region in turn, after clearing it. The draw function can draw
+// the whole scene, the clipping region keeps everything else unchanged
+type RedrawScheduler struct {
+	cv   *Canvas
+	draw func(cv *Canvas, region image.Rectangle)
+
+	mu      sync.Mutex
+	dirty   []Bounds
+	changed chan struct{}
+}
+
+// NewRedrawScheduler creates a scheduler that redraws the canvas with
+// the given function. The whole canvas starts out invalid
+func NewRedrawScheduler(cv *Canvas, draw func(cv *Canvas, region image.Rectangle)) *RedrawScheduler {
+	rs := &RedrawScheduler{cv: cv, draw: draw, changed: make(chan struct{}, 1)}
+	rs.InvalidateAll()
+	return rs
+}
+
+// Invalidate marks the rectangle in canvas pixels as changed. It can be
+// called from any goroutine
+func (rs *RedrawScheduler) Invalidate(rect image.Rectangle) {
+	rect = rect.Canon()
+	if rect.Empty() {
+		return
+	}
+	rs.mu.Lock()
+	rs.dirty = append(rs.dirty, Bounds{
+		MinX: float64(rect.Min.X), MinY: float64(rect.Min.Y),
+		MaxX: float64(rect.Max.X), MaxY: float64(rect.Max.Y),
+	})
+	rs.mu.Unlock()
+	select {
+	case rs.changed <- struct{}{}:
+	default:
+	}
+}
+
+// InvalidateAll marks the whole canvas as changed
+func (rs *RedrawScheduler) InvalidateAll() {
+	w, h := rs.cv.Size()
+	rs.Invalidate(image.Rect(0, 0, w, h))
+}
+
+// Pending returns true if there are invalid regions
+func (rs *RedrawScheduler) Pending() bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return len(rs.dirty) > 0
+}
+
+// Changed returns a channel that receives a value when a region is
+// invalidated, so that an event loop can wait for it before calling
+// Frame. Several invalidations before a Frame may only be signaled once
+func (rs *RedrawScheduler) Changed() <-chan struct{} {
+	return rs.changed
+}
+
+// Regions returns the regions that the next Frame redraws, with the
+// invalid regions clipped to the canvas and merged where redrawing them
+// together is cheaper
+func (rs *RedrawScheduler) Regions() []image.Rectangle {
+	rs.mu.Lock()
+	dirty := append([]Bounds(nil), rs.dirty...)
+	rs.mu.Unlock()
+	return rs.coalesce(dirty)
+}
+
+func (rs *RedrawScheduler) coalesce(dirty []Bounds) []image.Rectangle {
+	w, h := rs.cv.Size()
+	canvasRect := image.Rect(0, 0, w, h)
+	rects := mergeDamage(dirty)
+	for i := 0; i < len(rects); i++ {
+		rects[i] = rects[i].Intersect(canvasRect)
+		if rects[i].Empty() {
+			rects = append(rects[:i], rects[i+1:]...)
+			i--
+		}
+	}
+
+	for merged := true; merged; {
+		merged = false
+		for i := 0; i < len(rects) && !merged; i++ {
+			for j := i + 1; j < len(rects); j++ {
+				a, b := rects[i], rects[j]
+				u := a.Union(b)
+				if rectArea(u) > rectArea(a)+rectArea(b)+redrawOverhead {
+					continue
+				}
+				rects[i] = u
+				rects = append(rects[:j], rects[j+1:]...)
+				merged = true
+				break
+			}
+		}
+	}
+
+	if len(rects) > maxRedrawRegions {
+		u := rects[0]
+		for _, r := range rects[1:] {
+			u = u.Union(r)
+		}
+		rects = append(rects[:0], u)
+	}
+	return rects
+}
+
+func rectArea(r image.Rectangle) int {
+	return r.Dx() * r.Dy()
+}
+
+// Frame redraws all invalid regions and returns them, or nil if there
+// were none. Each region is cleared and then drawn with the clipping
+// region set to it. The state of the canvas is saved and restored
+// around each call of the draw function
+func (rs *RedrawScheduler) Frame() []image.Rectangle {
+	rs.mu.Lock()
+	dirty := rs.dirty
+	rs.dirty = nil
+	rs.mu.Unlock()
+	if len(dirty) == 0 {
+		return nil
+	}
+
+	cv := rs.cv
+	regions := rs.coalesce(dirty)
+	for _, r := range regions {
+		x0, y0 := float64(r.Min.X), float64(r.Min.Y)
+		x1, y1 := float64(r.Max.X), float64(r.Max.Y)
+
+		cv.Save()
+		clip := cv.NewPath2D()
+		clip.Rect(x0, y0, x1-x0, y1-y0)
+		cv.clip(clip, BackendMatIdentity)
+		cv.countQuad([4]BackendVec{{x0, y0}, {x0, y1}, {x1, y1}, {x1, y0}})
+		cv.b.Clear([4]BackendVec{{x0, y0}, {x0, y1}, {x1, y1}, {x1, y0}})
+		rs.draw(cv, r)
+		cv.Restore()
+	}
+	cv.Flush()
+	return regions
+}
@@ -0,0 +1,80 @@
+package canvas
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// BackendOptions are passed to a backend factory by NewWithBackend
+type BackendOptions struct {
+	Width, Height int
+	// MSAA is the anti-aliasing level for backends that support it
+	MSAA int
+	// Params holds backend specific options
+	Params map[string]interface{}
+}
+
+// BackendFactory creates a backend with the given options
+type BackendFactory func(opts BackendOptions) (Backend, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]BackendFactory)
+)
+
+func init() {
+	RegisterBackend("software", func(opts BackendOptions) (Backend, error) {
+		b, err := NewBackendChecked(opts.Width, opts.Height)
+		if err != nil {
+			return nil, err
+		}
+		b.MSAA = opts.MSAA
+		return b, nil
+	})
+}
+
+// RegisterBackend makes a backend available to NewWithBackend under the
+// given name. It is meant to be called from the init function of the
+// package that implements the backend, so that importing the package is
+// enough to make the backend selectable. The software backend is always
+// registered as "software". Registering the same name twice panics
+func RegisterBackend(name string, factory BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if factory == nil {
+		panic("canvas: RegisterBackend factory is nil")
+	}
+	if _, ok := backends[name]; ok {
+		panic("canvas: RegisterBackend called twice for backend " + name)
+	}
+	backends[name] = factory
+}
+
+// Backends returns the sorted names of the registered backends
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewWithBackend creates a canvas with a new backend of the registered
+// name, for example chosen from a configuration file
+func NewWithBackend(name string, opts BackendOptions) (*Canvas, error) {
+	backendsMu.RLock()
+	factory, ok := backends[name]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown backend %q", name)
+	}
+	b, err := factory(opts)
+	if err != nil {
+		return nil, err
+	}
+	return New(b), nil
+}
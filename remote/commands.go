@@ -0,0 +1,421 @@
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/opentoys/canvas"
+)
+
+// commands are the methods and properties of CanvasRenderingContext2D
+// and the commands that create objects, by name
+var commands = map[string]func(s *session, a *args) error{
+	// state
+	"save":    func(s *session, a *args) error { s.cv.Save(); return nil },
+	"restore": func(s *session, a *args) error { s.cv.Restore(); return nil },
+
+	// transformations
+	"scale":     func(s *session, a *args) error { s.cv.Scale(a.num(0), a.num(1)); return nil },
+	"rotate":    func(s *session, a *args) error { s.cv.Rotate(a.num(0)); return nil },
+	"translate": func(s *session, a *args) error { s.cv.Translate(a.num(0), a.num(1)); return nil },
+	"transform": func(s *session, a *args) error {
+		m := a.nums(0, 6)
+		s.cv.Transform(m[0], m[1], m[2], m[3], m[4], m[5])
+		return nil
+	},
+	"setTransform": func(s *session, a *args) error {
+		m := a.nums(0, 6)
+		s.cv.SetTransform(m[0], m[1], m[2], m[3], m[4], m[5])
+		return nil
+	},
+	"resetTransform": func(s *session, a *args) error { s.cv.SetTransform(1, 0, 0, 1, 0, 0); return nil },
+
+	// rectangles
+	"clearRect": func(s *session, a *args) error {
+		r := a.nums(0, 4)
+		s.cv.ClearRect(r[0], r[1], r[2], r[3])
+		return nil
+	},
+	"fillRect": func(s *session, a *args) error {
+		r := a.nums(0, 4)
+		s.cv.FillRect(r[0], r[1], r[2], r[3])
+		return nil
+	},
+	"strokeRect": func(s *session, a *args) error {
+		r := a.nums(0, 4)
+		s.cv.StrokeRect(r[0], r[1], r[2], r[3])
+		return nil
+	},
+
+	// paths
+	"beginPath": func(s *session, a *args) error { s.cv.BeginPath(); return nil },
+	"closePath": func(s *session, a *args) error { s.cv.ClosePath(); return nil },
+	"moveTo":    func(s *session, a *args) error { s.cv.MoveTo(a.num(0), a.num(1)); return nil },
+	"lineTo":    func(s *session, a *args) error { s.cv.LineTo(a.num(0), a.num(1)); return nil },
+	"bezierCurveTo": func(s *session, a *args) error {
+		p := a.nums(0, 6)
+		s.cv.BezierCurveTo(p[0], p[1], p[2], p[3], p[4], p[5])
+		return nil
+	},
+	"quadraticCurveTo": func(s *session, a *args) error {
+		p := a.nums(0, 4)
+		s.cv.QuadraticCurveTo(p[0], p[1], p[2], p[3])
+		return nil
+	},
+	"arc": func(s *session, a *args) error {
+		p := a.nums(0, 5)
+		s.cv.Arc(p[0], p[1], p[2], p[3], p[4], a.flag(5))
+		return nil
+	},
+	"arcTo": func(s *session, a *args) error {
+		p := a.nums(0, 5)
+		s.cv.ArcTo(p[0], p[1], p[2], p[3], p[4])
+		return nil
+	},
+	"ellipse": func(s *session, a *args) error {
+		p := a.nums(0, 7)
+		s.cv.Ellipse(p[0], p[1], p[2], p[3], p[4], p[5], p[6], a.flag(7))
+		return nil
+	},
+	"rect": func(s *session, a *args) error {
+		r := a.nums(0, 4)
+		s.cv.Rect(r[0], r[1], r[2], r[3])
+		return nil
+	},
+	"fill":   func(s *session, a *args) error { s.cv.Fill(); return nil },
+	"stroke": func(s *session, a *args) error { s.cv.Stroke(); return nil },
+	"clip":   func(s *session, a *args) error { s.cv.Clip(); return nil },
+
+	// text
+	"fillText":   func(s *session, a *args) error { s.cv.FillText(a.str(0), a.num(1), a.num(2)); return nil },
+	"strokeText": func(s *session, a *args) error { s.cv.StrokeText(a.str(0), a.num(1), a.num(2)); return nil },
+
+	// images
+	"drawImage": func(s *session, a *args) error {
+		img, ok := s.objects[a.str(0)].(*canvas.Image)
+		coords := a.rest(1)
+		if a.err != nil {
+			return nil
+		}
+		if !ok {
+			return errors.New("Unknown image")
+		}
+		switch len(coords) {
+		case 2, 4, 8:
+		default:
+			return fmt.Errorf("Expected 2, 4 or 8 coordinates, got %d", len(coords))
+		}
+		s.cv.DrawImage(img, coords...)
+		return nil
+	},
+
+	// properties
+	"fillStyle": func(s *session, a *args) error {
+		s.cv.SetFillStyle(s.style(a.str(0)))
+		return nil
+	},
+	"strokeStyle": func(s *session, a *args) error {
+		s.cv.SetStrokeStyle(s.style(a.str(0)))
+		return nil
+	},
+	"globalAlpha":    func(s *session, a *args) error { s.cv.SetGlobalAlpha(a.num(0)); return nil },
+	"lineWidth":      func(s *session, a *args) error { s.cv.SetLineWidth(a.num(0)); return nil },
+	"miterLimit":     func(s *session, a *args) error { s.cv.SetMiterLimit(a.num(0)); return nil },
+	"lineDashOffset": func(s *session, a *args) error { s.cv.SetLineDashOffset(a.num(0)); return nil },
+	"setLineDash": func(s *session, a *args) error {
+		var dash []float64
+		a.arg(0, &dash)
+		s.cv.SetLineDash(dash)
+		return nil
+	},
+	"lineCap": func(s *session, a *args) error {
+		switch v := a.str(0); v {
+		case "butt":
+			s.cv.SetLineCap(canvas.Butt)
+		case "round":
+			s.cv.SetLineCap(canvas.Round)
+		case "square":
+			s.cv.SetLineCap(canvas.Square)
+		default:
+			return fmt.Errorf("Unknown value %q", v)
+		}
+		return nil
+	},
+	"lineJoin": func(s *session, a *args) error {
+		switch v := a.str(0); v {
+		case "miter":
+			s.cv.SetLineJoin(canvas.Miter)
+		case "round":
+			s.cv.SetLineJoin(canvas.Round)
+		case "bevel":
+			s.cv.SetLineJoin(canvas.Bevel)
+		default:
+			return fmt.Errorf("Unknown value %q", v)
+		}
+		return nil
+	},
+	"shadowColor":   func(s *session, a *args) error { s.cv.SetShadowColor(a.str(0)); return nil },
+	"shadowBlur":    func(s *session, a *args) error { s.cv.SetShadowBlur(a.num(0)); return nil },
+	"shadowOffsetX": func(s *session, a *args) error { s.cv.SetShadowOffsetX(a.num(0)); return nil },
+	"shadowOffsetY": func(s *session, a *args) error { s.cv.SetShadowOffsetY(a.num(0)); return nil },
+	"textAlign": func(s *session, a *args) error {
+		switch v := a.str(0); v {
+		case "left":
+			s.cv.SetTextAlign(canvas.Left)
+		case "center":
+			s.cv.SetTextAlign(canvas.Center)
+		case "right":
+			s.cv.SetTextAlign(canvas.Right)
+		case "start":
+			s.cv.SetTextAlign(canvas.Start)
+		case "end":
+			s.cv.SetTextAlign(canvas.End)
+		default:
+			return fmt.Errorf("Unknown value %q", v)
+		}
+		return nil
+	},
+	"textBaseline": func(s *session, a *args) error {
+		switch v := a.str(0); v {
+		case "alphabetic":
+			s.cv.SetTextBaseline(canvas.Alphabetic)
+		case "top":
+			s.cv.SetTextBaseline(canvas.Top)
+		case "hanging":
+			s.cv.SetTextBaseline(canvas.Hanging)
+		case "middle":
+			s.cv.SetTextBaseline(canvas.Middle)
+		case "ideographic":
+			s.cv.SetTextBaseline(canvas.Ideographic)
+		case "bottom":
+			s.cv.SetTextBaseline(canvas.Bottom)
+		default:
+			return fmt.Errorf("Unknown value %q", v)
+		}
+		return nil
+	},
+	"font": func(s *session, a *args) error { return s.setFont(a.str(0)) },
+
+	// objects
+	"createLinearGradient": func(s *session, a *args) error {
+		id := a.str(0)
+		p := a.nums(1, 4)
+		if a.err != nil {
+			return nil
+		}
+		if err := s.checkNew(id, false); err != nil {
+			return err
+		}
+		s.objects[id] = s.cv.CreateLinearGradient(p[0], p[1], p[2], p[3])
+		return nil
+	},
+	"createRadialGradient": func(s *session, a *args) error {
+		id := a.str(0)
+		p := a.nums(1, 6)
+		if a.err != nil {
+			return nil
+		}
+		if err := s.checkNew(id, false); err != nil {
+			return err
+		}
+		s.objects[id] = s.cv.CreateRadialGradient(p[0], p[1], p[2], p[3], p[4], p[5])
+		return nil
+	},
+	"addColorStop": func(s *session, a *args) error {
+		obj, pos, color := s.objects[a.str(0)], a.num(1), a.str(2)
+		if a.err != nil {
+			return nil
+		}
+		switch g := obj.(type) {
+		case *canvas.LinearGradient:
+			g.AddColorStop(pos, color)
+		case *canvas.RadialGradient:
+			g.AddColorStop(pos, color)
+		default:
+			return errors.New("Unknown gradient")
+		}
+		return nil
+	},
+	"gradientDither": func(s *session, a *args) error {
+		obj, mode := s.objects[a.str(0)], a.str(1)
+		if a.err != nil {
+			return nil
+		}
+		dither := canvas.NoDither
+		switch mode {
+		case "none":
+		case "ordered":
+			dither = canvas.DitherOrdered
+		case "noise":
+			dither = canvas.DitherNoise
+		default:
+			return fmt.Errorf("Unknown dithering %q", mode)
+		}
+		switch g := obj.(type) {
+		case *canvas.LinearGradient:
+			g.SetDither(dither)
+		case *canvas.RadialGradient:
+			g.SetDither(dither)
+		default:
+			return errors.New("Unknown gradient")
+		}
+		return nil
+	},
+	"createPattern": func(s *session, a *args) error {
+		id := a.str(0)
+		img, ok := s.objects[a.str(1)].(*canvas.Image)
+		repeat := "repeat"
+		if a.opt(2) {
+			repeat = a.str(2)
+		}
+		if a.err != nil {
+			return nil
+		}
+		if !ok {
+			return errors.New("Unknown image")
+		}
+		if err := s.checkNew(id, false); err != nil {
+			return err
+		}
+		rep := canvas.Repeat
+		switch repeat {
+		case "repeat":
+		case "repeat-x":
+			rep = canvas.RepeatX
+		case "repeat-y":
+			rep = canvas.RepeatY
+		case "no-repeat":
+			rep = canvas.NoRepeat
+		case "round":
+			rep = canvas.RepeatRound
+		case "space":
+			rep = canvas.RepeatSpace
+		default:
+			return fmt.Errorf("Unknown repetition %q", repeat)
+		}
+		s.objects[id] = s.cv.CreatePattern(img, rep)
+		return nil
+	},
+	"loadImage": func(s *session, a *args) error {
+		id := a.str(0)
+		var data []byte
+		a.arg(1, &data)
+		if a.err != nil {
+			return nil
+		}
+		if err := s.checkNew(id, false); err != nil {
+			return err
+		}
+		if err := checkImageSize(data); err != nil {
+			return err
+		}
+		img, err := s.cv.LoadImageBytes(data)
+		if err != nil {
+			return err
+		}
+		if old, ok := s.objects[id].(*canvas.Image); ok {
+			old.Delete()
+		}
+		s.objects[id] = img
+		return nil
+	},
+	"loadFont": func(s *session, a *args) error {
+		name := a.str(0)
+		var data []byte
+		a.arg(1, &data)
+		if a.err != nil {
+			return nil
+		}
+		if err := s.checkNew(name, true); err != nil {
+			return err
+		}
+		font, err := s.cv.LoadFont(data)
+		if err != nil {
+			return err
+		}
+		s.fonts[name] = font
+		return nil
+	},
+}
+
+// minArgs are the numbers of required arguments of the commands, checked
+// before they are run so that commands with missing arguments don't draw
+// with zeros instead
+var minArgs = map[string]int{
+	"scale": 2, "rotate": 1, "translate": 2, "transform": 6, "setTransform": 6,
+	"clearRect": 4, "fillRect": 4, "strokeRect": 4,
+	"moveTo": 2, "lineTo": 2, "bezierCurveTo": 6, "quadraticCurveTo": 4,
+	"arc": 5, "arcTo": 5, "ellipse": 7, "rect": 4,
+	"fillText": 3, "strokeText": 3, "drawImage": 3,
+	"fillStyle": 1, "strokeStyle": 1, "globalAlpha": 1, "lineWidth": 1,
+	"miterLimit": 1, "lineDashOffset": 1, "setLineDash": 1, "lineCap": 1,
+	"lineJoin": 1, "shadowColor": 1, "shadowBlur": 1, "shadowOffsetX": 1,
+	"shadowOffsetY": 1, "textAlign": 1, "textBaseline": 1, "font": 1,
+	"createLinearGradient": 5, "createRadialGradient": 7, "addColorStop": 3,
+	"gradientDither": 2, "createPattern": 2, "loadImage": 2, "loadFont": 2,
+}
+
+// checkImageSize returns an error if the image file would decode to an
+// image larger than canvas.SizeLimits, so that small files can't make
+// the server allocate huge images
+func checkImageSize(data []byte) error {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	w, h := cfg.Width, cfg.Height
+	if max := canvas.SizeLimits.MaxDimension; max > 0 && (w > max || h > max) {
+		return fmt.Errorf("Image size %dx%d exceeds the maximum dimension of %d", w, h, max)
+	}
+	if max := canvas.SizeLimits.MaxBytes; max > 0 && w > 0 && h > max/4/w {
+		return fmt.Errorf("Image size %dx%d exceeds the memory limit of %d bytes", w, h, max)
+	}
+	return nil
+}
+
+// flag decodes the optional boolean argument i
+func (a *args) flag(i int) bool {
+	var v bool
+	if a.opt(i) {
+		a.arg(i, &v)
+	}
+	return v
+}
+
+// style returns the object with the id, or the value as a color
+func (s *session) style(v string) interface{} {
+	switch obj := s.objects[v].(type) {
+	case *canvas.LinearGradient, *canvas.RadialGradient, *canvas.ImagePattern:
+		return obj
+	}
+	return v
+}
+
+// setFont sets the font from a CSS font value. Only the size in pixels
+// and the family are used, the family is the name of a font loaded with
+// loadFont, or the default font if there is none with the name
+func (s *session) setFont(v string) error {
+	fields := strings.Fields(v)
+	for i, f := range fields {
+		if !strings.HasSuffix(f, "px") {
+			continue
+		}
+		size, err := strconv.ParseFloat(strings.TrimSuffix(f, "px"), 64)
+		if err != nil || size <= 0 {
+			return fmt.Errorf("Invalid font size %q", f)
+		}
+		var font interface{}
+		if family := strings.Trim(strings.Join(fields[i+1:], " "), `"'`); family != "" {
+			if f, ok := s.fonts[family]; ok {
+				font = f
+			}
+		}
+		s.cv.SetFont(font, size)
+		return nil
+	}
+	return fmt.Errorf("Missing font size in %q", v)
+}
@@ -0,0 +1,288 @@
+// Package remote serves canvases over WebSocket, so that thin clients and
+// programs in other languages can draw with this engine.
+//
+// Every WebSocket connection gets its own software canvas, with the size
+// given by the width and height query parameters. The client sends text
+// messages with a JSON array of commands, and each command is an array of
+// the name of a method or property of CanvasRenderingContext2D followed
+// by its arguments:
+//
+//	[["fillStyle", "#f00"], ["fillRect", 0, 0, 100, 50],
+//	 ["beginPath"], ["arc", 50, 50, 20, 0, 6.283], ["stroke"], ["frame"]]
+//
+// Properties are set by passing the value. Objects such as gradients,
+// patterns, images and fonts are created with an id chosen by the client
+// as the first argument, and are used by passing the id in place of the
+// object:
+//
+//	[["createLinearGradient", "g", 0, 0, 100, 0],
+//	 ["addColorStop", "g", 0, "#f00"], ["addColorStop", "g", 1, "#00f"],
+//	 ["fillStyle", "g"], ["loadImage", "logo", "<base64 PNG>"],
+//	 ["drawImage", "logo", 10, 10], ["loadFont", "sans", "<base64 TTF>"],
+//	 ["font", "20px sans"], ["fillText", "Hi", 10, 40]]
+//
+// The frame command sends the canvas back as a binary message with a PNG
+// image. Errors are sent as text messages of the form
+// {"error": "...", "command": index}, the remaining commands of the
+// message are skipped
+package remote
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"net/http"
+	"strconv"
+
+	"github.com/opentoys/canvas"
+)
+
+// Server is an http.Handler that accepts WebSocket connections and
+// renders the commands of each connection on its own canvas
+type Server struct {
+	// Width and Height are the size of canvases when the client does not
+	// ask for one. Zero is 300x150 like an HTML canvas
+	Width, Height int
+	// MaxWidth and MaxHeight limit the size that clients can ask for.
+	// Zero is 4096
+	MaxWidth, MaxHeight int
+	// MaxMessage limits the size of messages from clients. Zero is 16 MiB
+	MaxMessage int
+	// MaxObjects limits the number of gradients, patterns, images and
+	// fonts that a client can create. Zero is 1024
+	MaxObjects int
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection and renders
+// the commands of the client until it disconnects
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	width, height, err := s.size(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxLen := s.MaxMessage
+	if maxLen <= 0 {
+		maxLen = 16 << 20
+	}
+	conn, err := upgrade(w, r, maxLen)
+	if err != nil {
+		return
+	}
+	defer conn.close()
+
+	sess := newSession(canvas.New(canvas.NewBackend(width, height)))
+	if s.MaxObjects > 0 {
+		sess.maxObjects = s.MaxObjects
+	}
+	for {
+		op, msg, err := conn.readMessage()
+		if err != nil {
+			return
+		}
+		if op != opText {
+			conn.writeMessage(opText, errorMessage(-1, "Expected a text message"))
+			continue
+		}
+		if err := sess.run(msg, conn); err != nil {
+			return
+		}
+	}
+}
+
+// size returns the canvas size asked for in the query
+func (s *Server) size(r *http.Request) (int, int, error) {
+	w, h := s.Width, s.Height
+	if w <= 0 || h <= 0 {
+		w, h = 300, 150
+	}
+	maxW, maxH := s.MaxWidth, s.MaxHeight
+	if maxW <= 0 {
+		maxW = 4096
+	}
+	if maxH <= 0 {
+		maxH = 4096
+	}
+	q := r.URL.Query()
+	for _, p := range []struct {
+		name string
+		v    *int
+		max  int
+	}{{"width", &w, maxW}, {"height", &h, maxH}} {
+		str := q.Get(p.name)
+		if str == "" {
+			continue
+		}
+		n, err := strconv.Atoi(str)
+		if err != nil || n <= 0 || n > p.max {
+			return 0, 0, fmt.Errorf("Invalid canvas %s %q", p.name, str)
+		}
+		*p.v = n
+	}
+	return w, h, nil
+}
+
+func errorMessage(index int, msg string) []byte {
+	data, _ := json.Marshal(struct {
+		Error   string `json:"error"`
+		Command int    `json:"command"`
+	}{msg, index})
+	return data
+}
+
+// session is the canvas of one connection and the objects the client
+// created
+type session struct {
+	cv         *canvas.Canvas
+	objects    map[string]interface{}
+	fonts      map[string]*canvas.Font
+	maxObjects int
+}
+
+func newSession(cv *canvas.Canvas) *session {
+	return &session{
+		cv:         cv,
+		objects:    make(map[string]interface{}),
+		fonts:      make(map[string]*canvas.Font),
+		maxObjects: 1024,
+	}
+}
+
+// checkNew returns an error if a new object with the id would exceed the
+// object limit. Replacing an existing object is always possible
+func (s *session) checkNew(id string, font bool) error {
+	if _, ok := s.objects[id]; ok && !font {
+		return nil
+	}
+	if _, ok := s.fonts[id]; ok && font {
+		return nil
+	}
+	if len(s.objects)+len(s.fonts) >= s.maxObjects {
+		return fmt.Errorf("Too many objects, the limit is %d", s.maxObjects)
+	}
+	return nil
+}
+
+// run executes the commands of a message. Only errors of the connection
+// are returned, errors of commands are sent to the client
+func (s *session) run(msg []byte, conn *wsConn) error {
+	var cmds [][]json.RawMessage
+	if err := json.Unmarshal(msg, &cmds); err != nil {
+		return conn.writeMessage(opText, errorMessage(-1, err.Error()))
+	}
+	for i, cmd := range cmds {
+		a := args{raw: cmd}
+		name := a.str(-1)
+		if a.err != nil {
+			return conn.writeMessage(opText, errorMessage(i, "Expected a command name"))
+		}
+		if name == "frame" {
+			var buf bytes.Buffer
+			w, h := s.cv.Size()
+			if err := s.cv.Screenshot(&buf, image.Rect(0, 0, w, h), canvas.ScreenshotOptions{}); err != nil {
+				return conn.writeMessage(opText, errorMessage(i, err.Error()))
+			}
+			if err := conn.writeMessage(opBinary, buf.Bytes()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.exec(name, &a); err != nil {
+			return conn.writeMessage(opText, errorMessage(i, err.Error()))
+		}
+	}
+	return nil
+}
+
+// exec runs the command with the name
+func (s *session) exec(name string, a *args) error {
+	fn, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("Unknown command %q", name)
+	}
+	if n := minArgs[name]; len(a.raw)-1 < n {
+		return fmt.Errorf("%s: expected %d arguments, got %d", name, n, len(a.raw)-1)
+	}
+	err := fn(s, a)
+	if err == nil {
+		err = a.err
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// Interpreter runs the commands of the protocol on a canvas without a
+// connection, for example from a script. Every interpreter has its own
+// objects. The frame command is not available
+type Interpreter struct {
+	s *session
+}
+
+// NewInterpreter creates an interpreter that draws on the canvas
+func NewInterpreter(cv *canvas.Canvas) *Interpreter {
+	return &Interpreter{s: newSession(cv)}
+}
+
+// Run runs the command with the arguments given as JSON values
+func (in *Interpreter) Run(name string, values ...json.RawMessage) error {
+	quoted, _ := json.Marshal(name)
+	raw := make([]json.RawMessage, 0, len(values)+1)
+	raw = append(raw, quoted)
+	return in.s.exec(name, &args{raw: append(raw, values...)})
+}
+
+// args decodes the arguments of a command. The first error is kept and
+// later calls return zero values
+type args struct {
+	raw []json.RawMessage
+	err error
+}
+
+// arg decodes the argument with index i, not counting the name
+func (a *args) arg(i int, v interface{}) {
+	if a.err != nil {
+		return
+	}
+	if i+1 >= len(a.raw) {
+		a.err = fmt.Errorf("Missing argument %d", i+1)
+		return
+	}
+	if err := json.Unmarshal(a.raw[i+1], v); err != nil {
+		a.err = fmt.Errorf("Argument %d: %v", i+1, err)
+	}
+}
+
+func (a *args) num(i int) float64 {
+	var v float64
+	a.arg(i, &v)
+	return v
+}
+
+func (a *args) str(i int) string {
+	var v string
+	a.arg(i, &v)
+	return v
+}
+
+// opt returns whether the optional argument i is given
+func (a *args) opt(i int) bool { return i+1 < len(a.raw) }
+
+// nums decodes the n arguments from i on
+func (a *args) nums(i, n int) []float64 {
+	v := make([]float64, n)
+	for j := range v {
+		v[j] = a.num(i + j)
+	}
+	return v
+}
+
+// rest decodes all arguments from i on as numbers
+func (a *args) rest(i int) []float64 {
+	if len(a.raw)-1 <= i {
+		return nil
+	}
+	return a.nums(i, len(a.raw)-1-i)
+}
@@ -0,0 +1,261 @@
+package remote_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/opentoys/canvas/remote"
+)
+
+// client is a minimal WebSocket client for the tests
+type client struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, srv *httptest.Server, query string) *client {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "GET /?"+query+" HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected the connection to be upgraded, got %s", resp.Status)
+	}
+	// the example key and accept value of RFC 6455
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Wrong accept key %q", got)
+	}
+	return &client{conn: conn, r: r}
+}
+
+func (c *client) send(t *testing.T, op byte, msg []byte) {
+	hdr := []byte{0x80 | op}
+	switch {
+	case len(msg) < 126:
+		hdr = append(hdr, 0x80|byte(len(msg)))
+	default:
+		hdr = append(hdr, 0x80|126, byte(len(msg)>>8), byte(len(msg)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	masked := make([]byte, len(msg))
+	for i := range msg {
+		masked[i] = msg[i] ^ mask[i&3]
+	}
+	if _, err := c.conn.Write(append(append(hdr, mask...), masked...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *client) sendJSON(t *testing.T, cmds ...[]interface{}) {
+	data, err := json.Marshal(cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.send(t, 0x1, data)
+}
+
+func (c *client) read(t *testing.T) (byte, []byte) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(c.r, hdr[:2]); err != nil {
+		t.Fatal(err)
+	}
+	op := hdr[0] & 0x0F
+	n := int(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		io.ReadFull(c.r, hdr[:2])
+		n = int(binary.BigEndian.Uint16(hdr[:2]))
+	case 127:
+		io.ReadFull(c.r, hdr[:8])
+		n = int(binary.BigEndian.Uint64(hdr[:8]))
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(c.r, msg); err != nil {
+		t.Fatal(err)
+	}
+	return op, msg
+}
+
+func (c *client) frame(t *testing.T) *image.RGBA {
+	op, msg := c.read(t)
+	if op != 0x2 {
+		t.Fatalf("Expected a binary frame, got %q", msg)
+	}
+	img, err := png.Decode(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	for y := 0; y < rgba.Rect.Dy(); y++ {
+		for x := 0; x < rgba.Rect.Dx(); x++ {
+			rgba.Set(x, y, img.At(x, y))
+		}
+	}
+	return rgba
+}
+
+func (c *client) error(t *testing.T) (string, int) {
+	op, msg := c.read(t)
+	if op != 0x1 {
+		t.Fatalf("Expected an error message, got opcode %d", op)
+	}
+	var e struct {
+		Error   string
+		Command int
+	}
+	if err := json.Unmarshal(msg, &e); err != nil {
+		t.Fatal(err)
+	}
+	return e.Error, e.Command
+}
+
+func TestServer(t *testing.T) {
+	srv := httptest.NewServer(&remote.Server{})
+	defer srv.Close()
+	c := dial(t, srv, "width=40&height=30")
+	defer c.conn.Close()
+
+	var white bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	png.Encode(&white, img)
+
+	c.sendJSON(t,
+		[]interface{}{"fillStyle", "#f00"},
+		[]interface{}{"fillRect", 0, 0, 20, 30},
+		[]interface{}{"createLinearGradient", "g", 20, 0, 40, 0},
+		[]interface{}{"addColorStop", "g", 0, "#00f"},
+		[]interface{}{"addColorStop", "g", 1, "#00f"},
+		[]interface{}{"fillStyle", "g"},
+		[]interface{}{"save"},
+		[]interface{}{"translate", 20, 0},
+		[]interface{}{"fillRect", 0, 0, 20, 30},
+		[]interface{}{"restore"},
+		[]interface{}{"loadImage", "white", base64.StdEncoding.EncodeToString(white.Bytes())},
+		[]interface{}{"drawImage", "white", 0, 0},
+		[]interface{}{"frame"},
+	)
+	frame := c.frame(t)
+	if frame.Rect.Dx() != 40 || frame.Rect.Dy() != 30 {
+		t.Fatalf("Expected a 40x30 frame, got %v", frame.Rect)
+	}
+	for _, p := range []struct {
+		x, y int
+		c    color.RGBA
+	}{
+		{1, 1, color.RGBA{255, 255, 255, 255}},
+		{10, 10, color.RGBA{255, 0, 0, 255}},
+		{30, 10, color.RGBA{0, 0, 255, 255}},
+	} {
+		if got := frame.RGBAAt(p.x, p.y); got != p.c {
+			t.Errorf("Expected %v at %d,%d, got %v", p.c, p.x, p.y, got)
+		}
+	}
+
+	// errors skip the rest of the message
+	c.sendJSON(t, []interface{}{"beginPath"}, []interface{}{"fillRect", 0, 0}, []interface{}{"frame"})
+	if msg, i := c.error(t); i != 1 || !strings.Contains(msg, "fillRect") {
+		t.Fatalf("Expected an error for command 1, got %q for %d", msg, i)
+	}
+	c.sendJSON(t, []interface{}{"drawCircle", 0, 0})
+	if msg, _ := c.error(t); !strings.Contains(msg, "Unknown command") {
+		t.Fatalf("Expected an unknown command error, got %q", msg)
+	}
+	c.send(t, 0x1, []byte("{"))
+	if _, i := c.error(t); i != -1 {
+		t.Fatalf("Expected an error for the message, got %d", i)
+	}
+
+	// pings are answered between messages
+	c.send(t, 0x9, []byte("hi"))
+	if op, msg := c.read(t); op != 0xA || string(msg) != "hi" {
+		t.Fatalf("Expected a pong, got opcode %d %q", op, msg)
+	}
+	c.send(t, 0x8, nil)
+	if op, _ := c.read(t); op != 0x8 {
+		t.Fatalf("Expected a close frame, got opcode %d", op)
+	}
+}
+
+func TestServerSize(t *testing.T) {
+	srv := httptest.NewServer(&remote.Server{MaxWidth: 100})
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/?width=200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected a bad request for a too large canvas, got %s", resp.Status)
+	}
+	resp, err = http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected a bad request without an upgrade, got %s", resp.Status)
+	}
+
+	c := dial(t, srv, "")
+	defer c.conn.Close()
+	c.sendJSON(t, []interface{}{"frame"})
+	if frame := c.frame(t); frame.Rect.Dx() != 300 || frame.Rect.Dy() != 150 {
+		t.Fatalf("Expected the default size of 300x150, got %v", frame.Rect)
+	}
+}
+
+func TestServerLimits(t *testing.T) {
+	srv := httptest.NewServer(&remote.Server{MaxObjects: 2})
+	defer srv.Close()
+	c := dial(t, srv, "width=40&height=30")
+	defer c.conn.Close()
+
+	// a zero dash is ignored instead of stroking forever
+	c.sendJSON(t, []interface{}{"setLineDash", []float64{0, 0}}, []interface{}{"strokeRect", 5, 5, 20, 20}, []interface{}{"frame"})
+	c.frame(t)
+
+	// a PNG header claiming a huge image is rejected before decoding
+	var huge bytes.Buffer
+	png.Encode(&huge, image.NewGray(image.Rect(0, 0, 1, 1)))
+	data := huge.Bytes()
+	binary.BigEndian.PutUint32(data[16:], 100000)
+	binary.BigEndian.PutUint32(data[20:], 100000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	c.sendJSON(t, []interface{}{"loadImage", "huge", base64.StdEncoding.EncodeToString(data)})
+	if msg, _ := c.error(t); !strings.Contains(msg, "exceeds") {
+		t.Fatalf("Expected a size error, got %q", msg)
+	}
+
+	c.sendJSON(t,
+		[]interface{}{"createLinearGradient", "a", 0, 0, 1, 0},
+		[]interface{}{"createLinearGradient", "b", 0, 0, 1, 0},
+		[]interface{}{"createLinearGradient", "a", 0, 0, 2, 0},
+		[]interface{}{"createLinearGradient", "c", 0, 0, 1, 0},
+	)
+	if msg, i := c.error(t); i != 3 || !strings.Contains(msg, "Too many objects") {
+		t.Fatalf("Expected an object limit error for command 3, got %q for %d", msg, i)
+	}
+}
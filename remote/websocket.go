@@ -0,0 +1,203 @@
+package remote
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// websocketGUID is appended to the key of the client to compute the
+// accept header of the handshake
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+var errMessageTooLarge = errors.New("WebSocket message too large")
+
+// wsConn is the server side of a WebSocket connection, implemented with
+// the standard library only. Only what the protocol of this package
+// needs is supported, which is whole messages without extensions
+type wsConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	maxLen int
+}
+
+// upgrade performs the WebSocket handshake and takes over the connection
+func upgrade(w http.ResponseWriter, r *http.Request, maxLen int) (*wsConn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil, errors.New("Not a WebSocket request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("Unsupported WebSocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Missing WebSocket key", http.StatusBadRequest)
+		return nil, errors.New("Missing WebSocket key")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Connection can't be upgraded", http.StatusInternalServerError)
+		return nil, errors.New("Response does not support hijacking")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
+	rw.WriteString(acceptKey(key))
+	rw.WriteString("\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, r: rw.Reader, w: rw.Writer, maxLen: maxLen}, nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readMessage returns the next text or binary message. Pings are
+// answered while waiting, and io.EOF is returned when the client closes
+// the connection
+func (c *wsConn) readMessage() (op byte, msg []byte, err error) {
+	for {
+		fin, frameOp, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch frameOp {
+		case opPing:
+			if err := c.writeMessage(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeMessage(opClose, nil)
+			return 0, nil, io.EOF
+		case opContinuation:
+			if op == 0 {
+				return 0, nil, errors.New("Unexpected WebSocket continuation frame")
+			}
+		case opText, opBinary:
+			if op != 0 {
+				return 0, nil, errors.New("Expected a WebSocket continuation frame")
+			}
+			op = frameOp
+		default:
+			return 0, nil, errors.New("Unknown WebSocket opcode")
+		}
+		if len(msg)+len(payload) > c.maxLen {
+			return 0, nil, errMessageTooLarge
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return op, msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(c.r, hdr[:2]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = hdr[0]&0x80 != 0
+	op = hdr[0] & 0x0F
+	if hdr[0]&0x70 != 0 {
+		return false, 0, nil, errors.New("Unsupported WebSocket extension")
+	}
+	if hdr[1]&0x80 == 0 {
+		return false, 0, nil, errors.New("Unmasked WebSocket frame from the client")
+	}
+	n := uint64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		if _, err := io.ReadFull(c.r, hdr[:2]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(hdr[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.r, hdr[:8]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(hdr[:8])
+	}
+	if n > uint64(c.maxLen) {
+		return false, 0, nil, errMessageTooLarge
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i&3]
+	}
+	return fin, op, payload, nil
+}
+
+// writeMessage sends a message in a single unmasked frame
+func (c *wsConn) writeMessage(op byte, msg []byte) error {
+	var hdr [10]byte
+	hdr[0] = 0x80 | op
+	n := 2
+	switch l := len(msg); {
+	case l < 126:
+		hdr[1] = byte(l)
+	case l <= 0xFFFF:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(l))
+		n = 4
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(l))
+		n = 10
+	}
+	c.w.Write(hdr[:n])
+	c.w.Write(msg)
+	return c.w.Flush()
+}
+
+func (c *wsConn) close() error {
+	return c.conn.Close()
+}
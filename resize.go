@@ -0,0 +1,129 @@
+package canvas
+
+import (
+	"errors"
+	"math"
+)
+
+// ResizePolicy defines what happens to the existing content of a canvas
+// when it is resized with Resize
+type ResizePolicy uint8
+
+// Resize policy constants for Resize
+const (
+	// ResizeDiscard clears the canvas, same as creating a new one
+	ResizeDiscard ResizePolicy = iota
+	// ResizeKeep keeps the content at the top left corner,
+	// cropping or padding it with transparent pixels
+	ResizeKeep
+	// ResizeCenter keeps the content centered, cropping or
+	// padding it with transparent pixels
+	ResizeCenter
+	// ResizeStretch scales the content to the new size
+	ResizeStretch
+	// ResizeFit scales the content uniformly to fit into the
+	// new size and centers it, padding the rest with transparent
+	// pixels (letterboxing)
+	ResizeFit
+)
+
+// ResizableBackend is implemented by backends that can change their size
+// while keeping their content. SetSizePreserve resizes the backend and
+// returns the matrix that maps the old pixel coordinates to the new ones
+type ResizableBackend interface {
+	SetSizePreserve(w, h int, policy ResizePolicy) BackendMat
+}
+
+// resizeMat returns the matrix that maps coordinates of a canvas of the
+// size ow/oh to a canvas of the size w/h according to the policy
+func resizeMat(ow, oh, w, h int, policy ResizePolicy) BackendMat {
+	if ow <= 0 || oh <= 0 {
+		return BackendMatIdentity
+	}
+	switch policy {
+	case ResizeCenter:
+		return BackendMatTranslate(BackendVec{float64((w - ow) / 2), float64((h - oh) / 2)})
+	case ResizeStretch:
+		return BackendMatScale(BackendVec{float64(w) / float64(ow), float64(h) / float64(oh)})
+	case ResizeFit:
+		s := math.Min(float64(w)/float64(ow), float64(h)/float64(oh))
+		return BackendMatScale(BackendVec{s, s}).Mul(BackendMatTranslate(BackendVec{
+			math.Round((float64(w) - float64(ow)*s) * 0.5),
+			math.Round((float64(h) - float64(oh)*s) * 0.5),
+		}))
+	}
+	return BackendMatIdentity
+}
+
+// Resize changes the size of the canvas. The policy defines whether and
+// how the existing content is kept. The transformation, the current path,
+// the hit regions, and the clipping regions (including those on the state
+// stack) are remapped the same way as the content, so drawing continues to
+// line up with what was drawn before. An error is returned if the backend does
+// not implement ResizableBackend
+func (cv *Canvas) Resize(w, h int, policy ResizePolicy) error {
+	rb, ok := cv.backend().(ResizableBackend)
+	if !ok {
+		return errors.New("Backend does not support resizing")
+	}
+	cv.Flush()
+	m := rb.SetSizePreserve(w, h, policy)
+	cv.remap(m)
+	return nil
+}
+
+// remap applies the matrix to everything in the canvas state that is
+// stored in pixel coordinates and reapplies the clipping regions
+func (cv *Canvas) remap(m BackendMat) {
+	if cv.view.active {
+		// the transformations are in world coordinates, so only the
+		// view has to follow the content
+		cv.view.mat = cv.view.mat.Mul(m)
+	} else {
+		cv.state.transform = cv.state.transform.Mul(m)
+	}
+	cv.path.remap(m)
+	cv.hitRegions.remap(m)
+	cv.state.clip.remap(m)
+	for i := range cv.stateStack {
+		st := &cv.stateStack[i]
+		if !cv.view.active {
+			st.transform = st.transform.Mul(m)
+		}
+		st.clip.remap(m)
+	}
+	cv.reapplyClips()
+}
+
+// reapplyClips sets the clipping regions of the backend again from the
+// clip paths of the states on the stack and the current state
+func (cv *Canvas) reapplyClips() {
+	clip := cv.state.clip
+	cv.clearClip()
+	for _, st := range cv.stateStack {
+		if len(st.clip.p) > 0 {
+			cv.clip(&st.clip, BackendMatIdentity)
+		}
+	}
+	if len(clip.p) > 0 {
+		cv.clip(&clip, BackendMatIdentity)
+	}
+	cv.state.clip = clip
+}
+
+// remap transforms the path points into a new slice, since saved states
+// can share their clip paths with each other
+func (p *Path2D) remap(m BackendMat) {
+	if m == BackendMatIdentity || len(p.p) == 0 {
+		return
+	}
+	p.clearCache()
+	pts := make([]pathPoint, len(p.p), cap(p.p))
+	for i, pt := range p.p {
+		pt.pos = pt.pos.MulMat(m)
+		pt.next = pt.next.MulMat(m)
+		pts[i] = pt
+	}
+	p.p = pts
+	p.move = p.move.MulMat(m)
+}
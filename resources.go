@@ -0,0 +1,319 @@
+package canvas
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// ResourceTracker is a debugging backend that passes all calls to a
+// target backend and keeps a list of the images, gradients and patterns
+// that were loaded and not deleted yet, to find resources that leak in
+// long running programs. Resources are deleted when the canvas deletes
+// them, for example when an Image is deleted or a gradient is garbage
+// collected
+type ResourceTracker struct {
+	target Backend
+
+	// Stacks records the call stack where each resource is loaded.
+	// It is slow and only meant for debugging
+	Stacks bool
+
+	mu     sync.Mutex
+	nextID int
+	live   map[*Resource]struct{}
+}
+
+// ResourceKind is the type of a resource of a ResourceTracker
+type ResourceKind uint8
+
+// Resource kinds
+const (
+	ResourceImage ResourceKind = iota
+	ResourceLinearGradient
+	ResourceRadialGradient
+	ResourcePattern
+)
+
+func (k ResourceKind) String() string {
+	switch k {
+	case ResourceLinearGradient:
+		return "linearGradient"
+	case ResourceRadialGradient:
+		return "radialGradient"
+	case ResourcePattern:
+		return "pattern"
+	}
+	return "image"
+}
+
+// Resource is a resource of the target backend that was loaded and not
+// deleted yet
+type Resource struct {
+	// ID numbers the resources in the order they were loaded
+	ID   int
+	Kind ResourceKind
+	// Width and Height are the size of images
+	Width, Height int
+	// Bytes is the approximate memory used by the resource. Patterns
+	// use the memory of their image
+	Bytes   int
+	Created time.Time
+	// Stack is the call stack where the resource was loaded, if Stacks
+	// was set
+	Stack string
+}
+
+// NewResourceTracker creates a new resource tracker for the given target
+func NewResourceTracker(target Backend) *ResourceTracker {
+	return &ResourceTracker{target: target, live: make(map[*Resource]struct{})}
+}
+
+// Target returns the backend that the calls are passed to
+func (rt *ResourceTracker) Target() Backend { return rt.target }
+
+// Resources returns the resources that are loaded, in the order they
+// were loaded
+func (rt *ResourceTracker) Resources() []Resource {
+	rt.mu.Lock()
+	list := make([]Resource, 0, len(rt.live))
+	for res := range rt.live {
+		list = append(list, *res)
+	}
+	rt.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Report writes the loaded resources with their size and age, the call
+// stacks if they were recorded, and the totals per kind
+func (rt *ResourceTracker) Report(w io.Writer) error {
+	list := rt.Resources()
+	var counts, bytes [ResourcePattern + 1]int
+	now := time.Now()
+	var sb strings.Builder
+	for _, res := range list {
+		counts[res.Kind]++
+		bytes[res.Kind] += res.Bytes
+		fmt.Fprintf(&sb, "#%d %s", res.ID, res.Kind)
+		if res.Kind == ResourceImage {
+			fmt.Fprintf(&sb, " %dx%d", res.Width, res.Height)
+		}
+		fmt.Fprintf(&sb, " %d bytes, age %v\n", res.Bytes, now.Sub(res.Created).Round(time.Millisecond))
+		if res.Stack != "" {
+			for _, line := range strings.Split(strings.TrimSpace(res.Stack), "\n") {
+				sb.WriteString("\t" + line + "\n")
+			}
+		}
+	}
+	for kind := range counts {
+		fmt.Fprintf(&sb, "%s: %d, %d bytes\n", ResourceKind(kind), counts[kind], bytes[kind])
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (rt *ResourceTracker) add(kind ResourceKind, bytes int) *Resource {
+	res := &Resource{Kind: kind, Bytes: bytes, Created: time.Now()}
+	if rt.Stacks {
+		res.Stack = string(debug.Stack())
+	}
+	rt.mu.Lock()
+	rt.nextID++
+	res.ID = rt.nextID
+	rt.live[res] = struct{}{}
+	rt.mu.Unlock()
+	return res
+}
+
+func (rt *ResourceTracker) remove(res *Resource) {
+	rt.mu.Lock()
+	delete(rt.live, res)
+	rt.mu.Unlock()
+}
+
+func (rt *ResourceTracker) resize(res *Resource, w, h, bytes int) {
+	rt.mu.Lock()
+	res.Width, res.Height, res.Bytes = w, h, bytes
+	rt.mu.Unlock()
+}
+
+func (rt *ResourceTracker) image(bimg BackendImage, err error) (BackendImage, error) {
+	if err != nil {
+		return nil, err
+	}
+	w, h := bimg.Size()
+	res := rt.add(ResourceImage, w*h*4)
+	res.Width, res.Height = w, h
+	return &trackedImage{BackendImage: bimg, rt: rt, res: res}, nil
+}
+
+func gradientBytes(data BackendGradient) int {
+	return len(data) * int(unsafe.Sizeof(BackendGradientStop{}))
+}
+
+type trackedImage struct {
+	BackendImage
+	rt  *ResourceTracker
+	res *Resource
+}
+
+func (ti *trackedImage) Delete() {
+	ti.rt.remove(ti.res)
+	ti.BackendImage.Delete()
+}
+
+func (ti *trackedImage) Replace(src image.Image) error {
+	err := ti.BackendImage.Replace(src)
+	w, h := ti.BackendImage.Size()
+	ti.rt.resize(ti.res, w, h, w*h*4)
+	return err
+}
+
+func (ti *trackedImage) SubImage(rect image.Rectangle) BackendImage {
+	return NewBackendSubImage(ti, rect)
+}
+
+type trackedGradient struct {
+	BackendLinearGradient
+	rt  *ResourceTracker
+	res *Resource
+}
+
+func (tg *trackedGradient) Delete() {
+	tg.rt.remove(tg.res)
+	tg.BackendLinearGradient.Delete()
+}
+
+func (tg *trackedGradient) Replace(data BackendGradient) {
+	tg.rt.resize(tg.res, 0, 0, gradientBytes(data))
+	tg.BackendLinearGradient.Replace(data)
+}
+
+type trackedPattern struct {
+	BackendImagePattern
+	rt  *ResourceTracker
+	res *Resource
+}
+
+func (tp *trackedPattern) Delete() {
+	tp.rt.remove(tp.res)
+	tp.BackendImagePattern.Delete()
+}
+
+func (tp *trackedPattern) Replace(data BackendImagePatternData) {
+	tp.BackendImagePattern.Replace(untrackedPatternData(data))
+}
+
+// untrackedImage returns the image of the target backend
+func untrackedImage(img BackendImage) BackendImage {
+	switch v := img.(type) {
+	case *trackedImage:
+		return v.BackendImage
+	case *BackendSubImage:
+		if ti, ok := v.Parent().(*trackedImage); ok {
+			return NewBackendSubImage(ti.BackendImage, v.Rect())
+		}
+	}
+	return img
+}
+
+func untrackedPatternData(data BackendImagePatternData) BackendImagePatternData {
+	data.Image = untrackedImage(data.Image)
+	return data
+}
+
+// untrackedStyle returns the style with the resources of the target
+// backend
+func untrackedStyle(style *BackendFillStyle) *BackendFillStyle {
+	s := *style
+	if tg, ok := s.LinearGradient.(*trackedGradient); ok {
+		s.LinearGradient = tg.BackendLinearGradient
+	}
+	if tg, ok := s.RadialGradient.(*trackedGradient); ok {
+		s.RadialGradient = tg.BackendLinearGradient
+	}
+	if tp, ok := s.ImagePattern.(*trackedPattern); ok {
+		s.ImagePattern = tp.BackendImagePattern
+	}
+	return &s
+}
+
+func (rt *ResourceTracker) Size() (int, int) { return rt.target.Size() }
+
+func (rt *ResourceTracker) LoadImage(img image.Image) (BackendImage, error) {
+	return rt.image(rt.target.LoadImage(img))
+}
+
+func (rt *ResourceTracker) LoadImageMipmap(img image.Image, quality MipmapQuality) (BackendImage, error) {
+	return rt.image(loadImageMipmap(rt.target, img, quality))
+}
+
+func (rt *ResourceTracker) LoadImageYUV(y, u, v []byte, format YUVFormat) (BackendImage, error) {
+	return rt.image(loadImageYUV(rt.target, y, u, v, format))
+}
+
+func (rt *ResourceTracker) LoadImagePattern(data BackendImagePatternData) BackendImagePattern {
+	ip := rt.target.LoadImagePattern(untrackedPatternData(data))
+	return &trackedPattern{BackendImagePattern: ip, rt: rt, res: rt.add(ResourcePattern, 0)}
+}
+
+func (rt *ResourceTracker) LoadLinearGradient(data BackendGradient) BackendLinearGradient {
+	lg := rt.target.LoadLinearGradient(data)
+	return &trackedGradient{BackendLinearGradient: lg, rt: rt, res: rt.add(ResourceLinearGradient, gradientBytes(data))}
+}
+
+func (rt *ResourceTracker) LoadRadialGradient(data BackendGradient) BackendRadialGradient {
+	rg := rt.target.LoadRadialGradient(data)
+	return &trackedGradient{BackendLinearGradient: rg, rt: rt, res: rt.add(ResourceRadialGradient, gradientBytes(data))}
+}
+
+func (rt *ResourceTracker) Clear(pts [4]BackendVec) { rt.target.Clear(pts) }
+
+func (rt *ResourceTracker) Fill(style *BackendFillStyle, pts []BackendVec, tf BackendMat, canOverlap bool) {
+	rt.target.Fill(untrackedStyle(style), pts, tf, canOverlap)
+}
+
+func (rt *ResourceTracker) DrawImage(dimg BackendImage, sx, sy, sw, sh float64, pts [4]BackendVec, alpha float64) {
+	rt.target.DrawImage(untrackedImage(dimg), sx, sy, sw, sh, pts, alpha)
+}
+
+func (rt *ResourceTracker) FillImageMask(style *BackendFillStyle, mask *image.Alpha, pts [4]BackendVec) {
+	rt.target.FillImageMask(untrackedStyle(style), mask, pts)
+}
+
+func (rt *ResourceTracker) FillTrianglesVertexColor(pts []BackendVec, colors []color.RGBA) {
+	rt.target.FillTrianglesVertexColor(pts, colors)
+}
+
+func (rt *ResourceTracker) ClearClip()            { rt.target.ClearClip() }
+func (rt *ResourceTracker) Clip(pts []BackendVec) { rt.target.Clip(pts) }
+
+func (rt *ResourceTracker) GetImageData(x, y, w, h int) *image.RGBA {
+	return rt.target.GetImageData(x, y, w, h)
+}
+
+func (rt *ResourceTracker) GetImageDataInto(dst []byte, x, y, w, h, stride int) {
+	getImageDataInto(rt.target, dst, x, y, w, h, stride)
+}
+
+func (rt *ResourceTracker) GetImageDataAsync(x, y, w, h int) <-chan *image.RGBA {
+	return getImageDataAsync(rt.target, x, y, w, h)
+}
+
+func (rt *ResourceTracker) PutImageData(img *image.RGBA, x, y int) { rt.target.PutImageData(img, x, y) }
+
+func (rt *ResourceTracker) CanUseAsImage(b Backend) bool { return rt.target.CanUseAsImage(b) }
+func (rt *ResourceTracker) AsImage() BackendImage        { return rt.target.AsImage() }
+
+func (rt *ResourceTracker) Capabilities() BackendCapabilities { return rt.target.Capabilities() }
+
+func (rt *ResourceTracker) BeginFrame() { beginFrame(rt.target) }
+func (rt *ResourceTracker) EndFrame()   { endFrame(rt.target) }
@@ -0,0 +1,44 @@
+//go:build !canvas_notrace
+// +build !canvas_notrace
+
+package canvas
+
+import (
+	"context"
+	"runtime/trace"
+)
+
+// frameTrace is the trace task of the current frame
+type frameTrace struct {
+	ctx  context.Context
+	task *trace.Task
+}
+
+// next ends the task of the frame and starts the task of the next frame
+// if runtime/trace is enabled
+func (ft *frameTrace) next() {
+	if ft.task != nil {
+		ft.task.End()
+		ft.ctx, ft.task = nil, nil
+	}
+	if trace.IsEnabled() {
+		ft.ctx, ft.task = trace.NewTask(context.Background(), "canvas.Frame")
+	}
+}
+
+// TraceContext returns the context of the trace task of the current
+// frame, so that regions of the application are shown within the frame.
+// Without tracing it is the background context. It is left out with the
+// canvas_notrace build tag
+func (cv *Canvas) TraceContext() context.Context {
+	if cv.stats.trace.ctx == nil {
+		return context.Background()
+	}
+	return cv.stats.trace.ctx
+}
+
+// traceRegion starts a trace region within the current frame. It does
+// not allocate when tracing is disabled
+func (cv *Canvas) traceRegion(name string) *trace.Region {
+	return trace.StartRegion(cv.TraceContext(), name)
+}
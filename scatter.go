@@ -0,0 +1,121 @@
+package canvas
+
+import (
+	"math"
+)
+
+// Scatter describes how instances of a brush are scattered within a
+// shape, e.g. for grass, confetti or stippling. The placements only
+// depend on the parameters and the shape, so the same seed always gives
+// the same result. Instances are placed on a grid that is aligned to
+// the origin, so changing the shape keeps the instances that are still
+// inside of it in place
+type Scatter struct {
+	// Seed selects the random placements
+	Seed int64
+	// Density is the number of instances per square pixel, e.g.
+	// 0.01 for one instance in every 10x10 pixel area
+	Density float64
+	// Jitter moves every instance randomly within its grid cell. It
+	// ranges from 0 for a regular grid to 1 for the whole cell
+	Jitter float64
+	// Rotation is the maximum random rotation in radians in either
+	// direction
+	Rotation float64
+	// MinScale and MaxScale are the range of the random scale of
+	// the instances. If both are zero the scale is 1
+	MinScale, MaxScale float64
+}
+
+// ScatterPlacement is the position, rotation and scale of a scattered
+// instance. Vector exporters can use the placements to write instances
+// of a single shape instead of all the drawn triangles
+type ScatterPlacement struct {
+	X, Y     float64
+	Rotation float64
+	Scale    float64
+}
+
+// maxScatterCells limits the number of grid cells that are checked, so
+// that a tiny spacing can't lock up the program
+const maxScatterCells = 1 << 22
+
+// Placements returns the placements of the instances whose centers are
+// inside of the path, in the coordinates of the path
+func (s Scatter) Placements(path *Path2D) []ScatterPlacement {
+	if s.Density <= 0 || len(path.p) < 3 {
+		return nil
+	}
+	spacing := 1 / math.Sqrt(s.Density)
+
+	b := EmptyBounds
+	for _, pt := range path.p {
+		b = b.Union(Bounds{MinX: pt.pos[0], MinY: pt.pos[1], MaxX: pt.pos[0], MaxY: pt.pos[1]})
+	}
+	x0, y0 := int(math.Floor(b.MinX/spacing)), int(math.Floor(b.MinY/spacing))
+	x1, y1 := int(math.Ceil(b.MaxX/spacing)), int(math.Ceil(b.MaxY/spacing))
+	if float64(x1-x0)*float64(y1-y0) > maxScatterCells {
+		return nil
+	}
+
+	minScale, maxScale := s.MinScale, s.MaxScale
+	if minScale == 0 && maxScale == 0 {
+		minScale, maxScale = 1, 1
+	}
+
+	var placements []ScatterPlacement
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			// seeding with the cell keeps each placement independent
+			// of the shape and of the other cells
+			r := noiseRand{state: uint64(s.Seed)}
+			r.state ^= uint64(int64(x))*0x9e3779b97f4a7c15 ^ uint64(int64(y))*0xc2b2ae3d27d4eb4f
+			px := (float64(x) + 0.5 + (r.float()-0.5)*s.Jitter) * spacing
+			py := (float64(y) + 0.5 + (r.float()-0.5)*s.Jitter) * spacing
+			rot := (r.float()*2 - 1) * s.Rotation
+			scale := minScale + r.float()*(maxScale-minScale)
+			if !path.IsPointInPath(px, py, NonZero) {
+				continue
+			}
+			placements = append(placements, ScatterPlacement{X: px, Y: py, Rotation: rot, Scale: scale})
+		}
+	}
+	return placements
+}
+
+// ScatterImage draws the image centered on every placement of the
+// scatter within the path, with the size w/h before scaling. The
+// instances are drawn with the current transformation and clipping
+// region, and are not cut off at the edge of the path
+func (cv *Canvas) ScatterImage(path *Path2D, s Scatter, image interface{}, w, h float64) {
+	img := cv.getImage(image)
+	if img == nil {
+		return
+	}
+	// only the transformation changes, so restoring it is much
+	// cheaper than Save and Restore, which reapply the clipping
+	tf := cv.state.transform
+	for _, pl := range s.Placements(path) {
+		cv.state.transform = tf
+		cv.Translate(pl.X, pl.Y)
+		cv.Rotate(pl.Rotation)
+		cv.Scale(pl.Scale, pl.Scale)
+		cv.DrawImage(img, -w*0.5, -h*0.5, w, h)
+	}
+	cv.state.transform = tf
+}
+
+// ScatterPath fills the shape with the current fill style on every
+// placement of the scatter within the path. The shape is drawn with
+// its origin at the placement, so it should be centered on 0/0
+func (cv *Canvas) ScatterPath(path *Path2D, s Scatter, shape *Path2D) {
+	tf := cv.state.transform
+	for _, pl := range s.Placements(path) {
+		cv.state.transform = tf
+		cv.Translate(pl.X, pl.Y)
+		cv.Rotate(pl.Rotation)
+		cv.Scale(pl.Scale, pl.Scale)
+		cv.FillPath(shape)
+	}
+	cv.state.transform = tf
+}
@@ -0,0 +1,389 @@
+package scene
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/opentoys/canvas"
+)
+
+var (
+	lineCaps  = []string{"", "butt", "round", "square"}
+	lineJoins = []string{"", "miter", "round", "bevel"}
+	aligns    = []string{"", "left", "center", "right", "start", "end"}
+	baselines = []string{"", "alphabetic", "top", "hanging", "middle", "ideographic", "bottom"}
+)
+
+func oneOf(s string, values []string) bool {
+	for _, v := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultTimeout is the timeout of downloads if Renderer.Client is nil
+const DefaultTimeout = 30 * time.Second
+
+// DefaultMaxSize is the maximum size in bytes of a font or image if
+// Renderer.MaxSize is zero
+const DefaultMaxSize = 32 << 20
+
+// Renderer draws scenes. The zero value is ready to use, but only
+// downloads fonts and images
+type Renderer struct {
+	// Client fetches images and fonts given as http or https URLs. Nil
+	// uses a client with DefaultTimeout. A custom client should have a
+	// timeout as well
+	Client *http.Client
+	// BaseDir is the directory that images and fonts that are not
+	// URLs are read from, as paths relative to it. Paths that lead
+	// outside of it are rejected. Empty doesn't allow reading files
+	BaseDir string
+	// MaxSize is the maximum size in bytes of a font or image, zero is
+	// DefaultMaxSize
+	MaxSize int64
+}
+
+var defaultClient = &http.Client{Timeout: DefaultTimeout}
+
+// Render draws the scene with a zero Renderer, see Renderer.Render
+func (s *Scene) Render() (*image.RGBA, error) {
+	var r Renderer
+	return r.Render(s)
+}
+
+// Render draws the scene on a new software canvas of the size of the
+// scene and returns its image
+func (r *Renderer) Render(s *Scene) (*image.RGBA, error) {
+	b, err := canvas.NewBackendChecked(s.Width, s.Height)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Draw(canvas.New(b), s); err != nil {
+		return nil, err
+	}
+	return b.Image, nil
+}
+
+// Draw draws the scene on the canvas. The canvas is not resized to the
+// size of the scene, and its drawing state is restored afterwards. Items
+// without fill and stroke are filled with the fill style of their group,
+// which is black by default
+func (r *Renderer) Draw(cv *canvas.Canvas, s *Scene) error {
+	rd := render{r: r, cv: cv, alpha: 1, fonts: make(map[string]*canvas.Font), images: make(map[string]*canvas.Image)}
+	defer func() {
+		for _, img := range rd.images {
+			img.Delete()
+		}
+	}()
+	for name, src := range s.Fonts {
+		data, err := r.fetch(src)
+		if err != nil {
+			return err
+		}
+		font, err := cv.LoadFont(data)
+		if err != nil {
+			return fmt.Errorf("Loading font %s: %w", name, err)
+		}
+		rd.fonts[name] = font
+	}
+
+	cv.Save()
+	defer cv.Restore()
+	if s.Background != "" {
+		cv.Save()
+		cv.SetFillStyle(s.Background)
+		cv.FillRect(0, 0, float64(s.Width), float64(s.Height))
+		cv.Restore()
+	}
+	for i := range s.Items {
+		if err := rd.item(&s.Items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetch reads a file or downloads a URL
+func (r *Renderer) fetch(src string) ([]byte, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return r.readFile(src)
+	}
+	client := r.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Get(src)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Fetching %s: %s", src, resp.Status)
+	}
+	return r.read(resp.Body, src)
+}
+
+// readFile reads a file relative to the base directory
+func (r *Renderer) readFile(src string) ([]byte, error) {
+	if r.BaseDir == "" {
+		return nil, fmt.Errorf("Reading %s: reading files is not allowed without a BaseDir", src)
+	}
+	name := filepath.Clean(filepath.FromSlash(src))
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" ||
+		name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("Reading %s: the path is outside of the BaseDir", src)
+	}
+	f, err := os.Open(filepath.Join(r.BaseDir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return r.read(f, src)
+}
+
+// read reads all data up to the maximum size
+func (r *Renderer) read(rd io.Reader, src string) ([]byte, error) {
+	max := r.MaxSize
+	if max <= 0 {
+		max = DefaultMaxSize
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(rd, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, fmt.Errorf("Reading %s: larger than the maximum of %d bytes", src, max)
+	}
+	return data, nil
+}
+
+// render is the state of drawing one scene
+type render struct {
+	r      *Renderer
+	cv     *canvas.Canvas
+	alpha  float64
+	fonts  map[string]*canvas.Font
+	images map[string]*canvas.Image
+}
+
+func (rd *render) item(it *Item) error {
+	cv := rd.cv
+	cv.Save()
+	defer cv.Restore()
+	if it.Translate != nil {
+		cv.Translate(it.Translate[0], it.Translate[1])
+	}
+	if it.Rotate != 0 {
+		cv.Rotate(it.Rotate * math.Pi / 180)
+	}
+	if it.Scale != nil {
+		cv.Scale(it.Scale[0], it.Scale[1])
+	}
+	if it.Opacity != nil {
+		alpha := rd.alpha
+		rd.alpha *= *it.Opacity
+		defer func() { rd.alpha = alpha }()
+	}
+	cv.SetGlobalAlpha(rd.alpha)
+	if it.Shadow != nil {
+		cv.SetShadowColor(it.Shadow.Color)
+		cv.SetShadowOffset(it.Shadow.OffsetX, it.Shadow.OffsetY)
+		cv.SetShadowBlur(it.Shadow.Blur)
+	}
+	if it.LineWidth > 0 {
+		cv.SetLineWidth(it.LineWidth)
+	}
+	if it.LineDash != nil {
+		cv.SetLineDash(it.LineDash)
+	}
+	switch it.LineCap {
+	case "butt":
+		cv.SetLineCap(canvas.Butt)
+	case "round":
+		cv.SetLineCap(canvas.Round)
+	case "square":
+		cv.SetLineCap(canvas.Square)
+	}
+	switch it.LineJoin {
+	case "miter":
+		cv.SetLineJoin(canvas.Miter)
+	case "round":
+		cv.SetLineJoin(canvas.Round)
+	case "bevel":
+		cv.SetLineJoin(canvas.Bevel)
+	}
+	if it.Fill != nil {
+		cv.SetFillStyle(rd.paint(it.Fill))
+	}
+	if it.Stroke != nil {
+		cv.SetStrokeStyle(rd.paint(it.Stroke))
+	}
+	fill := it.Fill != nil || it.Stroke == nil
+
+	cv.BeginPath()
+	switch it.Type {
+	case "rect":
+		roundRect(cv, it.X, it.Y, it.Width, it.Height, it.Radius)
+	case "circle":
+		cv.Arc(it.X, it.Y, it.Radius, 0, math.Pi*2, false)
+		cv.ClosePath()
+	case "ellipse":
+		cv.Ellipse(it.X, it.Y, it.RX, it.RY, 0, 0, math.Pi*2, false)
+		cv.ClosePath()
+	case "line", "polyline", "polygon":
+		cv.MoveTo(it.Points[0][0], it.Points[0][1])
+		for _, p := range it.Points[1:] {
+			cv.LineTo(p[0], p[1])
+		}
+		if it.Type == "polygon" {
+			cv.ClosePath()
+		} else {
+			// lines are only stroked
+			fill = false
+			if it.Stroke == nil {
+				cv.Stroke()
+			}
+		}
+	case "text":
+		return rd.text(it, fill)
+	case "image":
+		return rd.image(it)
+	case "group":
+		for i := range it.Items {
+			if err := rd.item(&it.Items[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if fill {
+		cv.Fill()
+	}
+	if it.Stroke != nil {
+		cv.Stroke()
+	}
+	return nil
+}
+
+// roundRect adds a rectangle with corners of the given radius
+func roundRect(cv *canvas.Canvas, x, y, w, h, r float64) {
+	r = math.Min(r, math.Min(math.Abs(w), math.Abs(h))/2)
+	if r <= 0 {
+		cv.Rect(x, y, w, h)
+		return
+	}
+	cv.MoveTo(x+r, y)
+	cv.ArcTo(x+w, y, x+w, y+h, r)
+	cv.ArcTo(x+w, y+h, x, y+h, r)
+	cv.ArcTo(x, y+h, x, y, r)
+	cv.ArcTo(x, y, x+w, y, r)
+	cv.ClosePath()
+}
+
+// paint returns the fill or stroke style of a paint
+func (rd *render) paint(p *Paint) interface{} {
+	switch {
+	case p.Linear != nil:
+		g := rd.cv.CreateLinearGradient(p.Linear[0], p.Linear[1], p.Linear[2], p.Linear[3])
+		for _, s := range p.Stops {
+			g.AddColorStop(s.Offset, s.Color)
+		}
+		return g
+	case p.Radial != nil:
+		g := rd.cv.CreateRadialGradient(p.Radial[0], p.Radial[1], p.Radial[2], p.Radial[3], p.Radial[4], p.Radial[5])
+		for _, s := range p.Stops {
+			g.AddColorStop(s.Offset, s.Color)
+		}
+		return g
+	}
+	return p.Color
+}
+
+func (rd *render) text(it *Item, fill bool) error {
+	cv := rd.cv
+	var font interface{}
+	if it.Font != "" {
+		f, ok := rd.fonts[it.Font]
+		if !ok {
+			return fmt.Errorf("Unknown font %q", it.Font)
+		}
+		font = f
+	}
+	size := it.FontSize
+	if size <= 0 {
+		size = 16
+	}
+	cv.SetFont(font, size)
+	switch it.Align {
+	case "left":
+		cv.SetTextAlign(canvas.Left)
+	case "center":
+		cv.SetTextAlign(canvas.Center)
+	case "right":
+		cv.SetTextAlign(canvas.Right)
+	case "end":
+		cv.SetTextAlign(canvas.End)
+	default:
+		cv.SetTextAlign(canvas.Start)
+	}
+	switch it.Baseline {
+	case "top":
+		cv.SetTextBaseline(canvas.Top)
+	case "hanging":
+		cv.SetTextBaseline(canvas.Hanging)
+	case "middle":
+		cv.SetTextBaseline(canvas.Middle)
+	case "ideographic":
+		cv.SetTextBaseline(canvas.Ideographic)
+	case "bottom":
+		cv.SetTextBaseline(canvas.Bottom)
+	default:
+		cv.SetTextBaseline(canvas.Alphabetic)
+	}
+	lineHeight := it.LineHeight
+	if lineHeight <= 0 {
+		lineHeight = size * 1.2
+	}
+	for i, line := range strings.Split(it.Text, "\n") {
+		y := it.Y + float64(i)*lineHeight
+		if fill {
+			cv.FillText(line, it.X, y)
+		}
+		if it.Stroke != nil {
+			cv.StrokeText(line, it.X, y)
+		}
+	}
+	return nil
+}
+
+func (rd *render) image(it *Item) error {
+	img, ok := rd.images[it.Src]
+	if !ok {
+		data, err := rd.r.fetch(it.Src)
+		if err != nil {
+			return err
+		}
+		img, err = rd.cv.LoadImageBytes(data)
+		if err != nil {
+			return fmt.Errorf("Loading image %s: %w", it.Src, err)
+		}
+		rd.images[it.Src] = img
+	}
+	if it.Width > 0 && it.Height > 0 {
+		rd.cv.DrawImage(img, it.X, it.Y, it.Width, it.Height)
+	} else {
+		rd.cv.DrawImage(img, it.X, it.Y)
+	}
+	return nil
+}
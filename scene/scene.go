@@ -0,0 +1,221 @@
+// Package scene renders declarative scene descriptions in JSON, for
+// services that generate images from templates such as social cards,
+// receipts and certificates.
+//
+// A scene has a size, a background and a list of items. Items are
+// shapes, text, images or groups of further items, each with its own
+// fill and stroke, line settings, shadow, opacity and transformation.
+// Colors are given in the formats of Canvas.SetFillStyle, and fills and
+// strokes can also be linear or radial gradients:
+//
+//	{
+//		"width": 400, "height": 200, "background": "#fff",
+//		"fonts": {"body": "fonts/Roboto.ttf"},
+//		"items": [
+//			{"type": "rect", "x": 10, "y": 10, "width": 380, "height": 180, "radius": 12,
+//			 "fill": {"linear": [0, 0, 400, 0], "stops": [{"offset": 0, "color": "#08f"}, {"offset": 1, "color": "#0cf"}]}},
+//			{"type": "text", "text": "Hello", "x": 200, "y": 100, "font": "body", "fontSize": 32,
+//			 "align": "center", "fill": "#fff"},
+//			{"type": "image", "src": "https://example.com/logo.png", "x": 20, "y": 20, "width": 48, "height": 48}
+//		]
+//	}
+//
+// ParseTemplate fills in a text/template before parsing, which is the
+// usual way to generate many images from one description. Since scenes
+// can come from untrusted input, fonts and images are only read from
+// files when Renderer.BaseDir is set, and downloads are limited in time
+// and size
+package scene
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	"github.com/opentoys/canvas"
+)
+
+// Scene is the description of an image
+type Scene struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Background is the color the canvas is filled with first. Empty
+	// leaves the canvas as it is
+	Background string `json:"background"`
+	// Fonts maps the names used by text items to font files or URLs,
+	// see Renderer for how they are read
+	Fonts map[string]string `json:"fonts"`
+	Items []Item            `json:"items"`
+}
+
+// Item is a shape, text, image or group. Only the fields that apply to
+// the type of the item are used
+type Item struct {
+	// Type is one of rect, circle, ellipse, line, polyline, polygon,
+	// text, image and group
+	Type string `json:"type"`
+
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+	// Radius is the radius of circles and of the corners of rects
+	Radius float64 `json:"radius"`
+	// RX and RY are the radii of ellipses
+	RX float64 `json:"rx"`
+	RY float64 `json:"ry"`
+	// Points are the points of lines, polylines and polygons
+	Points [][2]float64 `json:"points"`
+
+	Fill      *Paint    `json:"fill"`
+	Stroke    *Paint    `json:"stroke"`
+	LineWidth float64   `json:"lineWidth"`
+	LineDash  []float64 `json:"lineDash"`
+	// LineCap is butt, round or square, LineJoin is miter, round or bevel
+	LineCap  string  `json:"lineCap"`
+	LineJoin string  `json:"lineJoin"`
+	Shadow   *Shadow `json:"shadow"`
+	// Opacity multiplies the alpha of the item, including the items of
+	// groups. Nil is opaque
+	Opacity *float64 `json:"opacity"`
+
+	// The transformation of the item and its children, applied as
+	// translation, rotation in degrees around the origin and scaling
+	Translate *[2]float64 `json:"translate"`
+	Rotate    float64     `json:"rotate"`
+	Scale     *[2]float64 `json:"scale"`
+
+	// Text is drawn in lines separated by newlines, LineHeight apart,
+	// which defaults to 1.2 times the font size
+	Text       string  `json:"text"`
+	Font       string  `json:"font"`
+	FontSize   float64 `json:"fontSize"`
+	LineHeight float64 `json:"lineHeight"`
+	// Align is left, center, right, start or end. Baseline is
+	// alphabetic, top, hanging, middle, ideographic or bottom
+	Align    string `json:"align"`
+	Baseline string `json:"baseline"`
+
+	// Src is the file or URL of an image, see Renderer for how it is
+	// read. Without a width and height the image is drawn at its size
+	Src string `json:"src"`
+
+	// Items are the children of a group
+	Items []Item `json:"items"`
+}
+
+// Paint is a color or a gradient. In JSON a plain string is a color
+type Paint struct {
+	Color string `json:"color"`
+	// Linear are the start and end points x0, y0, x1, y1 of a linear
+	// gradient
+	Linear *[4]float64 `json:"linear"`
+	// Radial are the circles x0, y0, r0, x1, y1, r1 of a radial gradient
+	Radial *[6]float64 `json:"radial"`
+	Stops  []Stop      `json:"stops"`
+}
+
+// Stop is a color stop of a gradient
+type Stop struct {
+	Offset float64 `json:"offset"`
+	Color  string  `json:"color"`
+}
+
+// Shadow is the shadow of an item
+type Shadow struct {
+	Color   string  `json:"color"`
+	OffsetX float64 `json:"offsetX"`
+	OffsetY float64 `json:"offsetY"`
+	Blur    float64 `json:"blur"`
+}
+
+// UnmarshalJSON accepts a color string as well as an object
+func (p *Paint) UnmarshalJSON(data []byte) error {
+	var color string
+	if err := json.Unmarshal(data, &color); err == nil {
+		*p = Paint{Color: color}
+		return nil
+	}
+	type paint Paint
+	return json.Unmarshal(data, (*paint)(p))
+}
+
+// Parse parses and checks a JSON scene description
+func Parse(data []byte) (*Scene, error) {
+	var s Scene
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if s.Width <= 0 || s.Height <= 0 {
+		return nil, fmt.Errorf("Invalid scene size %dx%d", s.Width, s.Height)
+	}
+	if max := canvas.SizeLimits.MaxDimension; max > 0 && (s.Width > max || s.Height > max) {
+		return nil, fmt.Errorf("Scene size %dx%d exceeds the maximum of %d", s.Width, s.Height, max)
+	}
+	if err := checkItems(s.Items, "items"); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ParseTemplate executes the text/template with the data and parses the
+// result. Values inserted into JSON strings should be passed through the
+// json function of the template, which quotes them
+func ParseTemplate(tmpl string, data interface{}) (*Scene, error) {
+	t, err := template.New("scene").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return Parse(buf.Bytes())
+}
+
+func checkItems(items []Item, path string) error {
+	for i := range items {
+		it := &items[i]
+		where := fmt.Sprintf("%s[%d]", path, i)
+		switch it.Type {
+		case "rect", "circle", "ellipse", "text", "image":
+		case "line", "polyline", "polygon":
+			if len(it.Points) < 2 {
+				return fmt.Errorf("Scene item %s: %s needs at least 2 points", where, it.Type)
+			}
+		case "group":
+			if err := checkItems(it.Items, where+".items"); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("Scene item %s: unknown type %q", where, it.Type)
+		}
+		if it.Type == "image" && it.Src == "" {
+			return fmt.Errorf("Scene item %s: image without src", where)
+		}
+		for _, p := range []*Paint{it.Fill, it.Stroke} {
+			if p != nil && p.Linear != nil && p.Radial != nil {
+				return fmt.Errorf("Scene item %s: paint can't be linear and radial", where)
+			}
+		}
+		if !oneOf(it.LineCap, lineCaps) {
+			return fmt.Errorf("Scene item %s: unknown line cap %q", where, it.LineCap)
+		}
+		if !oneOf(it.LineJoin, lineJoins) {
+			return fmt.Errorf("Scene item %s: unknown line join %q", where, it.LineJoin)
+		}
+		if !oneOf(it.Align, aligns) {
+			return fmt.Errorf("Scene item %s: unknown text align %q", where, it.Align)
+		}
+		if !oneOf(it.Baseline, baselines) {
+			return fmt.Errorf("Scene item %s: unknown text baseline %q", where, it.Baseline)
+		}
+	}
+	return nil
+}
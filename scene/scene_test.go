@@ -0,0 +1,218 @@
+package scene_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opentoys/canvas"
+	"github.com/opentoys/canvas/scene"
+	"github.com/opentoys/canvas/testutil"
+)
+
+func TestRender(t *testing.T) {
+	s, err := scene.Parse([]byte(`{
+		"width": 100, "height": 80, "background": "#fff",
+		"items": [
+			{"type": "rect", "x": 10, "y": 10, "width": 50, "height": 30, "radius": 5,
+			 "fill": {"linear": [10, 0, 60, 0], "stops": [{"offset": 0, "color": "#f00"}, {"offset": 1, "color": "#00f"}]}},
+			{"type": "group", "translate": [50, 40], "rotate": 90, "opacity": 0.5, "fill": "#0f0", "items": [
+				{"type": "circle", "radius": 10, "stroke": "#000", "lineWidth": 2},
+				{"type": "polygon", "points": [[0, 0], [20, 0], [0, 20]]}
+			]},
+			{"type": "line", "points": [[0, 70], [100, 75]], "stroke": "#00f", "lineWidth": 3, "lineCap": "round"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := testutil.Render(100, 80, func(cv *canvas.Canvas) {
+		cv.SetFillStyle("#fff")
+		cv.FillRect(0, 0, 100, 80)
+		cv.Save()
+		grad := cv.CreateLinearGradient(10, 0, 60, 0)
+		grad.AddColorStop(0, "#f00")
+		grad.AddColorStop(1, "#00f")
+		cv.SetFillStyle(grad)
+		cv.BeginPath()
+		cv.MoveTo(15, 10)
+		cv.ArcTo(60, 10, 60, 40, 5)
+		cv.ArcTo(60, 40, 10, 40, 5)
+		cv.ArcTo(10, 40, 10, 10, 5)
+		cv.ArcTo(10, 10, 60, 10, 5)
+		cv.ClosePath()
+		cv.Fill()
+		cv.Restore()
+
+		cv.Save()
+		cv.Translate(50, 40)
+		cv.Rotate(math.Pi / 2)
+		cv.SetGlobalAlpha(0.5)
+		cv.SetFillStyle("#0f0")
+		cv.SetStrokeStyle("#000")
+		cv.SetLineWidth(2)
+		cv.BeginPath()
+		cv.Arc(0, 0, 10, 0, math.Pi*2, false)
+		cv.ClosePath()
+		cv.Stroke()
+		cv.SetLineWidth(1)
+		cv.BeginPath()
+		cv.MoveTo(0, 0)
+		cv.LineTo(20, 0)
+		cv.LineTo(0, 20)
+		cv.ClosePath()
+		cv.Fill()
+		cv.Restore()
+
+		cv.SetStrokeStyle("#00f")
+		cv.SetLineWidth(3)
+		cv.SetLineCap(canvas.Round)
+		cv.BeginPath()
+		cv.MoveTo(0, 70)
+		cv.LineTo(100, 75)
+		cv.Stroke()
+	})
+	if !bytes.Equal(got.Pix, want.Pix) {
+		t.Fatal("Expected the scene to draw the same as the canvas calls")
+	}
+}
+
+func TestRenderImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	img.Pix[1], img.Pix[2] = 0, 0
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/logo.png" {
+			http.NotFound(w, r)
+			return
+		}
+		requests++
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	s, err := scene.ParseTemplate(`{"width": 20, "height": 20, "items": [
+		{"type": "image", "src": {{json .Logo}}, "x": 2, "y": 2},
+		{"type": "image", "src": {{json .Logo}}, "x": 10, "y": 10, "width": 8, "height": 8}
+	]}`, map[string]string{"Logo": srv.URL + "/logo.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := scene.Renderer{Client: srv.Client()}
+	got, err := r.Render(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Fatalf("Expected the image to be fetched once, got %d requests", requests)
+	}
+	if c := got.RGBAAt(2, 2); c != (color.RGBA{255, 0, 0, 255}) {
+		t.Fatalf("Expected the red corner of the image, got %v", c)
+	}
+	if c := got.RGBAAt(15, 15); c != (color.RGBA{255, 255, 255, 255}) {
+		t.Fatalf("Expected the scaled image, got %v", c)
+	}
+
+	s.Items[0].Src = srv.URL + "/missing.png"
+	if _, err := r.Render(s); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("Expected an error for a missing image, got %v", err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{
+		`{"width": 10}`,
+		`{"width": 10, "height": 10, "items": [{"type": "star"}]}`,
+		`{"width": 10, "height": 10, "items": [{"type": "group", "items": [{"type": "line", "points": [[0, 0]]}]}]}`,
+		`{"width": 10, "height": 10, "items": [{"type": "rect", "lineCap": "pointy"}]}`,
+		`{"width": 10, "height": 10, "items": [{"type": "image"}]}`,
+		`{"width": 10, "height": 10, "items": [{"type": "rect", "fill": {"linear": [0, 0, 1, 1], "radial": [0, 0, 1, 1, 1, 2]}}]}`,
+		`{"width": 3000000, "height": 3000000}`,
+	} {
+		if _, err := scene.Parse([]byte(src)); err == nil {
+			t.Errorf("Expected an error for %s", src)
+		}
+	}
+}
+
+func TestRenderLimits(t *testing.T) {
+	// the size is checked before the canvas is allocated
+	if _, err := (&scene.Scene{Width: 16000, Height: 16000}).Render(); err == nil {
+		t.Fatal("Expected an error for a scene that is too large")
+	}
+
+	dir, err := ioutil.TempDir("", "scene")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	if err := ioutil.WriteFile(filepath.Join(dir, "logo.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	render := func(r *scene.Renderer, src string) error {
+		s := &scene.Scene{Width: 10, Height: 10, Items: []scene.Item{{Type: "image", Src: src}}}
+		_, err := r.Render(s)
+		return err
+	}
+	if err := render(&scene.Renderer{}, filepath.Join(dir, "logo.png")); err == nil {
+		t.Error("Expected an error for reading a file without a BaseDir")
+	}
+	r := &scene.Renderer{BaseDir: dir}
+	if err := render(r, "logo.png"); err != nil {
+		t.Errorf("Expected the file to be read from the BaseDir, got %v", err)
+	}
+	for _, src := range []string{"../logo.png", "a/../../logo.png", filepath.Join(dir, "logo.png")} {
+		if err := render(r, src); err == nil {
+			t.Errorf("Expected an error for %s outside of the BaseDir", src)
+		}
+	}
+	// the header of the image is checked before it is decoded
+	huge := append([]byte(nil), buf.Bytes()...)
+	binary.BigEndian.PutUint32(huge[16:], 100000)
+	binary.BigEndian.PutUint32(huge[20:], 100000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+	if err := ioutil.WriteFile(filepath.Join(dir, "huge.png"), huge, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := render(r, "huge.png"); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("Expected a size error for a huge image, got %v", err)
+	}
+
+	r.MaxSize = 10
+	if err := render(r, "logo.png"); err == nil || !strings.Contains(err.Error(), "maximum") {
+		t.Errorf("Expected an error for a file larger than MaxSize, got %v", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+	r = &scene.Renderer{Client: srv.Client(), MaxSize: 10}
+	if err := render(r, srv.URL+"/logo.png"); err == nil || !strings.Contains(err.Error(), "maximum") {
+		t.Errorf("Expected an error for a download larger than MaxSize, got %v", err)
+	}
+}
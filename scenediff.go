@@ -0,0 +1,187 @@
+package canvas
+
+import (
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"image"
+	"math"
+)
+
+// SceneDiff is the difference between the display lists of two frames
+type SceneDiff struct {
+	// Added are the indices of the commands in the new list that
+	// are not in the old list
+	Added []int
+	// Removed are the indices of the commands in the old list that
+	// are not in the new list
+	Removed []int
+	// Damage is the set of pixel regions that may differ between the
+	// two frames. Overlapping and touching regions are merged
+	Damage []image.Rectangle
+}
+
+// DiffDisplayLists compares the display lists of two consecutive frames.
+// Drawing commands are matched in order by their content and the clipping
+// region they are drawn with. The regions that unmatched commands draw to
+// are damaged, everything else is drawn the same way in both frames.
+//
+// Images, gradients and image patterns are compared by identity, so
+// changing their content with Replace is not detected
+func DiffDisplayLists(prev, next *DisplayList) SceneDiff {
+	prevHashes := prev.commandHashes()
+	nextHashes := next.commandHashes()
+
+	// match commands greedily in order, which keeps the
+	// relative order of the matched commands the same
+	positions := make(map[uint64][]int)
+	for i, h := range prevHashes {
+		if prev.Commands[i].drawing() {
+			positions[h] = append(positions[h], i)
+		}
+	}
+	matched := make([]bool, len(prevHashes))
+	var diff SceneDiff
+	var damage []Bounds
+	last := -1
+	for i, h := range nextHashes {
+		cmd := &next.Commands[i]
+		if !cmd.drawing() {
+			continue
+		}
+		list := positions[h]
+		for len(list) > 0 && list[0] <= last {
+			list = list[1:]
+		}
+		positions[h] = list
+		if len(list) > 0 {
+			last = list[0]
+			matched[last] = true
+			positions[h] = list[1:]
+			continue
+		}
+		diff.Added = append(diff.Added, i)
+		damage = append(damage, cmd.Bounds)
+	}
+	for i := range prev.Commands {
+		if prev.Commands[i].drawing() && !matched[i] {
+			diff.Removed = append(diff.Removed, i)
+			damage = append(damage, prev.Commands[i].Bounds)
+		}
+	}
+
+	diff.Damage = mergeDamage(damage)
+	return diff
+}
+
+// commandHashes returns a hash for every command that includes the
+// clipping commands it is drawn with
+func (dl *DisplayList) commandHashes() []uint64 {
+	hashes := make([]uint64, len(dl.Commands))
+	var clip uint64
+	var clipStack []uint64
+	h := fnv.New64a()
+	for i := range dl.Commands {
+		cmd := &dl.Commands[i]
+		h.Reset()
+		cmd.hash(h)
+		switch cmd.Kind {
+		case DisplayClearClip:
+			clipStack = clipStack[:0]
+			clip = 0
+		case DisplayClip:
+			clipStack = append(clipStack, h.Sum64())
+			h.Reset()
+			for _, c := range clipStack {
+				writeHashUint(h, c)
+			}
+			clip = h.Sum64()
+		default:
+			writeHashUint(h, clip)
+		}
+		hashes[i] = h.Sum64()
+	}
+	return hashes
+}
+
+func (c *DisplayCommand) hash(h hash.Hash64) {
+	writeHashUint(h, uint64(c.Kind))
+	for _, pt := range c.Pts {
+		writeHashUint(h, math.Float64bits(pt[0]))
+		writeHashUint(h, math.Float64bits(pt[1]))
+	}
+	if c.CanOverlap {
+		writeHashUint(h, 1)
+	}
+	for _, col := range c.Colors {
+		h.Write([]byte{col.R, col.G, col.B, col.A})
+	}
+
+	s := &c.Style
+	h.Write([]byte{s.Color.R, s.Color.G, s.Color.B, s.Color.A, byte(s.Dither)})
+	for _, v := range [...]float64{s.Blur, s.Gradient.X0, s.Gradient.Y0, s.Gradient.X1, s.Gradient.Y1, s.Gradient.RadFrom, s.Gradient.RadTo} {
+		writeHashUint(h, math.Float64bits(v))
+	}
+	if s.LinearGradient != nil || s.RadialGradient != nil || s.ImagePattern != nil {
+		fmt.Fprintf(h, "%p%p%p", s.LinearGradient, s.RadialGradient, s.ImagePattern)
+	}
+
+	if c.Image != nil {
+		fmt.Fprintf(h, "%p", c.Image)
+	}
+	for _, v := range [...]float64{c.SX, c.SY, c.SW, c.SH, c.Alpha} {
+		writeHashUint(h, math.Float64bits(v))
+	}
+	if c.Matrix != nil {
+		for _, v := range c.Matrix {
+			writeHashUint(h, math.Float64bits(v))
+		}
+	}
+	if c.Mask != nil {
+		h.Write(c.Mask.Pix)
+	}
+	if c.Data != nil {
+		writeHashUint(h, math.Float64bits(c.Bounds.MinX))
+		writeHashUint(h, math.Float64bits(c.Bounds.MinY))
+		h.Write(c.Data.Pix)
+	}
+}
+
+func writeHashUint(h hash.Hash64, v uint64) {
+	var buf [8]byte
+	for i := range buf {
+		buf[i] = byte(v >> (i * 8))
+	}
+	h.Write(buf[:])
+}
+
+// mergeDamage converts the bounds to pixel rectangles and merges all
+// rectangles that overlap or touch
+func mergeDamage(damage []Bounds) []image.Rectangle {
+	var rects []image.Rectangle
+	for _, b := range damage {
+		if b.Empty() {
+			continue
+		}
+		rects = append(rects, image.Rect(
+			int(math.Floor(b.MinX)), int(math.Floor(b.MinY)),
+			int(math.Ceil(b.MaxX)), int(math.Ceil(b.MaxY))))
+	}
+
+	for merged := true; merged; {
+		merged = false
+		for i := 0; i < len(rects); i++ {
+			for j := i + 1; j < len(rects); j++ {
+				a, b := rects[i], rects[j]
+				if a.Min.X > b.Max.X || b.Min.X > a.Max.X || a.Min.Y > b.Max.Y || b.Min.Y > a.Max.Y {
+					continue
+				}
+				rects[i] = a.Union(b)
+				rects = append(rects[:j], rects[j+1:]...)
+				merged = true
+				j--
+			}
+		}
+	}
+	return rects
+}
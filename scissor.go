@@ -0,0 +1,61 @@
+package canvas
+
+import "image"
+
+// ScissorBackend is implemented by backends that can restrict drawing
+// to a rectangle more cheaply than with a clipping region, like the
+// scissor test of GPUs. The backend may clear the clipping region, the
+// canvas sets it again afterwards. For other backends the rectangle is
+// added to the clipping region
+type ScissorBackend interface {
+	SetScissor(rect image.Rectangle)
+}
+
+// SetRenderBounds restricts all drawing to the rectangle in pixels, in
+// addition to the clipping region, so that a canvas shown in a scrolled
+// viewport only draws the visible part. Unlike clipping regions, it is
+// not affected by the transformation or by Save and Restore. An empty
+// rectangle removes the restriction
+func (cv *Canvas) SetRenderBounds(rect image.Rectangle) {
+	cv.Flush()
+	cv.renderBounds = rect.Canon()
+	if sb, ok := cv.b.(ScissorBackend); ok {
+		sb.SetScissor(cv.renderBounds)
+	}
+	cv.reapplyClips()
+}
+
+// RenderBounds returns the rectangle set with SetRenderBounds
+func (cv *Canvas) RenderBounds() image.Rectangle {
+	return cv.renderBounds
+}
+
+// clearClip clears the clipping region of the backend, keeping the
+// render bounds on backends without a scissor
+func (cv *Canvas) clearClip() {
+	cv.b.ClearClip()
+	if cv.renderBounds.Empty() {
+		return
+	}
+	if _, ok := cv.b.(ScissorBackend); ok {
+		return
+	}
+	r := cv.renderBounds
+	x0, y0, x1, y1 := float64(r.Min.X), float64(r.Min.Y), float64(r.Max.X), float64(r.Max.Y)
+	cv.b.Clip([]BackendVec{{x0, y0}, {x0, y1}, {x1, y1}, {x1, y0}})
+}
+
+// SetScissor restricts drawing to the rectangle, an empty one removes
+// the restriction. It clears the clipping region
+func (b *SoftwareBackend) SetScissor(rect image.Rectangle) {
+	b.scissor = rect
+	b.ClearClip()
+}
+
+// scissorRect returns the part of the image that can be drawn to
+func (b *SoftwareBackend) scissorRect() image.Rectangle {
+	if b.scissor.Empty() {
+		return b.clip.Rect
+	}
+	return b.clip.Rect.Intersect(b.scissor)
+}
@@ -0,0 +1,89 @@
+package canvas
+
+import (
+	"sync"
+)
+
+// Scratch buffers for triangles and MSAA pixels that are shared by all
+// canvases and backends. Buffers keep the capacity they grew to when they
+// are returned, so after a few frames they fit the scenes being drawn and
+// drawing doesn't allocate anymore
+
+type vecScratch struct{ buf []BackendVec }
+type msaaScratch struct{ buf []msaaPixel }
+type pathScratch struct{ buf []pathPoint }
+
+var vecScratchPool = sync.Pool{New: func() interface{} {
+	return &vecScratch{buf: make([]BackendVec, 0, 500)}
+}}
+
+var msaaScratchPool = sync.Pool{New: func() interface{} {
+	return &msaaScratch{buf: make([]msaaPixel, 0, 500)}
+}}
+
+var pathScratchPool = sync.Pool{New: func() interface{} {
+	return &pathScratch{buf: make([]pathPoint, 0, 64)}
+}}
+
+// getVecScratch returns an empty scratch buffer with a capacity of at
+// least n. It must be returned with release
+func getVecScratch(n int) *vecScratch {
+	s := vecScratchPool.Get().(*vecScratch)
+	if cap(s.buf) < n {
+		s.buf = make([]BackendVec, 0, n)
+	}
+	s.buf = s.buf[:0]
+	return s
+}
+
+// release returns the scratch buffer to the pool. buf is the buffer
+// that was used, which may have grown beyond the original capacity
+func (s *vecScratch) release(buf []BackendVec) {
+	if cap(buf) > cap(s.buf) {
+		s.buf = buf
+	}
+	vecScratchPool.Put(s)
+}
+
+func getMSAAScratch(n int) *msaaScratch {
+	s := msaaScratchPool.Get().(*msaaScratch)
+	if cap(s.buf) < n {
+		s.buf = make([]msaaPixel, 0, n)
+	}
+	s.buf = s.buf[:0]
+	return s
+}
+
+func (s *msaaScratch) release(buf []msaaPixel) {
+	if cap(buf) > cap(s.buf) {
+		s.buf = buf
+	}
+	msaaScratchPool.Put(s)
+}
+
+func getPathScratch() *pathScratch {
+	s := pathScratchPool.Get().(*pathScratch)
+	s.buf = s.buf[:0]
+	return s
+}
+
+func (s *pathScratch) release(buf []pathPoint) {
+	if cap(buf) > cap(s.buf) {
+		s.buf = buf
+	}
+	pathScratchPool.Put(s)
+}
+
+// Reserve makes sure that the scratch buffers for filling shapes with up
+// to verts vertices, and for msaaSamples edge samples when anti-aliasing
+// with MSAA, are allocated ahead of time. Both values are also used as
+// the minimum size of buffers allocated later, for example after the
+// garbage collector released unused buffers. This avoids allocations in
+// the first frames of an animation
+func (b *SoftwareBackend) Reserve(verts, msaaSamples int) {
+	b.reserveVerts, b.reserveMSAA = verts, msaaSamples
+	vs := getVecScratch(verts)
+	vs.release(vs.buf)
+	ms := getMSAAScratch(msaaSamples)
+	ms.release(ms.buf)
+}
@@ -0,0 +1,153 @@
+package canvas
+
+import (
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"math"
+)
+
+// ScreenshotFormat selects the image format of Screenshot
+type ScreenshotFormat uint8
+
+// Screenshot formats
+const (
+	ScreenshotPNG ScreenshotFormat = iota
+	ScreenshotJPEG
+)
+
+// ScreenshotOptions control what Screenshot draws on top of the copied
+// region and how it is encoded
+type ScreenshotOptions struct {
+	Format ScreenshotFormat
+	// Quality is the JPEG quality between 1 and 100. Zero uses the
+	// default quality of image/jpeg
+	Quality int
+
+	// Cursor is drawn with its top left corner at CursorX/CursorY in
+	// canvas coordinates, minus the hotspot
+	Cursor           image.Image
+	CursorX, CursorY float64
+	HotspotX         float64
+	HotspotY         float64
+
+	// Overlay is called after the cursor is drawn, to draw annotations
+	// on a canvas that holds the copy. It uses the coordinates of the
+	// original canvas
+	Overlay func(cv *Canvas)
+}
+
+// Screenshot copies the region of the canvas, draws the cursor and the
+// overlay on top of the copy and writes it to w in the selected format.
+// The canvas itself is not changed, so this can be called on a canvas
+// that is used as the compositor of a screen share. JPEG is not
+// supported with the canvas_noimageformats build tag
+func (cv *Canvas) Screenshot(w io.Writer, rect image.Rectangle, opts ScreenshotOptions) error {
+	cw, ch := cv.Size()
+	rect = rect.Canon().Intersect(image.Rect(0, 0, cw, ch))
+	if rect.Empty() {
+		return errors.New("Screenshot region is outside of the canvas")
+	}
+	data := cv.GetImageData(rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
+
+	img := ImageDataNRGBA(data)
+	if opts.Cursor != nil || opts.Overlay != nil {
+		b := NewBackend(rect.Dx(), rect.Dy())
+		copy(b.Image.Pix, img.Pix)
+		layer := New(b)
+		layer.Translate(-float64(rect.Min.X), -float64(rect.Min.Y))
+		if opts.Cursor != nil {
+			cb := opts.Cursor.Bounds()
+			layer.DrawImage(opts.Cursor, opts.CursorX-opts.HotspotX, opts.CursorY-opts.HotspotY, float64(cb.Dx()), float64(cb.Dy()))
+		}
+		if opts.Overlay != nil {
+			opts.Overlay(layer)
+		}
+		layer.Flush()
+		img = ImageDataNRGBA(b.Image)
+	}
+
+	return encodeScreenshot(w, img, opts.Format, opts.Quality)
+}
+
+func encodeScreenshot(w io.Writer, img image.Image, format ScreenshotFormat, quality int) error {
+	switch format {
+	case ScreenshotPNG:
+		return png.Encode(w, img)
+	case ScreenshotJPEG:
+		return encodeJPEG(w, img, quality)
+	}
+	return errors.New("Unknown screenshot format")
+}
+
+// Export writes the region of the canvas scaled by the given factor to w
+// in the selected format, for example to export a selection at twice the
+// resolution. See ExportImage for how the region is scaled
+func (cv *Canvas) Export(w io.Writer, rect image.Rectangle, scale float64, format ScreenshotFormat) error {
+	img, err := cv.ExportImage(rect, scale)
+	if err != nil {
+		return err
+	}
+	return encodeScreenshot(w, img, format, 0)
+}
+
+// ExportImage returns the region of the canvas scaled by the given
+// factor. If the canvas draws into a RecordingBackend for a software
+// backend, possibly through other wrappers, the display list is rendered
+// again at the output scale, which keeps edges and text sharp. Otherwise
+// the pixels of the region are copied and scaled with a Catmull-Rom
+// filter, or bilinearly with the canvas_noimageformats build tag
+func (cv *Canvas) ExportImage(rect image.Rectangle, scale float64) (*image.NRGBA, error) {
+	if !(scale > 0) || math.IsInf(scale, 0) {
+		return nil, errors.New("Export scale must be positive")
+	}
+	cw, ch := cv.Size()
+	rect = rect.Canon().Intersect(image.Rect(0, 0, cw, ch))
+	if rect.Empty() {
+		return nil, errors.New("Export region is outside of the canvas")
+	}
+	ow := int(math.Max(1, math.Round(float64(rect.Dx())*scale)))
+	oh := int(math.Max(1, math.Round(float64(rect.Dy())*scale)))
+	if err := checkSize(ow, oh); err != nil {
+		return nil, err
+	}
+
+	cv.Flush()
+	if rb := findRecordingBackend(cv.b); rb != nil {
+		if _, ok := rb.target.(*SoftwareBackend); ok {
+			b := NewBackend(ow, oh)
+			min := BackendVec{float64(rect.Min.X), float64(rect.Min.Y)}
+			m := BackendMatTranslate(min.Mulf(-1)).Mul(BackendMatScale(BackendVec{
+				float64(ow) / float64(rect.Dx()), float64(oh) / float64(rect.Dy()),
+			}))
+			view := Bounds{MinX: min[0], MinY: min[1], MaxX: float64(rect.Max.X), MaxY: float64(rect.Max.Y)}
+			rb.list.replay(b, m, view.Grow(1))
+			return ImageDataNRGBA(b.GetImageData(0, 0, ow, oh)), nil
+		}
+	}
+
+	img := ImageDataNRGBA(cv.GetImageData(rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy()))
+	if ow == rect.Dx() && oh == rect.Dy() {
+		return img, nil
+	}
+	scaled := image.NewNRGBA(image.Rect(0, 0, ow, oh))
+	scaleExport(scaled, img)
+	return scaled, nil
+}
+
+// findRecordingBackend returns the recording backend that the backend is
+// or wraps, or nil
+func findRecordingBackend(b Backend) *RecordingBackend {
+	for b != nil {
+		if rb, ok := b.(*RecordingBackend); ok {
+			return rb
+		}
+		wrapper, ok := b.(interface{ Target() Backend })
+		if !ok {
+			return nil
+		}
+		b = wrapper.Target()
+	}
+	return nil
+}
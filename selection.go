@@ -0,0 +1,179 @@
+package canvas
+
+import (
+	"math"
+	"time"
+)
+
+// Selection draws the outline of a rectangular or freeform selection as
+// "marching ants", black and white dashes that move along the outline.
+// The outline is given in canvas coordinates and drawn with the current
+// transformation.
+//
+// Moving the ants only changes the pixels on the outline, so instead of
+// drawing the whole frame again, Redraw can be used to draw the areas
+// returned by Damage
+type Selection struct {
+	// DashLength is the length of the dashes
+	DashLength float64
+	// Speed is the distance the ants move per second
+	Speed float64
+
+	pts    []BackendVec
+	closed bool
+	offset float64
+}
+
+// NewRectSelection creates a rectangular selection. To get sharp lines,
+// the coordinates should be in the middle of pixels, for example 10.5
+func NewRectSelection(x, y, w, h float64) *Selection {
+	return &Selection{
+		DashLength: 4,
+		Speed:      16,
+		pts:        []BackendVec{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}},
+		closed:     true,
+	}
+}
+
+// NewLassoSelection creates an empty freeform selection. Points are added
+// with Add while the user draws the selection, and Close connects the
+// last point to the first one
+func NewLassoSelection() *Selection {
+	return &Selection{DashLength: 4, Speed: 16}
+}
+
+// Add adds a point to the outline of a freeform selection
+func (s *Selection) Add(x, y float64) {
+	if s.closed {
+		return
+	}
+	if n := len(s.pts); n > 0 && isSamePoint(s.pts[n-1], BackendVec{x, y}, 0.5) {
+		return
+	}
+	s.pts = append(s.pts, BackendVec{x, y})
+}
+
+// Close closes the outline of a freeform selection
+func (s *Selection) Close() {
+	s.closed = true
+}
+
+// Closed returns true if the outline is closed
+func (s *Selection) Closed() bool { return s.closed }
+
+// Advance moves the ants by the distance they travel in the given time
+func (s *Selection) Advance(dt time.Duration) {
+	s.offset = math.Mod(s.offset+s.Speed*dt.Seconds(), s.DashLength*2)
+}
+
+// Contains returns true if the point is inside the selection. Open
+// freeform selections contain no points
+func (s *Selection) Contains(x, y float64) bool {
+	if !s.closed || len(s.pts) < 3 {
+		return false
+	}
+	inside := false
+	p := BackendVec{x, y}
+	prev := s.pts[len(s.pts)-1]
+	for _, pt := range s.pts {
+		if (pt[1] > p[1]) != (prev[1] > p[1]) &&
+			p[0] < (prev[0]-pt[0])*(p[1]-pt[1])/(prev[1]-pt[1])+pt[0] {
+			inside = !inside
+		}
+		prev = pt
+	}
+	return inside
+}
+
+// Bounds returns the area covered by the outline
+func (s *Selection) Bounds() Bounds {
+	if len(s.pts) == 0 {
+		return EmptyBounds
+	}
+	return BoundsOf(s.pts).Grow(1)
+}
+
+// Damage returns the areas that change when the ants move. Neighbouring
+// edges of the outline are combined as long as that doesn't add too much
+// area that isn't on the outline
+func (s *Selection) Damage() []Bounds {
+	n := len(s.pts)
+	if n < 2 {
+		return nil
+	}
+	edges := n - 1
+	if s.closed {
+		edges = n
+	}
+
+	var damage []Bounds
+	cur, area := EmptyBounds, 0.0
+	for i := 0; i < edges; i++ {
+		edge := BoundsOf([]BackendVec{s.pts[i], s.pts[(i+1)%n]}).Grow(1)
+		edgeArea := (edge.MaxX - edge.MinX) * (edge.MaxY - edge.MinY)
+		union := cur.Union(edge)
+		if !cur.Empty() && (union.MaxX-union.MinX)*(union.MaxY-union.MinY) > (area+edgeArea)*2 {
+			damage = append(damage, cur)
+			cur, area = edge, edgeArea
+			continue
+		}
+		cur, area = union, area+edgeArea
+	}
+	return append(damage, cur)
+}
+
+// Draw draws the outline with a line width of one pixel
+func (s *Selection) Draw(cv *Canvas) {
+	if len(s.pts) < 2 {
+		return
+	}
+	path := cv.NewPath2D()
+	path.MoveTo(s.pts[0][0], s.pts[0][1])
+	for _, pt := range s.pts[1:] {
+		path.LineTo(pt[0], pt[1])
+	}
+	if s.closed {
+		path.ClosePath()
+	}
+
+	// the dash offset has to be within the first dash, so the
+	// second half of the pattern is drawn with swapped colors
+	base, dashes := "#FFF", "#000"
+	pos := math.Mod(-s.offset, s.DashLength*2)
+	if pos < 0 {
+		pos += s.DashLength * 2
+	}
+	if pos >= s.DashLength {
+		base, dashes = dashes, base
+		pos -= s.DashLength
+	}
+
+	cv.Save()
+	cv.SetLineWidth(1)
+	cv.SetLineJoin(Miter)
+	cv.SetLineCap(Butt)
+	cv.SetGlobalAlpha(1)
+	cv.SetLineDash(nil)
+	cv.SetStrokeStyle(base)
+	cv.StrokePath(path)
+	cv.SetLineDash([]float64{s.DashLength, s.DashLength})
+	cv.SetLineDashOffset(pos)
+	cv.SetStrokeStyle(dashes)
+	cv.StrokePath(path)
+	cv.Restore()
+}
+
+// Redraw draws the areas returned by Damage. For each area, background
+// is called to draw the content below the selection, clipped to the
+// area, and then the outline is drawn. This replaces the current path
+func (s *Selection) Redraw(cv *Canvas, background func(area Bounds)) {
+	for _, area := range s.Damage() {
+		cv.Save()
+		cv.BeginPath()
+		cv.Rect(area.MinX, area.MinY, area.MaxX-area.MinX, area.MaxY-area.MinY)
+		cv.Clip()
+		background(area)
+		s.Draw(cv)
+		cv.Restore()
+	}
+}
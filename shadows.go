@@ -1,12 +1,29 @@
 package canvas
 
 import (
+	"fmt"
 	"image"
 	"math"
 )
 
+func (cv *Canvas) shadowStyle() BackendFillStyle {
+	color := cv.state.shadowColor
+	color.A = uint8(math.Round(((float64(color.A) / 255.0) * cv.state.globalAlpha) * 255.0))
+	return BackendFillStyle{Color: color, Blur: cv.state.shadowBlur}
+}
+
+// drawShadow draws the shadow that is cast behind a shape, so it has
+// to be called before the shape itself is drawn
 func (cv *Canvas) drawShadow(pts []BackendVec, mask *image.Alpha, canOverlap bool) {
-	if cv.state.shadowColor.A == 0 {
+	if cv.state.shadowColor.A == 0 || cv.state.shadowInset {
+		return
+	}
+	defer cv.resumePicking(cv.pausePicking())
+	defer cv.traceRegion("canvas.Shadow").End()
+	if cv.state.shadowSpread != 0 || (cv.state.shadowBlur > 0 && (mask != nil || !cv.caps.Blur)) {
+		// blur only the silhouette instead of a whole
+		// layer the size of the canvas
+		cv.drawShadowMask(pts, mask, false)
 		return
 	}
 	if cv.state.shadowOffsetX == 0 && cv.state.shadowOffsetY == 0 {
@@ -25,17 +42,344 @@ func (cv *Canvas) drawShadow(pts []BackendVec, mask *image.Alpha, canOverlap boo
 		})
 	}
 
-	color := cv.state.shadowColor
-	color.A = uint8(math.Round(((float64(color.A) / 255.0) * cv.state.globalAlpha) * 255.0))
-	style := BackendFillStyle{Color: color, Blur: cv.state.shadowBlur}
+	style := cv.shadowStyle()
 	if mask != nil {
 		if len(cv.shadowBuf) != 4 {
-			panic("invalid number of points to fill with mask, must be 4")
+			cv.reportError(fmt.Errorf("Shadow mask with %d points instead of 4: %w", len(cv.shadowBuf), ErrInvalidPath))
+			return
 		}
 		var quad [4]BackendVec
 		copy(quad[:], cv.shadowBuf)
-		cv.b.FillImageMask(&style, mask, quad)
+		cv.fillImageMask(&style, mask, quad)
+	} else {
+		cv.fill(&style, cv.shadowBuf, BackendMatIdentity, canOverlap)
+	}
+}
+
+// shadowPts returns the points transformed by the given matrix, but only
+// if a shadow is drawn at all
+func (cv *Canvas) shadowPts(pts []BackendVec, tf BackendMat) []BackendVec {
+	if cv.state.shadowColor.A == 0 || tf == BackendMatIdentity {
+		return pts
+	}
+	result := make([]BackendVec, len(pts))
+	for i, pt := range pts {
+		result[i] = pt.MulMat(tf)
+	}
+	return result
+}
+
+// drawInsetShadow draws the shadow that is cast inside a shape, so it
+// has to be called after the shape itself is drawn
+func (cv *Canvas) drawInsetShadow(pts []BackendVec, mask *image.Alpha) {
+	if cv.state.shadowColor.A == 0 || !cv.state.shadowInset {
+		return
+	}
+	if cv.state.shadowOffsetX == 0 && cv.state.shadowOffsetY == 0 &&
+		cv.state.shadowSpread == 0 && cv.state.shadowBlur == 0 {
+		return
+	}
+	defer cv.resumePicking(cv.pausePicking())
+	cv.drawShadowMask(pts, mask, true)
+}
+
+// drawShadowMask renders the silhouette of the shape into an alpha
+// mask, grows or shrinks it by the shadow spread, and then draws it
+// with the backend. For inset shadows the mask is inverted and cut
+// to the shape before it is drawn. The shape is either given as
+// triangles, or as a mask with the quad it is drawn to
+func (cv *Canvas) drawShadowMask(pts []BackendVec, mask *image.Alpha, inset bool) {
+	offset := BackendVec{cv.state.shadowOffsetX, cv.state.shadowOffsetY}
+
+	// the mask space is defined by the quad origin and the
+	// vectors along one mask pixel in x and y direction
+	var origin, ax, ay BackendVec
+	var shape *image.Alpha
+	margin := math.Abs(cv.state.shadowSpread) + cv.state.shadowBlur*3 + 1
+	if mask != nil {
+		if len(pts) != 4 {
+			cv.reportError(fmt.Errorf("Shadow mask with %d points instead of 4: %w", len(pts), ErrInvalidPath))
+			return
+		}
+		mw, mh := mask.Rect.Dx(), mask.Rect.Dy()
+		if mw == 0 || mh == 0 {
+			return
+		}
+		ax = pts[3].Sub(pts[0]).Divf(float64(mw))
+		ay = pts[1].Sub(pts[0]).Divf(float64(mh))
+		// masks of images and text can have a much higher resolution
+		// than the canvas, which a wide blur or spread doesn't need
+		budget := cv.shadowMaskBudget()
+		for mw > 1 || mh > 1 {
+			px := math.Min(ax.Len(), ay.Len())
+			pad := int(math.Ceil(margin / px))
+			if cv.state.shadowBlur/px <= shadowMaxBlur && (mw+pad*2)*(mh+pad*2) <= budget {
+				break
+			}
+			mask = halveAlpha(mask)
+			ax = ax.Mulf(float64(mw) / float64(mask.Rect.Dx()))
+			ay = ay.Mulf(float64(mh) / float64(mask.Rect.Dy()))
+			mw, mh = mask.Rect.Dx(), mask.Rect.Dy()
+		}
+		pad := int(math.Ceil(margin / math.Min(ax.Len(), ay.Len())))
+		if inset {
+			pad = 1
+		}
+		shape = image.NewAlpha(image.Rect(0, 0, mw+pad*2, mh+pad*2))
+		for y := 0; y < mh; y++ {
+			src := mask.Pix[mask.PixOffset(mask.Rect.Min.X, mask.Rect.Min.Y+y):]
+			copy(shape.Pix[shape.PixOffset(pad, pad+y):], src[:mw])
+		}
+		origin = pts[0].Sub(ax.Mulf(float64(pad))).Sub(ay.Mulf(float64(pad)))
+	} else {
+		if len(pts) < 3 {
+			return
+		}
+		// only the part of the shape that can cast a shadow onto the
+		// visible canvas is relevant, and only the visible part of the
+		// shadow with room for the blur around it has to be rendered
+		shapeBounds := BoundsOf(pts)
+		out := cv.visibleBounds()
+		var area Bounds
+		if inset {
+			out = out.Grow(margin + math.Max(math.Abs(offset[0]), math.Abs(offset[1])))
+			area = shapeBounds.Grow(margin).Intersect(out)
+		} else {
+			out.MinX, out.MaxX = out.MinX-offset[0], out.MaxX-offset[0]
+			out.MinY, out.MaxY = out.MinY-offset[1], out.MaxY-offset[1]
+			src := shapeBounds.Intersect(out.Grow(margin))
+			dst := out.Grow(cv.state.shadowBlur*3 + 1).Intersect(shapeBounds.Grow(margin))
+			if src.Empty() || dst.Empty() {
+				return
+			}
+			area = src.Union(dst)
+		}
+		if area.Empty() {
+			return
+		}
+		min := BackendVec{math.Floor(area.MinX), math.Floor(area.MinY)}
+		max := BackendVec{math.Ceil(area.MaxX), math.Ceil(area.MaxY)}
+		if max[0] <= min[0] || max[1] <= min[1] {
+			return
+		}
+
+		// wide blurs are rendered at a lower resolution
+		scale := 1.0
+		budget := float64(cv.shadowMaskBudget())
+		for cv.state.shadowBlur/scale > shadowMaxBlur ||
+			(max[0]-min[0])*(max[1]-min[1])/(scale*scale) > budget {
+			scale *= 2
+		}
+		w := int(math.Ceil((max[0] - min[0]) / scale))
+		h := int(math.Ceil((max[1] - min[1]) / scale))
+		shape = image.NewAlpha(image.Rect(0, 0, w, h))
+		origin = min.Divf(scale)
+		iterateTriangles(pts, func(tri [3]BackendVec) {
+			fillTriangleMask(shape, origin, []BackendVec{tri[0].Divf(scale), tri[1].Divf(scale), tri[2].Divf(scale)})
+		})
+		origin, ax, ay = min, BackendVec{scale, 0}, BackendVec{0, scale}
+	}
+
+	// the scale converts distances on the canvas into mask pixels
+	scale := 2 / (ax.Len() + ay.Len())
+	spread := cv.state.shadowSpread * scale
+	blur := cv.state.shadowBlur * scale
+
+	shadow := image.NewAlpha(shape.Rect)
+	copy(shadow.Pix, shape.Pix)
+	if inset {
+		spreadAlpha(shadow, -spread)
+		for i, a := range shadow.Pix {
+			shadow.Pix[i] = 255 - a
+		}
+
+		// move the inverted mask by the offset inside of the shape,
+		// with everything outside of the mask counting as shadow
+		det := ax[0]*ay[1] - ax[1]*ay[0]
+		ox := int(math.Round((offset[0]*ay[1] - offset[1]*ay[0]) / det))
+		oy := int(math.Round((ax[0]*offset[1] - ax[1]*offset[0]) / det))
+		shadow = shiftAlpha(shadow, ox, oy, 255)
+		if blur > 0 {
+			cv.stats.cur.BlurPixels += len(shadow.Pix)
+			shadow = blurAlpha(shadow, blur, qualityPresets[cv.quality].blurPasses)
+		}
+		for i, a := range shape.Pix {
+			shadow.Pix[i] = uint8((int(shadow.Pix[i])*int(a) + 127) / 255)
+		}
+		offset = BackendVec{}
 	} else {
-		cv.b.Fill(&style, cv.shadowBuf, BackendMatIdentity, canOverlap)
+		spreadAlpha(shadow, spread)
+		if blur > 0 {
+			cv.stats.cur.BlurPixels += len(shadow.Pix)
+			shadow = blurAlpha(shadow, blur, qualityPresets[cv.quality].blurPasses)
+		}
+	}
+
+	sw, sh := float64(shadow.Rect.Dx()), float64(shadow.Rect.Dy())
+	origin = origin.Add(offset)
+	quad := [4]BackendVec{
+		origin,
+		origin.Add(ay.Mulf(sh)),
+		origin.Add(ay.Mulf(sh)).Add(ax.Mulf(sw)),
+		origin.Add(ax.Mulf(sw)),
+	}
+
+	style := cv.shadowStyle()
+	style.Blur = 0
+	cv.fillImageMask(&style, shadow, quad)
+}
+
+// shadowMaxBlur is the blur size in mask pixels above which shadow masks
+// are rendered at a lower resolution. A wide blur hides the missing
+// detail, while the cost grows with the square of the blur size
+const shadowMaxBlur = 16
+
+// shadowMaskBudget returns the number of pixels above which shadow masks
+// are rendered at a lower resolution, so that a large spread can't
+// allocate more than a few times the size of the canvas
+func (cv *Canvas) shadowMaskBudget() int {
+	w, h := cv.b.Size()
+	return w*h*4 + 1<<16
+}
+
+func fillTriangleMask(mask *image.Alpha, origin BackendVec, tri []BackendVec) {
+	var t [3]BackendVec
+	for i := range t {
+		t[i] = tri[i].Sub(origin)
+	}
+	w, h := mask.Rect.Dx(), mask.Rect.Dy()
+	minY := int(math.Floor(math.Min(math.Min(t[0][1], t[1][1]), t[2][1])))
+	maxY := int(math.Ceil(math.Max(math.Max(t[0][1], t[1][1]), t[2][1])))
+	if minY < 0 {
+		minY = 0
+	}
+	if maxY > h-1 {
+		maxY = h - 1
+	}
+	for y := minY; y <= maxY; y++ {
+		l, r, out := triangleLR(t[:], float64(y)+0.5)
+		if out {
+			continue
+		}
+		fl := int(math.Max(0, math.Ceil(l-0.5)))
+		cr := int(math.Min(float64(w), math.Ceil(r-0.5)))
+		line := mask.Pix[y*mask.Stride:]
+		for x := fl; x < cr; x++ {
+			line[x] = 255
+		}
+	}
+}
+
+// spreadAlpha grows the opaque area of the mask by the given distance
+// in pixels, or shrinks it if the distance is negative
+func spreadAlpha(mask *image.Alpha, dist float64) {
+	if dist == 0 {
+		return
+	}
+	grow := dist > 0
+	dist = math.Abs(dist)
+	d := distanceTransform(mask, !grow)
+	for i, dd := range d {
+		if dd > 0 && dd <= dist {
+			if grow {
+				mask.Pix[i] = 255
+			} else {
+				mask.Pix[i] = 0
+			}
+		}
+	}
+}
+
+// distanceTransform returns for every pixel the approximate distance to
+// the closest pixel that is opaque, or transparent if inverse is set.
+// Pixels that are themselves opaque (or transparent) have a distance
+// of zero
+func distanceTransform(mask *image.Alpha, inverse bool) []float64 {
+	const diag = math.Sqrt2
+	w, h := mask.Rect.Dx(), mask.Rect.Dy()
+	d := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			opaque := mask.Pix[y*mask.Stride+x] >= 128
+			if opaque != inverse {
+				d[y*w+x] = 0
+			} else {
+				d[y*w+x] = math.Inf(1)
+			}
+		}
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := d[y*w+x]
+			if x > 0 {
+				v = math.Min(v, d[y*w+x-1]+1)
+			}
+			if y > 0 {
+				v = math.Min(v, d[(y-1)*w+x]+1)
+				if x > 0 {
+					v = math.Min(v, d[(y-1)*w+x-1]+diag)
+				}
+				if x < w-1 {
+					v = math.Min(v, d[(y-1)*w+x+1]+diag)
+				}
+			}
+			d[y*w+x] = v
+		}
+	}
+	for y := h - 1; y >= 0; y-- {
+		for x := w - 1; x >= 0; x-- {
+			v := d[y*w+x]
+			if x < w-1 {
+				v = math.Min(v, d[y*w+x+1]+1)
+			}
+			if y < h-1 {
+				v = math.Min(v, d[(y+1)*w+x]+1)
+				if x < w-1 {
+					v = math.Min(v, d[(y+1)*w+x+1]+diag)
+				}
+				if x > 0 {
+					v = math.Min(v, d[(y+1)*w+x-1]+diag)
+				}
+			}
+			d[y*w+x] = v
+		}
+	}
+	return d
+}
+
+// shiftAlpha moves the mask contents by the given number of pixels,
+// filling the uncovered area with the given alpha value
+func shiftAlpha(mask *image.Alpha, dx, dy int, fill uint8) *image.Alpha {
+	if dx == 0 && dy == 0 {
+		return mask
+	}
+	w, h := mask.Rect.Dx(), mask.Rect.Dy()
+	result := image.NewAlpha(mask.Rect)
+	for y := 0; y < h; y++ {
+		sy := y - dy
+		for x := 0; x < w; x++ {
+			sx := x - dx
+			if sx < 0 || sy < 0 || sx >= w || sy >= h {
+				result.Pix[y*result.Stride+x] = fill
+			} else {
+				result.Pix[y*result.Stride+x] = mask.Pix[sy*mask.Stride+sx]
+			}
+		}
+	}
+	return result
+}
+
+// blurAlpha blurs the mask with the same box blur that the software
+// backend uses for shadows
+func blurAlpha(mask *image.Alpha, size float64, passes int) *image.Alpha {
+	rgba := image.NewRGBA(mask.Rect)
+	for i, a := range mask.Pix {
+		rgba.Pix[i*4+3] = a
+	}
+	rgba = boxBlur(rgba, size, passes)
+	result := image.NewAlpha(mask.Rect)
+	for i := range result.Pix {
+		result.Pix[i] = rgba.Pix[i*4+3]
 	}
+	return result
 }
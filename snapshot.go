@@ -0,0 +1,188 @@
+package canvas
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// snapshotTile is the size of the tiles that snapshots copy when they are
+// drawn over
+const snapshotTile = 64
+
+// Snapshot is an immutable image of the content of a software backend at
+// the time it was taken. It shares the pixels of the backend until they
+// are drawn over, only then the affected tiles are copied, which makes
+// snapshots cheap for undo previews and trail effects. A snapshot is an
+// image.Image that can be drawn with DrawImage. Like the backend, it
+// must not be read while the backend is drawn to in another goroutine
+type Snapshot struct {
+	b     *SoftwareBackend
+	src   *image.RGBA
+	rect  image.Rectangle
+	cols  int
+	tiles [][]byte
+	// shared is the number of tiles that are still shared with the backend
+	shared int
+}
+
+// Snapshot returns a copy-on-write snapshot of the current content.
+// Snapshots that are no longer needed should be released, since the
+// backend copies tiles for every snapshot that is alive. Writing to the
+// Image field directly bypasses the copying
+func (b *SoftwareBackend) Snapshot() *Snapshot {
+	rect := b.Image.Rect
+	cols := (rect.Dx() + snapshotTile - 1) / snapshotTile
+	rows := (rect.Dy() + snapshotTile - 1) / snapshotTile
+	s := &Snapshot{
+		b:      b,
+		src:    b.Image,
+		rect:   rect,
+		cols:   cols,
+		tiles:  make([][]byte, cols*rows),
+		shared: cols * rows,
+	}
+	if s.shared > 0 {
+		b.snapshots = append(b.snapshots, s)
+	}
+	return s
+}
+
+// Release stops tracking the snapshot. It must not be used afterwards
+func (s *Snapshot) Release() {
+	if s.b != nil {
+		s.b.dropSnapshot(s)
+	}
+	s.src = nil
+	s.tiles = nil
+}
+
+func (s *Snapshot) ColorModel() color.Model { return color.RGBAModel }
+
+func (s *Snapshot) Bounds() image.Rectangle { return s.rect }
+
+func (s *Snapshot) At(x, y int) color.Color { return s.RGBAAt(x, y) }
+
+// RGBAAt returns the color of a pixel at the time of the snapshot
+func (s *Snapshot) RGBAAt(x, y int) color.RGBA {
+	if !(image.Point{x, y}.In(s.rect)) {
+		return color.RGBA{}
+	}
+	tx, ty := (x-s.rect.Min.X)/snapshotTile, (y-s.rect.Min.Y)/snapshotTile
+	tile := s.tiles[ty*s.cols+tx]
+	if tile == nil {
+		return s.src.RGBAAt(x, y)
+	}
+	tr := s.tileRect(tx, ty)
+	p := tile[((y-tr.Min.Y)*tr.Dx()+x-tr.Min.X)*4:]
+	return color.RGBA{p[0], p[1], p[2], p[3]}
+}
+
+// Image returns a copy of the snapshot as an RGBA image
+func (s *Snapshot) Image() *image.RGBA {
+	img := image.NewRGBA(s.rect)
+	for ty := 0; ty*s.cols < len(s.tiles); ty++ {
+		for tx := 0; tx < s.cols; tx++ {
+			tr := s.tileRect(tx, ty)
+			tile := s.tiles[ty*s.cols+tx]
+			for y := tr.Min.Y; y < tr.Max.Y; y++ {
+				row := img.Pix[img.PixOffset(tr.Min.X, y):img.PixOffset(tr.Max.X, y)]
+				if tile == nil {
+					copy(row, s.src.Pix[s.src.PixOffset(tr.Min.X, y):])
+				} else {
+					copy(row, tile[(y-tr.Min.Y)*tr.Dx()*4:])
+				}
+			}
+		}
+	}
+	return img
+}
+
+func (s *Snapshot) tileRect(tx, ty int) image.Rectangle {
+	min := s.rect.Min.Add(image.Pt(tx*snapshotTile, ty*snapshotTile))
+	return image.Rectangle{Min: min, Max: min.Add(image.Pt(snapshotTile, snapshotTile))}.Intersect(s.rect)
+}
+
+// save copies the shared tiles that overlap r before they are drawn over
+func (s *Snapshot) save(r image.Rectangle) {
+	r = r.Intersect(s.rect)
+	if r.Empty() {
+		return
+	}
+	tx0, ty0 := (r.Min.X-s.rect.Min.X)/snapshotTile, (r.Min.Y-s.rect.Min.Y)/snapshotTile
+	tx1, ty1 := (r.Max.X-s.rect.Min.X-1)/snapshotTile, (r.Max.Y-s.rect.Min.Y-1)/snapshotTile
+	for ty := ty0; ty <= ty1; ty++ {
+		for tx := tx0; tx <= tx1; tx++ {
+			i := ty*s.cols + tx
+			if s.tiles[i] != nil {
+				continue
+			}
+			tr := s.tileRect(tx, ty)
+			tile := make([]byte, tr.Dx()*tr.Dy()*4)
+			for y := tr.Min.Y; y < tr.Max.Y; y++ {
+				copy(tile[(y-tr.Min.Y)*tr.Dx()*4:(y-tr.Min.Y+1)*tr.Dx()*4], s.src.Pix[s.src.PixOffset(tr.Min.X, y):])
+			}
+			s.tiles[i] = tile
+			s.shared--
+		}
+	}
+}
+
+// touch copies the part of the image within r into the snapshots before
+// it is drawn to. Snapshots that no longer share any tiles are dropped
+func (b *SoftwareBackend) touch(r image.Rectangle) {
+	if len(b.snapshots) == 0 {
+		return
+	}
+	for i := 0; i < len(b.snapshots); {
+		s := b.snapshots[i]
+		s.save(r)
+		if s.shared == 0 {
+			s.src = nil
+			b.dropSnapshot(s)
+			continue
+		}
+		i++
+	}
+}
+
+// touchPts is touch for the bounds of the points, grown by a pixel for
+// anti-aliasing
+func (b *SoftwareBackend) touchPts(pts []BackendVec) {
+	if len(b.snapshots) == 0 || len(pts) == 0 {
+		return
+	}
+	bounds := BoundsOf(pts)
+	r := b.Image.Rect
+	if math.IsNaN(bounds.MinX + bounds.MinY + bounds.MaxX + bounds.MaxY) {
+		b.touch(r)
+		return
+	}
+	// clamped before the conversion, which is undefined for huge values
+	minX := math.Max(math.Floor(bounds.MinX)-1, float64(r.Min.X))
+	minY := math.Max(math.Floor(bounds.MinY)-1, float64(r.Min.Y))
+	maxX := math.Min(math.Ceil(bounds.MaxX)+1, float64(r.Max.X))
+	maxY := math.Min(math.Ceil(bounds.MaxY)+1, float64(r.Max.Y))
+	if minX < maxX && minY < maxY {
+		b.touch(image.Rect(int(minX), int(minY), int(maxX), int(maxY)))
+	}
+}
+
+func (b *SoftwareBackend) dropSnapshot(s *Snapshot) {
+	for i, s2 := range b.snapshots {
+		if s2 == s {
+			b.snapshots = append(b.snapshots[:i], b.snapshots[i+1:]...)
+			break
+		}
+	}
+	s.b = nil
+}
+
+// detachSnapshots stops tracking all snapshots when the backend stops
+// drawing to their image, which they then keep to themselves
+func (b *SoftwareBackend) detachSnapshots() {
+	for _, s := range b.snapshots {
+		s.b = nil
+	}
+	b.snapshots = nil
+}
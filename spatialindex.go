@@ -0,0 +1,174 @@
+package canvas
+
+import (
+	"math"
+	"sort"
+)
+
+// Bounds is an axis aligned rectangle in canvas pixel coordinates
+type Bounds struct {
+	MinX, MinY float64
+	MaxX, MaxY float64
+}
+
+// InfiniteBounds contains every point
+var InfiniteBounds = Bounds{
+	MinX: math.Inf(-1), MinY: math.Inf(-1),
+	MaxX: math.Inf(1), MaxY: math.Inf(1),
+}
+
+// EmptyBounds contains no points
+var EmptyBounds = Bounds{
+	MinX: math.Inf(1), MinY: math.Inf(1),
+	MaxX: math.Inf(-1), MaxY: math.Inf(-1),
+}
+
+// BoundsOf returns the bounds of the given points. The result is empty
+// if there are no points
+func BoundsOf(pts []BackendVec) Bounds {
+	b := EmptyBounds
+	for _, pt := range pts {
+		b.MinX = math.Min(b.MinX, pt[0])
+		b.MinY = math.Min(b.MinY, pt[1])
+		b.MaxX = math.Max(b.MaxX, pt[0])
+		b.MaxY = math.Max(b.MaxY, pt[1])
+	}
+	return b
+}
+
+// Empty returns true if the bounds contain no points
+func (b Bounds) Empty() bool {
+	return !(b.MinX <= b.MaxX && b.MinY <= b.MaxY)
+}
+
+// Intersects returns true if the two bounds overlap
+func (b Bounds) Intersects(b2 Bounds) bool {
+	return b.MinX <= b2.MaxX && b2.MinX <= b.MaxX && b.MinY <= b2.MaxY && b2.MinY <= b.MaxY
+}
+
+// Intersect returns the area covered by both bounds
+func (b Bounds) Intersect(b2 Bounds) Bounds {
+	return Bounds{
+		MinX: math.Max(b.MinX, b2.MinX), MinY: math.Max(b.MinY, b2.MinY),
+		MaxX: math.Min(b.MaxX, b2.MaxX), MaxY: math.Min(b.MaxY, b2.MaxY),
+	}
+}
+
+// Union returns the smallest bounds that contain both bounds
+func (b Bounds) Union(b2 Bounds) Bounds {
+	if b.Empty() {
+		return b2
+	} else if b2.Empty() {
+		return b
+	}
+	return Bounds{
+		MinX: math.Min(b.MinX, b2.MinX), MinY: math.Min(b.MinY, b2.MinY),
+		MaxX: math.Max(b.MaxX, b2.MaxX), MaxY: math.Max(b.MaxY, b2.MaxY),
+	}
+}
+
+// Grow returns the bounds extended by the given distance on all sides
+func (b Bounds) Grow(d float64) Bounds {
+	return Bounds{MinX: b.MinX - d, MinY: b.MinY - d, MaxX: b.MaxX + d, MaxY: b.MaxY + d}
+}
+
+// spatialNodeSize is the maximum number of entries per tree node
+const spatialNodeSize = 16
+
+// SpatialIndex is an R-tree over a fixed list of bounds, used to quickly
+// find the entries that intersect an area. It is built once in bulk
+// (sort-tile-recursive) and can not be modified afterwards
+type SpatialIndex struct {
+	root *spatialNode
+	size int
+}
+
+type spatialNode struct {
+	bounds   Bounds
+	ids      []int
+	children []*spatialNode
+}
+
+// NewSpatialIndex creates an index over the given bounds. The ids
+// returned by the index are the positions in the bounds slice. Empty
+// bounds are never returned
+func NewSpatialIndex(bounds []Bounds) *SpatialIndex {
+	leaves := make([]*spatialNode, 0, len(bounds))
+	for i, b := range bounds {
+		if b.Empty() {
+			continue
+		}
+		leaves = append(leaves, &spatialNode{bounds: b, ids: []int{i}})
+	}
+	si := &SpatialIndex{size: len(leaves)}
+	if len(leaves) == 0 {
+		return si
+	}
+
+	level := packSpatialNodes(leaves)
+	for len(level) > 1 {
+		level = packSpatialNodes(level)
+	}
+	si.root = level[0]
+	return si
+}
+
+// packSpatialNodes groups the nodes into parent nodes, first sorting
+// them into vertical slices by x and then each slice by y
+func packSpatialNodes(nodes []*spatialNode) []*spatialNode {
+	centerX := func(n *spatialNode) float64 { return n.bounds.MinX + n.bounds.MaxX }
+	centerY := func(n *spatialNode) float64 { return n.bounds.MinY + n.bounds.MaxY }
+
+	parentCount := (len(nodes) + spatialNodeSize - 1) / spatialNodeSize
+	slices := int(math.Ceil(math.Sqrt(float64(parentCount))))
+	sliceSize := slices * spatialNodeSize
+
+	sort.SliceStable(nodes, func(i, j int) bool { return centerX(nodes[i]) < centerX(nodes[j]) })
+	parents := make([]*spatialNode, 0, parentCount)
+	for s := 0; s < len(nodes); s += sliceSize {
+		slice := nodes[s:minInt(s+sliceSize, len(nodes))]
+		sort.SliceStable(slice, func(i, j int) bool { return centerY(slice[i]) < centerY(slice[j]) })
+		for i := 0; i < len(slice); i += spatialNodeSize {
+			group := slice[i:minInt(i+spatialNodeSize, len(slice))]
+			parent := &spatialNode{bounds: group[0].bounds}
+			for _, n := range group {
+				parent.bounds = parent.bounds.Union(n.bounds)
+				parent.children = append(parent.children, n)
+			}
+			parents = append(parents, parent)
+		}
+	}
+	return parents
+}
+
+// Len returns the number of entries in the index
+func (si *SpatialIndex) Len() int { return si.size }
+
+// Query returns the ids of all entries that intersect the given
+// bounds in ascending order
+func (si *SpatialIndex) Query(b Bounds) []int {
+	var ids []int
+	if si.root != nil {
+		ids = si.root.query(b, ids)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func (n *spatialNode) query(b Bounds, ids []int) []int {
+	if !n.bounds.Intersects(b) {
+		return ids
+	}
+	ids = append(ids, n.ids...)
+	for _, c := range n.children {
+		ids = c.query(b, ids)
+	}
+	return ids
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,108 @@
+package canvas
+
+import (
+	"image/color"
+)
+
+// SpriteInstance is one draw of an image with DrawSprites
+type SpriteInstance struct {
+	// SX, SY, SW and SH are the region of the image to draw. If SW or
+	// SH is zero, the whole image is drawn
+	SX, SY, SW, SH float64
+	// Transform maps the region, with its top left corner at 0/0, to
+	// the current coordinates, like the matrix of DrawImageTransformed
+	Transform BackendMat
+	// Alpha is multiplied with the global alpha, so 1 draws the sprite
+	// with the global alpha
+	Alpha float64
+	// Tint is multiplied with the color of every pixel. The zero value
+	// draws the sprite untinted
+	Tint color.RGBA
+}
+
+// BackendSprite is a sprite in canvas coordinates, as it is passed to
+// SpriteBackend
+type BackendSprite struct {
+	SX, SY, SW, SH float64
+	Pts            [4]BackendVec
+	Alpha          float64
+	Tint           color.RGBA
+	Tinted         bool
+}
+
+// SpriteBackend is implemented by backends that can draw many regions of
+// the same image in one call, for example with a single texture bind.
+// For other backends each sprite is drawn with DrawImage
+type SpriteBackend interface {
+	DrawSprites(dimg BackendImage, sprites []BackendSprite)
+}
+
+// DrawSprites draws many regions of the same image, each with its own
+// transformation, alpha and tint, which is much faster than calling
+// DrawImage for each of them, for example for particle systems and tile
+// maps. Shadows are not drawn for sprites
+func (cv *Canvas) DrawSprites(image interface{}, sprites []SpriteInstance) {
+	img := cv.getImage(image)
+	if img == nil || len(sprites) == 0 {
+		return
+	}
+
+	w, h := float64(img.Width()), float64(img.Height())
+	cv.spriteBuf = cv.spriteBuf[:0]
+	for _, s := range sprites {
+		if s.SW == 0 || s.SH == 0 {
+			s.SX, s.SY, s.SW, s.SH = 0, 0, w, h
+		}
+		cv.spriteBuf = append(cv.spriteBuf, BackendSprite{
+			SX: s.SX, SY: s.SY, SW: s.SW, SH: s.SH,
+			Pts: [4]BackendVec{
+				cv.tf(BackendVec{0, 0}.MulMat(s.Transform)),
+				cv.tf(BackendVec{0, s.SH}.MulMat(s.Transform)),
+				cv.tf(BackendVec{s.SW, s.SH}.MulMat(s.Transform)),
+				cv.tf(BackendVec{s.SW, 0}.MulMat(s.Transform)),
+			},
+			Alpha:  cv.state.globalAlpha * s.Alpha,
+			Tint:   s.Tint,
+			Tinted: s.Tint != color.RGBA{},
+		})
+	}
+	cv.drawSpriteBuf(img)
+}
+
+// drawSpriteBuf draws the sprites in spriteBuf with the image
+func (cv *Canvas) drawSpriteBuf(img *Image) {
+	cv.Flush()
+	r := cv.traceRegion("canvas.DrawSprites")
+	defer r.End()
+	for i := range cv.spriteBuf {
+		cv.countQuad(cv.spriteBuf[i].Pts)
+	}
+	cv.stats.cur.DrawCalls -= len(cv.spriteBuf) - 1
+	if sb, ok := cv.b.(SpriteBackend); ok {
+		bimg, ox, oy := subImageSource(img.img, 0, 0)
+		if ox != 0 || oy != 0 {
+			for i := range cv.spriteBuf {
+				cv.spriteBuf[i].SX += ox
+				cv.spriteBuf[i].SY += oy
+			}
+		}
+		sb.DrawSprites(bimg, cv.spriteBuf)
+		return
+	}
+	for _, s := range cv.spriteBuf {
+		if s.Tinted {
+			cv.drawTinted(img, s.SX, s.SY, s.SW, s.SH, s.Pts, s.Alpha, s.Tint)
+		} else {
+			bimg, sx, sy := subImageSource(img.img, s.SX, s.SY)
+			cv.b.DrawImage(bimg, sx, sy, s.SW, s.SH, s.Pts, s.Alpha)
+		}
+	}
+}
+
+// DrawSprites draws all sprites in a single loop
+func (b *SoftwareBackend) DrawSprites(dimg BackendImage, sprites []BackendSprite) {
+	for i := range sprites {
+		s := &sprites[i]
+		b.drawImage(dimg, s.SX, s.SY, s.SW, s.SH, s.Pts, s.Alpha, imageColor{tint: s.Tint, tinted: s.Tinted})
+	}
+}
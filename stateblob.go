@@ -0,0 +1,502 @@
+package canvas
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// stateBlobMagic starts every state blob, followed by the version
+const stateBlobMagic = "CVST"
+
+// stateBlobVersion is the version that is written. Version 1 blobs,
+// which have no gradient dithering, and version 2 blobs, which have no
+// stroke alignment, can still be loaded
+const stateBlobVersion = 3
+
+var errInvalidStateBlob = errors.New("Invalid canvas state blob")
+
+// style kinds of the state blob, gradients and patterns are stored once
+// in a table and referenced by index, since states share them
+const (
+	blobColor uint8 = iota
+	blobLinearGradient
+	blobRadialGradient
+	blobPattern
+)
+
+// SaveStateBlob serializes the content of the canvas together with the
+// drawing state and the stack of saved states, including the
+// transformations, fill and stroke styles, line and shadow settings and
+// clipping regions, so that a drawing session can be resumed with
+// LoadStateBlob, also in another process. Fonts are not included
+func (cv *Canvas) SaveStateBlob() ([]byte, error) {
+	cv.Flush()
+	w, h := cv.Size()
+	var bw blobWriter
+	bw.buf.WriteString(stateBlobMagic)
+	bw.u8(stateBlobVersion)
+	bw.u32(w)
+	bw.u32(h)
+	img := cv.GetImageData(0, 0, w, h)
+	for y := 0; y < h; y++ {
+		bw.buf.Write(img.Pix[y*img.Stride : y*img.Stride+w*4])
+	}
+	bw.u8(uint8(cv.quality))
+	bw.bool(cv.view.active)
+	bw.vec(cv.view.origin)
+	bw.mat(cv.view.mat)
+
+	states := append(append(make([]drawState, 0, len(cv.stateStack)+1), cv.stateStack...), cv.state)
+	var table []interface{}
+	index := make(map[interface{}]int)
+	for _, st := range states {
+		for _, s := range [2]drawStyle{st.fill, st.stroke} {
+			var obj interface{}
+			switch {
+			case s.linearGradient != nil:
+				obj = s.linearGradient
+			case s.radialGradient != nil:
+				obj = s.radialGradient
+			case s.imagePattern != nil:
+				obj = s.imagePattern
+			default:
+				continue
+			}
+			if _, ok := index[obj]; !ok {
+				index[obj] = len(table)
+				table = append(table, obj)
+			}
+		}
+	}
+	bw.u32(len(table))
+	for _, obj := range table {
+		switch v := obj.(type) {
+		case *LinearGradient:
+			bw.u8(blobLinearGradient)
+			bw.vec(v.from)
+			bw.vec(v.to)
+			bw.stops(v.data)
+			bw.u8(uint8(v.dither))
+		case *RadialGradient:
+			bw.u8(blobRadialGradient)
+			bw.vec(v.from)
+			bw.vec(v.to)
+			bw.f64(v.radFrom)
+			bw.f64(v.radTo)
+			bw.stops(v.data)
+			bw.u8(uint8(v.dither))
+		case *ImagePattern:
+			if err := bw.pattern(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	bw.u32(len(states))
+	for i := range states {
+		st := &states[i]
+		bw.mat(st.transform)
+		bw.style(st.fill, index)
+		bw.style(st.stroke, index)
+		bw.u8(uint8(st.textAlign))
+		bw.u8(uint8(st.textBaseline))
+		bw.f64(st.lineAlpha)
+		bw.f64(st.lineWidth)
+		bw.u8(uint8(st.lineJoin))
+		bw.u8(uint8(st.lineCap))
+		bw.f64(st.miterLimitSqr)
+		bw.f64(st.globalAlpha)
+		bw.u32(len(st.lineDash))
+		for _, d := range st.lineDash {
+			bw.f64(d)
+		}
+		bw.u32(st.lineDashPoint)
+		bw.f64(st.lineDashOffset)
+		bw.u32(len(st.clip.p))
+		for _, p := range st.clip.p {
+			bw.vec(p.pos)
+			bw.vec(p.next)
+			bw.u8(uint8(p.flags))
+		}
+		bw.vec(st.clip.move)
+		bw.f64(st.clip.cwSum)
+		bw.rgba(st.shadowColor)
+		bw.f64(st.shadowOffsetX)
+		bw.f64(st.shadowOffsetY)
+		bw.f64(st.shadowBlur)
+		bw.f64(st.shadowSpread)
+		bw.bool(st.shadowInset)
+		bw.u8(uint8(st.strokeAlign))
+	}
+	return bw.buf.Bytes(), nil
+}
+
+// LoadStateBlob restores the content and the drawing state saved with
+// SaveStateBlob. The canvas must have the size it had when the blob was
+// saved. The font of the canvas is kept for all restored states
+func (cv *Canvas) LoadStateBlob(data []byte) error {
+	br := blobReader{data: data}
+	if string(br.bytes(len(stateBlobMagic))) != stateBlobMagic {
+		return errInvalidStateBlob
+	}
+	version := br.u8()
+	if version < 1 || version > stateBlobVersion {
+		return errInvalidStateBlob
+	}
+	w, h := br.u32(), br.u32()
+	if cw, ch := cv.Size(); w != cw || h != ch {
+		return errors.New("Canvas state blob has a different size than the canvas")
+	}
+	img := &image.RGBA{Pix: br.bytes(w * h * 4), Stride: w * 4, Rect: image.Rect(0, 0, w, h)}
+	quality := Quality(br.enum(uint8(QualityDefault), uint8(QualityHigh)))
+	view := viewTransform{active: br.bool(), origin: br.vec(), mat: br.mat()}
+
+	table := make([]interface{}, br.count(1))
+	for i := range table {
+		switch br.u8() {
+		case blobLinearGradient:
+			from, to := br.vec(), br.vec()
+			lg := cv.CreateLinearGradient(from[0], from[1], to[0], to[1])
+			lg.data, lg.opaque = br.stops(lg.data)
+			if version >= 2 {
+				lg.dither = gradientDither(br.enum(uint8(NoDither), uint8(DitherNoise)))
+			}
+			table[i] = lg
+		case blobRadialGradient:
+			from, to := br.vec(), br.vec()
+			r0, r1 := br.f64(), br.f64()
+			rg := cv.CreateRadialGradient(from[0], from[1], r0, to[0], to[1], r1)
+			rg.data, rg.opaque = br.stops(rg.data)
+			if version >= 2 {
+				rg.dither = gradientDither(br.enum(uint8(NoDither), uint8(DitherNoise)))
+			}
+			table[i] = rg
+		case blobPattern:
+			rep := imagePatternRepeat(br.enum(uint8(Repeat), uint8(RepeatSpace)))
+			tf := br.mat()
+			var area [4]float64
+			if rep == RepeatRound || rep == RepeatSpace {
+				for j := range area {
+					area[j] = br.f64()
+				}
+			}
+			pimg := br.image()
+			if br.err != nil {
+				return br.err
+			}
+			ip := cv.CreatePattern(pimg, rep)
+			ip.tf, ip.area = tf, area
+			table[i] = ip
+		default:
+			return errInvalidStateBlob
+		}
+	}
+
+	states := make([]drawState, br.count(1))
+	for i := range states {
+		st := &states[i]
+		st.transform = br.mat()
+		st.fill = br.style(table)
+		st.stroke = br.style(table)
+		st.text = cv.state.text
+		st.textAlign = textAlign(br.enum(Left, End))
+		st.textBaseline = textBaseline(br.enum(Alphabetic, Bottom))
+		st.lineAlpha = br.f64()
+		st.lineWidth = br.f64()
+		st.lineJoin = lineJoin(br.enum(Miter, Butt))
+		st.lineCap = lineCap(br.enum(Miter, Butt))
+		st.miterLimitSqr = br.f64()
+		st.globalAlpha = br.f64()
+		if n := br.count(8); n > 0 {
+			st.lineDash = make([]float64, n)
+			for j := range st.lineDash {
+				st.lineDash[j] = br.f64()
+			}
+		}
+		st.lineDashPoint = br.u32()
+		if len(st.lineDash)%2 != 0 || !validLineDash(st.lineDash) ||
+			(st.lineDashPoint != 0 && st.lineDashPoint >= len(st.lineDash)) {
+			return errInvalidStateBlob
+		}
+		st.lineDashOffset = br.f64()
+		if n := br.count(33); n > 0 {
+			st.clip.p = make([]pathPoint, n)
+			for j := range st.clip.p {
+				st.clip.p[j] = pathPoint{pos: br.vec(), next: br.vec(), flags: pathPointFlag(br.u8())}
+			}
+		}
+		st.clip.move = br.vec()
+		st.clip.cwSum = br.f64()
+		st.shadowColor = br.rgba()
+		st.shadowOffsetX = br.f64()
+		st.shadowOffsetY = br.f64()
+		st.shadowBlur = br.f64()
+		st.shadowSpread = br.f64()
+		st.shadowInset = br.bool()
+		st.strokeAlign = Center
+		if version >= 3 {
+			st.strokeAlign = strokeAlign(br.u8())
+			if st.strokeAlign != Center && st.strokeAlign != Inner && st.strokeAlign != Outer {
+				return errInvalidStateBlob
+			}
+		}
+	}
+	if br.err != nil {
+		return br.err
+	}
+	if len(states) == 0 || len(br.data) != 0 {
+		return errInvalidStateBlob
+	}
+
+	cv.Flush()
+	cv.clearClip()
+	cv.PutImageData(img, 0, 0)
+	cv.SetQuality(quality)
+	cv.view = view
+	cv.stateStack = states[:len(states)-1]
+	cv.state = states[len(states)-1]
+	cv.reapplyClips()
+	return nil
+}
+
+type blobWriter struct {
+	buf bytes.Buffer
+	tmp [8]byte
+}
+
+func (bw *blobWriter) u8(v uint8) { bw.buf.WriteByte(v) }
+
+func (bw *blobWriter) bool(v bool) {
+	if v {
+		bw.u8(1)
+	} else {
+		bw.u8(0)
+	}
+}
+
+func (bw *blobWriter) u32(v int) {
+	binary.LittleEndian.PutUint32(bw.tmp[:4], uint32(v))
+	bw.buf.Write(bw.tmp[:4])
+}
+
+func (bw *blobWriter) f64(v float64) {
+	binary.LittleEndian.PutUint64(bw.tmp[:], math.Float64bits(v))
+	bw.buf.Write(bw.tmp[:])
+}
+
+func (bw *blobWriter) vec(v BackendVec) {
+	bw.f64(v[0])
+	bw.f64(v[1])
+}
+
+func (bw *blobWriter) mat(m BackendMat) {
+	for _, v := range m {
+		bw.f64(v)
+	}
+}
+
+func (bw *blobWriter) rgba(c color.RGBA) {
+	bw.buf.Write([]byte{c.R, c.G, c.B, c.A})
+}
+
+func (bw *blobWriter) stops(stops BackendGradient) {
+	bw.u32(len(stops))
+	for _, s := range stops {
+		bw.f64(s.Pos)
+		bw.rgba(s.Color)
+	}
+}
+
+func (bw *blobWriter) style(s drawStyle, index map[interface{}]int) {
+	switch {
+	case s.linearGradient != nil:
+		bw.u8(blobLinearGradient)
+		bw.u32(index[s.linearGradient])
+	case s.radialGradient != nil:
+		bw.u8(blobRadialGradient)
+		bw.u32(index[s.radialGradient])
+	case s.imagePattern != nil:
+		bw.u8(blobPattern)
+		bw.u32(index[s.imagePattern])
+	default:
+		bw.u8(blobColor)
+		bw.rgba(s.color)
+	}
+}
+
+// pattern writes the image of the pattern as straight alpha pixels
+func (bw *blobWriter) pattern(ip *ImagePattern) error {
+	if ip.img != nil && ip.img.evicted {
+		ip.cv.LoadImage(ip.img)
+	}
+	if ip.img == nil || ip.img.data == nil {
+		return errors.New("Image pattern without image data can not be saved")
+	}
+	bw.u8(blobPattern)
+	bw.u8(uint8(ip.rep))
+	bw.mat(ip.tf)
+	if ip.rep == RepeatRound || ip.rep == RepeatSpace {
+		for _, v := range ip.area {
+			bw.f64(v)
+		}
+	}
+	src := ip.img.data
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	bw.u32(w)
+	bw.u32(h)
+	// RGBA images already hold straight alpha, everything else is
+	// converted by the standard library
+	if rgba, ok := src.(*image.RGBA); ok {
+		bw.u8(0)
+		for y := 0; y < h; y++ {
+			off := rgba.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			bw.buf.Write(rgba.Pix[off : off+w*4])
+		}
+		return nil
+	}
+	nrgba := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(nrgba, nrgba.Rect, src, bounds.Min, draw.Src)
+	bw.u8(1)
+	bw.buf.Write(nrgba.Pix)
+	return nil
+}
+
+// blobReader reads the values written by blobWriter. After the data runs
+// out, all reads return zero values and err is set
+type blobReader struct {
+	data []byte
+	err  error
+}
+
+func (br *blobReader) bytes(n int) []byte {
+	if br.err != nil || n < 0 || n > len(br.data) {
+		br.err = errInvalidStateBlob
+		return nil
+	}
+	b := br.data[:n:n]
+	br.data = br.data[n:]
+	return b
+}
+
+func (br *blobReader) u8() uint8 {
+	if b := br.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (br *blobReader) bool() bool { return br.u8() != 0 }
+
+// enum reads a constant and checks that it is between min and max
+func (br *blobReader) enum(min, max uint8) uint8 {
+	v := br.u8()
+	if v < min || v > max {
+		br.err = errInvalidStateBlob
+		return min
+	}
+	return v
+}
+
+func (br *blobReader) u32() int {
+	if b := br.bytes(4); b != nil {
+		return int(binary.LittleEndian.Uint32(b))
+	}
+	return 0
+}
+
+// count reads a number of elements and checks it against the remaining
+// data, so that broken blobs can't cause huge allocations
+func (br *blobReader) count(minSize int) int {
+	n := br.u32()
+	if n > len(br.data)/minSize {
+		br.err = errInvalidStateBlob
+		return 0
+	}
+	return n
+}
+
+func (br *blobReader) f64() float64 {
+	if b := br.bytes(8); b != nil {
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (br *blobReader) vec() BackendVec { return BackendVec{br.f64(), br.f64()} }
+
+func (br *blobReader) mat() BackendMat {
+	var m BackendMat
+	for i := range m {
+		m[i] = br.f64()
+	}
+	return m
+}
+
+func (br *blobReader) rgba() color.RGBA {
+	if b := br.bytes(4); b != nil {
+		return color.RGBA{b[0], b[1], b[2], b[3]}
+	}
+	return color.RGBA{}
+}
+
+// stops appends the gradient stops and returns whether they are opaque
+func (br *blobReader) stops(stops BackendGradient) (BackendGradient, bool) {
+	opaque := true
+	n := br.count(12)
+	for i := 0; i < n; i++ {
+		s := BackendGradientStop{Pos: br.f64(), Color: br.rgba()}
+		if s.Color.A < 255 {
+			opaque = false
+		}
+		stops = append(stops, s)
+	}
+	return stops, opaque
+}
+
+func (br *blobReader) style(table []interface{}) drawStyle {
+	kind := br.u8()
+	if kind == blobColor {
+		return drawStyle{color: br.rgba()}
+	}
+	i := br.u32()
+	if br.err != nil || i >= len(table) {
+		br.err = errInvalidStateBlob
+		return drawStyle{}
+	}
+	var s drawStyle
+	var ok bool
+	switch kind {
+	case blobLinearGradient:
+		s.linearGradient, ok = table[i].(*LinearGradient)
+	case blobRadialGradient:
+		s.radialGradient, ok = table[i].(*RadialGradient)
+	case blobPattern:
+		s.imagePattern, ok = table[i].(*ImagePattern)
+	}
+	if !ok {
+		br.err = errInvalidStateBlob
+	}
+	return s
+}
+
+// image reads the pixels of a pattern image
+func (br *blobReader) image() image.Image {
+	w, h := br.u32(), br.u32()
+	nrgba := br.u8() == 1
+	if w == 0 || h == 0 || w > len(br.data)/4/h {
+		br.err = errInvalidStateBlob
+		return nil
+	}
+	pix := append([]byte(nil), br.bytes(w*h*4)...)
+	rect := image.Rect(0, 0, w, h)
+	if nrgba {
+		return &image.NRGBA{Pix: pix, Stride: w * 4, Rect: rect}
+	}
+	return &image.RGBA{Pix: pix, Stride: w * 4, Rect: rect}
+}
@@ -0,0 +1,122 @@
+package canvas
+
+import (
+	"math"
+)
+
+// RenderStats are counters of the work of a frame, to find the hot spots
+// of the rendering
+type RenderStats struct {
+	// DrawCalls is the number of fills, image draws and clears sent to
+	// the backend. Batched fills count as one
+	DrawCalls int
+	// Triangles is the number of triangles filled, images count as two
+	Triangles int
+	// Pixels is the area that was filled, drawn or cleared in pixels. It
+	// is approximate, overlapping triangles are counted twice
+	Pixels int
+	// BlurPixels is the area of blurred fills such as shadows, including
+	// the blur radius
+	BlurPixels int
+	// ImageCacheHits and ImageDecodes are the changes of the counters of
+	// ImageCacheStats during the frame
+	ImageCacheHits int
+	ImageDecodes   int
+	// GroupHits is the number of cache groups drawn from their cached
+	// image, GroupMisses the number that had to be drawn again
+	GroupHits   int
+	GroupMisses int
+	// Culled is the number of fills that were skipped because they
+	// were outside of the canvas, the render bounds or the clipping
+	// region
+	Culled int
+}
+
+// frameStats are the counters of the current and the last frame and the
+// trace task of the current frame
+type frameStats struct {
+	cur, last RenderStats
+	hits      int
+	decodes   int
+	// changes counts everything drawn, for live patterns of the canvas
+	changes uint64
+
+	trace frameTrace
+}
+
+// Stats returns the counters of the last frame, which ended with the
+// last call to EndFrame
+func (cv *Canvas) Stats() RenderStats {
+	return cv.stats.last
+}
+
+// EndFrame ends the current frame for the counters returned by Stats.
+// FramePacer calls it after every frame, other render loops should call
+// it after drawing a frame. It flushes the batched fills, lets backends
+// that implement FrameBackend finish the frame and then calls the present
+// function of SetFrameHandlers. When runtime/trace is enabled, every
+// frame is a trace task with regions for fills, shadows, text and images,
+// unless the package is built with the canvas_notrace build tag
+func (cv *Canvas) EndFrame() {
+	cv.Flush()
+	st := &cv.stats
+	st.cur.ImageCacheHits = cv.imageCache.stats.Hits - st.hits
+	st.cur.ImageDecodes = cv.imageCache.stats.Decodes - st.decodes
+	st.hits, st.decodes = cv.imageCache.stats.Hits, cv.imageCache.stats.Decodes
+	st.last, st.cur = st.cur, RenderStats{}
+
+	cv.frame.begun = false
+	endFrame(cv.b)
+	if cv.frame.onPresent != nil {
+		cv.frame.onPresent(st.last)
+	}
+
+	st.trace.next()
+}
+
+// backendFill calls Fill on the backend and counts it
+func (cv *Canvas) backendFill(style *BackendFillStyle, pts []BackendVec, tf BackendMat, canOverlap bool) {
+	cv.countTriangles(pts, tf)
+	if style.Blur > 0 && len(pts) > 0 {
+		b := BoundsOf(pts)
+		det := math.Sqrt(math.Abs(tf[0]*tf[3] - tf[1]*tf[2]))
+		w := (b.MaxX-b.MinX)*det + style.Blur*2
+		h := (b.MaxY-b.MinY)*det + style.Blur*2
+		cv.stats.cur.BlurPixels += int(w * h)
+	}
+	r := cv.traceRegion("canvas.Fill")
+	cv.b.Fill(style, pts, tf, canOverlap)
+	r.End()
+}
+
+// countTriangles counts a draw call with the triangles, or the quad if
+// there are four points
+func (cv *Canvas) countTriangles(pts []BackendVec, tf BackendMat) {
+	cv.stats.changes++
+	st := &cv.stats.cur
+	st.DrawCalls++
+	var area float64
+	if len(pts) == 4 {
+		st.Triangles += 2
+		area = triangleArea(pts[0], pts[1], pts[2]) + triangleArea(pts[0], pts[2], pts[3])
+	} else {
+		st.Triangles += len(pts) / 3
+		for i := 0; i+2 < len(pts); i += 3 {
+			area += triangleArea(pts[i], pts[i+1], pts[i+2])
+		}
+	}
+	st.Pixels += int(area * math.Abs(tf[0]*tf[3]-tf[1]*tf[2]))
+}
+
+// countQuad counts a draw call that covers the quad
+func (cv *Canvas) countQuad(pts [4]BackendVec) {
+	cv.stats.changes++
+	st := &cv.stats.cur
+	st.DrawCalls++
+	st.Triangles += 2
+	st.Pixels += int(quadArea(pts))
+}
+
+func triangleArea(a, b, c BackendVec) float64 {
+	return math.Abs((b[0]-a[0])*(c[1]-a[1])-(c[0]-a[0])*(b[1]-a[1])) / 2
+}
@@ -2,6 +2,7 @@ package canvas
 
 import (
 	"bytes"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
@@ -14,27 +15,242 @@ type SoftwareBackend struct {
 
 	MSAA int
 
+	// BilinearFilter samples images with bilinear filtering
+	// instead of the nearest pixel when drawing them
+	BilinearFilter bool
+	// Hairlines draws strokes of up to 1.5 pixels under the identity
+	// transformation with Wu's line algorithm, which is much faster
+	// for many thin lines, but approximates the line caps and joins
+	Hairlines bool
+	// BlurPasses is the number of box blur passes per direction
+	// used for shadows. Zero uses three passes
+	BlurPasses int
+	// DitherSeed seeds the noise of gradients drawn with DitherNoise.
+	// The same seed always gives the same pixels
+	DitherSeed int64
+
 	blurSwap *image.RGBA
+	// scissor is the rectangle that drawing is restricted to, the
+	// whole image if it is empty
+	scissor image.Rectangle
+
+	// buffers are the images that Present cycles through, buffer is
+	// the index of Image in them
+	buffers []*image.RGBA
+	buffer  int
 
 	clip    *image.Alpha
 	stencil *image.Alpha
 	w, h    int
+
+	// clipRect is the bounding rectangle of the clipping region. If
+	// clipIsRect is set, every pixel inside of it is in the region,
+	// so that spans clamped to it need no per pixel checks
+	clipRect   image.Rectangle
+	clipIsRect bool
+
+	// stencilDirty contains all pixels of the stencil that may have
+	// been set since it was last cleared
+	stencilDirty image.Rectangle
+
+	coverageFn func(coverage *image.Alpha)
+
+	// msaaHeads is the index of the first MSAA sample of each pixel
+	// while resolving a fill, or -1
+	msaaHeads []int32
+	// msaaUsed is set when msaaHeads was used since the last EndFrame
+	msaaUsed bool
+
+	reserveVerts int
+	reserveMSAA  int
+
+	snapshots []*Snapshot
+}
+
+// SizeLimits are the limits that NewBackendChecked and SetSizeChecked
+// validate a requested size against before allocating any memory. The
+// sizes of image files are checked against them before decoding. A
+// value of zero or less disables the respective check
+var SizeLimits = struct {
+	// MaxDimension is the maximum width or height
+	MaxDimension int
+	// MaxBytes is the maximum number of bytes allocated for the image
+	// and all buffers of the same size that the software backend may
+	// need, which are about 30 bytes per pixel
+	MaxBytes int
+}{
+	MaxDimension: 16384,
+	MaxBytes:     1 << 30,
 }
 
+// bytesPerPixel is an upper bound of the memory used per pixel: the RGBA
+// image, the clip and stencil masks, the MSAA sample heads, the target
+// and the two pass images of a shadow blur, and two more images for
+// triple buffering with Present
+const bytesPerPixel = 4 + 1 + 1 + 4 + 3*4 + 2*4
+
 func NewBackend(w, h int) *SoftwareBackend {
 	b := &SoftwareBackend{}
 	b.SetSize(w, h)
 	return b
 }
 
+// NewBackendChecked is like NewBackend, but returns an error instead
+// of panicking or running out of memory if the size is invalid or
+// exceeds SizeLimits. It is meant for sizes coming from untrusted input
+func NewBackendChecked(w, h int) (*SoftwareBackend, error) {
+	b := &SoftwareBackend{}
+	if err := b.SetSizeChecked(w, h); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// NewBackendForBuffer creates a software backend that draws directly into
+// the given pixels instead of allocating its own image, for example a
+// memory mapped framebuffer or a shared memory segment, so that no copy
+// is needed per frame. The pixels are in RGBA order with straight alpha,
+// like those of the image of NewBackend, and rows start stride bytes
+// apart. The buffer is replaced by a newly allocated image if the size is
+// changed with SetSize
+func NewBackendForBuffer(pix []byte, w, h, stride int) (*SoftwareBackend, error) {
+	if err := checkSize(w, h); err != nil {
+		return nil, err
+	}
+	if stride < w*4 {
+		return nil, fmt.Errorf("Stride of %d bytes is less than %d bytes per row", stride, w*4)
+	}
+	if h > 0 && len(pix) < stride*(h-1)+w*4 {
+		return nil, fmt.Errorf("Buffer of %d bytes is too small for %dx%d pixels with a stride of %d", len(pix), w, h, stride)
+	}
+	b := &SoftwareBackend{}
+	b.setImage(&image.RGBA{Pix: pix, Stride: stride, Rect: image.Rect(0, 0, w, h)})
+	return b, nil
+}
+
+// NewBackendForImage creates a software backend that draws directly into
+// the pixels of an existing image. The top left corner of the image
+// bounds is the origin of the canvas
+func NewBackendForImage(img *image.RGBA) (*SoftwareBackend, error) {
+	r := img.Rect
+	if r.Empty() {
+		return NewBackendForBuffer(nil, 0, 0, 0)
+	}
+	return NewBackendForBuffer(img.Pix[img.PixOffset(r.Min.X, r.Min.Y):], r.Dx(), r.Dy(), img.Stride)
+}
+
+// SetSizeChecked is like SetSize, but validates the size against
+// SizeLimits first. If an error is returned, the backend is unchanged
+func (b *SoftwareBackend) SetSizeChecked(w, h int) error {
+	if err := checkSize(w, h); err != nil {
+		return err
+	}
+	b.SetSize(w, h)
+	return nil
+}
+
+func checkSize(w, h int) error {
+	if w < 0 || h < 0 {
+		return fmt.Errorf("Invalid canvas size %dx%d", w, h)
+	}
+	if max := SizeLimits.MaxDimension; max > 0 && (w > max || h > max) {
+		return fmt.Errorf("Canvas size %dx%d exceeds the maximum dimension of %d", w, h, max)
+	}
+	if max := SizeLimits.MaxBytes; max > 0 && w > 0 && h > max/bytesPerPixel/w {
+		return fmt.Errorf("Canvas size %dx%d exceeds the memory limit of %d bytes", w, h, max)
+	}
+	return nil
+}
+
 func (b *SoftwareBackend) SetSize(w, h int) {
+	b.setImage(image.NewRGBA(image.Rect(0, 0, w, h)))
+}
+
+// setImage makes the backend draw into the image, which must start at 0,0
+func (b *SoftwareBackend) setImage(img *image.RGBA) {
+	b.detachSnapshots()
+	w, h := img.Rect.Dx(), img.Rect.Dy()
 	b.w, b.h = w, h
-	b.Image = image.NewRGBA(image.Rect(0, 0, w, h))
+	b.Image = img
 	b.clip = image.NewAlpha(image.Rect(0, 0, w, h))
 	b.stencil = image.NewAlpha(image.Rect(0, 0, w, h))
+	b.stencilDirty = image.Rectangle{}
 	b.ClearClip()
 }
 
+// SetSizePreserve changes the size of the backend like SetSize, but keeps
+// the existing content according to the policy. It returns the matrix
+// that maps the old pixel coordinates to the new ones
+func (b *SoftwareBackend) SetSizePreserve(w, h int, policy ResizePolicy) BackendMat {
+	old := b.Image
+	m := resizeMat(b.w, b.h, w, h, policy)
+	b.SetSize(w, h)
+	if old == nil {
+		return m
+	}
+
+	switch policy {
+	case ResizeKeep, ResizeCenter:
+		offset := image.Pt(int(m[4]), int(m[5]))
+		draw.Draw(b.Image, old.Rect.Add(offset), old, old.Rect.Min, draw.Src)
+	case ResizeStretch, ResizeFit:
+		p0 := BackendVec{0, 0}.MulMat(m)
+		p1 := BackendVec{float64(old.Rect.Dx()), float64(old.Rect.Dy())}.MulMat(m)
+		dst := image.Rect(int(math.Round(p0[0])), int(math.Round(p0[1])), int(math.Round(p1[0])), int(math.Round(p1[1])))
+		// interpolating straight alpha would mix the color of transparent
+		// pixels into the edges, so a premultiplied copy is scaled. The
+		// old image can't be changed, it may be a buffer of the caller
+		src := image.NewRGBA(old.Rect)
+		draw.Draw(src, src.Rect, old, old.Rect.Min, draw.Src)
+		PremultiplyRGBA(src)
+		scaleBilinear(b.Image, dst, src)
+		UnpremultiplyRGBA(b.Image.SubImage(dst).(*image.RGBA))
+	}
+	return m
+}
+
+// scaleBilinear draws the source image scaled to the rectangle of the
+// destination image with bilinear filtering. The channels are interpolated
+// independently, which is only correct for premultiplied alpha, so images
+// with straight alpha have to be premultiplied first
+func scaleBilinear(dst *image.RGBA, r image.Rectangle, src *image.RGBA) {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	if r.Empty() || sw == 0 || sh == 0 {
+		return
+	}
+	fx := float64(sw) / float64(r.Dx())
+	fy := float64(sh) / float64(r.Dy())
+	clamp := func(v, max int) int {
+		if v < 0 {
+			return 0
+		}
+		if v > max {
+			return max
+		}
+		return v
+	}
+	area := r.Intersect(dst.Rect)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		sy := (float64(y-r.Min.Y)+0.5)*fy - 0.5
+		y0 := int(math.Floor(sy))
+		ry := sy - float64(y0)
+		row0 := src.Pix[clamp(y0, sh-1)*src.Stride:]
+		row1 := src.Pix[clamp(y0+1, sh-1)*src.Stride:]
+		for x := area.Min.X; x < area.Max.X; x++ {
+			sx := (float64(x-r.Min.X)+0.5)*fx - 0.5
+			x0 := int(math.Floor(sx))
+			rx := sx - float64(x0)
+			i0, i1 := clamp(x0, sw-1)*4, clamp(x0+1, sw-1)*4
+			d := dst.Pix[dst.PixOffset(x, y):]
+			for c := 0; c < 4; c++ {
+				top := float64(row0[i0+c])*(1-rx) + float64(row0[i1+c])*rx
+				bottom := float64(row1[i0+c])*(1-rx) + float64(row1[i1+c])*rx
+				d[c] = uint8(math.Round(top*(1-ry) + bottom*ry))
+			}
+		}
+	}
+}
+
 func (b *SoftwareBackend) Bytes() []byte {
 	var buf bytes.Buffer
 	_ = png.Encode(&buf, b.Image)
@@ -45,12 +261,21 @@ func (b *SoftwareBackend) Size() (int, int) {
 	return b.w, b.h
 }
 
+// GetImageData returns a copy of the part of the image within the
+// rectangle, clipped to the image. The returned image keeps the
+// coordinates of the canvas
 func (b *SoftwareBackend) GetImageData(x, y, w, h int) *image.RGBA {
-	return b.Image.SubImage(image.Rect(x, y, w, h)).(*image.RGBA)
+	rect := image.Rect(x, y, x+w, y+h).Intersect(b.Image.Rect)
+	data := image.NewRGBA(rect)
+	for py := rect.Min.Y; py < rect.Max.Y; py++ {
+		copy(data.Pix[data.PixOffset(rect.Min.X, py):data.PixOffset(rect.Max.X, py)], b.Image.Pix[b.Image.PixOffset(rect.Min.X, py):])
+	}
+	return data
 }
 
 func (b *SoftwareBackend) PutImageData(img *image.RGBA, x, y int) {
-	draw.Draw(b.Image, image.Rect(x, y, img.Rect.Dx(), img.Rect.Dy()), img, image.ZP, draw.Src)
+	b.touch(image.Rect(x, y, x+img.Rect.Dx(), y+img.Rect.Dy()))
+	draw.Draw(b.Image, image.Rect(x, y, x+img.Rect.Dx(), y+img.Rect.Dy()), img, img.Rect.Min, draw.Src)
 }
 
 func (b *SoftwareBackend) CanUseAsImage(b2 Backend) bool {
@@ -61,6 +286,17 @@ func (b *SoftwareBackend) AsImage() BackendImage {
 	return nil
 }
 
+// Capabilities returns the optional features of the software backend.
+// Blur is not reported, since the software backend blurs a layer the
+// size of the whole canvas, which is slower than blurring on the CPU
+// in the canvas
+func (b *SoftwareBackend) Capabilities() BackendCapabilities {
+	return BackendCapabilities{
+		ImageMask:    true,
+		VertexColors: true,
+	}
+}
+
 type SoftwareLinearGradient struct {
 	data BackendGradient
 }
@@ -96,9 +332,10 @@ func (b *SoftwareBackend) activateBlurTarget() {
 }
 
 func (b *SoftwareBackend) drawBlurred(size float64) {
-	blurred := box3(b.Image, size)
+	blurred := boxBlur(b.Image, size, b.BlurPasses)
 	b.Image = b.blurSwap
-	draw.Draw(b.Image, b.Image.Rect, blurred, image.ZP, draw.Over)
+	r := b.scissorRect()
+	draw.Draw(b.Image, r, blurred, r.Min, draw.Over)
 }
 
 func box3(img *image.RGBA, size float64) *image.RGBA {
@@ -322,90 +559,107 @@ func triangleLR(tri []BackendVec, y float64) (l, r float64, outside bool) {
 	return
 }
 
-func (b *SoftwareBackend) fillTriangleNoAA(tri []BackendVec, fn func(x, y int)) {
+// fillTriangleNoAA calls fn for each row of the triangle with the span of
+// pixels x0 to x1-1 whose centers are inside the triangle
+func (b *SoftwareBackend) fillTriangleNoAA(tri []BackendVec, fn func(y, x0, x1 int)) {
 	minY := int(math.Floor(math.Min(math.Min(tri[0][1], tri[1][1]), tri[2][1])))
 	maxY := int(math.Ceil(math.Max(math.Max(tri[0][1], tri[1][1]), tri[2][1])))
-	if minY < 0 {
-		minY = 0
-	} else if minY >= b.h {
+	cr := b.clipRect
+	if minY < cr.Min.Y {
+		minY = cr.Min.Y
+	} else if minY >= cr.Max.Y {
 		return
 	}
-	if maxY < 0 {
+	if maxY < cr.Min.Y {
 		return
-	} else if maxY >= b.h {
-		maxY = b.h - 1
+	} else if maxY >= cr.Max.Y {
+		maxY = cr.Max.Y - 1
 	}
+	b.markStencil(tri, minY, maxY)
 	for y := minY; y <= maxY; y++ {
 		l, r, out := triangleLR(tri, float64(y)+0.5)
 		if out {
 			continue
 		}
-		if l < 0 {
-			l = 0
-		} else if l > float64(b.w) {
+		if l < float64(cr.Min.X) {
+			l = float64(cr.Min.X)
+		} else if l > float64(cr.Max.X) {
 			continue
 		}
-		if r < 0 {
+		if r < float64(cr.Min.X) {
 			continue
-		} else if r > float64(b.w) {
-			r = float64(b.w)
+		} else if r > float64(cr.Max.X) {
+			r = float64(cr.Max.X)
 		}
 		if l >= r {
 			continue
 		}
-		fl, cr := int(math.Floor(l)), int(math.Ceil(r))
-		for x := fl; x <= cr; x++ {
-			fx := float64(x) + 0.5
-			if fx < l || fx >= r {
-				continue
-			}
-			fn(x, y)
+		if x0, x1 := pixelSpan(l, r); x0 < x1 {
+			fn(y, x0, x1)
 		}
 	}
 }
 
+// pixelSpan returns the span of pixels x0 to x1-1 whose centers are
+// within l (inclusive) and r (exclusive)
+func pixelSpan(l, r float64) (x0, x1 int) {
+	x0 = int(math.Floor(l))
+	if float64(x0)+0.5 < l {
+		x0++
+	}
+	x1 = int(math.Ceil(r))
+	if x1 > x0 && float64(x1)-0.5 >= r {
+		x1--
+	}
+	return x0, x1
+}
+
 type msaaPixel struct {
 	ix, iy int
 	fx, fy float64
 	tx, ty float64
+	// next is the index of the next sample of the same pixel
+	next int32
 }
 
-func (b *SoftwareBackend) fillTriangleMSAA(tri []BackendVec, msaaLevel int, msaaPixels []msaaPixel, fn func(x, y int)) []msaaPixel {
+func (b *SoftwareBackend) fillTriangleMSAA(tri []BackendVec, msaaLevel int, msaaPixels []msaaPixel, fn func(y, x0, x1 int)) []msaaPixel {
 	msaaStep := 1.0 / float64(msaaLevel+1)
 
 	minY := int(math.Floor(math.Min(math.Min(tri[0][1], tri[1][1]), tri[2][1])))
 	maxY := int(math.Ceil(math.Max(math.Max(tri[0][1], tri[1][1]), tri[2][1])))
-	if minY < 0 {
-		minY = 0
-	} else if minY >= b.h {
+	cr := b.clipRect
+	if minY < cr.Min.Y {
+		minY = cr.Min.Y
+	} else if minY >= cr.Max.Y {
 		return msaaPixels
 	}
-	if maxY < 0 {
+	if maxY < cr.Min.Y {
 		return msaaPixels
-	} else if maxY >= b.h {
-		maxY = b.h - 1
+	} else if maxY >= cr.Max.Y {
+		maxY = cr.Max.Y - 1
 	}
+	b.markStencil(tri, minY, maxY)
 
 	for y := minY; y <= maxY; y++ {
 		var l, r [5]float64
-		allOut := true
-		minL, maxR := math.MaxFloat64, 0.0
+		allOut, anyOut := true, false
+		minL, maxR := math.MaxFloat64, float64(cr.Min.X)
 
 		sy := float64(y) + msaaStep*0.5
 		for step := 0; step <= msaaLevel; step++ {
 			var out bool
 			l[step], r[step], out = triangleLR(tri, sy)
-			if l[step] < 0 {
-				l[step] = 0
-			} else if l[step] > float64(b.w) {
-				l[step] = float64(b.w)
+			if l[step] < float64(cr.Min.X) {
+				l[step] = float64(cr.Min.X)
+			} else if l[step] > float64(cr.Max.X) {
+				l[step] = float64(cr.Max.X)
 				out = true
 			}
-			if r[step] < 0 {
-				r[step] = 0
+			if r[step] < float64(cr.Min.X) {
+				r[step] = float64(cr.Min.X)
 				out = true
-			} else if r[step] > float64(b.w) {
-				r[step] = float64(b.w)
+			} else if r[step] > float64(cr.Max.X) {
+				r[step] = float64(cr.Max.X)
 			}
 			if r[step] <= l[step] {
 				out = true
@@ -414,6 +668,8 @@ func (b *SoftwareBackend) fillTriangleMSAA(tri []BackendVec, msaaLevel int, msaa
 				allOut = false
 				minL = math.Min(minL, l[step])
 				maxR = math.Max(maxR, r[step])
+			} else {
+				anyOut = true
 			}
 			sy += msaaStep
 		}
@@ -422,8 +678,18 @@ func (b *SoftwareBackend) fillTriangleMSAA(tri []BackendVec, msaaLevel int, msaa
 			continue
 		}
 
-		fl, cr := int(math.Floor(minL)), int(math.Ceil(maxR))
-		for x := fl; x <= cr; x++ {
+		fl, fr := int(math.Floor(minL)), int(math.Ceil(maxR))
+		in0, in1 := 0, 0
+		if !anyOut {
+			in0, in1 = msaaInterior(l[:msaaLevel+1], r[:msaaLevel+1], msaaStep)
+		}
+		for x := fl; x <= fr; x++ {
+			if x == in0 && in0 < in1 {
+				fn(y, in0, in1)
+				x = in1 - 1
+				continue
+			}
+
 			sy = float64(y) + msaaStep*0.5
 			allIn := true
 		check:
@@ -440,7 +706,7 @@ func (b *SoftwareBackend) fillTriangleMSAA(tri []BackendVec, msaaLevel int, msaa
 			}
 
 			if allIn {
-				fn(x, y)
+				fn(y, x, x+1)
 				continue
 			}
 
@@ -449,7 +715,7 @@ func (b *SoftwareBackend) fillTriangleMSAA(tri []BackendVec, msaaLevel int, msaa
 				sx := float64(x) + msaaStep*0.5
 				for stepx := 0; stepx <= msaaLevel; stepx++ {
 					if sx >= l[stepy] && sx < r[stepy] {
-						msaaPixels = addMSAAPixel(msaaPixels, msaaPixel{ix: x, iy: y, fx: sx, fy: sy})
+						msaaPixels = append(msaaPixels, msaaPixel{ix: x, iy: y, fx: sx, fy: sy})
 					}
 					sx += msaaStep
 				}
@@ -461,13 +727,79 @@ func (b *SoftwareBackend) fillTriangleMSAA(tri []BackendVec, msaaLevel int, msaa
 	return msaaPixels
 }
 
-func addMSAAPixel(msaaPixels []msaaPixel, px msaaPixel) []msaaPixel {
-	for _, px2 := range msaaPixels {
-		if px == px2 {
-			return msaaPixels
+// msaaInterior returns the span of pixels x0 to x1-1 of a row whose
+// samples are all inside of the sample row spans l/r. The span is
+// shrunk by a pixel on the left so that rounding never puts a
+// partially covered pixel into it
+func msaaInterior(l, r []float64, msaaStep float64) (x0, x1 int) {
+	maxL, minR := l[0], r[0]
+	for i := 1; i < len(l); i++ {
+		maxL = math.Max(maxL, l[i])
+		minR = math.Min(minR, r[i])
+	}
+	x0 = int(math.Ceil(maxL-msaaStep*0.5)) + 1
+	x1 = int(math.Floor(minR - msaaStep*(float64(len(l))-0.5)))
+	return x0, x1
+}
+
+// resolveMSAA combines the samples of the partially covered pixels and
+// blends them into the image. The samples of each pixel are chained
+// together, so that samples of the same pixel from different triangles
+// are combined and duplicates are only counted once
+func (b *SoftwareBackend) resolveMSAA(msaaPixels []msaaPixel, msaaLevel int, fn func(px *msaaPixel) color.RGBA) {
+	if len(b.msaaHeads) != b.w*b.h {
+		b.msaaHeads = make([]int32, b.w*b.h)
+		for i := range b.msaaHeads {
+			b.msaaHeads[i] = -1
 		}
 	}
-	return append(msaaPixels, px)
+	heads := b.msaaHeads
+	b.msaaUsed = true
+	for i := range msaaPixels {
+		px := &msaaPixels[i]
+		idx := px.iy*b.w + px.ix
+		px.next = heads[idx]
+		heads[idx] = int32(i)
+	}
+
+	samples := (msaaLevel + 1) * (msaaLevel + 1)
+	for _, px := range msaaPixels {
+		idx := px.iy*b.w + px.ix
+		first := heads[idx]
+		if first < 0 {
+			continue
+		}
+		heads[idx] = -1
+
+		if b.clip.Pix[idx] == 0 || b.stencil.Pix[idx] > 0 {
+			continue
+		}
+		b.stencil.Pix[idx] = 255
+
+		var mr, mg, mb, ma int
+	samples:
+		for i := first; i >= 0; i = msaaPixels[i].next {
+			s := &msaaPixels[i]
+			for j := first; j != i; j = msaaPixels[j].next {
+				if msaaPixels[j].fx == s.fx && msaaPixels[j].fy == s.fy {
+					continue samples
+				}
+			}
+			col := fn(s)
+			mr += int(col.R)
+			mg += int(col.G)
+			mb += int(col.B)
+			ma += int(col.A)
+		}
+
+		combined := color.RGBA{
+			R: uint8(mr / samples),
+			G: uint8(mg / samples),
+			B: uint8(mb / samples),
+			A: uint8(ma / samples),
+		}
+		b.Image.SetRGBA(px.ix, px.iy, mix(combined, b.Image.RGBAAt(px.ix, px.iy)))
+	}
 }
 
 func quadArea(quad [4]BackendVec) float64 {
@@ -476,28 +808,79 @@ func quadArea(quad [4]BackendVec) float64 {
 	return math.Abs(leftv[0]*topv[1] - leftv[1]*topv[0])
 }
 
-func (b *SoftwareBackend) fillQuadNoAA(quad [4]BackendVec, fn func(x, y int, tx, ty float64)) {
+// quadMapping maps pixel positions to the relative position within a
+// quad, as used for texture coordinates. Quads that are not
+// parallelograms, as created by perspective transformations, are
+// mapped with the inverse of their projective mapping
+type quadMapping struct {
+	origin          BackendVec
+	leftv, topv     BackendVec
+	leftLen, topLen float64
+
+	projective bool
+	inv        [9]float64
+}
+
+func newQuadMapping(quad [4]BackendVec) quadMapping {
+	m := quadMapping{origin: quad[0]}
+	if !isParallelogram(quad) {
+		if h, ok := squareToQuad(quad); ok {
+			if inv, ok := invert3(h); ok {
+				m.projective = true
+				m.inv = inv
+				return m
+			}
+		}
+	}
+	m.leftv = BackendVec{quad[1][0] - quad[0][0], quad[1][1] - quad[0][1]}
+	m.leftLen = math.Sqrt(m.leftv[0]*m.leftv[0] + m.leftv[1]*m.leftv[1])
+	m.leftv[0] /= m.leftLen
+	m.leftv[1] /= m.leftLen
+	m.topv = BackendVec{quad[3][0] - quad[0][0], quad[3][1] - quad[0][1]}
+	m.topLen = math.Sqrt(m.topv[0]*m.topv[0] + m.topv[1]*m.topv[1])
+	m.topv[0] /= m.topLen
+	m.topv[1] /= m.topLen
+	return m
+}
+
+func (m *quadMapping) at(fx, fy float64) (float64, float64) {
+	if m.projective {
+		h := &m.inv
+		w := h[6]*fx + h[7]*fy + h[8]
+		return (h[0]*fx + h[1]*fy + h[2]) / w, (h[3]*fx + h[4]*fy + h[5]) / w
+	}
+	leftv, topv := m.leftv, m.topv
+	tfx := fx - m.origin[0]
+	tfy := fy - m.origin[1]
+
+	var tx, ty float64
+	if math.Abs(leftv[0]) > math.Abs(leftv[1]) {
+		tx = (tfy - tfx*(leftv[1]/leftv[0])) / (topv[1] - topv[0]*(leftv[1]/leftv[0]))
+		ty = (tfx - topv[0]*tx) / leftv[0]
+	} else {
+		tx = (tfx - tfy*(leftv[0]/leftv[1])) / (topv[0] - topv[1]*(leftv[0]/leftv[1]))
+		ty = (tfy - topv[1]*tx) / leftv[1]
+	}
+	return tx / m.topLen, ty / m.leftLen
+}
+
+// fillQuadNoAA calls fn for each row of the quad with the span of pixels
+// x0 to x1-1 whose centers are inside the quad
+func (b *SoftwareBackend) fillQuadNoAA(quad [4]BackendVec, fn func(y, x0, x1 int)) {
 	minY := int(math.Floor(math.Min(math.Min(quad[0][1], quad[1][1]), math.Min(quad[2][1], quad[3][1]))))
 	maxY := int(math.Ceil(math.Max(math.Max(quad[0][1], quad[1][1]), math.Max(quad[2][1], quad[3][1]))))
-	if minY < 0 {
-		minY = 0
-	} else if minY >= b.h {
+	cr := b.clipRect
+	if minY < cr.Min.Y {
+		minY = cr.Min.Y
+	} else if minY >= cr.Max.Y {
 		return
 	}
-	if maxY < 0 {
+	if maxY < cr.Min.Y {
 		return
-	} else if maxY >= b.h {
-		maxY = b.h - 1
+	} else if maxY >= cr.Max.Y {
+		maxY = cr.Max.Y - 1
 	}
-
-	leftv := BackendVec{quad[1][0] - quad[0][0], quad[1][1] - quad[0][1]}
-	leftLen := math.Sqrt(leftv[0]*leftv[0] + leftv[1]*leftv[1])
-	leftv[0] /= leftLen
-	leftv[1] /= leftLen
-	topv := BackendVec{quad[3][0] - quad[0][0], quad[3][1] - quad[0][1]}
-	topLen := math.Sqrt(topv[0]*topv[0] + topv[1]*topv[1])
-	topv[0] /= topLen
-	topv[1] /= topLen
+	b.markStencil(quad[:], minY, maxY)
 
 	tri1 := [3]BackendVec{quad[0], quad[1], quad[2]}
 	tri2 := [3]BackendVec{quad[0], quad[2], quad[3]}
@@ -509,39 +892,22 @@ func (b *SoftwareBackend) fillQuadNoAA(quad [4]BackendVec, fn func(x, y int, tx,
 		}
 		l := math.Min(lf1, lf2)
 		r := math.Max(rf1, rf2)
-		if l < 0 {
-			l = 0
-		} else if l > float64(b.w) {
+		if l < float64(cr.Min.X) {
+			l = float64(cr.Min.X)
+		} else if l > float64(cr.Max.X) {
 			continue
 		}
-		if r < 0 {
+		if r < float64(cr.Min.X) {
 			continue
-		} else if r > float64(b.w) {
-			r = float64(b.w)
+		} else if r > float64(cr.Max.X) {
+			r = float64(cr.Max.X)
 		}
 		if l >= r {
 			continue
 		}
 
-		tfy := float64(y) + 0.5 - quad[0][1]
-		fl, cr := int(math.Floor(l)), int(math.Ceil(r))
-		for x := fl; x <= cr; x++ {
-			fx := float64(x) + 0.5
-			if fx < l || fx >= r {
-				continue
-			}
-			tfx := fx - quad[0][0]
-
-			var tx, ty float64
-			if math.Abs(leftv[0]) > math.Abs(leftv[1]) {
-				tx = (tfy - tfx*(leftv[1]/leftv[0])) / (topv[1] - topv[0]*(leftv[1]/leftv[0]))
-				ty = (tfx - topv[0]*tx) / leftv[0]
-			} else {
-				tx = (tfx - tfy*(leftv[0]/leftv[1])) / (topv[0] - topv[1]*(leftv[0]/leftv[1]))
-				ty = (tfy - topv[1]*tx) / leftv[1]
-			}
-
-			fn(x, y, tx/topLen, ty/leftLen)
+		if x0, x1 := pixelSpan(l, r); x0 < x1 {
+			fn(y, x0, x1)
 		}
 	}
 }
@@ -551,32 +917,27 @@ func (b *SoftwareBackend) fillQuadMSAA(quad [4]BackendVec, msaaLevel int, msaaPi
 
 	minY := int(math.Floor(math.Min(math.Min(quad[0][1], quad[1][1]), math.Min(quad[2][1], quad[3][1]))))
 	maxY := int(math.Ceil(math.Max(math.Max(quad[0][1], quad[1][1]), math.Max(quad[2][1], quad[3][1]))))
-	if minY < 0 {
-		minY = 0
-	} else if minY >= b.h {
+	cr := b.clipRect
+	if minY < cr.Min.Y {
+		minY = cr.Min.Y
+	} else if minY >= cr.Max.Y {
 		return msaaPixels
 	}
-	if maxY < 0 {
+	if maxY < cr.Min.Y {
 		return msaaPixels
-	} else if maxY >= b.h {
-		maxY = b.h - 1
+	} else if maxY >= cr.Max.Y {
+		maxY = cr.Max.Y - 1
 	}
+	b.markStencil(quad[:], minY, maxY)
 
-	leftv := BackendVec{quad[1][0] - quad[0][0], quad[1][1] - quad[0][1]}
-	leftLen := math.Sqrt(leftv[0]*leftv[0] + leftv[1]*leftv[1])
-	leftv[0] /= leftLen
-	leftv[1] /= leftLen
-	topv := BackendVec{quad[3][0] - quad[0][0], quad[3][1] - quad[0][1]}
-	topLen := math.Sqrt(topv[0]*topv[0] + topv[1]*topv[1])
-	topv[0] /= topLen
-	topv[1] /= topLen
+	m := newQuadMapping(quad)
 
 	tri1 := [3]BackendVec{quad[0], quad[1], quad[2]}
 	tri2 := [3]BackendVec{quad[0], quad[2], quad[3]}
 	for y := minY; y <= maxY; y++ {
 		var l, r [5]float64
-		allOut := true
-		minL, maxR := math.MaxFloat64, 0.0
+		allOut, anyOut := true, false
+		minL, maxR := math.MaxFloat64, float64(cr.Min.X)
 
 		sy := float64(y) + msaaStep*0.5
 		for step := 0; step <= msaaLevel; step++ {
@@ -586,17 +947,17 @@ func (b *SoftwareBackend) fillQuadMSAA(quad [4]BackendVec, msaaLevel int, msaaPi
 			r[step] = math.Max(rf1, rf2)
 			out := out1 || out2
 
-			if l[step] < 0 {
-				l[step] = 0
-			} else if l[step] > float64(b.w) {
-				l[step] = float64(b.w)
+			if l[step] < float64(cr.Min.X) {
+				l[step] = float64(cr.Min.X)
+			} else if l[step] > float64(cr.Max.X) {
+				l[step] = float64(cr.Max.X)
 				out = true
 			}
-			if r[step] < 0 {
-				r[step] = 0
+			if r[step] < float64(cr.Min.X) {
+				r[step] = float64(cr.Min.X)
 				out = true
-			} else if r[step] > float64(b.w) {
-				r[step] = float64(b.w)
+			} else if r[step] > float64(cr.Max.X) {
+				r[step] = float64(cr.Max.X)
 			}
 			if r[step] <= l[step] {
 				out = true
@@ -605,6 +966,8 @@ func (b *SoftwareBackend) fillQuadMSAA(quad [4]BackendVec, msaaLevel int, msaaPi
 				allOut = false
 				minL = math.Min(minL, l[step])
 				maxR = math.Max(maxR, r[step])
+			} else {
+				anyOut = true
 			}
 			sy += msaaStep
 		}
@@ -613,37 +976,32 @@ func (b *SoftwareBackend) fillQuadMSAA(quad [4]BackendVec, msaaLevel int, msaaPi
 			continue
 		}
 
-		fl, cr := int(math.Floor(minL)), int(math.Ceil(maxR))
-		for x := fl; x <= cr; x++ {
+		fl, fr := int(math.Floor(minL)), int(math.Ceil(maxR))
+		in0, in1 := 0, 0
+		if !anyOut {
+			in0, in1 = msaaInterior(l[:msaaLevel+1], r[:msaaLevel+1], msaaStep)
+		}
+		for x := fl; x <= fr; x++ {
 			sy = float64(y) + msaaStep*0.5
 			allIn := true
-		check:
-			for stepy := 0; stepy <= msaaLevel; stepy++ {
-				sx := float64(x) + msaaStep*0.5
-				for stepx := 0; stepx <= msaaLevel; stepx++ {
-					if sx < l[stepy] || sx >= r[stepy] {
-						allIn = false
-						break check
+			if x < in0 || x >= in1 {
+			check:
+				for stepy := 0; stepy <= msaaLevel; stepy++ {
+					sx := float64(x) + msaaStep*0.5
+					for stepx := 0; stepx <= msaaLevel; stepx++ {
+						if sx < l[stepy] || sx >= r[stepy] {
+							allIn = false
+							break check
+						}
+						sx += msaaStep
 					}
-					sx += msaaStep
+					sy += msaaStep
 				}
-				sy += msaaStep
 			}
 
 			if allIn {
-				tfx := float64(x) + 0.5 - quad[0][0]
-				tfy := float64(y) + 0.5 - quad[0][1]
-
-				var tx, ty float64
-				if math.Abs(leftv[0]) > math.Abs(leftv[1]) {
-					tx = (tfy - tfx*(leftv[1]/leftv[0])) / (topv[1] - topv[0]*(leftv[1]/leftv[0]))
-					ty = (tfx - topv[0]*tx) / leftv[0]
-				} else {
-					tx = (tfx - tfy*(leftv[0]/leftv[1])) / (topv[0] - topv[1]*(leftv[0]/leftv[1]))
-					ty = (tfy - topv[1]*tx) / leftv[1]
-				}
-
-				fn(x, y, tx/topLen, ty/leftLen)
+				tx, ty := m.at(float64(x)+0.5, float64(y)+0.5)
+				fn(x, y, tx, ty)
 				continue
 			}
 
@@ -652,19 +1010,8 @@ func (b *SoftwareBackend) fillQuadMSAA(quad [4]BackendVec, msaaLevel int, msaaPi
 				sx := float64(x) + msaaStep*0.5
 				for stepx := 0; stepx <= msaaLevel; stepx++ {
 					if sx >= l[stepy] && sx < r[stepy] {
-						tfx := sx - quad[0][0]
-						tfy := sy - quad[0][1]
-
-						var tx, ty float64
-						if math.Abs(leftv[0]) > math.Abs(leftv[1]) {
-							tx = (tfy - tfx*(leftv[1]/leftv[0])) / (topv[1] - topv[0]*(leftv[1]/leftv[0]))
-							ty = (tfx - topv[0]*tx) / leftv[0]
-						} else {
-							tx = (tfx - tfy*(leftv[0]/leftv[1])) / (topv[0] - topv[1]*(leftv[0]/leftv[1]))
-							ty = (tfy - topv[1]*tx) / leftv[1]
-						}
-
-						msaaPixels = addMSAAPixel(msaaPixels, msaaPixel{ix: x, iy: y, fx: sx, fy: sy, tx: tx / topLen, ty: ty / leftLen})
+						tx, ty := m.at(sx, sy)
+						msaaPixels = append(msaaPixels, msaaPixel{ix: x, iy: y, fx: sx, fy: sy, tx: tx, ty: ty})
 					}
 					sx += msaaStep
 				}
@@ -680,10 +1027,8 @@ func (b *SoftwareBackend) fillQuad(pts [4]BackendVec, fn func(x, y, tx, ty float
 	b.clearStencil()
 
 	if b.MSAA > 0 {
-		var msaaPixelBuf [500]msaaPixel
-		msaaPixels := msaaPixelBuf[:0]
-
-		msaaPixels = b.fillQuadMSAA(pts, b.MSAA, msaaPixels, func(x, y int, tx, ty float64) {
+		scratch := getMSAAScratch(b.reserveMSAA)
+		msaaPixels := b.fillQuadMSAA(pts, b.MSAA, scratch.buf, func(x, y int, tx, ty float64) {
 			if b.clip.AlphaAt(x, y).A == 0 {
 				return
 			}
@@ -697,193 +1042,204 @@ func (b *SoftwareBackend) fillQuad(pts [4]BackendVec, fn func(x, y, tx, ty float
 			}
 		})
 
-		samples := (b.MSAA + 1) * (b.MSAA + 1)
-
-		for i, px := range msaaPixels {
-			if px.ix < 0 || b.clip.AlphaAt(px.ix, px.iy).A == 0 || b.stencil.AlphaAt(px.ix, px.iy).A > 0 {
-				continue
-			}
-			b.stencil.SetAlpha(px.ix, px.iy, color.Alpha{A: 255})
-
-			var mr, mg, mb, ma int
-			for j, px2 := range msaaPixels[i:] {
-				if px2.ix != px.ix || px2.iy != px.iy {
-					continue
-				}
-
-				col := fn(px2.fx, px2.fy, px2.tx, px2.ty)
-				mr += int(col.R)
-				mg += int(col.G)
-				mb += int(col.B)
-				ma += int(col.A)
-
-				msaaPixels[i+j].ix = -1
-			}
-
-			combined := color.RGBA{
-				R: uint8(mr / samples),
-				G: uint8(mg / samples),
-				B: uint8(mb / samples),
-				A: uint8(ma / samples),
-			}
-			b.Image.SetRGBA(px.ix, px.iy, mix(combined, b.Image.RGBAAt(px.ix, px.iy)))
-		}
-
+		b.resolveMSAA(msaaPixels, b.MSAA, func(px *msaaPixel) color.RGBA {
+			return fn(px.fx, px.fy, px.tx, px.ty)
+		})
+		scratch.release(msaaPixels)
 	} else {
-		b.fillQuadNoAA(pts, func(x, y int, tx, ty float64) {
-			if b.clip.AlphaAt(x, y).A == 0 {
-				return
-			}
-			if b.stencil.AlphaAt(x, y).A > 0 {
-				return
-			}
-			b.stencil.SetAlpha(x, y, color.Alpha{A: 255})
-			col := fn(float64(x)+0.5, float64(y)+0.5, tx, ty)
-			if col.A > 0 {
-				b.Image.SetRGBA(x, y, mix(col, b.Image.RGBAAt(x, y)))
-			}
+		m := newQuadMapping(pts)
+		b.fillQuadNoAA(pts, func(y, x0, x1 int) {
+			fy := float64(y) + 0.5
+			b.blendSpan(y, x0, x1, func(x int) color.RGBA {
+				fx := float64(x) + 0.5
+				tx, ty := m.at(fx, fy)
+				return fn(fx, fy, tx, ty)
+			})
 		})
 	}
 }
 
-func iterateTriangles(pts []BackendVec, fn func(tri []BackendVec)) {
+// iterateTriangles calls fn for each triangle. Four points are a quad
+// that is split into two triangles. The triangles are passed by value so
+// that the points don't escape to the heap through fn
+func iterateTriangles(pts []BackendVec, fn func(tri [3]BackendVec)) {
 	if len(pts) == 4 {
-		var buf [3]BackendVec
-		buf[0] = pts[0]
-		buf[1] = pts[1]
-		buf[2] = pts[2]
-		fn(buf[:])
-		buf[1] = pts[2]
-		buf[2] = pts[3]
-		fn(buf[:])
+		fn([3]BackendVec{pts[0], pts[1], pts[2]})
+		fn([3]BackendVec{pts[0], pts[2], pts[3]})
 		return
 	}
 	for i := 3; i <= len(pts); i += 3 {
-		fn(pts[i-3 : i])
+		fn([3]BackendVec{pts[i-3], pts[i-2], pts[i-1]})
 	}
 }
 
 func (b *SoftwareBackend) fillTrianglesNoAA(pts []BackendVec, fn func(x, y float64) color.RGBA) {
-	iterateTriangles(pts[:], func(tri []BackendVec) {
-		b.fillTriangleNoAA(tri, func(x, y int) {
-			if b.clip.AlphaAt(x, y).A == 0 {
-				return
-			}
-			if b.stencil.AlphaAt(x, y).A > 0 {
-				return
-			}
-			b.stencil.SetAlpha(x, y, color.Alpha{A: 255})
-			col := fn(float64(x), float64(y))
-			if col.A > 0 {
-				b.Image.SetRGBA(x, y, mix(col, b.Image.RGBAAt(x, y)))
-			}
+	iterateTriangles(pts, func(tri [3]BackendVec) {
+		b.fillTriangleNoAA(tri[:], func(y, x0, x1 int) {
+			fy := float64(y)
+			b.blendSpan(y, x0, x1, func(x int) color.RGBA {
+				return fn(float64(x), fy)
+			})
 		})
 	})
 }
 
-func (b *SoftwareBackend) fillTrianglesMSAA(pts []BackendVec, msaaLevel int, fn func(x, y float64) color.RGBA) {
-	var msaaPixelBuf [500]msaaPixel
-	msaaPixels := msaaPixelBuf[:0]
-
-	iterateTriangles(pts[:], func(tri []BackendVec) {
-		msaaPixels = b.fillTriangleMSAA(tri, msaaLevel, msaaPixels, func(x, y int) {
-			if b.clip.AlphaAt(x, y).A == 0 {
-				return
-			}
-			if b.stencil.AlphaAt(x, y).A > 0 {
-				return
-			}
-			b.stencil.SetAlpha(x, y, color.Alpha{A: 255})
-			col := fn(float64(x), float64(y))
-			if col.A > 0 {
-				b.Image.SetRGBA(x, y, mix(col, b.Image.RGBAAt(x, y)))
+// fillTrianglesSolid fills the triangles with an opaque color, which
+// replaces the pixels instead of blending with them
+func (b *SoftwareBackend) fillTrianglesSolid(pts []BackendVec, col color.RGBA) {
+	if b.clipIsRect && b.coverageFn == nil {
+		// drawing the same opaque color twice has no effect, so
+		// overlapping triangles don't need the stencil
+		iterateTriangles(pts, func(tri [3]BackendVec) {
+			b.fillTriangleNoAA(tri[:], func(y, x0, x1 int) {
+				off := b.Image.PixOffset(x0, y)
+				fillPixels(b.Image.Pix[off:off+(x1-x0)*4], col)
+			})
+		})
+		return
+	}
+	iterateTriangles(pts, func(tri [3]BackendVec) {
+		b.fillTriangleNoAA(tri[:], func(y, x0, x1 int) {
+			clip := b.clip.Pix[b.clip.PixOffset(x0, y):]
+			stencil := b.stencil.Pix[b.stencil.PixOffset(x0, y):]
+			pix := b.Image.Pix[b.Image.PixOffset(x0, y):]
+			for i := 0; i < x1-x0; i++ {
+				if (!b.clipIsRect && clip[i] == 0) || stencil[i] > 0 {
+					continue
+				}
+				stencil[i] = 255
+				p := pix[i*4 : i*4+4 : i*4+4]
+				p[0], p[1], p[2], p[3] = col.R, col.G, col.B, col.A
 			}
 		})
 	})
+}
 
-	samples := (msaaLevel + 1) * (msaaLevel + 1)
+// fillPixels sets all pixels of the span to the color, by doubling
+// the filled part with copy
+func fillPixels(pix []byte, col color.RGBA) {
+	if len(pix) < 4 {
+		return
+	}
+	pix[0], pix[1], pix[2], pix[3] = col.R, col.G, col.B, col.A
+	for n := 4; n < len(pix); n *= 2 {
+		copy(pix[n:], pix[:n])
+	}
+}
 
-	for i, px := range msaaPixels {
-		if px.ix < 0 || b.clip.AlphaAt(px.ix, px.iy).A == 0 || b.stencil.AlphaAt(px.ix, px.iy).A > 0 {
+// blendSpan blends the colors returned by fn into the pixels x0 to x1-1
+// of row y that are inside the clipping region and not drawn yet by the
+// current fill
+func (b *SoftwareBackend) blendSpan(y, x0, x1 int, fn func(x int) color.RGBA) {
+	clip := b.clip.Pix[b.clip.PixOffset(x0, y):]
+	stencil := b.stencil.Pix[b.stencil.PixOffset(x0, y):]
+	pix := b.Image.Pix[b.Image.PixOffset(x0, y):]
+	for i := 0; i < x1-x0; i++ {
+		if (!b.clipIsRect && clip[i] == 0) || stencil[i] > 0 {
 			continue
 		}
-		b.stencil.SetAlpha(px.ix, px.iy, color.Alpha{A: 255})
-
-		var mr, mg, mb, ma int
-		for j, px2 := range msaaPixels[i:] {
-			if px2.ix != px.ix || px2.iy != px.iy {
-				continue
-			}
-
-			col := fn(px2.fx, px2.fy)
-			mr += int(col.R)
-			mg += int(col.G)
-			mb += int(col.B)
-			ma += int(col.A)
-
-			msaaPixels[i+j].ix = -1
+		stencil[i] = 255
+		col := fn(x0 + i)
+		if col.A == 0 {
+			continue
 		}
-
-		combined := color.RGBA{
-			R: uint8(mr / samples),
-			G: uint8(mg / samples),
-			B: uint8(mb / samples),
-			A: uint8(ma / samples),
+		p := pix[i*4 : i*4+4 : i*4+4]
+		if col.A < 255 {
+			col = mix(col, color.RGBA{R: p[0], G: p[1], B: p[2], A: p[3]})
 		}
-		b.Image.SetRGBA(px.ix, px.iy, mix(combined, b.Image.RGBAAt(px.ix, px.iy)))
+		p[0], p[1], p[2], p[3] = col.R, col.G, col.B, col.A
 	}
 }
 
-func (b *SoftwareBackend) fillTriangles(pts []BackendVec, fn func(x, y float64) color.RGBA) {
+func (b *SoftwareBackend) fillTrianglesMSAA(pts []BackendVec, msaaLevel int, fn func(x, y float64) color.RGBA) {
+	scratch := getMSAAScratch(b.reserveMSAA)
+	msaaPixels := scratch.buf
+
+	iterateTriangles(pts, func(tri [3]BackendVec) {
+		msaaPixels = b.fillTriangleMSAA(tri[:], msaaLevel, msaaPixels, func(y, x0, x1 int) {
+			fy := float64(y)
+			b.blendSpan(y, x0, x1, func(x int) color.RGBA {
+				return fn(float64(x), fy)
+			})
+		})
+	})
+
+	b.resolveMSAA(msaaPixels, msaaLevel, func(px *msaaPixel) color.RGBA {
+		return fn(px.fx, px.fy)
+	})
+	scratch.release(msaaPixels)
+}
+
+func (b *SoftwareBackend) fillTriangles(pts []BackendVec, f *filler) {
 	b.clearStencil()
 
 	if b.MSAA > 0 {
-		b.fillTrianglesMSAA(pts, b.MSAA, fn)
+		b.fillTrianglesMSAA(pts, b.MSAA, f.at)
+	} else if f.solid() {
+		b.fillTrianglesSolid(pts, f.style.Color)
 	} else {
-		b.fillTrianglesNoAA(pts, fn)
+		b.fillTrianglesNoAA(pts, f.at)
+	}
+
+	if b.coverageFn != nil {
+		b.coverageFn(b.stencil)
 	}
 }
 
+// SetCoverageCallback sets a function that is called after every Fill
+// with the coverage mask of the fill, which is 255 for every pixel that
+// was drawn to and 0 everywhere else. Pixels outside of the clipping
+// region are not covered. The mask is only valid during the call and
+// must not be modified. This allows effects such as outlining everything
+// that was drawn without rendering the shapes again. A nil function
+// removes the callback
+func (b *SoftwareBackend) SetCoverageCallback(fn func(coverage *image.Alpha)) {
+	b.coverageFn = fn
+}
+
+// ClipMask returns a copy of the current clipping region, which is 255
+// for pixels inside of the region and 0 outside
+func (b *SoftwareBackend) ClipMask() *image.Alpha {
+	mask := image.NewAlpha(b.clip.Rect)
+	copy(mask.Pix, b.clip.Pix)
+	return mask
+}
+
 type SoftwareImage struct {
 	mips    []image.Image
+	quality MipmapQuality
 	deleted bool
 }
 
 func (b *SoftwareBackend) LoadImage(img image.Image) (BackendImage, error) {
-	bimg := &SoftwareImage{mips: make([]image.Image, 1, 10)}
+	return b.LoadImageMipmap(img, MipmapBox)
+}
+
+// LoadImageMipmap loads the image and creates its mipmaps with the
+// given filter
+func (b *SoftwareBackend) LoadImageMipmap(img image.Image, quality MipmapQuality) (BackendImage, error) {
+	bimg := &SoftwareImage{mips: make([]image.Image, 1, 10), quality: quality}
 	bimg.Replace(img)
 	return bimg, nil
 }
 
-func halveImage(img image.Image) (*image.RGBA, int, int) {
-	bounds := img.Bounds()
-	w, h := bounds.Dx(), bounds.Dy()
-	w = w / 2
-	h = h / 2
-	rimg := image.NewRGBA(image.Rect(0, 0, w, h))
-	for y := 0; y < h; y++ {
-		sy := y * 2
-		for x := 0; x < w; x++ {
-			sx := x * 2
-			r1, g1, b1, a1 := img.At(sx, sy).RGBA()
-			r2, g2, b2, a2 := img.At(sx+1, sy).RGBA()
-			r3, g3, b3, a3 := img.At(sx, sy+1).RGBA()
-			r4, g4, b4, a4 := img.At(sx+1, sy+1).RGBA()
-			mixr := uint8((int(r1) + int(r2) + int(r3) + int(r4)) / 1024)
-			mixg := uint8((int(g1) + int(g2) + int(g3) + int(g4)) / 1024)
-			mixb := uint8((int(b1) + int(b2) + int(b3) + int(b4)) / 1024)
-			mixa := uint8((int(a1) + int(a2) + int(a3) + int(a4)) / 1024)
-			rimg.Set(x, y, color.RGBA{R: mixr, G: mixg, B: mixb, A: mixa})
-		}
-	}
-	return rimg, w, h
+func (b *SoftwareBackend) DrawImage(dimg BackendImage, sx, sy, sw, sh float64, pts [4]BackendVec, alpha float64) {
+	b.drawImage(dimg, sx, sy, sw, sh, pts, alpha, imageColor{})
 }
 
-func (b *SoftwareBackend) DrawImage(dimg BackendImage, sx, sy, sw, sh float64, pts [4]BackendVec, alpha float64) {
+// DrawImageTinted draws the image like DrawImage, with the color of
+// every sampled pixel multiplied by the tint color
+func (b *SoftwareBackend) DrawImageTinted(dimg BackendImage, sx, sy, sw, sh float64, pts [4]BackendVec, alpha float64, tint color.RGBA) {
+	b.drawImage(dimg, sx, sy, sw, sh, pts, alpha, imageColor{tint: tint, tinted: true})
+}
+
+func (b *SoftwareBackend) drawImage(dimg BackendImage, sx, sy, sw, sh float64, pts [4]BackendVec, alpha float64, ic imageColor) {
+	dimg, sx, sy = subImageSource(dimg, sx, sy)
 	simg := dimg.(*SoftwareImage)
 	if simg.deleted {
 		return
 	}
+	b.touchPts(pts[:])
 
 	bounds := simg.mips[0].Bounds()
 	w, h := bounds.Dx(), bounds.Dy()
@@ -915,19 +1271,21 @@ func (b *SoftwareBackend) DrawImage(dimg BackendImage, sx, sy, sw, sh float64, p
 	b.fillQuad(pts, func(x, y, tx, ty float64) color.RGBA {
 		imgx := sx + sw*tx
 		imgy := sy + sh*ty
-		imgxf := math.Floor(imgx)
-		imgyf := math.Floor(imgy)
-		return toRGBA(mip.At(int(imgxf), int(imgyf)))
-
-		// rx := imgx - imgxf
-		// ry := imgy - imgyf
-		// ca := mip.At(int(imgxf), int(imgyf))
-		// cb := mip.At(int(imgxf+1), int(imgyf))
-		// cc := mip.At(int(imgxf), int(imgyf+1))
-		// cd := mip.At(int(imgxf+1), int(imgyf+1))
-		// ctop := lerp(ca, cb, rx)
-		// cbtm := lerp(cc, cd, rx)
-		// b.Image.Set(x, y, lerp(ctop, cbtm, ry))
+		var col color.RGBA
+		if b.BilinearFilter {
+			col = bilinearAt(mip, imgx, imgy)
+		} else {
+			col = rgbaAt(mip, int(math.Floor(imgx)), int(math.Floor(imgy)))
+		}
+		if ic.matrix != nil {
+			col = ic.matrix.Apply(col)
+		} else if ic.tinted {
+			col = tintColor(col, ic.tint)
+		}
+		if alpha < 1 {
+			col.A = uint8(math.Round(float64(col.A) * alpha))
+		}
+		return col
 	})
 }
 
@@ -944,19 +1302,29 @@ func (img *SoftwareImage) Size() (w, h int) {
 	return b.Dx(), b.Dy()
 }
 
+func (img *SoftwareImage) SubImage(rect image.Rectangle) BackendImage {
+	return NewBackendSubImage(img, rect)
+}
+
 func (img *SoftwareImage) Delete() {
 	img.deleted = true
 }
 
 func (img *SoftwareImage) Replace(src image.Image) error {
+	if p, ok := src.(*image.Paletted); ok {
+		src = newPalettedImage(p)
+	}
 	img.mips = img.mips[:1]
 	img.mips[0] = src
 
 	bounds := src.Bounds()
-	w, h := bounds.Dx(), bounds.Dy()
-	for w > 1 && h > 1 {
-		src, w, h = halveImage(src)
-		img.mips = append(img.mips, src)
+	if bounds.Dx() <= 1 && bounds.Dy() <= 1 {
+		return nil
+	}
+	li := newLinearImage(src)
+	for li.w > 1 || li.h > 1 {
+		li = li.halve(img.quality)
+		img.mips = append(img.mips, li.rgba())
 	}
 
 	return nil
@@ -976,160 +1344,564 @@ func (ip *SoftwareImagePattern) Delete()                              {}
 func (ip *SoftwareImagePattern) Replace(data BackendImagePatternData) { ip.data = data }
 
 func (b *SoftwareBackend) Clear(pts [4]BackendVec) {
-	iterateTriangles(pts[:], func(tri []BackendVec) {
-		b.fillTriangleNoAA(tri, func(x, y int) {
-			if b.clip.AlphaAt(x, y).A == 0 {
-				return
+	b.touchPts(pts[:])
+	iterateTriangles(pts[:], func(tri [3]BackendVec) {
+		b.fillTriangleNoAA(tri[:], func(y, x0, x1 int) {
+			clip := b.clip.Pix[b.clip.PixOffset(x0, y):]
+			pix := b.Image.Pix[b.Image.PixOffset(x0, y):]
+			for i := 0; i < x1-x0; i++ {
+				if b.clipIsRect || clip[i] != 0 {
+					p := pix[i*4 : i*4+4 : i*4+4]
+					p[0], p[1], p[2], p[3] = 0, 0, 0, 0
+				}
 			}
-			b.Image.SetRGBA(x, y, color.RGBA{})
 		})
 	})
 }
 
 func (b *SoftwareBackend) Fill(style *BackendFillStyle, pts []BackendVec, tf BackendMat, canOverlap bool) {
-	ffn := fillFunc(style)
+	f := newFiller(style, b.BilinearFilter, b.DitherSeed)
 
-	var triBuf [500]BackendVec
 	if tf != BackendMatIdentity {
 		ptsOld := pts
-		if len(pts) < len(triBuf) {
-			pts = triBuf[:len(pts)]
-		} else {
-			pts = make([]BackendVec, len(pts))
+		n := len(pts)
+		if n < b.reserveVerts {
+			n = b.reserveVerts
 		}
+		scratch := getVecScratch(n)
+		defer scratch.release(scratch.buf)
+		pts = scratch.buf[:len(pts)]
 		for i, pt := range ptsOld {
 			pts[i] = pt.MulMat(tf)
 		}
 	}
 
 	if style.Blur > 0 {
+		b.touch(b.Image.Rect)
 		b.activateBlurTarget()
-		b.fillTriangles(pts, ffn)
+		b.fillTriangles(pts, &f)
 		b.drawBlurred(style.Blur)
 	} else {
-		b.fillTriangles(pts, ffn)
+		b.touchPts(pts)
+		b.fillTriangles(pts, &f)
 	}
 }
 
 func (b *SoftwareBackend) FillImageMask(style *BackendFillStyle, mask *image.Alpha, pts [4]BackendVec) {
-	ffn := fillFunc(style)
+	b.touchPts(pts[:])
+	f := newFiller(style, b.BilinearFilter, b.DitherSeed)
 
-	mw := float64(mask.Bounds().Dx())
-	mh := float64(mask.Bounds().Dy())
+	mx, my := mask.Rect.Min.X, mask.Rect.Min.Y
+	mw := float64(mask.Rect.Dx())
+	mh := float64(mask.Rect.Dy())
 	b.fillQuad(pts, func(x, y, sx2, sy2 float64) color.RGBA {
-		sxi := int(mw * sx2)
-		syi := int(mh * sy2)
+		sxi := mx + int(mw*sx2)
+		syi := my + int(mh*sy2)
 		a := mask.AlphaAt(sxi, syi)
 		if a.A == 0 {
 			return color.RGBA{}
 		}
-		col := ffn(x, y)
+		col := f.at(x, y)
 		return alphaColor(col, a)
 	})
 }
 
-func fillFunc(style *BackendFillStyle) func(x, y float64) color.RGBA {
+func (b *SoftwareBackend) FillTrianglesVertexColor(pts []BackendVec, colors []color.RGBA) {
+	b.touchPts(pts)
+	b.clearStencil()
+
+	for i := 3; i <= len(pts) && i <= len(colors); i += 3 {
+		tri := pts[i-3 : i]
+		ffn := vertexColorFunc(tri, colors[i-3:i])
+		if b.MSAA > 0 {
+			b.fillTrianglesMSAA(tri, b.MSAA, ffn)
+		} else {
+			b.fillTrianglesNoAA(tri, ffn)
+		}
+	}
+}
+
+func vertexColorFunc(tri []BackendVec, cols []color.RGBA) func(x, y float64) color.RGBA {
+	a, b, c := tri[0], tri[1], tri[2]
+	det := (b[1]-c[1])*(a[0]-c[0]) + (c[0]-b[0])*(a[1]-c[1])
+	if det == 0 {
+		return func(x, y float64) color.RGBA { return cols[0] }
+	}
+	return func(x, y float64) color.RGBA {
+		w0 := ((b[1]-c[1])*(x-c[0]) + (c[0]-b[0])*(y-c[1])) / det
+		w1 := ((c[1]-a[1])*(x-c[0]) + (a[0]-c[0])*(y-c[1])) / det
+		w0 = math.Max(0, math.Min(1, w0))
+		w1 = math.Max(0, math.Min(1-w0, w1))
+		w2 := 1 - w0 - w1
+		return color.RGBA{
+			R: uint8(math.Round(float64(cols[0].R)*w0 + float64(cols[1].R)*w1 + float64(cols[2].R)*w2)),
+			G: uint8(math.Round(float64(cols[0].G)*w0 + float64(cols[1].G)*w1 + float64(cols[2].G)*w2)),
+			B: uint8(math.Round(float64(cols[0].B)*w0 + float64(cols[1].B)*w1 + float64(cols[2].B)*w2)),
+			A: uint8(math.Round(float64(cols[0].A)*w0 + float64(cols[1].A)*w1 + float64(cols[2].A)*w2)),
+		}
+	}
+}
+
+// filler computes the colors of a fill style. It is used instead of a
+// closure so that it doesn't have to be allocated on the heap
+type filler struct {
+	style *BackendFillStyle
+
+	lg       *SoftwareLinearGradient
+	rg       *SoftwareRadialGradient
+	ip       *SoftwareImagePattern
+	from, to BackendVec
+	dir      BackendVec
+	dirlen   float64
+	mip      image.Image
+	w, h     int
+	fw, fh   float64
+	rx, ry   bool
+	// fit is set for the round and space repeat modes, which map the
+	// coordinates with ax and ay before sampling
+	fit    bool
+	ax, ay patternAxis
+
+	// mw/mh is the size of the mip level that a pattern is sampled
+	// from and msx/msy the scale from the image size to it
+	mw, mh   int
+	msx, msy float64
+	bilinear bool
+	// seed is the seed of the noise dithering
+	seed int64
+}
+
+func newFiller(style *BackendFillStyle, bilinear bool, seed int64) filler {
+	f := filler{style: style, bilinear: bilinear, seed: seed}
 	if lg := style.LinearGradient; lg != nil {
-		lg := lg.(*SoftwareLinearGradient)
-		from := BackendVec{style.Gradient.X0, style.Gradient.Y0}
+		f.lg = lg.(*SoftwareLinearGradient)
+		f.from = BackendVec{style.Gradient.X0, style.Gradient.Y0}
 		dir := BackendVec{style.Gradient.X1 - style.Gradient.X0, style.Gradient.Y1 - style.Gradient.Y0}
-		dirlen := math.Sqrt(dir[0]*dir[0] + dir[1]*dir[1])
-		dir[0] /= dirlen
-		dir[1] /= dirlen
-		return func(x, y float64) color.RGBA {
-			pos := BackendVec{x - from[0], y - from[1]}
-			r := (pos[0]*dir[0] + pos[1]*dir[1]) / dirlen
-			return lg.data.ColorAt(r)
-		}
+		f.dirlen = math.Sqrt(dir[0]*dir[0] + dir[1]*dir[1])
+		dir[0] /= f.dirlen
+		dir[1] /= f.dirlen
+		f.dir = dir
 	} else if rg := style.RadialGradient; rg != nil {
-		rg := rg.(*SoftwareRadialGradient)
-		from := BackendVec{style.Gradient.X0, style.Gradient.Y0}
-		to := BackendVec{style.Gradient.X1, style.Gradient.Y1}
-		radFrom := style.Gradient.RadFrom
-		radTo := style.Gradient.RadTo
-		return func(x, y float64) color.RGBA {
-			pos := BackendVec{x, y}
-			oa := 0.5 * math.Sqrt(
-				math.Pow(-2.0*from[0]*from[0]+2.0*from[0]*to[0]+2.0*from[0]*pos[0]-2.0*to[0]*pos[0]-2.0*from[1]*from[1]+2.0*from[1]*to[1]+2.0*from[1]*pos[1]-2.0*to[1]*pos[1]+2.0*radFrom*radFrom-2.0*radFrom*radTo, 2.0)-
-					4.0*(from[0]*from[0]-2.0*from[0]*pos[0]+pos[0]*pos[0]+from[1]*from[1]-2.0*from[1]*pos[1]+pos[1]*pos[1]-radFrom*radFrom)*
-						(from[0]*from[0]-2.0*from[0]*to[0]+to[0]*to[0]+from[1]*from[1]-2.0*from[1]*to[1]+to[1]*to[1]-radFrom*radFrom+2.0*radFrom*radTo-radTo*radTo))
-			ob := (from[0]*from[0] - from[0]*to[0] - from[0]*pos[0] + to[0]*pos[0] + from[1]*from[1] - from[1]*to[1] - from[1]*pos[1] + to[1]*pos[1] - radFrom*radFrom + radFrom*radTo)
-			oc := (from[0]*from[0] - 2.0*from[0]*to[0] + to[0]*to[0] + from[1]*from[1] - 2.0*from[1]*to[1] + to[1]*to[1] - radFrom*radFrom + 2.0*radFrom*radTo - radTo*radTo)
-			o1 := (-oa + ob) / oc
-			o2 := (oa + ob) / oc
-			if math.IsNaN(o1) && math.IsNaN(o2) {
-				return color.RGBA{}
-			}
-			o := math.Max(o1, o2)
-			return rg.data.ColorAt(o)
-		}
+		f.rg = rg.(*SoftwareRadialGradient)
+		f.from = BackendVec{style.Gradient.X0, style.Gradient.Y0}
+		f.to = BackendVec{style.Gradient.X1, style.Gradient.Y1}
 	} else if ip := style.ImagePattern; ip != nil {
-		ip := ip.(*SoftwareImagePattern)
-		img := ip.data.Image.(*SoftwareImage)
-		mip := img.mips[0] // todo select the right mip size
-		w, h := img.Size()
-		fw, fh := float64(w), float64(h)
-		rx := ip.data.Repeat == BackendRepeat || ip.data.Repeat == BackendRepeatX
-		ry := ip.data.Repeat == BackendRepeat || ip.data.Repeat == BackendRepeatY
-		return func(x, y float64) color.RGBA {
-			pos := BackendVec{x, y}
-			tfptx := pos[0]*ip.data.Transform[0] + pos[1]*ip.data.Transform[1] + ip.data.Transform[2]
-			tfpty := pos[0]*ip.data.Transform[3] + pos[1]*ip.data.Transform[4] + ip.data.Transform[5]
-
-			if !rx && (tfptx < 0 || tfptx >= fw) {
-				return color.RGBA{}
-			}
-			if !ry && (tfpty < 0 || tfpty >= fh) {
+		f.ip = ip.(*SoftwareImagePattern)
+		img := f.ip.data.Image.(*SoftwareImage)
+		f.w, f.h = img.Size()
+		f.fw, f.fh = float64(f.w), float64(f.h)
+		f.mip, f.mw, f.mh = patternMip(img, &f.ip.data.Transform)
+		f.msx, f.msy = float64(f.mw)/f.fw, float64(f.mh)/f.fh
+		f.rx = f.ip.data.Repeat == BackendRepeat || f.ip.data.Repeat == BackendRepeatX
+		f.ry = f.ip.data.Repeat == BackendRepeat || f.ip.data.Repeat == BackendRepeatY
+		if rep := f.ip.data.Repeat; rep == BackendRepeatRound || rep == BackendRepeatSpace {
+			area := &f.ip.data.Area
+			f.fit, f.rx, f.ry = true, true, true
+			f.ax = newPatternAxis(rep, area[0], area[2], f.fw)
+			f.ay = newPatternAxis(rep, area[1], area[3], f.fh)
+		}
+	}
+	return f
+}
+
+// patternAxis maps pattern coordinates to image coordinates on one axis
+// for the round and space repeat modes. The tiles start at origin and
+// repeat every period, scaled by scale, with the rest of the period
+// being a gap
+type patternAxis struct {
+	origin, period, scale, size float64
+	// single is set if the image is not repeated because less than
+	// two tiles fit into the area with the space mode
+	single bool
+}
+
+func newPatternAxis(repeat BackendImagePatternRepeat, origin, area, size float64) patternAxis {
+	a := patternAxis{origin: origin, period: size, scale: 1, size: size}
+	if area <= 0 {
+		return a
+	}
+	if repeat == BackendRepeatRound {
+		n := math.Max(1, math.Round(area/size))
+		a.period = area / n
+		a.scale = size / a.period
+		return a
+	}
+	n := math.Floor(area / size)
+	if n < 2 {
+		a.single = true
+	} else {
+		a.period = size + (area-n*size)/(n-1)
+	}
+	return a
+}
+
+// apply returns the image coordinate, or false if v is in a gap
+func (a *patternAxis) apply(v float64) (float64, bool) {
+	v -= a.origin
+	if a.single {
+		return v, v >= 0 && v < a.size
+	}
+	v -= math.Floor(v/a.period) * a.period
+	v *= a.scale
+	return v, v < a.size
+}
+
+// solid returns true if the style is an opaque color
+func (f *filler) solid() bool {
+	return f.lg == nil && f.rg == nil && f.ip == nil && f.style.Color.A == 255
+}
+
+// bayer8 is the 8x8 Bayer matrix for ordered dithering
+var bayer8 = [8][8]uint8{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// ditherColor rounds a gradient color to 8 bits with a threshold that
+// depends on the pixel, so that on average the pixels have the exact
+// color and large gradients show no bands. The stop colors themselves
+// are kept exactly. The seed selects the noise of DitherNoise
+func ditherColor(c [4]float64, mode BackendDither, seed int64, x, y float64) color.RGBA {
+	px, py := int(math.Floor(x)), int(math.Floor(y))
+	var t float64
+	if mode == BackendDitherOrdered {
+		t = (float64(bayer8[py&7][px&7]) + 0.5) / 64
+	} else {
+		t = (noiseHash(seed, 0, px, py) + 1) / 2
+	}
+	var v [4]uint8
+	for i, ch := range c {
+		ch = math.Floor(ch + t)
+		if ch > 255 {
+			ch = 255
+		} else if ch < 0 {
+			ch = 0
+		}
+		v[i] = uint8(ch)
+	}
+	return color.RGBA{R: v[0], G: v[1], B: v[2], A: v[3]}
+}
+
+func (f *filler) at(x, y float64) color.RGBA {
+	if f.lg != nil {
+		from, dir := f.from, f.dir
+		pos := BackendVec{x - from[0], y - from[1]}
+		r := (pos[0]*dir[0] + pos[1]*dir[1]) / f.dirlen
+		if f.style.Dither != BackendNoDither {
+			return ditherColor(f.lg.data.colorAt(r), f.style.Dither, f.seed, x, y)
+		}
+		return f.lg.data.ColorAt(r)
+	} else if f.rg != nil {
+		from, to := f.from, f.to
+		radFrom := f.style.Gradient.RadFrom
+		radTo := f.style.Gradient.RadTo
+		pos := BackendVec{x, y}
+		oa := 0.5 * math.Sqrt(
+			math.Pow(-2.0*from[0]*from[0]+2.0*from[0]*to[0]+2.0*from[0]*pos[0]-2.0*to[0]*pos[0]-2.0*from[1]*from[1]+2.0*from[1]*to[1]+2.0*from[1]*pos[1]-2.0*to[1]*pos[1]+2.0*radFrom*radFrom-2.0*radFrom*radTo, 2.0)-
+				4.0*(from[0]*from[0]-2.0*from[0]*pos[0]+pos[0]*pos[0]+from[1]*from[1]-2.0*from[1]*pos[1]+pos[1]*pos[1]-radFrom*radFrom)*
+					(from[0]*from[0]-2.0*from[0]*to[0]+to[0]*to[0]+from[1]*from[1]-2.0*from[1]*to[1]+to[1]*to[1]-radFrom*radFrom+2.0*radFrom*radTo-radTo*radTo))
+		ob := (from[0]*from[0] - from[0]*to[0] - from[0]*pos[0] + to[0]*pos[0] + from[1]*from[1] - from[1]*to[1] - from[1]*pos[1] + to[1]*pos[1] - radFrom*radFrom + radFrom*radTo)
+		oc := (from[0]*from[0] - 2.0*from[0]*to[0] + to[0]*to[0] + from[1]*from[1] - 2.0*from[1]*to[1] + to[1]*to[1] - radFrom*radFrom + 2.0*radFrom*radTo - radTo*radTo)
+		o1 := (-oa + ob) / oc
+		o2 := (oa + ob) / oc
+		if math.IsNaN(o1) && math.IsNaN(o2) {
+			return color.RGBA{}
+		}
+		o := math.Max(o1, o2)
+		if f.style.Dither != BackendNoDither {
+			return ditherColor(f.rg.data.colorAt(o), f.style.Dither, f.seed, x, y)
+		}
+		return f.rg.data.ColorAt(o)
+	} else if f.ip != nil {
+		tf := &f.ip.data.Transform
+		tfptx := x*tf[0] + y*tf[1] + tf[2]
+		tfpty := x*tf[3] + y*tf[4] + tf[5]
+
+		if f.fit {
+			var okx, oky bool
+			tfptx, okx = f.ax.apply(tfptx)
+			tfpty, oky = f.ay.apply(tfpty)
+			if !okx || !oky {
 				return color.RGBA{}
 			}
+		}
+		if !f.rx && (tfptx < 0 || tfptx >= f.fw) {
+			return color.RGBA{}
+		}
+		if !f.ry && (tfpty < 0 || tfpty >= f.fh) {
+			return color.RGBA{}
+		}
 
-			mx := int(math.Floor(tfptx)) % w
-			if mx < 0 {
-				mx += w
-			}
-			my := int(math.Floor(tfpty)) % h
-			if my < 0 {
-				my += h
-			}
+		tfptx *= f.msx
+		tfpty *= f.msy
+		if f.bilinear {
+			return f.patternBilinear(tfptx, tfpty)
+		}
+		return f.patternTexel(int(math.Floor(tfptx)), int(math.Floor(tfpty)))
+	}
+	return f.style.Color
+}
 
-			return toRGBA(mip.At(mx, my))
+// patternTexel returns the pixel of the pattern mip level, wrapping
+// around on the repeated axes and clamping to the edge otherwise
+func (f *filler) patternTexel(mx, my int) color.RGBA {
+	if f.rx {
+		mx %= f.mw
+		if mx < 0 {
+			mx += f.mw
 		}
+	} else if mx < 0 {
+		mx = 0
+	} else if mx >= f.mw {
+		mx = f.mw - 1
 	}
-	return func(x, y float64) color.RGBA {
-		return style.Color
+	if f.ry {
+		my %= f.mh
+		if my < 0 {
+			my += f.mh
+		}
+	} else if my < 0 {
+		my = 0
+	} else if my >= f.mh {
+		my = f.mh - 1
+	}
+	return rgbaAt(f.mip, mx, my)
+}
+
+// patternBilinear samples the pattern mip level with bilinear filtering
+func (f *filler) patternBilinear(x, y float64) color.RGBA {
+	x -= 0.5
+	y -= 0.5
+	xf, yf := math.Floor(x), math.Floor(y)
+	rx, ry := x-xf, y-yf
+	x0, y0 := int(xf), int(yf)
+	return bilinearMix(f.patternTexel(x0, y0), f.patternTexel(x0+1, y0),
+		f.patternTexel(x0, y0+1), f.patternTexel(x0+1, y0+1), rx, ry)
+}
+
+// patternMip selects the mip level of the image whose size is closest
+// to the size that the pattern transform scales the image to, the same
+// way that DrawImage selects it
+func patternMip(img *SoftwareImage, tf *[9]float64) (image.Image, int, int) {
+	bounds := img.mips[0].Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	// the transform maps canvas pixels to image pixels, so the
+	// determinant is the number of image pixels per canvas pixel
+	det := math.Abs(tf[0]*tf[4] - tf[1]*tf[3])
+	if det <= 1 || len(img.mips) == 1 {
+		return img.mips[0], w, h
+	}
+	area := float64(w*h) / det
+	mip, mipW, mipH := img.mips[0], w, h
+	closest := math.MaxFloat64
+	for _, m := range img.mips {
+		bounds := m.Bounds()
+		mw, mh := bounds.Dx(), bounds.Dy()
+		dist := math.Abs(float64(mw*mh) - area)
+		if dist < closest {
+			closest = dist
+			mip, mipW, mipH = m, mw, mh
+		}
+	}
+	return mip, mipW, mipH
+}
+
+// rgbaAt returns the color of the image at x/y, without converting it
+// through the color.Color interface if it is an RGBA image
+func rgbaAt(img image.Image, x, y int) color.RGBA {
+	// the standard image types are read directly, calling At boxes
+	// every color in an interface. The results are the same as
+	// converting the color returned by At
+	switch img := img.(type) {
+	case *image.RGBA:
+		return img.RGBAAt(x, y)
+	case *image.NRGBA:
+		if !(image.Point{x, y}.In(img.Rect)) {
+			return color.RGBA{}
+		}
+		p := img.Pix[img.PixOffset(x, y):]
+		return premultiplyNRGBA(p[0], p[1], p[2], p[3])
+	case *palettedImage:
+		return img.RGBAAt(x, y)
+	case *image.Paletted:
+		return toRGBA(img.At(x, y))
+	case *image.Gray:
+		g := img.GrayAt(x, y).Y
+		return color.RGBA{g, g, g, 255}
+	case *image.Alpha:
+		a := img.AlphaAt(x, y).A
+		return color.RGBA{a, a, a, a}
+	case *yuvImage:
+		return img.RGBAAt(x, y)
+	case *Snapshot:
+		return img.RGBAAt(x, y)
 	}
+	return toRGBA(img.At(x, y))
+}
+
+// premultiplyNRGBA returns the same color as converting color.NRGBA with
+// toRGBA
+func premultiplyNRGBA(r, g, b, a uint8) color.RGBA {
+	if a == 255 {
+		return color.RGBA{r, g, b, a}
+	}
+	ch := func(v uint8) uint8 {
+		c := uint32(v)
+		c |= c << 8
+		c *= uint32(a)
+		c /= 0xff
+		return uint8(c >> 8)
+	}
+	return color.RGBA{ch(r), ch(g), ch(b), a}
+}
+
+// palettedImage is a paletted image with the palette converted to RGBA
+// colors once, instead of for every sampled pixel
+type palettedImage struct {
+	*image.Paletted
+	palette []color.RGBA
 }
 
+func newPalettedImage(p *image.Paletted) *palettedImage {
+	pi := &palettedImage{Paletted: p, palette: make([]color.RGBA, len(p.Palette))}
+	for i, c := range p.Palette {
+		pi.palette[i] = toRGBA(c)
+	}
+	return pi
+}
+
+// RGBAAt returns the color of a pixel like At
+func (pi *palettedImage) RGBAAt(x, y int) color.RGBA {
+	if len(pi.palette) == 0 {
+		return color.RGBA{}
+	}
+	if !(image.Point{x, y}.In(pi.Rect)) {
+		return pi.palette[0]
+	}
+	i := int(pi.Pix[pi.PixOffset(x, y)])
+	if i >= len(pi.palette) {
+		return color.RGBA{}
+	}
+	return pi.palette[i]
+}
+
+// clearStencil clears the part of the stencil that was drawn to
+// since the last clear, which is usually much smaller than the
+// whole canvas
 func (b *SoftwareBackend) clearStencil() {
-	p := b.stencil.Pix
-	for i := range p {
-		p[i] = 0
+	r := b.stencilDirty
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		off := b.stencil.PixOffset(r.Min.X, y)
+		p := b.stencil.Pix[off : off+r.Dx()]
+		for i := range p {
+			p[i] = 0
+		}
+	}
+	b.stencilDirty = image.Rectangle{}
+}
+
+// markStencil adds the bounds of the points within the rows minY to
+// maxY to the part of the stencil that has to be cleared
+func (b *SoftwareBackend) markStencil(pts []BackendVec, minY, maxY int) {
+	minX, maxX := pts[0][0], pts[0][0]
+	for _, pt := range pts[1:] {
+		minX = math.Min(minX, pt[0])
+		maxX = math.Max(maxX, pt[0])
+	}
+	r := image.Rect(int(math.Floor(minX)), minY, int(math.Ceil(maxX))+1, maxY+1)
+	r = r.Intersect(b.clipRect)
+	if r.Empty() {
+		return
+	}
+	if b.stencilDirty.Empty() {
+		b.stencilDirty = r
+	} else {
+		b.stencilDirty = b.stencilDirty.Union(r)
 	}
 }
 
 func (b *SoftwareBackend) ClearClip() {
 	p := b.clip.Pix
-	for i := range p {
-		p[i] = 255
+	r := b.scissorRect()
+	if r == b.clip.Rect {
+		for i := range p {
+			p[i] = 255
+		}
+	} else {
+		for i := range p {
+			p[i] = 0
+		}
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			off := b.clip.PixOffset(r.Min.X, y)
+			row := p[off : off+r.Dx()]
+			for i := range row {
+				row[i] = 255
+			}
+		}
 	}
+	b.clipRect = r
+	b.clipIsRect = true
 }
 
 func (b *SoftwareBackend) Clip(pts []BackendVec) {
 	b.clearStencil()
 
-	iterateTriangles(pts[:], func(tri []BackendVec) {
-		b.fillTriangleNoAA(tri, func(x, y int) {
-			b.stencil.SetAlpha(x, y, color.Alpha{A: 255})
+	iterateTriangles(pts, func(tri [3]BackendVec) {
+		b.fillTriangleNoAA(tri[:], func(y, x0, x1 int) {
+			off := b.stencil.PixOffset(x0, y)
+			span := b.stencil.Pix[off : off+x1-x0]
+			for i := range span {
+				span[i] = 255
+			}
 		})
 	})
 
-	p := b.clip.Pix
-	p2 := b.stencil.Pix
-	for i := range p {
-		if p2[i] == 0 {
-			p[i] = 0
+	// everything outside of the previous bounds is already
+	// clipped, so only the pixels inside need to be updated
+	cr := b.clipRect
+	minX, minY, maxX, maxY := cr.Max.X, cr.Max.Y, cr.Min.X, cr.Min.Y
+	count := 0
+	for y := cr.Min.Y; y < cr.Max.Y; y++ {
+		off := b.clip.PixOffset(cr.Min.X, y)
+		p := b.clip.Pix[off : off+cr.Dx()]
+		p2 := b.stencil.Pix[off : off+cr.Dx()]
+		rowMin, rowMax := len(p), -1
+		for i := range p {
+			if p2[i] == 0 {
+				p[i] = 0
+			} else if p[i] != 0 {
+				if i < rowMin {
+					rowMin = i
+				}
+				rowMax = i
+				count++
+			}
+		}
+		if rowMax < 0 {
+			continue
+		}
+		if y < minY {
+			minY = y
+		}
+		maxY = y + 1
+		if cr.Min.X+rowMin < minX {
+			minX = cr.Min.X + rowMin
 		}
+		if cr.Min.X+rowMax+1 > maxX {
+			maxX = cr.Min.X + rowMax + 1
+		}
+	}
+	b.clipRect = image.Rect(minX, minY, maxX, maxY)
+	if count == 0 {
+		b.clipRect = image.Rectangle{}
 	}
+	b.clipIsRect = count == b.clipRect.Dx()*b.clipRect.Dy()
 }
 
 func toRGBA(src color.Color) color.RGBA {
@@ -1142,19 +1914,26 @@ func toRGBA(src color.Color) color.RGBA {
 	}
 }
 
-func mix(src, dest color.Color) color.RGBA {
-	ir1, ig1, ib1, ia1 := src.RGBA()
-	r1 := float64(ir1) / 65535.0
-	g1 := float64(ig1) / 65535.0
-	b1 := float64(ib1) / 65535.0
-	a1 := float64(ia1) / 65535.0
-
-	ir2, ig2, ib2, ia2 := dest.RGBA()
-	r2 := float64(ir2) / 65535.0
-	g2 := float64(ig2) / 65535.0
-	b2 := float64(ib2) / 65535.0
-	a2 := float64(ia2) / 65535.0
+func mix(src, dest color.RGBA) color.RGBA {
+	// the blending below gives exactly these results
+	// for fully opaque and fully transparent colors
+	if src.A == 255 {
+		return src
+	} else if src.A == 0 {
+		return dest
+	}
 
+	// same as converting with RGBA() to 16 bit values, but without
+	// the allocations of calling it through the color.Color interface
+	r1 := float64(uint32(src.R)*0x101) / 65535.0
+	g1 := float64(uint32(src.G)*0x101) / 65535.0
+	b1 := float64(uint32(src.B)*0x101) / 65535.0
+	a1 := float64(uint32(src.A)*0x101) / 65535.0
+
+	r2 := float64(uint32(dest.R)*0x101) / 65535.0
+	g2 := float64(uint32(dest.G)*0x101) / 65535.0
+	b2 := float64(uint32(dest.B)*0x101) / 65535.0
+	a2 := float64(uint32(dest.A)*0x101) / 65535.0
 	r := (r1-r2)*a1 + r2
 	g := (g1-g2)*a1 + g2
 	b := (b1-b2)*a1 + b2
@@ -1168,18 +1947,9 @@ func mix(src, dest color.Color) color.RGBA {
 	}
 }
 
-func alphaColor(col color.Color, alpha color.Alpha) color.RGBA {
-	ir, ig, ib, _ := col.RGBA()
-	a2 := float64(alpha.A) / 255.0
-	r := float64(ir) * a2 / 65535.0
-	g := float64(ig) * a2 / 65535.0
-	b := float64(ib) * a2 / 65535.0
-	return color.RGBA{
-		R: uint8(r * 255.0),
-		G: uint8(g * 255.0),
-		B: uint8(b * 255.0),
-		A: 255,
-	}
+func alphaColor(col color.RGBA, alpha color.Alpha) color.RGBA {
+	col.A = uint8(math.Round(float64(col.A) * float64(alpha.A) / 255.0))
+	return col
 }
 
 func lerp(col1, col2 color.Color, ratio float64) color.RGBA {
@@ -0,0 +1,109 @@
+package canvas
+
+import (
+	"image"
+	"math"
+)
+
+// strokeAlignSamples is the number of mask pixels per canvas pixel in
+// each direction that inner and outer strokes are rendered with, for
+// the anti-aliasing. It has to be a power of two
+const strokeAlignSamples = 4
+
+// closedPath returns whether the path has subpaths with lines and all of
+// them are closed
+func closedPath(path []pathPoint) bool {
+	lines := false
+	for i, p := range path {
+		if i+1 < len(path) && path[i+1].flags&pathMove == 0 {
+			lines = true
+			continue
+		}
+		// p is the last point of a subpath
+		if p.flags&pathMove == 0 && p.flags&pathAttach == 0 {
+			return false
+		}
+	}
+	return lines
+}
+
+// strokeAligned draws the path with an inner or outer stroke alignment.
+// The line is stroked twice as wide and cut to the inside or outside of
+// the filled path in an alpha mask. It returns false if the path has to
+// be stroked centered instead
+func (cv *Canvas) strokeAligned(path *Path2D, tf BackendMat, inv BackendMat, doInv bool) bool {
+	align := cv.state.strokeAlign
+	if (align != Inner && align != Outer) || !closedPath(path.p) {
+		return false
+	}
+
+	lw := cv.state.lineWidth
+	cv.state.lineWidth = lw * 2
+	scratch := getVecScratch(0)
+	tris := cv.strokeTris(path, tf, inv, doInv, scratch.buf)
+	defer scratch.release(tris)
+	cv.state.lineWidth = lw
+
+	// the current path is already in canvas coordinates
+	mat := tf
+	if doInv {
+		mat = BackendMatIdentity
+	}
+	var fill []BackendVec
+	runSubPaths(path.p, true, func(sp []pathPoint) bool {
+		fill = appendSubPathTriangles(fill, mat, sp)
+		return false
+	})
+
+	b := BoundsOf(tris)
+	if math.IsNaN(b.MinX + b.MinY + b.MaxX + b.MaxY) {
+		return false
+	}
+	if cv.culledWithShadow(b) {
+		return true
+	}
+	// only the part of the line that can be seen or cast a visible
+	// shadow is rendered
+	limit := cv.visibleBounds()
+	if cv.state.shadowColor.A != 0 {
+		limit = limit.Grow(math.Abs(cv.state.shadowSpread) + cv.state.shadowBlur*3 + 1 +
+			math.Max(math.Abs(cv.state.shadowOffsetX), math.Abs(cv.state.shadowOffsetY)))
+	}
+	min := BackendVec{math.Floor(math.Max(b.MinX, limit.MinX)), math.Floor(math.Max(b.MinY, limit.MinY))}
+	max := BackendVec{math.Ceil(math.Min(b.MaxX, limit.MaxX)), math.Ceil(math.Min(b.MaxY, limit.MaxY))}
+	if max[0] <= min[0] || max[1] <= min[1] {
+		return true
+	}
+
+	const s = strokeAlignSamples
+	w, h := int(max[0]-min[0]), int(max[1]-min[1])
+	line := image.NewAlpha(image.Rect(0, 0, w*s, h*s))
+	inside := image.NewAlpha(line.Rect)
+	origin := min.Mulf(s)
+	iterateTriangles(tris, func(tri [3]BackendVec) {
+		fillTriangleMask(line, origin, []BackendVec{tri[0].Mulf(s), tri[1].Mulf(s), tri[2].Mulf(s)})
+	})
+	iterateTriangles(fill, func(tri [3]BackendVec) {
+		fillTriangleMask(inside, origin, []BackendVec{tri[0].Mulf(s), tri[1].Mulf(s), tri[2].Mulf(s)})
+	})
+	for i, a := range inside.Pix {
+		if (a != 0) != (align == Inner) {
+			line.Pix[i] = 0
+		}
+	}
+	mask := line
+	for n := 1; n < s; n *= 2 {
+		mask = halveAlpha(mask)
+	}
+
+	fw, fh := float64(w), float64(h)
+	quad := [4]BackendVec{min, {min[0], min[1] + fh}, {min[0] + fw, min[1] + fh}, {min[0] + fw, min[1]}}
+
+	cv.drawShadow(quad[:], mask, true)
+
+	stl := cv.backendFillStyle(&cv.state.stroke, 1)
+	cv.fillImageMask(&stl, mask, quad)
+
+	cv.drawInsetShadow(quad[:], mask)
+	return true
+}
@@ -0,0 +1,143 @@
+package canvas
+
+import (
+	"image"
+)
+
+// BackendSubImage is a rectangular part of a backend image that shares the
+// texture or pixels of its parent, so that the images of a sprite atlas
+// can be used without slicing them into separate images. Backends can
+// return it from SubImage. The canvas draws the parent image with the
+// source coordinates moved into the rectangle
+type BackendSubImage struct {
+	parent BackendImage
+	rect   image.Rectangle
+}
+
+// NewBackendSubImage returns the part of the parent image within rect,
+// clipped to the size of the parent. Sub-images of sub-images refer to
+// the original parent directly
+func NewBackendSubImage(parent BackendImage, rect image.Rectangle) *BackendSubImage {
+	if si, ok := parent.(*BackendSubImage); ok {
+		return NewBackendSubImage(si.parent, rect.Add(si.rect.Min).Intersect(si.rect))
+	}
+	w, h := parent.Size()
+	return &BackendSubImage{parent: parent, rect: rect.Intersect(image.Rect(0, 0, w, h))}
+}
+
+// Parent returns the image that the sub-image is a part of
+func (si *BackendSubImage) Parent() BackendImage { return si.parent }
+
+// Rect returns the rectangle of the sub-image in the parent image
+func (si *BackendSubImage) Rect() image.Rectangle { return si.rect }
+
+func (si *BackendSubImage) Width() int { return si.rect.Dx() }
+
+func (si *BackendSubImage) Height() int { return si.rect.Dy() }
+
+func (si *BackendSubImage) Size() (w, h int) { return si.rect.Dx(), si.rect.Dy() }
+
+// Delete does nothing, the pixels belong to the parent image
+func (si *BackendSubImage) Delete() {}
+
+// Replace returns ErrSubImageReplace, replace the parent image instead
+func (si *BackendSubImage) Replace(src image.Image) error { return ErrSubImageReplace }
+
+// SubImage returns a part of the sub-image. The rectangle is relative to
+// the sub-image
+func (si *BackendSubImage) SubImage(rect image.Rectangle) BackendImage {
+	return NewBackendSubImage(si, rect)
+}
+
+// SubImageBackendImage is implemented by backend images that provide
+// their own handles for parts of the image, for example texture views.
+// For other images the canvas uses a BackendSubImage
+type SubImageBackendImage interface {
+	// SubImage returns a handle to the part of the image within rect
+	// that shares its pixels
+	SubImage(rect image.Rectangle) BackendImage
+}
+
+func backendSubImage(bimg BackendImage, rect image.Rectangle) BackendImage {
+	if si, ok := bimg.(SubImageBackendImage); ok {
+		return si.SubImage(rect)
+	}
+	return NewBackendSubImage(bimg, rect)
+}
+
+// subImageSource returns the image that backends draw for the given image
+// and the source coordinates in it
+func subImageSource(bimg BackendImage, sx, sy float64) (BackendImage, float64, float64) {
+	if si, ok := bimg.(*BackendSubImage); ok {
+		return si.parent, sx + float64(si.rect.Min.X), sy + float64(si.rect.Min.Y)
+	}
+	return bimg, sx, sy
+}
+
+// SubImage returns a handle to the part of the image within rect, for
+// example a single sprite of an atlas. The handle shares the pixels of
+// the image, and drawing it draws the part of the image, with source
+// coordinates relative to the rectangle. It stays valid as long as the
+// image is not deleted or replaced
+func (img *Image) SubImage(rect image.Rectangle) *Image {
+	if img.evicted {
+		img.cv.LoadImage(img)
+	}
+	sub := &Image{
+		cv:       img.cv,
+		img:      backendSubImage(img.img, rect),
+		deleted:  img.deleted,
+		lastUsed: img.lastUsed,
+		mipmap:   img.mipmap,
+	}
+	if sr, ok := img.data.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		r := img.data.Bounds()
+		sub.data = sr.SubImage(rect.Add(r.Min).Intersect(r))
+	}
+	return sub
+}
+
+// DrawNinePatch draws the image stretched to the destination rectangle
+// while keeping the borders unscaled, as is common for buttons and
+// panels. The left, top, right and bottom insets are the sizes of the
+// borders in the image, which are drawn at the same size. The edges
+// are stretched in one direction and the center in both
+func (cv *Canvas) DrawNinePatch(image interface{}, left, top, right, bottom, dx, dy, dw, dh float64) {
+	img := cv.getImage(image)
+	if img == nil {
+		return
+	}
+	w, h := float64(img.Width()), float64(img.Height())
+	left, right = fitInsets(left, right, w)
+	top, bottom = fitInsets(top, bottom, h)
+	sxs := [4]float64{0, left, w - right, w}
+	sys := [4]float64{0, top, h - bottom, h}
+
+	// the borders shrink if the destination is smaller than them
+	left, right = fitInsets(left, right, dw)
+	top, bottom = fitInsets(top, bottom, dh)
+	dxs := [4]float64{dx, dx + left, dx + dw - right, dx + dw}
+	dys := [4]float64{dy, dy + top, dy + dh - bottom, dy + dh}
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 3; x++ {
+			sw, sh := sxs[x+1]-sxs[x], sys[y+1]-sys[y]
+			dw, dh := dxs[x+1]-dxs[x], dys[y+1]-dys[y]
+			if sw <= 0 || sh <= 0 || dw <= 0 || dh <= 0 {
+				continue
+			}
+			cv.DrawImage(img, sxs[x], sys[y], sw, sh, dxs[x], dys[y], dw, dh)
+		}
+	}
+}
+
+// fitInsets scales the two insets down proportionally if together they
+// are larger than size
+func fitInsets(a, b, size float64) (float64, float64) {
+	if a+b > size && a+b > 0 {
+		s := size / (a + b)
+		return a * s, b * s
+	}
+	return a, b
+}
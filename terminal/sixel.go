@@ -0,0 +1,86 @@
+package terminal
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+)
+
+// writeSixel writes the image as sixels, blended with the background and
+// dithered to the web safe palette
+func writeSixel(w *bufio.Writer, img *image.NRGBA, bg color.RGBA) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	opaque := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			opaque.SetRGBA(x, y, blend(img, x, y, bg))
+		}
+	}
+	pal := image.NewPaletted(opaque.Rect, palette.WebSafe)
+	draw.FloydSteinberg.Draw(pal, pal.Rect, opaque, image.Point{})
+
+	// DCS with a pixel aspect ratio of 1:1, and the raster size
+	fmt.Fprintf(w, "\x1bP0;1;0q\"1;1;%d;%d", width, height)
+	for i, c := range palette.WebSafe {
+		r, g, b, _ := c.RGBA()
+		fmt.Fprintf(w, "#%d;2;%d;%d;%d", i, r*100/0xFFFF, g*100/0xFFFF, b*100/0xFFFF)
+	}
+
+	used := make([]bool, len(palette.WebSafe))
+	row := make([]byte, width)
+	for band := 0; band < height; band += 6 {
+		for i := range used {
+			used[i] = false
+		}
+		for y := band; y < band+6 && y < height; y++ {
+			for _, idx := range pal.Pix[pal.PixOffset(0, y):][:width] {
+				used[idx] = true
+			}
+		}
+		first := true
+		for idx, ok := range used {
+			if !ok {
+				continue
+			}
+			for x := range row {
+				var bits byte
+				for dy := 0; dy < 6 && band+dy < height; dy++ {
+					if int(pal.ColorIndexAt(x, band+dy)) == idx {
+						bits |= 1 << uint(dy)
+					}
+				}
+				row[x] = '?' + bits
+			}
+			if !first {
+				// back to the start of the band for the next color
+				w.WriteByte('$')
+			}
+			first = false
+			fmt.Fprintf(w, "#%d", idx)
+			writeSixelRun(w, row)
+		}
+		w.WriteByte('-')
+	}
+	w.WriteString("\x1b\\")
+}
+
+// writeSixelRun writes the sixels of a row with run-length encoding
+func writeSixelRun(w *bufio.Writer, row []byte) {
+	for i := 0; i < len(row); {
+		n := 1
+		for i+n < len(row) && row[i+n] == row[i] {
+			n++
+		}
+		if n > 3 {
+			fmt.Fprintf(w, "!%d%c", n, row[i])
+		} else {
+			for j := 0; j < n; j++ {
+				w.WriteByte(row[i])
+			}
+		}
+		i += n
+	}
+}
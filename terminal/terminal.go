@@ -0,0 +1,162 @@
+// Package terminal shows canvas images in a terminal, with the sixel or
+// kitty graphics protocols where the terminal supports them and with
+// Unicode half-block characters in 24-bit color everywhere else. It is
+// meant for quick visualizations, for example over SSH
+package terminal
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/opentoys/canvas"
+)
+
+// Mode is the way images are sent to the terminal
+type Mode uint8
+
+// Terminal modes
+const (
+	// HalfBlock draws two pixels per character cell with the upper
+	// half block character, the top pixel as the foreground color and
+	// the bottom pixel as the background color. It works in every
+	// terminal with 24-bit color
+	HalfBlock Mode = iota
+	// Sixel sends a sixel image with a 216 color palette and dithering
+	Sixel
+	// Kitty sends the pixels with the kitty graphics protocol, with
+	// transparency and without color loss
+	Kitty
+)
+
+// Options control how images are written
+type Options struct {
+	Mode Mode
+	// Background is the color that transparent pixels are blended with
+	// in the modes without transparency. The zero value is black
+	Background color.RGBA
+}
+
+// Detect returns the best mode for the terminal that the program runs
+// in, from the environment variables that terminals set
+func Detect() Mode {
+	term := os.Getenv("TERM")
+	prog := os.Getenv("TERM_PROGRAM")
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty" || term == "xterm-ghostty" ||
+		prog == "WezTerm" || prog == "ghostty":
+		return Kitty
+	case strings.Contains(term, "sixel") || strings.HasPrefix(term, "foot") || strings.HasPrefix(term, "mlterm") ||
+		strings.HasPrefix(term, "yaft") || strings.HasPrefix(term, "contour"):
+		return Sixel
+	}
+	return HalfBlock
+}
+
+// Write writes the content of the canvas to the terminal
+func Write(w io.Writer, cv *canvas.Canvas, opts Options) error {
+	cw, ch := cv.Size()
+	return write(w, canvas.ImageDataNRGBA(cv.GetImageData(0, 0, cw, ch)), opts)
+}
+
+// WriteImage writes an image to the terminal
+func WriteImage(w io.Writer, img image.Image, opts Options) error {
+	b := img.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(nrgba, nrgba.Rect, img, b.Min, draw.Src)
+	return write(w, nrgba, opts)
+}
+
+func write(w io.Writer, img *image.NRGBA, opts Options) error {
+	bw := bufio.NewWriter(w)
+	switch opts.Mode {
+	case HalfBlock:
+		writeHalfBlock(bw, img, opts.Background)
+	case Sixel:
+		writeSixel(bw, img, opts.Background)
+	case Kitty:
+		if err := writeKitty(bw, img); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unknown terminal mode %d", opts.Mode)
+	}
+	return bw.Flush()
+}
+
+// blend returns the pixel blended with the background
+func blend(img *image.NRGBA, x, y int, bg color.RGBA) color.RGBA {
+	c := img.NRGBAAt(x, y)
+	if c.A == 255 {
+		return color.RGBA{c.R, c.G, c.B, 255}
+	}
+	mix := func(v, b uint8) uint8 {
+		return uint8((int(v)*int(c.A) + int(b)*(255-int(c.A)) + 127) / 255)
+	}
+	return color.RGBA{mix(c.R, bg.R), mix(c.G, bg.G), mix(c.B, bg.B), 255}
+}
+
+func writeHalfBlock(w *bufio.Writer, img *image.NRGBA, bg color.RGBA) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	for y := 0; y < height; y += 2 {
+		// colors are only sent when they change within a line
+		var fg, bgc color.RGBA
+		first := true
+		for x := 0; x < width; x++ {
+			top := blend(img, x, y, bg)
+			bottom := bg
+			if y+1 < height {
+				bottom = blend(img, x, y+1, bg)
+			}
+			if first || top != fg {
+				fmt.Fprintf(w, "\x1b[38;2;%d;%d;%dm", top.R, top.G, top.B)
+			}
+			if first || bottom != bgc {
+				fmt.Fprintf(w, "\x1b[48;2;%d;%d;%dm", bottom.R, bottom.G, bottom.B)
+			}
+			fg, bgc, first = top, bottom, false
+			w.WriteString("▀")
+		}
+		w.WriteString("\x1b[0m\n")
+	}
+}
+
+// kittyChunk is the maximum size of the base64 payload per escape code
+const kittyChunk = 4096
+
+func writeKitty(w *bufio.Writer, img *image.NRGBA) error {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	for y := 0; y < height; y++ {
+		if _, err := zw.Write(img.Pix[img.PixOffset(0, y):][:width*4]); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	payload := base64.StdEncoding.EncodeToString(compressed.Bytes())
+	for i := 0; i == 0 || i < len(payload); i += kittyChunk {
+		end := i + kittyChunk
+		more := 1
+		if end >= len(payload) {
+			end, more = len(payload), 0
+		}
+		if i == 0 {
+			fmt.Fprintf(w, "\x1b_Ga=T,f=32,o=z,s=%d,v=%d,m=%d;%s\x1b\\", width, height, more, payload[i:end])
+		} else {
+			fmt.Fprintf(w, "\x1b_Gm=%d;%s\x1b\\", more, payload[i:end])
+		}
+	}
+	w.WriteString("\n")
+	return nil
+}
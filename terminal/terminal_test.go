@@ -0,0 +1,129 @@
+package terminal_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/opentoys/canvas"
+	"github.com/opentoys/canvas/terminal"
+)
+
+func TestHalfBlock(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 3))
+	img.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+	img.SetNRGBA(0, 1, color.NRGBA{0, 0, 255, 255})
+	img.SetNRGBA(1, 0, color.NRGBA{255, 255, 255, 0})
+
+	var buf bytes.Buffer
+	err := terminal.WriteImage(&buf, img, terminal.Options{Background: color.RGBA{10, 20, 30, 255}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if n := strings.Count(out, "▀"); n != 4 {
+		t.Fatalf("Expected 4 cells for 2x3 pixels, got %d", n)
+	}
+	if strings.Count(out, "\n") != 2 {
+		t.Fatalf("Expected 2 lines, got %q", out)
+	}
+	if !strings.HasPrefix(out, "\x1b[38;2;255;0;0m\x1b[48;2;0;0;255m▀\x1b[38;2;10;20;30m") {
+		t.Fatalf("Unexpected colors in %q", out)
+	}
+}
+
+func TestKitty(t *testing.T) {
+	cv := canvas.New(canvas.NewBackend(100, 80))
+	cv.SetFillStyle("#F008")
+	cv.FillRect(10, 10, 50, 40)
+
+	var buf bytes.Buffer
+	if err := terminal.Write(&buf, cv, terminal.Options{Mode: terminal.Kitty}); err != nil {
+		t.Fatal(err)
+	}
+	chunks := regexp.MustCompile("\x1b_G([^;]*);([^\x1b]*)\x1b\\\\").FindAllStringSubmatch(buf.String(), -1)
+	if len(chunks) == 0 || !strings.Contains(chunks[0][1], "f=32,o=z,s=100,v=80") {
+		t.Fatalf("Unexpected kitty output %q", buf.String())
+	}
+	var payload string
+	for i, c := range chunks {
+		if more := strings.Contains(c[1], "m=1"); more != (i < len(chunks)-1) {
+			t.Fatalf("Chunk %d of %d has the wrong continuation flag %q", i, len(chunks), c[1])
+		}
+		payload += c[2]
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pix, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pix, canvas.ImageDataNRGBA(cv.GetImageData(0, 0, 100, 80)).Pix) {
+		t.Fatal("Expected the straight alpha pixels of the canvas")
+	}
+}
+
+func TestSixel(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 6))
+	for i := 0; i < len(img.Pix); i += 4 {
+		copy(img.Pix[i:], []byte{255, 0, 0, 255})
+	}
+	var buf bytes.Buffer
+	if err := terminal.WriteImage(&buf, img, terminal.Options{Mode: terminal.Sixel}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	red := color.Palette(palette.WebSafe).Index(color.RGBA{255, 0, 0, 255})
+	if !strings.HasPrefix(out, "\x1bP0;1;0q\"1;1;10;6") || !strings.HasSuffix(out, "\x1b\\") {
+		t.Fatalf("Unexpected sixel framing %q", out)
+	}
+	if !strings.Contains(out, fmt.Sprintf("#%d;2;100;0;0", red)) || !strings.Contains(out, fmt.Sprintf("#%d!10~-", red)) {
+		t.Fatalf("Expected a single run of red sixels in %q", out[len(out)-40:])
+	}
+}
+
+// setenv sets an environment variable and returns a function that
+// restores it
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	for _, env := range []string{"KITTY_WINDOW_ID", "TERM", "TERM_PROGRAM"} {
+		defer setenv(env, "")()
+	}
+	if terminal.Detect() != terminal.HalfBlock {
+		t.Fatal("Expected half blocks without a known terminal")
+	}
+	os.Setenv("TERM", "foot")
+	if terminal.Detect() != terminal.Sixel {
+		t.Fatal("Expected sixels for foot")
+	}
+	os.Setenv("TERM_PROGRAM", "WezTerm")
+	if terminal.Detect() != terminal.Kitty {
+		t.Fatal("Expected the kitty protocol for WezTerm")
+	}
+}
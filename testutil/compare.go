@@ -0,0 +1,119 @@
+// Package testutil helps writing rendering regression tests for drawing
+// code that uses the canvas package. Images rendered with the software
+// backend are compared against golden files, with a tolerance for small
+// differences, and failures can be collected into an HTML report that
+// shows the expected, actual and difference images side by side
+package testutil
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/opentoys/canvas"
+)
+
+// Options control how images are compared
+type Options struct {
+	// Tolerance is the maximum difference per channel (0-255) for
+	// two pixels to be considered equal
+	Tolerance uint8
+	// Perceptual compares pixels by their perceived color difference
+	// in the YIQ color space instead of per channel. Threshold is then
+	// used instead of Tolerance
+	Perceptual bool
+	// Threshold is the maximum perceived difference between 0 and 1
+	// for two pixels to be considered equal. 0.1 is a good default
+	Threshold float64
+	// MaxDiffPixels is the number of pixels that may differ for the
+	// images to still match
+	MaxDiffPixels int
+}
+
+// Result is the result of comparing two images
+type Result struct {
+	// DiffPixels is the number of pixels that differ
+	DiffPixels int
+	// Diff shows the differing pixels in red on a faded copy of the
+	// expected image
+	Diff *image.RGBA
+	// Match is true if no more than the allowed number of pixels differ
+	Match bool
+}
+
+// Render draws with the given function onto a new canvas of the given
+// size with a software backend and returns the result
+func Render(w, h int, fn func(cv *canvas.Canvas)) *image.RGBA {
+	backend := canvas.NewBackend(w, h)
+	cv := canvas.New(backend)
+	fn(cv)
+	cv.Flush()
+	return backend.Image
+}
+
+// Compare compares an image against the expected image. An error is
+// returned if the sizes differ
+func Compare(got, want image.Image, opts Options) (Result, error) {
+	gb, wb := got.Bounds(), want.Bounds()
+	if gb.Dx() != wb.Dx() || gb.Dy() != wb.Dy() {
+		return Result{}, fmt.Errorf("Image size %dx%d does not match the expected size %dx%d", gb.Dx(), gb.Dy(), wb.Dx(), wb.Dy())
+	}
+
+	maxDelta := 35215 * opts.Threshold * opts.Threshold
+	diff := image.NewRGBA(image.Rect(0, 0, wb.Dx(), wb.Dy()))
+	var result Result
+	for y := 0; y < wb.Dy(); y++ {
+		for x := 0; x < wb.Dx(); x++ {
+			c1 := color.NRGBAModel.Convert(got.At(gb.Min.X+x, gb.Min.Y+y)).(color.NRGBA)
+			c2 := color.NRGBAModel.Convert(want.At(wb.Min.X+x, wb.Min.Y+y)).(color.NRGBA)
+			var differs bool
+			if opts.Perceptual {
+				differs = yiqDelta(c1, c2) > maxDelta
+			} else {
+				differs = channelDiff(c1.R, c2.R) > opts.Tolerance ||
+					channelDiff(c1.G, c2.G) > opts.Tolerance ||
+					channelDiff(c1.B, c2.B) > opts.Tolerance ||
+					channelDiff(c1.A, c2.A) > opts.Tolerance
+			}
+			if differs {
+				result.DiffPixels++
+				diff.SetRGBA(x, y, color.RGBA{R: 255, A: 255})
+				continue
+			}
+			gray := uint8(255 - int(255-luma(c2))*int(c2.A)/255/4)
+			diff.SetRGBA(x, y, color.RGBA{R: gray, G: gray, B: gray, A: 255})
+		}
+	}
+	result.Diff = diff
+	result.Match = result.DiffPixels <= opts.MaxDiffPixels
+	return result, nil
+}
+
+func channelDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// blendWhite blends the color onto a white background
+func blendWhite(c color.NRGBA) (float64, float64, float64) {
+	a := float64(c.A) / 255
+	return 255 + (float64(c.R)-255)*a, 255 + (float64(c.G)-255)*a, 255 + (float64(c.B)-255)*a
+}
+
+func luma(c color.NRGBA) uint8 {
+	r, g, b := blendWhite(c)
+	return uint8(r*0.29889531 + g*0.58662247 + b*0.11448223)
+}
+
+// yiqDelta returns the squared perceived difference between two colors
+// as used by pixelmatch, with a maximum of 35215
+func yiqDelta(c1, c2 color.NRGBA) float64 {
+	r1, g1, b1 := blendWhite(c1)
+	r2, g2, b2 := blendWhite(c2)
+	y := (r1-r2)*0.29889531 + (g1-g2)*0.58662247 + (b1-b2)*0.11448223
+	i := (r1-r2)*0.59597799 - (g1-g2)*0.27417610 - (b1-b2)*0.32180189
+	q := (r1-r2)*0.21147017 - (g1-g2)*0.52261711 + (b1-b2)*0.31114694
+	return 0.5053*y*y + 0.299*i*i + 0.1957*q*q
+}
@@ -0,0 +1,89 @@
+package testutil
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// UpdateEnv is the environment variable that makes Golden.Check write
+// the rendered images as the new golden files if set to a non-empty value
+const UpdateEnv = "CANVAS_UPDATE_GOLDEN"
+
+// Golden manages golden image files in a directory. Golden files that
+// don't exist yet are created from the rendered image
+type Golden struct {
+	// Dir is the directory of the golden files, usually testdata
+	Dir string
+	// Options are used to compare the images
+	Options Options
+	// Update overwrites the golden files with the rendered images,
+	// as does setting the UpdateEnv environment variable
+	Update bool
+	// Report collects all failed comparisons if it is not nil
+	Report *Report
+}
+
+// Check compares the image against the golden file <name>.png and fails
+// the test if it doesn't match. On failure, the rendered image and the
+// difference image are written next to the golden file as <name>_fail.png
+// and <name>_diff.png
+func (g *Golden) Check(t testing.TB, name string, img image.Image) {
+	t.Helper()
+	fileName := filepath.Join(g.Dir, name+".png")
+
+	want, err := readPNG(fileName)
+	if os.IsNotExist(err) || g.Update || os.Getenv(UpdateEnv) != "" {
+		if err := writePNG(fileName, img); err != nil {
+			t.Fatal(err)
+		}
+		return
+	} else if err != nil {
+		t.Fatalf("Failed to read golden file \"%s\": %v", fileName, err)
+	}
+
+	result, err := Compare(img, want, g.Options)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if result.Match {
+		return
+	}
+
+	failName := filepath.Join(g.Dir, name+"_fail.png")
+	diffName := filepath.Join(g.Dir, name+"_diff.png")
+	if err := writePNG(failName, img); err != nil {
+		t.Error(err)
+	}
+	if err := writePNG(diffName, result.Diff); err != nil {
+		t.Error(err)
+	}
+	if g.Report != nil {
+		g.Report.Add(name, want, img, result)
+	}
+	t.Errorf("%s: %d pixels differ from the golden file, see %s", name, result.DiffPixels, diffName)
+}
+
+func readPNG(fileName string) (image.Image, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+func writePNG(fileName string, img image.Image) error {
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return fmt.Errorf("Failed to create file \"%s\": %v", fileName, err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		return fmt.Errorf("Failed to encode PNG \"%s\": %v", fileName, err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"html/template"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"sync"
+)
+
+// Report collects failed image comparisons and writes them as a single
+// HTML page. It is safe for concurrent use by parallel tests
+type Report struct {
+	mu      sync.Mutex
+	entries []reportEntry
+}
+
+type reportEntry struct {
+	Name       string
+	DiffPixels int
+	Want       template.URL
+	Got        template.URL
+	Diff       template.URL
+}
+
+// Add adds a failed comparison to the report
+func (r *Report) Add(name string, want, got image.Image, result Result) {
+	entry := reportEntry{
+		Name:       name,
+		DiffPixels: result.DiffPixels,
+		Want:       dataURL(want),
+		Got:        dataURL(got),
+	}
+	if result.Diff != nil {
+		entry.Diff = dataURL(result.Diff)
+	}
+	r.mu.Lock()
+	r.entries = append(r.entries, entry)
+	r.mu.Unlock()
+}
+
+// Len returns the number of failed comparisons in the report
+func (r *Report) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.entries)
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Rendering differences</title>
+<style>
+body { font-family: sans-serif; }
+td { padding: 4px; vertical-align: top; }
+img { image-rendering: pixelated; border: 1px solid #ccc; background: repeating-conic-gradient(#ddd 0 25%, #fff 0 50%) 0 0 / 16px 16px; }
+</style>
+</head>
+<body>
+<h1>{{len .}} rendering differences</h1>
+<table>
+<tr><th>Test</th><th>Expected</th><th>Actual</th><th>Difference</th></tr>
+{{range .}}<tr>
+<td>{{.Name}}<br>{{.DiffPixels}} pixels differ</td>
+<td><img src="{{.Want}}"></td>
+<td><img src="{{.Got}}"></td>
+<td>{{if .Diff}}<img src="{{.Diff}}">{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// WriteHTML writes the report as an HTML page with all images embedded
+func (r *Report) WriteHTML(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return reportTemplate.Execute(w, r.entries)
+}
+
+// WriteFile writes the report as an HTML file, if it contains any
+// failed comparisons
+func (r *Report) WriteFile(fileName string) error {
+	if r.Len() == 0 {
+		return nil
+	}
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return r.WriteHTML(f)
+}
+
+func dataURL(img image.Image) template.URL {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return ""
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()))
+}
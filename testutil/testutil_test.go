@@ -0,0 +1,96 @@
+package testutil_test
+
+import (
+	"bytes"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opentoys/canvas"
+	"github.com/opentoys/canvas/testutil"
+)
+
+func TestCompare(t *testing.T) {
+	img := testutil.Render(20, 20, func(cv *canvas.Canvas) {
+		cv.SetFillStyle("#F00")
+		cv.FillRect(5, 5, 10, 10)
+	})
+	changed := testutil.Render(20, 20, func(cv *canvas.Canvas) {
+		cv.SetFillStyle("#F00")
+		cv.FillRect(5, 5, 10, 10)
+	})
+	changed.SetRGBA(10, 10, color.RGBA{R: 250, A: 255})
+	changed.SetRGBA(0, 0, color.RGBA{B: 255, A: 255})
+
+	result, err := testutil.Compare(changed, img, testutil.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Match || result.DiffPixels != 2 {
+		t.Fatalf("Expected 2 differing pixels, got %d", result.DiffPixels)
+	}
+
+	result, _ = testutil.Compare(changed, img, testutil.Options{Tolerance: 5, MaxDiffPixels: 1})
+	if !result.Match || result.DiffPixels != 1 {
+		t.Fatalf("Expected a match with 1 differing pixel, got %d", result.DiffPixels)
+	}
+
+	result, _ = testutil.Compare(changed, img, testutil.Options{Perceptual: true, Threshold: 0.1})
+	if result.DiffPixels != 1 {
+		t.Fatalf("Expected 1 perceptually differing pixel, got %d", result.DiffPixels)
+	}
+
+	if _, err := testutil.Compare(img, testutil.Render(10, 10, func(cv *canvas.Canvas) {}), testutil.Options{}); err == nil {
+		t.Fatal("Expected an error for different sizes")
+	}
+}
+
+// failRecorder records failures instead of failing the test
+type failRecorder struct {
+	testing.TB
+	failed bool
+}
+
+func (r *failRecorder) Error(args ...interface{})                 { r.failed = true }
+func (r *failRecorder) Errorf(format string, args ...interface{}) { r.failed = true }
+
+func TestGolden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	report := &testutil.Report{}
+	golden := &testutil.Golden{Dir: dir, Report: report}
+
+	img := testutil.Render(10, 10, func(cv *canvas.Canvas) {
+		cv.SetFillStyle("#0F0")
+		cv.FillRect(0, 0, 5, 5)
+	})
+	golden.Check(t, "square", img)
+	if _, err := os.Stat(filepath.Join(dir, "square.png")); err != nil {
+		t.Fatalf("Expected the golden file to be created: %v", err)
+	}
+	golden.Check(t, "square", img)
+
+	img.SetRGBA(8, 8, color.RGBA{B: 255, A: 255})
+	inner := &failRecorder{TB: t}
+	golden.Check(inner, "square", img)
+	if !inner.failed {
+		t.Fatal("Expected the check to fail for a changed image")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "square_diff.png")); err != nil {
+		t.Fatalf("Expected a difference image: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteHTML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if report.Len() != 1 || !strings.Contains(buf.String(), "data:image/png;base64,") {
+		t.Fatal("Expected the report to contain the failed comparison")
+	}
+}
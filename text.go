@@ -1,12 +1,15 @@
+//go:build !canvas_notext
+// +build !canvas_notext
+
 package canvas
 
 import (
-	"errors"
 	"fmt"
 	"image"
 	"image/draw"
+	"io/ioutil"
 	"math"
-	"os"
+	"sync"
 	"time"
 	"unsafe"
 
@@ -59,10 +62,88 @@ func (ftc *fontTriCache) size() int {
 	return size
 }
 
+// textCache holds the fonts loaded by a canvas and the caches used
+// for rendering text
+type textCache struct {
+	fonts map[interface{}]*Font
+	ctxs  map[fontKey]*frCache
+	paths map[*Font]*fontPathCache
+	tris  map[*Font]*fontTriCache
+	image *image.Alpha
+}
+
+func newTextCache() textCache {
+	return textCache{
+		fonts: make(map[interface{}]*Font),
+		ctxs:  make(map[fontKey]*frCache),
+		paths: make(map[*Font]*fontPathCache),
+		tris:  make(map[*Font]*fontTriCache),
+	}
+}
+
+// textState is the part of the draw state that selects the font
+type textState struct {
+	font    *Font
+	size    fixed.Int26_6
+	metrics font.Metrics
+}
+
+// usage returns the approximate size of the caches and the time the
+// least recently used cache was last used. ok is false if there are
+// no caches
+func (tc *textCache) usage() (total int, oldest time.Time, ok bool) {
+	check := func(lastUsed time.Time) {
+		if !ok || lastUsed.Before(oldest) {
+			oldest = lastUsed
+			ok = true
+		}
+	}
+	for _, frctx := range tc.ctxs {
+		total += frctx.ctx.cacheSize()
+		check(frctx.lastUsed)
+	}
+	for _, cache := range tc.paths {
+		total += cache.size()
+		check(cache.lastUsed)
+	}
+	for _, cache := range tc.tris {
+		total += cache.size()
+		check(cache.lastUsed)
+	}
+	return
+}
+
+// dropOldest deletes the least recently used cache
+func (tc *textCache) dropOldest() {
+	_, oldest, ok := tc.usage()
+	if !ok {
+		return
+	}
+	for key, frctx := range tc.ctxs {
+		if frctx.lastUsed.Equal(oldest) {
+			frctx.ctx = nil
+			delete(tc.ctxs, key)
+			return
+		}
+	}
+	for fnt, cache := range tc.paths {
+		if cache.lastUsed.Equal(oldest) {
+			delete(tc.paths, fnt)
+			return
+		}
+	}
+	for fnt, cache := range tc.tris {
+		if cache.lastUsed.Equal(oldest) {
+			delete(tc.tris, fnt)
+			return
+		}
+	}
+}
+
 var zeroes [alphaTexSize]byte
-var textImage *image.Alpha
 
 var defaultFont *Font
+var defaultFontMu sync.Mutex
 
 var baseFontSize = fixed.I(42)
 
@@ -72,7 +153,7 @@ func (cv *Canvas) LoadFont(src interface{}) (*Font, error) {
 	if f, ok := src.(*Font); ok {
 		return f, nil
 	} else if _, ok := src.([]byte); !ok {
-		if f, ok := cv.fonts[src]; ok {
+		if f, ok := cv.text.fonts[src]; ok {
 			return f, nil
 		}
 	}
@@ -82,7 +163,7 @@ func (cv *Canvas) LoadFont(src interface{}) (*Font, error) {
 	case *truetype.Font:
 		f = &Font{font: v}
 	case string:
-		data, err := os.ReadFile(v)
+		data, err := ioutil.ReadFile(v)
 		if err != nil {
 			return nil, err
 		}
@@ -98,32 +179,55 @@ func (cv *Canvas) LoadFont(src interface{}) (*Font, error) {
 		}
 		f = &Font{font: font}
 	default:
-		return nil, errors.New("Unsupported source type")
+		return nil, ErrUnsupportedSource
 	}
+	defaultFontMu.Lock()
 	if defaultFont == nil {
 		defaultFont = f
 	}
+	defaultFontMu.Unlock()
 
 	if _, ok := src.([]byte); !ok {
-		cv.fonts[src] = f
+		cv.text.fonts[src] = f
 	}
 	return f, nil
 }
 
+// SetFont sets the font and font size. The font parameter can be a font loaded
+// with the LoadFont function, a filename for a font to load (which will be
+// cached), or nil, in which case the first loaded font will be used
+func (cv *Canvas) SetFont(src interface{}, size float64) {
+	cv.state.text.size = fixed.Int26_6(math.Round(size * 64))
+	if src == nil {
+		defaultFontMu.Lock()
+		cv.state.text.font = defaultFont
+		defaultFontMu.Unlock()
+	} else {
+		cv.state.text.font = cv.getFont(src)
+	}
+	if cv.state.text.font == nil {
+		cv.reportError(ErrNoFont)
+		return
+	}
+
+	fontFace := truetype.NewFace(cv.state.text.font.font, &truetype.Options{Size: size})
+	cv.state.text.metrics = fontFace.Metrics()
+}
+
 func (cv *Canvas) getFont(src interface{}) *Font {
 	f, err := cv.LoadFont(src)
 	if err != nil {
-		cv.fonts[src] = nil
-		fmt.Fprintf(os.Stderr, "Error loading font: %v\n", err)
+		cv.text.fonts[src] = nil
+		cv.reportError(fmt.Errorf("Error loading font: %w", err))
 	} else {
-		cv.fonts[src] = f
+		cv.text.fonts[src] = f
 	}
 	return f
 }
 
 func (cv *Canvas) getFRContext(font *Font, size fixed.Int26_6) *frContext {
 	k := fontKey{font: font, size: size}
-	if frctx, ok := cv.fontCtxs[k]; ok {
+	if frctx, ok := cv.text.ctxs[k]; ok {
 		frctx.lastUsed = time.Now()
 		return frctx.ctx
 	}
@@ -134,7 +238,7 @@ func (cv *Canvas) getFRContext(font *Font, size fixed.Int26_6) *frContext {
 	frctx.f = font.font
 	frctx.recalc()
 
-	cv.fontCtxs[k] = &frCache{ctx: frctx, lastUsed: time.Now()}
+	cv.text.ctxs[k] = &frCache{ctx: frctx, lastUsed: time.Now()}
 
 	return frctx
 }
@@ -142,14 +246,16 @@ func (cv *Canvas) getFRContext(font *Font, size fixed.Int26_6) *frContext {
 // FillText draws the given string at the given coordinates
 // using the currently set font and font height
 func (cv *Canvas) FillText(str string, x, y float64) {
-	if cv.state.font.font == nil {
+	if cv.state.text.font.font == nil {
 		return
 	}
+	defer cv.traceRegion("canvas.FillText").End()
 
-	scaleX := BackendVec{cv.state.transform[0], cv.state.transform[1]}.Len()
-	scaleY := BackendVec{cv.state.transform[2], cv.state.transform[3]}.Len()
+	tf := cv.transform()
+	scaleX := BackendVec{tf[0], tf[1]}.Len()
+	scaleY := BackendVec{tf[2], tf[3]}.Len()
 	scale := (scaleX + scaleY) * 0.5
-	fontSize := fixed.Int26_6(math.Round(float64(cv.state.fontSize) * scale))
+	fontSize := fixed.Int26_6(math.Round(float64(cv.state.text.size) * scale))
 
 	// if the font size is large or rotated or skewed in some way, use the
 	// triangulated font rendering
@@ -157,14 +263,13 @@ func (cv *Canvas) FillText(str string, x, y float64) {
 		cv.fillText2(str, x, y)
 		return
 	}
-	mat := cv.state.transform
-	if mat[1] != 0 || mat[2] != 0 || mat[0] != mat[3] {
+	if tf[1] != 0 || tf[2] != 0 || tf[0] != tf[3] {
 		cv.fillText2(str, x, y)
 		return
 	}
 
-	frc := cv.getFRContext(cv.state.font, fontSize)
-	fnt := cv.state.font.font
+	frc := cv.getFRContext(cv.state.text.font, fontSize)
+	fnt := cv.state.text.font.font
 
 	strWidth, strHeight, textOffset, str := cv.measureTextRendering(str, &x, &y, frc, scale)
 	if strWidth <= 0 || strHeight <= 0 {
@@ -172,7 +277,7 @@ func (cv *Canvas) FillText(str string, x, y float64) {
 	}
 
 	// make sure textImage is large enough for the rendered string
-	if textImage == nil || textImage.Bounds().Dx() < strWidth || textImage.Bounds().Dy() < strHeight {
+	if cv.text.image == nil || cv.text.image.Bounds().Dx() < strWidth || cv.text.image.Bounds().Dy() < strHeight {
 		var size int
 		for size = 2; size < alphaTexSize; size *= 2 {
 			if size >= strWidth && size >= strHeight {
@@ -182,13 +287,13 @@ func (cv *Canvas) FillText(str string, x, y float64) {
 		if size > alphaTexSize {
 			size = alphaTexSize
 		}
-		textImage = image.NewAlpha(image.Rect(0, 0, size, size))
+		cv.text.image = image.NewAlpha(image.Rect(0, 0, size, size))
 	}
 
 	// clear the render region in textImage
 	for y := 0; y < strHeight; y++ {
-		off := textImage.PixOffset(0, y)
-		line := textImage.Pix[off : off+strWidth]
+		off := cv.text.image.PixOffset(0, y)
+		line := cv.text.image.Pix[off : off+strWidth]
 		for i := range line {
 			line[i] = 0
 		}
@@ -220,7 +325,7 @@ func (cv *Canvas) FillText(str string, x, y float64) {
 		}
 		p.X += advance
 
-		draw.Draw(textImage, mask.Bounds().Add(offset).Sub(textOffset), mask, image.ZP, draw.Over)
+		draw.Draw(cv.text.image, mask.Bounds().Add(offset).Sub(textOffset), mask, image.ZP, draw.Over)
 
 		curX += float64(advance) / 64
 	}
@@ -232,28 +337,30 @@ func (cv *Canvas) FillText(str string, x, y float64) {
 	pts[2] = cv.tf(BackendVec{float64(textOffset.X)/scale + float64(strWidth)/scale + x, float64(textOffset.Y)/scale + float64(strHeight)/scale + y})
 	pts[3] = cv.tf(BackendVec{float64(textOffset.X)/scale + float64(strWidth)/scale + x, float64(textOffset.Y)/scale + y})
 
-	mask := textImage.SubImage(image.Rect(0, 0, strWidth, strHeight)).(*image.Alpha)
+	mask := cv.text.image.SubImage(image.Rect(0, 0, strWidth, strHeight)).(*image.Alpha)
 
 	cv.drawShadow(pts[:], mask, false)
 
 	stl := cv.backendFillStyle(&cv.state.fill, 1)
-	cv.b.FillImageMask(&stl, mask, pts)
+	cv.fillImageMask(&stl, mask, pts)
+
+	cv.drawInsetShadow(pts[:], mask)
 }
 
 func (cv *Canvas) fillText2(str string, x, y float64) {
-	if cv.state.font == nil {
+	if cv.state.text.font == nil {
 		return
 	}
 
-	frc := cv.getFRContext(cv.state.font, cv.state.fontSize)
-	fnt := cv.state.font.font
+	frc := cv.getFRContext(cv.state.text.font, cv.state.text.size)
+	fnt := cv.state.text.font.font
 
 	strWidth, strHeight, _, str := cv.measureTextRendering(str, &x, &y, frc, 1)
 	if strWidth <= 0 || strHeight <= 0 {
 		return
 	}
 
-	scale := float64(cv.state.fontSize) / float64(baseFontSize)
+	scale := float64(cv.state.text.size) / float64(baseFontSize)
 	scaleMat := BackendMatScale(BackendVec{scale, scale})
 
 	prev, hasPrev := truetype.Index(0), false
@@ -264,7 +371,7 @@ func (cv *Canvas) fillText2(str string, x, y float64) {
 		}
 
 		if hasPrev {
-			kern := fnt.Kern(cv.state.fontSize, prev, idx)
+			kern := fnt.Kern(cv.state.text.size, prev, idx)
 			if frc.hinting != font.HintingNone {
 				kern = (kern + 32) &^ 63
 			}
@@ -276,10 +383,12 @@ func (cv *Canvas) fillText2(str string, x, y float64) {
 		}
 
 		tris := cv.runeTris(rn)
-		tf := scaleMat.Mul(BackendMatTranslate(BackendVec{x, y})).Mul(cv.state.transform)
-		cv.drawShadow(tris, nil, false)
+		tf := scaleMat.Mul(BackendMatTranslate(BackendVec{x, y})).Mul(cv.transform())
+		shadowTris := cv.shadowPts(tris, tf)
+		cv.drawShadow(shadowTris, nil, false)
 		stl := cv.backendFillStyle(&cv.state.fill, 1)
-		cv.b.Fill(&stl, tris, tf, false)
+		cv.fill(&stl, tris, tf, false)
+		cv.drawInsetShadow(shadowTris, nil)
 
 		x += float64(advance) / 64
 	}
@@ -290,19 +399,20 @@ func (cv *Canvas) fillText2(str string, x, y float64) {
 // using the currently set font and font height and using the
 // current stroke style
 func (cv *Canvas) StrokeText(str string, x, y float64) {
-	if cv.state.font == nil {
+	if cv.state.text.font == nil {
 		return
 	}
+	defer cv.traceRegion("canvas.StrokeText").End()
 
-	frc := cv.getFRContext(cv.state.font, cv.state.fontSize)
-	fnt := cv.state.font.font
+	frc := cv.getFRContext(cv.state.text.font, cv.state.text.size)
+	fnt := cv.state.text.font.font
 
 	strWidth, strHeight, _, str := cv.measureTextRendering(str, &x, &y, frc, 1)
 	if strWidth <= 0 || strHeight <= 0 {
 		return
 	}
 
-	scale := float64(cv.state.fontSize) / float64(baseFontSize)
+	scale := float64(cv.state.text.size) / float64(baseFontSize)
 	scaleMat := BackendMatScale(BackendVec{scale, scale})
 
 	prev, hasPrev := truetype.Index(0), false
@@ -313,7 +423,7 @@ func (cv *Canvas) StrokeText(str string, x, y float64) {
 		}
 
 		if hasPrev {
-			kern := fnt.Kern(cv.state.fontSize, prev, idx)
+			kern := fnt.Kern(cv.state.text.size, prev, idx)
 			if frc.hinting != font.HintingNone {
 				kern = (kern + 32) &^ 63
 			}
@@ -325,7 +435,7 @@ func (cv *Canvas) StrokeText(str string, x, y float64) {
 		}
 
 		path := cv.runePath(rn)
-		tf := scaleMat.Mul(BackendMatTranslate(BackendVec{x, y})).Mul(cv.state.transform)
+		tf := scaleMat.Mul(BackendMatTranslate(BackendVec{x, y})).Mul(cv.transform())
 		cv.strokePath(path, tf, BackendMat{}, false)
 
 		x += float64(advance) / 64
@@ -382,7 +492,7 @@ func (cv *Canvas) measureTextRendering(str string, x, y *float64, frc *frContext
 	} else if cv.state.textAlign == Right || cv.state.textAlign == End {
 		*x -= float64(strWidth) / scale
 	}
-	metrics := cv.state.fontMetrics
+	metrics := cv.state.text.metrics
 	switch cv.state.textBaseline {
 	case Alphabetic:
 	case Middle:
@@ -497,12 +607,12 @@ func (cv *Canvas) measureTextRendering(str string, x, y *float64, frc *frContext
 }
 
 func (cv *Canvas) runePath(rn rune) *Path2D {
-	idx := cv.state.font.font.Index(rn)
+	idx := cv.state.text.font.font.Index(rn)
 	if idx == 0 {
-		idx = cv.state.font.font.Index(' ')
+		idx = cv.state.text.font.font.Index(' ')
 	}
 
-	if cache, ok := cv.fontPathCache[cv.state.font]; ok {
+	if cache, ok := cv.text.paths[cv.state.text.font]; ok {
 		if path, ok := cache.cache[idx]; ok {
 			cache.lastUsed = time.Now()
 			return path
@@ -514,7 +624,7 @@ func (cv *Canvas) runePath(rn rune) *Path2D {
 	const scale = 1.0 / 64.0
 
 	var gb truetype.GlyphBuf
-	gb.Load(cv.state.font.font, baseFontSize, idx, font.HintingFull)
+	gb.Load(cv.state.text.font.font, baseFontSize, idx, font.HintingFull)
 
 	from := 0
 	for _, to := range gb.Ends {
@@ -572,10 +682,10 @@ func (cv *Canvas) runePath(rn rune) *Path2D {
 		from = to
 	}
 
-	cache, ok := cv.fontPathCache[cv.state.font]
+	cache, ok := cv.text.paths[cv.state.text.font]
 	if !ok {
 		cache = &fontPathCache{cache: make(map[truetype.Index]*Path2D, 1024)}
-		cv.fontPathCache[cv.state.font] = cache
+		cv.text.paths[cv.state.text.font] = cache
 	}
 	cache.lastUsed = time.Now()
 	cache.cache[idx] = path
@@ -584,12 +694,12 @@ func (cv *Canvas) runePath(rn rune) *Path2D {
 }
 
 func (cv *Canvas) runeTris(rn rune) []BackendVec {
-	idx := cv.state.font.font.Index(rn)
+	idx := cv.state.text.font.font.Index(rn)
 	if idx == 0 {
-		idx = cv.state.font.font.Index(' ')
+		idx = cv.state.text.font.font.Index(' ')
 	}
 
-	if cache, ok := cv.fontTriCache[cv.state.font]; ok {
+	if cache, ok := cv.text.tris[cv.state.text.font]; ok {
 		if tris, ok := cache.cache[idx]; ok {
 			cache.lastUsed = time.Now()
 			return tris
@@ -599,7 +709,7 @@ func (cv *Canvas) runeTris(rn rune) []BackendVec {
 	const scale = 1.0 / 64.0
 
 	var gb truetype.GlyphBuf
-	gb.Load(cv.state.font.font, baseFontSize, idx, font.HintingFull)
+	gb.Load(cv.state.text.font.font, baseFontSize, idx, font.HintingFull)
 
 	contours := make([][]BackendVec, 0, len(gb.Ends))
 
@@ -715,10 +825,10 @@ func (cv *Canvas) runeTris(rn rune) []BackendVec {
 		pos += len(tris)
 	}
 
-	cache, ok := cv.fontTriCache[cv.state.font]
+	cache, ok := cv.text.tris[cv.state.text.font]
 	if !ok {
 		cache = &fontTriCache{cache: make(map[truetype.Index][]BackendVec, 1024)}
-		cv.fontTriCache[cv.state.font] = cache
+		cv.text.tris[cv.state.text.font] = cache
 	}
 	cache.lastUsed = time.Now()
 	cache.cache[idx] = allTris
@@ -736,12 +846,12 @@ type TextMetrics struct {
 // MeasureText measures the given string using the
 // current font and font height
 func (cv *Canvas) MeasureText(str string) TextMetrics {
-	if cv.state.font == nil {
+	if cv.state.text.font == nil {
 		return TextMetrics{}
 	}
 
-	frc := cv.getFRContext(cv.state.font, cv.state.fontSize)
-	fnt := cv.state.font.font
+	frc := cv.getFRContext(cv.state.text.font, cv.state.text.size)
+	fnt := cv.state.text.font.font
 
 	var p fixed.Point26_6
 	var x float64
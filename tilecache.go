@@ -0,0 +1,163 @@
+package canvas
+
+import (
+	"math"
+	"sort"
+)
+
+// TileCache draws a display list for views that pan and zoom, such as
+// maps. The display list is rendered into square tiles at the current
+// zoom level, and the tiles are reused while panning, so that only the
+// newly exposed tiles have to be rendered. Changing the zoom level
+// renders all tiles again.
+//
+// The tiles are rendered with software backends, so the display list
+// must be recorded for a SoftwareBackend. Image patterns and image data
+// in the display list are not scaled when zooming
+type TileCache struct {
+	// MaxTiles is the maximum number of tiles that are kept. The least
+	// recently used tiles are dropped first, but tiles that are visible
+	// in the last drawn view are always kept
+	MaxTiles int
+
+	list     *DisplayList
+	size     int
+	scale    float64
+	tiles    map[[2]int]*cacheTile
+	frame    int
+	rendered int
+}
+
+type cacheTile struct {
+	backend  *SoftwareBackend
+	img      *Image
+	lastUsed int
+}
+
+// NewTileCache creates a tile cache for the display list with the given
+// tile size in pixels
+func NewTileCache(list *DisplayList, tileSize int) *TileCache {
+	return &TileCache{
+		MaxTiles: 256,
+		list:     list,
+		size:     tileSize,
+		tiles:    make(map[[2]int]*cacheTile),
+	}
+}
+
+// SetDisplayList replaces the display list and drops all tiles
+func (tc *TileCache) SetDisplayList(list *DisplayList) {
+	tc.list = list
+	tc.Clear()
+}
+
+// Clear drops all tiles
+func (tc *TileCache) Clear() {
+	for key := range tc.tiles {
+		tc.drop(key)
+	}
+}
+
+// Invalidate drops the tiles that overlap the given area in display list
+// coordinates, so that they are rendered again the next time they are
+// visible. This should be called after changing the display list
+func (tc *TileCache) Invalidate(area Bounds) {
+	for key := range tc.tiles {
+		if tc.tileBounds(key).Intersects(area) {
+			tc.drop(key)
+		}
+	}
+}
+
+// Len returns the number of cached tiles
+func (tc *TileCache) Len() int { return len(tc.tiles) }
+
+// Rendered returns the number of tiles that were rendered so far
+func (tc *TileCache) Rendered() int { return tc.rendered }
+
+// Draw draws the view of the display list onto the canvas, with the top
+// left corner of the canvas at x/y in display list coordinates and the
+// given zoom scale. Tiles that are not cached yet are rendered
+func (tc *TileCache) Draw(cv *Canvas, x, y, scale float64) {
+	if scale != tc.scale {
+		tc.Clear()
+		tc.scale = scale
+	}
+	tc.frame++
+
+	w, h := cv.Size()
+	ox, oy := x*scale, y*scale
+	size := float64(tc.size)
+	tx0, ty0 := int(math.Floor(ox/size)), int(math.Floor(oy/size))
+	tx1, ty1 := int(math.Floor((ox+float64(w))/size)), int(math.Floor((oy+float64(h))/size))
+	for ty := ty0; ty <= ty1; ty++ {
+		for tx := tx0; tx <= tx1; tx++ {
+			tile := tc.tile([2]int{tx, ty})
+			if tile.img == nil || tile.img.cv != cv || tile.img.deleted {
+				if tile.img != nil {
+					tile.img.Delete()
+				}
+				tile.img = cv.getImage(tile.backend.Image)
+			}
+			cv.DrawImage(tile.img, float64(tx)*size-ox, float64(ty)*size-oy)
+		}
+	}
+
+	tc.evict()
+}
+
+// tileBounds returns the area covered by the tile in display list
+// coordinates, including a pixel of the neighbouring tiles for
+// antialiasing
+func (tc *TileCache) tileBounds(key [2]int) Bounds {
+	size := float64(tc.size)
+	return Bounds{
+		MinX: float64(key[0]) * size / tc.scale, MinY: float64(key[1]) * size / tc.scale,
+		MaxX: float64(key[0]+1) * size / tc.scale, MaxY: float64(key[1]+1) * size / tc.scale,
+	}.Grow(1 / tc.scale)
+}
+
+func (tc *TileCache) tile(key [2]int) *cacheTile {
+	if tile, ok := tc.tiles[key]; ok {
+		tile.lastUsed = tc.frame
+		return tile
+	}
+	tile := &cacheTile{backend: NewBackend(tc.size, tc.size), lastUsed: tc.frame}
+	if tc.list != nil {
+		m := BackendMatScale(BackendVec{tc.scale, tc.scale}).Mul(BackendMatTranslate(BackendVec{
+			-float64(key[0] * tc.size), -float64(key[1] * tc.size),
+		}))
+		tc.list.replay(tile.backend, m, tc.tileBounds(key))
+	}
+	tc.rendered++
+	tc.tiles[key] = tile
+	return tile
+}
+
+func (tc *TileCache) drop(key [2]int) {
+	if img := tc.tiles[key].img; img != nil {
+		img.Delete()
+	}
+	delete(tc.tiles, key)
+}
+
+// evict drops the least recently used tiles that are not visible until
+// there are at most MaxTiles tiles
+func (tc *TileCache) evict() {
+	if len(tc.tiles) <= tc.MaxTiles {
+		return
+	}
+	keys := make([][2]int, 0, len(tc.tiles))
+	for key, tile := range tc.tiles {
+		if tile.lastUsed < tc.frame {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return tc.tiles[keys[i]].lastUsed < tc.tiles[keys[j]].lastUsed })
+	for _, key := range keys {
+		if len(tc.tiles) <= tc.MaxTiles {
+			break
+		}
+		tc.drop(key)
+	}
+}
@@ -0,0 +1,178 @@
+package canvas
+
+import (
+	"image"
+	"math"
+)
+
+// TileMap draws a grid of tiles from a tileset image. Only the tiles
+// that are visible on the canvas are drawn, all with a single
+// DrawSprites call. The tiles are copied into an atlas with a one pixel
+// border around each tile that repeats its edge pixels, so that image
+// filtering does not blend in the neighboring tiles of the tileset when
+// the map is scaled. Unless the map is rotated, the tile edges are
+// also snapped to whole pixels, so that the anti-aliased edges of
+// neighboring tiles don't leave visible seams between them
+type TileMap struct {
+	tileW, tileH int
+	cols, rows   int
+	tiles        []int
+
+	atlas     *image.RGBA
+	atlasCols int
+	count     int
+
+	sprites []SpriteInstance
+	edgesX  []float64
+	edgesY  []float64
+}
+
+// NewTileMap creates a map of cols by rows tiles, which are all empty.
+// The tiles of the tileset are numbered from left to right and top to
+// bottom, starting at zero
+func NewTileMap(tileset image.Image, tileW, tileH, cols, rows int) *TileMap {
+	tm := &TileMap{tileW: tileW, tileH: tileH, cols: cols, rows: rows}
+	tm.tiles = make([]int, cols*rows)
+	for i := range tm.tiles {
+		tm.tiles[i] = -1
+	}
+	if tileW <= 0 || tileH <= 0 {
+		return tm
+	}
+
+	bounds := tileset.Bounds()
+	tm.atlasCols = bounds.Dx() / tileW
+	tilesY := bounds.Dy() / tileH
+	tm.count = tm.atlasCols * tilesY
+	pw, ph := tileW+2, tileH+2
+	tm.atlas = image.NewRGBA(image.Rect(0, 0, tm.atlasCols*pw, tilesY*ph))
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tm.atlasCols; tx++ {
+			src := bounds.Min.Add(image.Pt(tx*tileW, ty*tileH))
+			dst := image.Pt(tx*pw, ty*ph)
+			for y := -1; y <= tileH; y++ {
+				sy := src.Y + clampInt(y, 0, tileH-1)
+				for x := -1; x <= tileW; x++ {
+					sx := src.X + clampInt(x, 0, tileW-1)
+					tm.atlas.SetRGBA(dst.X+x+1, dst.Y+y+1, rgbaAt(tileset, sx, sy))
+				}
+			}
+		}
+	}
+	return tm
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// Size returns the number of columns and rows of the map
+func (tm *TileMap) Size() (cols, rows int) { return tm.cols, tm.rows }
+
+// Set changes the tile at the column and row. A negative tile leaves
+// the cell empty
+func (tm *TileMap) Set(col, row, tile int) {
+	if col < 0 || row < 0 || col >= tm.cols || row >= tm.rows {
+		return
+	}
+	tm.tiles[row*tm.cols+col] = tile
+}
+
+// Tile returns the tile at the column and row, or -1 for an empty or
+// invalid cell
+func (tm *TileMap) Tile(col, row int) int {
+	if col < 0 || row < 0 || col >= tm.cols || row >= tm.rows {
+		return -1
+	}
+	return tm.tiles[row*tm.cols+col]
+}
+
+// SetTiles replaces all tiles with the grid, which holds the tiles row
+// by row
+func (tm *TileMap) SetTiles(tiles []int) {
+	n := copy(tm.tiles, tiles)
+	for i := n; i < len(tm.tiles); i++ {
+		tm.tiles[i] = -1
+	}
+}
+
+// Draw draws the part of the map that is visible on the canvas with its
+// top left corner at x/y, using the current transformation
+func (tm *TileMap) Draw(cv *Canvas, x, y float64) {
+	if tm.atlas == nil {
+		return
+	}
+	c0, r0, c1, r1, ok := tm.visible(cv, x, y)
+	if !ok {
+		return
+	}
+
+	tf := cv.transform()
+	snap := tf[1] == 0 && tf[2] == 0 && tf[0] != 0 && tf[3] != 0
+	tm.edgesX = tileEdges(tm.edgesX[:0], x, float64(tm.tileW), c0, c1, tf[0], tf[4], snap)
+	tm.edgesY = tileEdges(tm.edgesY[:0], y, float64(tm.tileH), r0, r1, tf[3], tf[5], snap)
+
+	pw, ph := float64(tm.tileW+2), float64(tm.tileH+2)
+	tw, th := float64(tm.tileW), float64(tm.tileH)
+	tm.sprites = tm.sprites[:0]
+	for row := r0; row < r1; row++ {
+		y0, y1 := tm.edgesY[row-r0], tm.edgesY[row-r0+1]
+		for col := c0; col < c1; col++ {
+			tile := tm.tiles[row*tm.cols+col]
+			if tile < 0 || tile >= tm.count {
+				continue
+			}
+			x0, x1 := tm.edgesX[col-c0], tm.edgesX[col-c0+1]
+			tx, ty := tile%tm.atlasCols, tile/tm.atlasCols
+			tm.sprites = append(tm.sprites, SpriteInstance{
+				SX: float64(tx)*pw + 1,
+				SY: float64(ty)*ph + 1,
+				SW: tw,
+				SH: th,
+				Transform: BackendMatScale(BackendVec{(x1 - x0) / tw, (y1 - y0) / th}).
+					Mul(BackendMatTranslate(BackendVec{x0, y0})),
+				Alpha: 1,
+			})
+		}
+	}
+	cv.DrawSprites(tm.atlas, tm.sprites)
+}
+
+// visible returns the range of columns and rows that cover the canvas
+func (tm *TileMap) visible(cv *Canvas, x, y float64) (c0, r0, c1, r1 int, ok bool) {
+	w, h := cv.Size()
+	inv := cv.transform().Invert()
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range [4]BackendVec{{0, 0}, {float64(w), 0}, {0, float64(h)}, {float64(w), float64(h)}} {
+		p = p.MulMat(inv)
+		minX, minY = math.Min(minX, p[0]), math.Min(minY, p[1])
+		maxX, maxY = math.Max(maxX, p[0]), math.Max(maxY, p[1])
+	}
+	tw, th := float64(tm.tileW), float64(tm.tileH)
+	c0 = clampInt(int(math.Floor((minX-x)/tw)), 0, tm.cols)
+	r0 = clampInt(int(math.Floor((minY-y)/th)), 0, tm.rows)
+	c1 = clampInt(int(math.Ceil((maxX-x)/tw)), 0, tm.cols)
+	r1 = clampInt(int(math.Ceil((maxY-y)/th)), 0, tm.rows)
+	return c0, r0, c1, r1, c0 < c1 && r0 < r1
+}
+
+// tileEdges appends the positions of the edges between the tiles from
+// first to last. If snap is set, the edges are moved to where scale and
+// offset map them to whole pixels
+func tileEdges(edges []float64, origin, size float64, first, last int, scale, offset float64, snap bool) []float64 {
+	for i := first; i <= last; i++ {
+		e := origin + float64(i)*size
+		if snap {
+			e = (math.Round(e*scale+offset) - offset) / scale
+		}
+		edges = append(edges, e)
+	}
+	return edges
+}
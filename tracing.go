@@ -0,0 +1,660 @@
+//go:build !canvas_notrace
+// +build !canvas_notrace
+
+package canvas
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"strings"
+)
+
+// TraceFormat is the format of the log of a TracingBackend
+type TraceFormat uint8
+
+// Trace formats. Text is one readable line per call, JSON is one JSON
+// object per line and can be replayed with ReplayTrace
+const (
+	TraceText TraceFormat = iota
+	TraceJSON
+)
+
+// TraceCall is a single logged backend call. Only the fields that belong
+// to the call are set. Images, gradients and image patterns are referred
+// to by the id that was logged when they were loaded
+type TraceCall struct {
+	Call string `json:"call"`
+	// ID is the id of the loaded, replaced or deleted resource
+	ID int `json:"id,omitempty"`
+	// Image is the id of the drawn image or the image of a pattern
+	Image int `json:"image,omitempty"`
+	// Rect is the part of the image of a pattern, if it is a sub-image
+	Rect *image.Rectangle `json:"rect,omitempty"`
+
+	Style      *TraceStyle  `json:"style,omitempty"`
+	Pts        []BackendVec `json:"pts,omitempty"`
+	Tf         *BackendMat  `json:"tf,omitempty"`
+	CanOverlap bool         `json:"canOverlap,omitempty"`
+	Colors     []color.RGBA `json:"colors,omitempty"`
+
+	SX    float64 `json:"sx,omitempty"`
+	SY    float64 `json:"sy,omitempty"`
+	SW    float64 `json:"sw,omitempty"`
+	SH    float64 `json:"sh,omitempty"`
+	Alpha float64 `json:"alpha,omitempty"`
+
+	Stops     BackendGradient           `json:"stops,omitempty"`
+	Transform *[9]float64               `json:"transform,omitempty"`
+	Repeat    BackendImagePatternRepeat `json:"repeat,omitempty"`
+	Area      *[4]float64               `json:"area,omitempty"`
+
+	X int `json:"x,omitempty"`
+	Y int `json:"y,omitempty"`
+	W int `json:"w,omitempty"`
+	H int `json:"h,omitempty"`
+	// Data are the RGBA pixels of images and image data, or the alpha
+	// values of masks, with W and H as the size
+	Data []byte `json:"data,omitempty"`
+}
+
+// TraceStyle is a BackendFillStyle with resources replaced by their ids
+type TraceStyle struct {
+	Color          color.RGBA    `json:"color"`
+	Blur           float64       `json:"blur,omitempty"`
+	LinearGradient int           `json:"linearGradient,omitempty"`
+	RadialGradient int           `json:"radialGradient,omitempty"`
+	ImagePattern   int           `json:"imagePattern,omitempty"`
+	Gradient       *[6]float64   `json:"gradient,omitempty"`
+	Dither         BackendDither `json:"dither,omitempty"`
+}
+
+// String returns the style as a readable list of the set fields
+func (s TraceStyle) String() string {
+	c := s.Color
+	str := fmt.Sprintf("{color=#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+	if s.Blur != 0 {
+		str += fmt.Sprintf(" blur=%v", s.Blur)
+	}
+	if s.LinearGradient != 0 {
+		str += fmt.Sprintf(" linearGradient=%d", s.LinearGradient)
+	}
+	if s.RadialGradient != 0 {
+		str += fmt.Sprintf(" radialGradient=%d", s.RadialGradient)
+	}
+	if s.ImagePattern != 0 {
+		str += fmt.Sprintf(" imagePattern=%d", s.ImagePattern)
+	}
+	if s.Gradient != nil {
+		str += fmt.Sprintf(" gradient=%v", *s.Gradient)
+	}
+	if s.Dither != 0 {
+		str += fmt.Sprintf(" dither=%d", s.Dither)
+	}
+	return str + "}"
+}
+
+// String returns the call as a readable line, with pixel data shortened
+// to its size
+func (c *TraceCall) String() string {
+	var sb strings.Builder
+	sb.WriteString(c.Call)
+	field := func(name string, v interface{}) {
+		fmt.Fprintf(&sb, " %s=%v", name, v)
+	}
+	if c.ID != 0 {
+		field("id", c.ID)
+	}
+	if c.Image != 0 {
+		field("image", c.Image)
+	}
+	if c.Rect != nil {
+		field("rect", *c.Rect)
+	}
+	if c.Style != nil {
+		field("style", *c.Style)
+	}
+	if c.Pts != nil {
+		field("pts", c.Pts)
+	}
+	if c.Tf != nil {
+		field("tf", *c.Tf)
+	}
+	if c.CanOverlap {
+		field("canOverlap", true)
+	}
+	if c.Colors != nil {
+		field("colors", c.Colors)
+	}
+	if c.SW != 0 || c.SH != 0 {
+		field("src", [4]float64{c.SX, c.SY, c.SW, c.SH})
+	}
+	if c.Call == "drawImage" {
+		field("alpha", c.Alpha)
+	}
+	if c.Stops != nil {
+		field("stops", c.Stops)
+	}
+	if c.Transform != nil {
+		field("transform", *c.Transform)
+		field("repeat", c.Repeat)
+		if c.Area != nil {
+			field("area", *c.Area)
+		}
+	}
+	if c.X != 0 || c.Y != 0 {
+		field("pos", [2]int{c.X, c.Y})
+	}
+	if c.W != 0 || c.H != 0 {
+		field("size", [2]int{c.W, c.H})
+	}
+	if c.Data != nil {
+		fmt.Fprintf(&sb, " data=<%d bytes>", len(c.Data))
+	}
+	return sb.String()
+}
+
+// TracingBackend is a backend that logs every call with its arguments
+// before passing it on to the target backend, for example to attach to a
+// bug report or to compare the calls of two canvases. Loaded images are
+// logged with their pixels, so that a JSON trace can be replayed with
+// ReplayTrace without the original resources. Optional backend features
+// such as tinting are not forwarded, the canvas uses its fallbacks, so
+// that the trace only contains calls of the Backend interface
+type TracingBackend struct {
+	target Backend
+	w      io.Writer
+	enc    *json.Encoder
+	nextID int
+	err    error
+}
+
+// NewTracingBackend creates a new tracing backend that logs the calls
+// to the target backend to w
+func NewTracingBackend(target Backend, w io.Writer, format TraceFormat) *TracingBackend {
+	tb := &TracingBackend{target: target, w: w}
+	if format == TraceJSON {
+		tb.enc = json.NewEncoder(w)
+	}
+	return tb
+}
+
+// Target returns the backend that the calls are passed on to
+func (tb *TracingBackend) Target() Backend { return tb.target }
+
+// Err returns the first error that occurred while writing the log
+func (tb *TracingBackend) Err() error { return tb.err }
+
+func (tb *TracingBackend) log(c *TraceCall) {
+	if tb.err != nil {
+		return
+	}
+	if tb.enc != nil {
+		tb.err = tb.enc.Encode(c)
+	} else {
+		_, tb.err = fmt.Fprintln(tb.w, c.String())
+	}
+}
+
+func (tb *TracingBackend) newID() int {
+	tb.nextID++
+	return tb.nextID
+}
+
+type tracedImage struct {
+	BackendImage
+	tb *TracingBackend
+	id int
+}
+
+func (ti *tracedImage) Delete() {
+	ti.tb.log(&TraceCall{Call: "deleteImage", ID: ti.id})
+	ti.BackendImage.Delete()
+}
+
+func (ti *tracedImage) Replace(src image.Image) error {
+	c := TraceCall{Call: "replaceImage", ID: ti.id}
+	traceRGBA(&c, src)
+	ti.tb.log(&c)
+	return ti.BackendImage.Replace(src)
+}
+
+func (ti *tracedImage) SubImage(rect image.Rectangle) BackendImage {
+	return NewBackendSubImage(ti, rect)
+}
+
+type tracedGradient struct {
+	BackendLinearGradient
+	tb *TracingBackend
+	id int
+}
+
+func (tg *tracedGradient) Delete() {
+	tg.tb.log(&TraceCall{Call: "deleteGradient", ID: tg.id})
+	tg.BackendLinearGradient.Delete()
+}
+
+func (tg *tracedGradient) Replace(data BackendGradient) {
+	tg.tb.log(&TraceCall{Call: "replaceGradient", ID: tg.id, Stops: data})
+	tg.BackendLinearGradient.Replace(data)
+}
+
+type tracedPattern struct {
+	BackendImagePattern
+	tb *TracingBackend
+	id int
+}
+
+func (tp *tracedPattern) Delete() {
+	tp.tb.log(&TraceCall{Call: "deletePattern", ID: tp.id})
+	tp.BackendImagePattern.Delete()
+}
+
+func (tp *tracedPattern) Replace(data BackendImagePatternData) {
+	c := TraceCall{Call: "replacePattern", ID: tp.id}
+	tp.BackendImagePattern.Replace(tp.tb.patternData(&c, data))
+	tp.tb.log(&c)
+}
+
+// traceRGBA sets the data of the call to the RGBA pixels of the image
+func traceRGBA(c *TraceCall, img image.Image) {
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	c.W, c.H, c.Data = b.Dx(), b.Dy(), rgba.Pix
+}
+
+// image returns the id of a traced image and the image of the target
+// backend. Images that were not loaded with the tracing backend have the
+// id 0 and can not be replayed
+func (tb *TracingBackend) image(img BackendImage) (int, BackendImage) {
+	if ti, ok := img.(*tracedImage); ok {
+		return ti.id, ti.BackendImage
+	}
+	return 0, img
+}
+
+// patternData logs the pattern data and returns it with the image of the
+// target backend
+func (tb *TracingBackend) patternData(c *TraceCall, data BackendImagePatternData) BackendImagePatternData {
+	tf := data.Transform
+	c.Transform, c.Repeat = &tf, data.Repeat
+	if data.Repeat == BackendRepeatRound || data.Repeat == BackendRepeatSpace {
+		area := data.Area
+		c.Area = &area
+	}
+	if si, ok := data.Image.(*BackendSubImage); ok {
+		rect := si.Rect()
+		var parent BackendImage
+		c.Image, parent = tb.image(si.Parent())
+		c.Rect = &rect
+		data.Image = NewBackendSubImage(parent, rect)
+	} else {
+		c.Image, data.Image = tb.image(data.Image)
+	}
+	return data
+}
+
+// style logs the style and returns it with the resources of the target
+// backend
+func (tb *TracingBackend) style(c *TraceCall, style *BackendFillStyle) *BackendFillStyle {
+	s := *style
+	ts := &TraceStyle{Color: s.Color, Blur: s.Blur, Dither: s.Dither}
+	if tg, ok := s.LinearGradient.(*tracedGradient); ok {
+		ts.LinearGradient, s.LinearGradient = tg.id, tg.BackendLinearGradient
+	}
+	if tg, ok := s.RadialGradient.(*tracedGradient); ok {
+		ts.RadialGradient, s.RadialGradient = tg.id, tg.BackendLinearGradient
+	}
+	if tp, ok := s.ImagePattern.(*tracedPattern); ok {
+		ts.ImagePattern, s.ImagePattern = tp.id, tp.BackendImagePattern
+	}
+	if s.LinearGradient != nil || s.RadialGradient != nil {
+		g := s.Gradient
+		ts.Gradient = &[6]float64{g.X0, g.Y0, g.X1, g.Y1, g.RadFrom, g.RadTo}
+	}
+	c.Style = ts
+	return &s
+}
+
+func (tb *TracingBackend) Size() (int, int) { return tb.target.Size() }
+
+func (tb *TracingBackend) LoadImage(img image.Image) (BackendImage, error) {
+	bimg, err := tb.target.LoadImage(img)
+	if err != nil {
+		return nil, err
+	}
+	c := TraceCall{Call: "loadImage", ID: tb.newID()}
+	traceRGBA(&c, img)
+	tb.log(&c)
+	return &tracedImage{BackendImage: bimg, tb: tb, id: c.ID}, nil
+}
+
+func (tb *TracingBackend) LoadImagePattern(data BackendImagePatternData) BackendImagePattern {
+	c := TraceCall{Call: "loadPattern", ID: tb.newID()}
+	ip := tb.target.LoadImagePattern(tb.patternData(&c, data))
+	tb.log(&c)
+	return &tracedPattern{BackendImagePattern: ip, tb: tb, id: c.ID}
+}
+
+func (tb *TracingBackend) LoadLinearGradient(data BackendGradient) BackendLinearGradient {
+	c := TraceCall{Call: "loadLinearGradient", ID: tb.newID(), Stops: data}
+	tb.log(&c)
+	return &tracedGradient{BackendLinearGradient: tb.target.LoadLinearGradient(data), tb: tb, id: c.ID}
+}
+
+func (tb *TracingBackend) LoadRadialGradient(data BackendGradient) BackendRadialGradient {
+	c := TraceCall{Call: "loadRadialGradient", ID: tb.newID(), Stops: data}
+	tb.log(&c)
+	return &tracedGradient{BackendLinearGradient: tb.target.LoadRadialGradient(data), tb: tb, id: c.ID}
+}
+
+func (tb *TracingBackend) Clear(pts [4]BackendVec) {
+	tb.log(&TraceCall{Call: "clear", Pts: pts[:]})
+	tb.target.Clear(pts)
+}
+
+func (tb *TracingBackend) Fill(style *BackendFillStyle, pts []BackendVec, tf BackendMat, canOverlap bool) {
+	c := TraceCall{Call: "fill", Pts: pts, Tf: &tf, CanOverlap: canOverlap}
+	s := tb.style(&c, style)
+	tb.log(&c)
+	tb.target.Fill(s, pts, tf, canOverlap)
+}
+
+func (tb *TracingBackend) DrawImage(dimg BackendImage, sx, sy, sw, sh float64, pts [4]BackendVec, alpha float64) {
+	id, bimg := tb.image(dimg)
+	tb.log(&TraceCall{Call: "drawImage", Image: id, SX: sx, SY: sy, SW: sw, SH: sh, Pts: pts[:], Alpha: alpha})
+	tb.target.DrawImage(bimg, sx, sy, sw, sh, pts, alpha)
+}
+
+func (tb *TracingBackend) FillImageMask(style *BackendFillStyle, mask *image.Alpha, pts [4]BackendVec) {
+	w, h := mask.Rect.Dx(), mask.Rect.Dy()
+	data := make([]byte, w*h)
+	for y := 0; y < h; y++ {
+		copy(data[y*w:], mask.Pix[mask.PixOffset(mask.Rect.Min.X, mask.Rect.Min.Y+y):][:w])
+	}
+	c := TraceCall{Call: "fillImageMask", Pts: pts[:], W: w, H: h, Data: data}
+	s := tb.style(&c, style)
+	tb.log(&c)
+	tb.target.FillImageMask(s, mask, pts)
+}
+
+func (tb *TracingBackend) FillTrianglesVertexColor(pts []BackendVec, colors []color.RGBA) {
+	tb.log(&TraceCall{Call: "fillTrianglesVertexColor", Pts: pts, Colors: colors})
+	tb.target.FillTrianglesVertexColor(pts, colors)
+}
+
+func (tb *TracingBackend) ClearClip() {
+	tb.log(&TraceCall{Call: "clearClip"})
+	tb.target.ClearClip()
+}
+
+func (tb *TracingBackend) Clip(pts []BackendVec) {
+	tb.log(&TraceCall{Call: "clip", Pts: pts})
+	tb.target.Clip(pts)
+}
+
+func (tb *TracingBackend) GetImageData(x, y, w, h int) *image.RGBA {
+	tb.log(&TraceCall{Call: "getImageData", X: x, Y: y, W: w, H: h})
+	return tb.target.GetImageData(x, y, w, h)
+}
+
+func (tb *TracingBackend) PutImageData(img *image.RGBA, x, y int) {
+	c := TraceCall{Call: "putImageData", X: x, Y: y}
+	traceRGBA(&c, img)
+	tb.log(&c)
+	tb.target.PutImageData(img, x, y)
+}
+
+func (tb *TracingBackend) CanUseAsImage(b Backend) bool { return false }
+
+// Capabilities returns the capabilities of the target backend, except
+// that a traced backend can not be used as an image
+func (tb *TracingBackend) Capabilities() BackendCapabilities {
+	caps := tb.target.Capabilities()
+	caps.AsImage = false
+	return caps
+}
+
+func (tb *TracingBackend) AsImage() BackendImage { return nil }
+
+func (tb *TracingBackend) BeginFrame() { beginFrame(tb.target) }
+func (tb *TracingBackend) EndFrame()   { endFrame(tb.target) }
+
+// traceReplay holds the resources loaded while replaying a trace
+type traceReplay struct {
+	b         Backend
+	images    map[int]BackendImage
+	gradients map[int]BackendLinearGradient
+	patterns  map[int]BackendImagePattern
+}
+
+// ReplayTrace executes the calls of a JSON trace written by a
+// TracingBackend on the given backend, which can be a different type of
+// backend than the one that was traced. Images, gradients and patterns
+// are loaded from the trace. Calls that read pixels are skipped
+func ReplayTrace(r io.Reader, b Backend) error {
+	rp := &traceReplay{
+		b:         b,
+		images:    make(map[int]BackendImage),
+		gradients: make(map[int]BackendLinearGradient),
+		patterns:  make(map[int]BackendImagePattern),
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<30)
+	for line := 1; sc.Scan(); line++ {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		var c TraceCall
+		if err := json.Unmarshal(sc.Bytes(), &c); err != nil {
+			return fmt.Errorf("Trace line %d: %v: %w", line, err, ErrInvalidTrace)
+		}
+		if err := rp.run(&c); err != nil {
+			return fmt.Errorf("Trace line %d: %w", line, err)
+		}
+	}
+	return sc.Err()
+}
+
+func (rp *traceReplay) rgba(c *TraceCall) (*image.RGBA, error) {
+	if c.W < 0 || c.H < 0 || len(c.Data) != c.W*c.H*4 {
+		return nil, fmt.Errorf("%d bytes of data for %dx%d pixels: %w", len(c.Data), c.W, c.H, ErrInvalidTrace)
+	}
+	return &image.RGBA{Pix: c.Data, Stride: c.W * 4, Rect: image.Rect(0, 0, c.W, c.H)}, nil
+}
+
+func (rp *traceReplay) image(id int) (BackendImage, error) {
+	img, ok := rp.images[id]
+	if !ok {
+		return nil, fmt.Errorf("Unknown image %d: %w", id, ErrInvalidTrace)
+	}
+	return img, nil
+}
+
+func (rp *traceReplay) quad(c *TraceCall) ([4]BackendVec, error) {
+	var quad [4]BackendVec
+	if len(c.Pts) != 4 {
+		return quad, fmt.Errorf("%s with %d points instead of 4: %w", c.Call, len(c.Pts), ErrInvalidTrace)
+	}
+	copy(quad[:], c.Pts)
+	return quad, nil
+}
+
+func (rp *traceReplay) patternData(c *TraceCall) (BackendImagePatternData, error) {
+	var data BackendImagePatternData
+	img, err := rp.image(c.Image)
+	if err != nil {
+		return data, err
+	}
+	if c.Rect != nil {
+		img = NewBackendSubImage(img, *c.Rect)
+	}
+	data.Image = img
+	if c.Transform != nil {
+		data.Transform = *c.Transform
+	}
+	data.Repeat = c.Repeat
+	if c.Area != nil {
+		data.Area = *c.Area
+	}
+	return data, nil
+}
+
+func (rp *traceReplay) style(c *TraceCall) (*BackendFillStyle, error) {
+	var s BackendFillStyle
+	if c.Style == nil {
+		return &s, nil
+	}
+	ts := c.Style
+	s.Color, s.Blur, s.Dither = ts.Color, ts.Blur, ts.Dither
+	var ok bool
+	if ts.LinearGradient != 0 {
+		if s.LinearGradient, ok = rp.gradients[ts.LinearGradient]; !ok {
+			return nil, fmt.Errorf("Unknown gradient %d: %w", ts.LinearGradient, ErrInvalidTrace)
+		}
+	}
+	if ts.RadialGradient != 0 {
+		if s.RadialGradient, ok = rp.gradients[ts.RadialGradient]; !ok {
+			return nil, fmt.Errorf("Unknown gradient %d: %w", ts.RadialGradient, ErrInvalidTrace)
+		}
+	}
+	if ts.ImagePattern != 0 {
+		if s.ImagePattern, ok = rp.patterns[ts.ImagePattern]; !ok {
+			return nil, fmt.Errorf("Unknown pattern %d: %w", ts.ImagePattern, ErrInvalidTrace)
+		}
+	}
+	if g := ts.Gradient; g != nil {
+		s.Gradient.X0, s.Gradient.Y0, s.Gradient.X1, s.Gradient.Y1 = g[0], g[1], g[2], g[3]
+		s.Gradient.RadFrom, s.Gradient.RadTo = g[4], g[5]
+	}
+	return &s, nil
+}
+
+func (rp *traceReplay) run(c *TraceCall) error {
+	b := rp.b
+	switch c.Call {
+	case "loadImage", "replaceImage":
+		rgba, err := rp.rgba(c)
+		if err != nil {
+			return err
+		}
+		if c.Call == "replaceImage" {
+			img, err := rp.image(c.ID)
+			if err != nil {
+				return err
+			}
+			return img.Replace(rgba)
+		}
+		img, err := b.LoadImage(rgba)
+		if err != nil {
+			return err
+		}
+		rp.images[c.ID] = img
+	case "deleteImage":
+		img, err := rp.image(c.ID)
+		if err != nil {
+			return err
+		}
+		img.Delete()
+		delete(rp.images, c.ID)
+	case "loadLinearGradient":
+		rp.gradients[c.ID] = b.LoadLinearGradient(c.Stops)
+	case "loadRadialGradient":
+		rp.gradients[c.ID] = b.LoadRadialGradient(c.Stops)
+	case "replaceGradient", "deleteGradient":
+		g, ok := rp.gradients[c.ID]
+		if !ok {
+			return fmt.Errorf("Unknown gradient %d: %w", c.ID, ErrInvalidTrace)
+		}
+		if c.Call == "deleteGradient" {
+			g.Delete()
+			delete(rp.gradients, c.ID)
+		} else {
+			g.Replace(c.Stops)
+		}
+	case "loadPattern":
+		data, err := rp.patternData(c)
+		if err != nil {
+			return err
+		}
+		rp.patterns[c.ID] = b.LoadImagePattern(data)
+	case "replacePattern", "deletePattern":
+		p, ok := rp.patterns[c.ID]
+		if !ok {
+			return fmt.Errorf("Unknown pattern %d: %w", c.ID, ErrInvalidTrace)
+		}
+		if c.Call == "deletePattern" {
+			p.Delete()
+			delete(rp.patterns, c.ID)
+			return nil
+		}
+		data, err := rp.patternData(c)
+		if err != nil {
+			return err
+		}
+		p.Replace(data)
+	case "clear":
+		quad, err := rp.quad(c)
+		if err != nil {
+			return err
+		}
+		b.Clear(quad)
+	case "fill":
+		s, err := rp.style(c)
+		if err != nil {
+			return err
+		}
+		tf := BackendMatIdentity
+		if c.Tf != nil {
+			tf = *c.Tf
+		}
+		b.Fill(s, c.Pts, tf, c.CanOverlap)
+	case "drawImage":
+		quad, err := rp.quad(c)
+		if err != nil {
+			return err
+		}
+		img, err := rp.image(c.Image)
+		if err != nil {
+			return err
+		}
+		b.DrawImage(img, c.SX, c.SY, c.SW, c.SH, quad, c.Alpha)
+	case "fillImageMask":
+		quad, err := rp.quad(c)
+		if err != nil {
+			return err
+		}
+		s, err := rp.style(c)
+		if err != nil {
+			return err
+		}
+		if c.W < 0 || c.H < 0 || len(c.Data) != c.W*c.H {
+			return fmt.Errorf("%d bytes of mask for %dx%d pixels: %w", len(c.Data), c.W, c.H, ErrInvalidTrace)
+		}
+		b.FillImageMask(s, &image.Alpha{Pix: c.Data, Stride: c.W, Rect: image.Rect(0, 0, c.W, c.H)}, quad)
+	case "fillTrianglesVertexColor":
+		if len(c.Colors) != len(c.Pts) {
+			return fmt.Errorf("%d colors for %d points: %w", len(c.Colors), len(c.Pts), ErrInvalidTrace)
+		}
+		b.FillTrianglesVertexColor(c.Pts, c.Colors)
+	case "clearClip":
+		b.ClearClip()
+	case "clip":
+		b.Clip(c.Pts)
+	case "putImageData":
+		rgba, err := rp.rgba(c)
+		if err != nil {
+			return err
+		}
+		b.PutImageData(rgba, c.X, c.Y)
+	case "getImageData":
+	default:
+		return fmt.Errorf("Unknown call %q: %w", c.Call, ErrInvalidTrace)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+//go:build !canvas_notrace
+// +build !canvas_notrace
+
+package canvas_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"runtime/trace"
+	"strings"
+	"testing"
+
+	"github.com/opentoys/canvas"
+)
+
+func TestTracingBackend(t *testing.T) {
+	draw := func(cv *canvas.Canvas) {
+		grad := cv.CreateLinearGradient(0, 0, 40, 0)
+		grad.AddColorStop(0, "#F00")
+		grad.AddColorStop(1, "#00F")
+		cv.SetFillStyle(grad)
+		cv.FillRect(0, 0, 40, 10)
+
+		src := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		for i := range src.Pix {
+			src.Pix[i] = uint8(i * 16)
+		}
+		img, err := cv.LoadImage(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cv.SetFillStyle(cv.CreatePattern(img, canvas.Repeat))
+		cv.FillRect(0, 10, 20, 10)
+		cv.DrawImage(img.SubImage(image.Rect(1, 1, 3, 3)), 20, 10, 10, 10)
+
+		cv.SetFillStyle("#0F0")
+		cv.SetShadowColor("#000")
+		cv.SetShadowBlur(2)
+		cv.BeginPath()
+		cv.Rect(5, 22, 10, 10)
+		cv.Clip()
+		cv.FillRect(0, 20, 40, 20)
+		cv.Flush()
+	}
+
+	direct := canvas.NewBackend(40, 40)
+	draw(canvas.New(direct))
+
+	var log bytes.Buffer
+	target := canvas.NewBackend(40, 40)
+	tb := canvas.NewTracingBackend(target, &log, canvas.TraceJSON)
+	draw(canvas.New(tb))
+	if tb.Err() != nil {
+		t.Fatal(tb.Err())
+	}
+	if !bytes.Equal(direct.Image.Pix, target.Image.Pix) {
+		t.Fatal("Tracing changed the result")
+	}
+
+	replayed := canvas.NewBackend(40, 40)
+	if err := canvas.ReplayTrace(bytes.NewReader(log.Bytes()), replayed); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(direct.Image.Pix, replayed.Image.Pix) {
+		t.Fatal("Replaying the trace gave a different result")
+	}
+
+	var text bytes.Buffer
+	draw(canvas.New(canvas.NewTracingBackend(canvas.NewBackend(40, 40), &text, canvas.TraceText)))
+	for _, call := range []string{"loadLinearGradient id=1 ", "\nfill style=", "\ndrawImage image=", "data=<64 bytes>", "\nclip pts="} {
+		if !strings.Contains(text.String(), call) {
+			t.Fatalf("Expected %q in the text trace:\n%s", call, text.String())
+		}
+	}
+
+	err := canvas.ReplayTrace(strings.NewReader(`{"call":"drawImage","image":7,"pts":[[0,0],[0,1],[1,1],[1,0]]}`), replayed)
+	if !errors.Is(err, canvas.ErrInvalidTrace) || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("Expected an invalid trace error, got %v", err)
+	}
+}
+
+func TestTraceContext(t *testing.T) {
+	cv := canvas.New(canvas.NewBackend(100, 100))
+	cv.EndFrame()
+	if cv.TraceContext() == nil {
+		t.Fatal("Expected a context without tracing")
+	}
+
+	var buf bytes.Buffer
+	if err := trace.Start(&buf); err != nil {
+		t.Skip(err)
+	}
+	cv.EndFrame()
+	cv.FillRect(0, 0, 10, 10)
+	ctx := cv.TraceContext()
+	cv.EndFrame()
+	trace.Stop()
+	if ctx == context.Background() {
+		t.Fatal("Expected a frame task while tracing")
+	}
+}
@@ -0,0 +1,97 @@
+package canvas
+
+// Unit is a physical unit that drawing coordinates can be given in
+type Unit uint8
+
+// Units for SetUnits
+const (
+	Pixels Unit = iota
+	Millimeters
+	Centimeters
+	Inches
+	Points
+)
+
+// DefaultDPI is the resolution used when no resolution is given, the
+// resolution of CSS pixels
+const DefaultDPI = 96
+
+// perInch returns how many of the unit make up an inch
+func (u Unit) perInch() float64 {
+	switch u {
+	case Millimeters:
+		return 25.4
+	case Centimeters:
+		return 2.54
+	case Points:
+		return 72
+	}
+	return 1
+}
+
+// PixelsPerUnit returns the number of pixels per unit at the resolution
+// in dots per inch. Pixels are always one pixel, regardless of the
+// resolution
+func (u Unit) PixelsPerUnit(dpi float64) float64 {
+	if u == Pixels {
+		return 1
+	}
+	if dpi <= 0 {
+		dpi = DefaultDPI
+	}
+	return dpi / u.perInch()
+}
+
+func (u Unit) String() string {
+	switch u {
+	case Millimeters:
+		return "mm"
+	case Centimeters:
+		return "cm"
+	case Inches:
+		return "in"
+	case Points:
+		return "pt"
+	}
+	return "px"
+}
+
+// SetUnits makes the coordinates of the canvas physical units at the
+// given resolution in dots per inch, so that for example a 300 DPI
+// canvas can be drawn to in millimeters. A resolution of zero is
+// DefaultDPI. The current transformation is reset to the scaling of the
+// units, and SetTransform and Reset keep it as the base, so line widths
+// and font sizes are in the units too. Like in HTML, shadow offsets and
+// blur stay in pixels
+func (cv *Canvas) SetUnits(unit Unit, dpi float64) {
+	cv.unit, cv.dpi = unit, dpi
+	cv.state.transform = cv.unitTransform()
+}
+
+// Units returns the units and the resolution set with SetUnits
+func (cv *Canvas) Units() (Unit, float64) {
+	dpi := cv.dpi
+	if dpi <= 0 {
+		dpi = DefaultDPI
+	}
+	return cv.unit, dpi
+}
+
+// ToPixels converts a length in the units of the canvas to pixels
+func (cv *Canvas) ToPixels(v float64) float64 {
+	return v * cv.unit.PixelsPerUnit(cv.dpi)
+}
+
+// FromPixels converts a length in pixels to the units of the canvas
+func (cv *Canvas) FromPixels(v float64) float64 {
+	return v / cv.unit.PixelsPerUnit(cv.dpi)
+}
+
+// unitTransform returns the base transformation for the units
+func (cv *Canvas) unitTransform() BackendMat {
+	if cv.unit == Pixels {
+		return BackendMatIdentity
+	}
+	s := cv.unit.PixelsPerUnit(cv.dpi)
+	return BackendMatScale(BackendVec{s, s})
+}
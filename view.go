@@ -0,0 +1,67 @@
+package canvas
+
+// viewTransform is the camera set with SetViewTransform. The origin is
+// subtracted from the world coordinates before the rest of the view is
+// applied, so that the large parts of coordinates far from the origin
+// cancel out before they are scaled
+type viewTransform struct {
+	active bool
+	origin BackendVec
+	mat    BackendMat
+}
+
+// SetViewTransform sets a camera for drawing in world coordinates, for
+// example for maps and CAD drawings where coordinates can be far from
+// zero. The world point originX/originY is drawn at x/y on the canvas,
+// scaled by scale and rotated by rotation in radians. The current
+// transformation maps to world coordinates, and paths that are already
+// started keep the view they were started with. Unlike the
+// transformation, the view is not changed by Save and Restore
+func (cv *Canvas) SetViewTransform(originX, originY, scale, rotation, x, y float64) {
+	cv.view = viewTransform{
+		active: true,
+		origin: BackendVec{originX, originY},
+		mat: BackendMatScale(BackendVec{scale, scale}).
+			Mul(BackendMatRotate(rotation)).
+			Mul(BackendMatTranslate(BackendVec{x, y})),
+	}
+}
+
+// ClearViewTransform removes the camera set with SetViewTransform, so
+// that world coordinates are canvas coordinates again
+func (cv *Canvas) ClearViewTransform() {
+	cv.view = viewTransform{}
+}
+
+// WorldToDevice converts world coordinates to canvas coordinates with the
+// view set with SetViewTransform
+func (cv *Canvas) WorldToDevice(x, y float64) (float64, float64) {
+	if !cv.view.active {
+		return x, y
+	}
+	v := BackendVec{x, y}.Sub(cv.view.origin).MulMat(cv.view.mat)
+	return v[0], v[1]
+}
+
+// DeviceToWorld converts canvas coordinates to world coordinates with the
+// view set with SetViewTransform, for example to find out where the
+// mouse points to in the world
+func (cv *Canvas) DeviceToWorld(x, y float64) (float64, float64) {
+	if !cv.view.active {
+		return x, y
+	}
+	v := BackendVec{x, y}.MulMat(cv.view.mat.Invert()).Add(cv.view.origin)
+	return v[0], v[1]
+}
+
+// transform returns the matrix from the current coordinates to canvas
+// coordinates, including the view
+func (cv *Canvas) transform() BackendMat {
+	if !cv.view.active {
+		return cv.state.transform
+	}
+	m := cv.state.transform
+	m[4] -= cv.view.origin[0]
+	m[5] -= cv.view.origin[1]
+	return m.Mul(cv.view.mat)
+}
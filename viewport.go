@@ -0,0 +1,162 @@
+package canvas
+
+import (
+	"math"
+	"time"
+)
+
+// Viewport is a pannable and zoomable view of an infinite world, with
+// the conversion between screen and world coordinates, zooming around
+// the mouse cursor and panning that keeps moving after a drag. Apply
+// sets it as the view transform of the canvas every frame
+type Viewport struct {
+	// CenterX and CenterY are the world point shown at the center of
+	// the canvas
+	CenterX, CenterY float64
+	// Zoom is the size of a world unit in pixels
+	Zoom float64
+	// MinZoom and MaxZoom limit the zoom, zero is no limit
+	MinZoom, MaxZoom float64
+	// Inertia is the part of the panning speed that is left after a
+	// second once a drag ended. Zero stops right away. NewViewport
+	// sets it
+	Inertia float64
+
+	w, h float64
+
+	dragging     bool
+	lastX, lastY float64
+	lastT        time.Time
+	vx, vy       float64
+}
+
+// minInertiaSpeed is the panning speed in pixels per second below which
+// the inertia stops
+const minInertiaSpeed = 5
+
+// NewViewport creates a new viewport centered on the world origin at a
+// zoom of 1
+func NewViewport() *Viewport {
+	return &Viewport{Zoom: 1, Inertia: 0.01}
+}
+
+// Apply sets the view transform of the canvas to the viewport, which
+// also sets the screen size for the conversions to the canvas size. It
+// should be called at the beginning of every frame
+func (vp *Viewport) Apply(cv *Canvas) {
+	w, h := cv.Size()
+	vp.w, vp.h = float64(w), float64(h)
+	cv.SetViewTransform(vp.CenterX, vp.CenterY, vp.Zoom, 0, vp.w/2, vp.h/2)
+}
+
+// ScreenToWorld converts canvas coordinates to world coordinates, for
+// example to find the world point under the mouse
+func (vp *Viewport) ScreenToWorld(x, y float64) (float64, float64) {
+	return vp.CenterX + (x-vp.w/2)/vp.Zoom, vp.CenterY + (y-vp.h/2)/vp.Zoom
+}
+
+// WorldToScreen converts world coordinates to canvas coordinates
+func (vp *Viewport) WorldToScreen(x, y float64) (float64, float64) {
+	return (x-vp.CenterX)*vp.Zoom + vp.w/2, (y-vp.CenterY)*vp.Zoom + vp.h/2
+}
+
+// VisibleBounds returns the part of the world that is shown on the
+// canvas, to skip drawing what is off screen
+func (vp *Viewport) VisibleBounds() Bounds {
+	x0, y0 := vp.ScreenToWorld(0, 0)
+	x1, y1 := vp.ScreenToWorld(vp.w, vp.h)
+	return Bounds{MinX: x0, MinY: y0, MaxX: x1, MaxY: y1}
+}
+
+// Pan moves the view by dx/dy pixels, so that the world moves along
+// with a dragging mouse
+func (vp *Viewport) Pan(dx, dy float64) {
+	vp.CenterX -= dx / vp.Zoom
+	vp.CenterY -= dy / vp.Zoom
+}
+
+// ZoomAt multiplies the zoom by factor, keeping the world point at the
+// canvas coordinates x/y in place
+func (vp *Viewport) ZoomAt(factor, x, y float64) {
+	wx, wy := vp.ScreenToWorld(x, y)
+	zoom := vp.Zoom * factor
+	if vp.MinZoom > 0 {
+		zoom = math.Max(zoom, vp.MinZoom)
+	}
+	if vp.MaxZoom > 0 {
+		zoom = math.Min(zoom, vp.MaxZoom)
+	}
+	vp.Zoom = zoom
+	vp.CenterX = wx - (x-vp.w/2)/zoom
+	vp.CenterY = wy - (y-vp.h/2)/zoom
+}
+
+// Wheel zooms around the cursor at x/y for a mouse wheel movement of
+// deltaY, in the units of the browser wheel event. A delta of 100, one
+// step of most mouse wheels, zooms out by about 13%, negative deltas
+// zoom in
+func (vp *Viewport) Wheel(deltaY, x, y float64) {
+	vp.ZoomAt(math.Pow(2, -deltaY/500), x, y)
+}
+
+// BeginDrag starts panning with the mouse at x/y, which stops the
+// inertia of an earlier drag
+func (vp *Viewport) BeginDrag(x, y float64) {
+	vp.dragging = true
+	vp.lastX, vp.lastY, vp.lastT = x, y, time.Now()
+	vp.vx, vp.vy = 0, 0
+}
+
+// Drag pans the view along with the mouse moving to x/y
+func (vp *Viewport) Drag(x, y float64) {
+	if !vp.dragging {
+		return
+	}
+	now := time.Now()
+	dx, dy := x-vp.lastX, y-vp.lastY
+	vp.Pan(dx, dy)
+	if dt := now.Sub(vp.lastT).Seconds(); dt > 0 {
+		// smooth the speed over the last moves
+		vp.vx = vp.vx*0.2 + dx/dt*0.8
+		vp.vy = vp.vy*0.2 + dy/dt*0.8
+	}
+	vp.lastX, vp.lastY, vp.lastT = x, y, now
+}
+
+// EndDrag ends panning with the mouse. The view keeps moving with the
+// speed of the drag and slows down with the Inertia, unless the mouse
+// rested before it was released
+func (vp *Viewport) EndDrag() {
+	vp.dragging = false
+	if vp.Inertia <= 0 || time.Since(vp.lastT) > 100*time.Millisecond {
+		vp.vx, vp.vy = 0, 0
+	}
+}
+
+// Update moves the view by the inertia of the last drag for the elapsed
+// time since the last frame. It returns whether the view is still
+// moving, so that the application knows to draw another frame
+func (vp *Viewport) Update(elapsed time.Duration) bool {
+	if vp.dragging || (vp.vx == 0 && vp.vy == 0) {
+		return false
+	}
+	dt := elapsed.Seconds()
+	if vp.Inertia <= 0 {
+		vp.vx, vp.vy = 0, 0
+		return false
+	} else if vp.Inertia >= 1 {
+		vp.Pan(vp.vx*dt, vp.vy*dt)
+		return true
+	}
+	// the speed decays exponentially, the distance is its integral
+	k := math.Log(vp.Inertia)
+	decay := math.Exp(k * dt)
+	vp.Pan(vp.vx*(decay-1)/k, vp.vy*(decay-1)/k)
+	vp.vx *= decay
+	vp.vy *= decay
+	if math.Hypot(vp.vx, vp.vy) < minInertiaSpeed {
+		vp.vx, vp.vy = 0, 0
+		return false
+	}
+	return true
+}
@@ -0,0 +1,378 @@
+package vnc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+)
+
+// Client to server message types
+const (
+	msgSetPixelFormat           = 0
+	msgSetEncodings             = 2
+	msgFramebufferUpdateRequest = 3
+	msgKeyEvent                 = 4
+	msgPointerEvent             = 5
+	msgClientCutText            = 6
+)
+
+// Encodings
+const (
+	encodingRaw         = 0
+	encodingDesktopSize = -223
+)
+
+// pixelFormat is the RFB description of the pixels a viewer wants
+type pixelFormat struct {
+	bpp, depth                uint8
+	bigEndian, trueColor      bool
+	redMax, greenMax, blueMax uint16
+	redShift, greenShift      uint8
+	blueShift                 uint8
+}
+
+// defaultFormat is 32 bit little-endian xRGB
+var defaultFormat = pixelFormat{
+	bpp: 32, depth: 24, trueColor: true,
+	redMax: 255, greenMax: 255, blueMax: 255,
+	redShift: 16, greenShift: 8, blueShift: 0,
+}
+
+func (pf pixelFormat) marshal() []byte {
+	b := make([]byte, 16)
+	b[0], b[1] = pf.bpp, pf.depth
+	if pf.bigEndian {
+		b[2] = 1
+	}
+	if pf.trueColor {
+		b[3] = 1
+	}
+	binary.BigEndian.PutUint16(b[4:], pf.redMax)
+	binary.BigEndian.PutUint16(b[6:], pf.greenMax)
+	binary.BigEndian.PutUint16(b[8:], pf.blueMax)
+	b[10], b[11], b[12] = pf.redShift, pf.greenShift, pf.blueShift
+	return b
+}
+
+func unmarshalPixelFormat(b []byte) (pixelFormat, error) {
+	pf := pixelFormat{
+		bpp: b[0], depth: b[1], bigEndian: b[2] != 0, trueColor: b[3] != 0,
+		redMax:   binary.BigEndian.Uint16(b[4:]),
+		greenMax: binary.BigEndian.Uint16(b[6:]),
+		blueMax:  binary.BigEndian.Uint16(b[8:]),
+		redShift: b[10], greenShift: b[11], blueShift: b[12],
+	}
+	if !pf.trueColor {
+		return pf, errors.New("Color map pixel formats are not supported")
+	}
+	if pf.bpp != 8 && pf.bpp != 16 && pf.bpp != 32 {
+		return pf, fmt.Errorf("Unsupported pixel format with %d bits per pixel", pf.bpp)
+	}
+	return pf, nil
+}
+
+// put appends the pixel in the format of the viewer
+func (pf pixelFormat) put(dst []byte, r, g, b uint8) []byte {
+	scale := func(v uint8, max uint16, shift uint8) uint32 {
+		return (uint32(v)*uint32(max) + 127) / 255 << shift
+	}
+	v := scale(r, pf.redMax, pf.redShift) | scale(g, pf.greenMax, pf.greenShift) | scale(b, pf.blueMax, pf.blueShift)
+	n := int(pf.bpp / 8)
+	for i := 0; i < n; i++ {
+		shift := uint(i) * 8
+		if pf.bigEndian {
+			shift = uint(n-1-i) * 8
+		}
+		dst = append(dst, uint8(v>>shift))
+	}
+	return dst
+}
+
+// client is the state of a connected viewer
+type client struct {
+	conn net.Conn
+	wake chan struct{}
+
+	mu          sync.Mutex
+	pf          pixelFormat
+	desktopSize bool
+	size        image.Rectangle
+	requested   bool
+	dirty       []image.Rectangle
+}
+
+// invalidate marks the rectangles to be sent with the next update
+func (c *client) invalidate(rects []image.Rectangle) {
+	c.mu.Lock()
+	c.dirty = mergeDirty(c.dirty, rects)
+	c.mu.Unlock()
+	c.signal()
+}
+
+func (c *client) signal() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ServeConn runs the protocol on the connection until the viewer
+// disconnects or an error occurs, and closes the connection
+func (s *Server) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	if err := handshake(conn, r); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errors.New("Server is closed")
+	}
+	c := &client{conn: conn, wake: make(chan struct{}, 1), pf: defaultFormat, size: s.fb.Rect}
+	init := make([]byte, 4, 24+len(s.Name))
+	binary.BigEndian.PutUint16(init, uint16(s.fb.Rect.Dx()))
+	binary.BigEndian.PutUint16(init[2:], uint16(s.fb.Rect.Dy()))
+	init = append(init, c.pf.marshal()...)
+	init = append(init, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(init[len(init)-4:], uint32(len(s.Name)))
+	init = append(init, s.Name...)
+	s.clients[c] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+	}()
+
+	if _, err := conn.Write(init); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	writeErr := make(chan error, 1)
+	go func() {
+		writeErr <- s.writeUpdates(c, done)
+	}()
+	err := s.readMessages(c, r)
+	close(done)
+	conn.Close()
+	if werr := <-writeErr; err == io.EOF || err == nil {
+		err = werr
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+// handshake negotiates the protocol version and the security type and
+// reads the client init message. All viewers share the framebuffer, so
+// the shared flag is ignored
+func handshake(conn net.Conn, r *bufio.Reader) error {
+	if _, err := io.WriteString(conn, "RFB 003.008\n"); err != nil {
+		return err
+	}
+	var version [12]byte
+	if _, err := io.ReadFull(r, version[:]); err != nil {
+		return err
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(string(version[:]), "RFB %03d.%03d\n", &major, &minor); err != nil || major != 3 {
+		return fmt.Errorf("Unsupported protocol version %q", version[:11])
+	}
+	if minor < 7 {
+		// the server decides on the security type
+		if _, err := conn.Write([]byte{0, 0, 0, 1}); err != nil {
+			return err
+		}
+	} else {
+		if _, err := conn.Write([]byte{1, 1}); err != nil {
+			return err
+		}
+		sec, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if sec != 1 {
+			if minor >= 8 {
+				reason := "Unsupported security type"
+				msg := []byte{0, 0, 0, 1, 0, 0, 0, byte(len(reason))}
+				conn.Write(append(msg, reason...))
+			}
+			return fmt.Errorf("Unsupported security type %d", sec)
+		}
+		if minor >= 8 {
+			if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := r.ReadByte()
+	return err
+}
+
+// readMessages handles the messages of the viewer
+func (s *Server) readMessages(c *client, r *bufio.Reader) error {
+	buf := make([]byte, 20)
+	for {
+		typ, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case msgSetPixelFormat:
+			if _, err := io.ReadFull(r, buf[:19]); err != nil {
+				return err
+			}
+			pf, err := unmarshalPixelFormat(buf[3:19])
+			if err != nil {
+				return err
+			}
+			c.mu.Lock()
+			c.pf = pf
+			c.mu.Unlock()
+		case msgSetEncodings:
+			if _, err := io.ReadFull(r, buf[:3]); err != nil {
+				return err
+			}
+			n := int(binary.BigEndian.Uint16(buf[1:]))
+			desktopSize := false
+			for i := 0; i < n; i++ {
+				if _, err := io.ReadFull(r, buf[:4]); err != nil {
+					return err
+				}
+				if int32(binary.BigEndian.Uint32(buf)) == encodingDesktopSize {
+					desktopSize = true
+				}
+			}
+			c.mu.Lock()
+			c.desktopSize = desktopSize
+			c.mu.Unlock()
+		case msgFramebufferUpdateRequest:
+			if _, err := io.ReadFull(r, buf[:9]); err != nil {
+				return err
+			}
+			c.mu.Lock()
+			if buf[0] == 0 {
+				x, y := int(binary.BigEndian.Uint16(buf[1:])), int(binary.BigEndian.Uint16(buf[3:]))
+				w, h := int(binary.BigEndian.Uint16(buf[5:])), int(binary.BigEndian.Uint16(buf[7:]))
+				area := image.Rect(x, y, x+w, y+h).Intersect(c.size)
+				if area.Empty() {
+					area = c.size
+				}
+				c.dirty = mergeDirty(c.dirty, []image.Rectangle{area})
+			}
+			c.requested = true
+			c.mu.Unlock()
+			c.signal()
+		case msgKeyEvent:
+			if _, err := io.ReadFull(r, buf[:7]); err != nil {
+				return err
+			}
+			if s.OnKey != nil {
+				s.OnKey(binary.BigEndian.Uint32(buf[3:]), buf[0] != 0)
+			}
+		case msgPointerEvent:
+			if _, err := io.ReadFull(r, buf[:5]); err != nil {
+				return err
+			}
+			if s.OnPointer != nil {
+				s.OnPointer(int(binary.BigEndian.Uint16(buf[1:])), int(binary.BigEndian.Uint16(buf[3:])), buf[0])
+			}
+		case msgClientCutText:
+			if _, err := io.ReadFull(r, buf[:7]); err != nil {
+				return err
+			}
+			n := int64(binary.BigEndian.Uint32(buf[3:]))
+			if _, err := io.CopyN(ioutil.Discard, r, n); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("Unknown message type %d", typ)
+		}
+	}
+}
+
+// writeUpdates sends the dirty rectangles whenever the viewer has
+// requested an update
+func (s *Server) writeUpdates(c *client, done chan struct{}) error {
+	var msg []byte
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-c.wake:
+		}
+
+		s.mu.Lock()
+		c.mu.Lock()
+		if !c.requested || (len(c.dirty) == 0 && c.size == s.fb.Rect) {
+			c.mu.Unlock()
+			s.mu.Unlock()
+			continue
+		}
+		var resize bool
+		if c.size != s.fb.Rect && c.desktopSize {
+			resize = true
+			c.size = s.fb.Rect
+			c.dirty = append(c.dirty[:0], c.size)
+		}
+		rects := make([]image.Rectangle, 0, len(c.dirty))
+		for _, r := range c.dirty {
+			r = r.Intersect(c.size).Intersect(s.fb.Rect)
+			if !r.Empty() {
+				rects = append(rects, r)
+			}
+		}
+		c.dirty = c.dirty[:0]
+		if len(rects) == 0 && !resize {
+			c.mu.Unlock()
+			s.mu.Unlock()
+			continue
+		}
+		c.requested = false
+
+		count := len(rects)
+		if resize {
+			count++
+		}
+		msg = append(msg[:0], 0, 0, byte(count>>8), byte(count))
+		if resize {
+			msg = appendRectHeader(msg, c.size, encodingDesktopSize)
+		}
+		for _, r := range rects {
+			msg = appendRectHeader(msg, r, encodingRaw)
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				row := s.fb.Pix[s.fb.PixOffset(r.Min.X, y):][:r.Dx()*4]
+				for x := 0; x < len(row); x += 4 {
+					msg = c.pf.put(msg, row[x], row[x+1], row[x+2])
+				}
+			}
+		}
+		c.mu.Unlock()
+		s.mu.Unlock()
+
+		if _, err := c.conn.Write(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func appendRectHeader(msg []byte, r image.Rectangle, encoding int32) []byte {
+	var b [12]byte
+	binary.BigEndian.PutUint16(b[0:], uint16(r.Min.X))
+	binary.BigEndian.PutUint16(b[2:], uint16(r.Min.Y))
+	binary.BigEndian.PutUint16(b[4:], uint16(r.Dx()))
+	binary.BigEndian.PutUint16(b[6:], uint16(r.Dy()))
+	binary.BigEndian.PutUint32(b[8:], uint32(encoding))
+	return append(msg, b[:]...)
+}
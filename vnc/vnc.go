@@ -0,0 +1,184 @@
+// Package vnc serves the content of a canvas over the RFB protocol used
+// by VNC viewers, so that headless services can expose a live view of
+// what they draw. After each frame the application passes the dirty
+// rectangles, for example from a DamageTracker or a RedrawScheduler, to
+// Update, and every viewer only receives the parts that changed since
+// its last update. Pointer and key events of the viewers are passed to
+// optional callbacks.
+//
+// The server supports the protocol versions 3.3, 3.7 and 3.8 without
+// authentication and the raw encoding, so it should only be exposed on
+// trusted networks or through a tunnel
+package vnc
+
+import (
+	"image"
+	"image/draw"
+	"net"
+	"sync"
+
+	"github.com/opentoys/canvas"
+)
+
+// maxDirty is the number of dirty rectangles per viewer after which they
+// are merged into their bounding rectangle
+const maxDirty = 64
+
+// Server serves a framebuffer to VNC viewers
+type Server struct {
+	// Name is the desktop name shown by the viewers
+	Name string
+	// OnPointer is called with the position and the pressed buttons,
+	// one bit per button, when a viewer moves the pointer or clicks
+	OnPointer func(x, y int, buttons uint8)
+	// OnKey is called when a viewer presses or releases a key, with the
+	// X11 keysym of the key
+	OnKey func(keysym uint32, down bool)
+
+	mu      sync.Mutex
+	fb      *image.RGBA
+	clients map[*client]struct{}
+	closed  bool
+}
+
+// NewServer creates a server with a black framebuffer of the given size
+func NewServer(w, h int) *Server {
+	return &Server{
+		Name:    "canvas",
+		fb:      image.NewRGBA(image.Rect(0, 0, w, h)),
+		clients: make(map[*client]struct{}),
+	}
+}
+
+// Size returns the size of the framebuffer
+func (s *Server) Size() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fb.Rect.Dx(), s.fb.Rect.Dy()
+}
+
+// Update copies the dirty rectangles of the canvas into the framebuffer
+// and sends them to the viewers that requested an update. Without dirty
+// rectangles the whole canvas is copied. If the size of the canvas
+// changed, the framebuffer is resized and the viewers are sent the whole
+// framebuffer
+func (s *Server) Update(cv *canvas.Canvas, dirty ...image.Rectangle) {
+	w, h := cv.Size()
+	bounds := image.Rect(0, 0, w, h)
+	if len(dirty) == 0 {
+		dirty = []image.Rectangle{bounds}
+	}
+	imgs := make([]*image.RGBA, 0, len(dirty))
+	for _, r := range dirty {
+		r = r.Intersect(bounds)
+		if !r.Empty() {
+			imgs = append(imgs, cv.GetImageData(r.Min.X, r.Min.Y, r.Dx(), r.Dy()))
+		}
+	}
+	s.update(bounds, imgs)
+}
+
+// UpdateImage is like Update, with the pixels taken from an image whose
+// bounds are the size of the framebuffer. The dirty rectangles are in the
+// coordinates of the image, which don't have to start at 0/0
+func (s *Server) UpdateImage(img image.Image, dirty ...image.Rectangle) {
+	bounds := img.Bounds()
+	if len(dirty) == 0 {
+		dirty = []image.Rectangle{bounds}
+	}
+	imgs := make([]*image.RGBA, 0, len(dirty))
+	for _, r := range dirty {
+		r = r.Intersect(bounds)
+		if !r.Empty() {
+			// the parts are in framebuffer coordinates
+			part := image.NewRGBA(r.Sub(bounds.Min))
+			draw.Draw(part, part.Rect, img, r.Min, draw.Src)
+			imgs = append(imgs, part)
+		}
+	}
+	s.update(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), imgs)
+}
+
+func (s *Server) update(size image.Rectangle, parts []*image.RGBA) {
+	s.mu.Lock()
+	resized := s.fb.Rect != size
+	if resized {
+		fb := image.NewRGBA(size)
+		draw.Draw(fb, size, s.fb, image.Point{}, draw.Src)
+		s.fb = fb
+	}
+	dirty := make([]image.Rectangle, 0, len(parts))
+	for _, part := range parts {
+		draw.Draw(s.fb, part.Rect, part, part.Rect.Min, draw.Src)
+		dirty = append(dirty, part.Rect)
+	}
+	if resized {
+		dirty = []image.Rectangle{size}
+	}
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range clients {
+		c.invalidate(dirty)
+	}
+}
+
+// Serve accepts connections on the listener and serves each of them in
+// its own goroutine, until the listener is closed
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// Close disconnects all viewers
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	clients := s.clients
+	s.clients = make(map[*client]struct{})
+	s.mu.Unlock()
+	for c := range clients {
+		c.conn.Close()
+	}
+	return nil
+}
+
+// mergeDirty adds the rectangles to the list, dropping the ones that are
+// contained in others and merging all of them if there are too many
+func mergeDirty(list []image.Rectangle, add []image.Rectangle) []image.Rectangle {
+	for _, r := range add {
+		if r.Empty() {
+			continue
+		}
+		contained := false
+		kept := list[:0]
+		for _, o := range list {
+			if r.In(o) {
+				contained = true
+			}
+			if !o.In(r) {
+				kept = append(kept, o)
+			}
+		}
+		list = kept
+		if !contained {
+			list = append(list, r)
+		}
+	}
+	if len(list) > maxDirty {
+		union := image.Rectangle{}
+		for _, r := range list {
+			union = union.Union(r)
+		}
+		list = append(list[:0], union)
+	}
+	return list
+}
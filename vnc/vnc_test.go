@@ -0,0 +1,217 @@
+package vnc_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/opentoys/canvas"
+	"github.com/opentoys/canvas/vnc"
+)
+
+type rect struct {
+	r        image.Rectangle
+	encoding int32
+	pix      []byte
+}
+
+type viewer struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	w, h int
+}
+
+func connect(t *testing.T, s *vnc.Server, version string) *viewer {
+	server, conn := net.Pipe()
+	go s.ServeConn(server)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	v := &viewer{t: t, conn: conn, r: bufio.NewReader(conn)}
+
+	v.read(12)
+	conn.Write([]byte(version))
+	if version == "RFB 003.003\n" {
+		if sec := binary.BigEndian.Uint32(v.read(4)); sec != 1 {
+			t.Fatalf("Expected security type 1, got %d", sec)
+		}
+	} else {
+		if types := v.read(2); types[0] != 1 || types[1] != 1 {
+			t.Fatalf("Expected only security type 1, got %v", types)
+		}
+		conn.Write([]byte{1})
+		if version == "RFB 003.008\n" {
+			if result := binary.BigEndian.Uint32(v.read(4)); result != 0 {
+				t.Fatalf("Security result %d", result)
+			}
+		}
+	}
+	conn.Write([]byte{1})
+	init := v.read(24)
+	v.w, v.h = int(binary.BigEndian.Uint16(init)), int(binary.BigEndian.Uint16(init[2:]))
+	v.read(int(binary.BigEndian.Uint32(init[20:])))
+	return v
+}
+
+func (v *viewer) read(n int) []byte {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(v.r, b); err != nil {
+		v.t.Fatalf("Failed to read from the server: %v", err)
+	}
+	return b
+}
+
+func (v *viewer) request(incremental bool, r image.Rectangle) {
+	msg := make([]byte, 10)
+	msg[0] = 3
+	if incremental {
+		msg[1] = 1
+	}
+	binary.BigEndian.PutUint16(msg[2:], uint16(r.Min.X))
+	binary.BigEndian.PutUint16(msg[4:], uint16(r.Min.Y))
+	binary.BigEndian.PutUint16(msg[6:], uint16(r.Dx()))
+	binary.BigEndian.PutUint16(msg[8:], uint16(r.Dy()))
+	v.conn.Write(msg)
+}
+
+func (v *viewer) update(bpp int) []rect {
+	head := v.read(4)
+	if head[0] != 0 {
+		v.t.Fatalf("Expected a framebuffer update, got message type %d", head[0])
+	}
+	rects := make([]rect, binary.BigEndian.Uint16(head[2:]))
+	for i := range rects {
+		h := v.read(12)
+		x, y := int(binary.BigEndian.Uint16(h)), int(binary.BigEndian.Uint16(h[2:]))
+		w, ht := int(binary.BigEndian.Uint16(h[4:])), int(binary.BigEndian.Uint16(h[6:]))
+		rects[i].r = image.Rect(x, y, x+w, y+ht)
+		rects[i].encoding = int32(binary.BigEndian.Uint32(h[8:]))
+		if rects[i].encoding == 0 {
+			rects[i].pix = v.read(w * ht * bpp)
+		}
+	}
+	return rects
+}
+
+func TestUpdates(t *testing.T) {
+	cv := canvas.New(canvas.NewBackend(16, 8))
+	cv.SetFillStyle("#F00")
+	cv.FillRect(0, 0, 16, 8)
+
+	s := vnc.NewServer(16, 8)
+	s.Update(cv)
+	v := connect(t, s, "RFB 003.008\n")
+	defer v.conn.Close()
+	if v.w != 16 || v.h != 8 {
+		t.Fatalf("Expected size 16x8, got %dx%d", v.w, v.h)
+	}
+
+	v.request(false, image.Rect(0, 0, 16, 8))
+	rects := v.update(4)
+	if len(rects) != 1 || rects[0].r != image.Rect(0, 0, 16, 8) {
+		t.Fatalf("Expected a full update, got %v", rects)
+	}
+	// default format is little-endian xRGB
+	if p := rects[0].pix[:4]; p[0] != 0 || p[1] != 0 || p[2] != 255 {
+		t.Errorf("Expected red, got %v", p)
+	}
+
+	// only the dirty part is sent with an incremental update
+	v.request(true, image.Rect(0, 0, 16, 8))
+	cv.SetFillStyle("#00F")
+	cv.FillRect(4, 2, 3, 2)
+	s.Update(cv, image.Rect(4, 2, 7, 4))
+	rects = v.update(4)
+	if len(rects) != 1 || rects[0].r != image.Rect(4, 2, 7, 4) {
+		t.Fatalf("Expected the dirty rectangle, got %v", rects)
+	}
+	for i := 0; i < len(rects[0].pix); i += 4 {
+		if p := rects[0].pix[i : i+4]; p[0] != 255 || p[2] != 0 {
+			t.Fatalf("Expected blue, got %v", p)
+		}
+	}
+
+	// 16 bit RGB565 big-endian
+	v.conn.Write([]byte{0, 0, 0, 0, 16, 16, 1, 1, 0, 31, 0, 63, 0, 31, 11, 5, 0, 0, 0, 0})
+	v.request(false, image.Rect(0, 0, 1, 1))
+	rects = v.update(2)
+	if len(rects) != 1 || rects[0].r != image.Rect(0, 0, 1, 1) {
+		t.Fatalf("Expected one pixel, got %v", rects)
+	}
+	if p := rects[0].pix; p[0] != 0xF8 || p[1] != 0 {
+		t.Errorf("Expected red in RGB565, got %x", p)
+	}
+}
+
+func TestResize(t *testing.T) {
+	s := vnc.NewServer(4, 4)
+	v := connect(t, s, "RFB 003.007\n")
+	defer v.conn.Close()
+	// raw and desktop size encodings
+	v.conn.Write([]byte{2, 0, 0, 2, 0, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0x21})
+	v.request(true, image.Rect(0, 0, 4, 4))
+
+	img := image.NewRGBA(image.Rect(0, 0, 6, 5))
+	img.Set(5, 4, color.RGBA{0, 255, 0, 255})
+	s.UpdateImage(img, image.Rect(5, 4, 6, 5))
+	rects := v.update(4)
+	if len(rects) != 2 || rects[0].encoding != -223 || rects[0].r != image.Rect(0, 0, 6, 5) {
+		t.Fatalf("Expected a desktop size change, got %v", rects)
+	}
+	if rects[1].r != image.Rect(0, 0, 6, 5) || rects[1].pix[(4*6+5)*4+1] != 255 {
+		t.Errorf("Expected the whole resized framebuffer, got %v", rects[1].r)
+	}
+}
+
+func TestUpdateSubImage(t *testing.T) {
+	s := vnc.NewServer(4, 4)
+	v := connect(t, s, "RFB 003.008\n")
+	defer v.conn.Close()
+	v.request(true, image.Rect(0, 0, 4, 4))
+
+	full := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	full.Set(7, 8, color.RGBA{0, 255, 0, 255})
+	img := full.SubImage(image.Rect(6, 6, 10, 10))
+	s.UpdateImage(img, image.Rect(7, 8, 8, 9))
+	rects := v.update(4)
+	if len(rects) != 1 || rects[0].r != image.Rect(1, 2, 2, 3) {
+		t.Fatalf("Expected the dirty rectangle relative to the image origin, got %v", rects)
+	}
+	if p := rects[0].pix; p[1] != 255 {
+		t.Errorf("Expected green, got %v", p)
+	}
+}
+
+func TestInput(t *testing.T) {
+	s := vnc.NewServer(4, 4)
+	events := make(chan string, 2)
+	s.OnPointer = func(x, y int, buttons uint8) {
+		if x == 3 && y == 2 && buttons == 1 {
+			events <- "pointer"
+		}
+	}
+	s.OnKey = func(keysym uint32, down bool) {
+		if keysym == 'a' && down {
+			events <- "key"
+		}
+	}
+	v := connect(t, s, "RFB 003.003\n")
+	defer v.conn.Close()
+	v.conn.Write([]byte{6, 0, 0, 0, 0, 0, 0, 2, 'h', 'i'})
+	v.conn.Write([]byte{5, 1, 0, 3, 0, 2})
+	v.conn.Write([]byte{4, 1, 0, 0, 0, 0, 0, 'a'})
+	for _, want := range []string{"pointer", "key"} {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Errorf("Expected %s event, got %s", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No %s event", want)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package canvas
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// YUVColorSpace selects the conversion of YUV values to RGB
+type YUVColorSpace uint8
+
+// YUV color space constants
+const (
+	// YUVBT601 is the limited range BT.601 color space of SD video
+	YUVBT601 YUVColorSpace = iota
+	// YUVBT709 is the limited range BT.709 color space of HD video
+	YUVBT709
+	// YUVFullRange is the full range BT.601 color space used by JPEG
+	YUVFullRange
+)
+
+// YUVFormat describes the layout of the planes of a YUV image
+type YUVFormat struct {
+	Width, Height int
+	// Subsampling is the chroma subsampling of the U and V planes.
+	// 4:2:0, 4:2:2 and 4:4:4 are supported
+	Subsampling image.YCbCrSubsampleRatio
+	// YStride and CStride are the bytes per row of the Y and of the U
+	// and V planes. Zero means that the rows are tightly packed
+	YStride, CStride int
+	ColorSpace       YUVColorSpace
+}
+
+// chromaSize returns the size of the U and V planes
+func (f YUVFormat) chromaSize() (int, int) {
+	switch f.Subsampling {
+	case image.YCbCrSubsampleRatio420:
+		return (f.Width + 1) / 2, (f.Height + 1) / 2
+	case image.YCbCrSubsampleRatio422:
+		return (f.Width + 1) / 2, f.Height
+	}
+	return f.Width, f.Height
+}
+
+// strides returns the strides with the zero values filled in
+func (f YUVFormat) strides() (int, int) {
+	ys, cs := f.YStride, f.CStride
+	if ys == 0 {
+		ys = f.Width
+	}
+	if cs == 0 {
+		cs, _ = f.chromaSize()
+	}
+	return ys, cs
+}
+
+// validate checks that the planes are large enough for the format
+func (f YUVFormat) validate(y, u, v []byte) error {
+	switch f.Subsampling {
+	case image.YCbCrSubsampleRatio420, image.YCbCrSubsampleRatio422, image.YCbCrSubsampleRatio444:
+	default:
+		return fmt.Errorf("Unsupported YUV subsampling %v", f.Subsampling)
+	}
+	if f.Width <= 0 || f.Height <= 0 {
+		return errors.New("Invalid YUV image size")
+	}
+	cw, ch := f.chromaSize()
+	ys, cs := f.strides()
+	if ys < f.Width || cs < cw {
+		return errors.New("YUV stride smaller than the image")
+	}
+	if len(y) < ys*(f.Height-1)+f.Width || len(u) < cs*(ch-1)+cw || len(v) < cs*(ch-1)+cw {
+		return errors.New("YUV planes smaller than the image")
+	}
+	return nil
+}
+
+// YUVBackend is implemented by backends that can draw YUV images
+// directly, for example by sampling the planes in a shader. For other
+// backends the image is converted to RGBA when it is loaded
+type YUVBackend interface {
+	LoadImageYUV(y, u, v []byte, format YUVFormat) (BackendImage, error)
+}
+
+func loadImageYUV(b Backend, y, u, v []byte, format YUVFormat) (BackendImage, error) {
+	if yb, ok := b.(YUVBackend); ok {
+		return yb.LoadImageYUV(y, u, v, format)
+	}
+	return b.LoadImage(newYUVImage(y, u, v, format).rgba())
+}
+
+// LoadImageYUV loads an image from the Y, U and V planes of a decoded
+// video frame. Backends that support it draw the planes directly, so
+// that frames don't have to be converted to RGBA first. The planes may
+// be reused after the call. The image is not cached, for video use
+// ReplaceYUV to update it with every frame
+func (cv *Canvas) LoadImageYUV(y, u, v []byte, format YUVFormat) (*Image, error) {
+	if err := format.validate(y, u, v); err != nil {
+		return nil, err
+	}
+	bimg, err := loadImageYUV(cv.b, y, u, v, format)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{cv: cv, img: bimg, mipmap: MipmapBox, data: yuvData(bimg, y, u, v, format)}, nil
+}
+
+// ReplaceYUV replaces the image with the planes of the next video frame
+func (img *Image) ReplaceYUV(y, u, v []byte, format YUVFormat) error {
+	if img.deleted {
+		return ErrImageDeleted
+	}
+	if err := format.validate(y, u, v); err != nil {
+		return err
+	}
+	bimg, err := loadImageYUV(img.cv.b, y, u, v, format)
+	if err != nil {
+		return err
+	}
+	if img.img != nil {
+		img.releaseImage()
+	}
+	img.img = bimg
+	img.data = yuvData(bimg, y, u, v, format)
+	img.alphaMask = nil
+	return nil
+}
+
+// yuvData returns the pixels of a YUV image for shadows and the fallbacks
+// of tinted images, shared with the backend image if possible
+func yuvData(bimg BackendImage, y, u, v []byte, format YUVFormat) image.Image {
+	if si, ok := bimg.(*SoftwareImage); ok {
+		return si.mips[0]
+	}
+	return newYUVImage(y, u, v, format)
+}
+
+// yuvImage is an image.Image that converts the pixels of YUV planes to
+// RGB when they are read. Unlike image.YCbCr it supports the video color
+// spaces
+type yuvImage struct {
+	y, u, v          []byte
+	w, h             int
+	yStride, cStride int
+	sub              image.YCbCrSubsampleRatio
+	coef             *yuvCoefficients
+}
+
+// yuvCoefficients are the conversion factors of a color space in 16.16
+// fixed point
+type yuvCoefficients struct {
+	yOff, yMul     int32
+	rv, gu, gv, bu int32
+}
+
+var yuvColorSpaces = [...]yuvCoefficients{
+	YUVBT601:     {16, 76309, 104597, 25675, 53279, 132201},
+	YUVBT709:     {16, 76309, 117489, 13975, 34925, 138438},
+	YUVFullRange: {0, 65536, 91881, 22554, 46802, 116130},
+}
+
+// newYUVImage copies the planes into a tightly packed yuvImage
+func newYUVImage(y, u, v []byte, format YUVFormat) *yuvImage {
+	cw, ch := format.chromaSize()
+	ys, cs := format.strides()
+	coef := &yuvColorSpaces[YUVBT601]
+	if int(format.ColorSpace) < len(yuvColorSpaces) {
+		coef = &yuvColorSpaces[format.ColorSpace]
+	}
+	return &yuvImage{
+		y:       packPlane(y, format.Width, format.Height, ys),
+		u:       packPlane(u, cw, ch, cs),
+		v:       packPlane(v, cw, ch, cs),
+		w:       format.Width,
+		h:       format.Height,
+		yStride: format.Width,
+		cStride: cw,
+		sub:     format.Subsampling,
+		coef:    coef,
+	}
+}
+
+func packPlane(p []byte, w, h, stride int) []byte {
+	packed := make([]byte, w*h)
+	for row := 0; row < h; row++ {
+		copy(packed[row*w:(row+1)*w], p[row*stride:])
+	}
+	return packed
+}
+
+func (yi *yuvImage) ColorModel() color.Model { return color.RGBAModel }
+
+func (yi *yuvImage) Bounds() image.Rectangle { return image.Rect(0, 0, yi.w, yi.h) }
+
+func (yi *yuvImage) At(x, y int) color.Color { return yi.RGBAAt(x, y) }
+
+// RGBAAt returns the converted color of a pixel
+func (yi *yuvImage) RGBAAt(x, y int) color.RGBA {
+	if x < 0 || y < 0 || x >= yi.w || y >= yi.h {
+		return color.RGBA{}
+	}
+	cx, cy := x, y
+	switch yi.sub {
+	case image.YCbCrSubsampleRatio420:
+		cx, cy = x/2, y/2
+	case image.YCbCrSubsampleRatio422:
+		cx = x / 2
+	}
+	c := yi.coef
+	yy := (int32(yi.y[y*yi.yStride+x]) - c.yOff) * c.yMul
+	cu := int32(yi.u[cy*yi.cStride+cx]) - 128
+	cv := int32(yi.v[cy*yi.cStride+cx]) - 128
+	return color.RGBA{
+		R: clampYUV(yy + c.rv*cv),
+		G: clampYUV(yy - c.gu*cu - c.gv*cv),
+		B: clampYUV(yy + c.bu*cu),
+		A: 255,
+	}
+}
+
+func clampYUV(v int32) uint8 {
+	v = (v + 1<<15) >> 16
+	if v < 0 {
+		return 0
+	} else if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// rgba converts the whole image
+func (yi *yuvImage) rgba() *image.RGBA {
+	rgba := image.NewRGBA(yi.Bounds())
+	for y := 0; y < yi.h; y++ {
+		for x := 0; x < yi.w; x++ {
+			rgba.SetRGBA(x, y, yi.RGBAAt(x, y))
+		}
+	}
+	return rgba
+}
+
+// LoadImageYUV loads the planes without converting them. The pixels are
+// converted when they are sampled, and no mipmaps are created, which
+// suits video frames that are drawn at about their size
+func (b *SoftwareBackend) LoadImageYUV(y, u, v []byte, format YUVFormat) (BackendImage, error) {
+	if err := format.validate(y, u, v); err != nil {
+		return nil, err
+	}
+	return &SoftwareImage{mips: []image.Image{newYUVImage(y, u, v, format)}, quality: MipmapBox}, nil
+}